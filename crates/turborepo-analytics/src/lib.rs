@@ -43,7 +43,8 @@ pub struct AnalyticsHandle {
 }
 
 /// Starts the `Worker` on a separate tokio thread. Returns an `AnalyticsSender`
-/// and an `AnalyticsHandle`.
+/// and an `AnalyticsHandle`. If a `run_id` is provided, it is attached to every
+/// event so they can be correlated with the run that produced them.
 ///
 /// We have two different types because the AnalyticsSender should be shared
 /// across threads (i.e. Clone + Send), while the AnalyticsHandle cannot be
@@ -51,6 +52,7 @@ pub struct AnalyticsHandle {
 pub fn start_analytics(
     api_auth: APIAuth,
     client: impl AnalyticsClient + Clone + Send + Sync + 'static,
+    run_id: Option<String>,
 ) -> (AnalyticsSender, AnalyticsHandle) {
     let (tx, rx) = mpsc::unbounded_channel();
     let (cancel_tx, cancel_rx) = oneshot::channel();
@@ -59,6 +61,7 @@ pub fn start_analytics(
         rx,
         buffer: Vec::new(),
         session_id,
+        run_id,
         api_auth,
         senders: FuturesUnordered::new(),
         exit_ch: cancel_tx,
@@ -96,6 +99,7 @@ struct Worker<C> {
     rx: mpsc::UnboundedReceiver<AnalyticsEvent>,
     buffer: Vec<AnalyticsEvent>,
     session_id: Uuid,
+    run_id: Option<String>,
     api_auth: APIAuth,
     senders: FuturesUnordered<JoinHandle<()>>,
     // Used to cancel the worker
@@ -156,6 +160,9 @@ impl<C: AnalyticsClient + Clone + Send + Sync + 'static> Worker<C> {
         let client = self.client.clone();
         let api_auth = self.api_auth.clone();
         add_session_id(session_id, &mut events);
+        if let Some(run_id) = &self.run_id {
+            add_run_id(run_id, &mut events);
+        }
 
         tokio::spawn(async move {
             // We don't log an error for a timeout because
@@ -176,6 +183,12 @@ fn add_session_id(id: Uuid, events: &mut Vec<AnalyticsEvent>) {
     }
 }
 
+fn add_run_id(id: &str, events: &mut Vec<AnalyticsEvent>) {
+    for event in events {
+        event.set_run_id(id.to_string());
+    }
+}
+
 #[cfg(test)]
 mod tests {
     use std::{
@@ -265,16 +278,19 @@ mod tests {
                 team_slug: None,
             },
             client.clone(),
+            None,
         );
 
         for _ in 0..2 {
             analytics_sender
                 .send(AnalyticsEvent {
                     session_id: None,
+                    run_id: None,
                     source: CacheSource::Local,
                     event: CacheEvent::Hit,
                     hash: "".to_string(),
                     duration: 0,
+                    bytes: None,
                 })
                 .unwrap();
         }
@@ -307,16 +323,19 @@ mod tests {
                 team_slug: None,
             },
             client.clone(),
+            None,
         );
 
         for _ in 0..12 {
             analytics_sender
                 .send(AnalyticsEvent {
                     session_id: None,
+                    run_id: None,
                     source: CacheSource::Local,
                     event: CacheEvent::Hit,
                     hash: "".to_string(),
                     duration: 0,
+                    bytes: None,
                 })
                 .unwrap();
         }
@@ -355,16 +374,19 @@ mod tests {
                 team_slug: None,
             },
             client.clone(),
+            None,
         );
 
         for _ in 0..2 {
             analytics_sender
                 .send(AnalyticsEvent {
                     session_id: None,
+                    run_id: None,
                     source: CacheSource::Local,
                     event: CacheEvent::Hit,
                     hash: "".to_string(),
                     duration: 0,
+                    bytes: None,
                 })
                 .unwrap();
         }
@@ -26,7 +26,7 @@ impl AnalyticsClient for APIClient {
             .await?
             .json(&events);
 
-        retry::make_retryable_request(request_builder)
+        retry::make_retryable_request(request_builder, self.retry_policy)
             .await?
             .error_for_status()?;
 
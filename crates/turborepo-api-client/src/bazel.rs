@@ -0,0 +1,1235 @@
+//! A cache client for remote caches that implement the Bazel Remote Execution
+//! API, such as Buildbarn, Buildfarm or bazel-remote. A custom provider whose
+//! `baseUrl` uses the `grpc://` or `grpcs://` scheme is served by this client
+//! instead of plain HTTP, with the path of the URL as the instance name.
+//!
+//! Artifacts are stored in the content addressable storage and each task
+//! hash maps to an action result that lists them. The action digest is
+//! derived from the task hash, since there's no Bazel action to hash.
+
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+use async_trait::async_trait;
+use futures::stream;
+use reqwest::{
+    header::{HeaderName, HeaderValue},
+    Method,
+};
+use tonic::{
+    client::Grpc,
+    codec::ProstCodec,
+    codegen::http::uri::PathAndQuery,
+    metadata::{MetadataKey, MetadataValue},
+    transport::{Channel, ClientTlsConfig, Endpoint},
+    Code,
+};
+use tracing::warn;
+use turborepo_vercel_api::{CachingStatus, CachingStatusResponse};
+use url::Url;
+
+use crate::{custom::CustomProvider, APIClient, CacheClient, Error, Response, Result};
+
+// The path of the artifact, and of its signature if it's signed, in the
+// outputs of an action result
+const ARTIFACT_PATH: &str = "artifact.tar";
+const TAG_PATH: &str = "artifact.tag";
+
+// Blobs are written in chunks that stay well under gRPC's default message
+// size limit of 4MiB
+const WRITE_CHUNK_SIZE: usize = 1024 * 1024;
+
+const GET_ACTION_RESULT: &str = "/build.bazel.remote.execution.v2.ActionCache/GetActionResult";
+const UPDATE_ACTION_RESULT: &str =
+    "/build.bazel.remote.execution.v2.ActionCache/UpdateActionResult";
+const FIND_MISSING_BLOBS: &str =
+    "/build.bazel.remote.execution.v2.ContentAddressableStorage/FindMissingBlobs";
+const BYTESTREAM_READ: &str = "/google.bytestream.ByteStream/Read";
+const BYTESTREAM_WRITE: &str = "/google.bytestream.ByteStream/Write";
+
+impl CustomProvider {
+    /// Whether the provider is a Bazel remote cache rather than plain HTTP
+    pub fn is_bazel(&self) -> bool {
+        self.base_url.starts_with("grpc://") || self.base_url.starts_with("grpcs://")
+    }
+}
+
+/// A cache client for a `CustomProvider` that speaks the Bazel remote caching
+/// protocol. Like other custom providers, team parameters are ignored.
+#[derive(Clone)]
+pub struct BazelCacheClient {
+    channel: Channel,
+    instance_name: String,
+    provider: CustomProvider,
+}
+
+impl APIClient {
+    /// Creates a client for a Bazel remote cache. The connection is made
+    /// when the first request is sent.
+    pub fn bazel_cache_client(&self, provider: CustomProvider) -> Result<BazelCacheClient> {
+        let invalid_url = |err| Error::InvalidUrl {
+            url: provider.base_url.clone(),
+            err,
+        };
+        let url = Url::parse(&provider.base_url).map_err(invalid_url)?;
+        let host = url
+            .host_str()
+            .ok_or_else(|| invalid_url(url::ParseError::EmptyHost))?;
+        let tls = url.scheme() == "grpcs";
+        let endpoint_url = format!(
+            "{}://{}:{}",
+            if tls { "https" } else { "http" },
+            host,
+            url.port().unwrap_or(if tls { 443 } else { 80 })
+        );
+
+        let grpc_error = |err| Error::GrpcTransport {
+            url: provider.base_url.clone(),
+            err,
+        };
+        let mut endpoint = Endpoint::from_shared(endpoint_url)
+            .map_err(grpc_error)?
+            .user_agent(self.user_agent.clone())
+            .map_err(grpc_error)?;
+        if tls {
+            endpoint = endpoint
+                .tls_config(ClientTlsConfig::new().domain_name(host))
+                .map_err(grpc_error)?;
+        }
+
+        Ok(BazelCacheClient {
+            channel: endpoint.connect_lazy(),
+            instance_name: url.path().trim_matches('/').to_string(),
+            provider,
+        })
+    }
+}
+
+impl BazelCacheClient {
+    fn request<T>(&self, message: T, token: &str) -> tonic::Request<T> {
+        let mut request = tonic::Request::new(message);
+        if let Some((name, value)) = self.provider.auth_header(token) {
+            match (
+                MetadataKey::from_bytes(name.to_lowercase().as_bytes()),
+                MetadataValue::try_from(value),
+            ) {
+                (Ok(key), Ok(value)) => {
+                    request.metadata_mut().insert(key, value);
+                }
+                _ => warn!(
+                    "ignoring invalid auth header for {}",
+                    self.provider.base_url
+                ),
+            }
+        }
+        request
+    }
+
+    async fn grpc(&self) -> Result<Grpc<Channel>> {
+        let mut grpc = Grpc::new(self.channel.clone());
+        grpc.ready().await.map_err(|err| Error::GrpcTransport {
+            url: self.provider.base_url.clone(),
+            err,
+        })?;
+        Ok(grpc)
+    }
+
+    async fn unary<Req, Res>(&self, path: &'static str, message: Req, token: &str) -> Result<Res>
+    where
+        Req: prost::Message + Send + Sync + 'static,
+        Res: prost::Message + Default + Send + Sync + 'static,
+    {
+        let response = self
+            .grpc()
+            .await?
+            .unary(
+                self.request(message, token),
+                PathAndQuery::from_static(path),
+                ProstCodec::default(),
+            )
+            .await?;
+        Ok(response.into_inner())
+    }
+
+    // Action results are keyed by the digest of an action, which is derived
+    // from the task hash
+    fn action_digest(hash: &str) -> proto::Digest {
+        proto::Digest::of(format!("turborepo/{hash}").as_bytes())
+    }
+
+    async fn get_action_result(
+        &self,
+        hash: &str,
+        inline: bool,
+        token: &str,
+    ) -> Result<Option<proto::ActionResult>> {
+        let request = proto::GetActionResultRequest {
+            instance_name: self.instance_name.clone(),
+            action_digest: Some(Self::action_digest(hash)),
+            inline_output_files: match inline {
+                true => vec![ARTIFACT_PATH.to_string(), TAG_PATH.to_string()],
+                false => Vec::new(),
+            },
+        };
+        match self.unary(GET_ACTION_RESULT, request, token).await {
+            Ok(action_result) => Ok(Some(action_result)),
+            Err(Error::GrpcError(status)) if status.code() == Code::NotFound => Ok(None),
+            Err(e) => Err(e),
+        }
+    }
+
+    // Returns the contents of an output, which the server may have inlined
+    // in the action result. `None` means the blob was evicted.
+    async fn read_output(
+        &self,
+        output: &proto::OutputFile,
+        token: &str,
+    ) -> Result<Option<Vec<u8>>> {
+        let Some(digest) = &output.digest else {
+            return Ok(Some(output.contents.clone()));
+        };
+        if !output.contents.is_empty() || digest.size_bytes == 0 {
+            return Ok(Some(output.contents.clone()));
+        }
+
+        let request = proto::ReadRequest {
+            resource_name: self
+                .resource_name(&format!("blobs/{}/{}", digest.hash, digest.size_bytes)),
+            read_offset: 0,
+            read_limit: 0,
+        };
+        let response = self
+            .grpc()
+            .await?
+            .server_streaming::<_, proto::ReadResponse, _>(
+                self.request(request, token),
+                PathAndQuery::from_static(BYTESTREAM_READ),
+                ProstCodec::default(),
+            )
+            .await;
+        let mut stream = match response {
+            Ok(response) => response.into_inner(),
+            Err(status) if status.code() == Code::NotFound => return Ok(None),
+            Err(status) => return Err(status.into()),
+        };
+
+        let mut contents = Vec::with_capacity(digest.size_bytes as usize);
+        loop {
+            match stream.message().await {
+                Ok(Some(message)) => contents.extend_from_slice(&message.data),
+                Ok(None) => break,
+                Err(status) if status.code() == Code::NotFound => return Ok(None),
+                Err(status) => return Err(status.into()),
+            }
+        }
+
+        Ok(Some(contents))
+    }
+
+    // Uploads the blobs the storage doesn't already have
+    async fn write_blobs(&self, blobs: &[(&proto::Digest, &[u8])], token: &str) -> Result<()> {
+        let request = proto::FindMissingBlobsRequest {
+            instance_name: self.instance_name.clone(),
+            blob_digests: blobs.iter().map(|(digest, _)| (*digest).clone()).collect(),
+        };
+        let response: proto::FindMissingBlobsResponse =
+            self.unary(FIND_MISSING_BLOBS, request, token).await?;
+
+        for (digest, contents) in blobs {
+            if response.missing_blob_digests.contains(*digest) {
+                self.write_blob(digest, contents, token).await?;
+            }
+        }
+
+        Ok(())
+    }
+
+    async fn write_blob(&self, digest: &proto::Digest, contents: &[u8], token: &str) -> Result<()> {
+        let resource_name = self.resource_name(&format!(
+            "uploads/{}/blobs/{}/{}",
+            upload_id(),
+            digest.hash,
+            digest.size_bytes
+        ));
+        let chunks = contents.chunks(WRITE_CHUNK_SIZE).collect::<Vec<_>>();
+        let last = chunks.len().saturating_sub(1);
+        let mut offset = 0;
+        let requests = chunks
+            .into_iter()
+            .enumerate()
+            .map(|(index, chunk)| {
+                let request = proto::WriteRequest {
+                    // Only the first request needs to name the resource
+                    resource_name: match index {
+                        0 => resource_name.clone(),
+                        _ => String::new(),
+                    },
+                    write_offset: offset,
+                    finish_write: index == last,
+                    data: chunk.to_vec(),
+                };
+                offset += chunk.len() as i64;
+                request
+            })
+            .collect::<Vec<_>>();
+
+        self.grpc()
+            .await?
+            .client_streaming::<_, _, proto::WriteResponse, _>(
+                self.request(stream::iter(requests), token),
+                PathAndQuery::from_static(BYTESTREAM_WRITE),
+                ProstCodec::default(),
+            )
+            .await?;
+
+        Ok(())
+    }
+
+    fn resource_name(&self, resource: &str) -> String {
+        match self.instance_name.is_empty() {
+            true => resource.to_string(),
+            false => format!("{}/{}", self.instance_name, resource),
+        }
+    }
+}
+
+// Uploads are named by a client-chosen identifier that only needs to be
+// unique, which is formatted like a UUID as servers expect
+fn upload_id() -> String {
+    let id = format!("{:032x}", rand::random::<u128>());
+    format!(
+        "{}-{}-{}-{}-{}",
+        &id[..8],
+        &id[8..12],
+        &id[12..16],
+        &id[16..20],
+        &id[20..]
+    )
+}
+
+// The task duration is stored as the time the action took to execute
+fn execution_metadata(duration: u64) -> proto::ExecutedActionMetadata {
+    let completed = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .unwrap_or_default();
+    let start = completed.saturating_sub(Duration::from_millis(duration));
+    proto::ExecutedActionMetadata {
+        worker: "turbo".to_string(),
+        execution_start_timestamp: Some(start.into()),
+        execution_completed_timestamp: Some(completed.into()),
+    }
+}
+
+fn duration(action_result: &proto::ActionResult) -> u64 {
+    let Some(metadata) = &action_result.execution_metadata else {
+        return 0;
+    };
+    match (
+        &metadata.execution_start_timestamp,
+        &metadata.execution_completed_timestamp,
+    ) {
+        (Some(start), Some(completed)) => Duration::from(completed)
+            .saturating_sub(Duration::from(start))
+            .as_millis() as u64,
+        _ => 0,
+    }
+}
+
+// The rest of turbo expects responses in the shape of the HTTP cache API,
+// with the task duration and signature in headers
+fn artifact_response(body: Vec<u8>, duration: u64, tag: Option<&[u8]>) -> Response {
+    let mut response = http::Response::new(body);
+    let headers = response.headers_mut();
+    headers.insert(
+        HeaderName::from_static("x-artifact-duration"),
+        HeaderValue::from(duration),
+    );
+    if let Some(tag) = tag.and_then(|tag| HeaderValue::from_bytes(tag).ok()) {
+        headers.insert(HeaderName::from_static("x-artifact-tag"), tag);
+    }
+    Response::from(response)
+}
+
+#[async_trait]
+impl CacheClient for BazelCacheClient {
+    async fn get_artifact(
+        &self,
+        hash: &str,
+        token: &str,
+        _team_id: Option<&str>,
+        _team_slug: Option<&str>,
+        method: Method,
+    ) -> Result<Option<Response>> {
+        let include_body = method != Method::HEAD;
+        let Some(action_result) = self.get_action_result(hash, include_body, token).await? else {
+            return Ok(None);
+        };
+        let output = |path| {
+            action_result
+                .output_files
+                .iter()
+                .find(|output| output.path == path)
+        };
+        let Some(artifact) = output(ARTIFACT_PATH) else {
+            return Ok(None);
+        };
+        if !include_body {
+            return Ok(Some(artifact_response(
+                Vec::new(),
+                duration(&action_result),
+                None,
+            )));
+        }
+
+        let Some(body) = self.read_output(artifact, token).await? else {
+            return Ok(None);
+        };
+        let tag = match output(TAG_PATH) {
+            Some(tag) => self.read_output(tag, token).await?,
+            None => None,
+        };
+
+        Ok(Some(artifact_response(
+            body,
+            duration(&action_result),
+            tag.as_deref(),
+        )))
+    }
+
+    #[tracing::instrument(skip_all)]
+    async fn fetch_artifact(
+        &self,
+        hash: &str,
+        token: &str,
+        team_id: Option<&str>,
+        team_slug: Option<&str>,
+    ) -> Result<Option<Response>> {
+        self.get_artifact(hash, token, team_id, team_slug, Method::GET)
+            .await
+    }
+
+    #[tracing::instrument(skip_all)]
+    async fn put_artifact(
+        &self,
+        hash: &str,
+        artifact_body: &[u8],
+        duration: u64,
+        tag: Option<&str>,
+        token: &str,
+        _team_id: Option<&str>,
+        _team_slug: Option<&str>,
+    ) -> Result<()> {
+        let artifact_digest = proto::Digest::of(artifact_body);
+        let tag_digest = tag.map(|tag| proto::Digest::of(tag.as_bytes()));
+
+        let mut blobs = vec![(&artifact_digest, artifact_body)];
+        let mut output_files = vec![proto::OutputFile {
+            path: ARTIFACT_PATH.to_string(),
+            digest: Some(artifact_digest.clone()),
+            contents: Vec::new(),
+        }];
+        if let (Some(tag), Some(tag_digest)) = (tag, &tag_digest) {
+            blobs.push((tag_digest, tag.as_bytes()));
+            output_files.push(proto::OutputFile {
+                path: TAG_PATH.to_string(),
+                digest: Some(tag_digest.clone()),
+                contents: Vec::new(),
+            });
+        }
+        // The blobs are written first so the action result never refers to
+        // missing contents
+        self.write_blobs(&blobs, token).await?;
+
+        let request = proto::UpdateActionResultRequest {
+            instance_name: self.instance_name.clone(),
+            action_digest: Some(Self::action_digest(hash)),
+            action_result: Some(proto::ActionResult {
+                output_files,
+                execution_metadata: Some(execution_metadata(duration)),
+            }),
+        };
+        let _: proto::ActionResult = self.unary(UPDATE_ACTION_RESULT, request, token).await?;
+
+        Ok(())
+    }
+
+    #[tracing::instrument(skip_all)]
+    async fn artifact_exists(
+        &self,
+        hash: &str,
+        token: &str,
+        team_id: Option<&str>,
+        team_slug: Option<&str>,
+    ) -> Result<Option<Response>> {
+        self.get_artifact(hash, token, team_id, team_slug, Method::HEAD)
+            .await
+    }
+
+    async fn get_caching_status(
+        &self,
+        _token: &str,
+        _team_id: Option<&str>,
+        _team_slug: Option<&str>,
+    ) -> Result<CachingStatusResponse> {
+        // Like other custom providers, there are no usage limits to check
+        Ok(CachingStatusResponse {
+            status: CachingStatus::Enabled,
+        })
+    }
+}
+
+/// The subset of the Remote Execution API and ByteStream messages that's
+/// used for caching, with their field numbers from
+/// `build/bazel/remote/execution/v2/remote_execution.proto` and
+/// `google/bytestream/bytestream.proto`
+mod proto {
+    use std::time::Duration;
+
+    use sha2::{Digest as _, Sha256};
+
+    #[derive(Clone, PartialEq, Eq, prost::Message)]
+    pub struct Digest {
+        #[prost(string, tag = "1")]
+        pub hash: String,
+        #[prost(int64, tag = "2")]
+        pub size_bytes: i64,
+    }
+
+    impl Digest {
+        pub fn of(contents: &[u8]) -> Self {
+            Self {
+                hash: hex::encode(Sha256::digest(contents)),
+                size_bytes: contents.len() as i64,
+            }
+        }
+    }
+
+    #[derive(Clone, PartialEq, prost::Message)]
+    pub struct Timestamp {
+        #[prost(int64, tag = "1")]
+        pub seconds: i64,
+        #[prost(int32, tag = "2")]
+        pub nanos: i32,
+    }
+
+    impl From<Duration> for Timestamp {
+        fn from(since_epoch: Duration) -> Self {
+            Self {
+                seconds: since_epoch.as_secs() as i64,
+                nanos: since_epoch.subsec_nanos() as i32,
+            }
+        }
+    }
+
+    impl From<&Timestamp> for Duration {
+        fn from(timestamp: &Timestamp) -> Self {
+            Duration::new(
+                timestamp.seconds.max(0) as u64,
+                timestamp.nanos.max(0) as u32,
+            )
+        }
+    }
+
+    #[derive(Clone, PartialEq, prost::Message)]
+    pub struct OutputFile {
+        #[prost(string, tag = "1")]
+        pub path: String,
+        #[prost(message, optional, tag = "2")]
+        pub digest: Option<Digest>,
+        #[prost(bytes = "vec", tag = "5")]
+        pub contents: Vec<u8>,
+    }
+
+    #[derive(Clone, PartialEq, prost::Message)]
+    pub struct ExecutedActionMetadata {
+        #[prost(string, tag = "1")]
+        pub worker: String,
+        #[prost(message, optional, tag = "7")]
+        pub execution_start_timestamp: Option<Timestamp>,
+        #[prost(message, optional, tag = "8")]
+        pub execution_completed_timestamp: Option<Timestamp>,
+    }
+
+    #[derive(Clone, PartialEq, prost::Message)]
+    pub struct ActionResult {
+        #[prost(message, repeated, tag = "2")]
+        pub output_files: Vec<OutputFile>,
+        #[prost(message, optional, tag = "9")]
+        pub execution_metadata: Option<ExecutedActionMetadata>,
+    }
+
+    #[derive(Clone, PartialEq, prost::Message)]
+    pub struct GetActionResultRequest {
+        #[prost(string, tag = "1")]
+        pub instance_name: String,
+        #[prost(message, optional, tag = "2")]
+        pub action_digest: Option<Digest>,
+        #[prost(string, repeated, tag = "5")]
+        pub inline_output_files: Vec<String>,
+    }
+
+    #[derive(Clone, PartialEq, prost::Message)]
+    pub struct UpdateActionResultRequest {
+        #[prost(string, tag = "1")]
+        pub instance_name: String,
+        #[prost(message, optional, tag = "2")]
+        pub action_digest: Option<Digest>,
+        #[prost(message, optional, tag = "3")]
+        pub action_result: Option<ActionResult>,
+    }
+
+    #[derive(Clone, PartialEq, prost::Message)]
+    pub struct FindMissingBlobsRequest {
+        #[prost(string, tag = "1")]
+        pub instance_name: String,
+        #[prost(message, repeated, tag = "2")]
+        pub blob_digests: Vec<Digest>,
+    }
+
+    #[derive(Clone, PartialEq, prost::Message)]
+    pub struct FindMissingBlobsResponse {
+        #[prost(message, repeated, tag = "2")]
+        pub missing_blob_digests: Vec<Digest>,
+    }
+
+    #[derive(Clone, PartialEq, prost::Message)]
+    pub struct ReadRequest {
+        #[prost(string, tag = "1")]
+        pub resource_name: String,
+        #[prost(int64, tag = "2")]
+        pub read_offset: i64,
+        #[prost(int64, tag = "3")]
+        pub read_limit: i64,
+    }
+
+    #[derive(Clone, PartialEq, prost::Message)]
+    pub struct ReadResponse {
+        #[prost(bytes = "vec", tag = "10")]
+        pub data: Vec<u8>,
+    }
+
+    #[derive(Clone, PartialEq, prost::Message)]
+    pub struct WriteRequest {
+        #[prost(string, tag = "1")]
+        pub resource_name: String,
+        #[prost(int64, tag = "2")]
+        pub write_offset: i64,
+        #[prost(bool, tag = "3")]
+        pub finish_write: bool,
+        #[prost(bytes = "vec", tag = "10")]
+        pub data: Vec<u8>,
+    }
+
+    #[derive(Clone, PartialEq, prost::Message)]
+    pub struct WriteResponse {
+        #[prost(int64, tag = "1")]
+        pub committed_size: i64,
+    }
+}
+
+#[cfg(test)]
+mod test {
+    use std::{
+        collections::HashMap,
+        convert::Infallible,
+        future,
+        sync::{Arc, Mutex},
+    };
+
+    use futures::stream;
+    use sha2::{Digest as _, Sha256};
+    use test_case::test_case;
+    use tonic::{
+        body::BoxBody,
+        codec::{ProstCodec, Streaming},
+        codegen::{empty_body, http, Body, BoxFuture, Context, Poll, Service, StdError},
+        server::{Grpc, NamedService},
+        transport::{server::TcpIncoming, Server},
+        Code, Status,
+    };
+    use tower::service_fn;
+
+    use super::{
+        duration, execution_metadata, proto, upload_id, BazelCacheClient, ARTIFACT_PATH,
+        BYTESTREAM_READ, BYTESTREAM_WRITE, FIND_MISSING_BLOBS, GET_ACTION_RESULT, TAG_PATH,
+        UPDATE_ACTION_RESULT, WRITE_CHUNK_SIZE,
+    };
+    use crate::{
+        custom::{test::check_conformance, CustomProvider},
+        APIClient, CacheClient, Error,
+    };
+
+    fn provider(base_url: &str) -> CustomProvider {
+        CustomProvider {
+            base_url: base_url.to_string(),
+            auth_header: None,
+            get_path: String::new(),
+            put_path: String::new(),
+        }
+    }
+
+    #[test_case("grpc://localhost:8980", true ; "grpc")]
+    #[test_case("grpcs://cache.example.com/main", true ; "grpcs")]
+    #[test_case("https://cache.example.com", false ; "https")]
+    fn test_is_bazel(base_url: &str, expected: bool) {
+        assert_eq!(provider(base_url).is_bazel(), expected);
+    }
+
+    #[test_case("grpc://localhost:8980", "" ; "no instance")]
+    #[test_case("grpc://localhost:8980/main", "main" ; "instance")]
+    #[test_case("grpcs://cache.example.com/team/main/", "team/main" ; "nested instance")]
+    #[tokio::test]
+    async fn test_instance_name(base_url: &str, expected: &str) {
+        let api_client = APIClient::new("http://localhost", 200, "2.0.0", false).unwrap();
+        let client = api_client.bazel_cache_client(provider(base_url)).unwrap();
+        assert_eq!(client.instance_name, expected);
+        assert_eq!(
+            client.resource_name("blobs/abc/3"),
+            match expected {
+                "" => "blobs/abc/3".to_string(),
+                instance => format!("{instance}/blobs/abc/3"),
+            }
+        );
+    }
+
+    #[test]
+    fn test_duration_round_trip() {
+        let action_result = proto::ActionResult {
+            output_files: Vec::new(),
+            execution_metadata: Some(execution_metadata(1234)),
+        };
+        assert_eq!(duration(&action_result), 1234);
+        assert_eq!(duration(&proto::ActionResult::default()), 0);
+    }
+
+    #[test]
+    fn test_upload_id() {
+        let id = upload_id();
+        assert_eq!(id.len(), 36);
+        assert_eq!(
+            id.split('-').map(str::len).collect::<Vec<_>>(),
+            vec![8, 4, 4, 4, 12]
+        );
+        assert_ne!(id, upload_id());
+    }
+
+    /// The messages the fake cache speaks, declared separately from `proto`
+    /// with the field numbers of the upstream protos. A client message with a
+    /// wrong tag then fails to round trip instead of being decoded the same
+    /// wrong way on both ends.
+    mod wire {
+        #[derive(Clone, PartialEq, prost::Message)]
+        pub struct Digest {
+            #[prost(string, tag = "1")]
+            pub hash: String,
+            #[prost(int64, tag = "2")]
+            pub size_bytes: i64,
+        }
+
+        #[derive(Clone, PartialEq, prost::Message)]
+        pub struct Timestamp {
+            #[prost(int64, tag = "1")]
+            pub seconds: i64,
+            #[prost(int32, tag = "2")]
+            pub nanos: i32,
+        }
+
+        #[derive(Clone, PartialEq, prost::Message)]
+        pub struct OutputFile {
+            #[prost(string, tag = "1")]
+            pub path: String,
+            #[prost(message, optional, tag = "2")]
+            pub digest: Option<Digest>,
+            #[prost(bytes = "vec", tag = "5")]
+            pub contents: Vec<u8>,
+        }
+
+        #[derive(Clone, PartialEq, prost::Message)]
+        pub struct ExecutedActionMetadata {
+            #[prost(string, tag = "1")]
+            pub worker: String,
+            #[prost(message, optional, tag = "7")]
+            pub execution_start_timestamp: Option<Timestamp>,
+            #[prost(message, optional, tag = "8")]
+            pub execution_completed_timestamp: Option<Timestamp>,
+        }
+
+        #[derive(Clone, PartialEq, prost::Message)]
+        pub struct ActionResult {
+            #[prost(message, repeated, tag = "2")]
+            pub output_files: Vec<OutputFile>,
+            #[prost(message, optional, tag = "9")]
+            pub execution_metadata: Option<ExecutedActionMetadata>,
+        }
+
+        #[derive(Clone, PartialEq, prost::Message)]
+        pub struct GetActionResultRequest {
+            #[prost(string, tag = "1")]
+            pub instance_name: String,
+            #[prost(message, optional, tag = "2")]
+            pub action_digest: Option<Digest>,
+            #[prost(string, repeated, tag = "5")]
+            pub inline_output_files: Vec<String>,
+        }
+
+        #[derive(Clone, PartialEq, prost::Message)]
+        pub struct UpdateActionResultRequest {
+            #[prost(string, tag = "1")]
+            pub instance_name: String,
+            #[prost(message, optional, tag = "2")]
+            pub action_digest: Option<Digest>,
+            #[prost(message, optional, tag = "3")]
+            pub action_result: Option<ActionResult>,
+        }
+
+        #[derive(Clone, PartialEq, prost::Message)]
+        pub struct FindMissingBlobsRequest {
+            #[prost(string, tag = "1")]
+            pub instance_name: String,
+            #[prost(message, repeated, tag = "2")]
+            pub blob_digests: Vec<Digest>,
+        }
+
+        #[derive(Clone, PartialEq, prost::Message)]
+        pub struct FindMissingBlobsResponse {
+            #[prost(message, repeated, tag = "2")]
+            pub missing_blob_digests: Vec<Digest>,
+        }
+
+        #[derive(Clone, PartialEq, prost::Message)]
+        pub struct ReadRequest {
+            #[prost(string, tag = "1")]
+            pub resource_name: String,
+            #[prost(int64, tag = "2")]
+            pub read_offset: i64,
+            #[prost(int64, tag = "3")]
+            pub read_limit: i64,
+        }
+
+        #[derive(Clone, PartialEq, prost::Message)]
+        pub struct ReadResponse {
+            #[prost(bytes = "vec", tag = "10")]
+            pub data: Vec<u8>,
+        }
+
+        #[derive(Clone, PartialEq, prost::Message)]
+        pub struct WriteRequest {
+            #[prost(string, tag = "1")]
+            pub resource_name: String,
+            #[prost(int64, tag = "2")]
+            pub write_offset: i64,
+            #[prost(bool, tag = "3")]
+            pub finish_write: bool,
+            #[prost(bytes = "vec", tag = "10")]
+            pub data: Vec<u8>,
+        }
+
+        #[derive(Clone, PartialEq, prost::Message)]
+        pub struct WriteResponse {
+            #[prost(int64, tag = "1")]
+            pub committed_size: i64,
+        }
+    }
+
+    type ReadStream = stream::Iter<std::vec::IntoIter<Result<wire::ReadResponse, Status>>>;
+
+    // An in-memory Bazel remote cache that rejects requests it can't make
+    // sense of, the way a real server would
+    #[derive(Clone, Default)]
+    struct FakeCache {
+        action_results: Arc<Mutex<HashMap<String, wire::ActionResult>>>,
+        blobs: Arc<Mutex<HashMap<String, Vec<u8>>>>,
+        // The number of chunks each blob was uploaded in
+        uploads: Arc<Mutex<Vec<usize>>>,
+    }
+
+    fn valid_digest(digest: Option<wire::Digest>) -> Result<wire::Digest, Status> {
+        let digest = digest.ok_or_else(|| Status::invalid_argument("missing digest"))?;
+        if digest.hash.len() != 64 || hex::decode(&digest.hash).is_err() || digest.size_bytes < 0 {
+            return Err(Status::invalid_argument(format!(
+                "invalid digest {digest:?}"
+            )));
+        }
+        Ok(digest)
+    }
+
+    // Finds the digest in a resource name that ends with
+    // `blobs/{hash}/{size}`
+    fn resource_digest(resource_name: &str) -> Result<wire::Digest, Status> {
+        let invalid = || Status::invalid_argument(format!("invalid resource {resource_name}"));
+        let mut segments = resource_name.rsplit('/');
+        let (Some(size), Some(hash), Some("blobs")) =
+            (segments.next(), segments.next(), segments.next())
+        else {
+            return Err(invalid());
+        };
+        valid_digest(Some(wire::Digest {
+            hash: hash.to_string(),
+            size_bytes: size.parse().map_err(|_| invalid())?,
+        }))
+    }
+
+    impl FakeCache {
+        fn get_action_result(
+            &self,
+            request: wire::GetActionResultRequest,
+        ) -> Result<tonic::Response<wire::ActionResult>, Status> {
+            let digest = valid_digest(request.action_digest)?;
+            self.action_results
+                .lock()
+                .unwrap()
+                .get(&digest.hash)
+                .cloned()
+                .map(tonic::Response::new)
+                .ok_or_else(|| Status::not_found("no action result"))
+        }
+
+        fn update_action_result(
+            &self,
+            request: wire::UpdateActionResultRequest,
+        ) -> Result<tonic::Response<wire::ActionResult>, Status> {
+            let digest = valid_digest(request.action_digest)?;
+            let action_result = request
+                .action_result
+                .ok_or_else(|| Status::invalid_argument("missing action result"))?;
+            for output in &action_result.output_files {
+                valid_digest(output.digest.clone())?;
+                if output.path.is_empty() {
+                    return Err(Status::invalid_argument("missing output path"));
+                }
+            }
+            self.action_results
+                .lock()
+                .unwrap()
+                .insert(digest.hash, action_result.clone());
+            Ok(tonic::Response::new(action_result))
+        }
+
+        fn find_missing_blobs(
+            &self,
+            request: wire::FindMissingBlobsRequest,
+        ) -> Result<tonic::Response<wire::FindMissingBlobsResponse>, Status> {
+            let blobs = self.blobs.lock().unwrap();
+            let mut missing_blob_digests = Vec::new();
+            for digest in request.blob_digests {
+                let digest = valid_digest(Some(digest))?;
+                if !blobs.contains_key(&digest.hash) {
+                    missing_blob_digests.push(digest);
+                }
+            }
+            Ok(tonic::Response::new(wire::FindMissingBlobsResponse {
+                missing_blob_digests,
+            }))
+        }
+
+        fn read(&self, request: wire::ReadRequest) -> Result<tonic::Response<ReadStream>, Status> {
+            let digest = resource_digest(&request.resource_name)?;
+            let blob = self
+                .blobs
+                .lock()
+                .unwrap()
+                .get(&digest.hash)
+                .cloned()
+                .ok_or_else(|| Status::not_found("no blob"))?;
+            // The blob is sent in two messages so the client has to put it
+            // back together
+            let (first, second) = blob.split_at(blob.len() / 2);
+            Ok(tonic::Response::new(stream::iter(vec![
+                Ok(wire::ReadResponse {
+                    data: first.to_vec(),
+                }),
+                Ok(wire::ReadResponse {
+                    data: second.to_vec(),
+                }),
+            ])))
+        }
+
+        async fn write(
+            &self,
+            mut requests: Streaming<wire::WriteRequest>,
+        ) -> Result<tonic::Response<wire::WriteResponse>, Status> {
+            let mut resource_name = None;
+            let mut contents = Vec::new();
+            let mut chunks = 0;
+            let mut finished = false;
+            while let Some(request) = requests.message().await? {
+                if finished || request.write_offset != contents.len() as i64 {
+                    return Err(Status::invalid_argument("unexpected write"));
+                }
+                resource_name.get_or_insert(request.resource_name);
+                contents.extend_from_slice(&request.data);
+                chunks += 1;
+                finished = request.finish_write;
+            }
+
+            let digest = resource_digest(resource_name.as_deref().unwrap_or_default())?;
+            if !finished
+                || digest.size_bytes != contents.len() as i64
+                || digest.hash != hex::encode(Sha256::digest(&contents))
+            {
+                return Err(Status::invalid_argument("contents don't match digest"));
+            }
+            self.blobs.lock().unwrap().insert(digest.hash, contents);
+            self.uploads.lock().unwrap().push(chunks);
+            Ok(tonic::Response::new(wire::WriteResponse {
+                committed_size: digest.size_bytes,
+            }))
+        }
+
+        async fn handle<B>(self, request: http::Request<B>) -> http::Response<BoxBody>
+        where
+            B: Body + Send + 'static,
+            B::Error: Into<StdError> + Send + 'static,
+        {
+            let path = request.uri().path().to_string();
+            match path.as_str() {
+                GET_ACTION_RESULT => {
+                    let service = service_fn(
+                        move |request: tonic::Request<wire::GetActionResultRequest>| {
+                            future::ready(self.get_action_result(request.into_inner()))
+                        },
+                    );
+                    Grpc::new(ProstCodec::default())
+                        .unary(service, request)
+                        .await
+                }
+                UPDATE_ACTION_RESULT => {
+                    let service = service_fn(
+                        move |request: tonic::Request<wire::UpdateActionResultRequest>| {
+                            future::ready(self.update_action_result(request.into_inner()))
+                        },
+                    );
+                    Grpc::new(ProstCodec::default())
+                        .unary(service, request)
+                        .await
+                }
+                FIND_MISSING_BLOBS => {
+                    let service = service_fn(
+                        move |request: tonic::Request<wire::FindMissingBlobsRequest>| {
+                            future::ready(self.find_missing_blobs(request.into_inner()))
+                        },
+                    );
+                    Grpc::new(ProstCodec::default())
+                        .unary(service, request)
+                        .await
+                }
+                BYTESTREAM_READ => {
+                    let service = service_fn(move |request: tonic::Request<wire::ReadRequest>| {
+                        future::ready(self.read(request.into_inner()))
+                    });
+                    Grpc::new(ProstCodec::default())
+                        .server_streaming(service, request)
+                        .await
+                }
+                BYTESTREAM_WRITE => {
+                    let service = service_fn(
+                        move |request: tonic::Request<Streaming<wire::WriteRequest>>| {
+                            let cache = self.clone();
+                            async move { cache.write(request.into_inner()).await }
+                        },
+                    );
+                    Grpc::new(ProstCodec::default())
+                        .client_streaming(service, request)
+                        .await
+                }
+                _ => http::Response::builder()
+                    .status(200)
+                    .header("grpc-status", Code::Unimplemented as i32)
+                    .header("content-type", "application/grpc")
+                    .body(empty_body())
+                    .unwrap(),
+            }
+        }
+    }
+
+    // The cache is served as the three gRPC services the client talks to,
+    // which all share the same handler
+    #[derive(Clone)]
+    struct Routes<const SERVICE: usize>(FakeCache);
+
+    impl NamedService for Routes<0> {
+        const NAME: &'static str = "build.bazel.remote.execution.v2.ActionCache";
+    }
+
+    impl NamedService for Routes<1> {
+        const NAME: &'static str = "build.bazel.remote.execution.v2.ContentAddressableStorage";
+    }
+
+    impl NamedService for Routes<2> {
+        const NAME: &'static str = "google.bytestream.ByteStream";
+    }
+
+    impl<const SERVICE: usize, B> Service<http::Request<B>> for Routes<SERVICE>
+    where
+        B: Body + Send + 'static,
+        B::Error: Into<StdError> + Send + 'static,
+    {
+        type Response = http::Response<BoxBody>;
+        type Error = Infallible;
+        type Future = BoxFuture<Self::Response, Self::Error>;
+
+        fn poll_ready(&mut self, _cx: &mut Context<'_>) -> Poll<Result<(), Self::Error>> {
+            Poll::Ready(Ok(()))
+        }
+
+        fn call(&mut self, request: http::Request<B>) -> Self::Future {
+            let cache = self.0.clone();
+            Box::pin(async move { Ok(cache.handle(request).await) })
+        }
+    }
+
+    // Serves the cache on a local port and returns a client for it
+    fn serve(cache: &FakeCache) -> (BazelCacheClient, tokio::task::JoinHandle<()>) {
+        let port = port_scanner::request_open_port().unwrap();
+        let incoming = TcpIncoming::new(([127, 0, 0, 1], port).into(), true, None).unwrap();
+        let server = Server::builder()
+            .add_service(Routes::<0>(cache.clone()))
+            .add_service(Routes::<1>(cache.clone()))
+            .add_service(Routes::<2>(cache.clone()))
+            .serve_with_incoming(incoming);
+        let handle = tokio::spawn(async move {
+            server.await.unwrap();
+        });
+
+        let api_client = APIClient::new("http://localhost", 200, "2.0.0", false).unwrap();
+        let client = api_client
+            .bazel_cache_client(provider(&format!("grpc://127.0.0.1:{port}/main")))
+            .unwrap();
+        (client, handle)
+    }
+
+    #[tokio::test]
+    async fn test_round_trip() -> anyhow::Result<()> {
+        let cache = FakeCache::default();
+        let (client, handle) = serve(&cache);
+        check_conformance(&client, "").await?;
+
+        // Large enough to be written in three chunks
+        let body = (0..WRITE_CHUNK_SIZE * 5 / 2)
+            .map(|i| i as u8)
+            .collect::<Vec<_>>();
+        client
+            .put_artifact("big-hash", &body, 1234, Some("signature"), "", None, None)
+            .await?;
+        assert_eq!(*cache.uploads.lock().unwrap(), vec![1, 3, 1]);
+
+        let action_result = cache
+            .action_results
+            .lock()
+            .unwrap()
+            .get(&BazelCacheClient::action_digest("big-hash").hash)
+            .cloned()
+            .expect("action result should be stored under the action digest");
+        assert_eq!(
+            action_result
+                .output_files
+                .iter()
+                .map(|output| output.path.as_str())
+                .collect::<Vec<_>>(),
+            vec![ARTIFACT_PATH, TAG_PATH]
+        );
+
+        let fetched = client
+            .fetch_artifact("big-hash", "", None, None)
+            .await?
+            .expect("uploaded artifact should be fetched");
+        let headers = fetched.headers();
+        assert_eq!(
+            headers.get("x-artifact-duration").unwrap().to_str()?,
+            "1234"
+        );
+        assert_eq!(
+            headers.get("x-artifact-tag").unwrap().to_str()?,
+            "signature"
+        );
+        assert_eq!(fetched.bytes().await?.as_ref(), body);
+
+        handle.abort();
+        Ok(())
+    }
+
+    #[tokio::test]
+    async fn test_skips_existing_blobs() -> anyhow::Result<()> {
+        let cache = FakeCache::default();
+        let (client, handle) = serve(&cache);
+
+        client
+            .put_artifact("first-hash", b"contents", 10, None, "", None, None)
+            .await?;
+        client
+            .put_artifact("second-hash", b"contents", 20, None, "", None, None)
+            .await?;
+        // The second artifact has the same contents, so only its action
+        // result is written
+        assert_eq!(*cache.uploads.lock().unwrap(), vec![1]);
+        assert_eq!(cache.action_results.lock().unwrap().len(), 2);
+
+        let fetched = client
+            .fetch_artifact("second-hash", "", None, None)
+            .await?
+            .expect("second artifact should be fetched");
+        assert_eq!(fetched.bytes().await?.as_ref(), b"contents");
+
+        handle.abort();
+        Ok(())
+    }
+
+    #[tokio::test]
+    async fn test_evicted_blob_is_a_miss() -> anyhow::Result<()> {
+        let cache = FakeCache::default();
+        let (client, handle) = serve(&cache);
+
+        client
+            .put_artifact("hash", b"contents", 10, None, "", None, None)
+            .await?;
+        cache.blobs.lock().unwrap().clear();
+
+        assert!(client
+            .fetch_artifact("hash", "", None, None)
+            .await?
+            .is_none());
+
+        handle.abort();
+        Ok(())
+    }
+
+    #[tokio::test]
+    async fn test_wrong_field_tag_fails() {
+        // A digest with the numbers of its fields swapped
+        #[derive(Clone, PartialEq, prost::Message)]
+        struct SwappedDigest {
+            #[prost(int64, tag = "1")]
+            size_bytes: i64,
+            #[prost(string, tag = "2")]
+            hash: String,
+        }
+
+        #[derive(Clone, PartialEq, prost::Message)]
+        struct GetActionResultRequest {
+            #[prost(string, tag = "1")]
+            instance_name: String,
+            #[prost(message, optional, tag = "2")]
+            action_digest: Option<SwappedDigest>,
+        }
+
+        let cache = FakeCache::default();
+        let (client, handle) = serve(&cache);
+
+        let digest = BazelCacheClient::action_digest("hash");
+        let request = GetActionResultRequest {
+            instance_name: client.instance_name.clone(),
+            action_digest: Some(SwappedDigest {
+                size_bytes: digest.size_bytes,
+                hash: digest.hash,
+            }),
+        };
+        let result = client
+            .unary::<_, proto::ActionResult>(GET_ACTION_RESULT, request, "")
+            .await;
+        assert!(
+            matches!(&result, Err(Error::GrpcError(status)) if status.code() != Code::NotFound),
+            "expected the request to be rejected, got {result:?}"
+        );
+
+        handle.abort();
+    }
+}
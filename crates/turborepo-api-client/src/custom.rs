@@ -0,0 +1,281 @@
+use async_trait::async_trait;
+use reqwest::{Method, RequestBuilder, StatusCode};
+use serde::{Deserialize, Serialize};
+use turborepo_vercel_api::{CachingStatus, CachingStatusResponse};
+use url::Url;
+
+use crate::{retry, APIClient, CacheClient, Error, Response, Result, RetryPolicy};
+
+// Stores artifacts at the root of the provider's base URL unless configured
+// otherwise
+pub const DEFAULT_ARTIFACT_PATH: &str = "/{hash}";
+
+/// An artifact store that speaks plain HTTP, configured via
+/// `remoteCache.provider` in `turbo.json`. Templates can reference `{hash}`
+/// in the paths and `{token}` in the auth header.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct CustomProvider {
+    pub base_url: String,
+    // A header in the form of `<name>: <value>`, e.g. `Authorization: Bearer {token}`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub auth_header: Option<String>,
+    #[serde(default = "default_artifact_path")]
+    pub get_path: String,
+    #[serde(default = "default_artifact_path")]
+    pub put_path: String,
+}
+
+fn default_artifact_path() -> String {
+    DEFAULT_ARTIFACT_PATH.to_string()
+}
+
+impl CustomProvider {
+    fn get_url(&self, hash: &str) -> Result<Url> {
+        self.make_url(&self.get_path, hash)
+    }
+
+    fn put_url(&self, hash: &str) -> Result<Url> {
+        self.make_url(&self.put_path, hash)
+    }
+
+    fn make_url(&self, path: &str, hash: &str) -> Result<Url> {
+        let url = format!(
+            "{}{}",
+            self.base_url.trim_end_matches('/'),
+            path.replace("{hash}", hash)
+        );
+        Url::parse(&url).map_err(|err| Error::InvalidUrl { url, err })
+    }
+
+    pub(crate) fn auth_header(&self, token: &str) -> Option<(String, String)> {
+        let (name, value) = self.auth_header.as_deref()?.split_once(':')?;
+        Some((
+            name.trim().to_string(),
+            value.trim().replace("{token}", token),
+        ))
+    }
+}
+
+/// A cache client for a `CustomProvider`. Team parameters and preflight
+/// requests are specific to the Vercel API and are ignored.
+#[derive(Clone)]
+pub struct CustomCacheClient {
+    client: reqwest::Client,
+    user_agent: String,
+    retry_policy: RetryPolicy,
+    artifact_headers: Vec<(String, String)>,
+    provider: CustomProvider,
+}
+
+impl APIClient {
+    /// Creates a client for a custom provider that shares this client's
+    /// timeout, retry and TLS settings
+    pub fn custom_cache_client(&self, provider: CustomProvider) -> CustomCacheClient {
+        CustomCacheClient {
+            client: self.client.clone(),
+            user_agent: self.user_agent.clone(),
+            retry_policy: self.retry_policy,
+            artifact_headers: self.artifact_headers.clone(),
+            provider,
+        }
+    }
+}
+
+impl CustomCacheClient {
+    fn request(&self, method: Method, url: Url, token: &str) -> RequestBuilder {
+        let mut request_builder = self
+            .client
+            .request(method, url)
+            .header("User-Agent", self.user_agent.clone());
+        if let Some((name, value)) = self.provider.auth_header(token) {
+            request_builder = request_builder.header(name, value);
+        }
+
+        request_builder
+    }
+}
+
+#[async_trait]
+impl CacheClient for CustomCacheClient {
+    async fn get_artifact(
+        &self,
+        hash: &str,
+        token: &str,
+        _team_id: Option<&str>,
+        _team_slug: Option<&str>,
+        method: Method,
+    ) -> Result<Option<Response>> {
+        let request_builder = self.request(method, self.provider.get_url(hash)?, token);
+
+        let response = retry::make_retryable_request(request_builder, self.retry_policy).await?;
+
+        match response.status() {
+            StatusCode::NOT_FOUND => Ok(None),
+            _ => Ok(Some(response.error_for_status()?)),
+        }
+    }
+
+    #[tracing::instrument(skip_all)]
+    async fn fetch_artifact(
+        &self,
+        hash: &str,
+        token: &str,
+        team_id: Option<&str>,
+        team_slug: Option<&str>,
+    ) -> Result<Option<Response>> {
+        self.get_artifact(hash, token, team_id, team_slug, Method::GET)
+            .await
+    }
+
+    #[tracing::instrument(skip_all)]
+    async fn put_artifact(
+        &self,
+        hash: &str,
+        artifact_body: &[u8],
+        duration: u64,
+        tag: Option<&str>,
+        token: &str,
+        _team_id: Option<&str>,
+        _team_slug: Option<&str>,
+    ) -> Result<()> {
+        let mut request_builder = self
+            .request(Method::PUT, self.provider.put_url(hash)?, token)
+            .header("Content-Type", "application/octet-stream")
+            .header("x-artifact-duration", duration.to_string())
+            .body(artifact_body.to_vec());
+
+        if let Some(tag) = tag {
+            request_builder = request_builder.header("x-artifact-tag", tag);
+        }
+
+        for (name, value) in &self.artifact_headers {
+            request_builder = request_builder.header(name, value);
+        }
+
+        retry::make_retryable_request(request_builder, self.retry_policy)
+            .await?
+            .error_for_status()?;
+        Ok(())
+    }
+
+    #[tracing::instrument(skip_all)]
+    async fn artifact_exists(
+        &self,
+        hash: &str,
+        token: &str,
+        team_id: Option<&str>,
+        team_slug: Option<&str>,
+    ) -> Result<Option<Response>> {
+        self.get_artifact(hash, token, team_id, team_slug, Method::HEAD)
+            .await
+    }
+
+    async fn get_caching_status(
+        &self,
+        _token: &str,
+        _team_id: Option<&str>,
+        _team_slug: Option<&str>,
+    ) -> Result<CachingStatusResponse> {
+        // Custom providers have no notion of usage limits, so caching is
+        // enabled as long as the provider is configured
+        Ok(CachingStatusResponse {
+            status: CachingStatus::Enabled,
+        })
+    }
+}
+
+#[cfg(test)]
+pub(crate) mod test {
+    use anyhow::Result;
+    use turborepo_vercel_api_mock::start_test_server;
+
+    use super::*;
+
+    fn provider(base_url: &str) -> CustomProvider {
+        CustomProvider {
+            base_url: base_url.to_string(),
+            auth_header: Some("Authorization: Bearer {token}".to_string()),
+            get_path: "/v8/artifacts/{hash}".to_string(),
+            put_path: "/v8/artifacts/{hash}".to_string(),
+        }
+    }
+
+    // Checks the behavior turbo relies on from any provider: misses are
+    // reported as `None`, and an uploaded artifact can be found and
+    // downloaded along with its duration.
+    pub(crate) async fn check_conformance(client: &impl CacheClient, token: &str) -> Result<()> {
+        let hash = "conformance-hash";
+        let body = b"conformance artifact";
+
+        assert!(client
+            .fetch_artifact("missing-hash", token, None, None)
+            .await?
+            .is_none());
+        assert!(client
+            .artifact_exists("missing-hash", token, None, None)
+            .await?
+            .is_none());
+
+        client
+            .put_artifact(hash, body, 42, None, token, None, None)
+            .await?;
+
+        let exists = client
+            .artifact_exists(hash, token, None, None)
+            .await?
+            .expect("uploaded artifact should exist");
+        assert_eq!(
+            exists
+                .headers()
+                .get("x-artifact-duration")
+                .map(|value| value.to_str().unwrap()),
+            Some("42")
+        );
+
+        let fetched = client
+            .fetch_artifact(hash, token, None, None)
+            .await?
+            .expect("uploaded artifact should be fetched");
+        assert_eq!(fetched.bytes().await?.as_ref(), body);
+
+        Ok(())
+    }
+
+    #[tokio::test]
+    async fn test_mock_server_conformance() -> Result<()> {
+        let port = port_scanner::request_open_port().unwrap();
+        let handle = tokio::spawn(start_test_server(port));
+        let base_url = format!("http://localhost:{}", port);
+
+        let client = APIClient::new(&base_url, 200, "2.0.0", false)?
+            .custom_cache_client(provider(&base_url));
+        check_conformance(&client, "my-token").await?;
+
+        handle.abort();
+        Ok(())
+    }
+
+    #[test]
+    fn test_templates() {
+        let provider = CustomProvider {
+            base_url: "https://cache.example.com/turbo/".to_string(),
+            auth_header: Some("x-api-key: {token}".to_string()),
+            get_path: "/artifacts/{hash}.tar.zst".to_string(),
+            put_path: DEFAULT_ARTIFACT_PATH.to_string(),
+        };
+
+        assert_eq!(
+            provider.get_url("abc").unwrap().as_str(),
+            "https://cache.example.com/turbo/artifacts/abc.tar.zst"
+        );
+        assert_eq!(
+            provider.put_url("abc").unwrap().as_str(),
+            "https://cache.example.com/turbo/abc"
+        );
+        assert_eq!(
+            provider.auth_header("secret"),
+            Some(("x-api-key".to_string(), "secret".to_string()))
+        );
+    }
+}
@@ -47,6 +47,13 @@ pub enum Error {
     },
     #[error("[HTTP 403] token is forbidden from accessing {url}")]
     ForbiddenToken { url: String },
+    #[error("remote cache request failed: {0}")]
+    GrpcError(#[from] tonic::Status),
+    #[error("unable to connect to {url}: {err}")]
+    GrpcTransport {
+        url: String,
+        err: tonic::transport::Error,
+    },
 }
 
 pub type Result<T> = std::result::Result<T, Error>;
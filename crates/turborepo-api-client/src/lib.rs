@@ -18,9 +18,14 @@ use turborepo_vercel_api::{
 };
 use url::Url;
 
-pub use crate::error::{Error, Result};
+pub use crate::{
+    error::{Error, Result},
+    retry::RetryPolicy,
+};
 
 pub mod analytics;
+pub mod bazel;
+pub mod custom;
 mod error;
 mod retry;
 pub mod spaces;
@@ -98,6 +103,10 @@ pub struct APIClient {
     base_url: String,
     user_agent: String,
     use_preflight: bool,
+    retry_policy: RetryPolicy,
+    // Sent with every artifact upload, in addition to the headers the API
+    // requires
+    artifact_headers: Vec<(String, String)>,
 }
 
 #[derive(Clone)]
@@ -123,7 +132,7 @@ impl Client for APIClient {
             .header("User-Agent", self.user_agent.clone())
             .header("Authorization", format!("Bearer {}", token))
             .header("Content-Type", "application/json");
-        let response = retry::make_retryable_request(request_builder)
+        let response = retry::make_retryable_request(request_builder, self.retry_policy)
             .await?
             .error_for_status()?;
 
@@ -138,7 +147,7 @@ impl Client for APIClient {
             .header("Content-Type", "application/json")
             .header("Authorization", format!("Bearer {}", token));
 
-        let response = retry::make_retryable_request(request_builder)
+        let response = retry::make_retryable_request(request_builder, self.retry_policy)
             .await?
             .error_for_status()?;
 
@@ -183,7 +192,7 @@ impl Client for APIClient {
             .header("Content-Type", "application/json")
             .header("Authorization", format!("Bearer {}", token));
 
-        let response = retry::make_retryable_request(request_builder)
+        let response = retry::make_retryable_request(request_builder, self.retry_policy)
             .await?
             .error_for_status()?;
 
@@ -197,7 +206,7 @@ impl Client for APIClient {
             .query(&[("token", token), ("tokenName", token_name)])
             .header("User-Agent", self.user_agent.clone());
 
-        let response = retry::make_retryable_request(request_builder)
+        let response = retry::make_retryable_request(request_builder, self.retry_policy)
             .await?
             .error_for_status()?;
 
@@ -300,7 +309,7 @@ impl CacheClient for APIClient {
 
         request_builder = Self::add_team_params(request_builder, team_id, team_slug);
 
-        let response = retry::make_retryable_request(request_builder).await?;
+        let response = retry::make_retryable_request(request_builder, self.retry_policy).await?;
 
         match response.status() {
             StatusCode::FORBIDDEN => Err(Self::handle_403(response).await),
@@ -348,13 +357,15 @@ impl CacheClient for APIClient {
         let mut allow_auth = true;
 
         if self.use_preflight {
+            let mut request_headers = String::from(
+                "Authorization, Content-Type, User-Agent, x-artifact-duration, x-artifact-tag",
+            );
+            for (name, _) in &self.artifact_headers {
+                request_headers.push_str(", ");
+                request_headers.push_str(name);
+            }
             let preflight_response = self
-                .do_preflight(
-                    token,
-                    request_url.clone(),
-                    "PUT",
-                    "Authorization, Content-Type, User-Agent, x-artifact-duration, x-artifact-tag",
-                )
+                .do_preflight(token, request_url.clone(), "PUT", &request_headers)
                 .await?;
 
             allow_auth = preflight_response.allow_authorization_header;
@@ -381,7 +392,11 @@ impl CacheClient for APIClient {
             request_builder = request_builder.header("x-artifact-tag", tag);
         }
 
-        let response = retry::make_retryable_request(request_builder).await?;
+        for (name, value) in &self.artifact_headers {
+            request_builder = request_builder.header(name, value);
+        }
+
+        let response = retry::make_retryable_request(request_builder, self.retry_policy).await?;
 
         if response.status() == StatusCode::FORBIDDEN {
             return Err(Self::handle_403(response).await);
@@ -406,7 +421,7 @@ impl CacheClient for APIClient {
 
         let request_builder = Self::add_team_params(request_builder, team_id, team_slug);
 
-        let response = retry::make_retryable_request(request_builder)
+        let response = retry::make_retryable_request(request_builder, self.retry_policy)
             .await?
             .error_for_status()?;
 
@@ -442,7 +457,7 @@ impl TokenClient for APIClient {
             invalid_token: bool,
         }
 
-        let response = retry::make_retryable_request(request_builder).await?;
+        let response = retry::make_retryable_request(request_builder, self.retry_policy).await?;
         let status = response.status();
         // Give a better error message for invalid tokens. This endpoint returns the
         // following statuses:
@@ -494,7 +509,7 @@ impl TokenClient for APIClient {
             invalid_token: bool,
         }
 
-        let response = retry::make_retryable_request(request_builder).await?;
+        let response = retry::make_retryable_request(request_builder, self.retry_policy).await?;
         let status = response.status();
         // Give a better error message for invalid tokens. This endpoint returns the
         // following statuses:
@@ -548,9 +563,25 @@ impl APIClient {
             base_url: base_url.as_ref().to_string(),
             user_agent,
             use_preflight,
+            retry_policy: RetryPolicy::default(),
+            artifact_headers: Vec::new(),
         })
     }
 
+    /// Sets how many times requests that fail with a transient error, such
+    /// as a 503 or a reset connection, are retried
+    pub fn with_max_retries(mut self, max_retries: u32) -> Self {
+        self.retry_policy.max_retries = max_retries;
+        self
+    }
+
+    /// Sets headers that are sent with every artifact upload, e.g. to record
+    /// where the artifact was built
+    pub fn with_artifact_headers(mut self, artifact_headers: Vec<(String, String)>) -> Self {
+        self.artifact_headers = artifact_headers;
+        self
+    }
+
     pub fn base_url(&self) -> &str {
         self.base_url.as_str()
     }
@@ -570,7 +601,7 @@ impl APIClient {
             .header("Access-Control-Request-Headers", request_headers)
             .header("Authorization", format!("Bearer {}", token));
 
-        let response = retry::make_retryable_request(request_builder).await?;
+        let response = retry::make_retryable_request(request_builder, self.retry_policy).await?;
 
         let headers = response.headers();
         let location = if let Some(location) = headers.get("Location") {
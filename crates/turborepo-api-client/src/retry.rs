@@ -1,27 +1,69 @@
+use std::time::Duration;
+
+use rand::Rng;
 use reqwest::{RequestBuilder, Response, StatusCode};
 use tokio::time::sleep;
+use tracing::debug;
 
 use crate::Error;
 
-const MIN_SLEEP_TIME_SECS: u64 = 2;
-const MAX_SLEEP_TIME_SECS: u64 = 10;
-const RETRY_MAX: u32 = 2;
+const DEFAULT_MAX_RETRIES: u32 = 2;
+const BASE_DELAY: Duration = Duration::from_secs(1);
+const MAX_DELAY: Duration = Duration::from_secs(10);
+
+/// How requests that fail with a transient error are retried
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct RetryPolicy {
+    pub max_retries: u32,
+    pub base_delay: Duration,
+    pub max_delay: Duration,
+}
 
-/// Retries a request until `RETRY_MAX` is reached, the `should_retry_request`
-/// function returns false, or the future succeeds. Uses an exponential backoff
-/// with a base of 2 to delay between retries.
+impl Default for RetryPolicy {
+    fn default() -> Self {
+        Self {
+            max_retries: DEFAULT_MAX_RETRIES,
+            base_delay: BASE_DELAY,
+            max_delay: MAX_DELAY,
+        }
+    }
+}
+
+impl RetryPolicy {
+    // The delay doubles with every retry. Sleeping for a random part of the
+    // upper half keeps concurrent requests that failed together from retrying
+    // in lockstep.
+    fn delay(&self, retry_count: u32) -> Duration {
+        let ceiling = self
+            .base_delay
+            .saturating_mul(2_u32.saturating_pow(retry_count))
+            .min(self.max_delay);
+        let half = ceiling / 2;
+        half + rand::thread_rng().gen_range(Duration::ZERO..=half)
+    }
+}
+
+/// Retries a request until `policy.max_retries` is reached, the request fails
+/// with an error that isn't transient, or the request succeeds. Uses a
+/// jittered exponential backoff to delay between retries.
+///
+/// Responses with a status that may be transient, such as a 503, are retried
+/// as well. If retries run out the last response is returned so that callers
+/// can handle its status.
 ///
 /// # Arguments
 ///
 /// * `request_builder`: The request builder with everything, i.e. headers and
 ///   body already set. NOTE: This must be cloneable, so no streams are allowed.
+/// * `policy`: How many times to retry and how long to wait in between.
 ///
 /// returns: Result<Response, Error>
 pub(crate) async fn make_retryable_request(
     request_builder: RequestBuilder,
+    policy: RetryPolicy,
 ) -> Result<Response, Error> {
-    let mut last_error = None;
-    for retry_count in 0..RETRY_MAX {
+    let mut retry_count = 0;
+    loop {
         // A request builder can fail to clone for two reasons:
         // - the URL given was given as a string and isn't a valid URL this can be
         //   mitigated by constructing requests with pre-parsed URLs via Url::parse
@@ -30,35 +72,168 @@ pub(crate) async fn make_retryable_request(
         let Some(builder) = request_builder.try_clone() else {
             return Ok(request_builder.send().await?);
         };
-        match builder.send().await {
-            Ok(value) => return Ok(value),
-            Err(err) => {
-                if !should_retry_request(&err) {
-                    return Err(err.into());
-                }
-                last_error = Some(err);
-            }
+        let result = builder.send().await;
+        let should_retry = match &result {
+            Ok(response) => should_retry_status(response.status()),
+            Err(err) => should_retry_error(err),
+        };
+        if !should_retry || retry_count >= policy.max_retries {
+            return match result {
+                Ok(response) => Ok(response),
+                Err(err) if should_retry => Err(Error::TooManyFailures(Box::new(err))),
+                Err(err) => Err(err.into()),
+            };
         }
 
-        let sleep_period = (2_u64)
-            .pow(retry_count)
-            .clamp(MIN_SLEEP_TIME_SECS, MAX_SLEEP_TIME_SECS);
-        sleep(std::time::Duration::from_secs(sleep_period)).await;
+        let delay = policy.delay(retry_count);
+        match &result {
+            Ok(response) => debug!(
+                "request failed with {}, retrying in {:?}",
+                response.status(),
+                delay
+            ),
+            Err(err) => debug!("request failed: {}, retrying in {:?}", err, delay),
+        }
+        sleep(delay).await;
+        retry_count += 1;
     }
+}
 
-    Err(Error::TooManyFailures(Box::new(last_error.unwrap())))
+// Rate limits and server errors are usually transient. Client errors, such as
+// a 401 or 403 for a bad token, will fail again no matter how often they're
+// retried.
+fn should_retry_status(status: StatusCode) -> bool {
+    status == StatusCode::TOO_MANY_REQUESTS
+        || (status.is_server_error() && status != StatusCode::NOT_IMPLEMENTED)
 }
 
-fn should_retry_request(error: &reqwest::Error) -> bool {
+// Timeouts, refused connections and connections that were reset while sending
+// the request
+fn should_retry_error(error: &reqwest::Error) -> bool {
     if let Some(status) = error.status() {
-        if status == StatusCode::TOO_MANY_REQUESTS {
-            return true;
+        return should_retry_status(status);
+    }
+
+    error.is_timeout() || error.is_connect() || error.is_request()
+}
+
+#[cfg(test)]
+mod test {
+    use std::{
+        net::TcpListener,
+        sync::{
+            atomic::{AtomicU32, Ordering},
+            Arc,
+        },
+    };
+
+    use test_case::test_case;
+    use tokio::io::{AsyncReadExt, AsyncWriteExt};
+
+    use super::*;
+
+    #[test_case(StatusCode::INTERNAL_SERVER_ERROR, true ; "server error")]
+    #[test_case(StatusCode::BAD_GATEWAY, true ; "bad gateway")]
+    #[test_case(StatusCode::TOO_MANY_REQUESTS, true ; "rate limited")]
+    #[test_case(StatusCode::NOT_IMPLEMENTED, false ; "not implemented")]
+    #[test_case(StatusCode::UNAUTHORIZED, false ; "unauthorized")]
+    #[test_case(StatusCode::FORBIDDEN, false ; "forbidden")]
+    #[test_case(StatusCode::NOT_FOUND, false ; "not found")]
+    fn test_should_retry_status(status: StatusCode, expected: bool) {
+        assert_eq!(should_retry_status(status), expected);
+    }
+
+    #[test]
+    fn test_delay() {
+        let policy = RetryPolicy::default();
+        for retry_count in 0..8 {
+            let ceiling = (BASE_DELAY * 2_u32.pow(retry_count)).min(MAX_DELAY);
+            let delay = policy.delay(retry_count);
+            assert!(delay >= ceiling / 2 && delay <= ceiling, "{delay:?}");
         }
+    }
+
+    // Responds with each status in turn, then with the last one forever
+    async fn serve(statuses: Vec<u16>) -> (String, Arc<AtomicU32>) {
+        let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+        listener.set_nonblocking(true).unwrap();
+        let url = format!("http://{}", listener.local_addr().unwrap());
+        let listener = tokio::net::TcpListener::from_std(listener).unwrap();
+        let requests = Arc::new(AtomicU32::new(0));
+        let counter = requests.clone();
+        tokio::spawn(async move {
+            while let Ok((mut stream, _)) = listener.accept().await {
+                let count = counter.fetch_add(1, Ordering::SeqCst) as usize;
+                let status = statuses[count.min(statuses.len() - 1)];
+                let mut buf = [0; 1024];
+                let _ = stream.read(&mut buf).await;
+                let response = format!(
+                    "HTTP/1.1 {status} Status\r\ncontent-length: 0\r\nconnection: close\r\n\r\n"
+                );
+                let _ = stream.write_all(response.as_bytes()).await;
+            }
+        });
+        (url, requests)
+    }
 
-        if status.as_u16() >= 500 && status.as_u16() != 501 {
-            return true;
+    fn fast_policy(max_retries: u32) -> RetryPolicy {
+        RetryPolicy {
+            max_retries,
+            base_delay: Duration::from_millis(1),
+            max_delay: Duration::from_millis(2),
         }
     }
 
-    false
+    #[tokio::test]
+    async fn test_retries_server_errors() {
+        let (url, requests) = serve(vec![503, 502, 200]).await;
+        let request = reqwest::Client::new().get(url);
+
+        let response = make_retryable_request(request, fast_policy(2))
+            .await
+            .unwrap();
+
+        assert_eq!(response.status(), StatusCode::OK);
+        assert_eq!(requests.load(Ordering::SeqCst), 3);
+    }
+
+    #[tokio::test]
+    async fn test_returns_last_response_when_retries_run_out() {
+        let (url, requests) = serve(vec![500]).await;
+        let request = reqwest::Client::new().get(url);
+
+        let response = make_retryable_request(request, fast_policy(1))
+            .await
+            .unwrap();
+
+        assert_eq!(response.status(), StatusCode::INTERNAL_SERVER_ERROR);
+        assert_eq!(requests.load(Ordering::SeqCst), 2);
+    }
+
+    #[tokio::test]
+    async fn test_does_not_retry_forbidden() {
+        let (url, requests) = serve(vec![403, 200]).await;
+        let request = reqwest::Client::new().get(url);
+
+        let response = make_retryable_request(request, fast_policy(2))
+            .await
+            .unwrap();
+
+        assert_eq!(response.status(), StatusCode::FORBIDDEN);
+        assert_eq!(requests.load(Ordering::SeqCst), 1);
+    }
+
+    #[tokio::test]
+    async fn test_retries_refused_connections() {
+        // Bind and drop a listener to find a port that nothing is listening on
+        let addr = TcpListener::bind("127.0.0.1:0")
+            .unwrap()
+            .local_addr()
+            .unwrap();
+        let request = reqwest::Client::new().get(format!("http://{addr}"));
+
+        let result = make_retryable_request(request, fast_policy(1)).await;
+
+        assert!(matches!(result, Err(Error::TooManyFailures(_))));
+    }
 }
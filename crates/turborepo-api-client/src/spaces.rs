@@ -152,7 +152,7 @@ impl APIClient {
             .await?
             .json(&payload);
 
-        let response = retry::make_retryable_request(request_builder)
+        let response = retry::make_retryable_request(request_builder, self.retry_policy)
             .await?
             .error_for_status()?;
 
@@ -176,7 +176,7 @@ impl APIClient {
             .await?
             .json(&task);
 
-        retry::make_retryable_request(request_builder)
+        retry::make_retryable_request(request_builder, self.retry_policy)
             .await?
             .error_for_status()?;
 
@@ -201,7 +201,7 @@ impl APIClient {
             .await?
             .json(&payload);
 
-        retry::make_retryable_request(request_builder)
+        retry::make_retryable_request(request_builder, self.retry_policy)
             .await?
             .error_for_status()?;
 
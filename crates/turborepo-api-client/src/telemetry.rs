@@ -2,7 +2,7 @@ use async_trait::async_trait;
 use reqwest::Method;
 use turborepo_vercel_api::telemetry::TelemetryEvent;
 
-use crate::{retry, AnonAPIClient, Error};
+use crate::{retry, AnonAPIClient, Error, RetryPolicy};
 
 const TELEMETRY_ENDPOINT: &str = "/api/turborepo/v1/events";
 
@@ -34,7 +34,7 @@ impl TelemetryClient for AnonAPIClient {
             .header("x-turbo-session-id", session_id)
             .json(&events);
 
-        retry::make_retryable_request(telemetry_request)
+        retry::make_retryable_request(telemetry_request, RetryPolicy::default())
             .await?
             .error_for_status()?;
 
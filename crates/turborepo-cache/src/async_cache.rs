@@ -1,20 +1,42 @@
-use std::sync::{atomic::AtomicU8, Arc};
+use std::{
+    collections::HashMap,
+    future::Future,
+    sync::{
+        atomic::{AtomicUsize, Ordering},
+        Arc, Mutex,
+    },
+    time::Instant,
+};
 
 use futures::{stream::FuturesUnordered, StreamExt};
-use tokio::sync::{mpsc, Semaphore};
-use tracing::{warn, Instrument, Level};
-use turbopath::{AbsoluteSystemPath, AbsoluteSystemPathBuf, AnchoredSystemPathBuf};
+use tokio::sync::{mpsc, watch, Semaphore};
+use tracing::{Instrument, Level};
+use turbopath::{
+    AbsoluteSystemPath, AbsoluteSystemPathBuf, AnchoredSystemPath, AnchoredSystemPathBuf,
+};
 use turborepo_analytics::AnalyticsSender;
 use turborepo_api_client::{APIAuth, APIClient};
 
-use crate::{multiplexer::CacheMultiplexer, CacheError, CacheHitMetadata, CacheOpts};
-
-const WARNING_CUTOFF: u8 = 4;
+use crate::{
+    journal::WriteJournal, multiplexer::CacheMultiplexer, CacheDownloadMetadata, CacheError,
+    CacheHitMetadata, CacheOpts, CacheState, CacheUploadMetadata, NetworkUsage,
+};
 
 #[derive(Clone)]
 pub struct AsyncCache {
     real_cache: Arc<CacheMultiplexer>,
     writer_sender: mpsc::Sender<WorkerRequest>,
+    // Remote uploads that have finished, keyed by hash
+    uploads: Arc<Mutex<HashMap<String, CacheUploadMetadata>>>,
+    // Artifacts that were restored, keyed by hash
+    downloads: Arc<Mutex<HashMap<String, CacheDownloadMetadata>>>,
+    // Sizes of the artifacts that were too large to upload, keyed by hash
+    skipped_uploads: Arc<Mutex<HashMap<String, u64>>>,
+    journal: Arc<WriteJournal>,
+    // Set once the cache is cancelled, which stops every transfer in progress
+    cancelled: Arc<watch::Sender<bool>>,
+    // Writes that have been queued but haven't finished
+    pending_writes: Arc<AtomicUsize>,
 }
 
 enum WorkerRequest {
@@ -23,6 +45,8 @@ enum WorkerRequest {
         key: String,
         duration: u64,
         files: Vec<AnchoredSystemPathBuf>,
+        // Dropped once the write has finished
+        written: Option<tokio::sync::oneshot::Sender<()>>,
     },
     Flush(tokio::sync::oneshot::Sender<()>),
     Shutdown(tokio::sync::oneshot::Sender<()>),
@@ -37,6 +61,8 @@ impl AsyncCache {
         analytics_recorder: Option<AnalyticsSender>,
     ) -> Result<AsyncCache, CacheError> {
         let max_workers = opts.workers.try_into().expect("usize is smaller than u32");
+        let queue_size = opts.queue_size.max(1) as usize;
+        let drain_timeout = opts.drain_timeout;
         let real_cache = Arc::new(CacheMultiplexer::new(
             opts,
             repo_root,
@@ -44,15 +70,25 @@ impl AsyncCache {
             api_auth,
             analytics_recorder,
         )?);
-        let (writer_sender, mut write_consumer) = mpsc::channel(1);
+        let (writer_sender, mut write_consumer) = mpsc::channel(queue_size);
+        let uploads = Arc::new(Mutex::new(HashMap::new()));
+        let skipped_uploads = Arc::new(Mutex::new(HashMap::new()));
+        let journal = Arc::new(WriteJournal::new(repo_root));
+        let (cancelled, _) = watch::channel(false);
+        let cancelled = Arc::new(cancelled);
+        let pending_writes = Arc::new(AtomicUsize::new(0));
 
         // start a task to manage workers
         let worker_real_cache = real_cache.clone();
+        let worker_uploads = uploads.clone();
+        let worker_skipped_uploads = skipped_uploads.clone();
+        let worker_journal = journal.clone();
+        let worker_cancelled = cancelled.clone();
+        let worker_pending_writes = pending_writes.clone();
         tokio::spawn(async move {
             let semaphore = Arc::new(Semaphore::new(max_workers));
             let mut workers = FuturesUnordered::new();
             let real_cache = worker_real_cache;
-            let warnings = Arc::new(AtomicU8::new(0));
 
             let mut shutdown_callback = None;
             while let Some(request) = write_consumer.recv().await {
@@ -62,26 +98,45 @@ impl AsyncCache {
                         key,
                         duration,
                         files,
+                        written,
                     } => {
                         let permit = semaphore.clone().acquire_owned().await.unwrap();
                         let real_cache = real_cache.clone();
-                        let warnings = warnings.clone();
+                        let uploads = worker_uploads.clone();
+                        let skipped_uploads = worker_skipped_uploads.clone();
+                        let journal = worker_journal.clone();
+                        let cancelled = worker_cancelled.subscribe();
+                        let pending_writes = worker_pending_writes.clone();
                         let worker_span = tracing::span!(Level::TRACE, "cache worker: cache PUT");
                         workers.push(tokio::spawn(
                             async move {
-                                if let Err(err) =
-                                    real_cache.put(&anchor, &key, &files, duration).await
-                                {
-                                    let num_warnings =
-                                        warnings.load(std::sync::atomic::Ordering::Acquire);
-                                    if num_warnings <= WARNING_CUTOFF {
-                                        warnings.store(
-                                            num_warnings + 1,
-                                            std::sync::atomic::Ordering::Release,
-                                        );
-                                        warn!("{err}");
+                                let put = real_cache.put(&anchor, &key, &files, duration);
+                                let result = cancellable(cancelled, put).await;
+                                pending_writes.fetch_sub(1, Ordering::Relaxed);
+                                match result {
+                                    // The write stays in the journal so that the
+                                    // next run resumes it
+                                    Err(CacheError::Cancelled) => return,
+                                    Ok(Some(upload)) => {
+                                        uploads
+                                            .lock()
+                                            .expect("uploads mutex poisoned")
+                                            .insert(key.clone(), upload);
                                     }
+                                    Ok(None) => {}
+                                    // Still written to the local cache, so this
+                                    // is reported by the caller instead
+                                    Err(CacheError::ArtifactTooLarge(size, ..)) => {
+                                        skipped_uploads
+                                            .lock()
+                                            .expect("skipped uploads mutex poisoned")
+                                            .insert(key.clone(), size);
+                                    }
+                                    Err(err) => real_cache.warnings().warn(err),
                                 }
+                                // A failed write isn't retried by later runs
+                                journal.finish(&key);
+                                drop(written);
                                 // Release permit once we're done with the write
                                 drop(permit);
                             }
@@ -105,9 +160,32 @@ impl AsyncCache {
             drop(write_consumer);
 
             // wait for all writers to finish
-            while let Some(worker) = workers.next().await {
-                let _ = worker;
+            let drain = async {
+                while let Some(worker) = workers.next().await {
+                    let _ = worker;
+                }
+            };
+            match drain_timeout {
+                Some(timeout) => {
+                    if tokio::time::timeout(timeout, drain).await.is_err() {
+                        real_cache.warnings().warn(format!(
+                            "stopped waiting for {} cache writes after {}s, they will be resumed \
+                             by the next run",
+                            worker_pending_writes.load(Ordering::Relaxed),
+                            timeout.as_secs()
+                        ));
+                        worker_cancelled.send_replace(true);
+                        while let Some(worker) = workers.next().await {
+                            let _ = worker;
+                        }
+                    }
+                }
+                None => drain.await,
             }
+            // All writes have finished, so nothing is added to the cache mid-sweep
+            real_cache.collect_garbage();
+            // No more writes can fail, so report the warnings we've collected
+            real_cache.warnings().flush();
             if let Some(callback) = shutdown_callback {
                 callback.send(()).ok();
             }
@@ -116,9 +194,24 @@ impl AsyncCache {
         Ok(AsyncCache {
             real_cache,
             writer_sender,
+            uploads,
+            downloads: Arc::new(Mutex::new(HashMap::new())),
+            skipped_uploads,
+            journal,
+            cancelled,
+            pending_writes,
         })
     }
 
+    /// Stops the transfers in progress along with any that are queued, e.g.
+    /// when the run is interrupted. Writes that were cut short are resumed by
+    /// the next run, and later operations fail with `CacheError::Cancelled`.
+    pub fn cancel(&self) {
+        self.cancelled.send_replace(true);
+        // An interrupted run may exit before the cache shuts down
+        self.real_cache.warnings().flush();
+    }
+
     #[tracing::instrument(skip_all)]
     pub async fn put(
         &self,
@@ -127,6 +220,35 @@ impl AsyncCache {
         files: Vec<AnchoredSystemPathBuf>,
         duration: u64,
     ) -> Result<(), CacheError> {
+        self.queue_write(anchor, key, files, duration, None).await
+    }
+
+    /// Like `put`, but returns a receiver that resolves once the write has
+    /// finished, whether or not it succeeded
+    #[tracing::instrument(skip_all)]
+    pub async fn put_and_notify(
+        &self,
+        anchor: AbsoluteSystemPathBuf,
+        key: String,
+        files: Vec<AnchoredSystemPathBuf>,
+        duration: u64,
+    ) -> Result<tokio::sync::oneshot::Receiver<()>, CacheError> {
+        let (tx, rx) = tokio::sync::oneshot::channel();
+        self.queue_write(anchor, key, files, duration, Some(tx))
+            .await?;
+        Ok(rx)
+    }
+
+    async fn queue_write(
+        &self,
+        anchor: AbsoluteSystemPathBuf,
+        key: String,
+        files: Vec<AnchoredSystemPathBuf>,
+        duration: u64,
+        written: Option<tokio::sync::oneshot::Sender<()>>,
+    ) -> Result<(), CacheError> {
+        self.journal.record(&anchor, &key, duration, &files);
+        self.pending_writes.fetch_add(1, Ordering::Relaxed);
         if self
             .writer_sender
             .send(WorkerRequest::WriteRequest {
@@ -134,19 +256,71 @@ impl AsyncCache {
                 key,
                 duration,
                 files,
+                written,
             })
             .await
             .is_err()
         {
+            self.pending_writes.fetch_sub(1, Ordering::Relaxed);
             Err(CacheError::CacheShuttingDown)
         } else {
             Ok(())
         }
     }
 
+    /// The number of writes that are queued or in progress
+    pub fn pending_writes(&self) -> usize {
+        self.pending_writes.load(Ordering::Relaxed)
+    }
+
+    /// Queues the writes that an interrupted run didn't finish and waits for
+    /// them, so that the tasks they belong to are cache hits in this run.
+    /// Returns how many writes were resumed.
+    #[tracing::instrument(skip_all)]
+    pub async fn resume_interrupted_writes(&self) -> Result<usize, CacheError> {
+        let pending = self.journal.pending();
+        let resumed = pending.len();
+        for (anchor, write) in pending {
+            self.put(anchor, write.key, write.files, write.duration)
+                .await?;
+        }
+        if resumed > 0 {
+            self.wait().await?;
+        }
+        Ok(resumed)
+    }
+
     #[tracing::instrument(skip_all)]
     pub async fn exists(&self, key: &str) -> Result<Option<CacheHitMetadata>, CacheError> {
-        self.real_cache.exists(key).await
+        cancellable(self.cancelled.subscribe(), self.real_cache.exists(key)).await
+    }
+
+    /// Checks the local and the remote caches for `key` separately, see
+    /// `CacheMultiplexer::cache_state`
+    #[tracing::instrument(skip_all)]
+    pub async fn cache_state(&self, key: &str) -> Result<CacheState, CacheError> {
+        cancellable(self.cancelled.subscribe(), async {
+            Ok(self.real_cache.cache_state(key).await)
+        })
+        .await
+    }
+
+    /// Checks which of `keys` are cached without downloading any artifacts.
+    /// The remote caches are asked about every key at once, and hashes they
+    /// don't have are remembered so that fetching them later is a local miss.
+    #[tracing::instrument(skip_all)]
+    pub async fn exists_all(
+        &self,
+        keys: impl IntoIterator<Item = String>,
+    ) -> HashMap<String, Result<Option<CacheHitMetadata>, CacheError>> {
+        keys.into_iter()
+            .map(|key| async move {
+                let response = self.exists(&key).await;
+                (key, response)
+            })
+            .collect::<FuturesUnordered<_>>()
+            .collect()
+            .await
     }
 
     #[tracing::instrument(skip_all)]
@@ -155,11 +329,98 @@ impl AsyncCache {
         anchor: &AbsoluteSystemPath,
         key: &str,
     ) -> Result<Option<(CacheHitMetadata, Vec<AnchoredSystemPathBuf>)>, CacheError> {
-        self.real_cache.fetch(anchor, key).await
+        let start = Instant::now();
+        let response = cancellable(
+            self.cancelled.subscribe(),
+            self.real_cache.fetch(anchor, key),
+        )
+        .await;
+        self.record_download(key, start, &response);
+        response
     }
 
-    // Used for testing to ensure that the workers resolve
-    // before checking the cache.
+    /// Fetches an artifact but only restores the files accepted by `filter`,
+    /// e.g. to skip files that were cached under a different configuration
+    #[tracing::instrument(skip_all)]
+    pub async fn fetch_matching(
+        &self,
+        anchor: &AbsoluteSystemPath,
+        key: &str,
+        filter: &(impl Fn(&AnchoredSystemPath) -> bool + Sync),
+    ) -> Result<Option<(CacheHitMetadata, Vec<AnchoredSystemPathBuf>)>, CacheError> {
+        let start = Instant::now();
+        let response = cancellable(
+            self.cancelled.subscribe(),
+            self.real_cache.fetch_matching(anchor, key, filter),
+        )
+        .await;
+        self.record_download(key, start, &response);
+        response
+    }
+
+    fn record_download<T>(
+        &self,
+        key: &str,
+        start: Instant,
+        response: &Result<Option<T>, CacheError>,
+    ) {
+        if let Ok(Some(_)) = response {
+            let download = CacheDownloadMetadata {
+                bytes: self.real_cache.downloaded_bytes(key),
+                duration: start.elapsed(),
+            };
+            self.downloads
+                .lock()
+                .expect("downloads mutex poisoned")
+                .insert(key.to_string(), download);
+        }
+    }
+
+    /// Downloads an artifact from the remote cache into the local cache without
+    /// restoring its files
+    #[tracing::instrument(skip_all)]
+    pub async fn prefetch(&self, key: &str) -> Result<Option<CacheHitMetadata>, CacheError> {
+        cancellable(self.cancelled.subscribe(), self.real_cache.prefetch(key)).await
+    }
+
+    /// Returns the size and duration of the remote upload for the given hash
+    /// if it has finished.
+    pub fn upload_metadata(&self, key: &str) -> Option<CacheUploadMetadata> {
+        self.uploads
+            .lock()
+            .expect("uploads mutex poisoned")
+            .get(key)
+            .copied()
+    }
+
+    /// Returns how much was downloaded for the given hash and how long it took
+    /// to restore, if it was a cache hit
+    pub fn download_metadata(&self, key: &str) -> Option<CacheDownloadMetadata> {
+        self.downloads
+            .lock()
+            .expect("downloads mutex poisoned")
+            .get(key)
+            .copied()
+    }
+
+    /// Returns the size of the artifact for the given hash if it was too large
+    /// to upload to the remote cache
+    pub fn skipped_upload(&self, key: &str) -> Option<u64> {
+        self.skipped_uploads
+            .lock()
+            .expect("skipped uploads mutex poisoned")
+            .get(key)
+            .copied()
+    }
+
+    /// Returns the number of bytes transferred to and from the remote cache so
+    /// far. Uploads that are still pending aren't included.
+    pub fn network_usage(&self) -> NetworkUsage {
+        self.real_cache.network_usage()
+    }
+
+    // Ensures that the workers resolve before checking the cache. Used in tests
+    // and before reporting upload metadata.
     #[tracing::instrument(skip_all)]
     pub async fn wait(&self) -> Result<(), CacheError> {
         let (tx, rx) = tokio::sync::oneshot::channel();
@@ -184,22 +445,64 @@ impl AsyncCache {
     }
 }
 
+// Runs `operation` unless the cache is cancelled first, in which case it's
+// dropped along with any requests it has in flight
+async fn cancellable<T>(
+    mut cancelled: watch::Receiver<bool>,
+    operation: impl Future<Output = Result<T, CacheError>>,
+) -> Result<T, CacheError> {
+    let wait_for_cancel = async move {
+        // The sender only goes away with the cache, which can't be cancelled after
+        if cancelled.wait_for(|cancelled| *cancelled).await.is_err() {
+            std::future::pending::<()>().await;
+        }
+    };
+    tokio::select! {
+        biased;
+        _ = wait_for_cancel => Err(CacheError::Cancelled),
+        result = operation => result,
+    }
+}
+
 #[cfg(test)]
 mod tests {
-    use std::assert_matches::assert_matches;
+    use std::{assert_matches::assert_matches, time::Duration};
 
     use anyhow::Result;
+    use camino::Utf8PathBuf;
     use futures::future::try_join_all;
     use tempfile::tempdir;
+    use tokio::sync::watch;
     use turbopath::AbsoluteSystemPathBuf;
     use turborepo_api_client::{APIAuth, APIClient};
     use turborepo_vercel_api_mock::start_test_server;
 
+    use super::cancellable;
     use crate::{
+        journal::WriteJournal,
         test_cases::{get_test_cases, TestCase},
-        AsyncCache, CacheHitMetadata, CacheOpts, CacheSource, RemoteCacheOpts,
+        AsyncCache, CacheError, CacheHitMetadata, CacheOpts, CacheSource, CacheState,
+        RemoteCacheOpts, RemoteCacheWritePolicy, RestoreMode,
     };
 
+    #[tokio::test]
+    async fn test_cancel_stops_pending_operation() {
+        let (cancelled, rx) = watch::channel(false);
+        let operation = tokio::spawn(cancellable(
+            rx,
+            std::future::pending::<Result<(), CacheError>>(),
+        ));
+        cancelled.send_replace(true);
+        assert_matches!(operation.await.unwrap(), Err(CacheError::Cancelled));
+    }
+
+    #[tokio::test]
+    async fn test_dropped_cache_doesnt_cancel() {
+        let (cancelled, rx) = watch::channel(false);
+        drop(cancelled);
+        assert_matches!(cancellable(rx, async { Ok(1) }).await, Ok(1));
+    }
+
     #[tokio::test]
     async fn test_async_cache() -> Result<()> {
         let port = port_scanner::request_open_port().unwrap();
@@ -216,6 +519,567 @@ mod tests {
         Ok(())
     }
 
+    #[tokio::test]
+    async fn test_concurrent_remote_fetches() -> Result<()> {
+        let port = port_scanner::request_open_port().unwrap();
+        let handle = tokio::spawn(start_test_server(port));
+
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPathBuf::try_from(repo_root.path())?;
+        let test_case = &get_test_cases()[0];
+        test_case.initialize(&repo_root_path)?;
+
+        let hash = format!("{}-concurrent", test_case.hash);
+
+        let opts = CacheOpts {
+            override_dir: None,
+            remote_cache_read_only: false,
+            write_only: false,
+            skip_remote: false,
+            skip_filesystem: true,
+            workers: 10,
+            queue_size: 1,
+            drain_timeout: None,
+            dereference_symlinks: false,
+            restore_mode: RestoreMode::Hardlink,
+            show_all_warnings: false,
+            namespace: None,
+            max_age: None,
+            remote_read_concurrency: None,
+            remote_write_concurrency: None,
+            max_artifact_size: None,
+            provenance: None,
+            remote_cache_opts: Some(RemoteCacheOpts {
+                unused_team_id: Some("my-team".to_string()),
+                signature: false,
+                custom_provider: None,
+                upload_chunk_size: None,
+                fallbacks: Vec::new(),
+                write_policy: RemoteCacheWritePolicy::WriteFirst,
+            }),
+        };
+
+        let api_client = APIClient::new(format!("http://localhost:{}", port), 200, "2.0.0", true)?;
+        let api_auth = Some(APIAuth {
+            team_id: Some("my-team-id".to_string()),
+            token: "my-token".to_string(),
+            team_slug: None,
+        });
+        let async_cache = AsyncCache::new(&opts, &repo_root_path, api_client, api_auth, None)?;
+
+        async_cache
+            .put(
+                repo_root_path.clone(),
+                hash.clone(),
+                test_case
+                    .files
+                    .iter()
+                    .map(|f| f.path().to_owned())
+                    .collect(),
+                test_case.duration,
+            )
+            .await?;
+        async_cache.wait().await?;
+
+        // Both fetches should get the artifact even though only one downloads it
+        let (first, second) = tokio::join!(
+            async_cache.fetch(&repo_root_path, &hash),
+            async_cache.fetch(&repo_root_path, &hash)
+        );
+        let first = first?.expect("first fetch should hit");
+        let second = second?.expect("second fetch should hit");
+        assert_eq!(first, second);
+        assert_eq!(first.0.source, CacheSource::Remote);
+        assert_eq!(first.1.len(), test_case.files.len());
+        // Finished fetches aren't kept around
+        assert_eq!(async_cache.real_cache.remote_fetches_in_progress(), 0);
+
+        async_cache.shutdown().await?;
+        handle.abort();
+        Ok(())
+    }
+
+    #[tokio::test]
+    async fn test_prefetch() -> Result<()> {
+        let port = port_scanner::request_open_port().unwrap();
+        let handle = tokio::spawn(start_test_server(port));
+
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPathBuf::try_from(repo_root.path())?;
+        let test_case = &get_test_cases()[0];
+        test_case.initialize(&repo_root_path)?;
+
+        let hash = format!("{}-prefetch", test_case.hash);
+        let api_auth = Some(APIAuth {
+            team_id: Some("my-team-id".to_string()),
+            token: "my-token".to_string(),
+            team_slug: None,
+        });
+        let opts = |skip_filesystem| CacheOpts {
+            skip_filesystem,
+            workers: 10,
+            remote_cache_opts: Some(RemoteCacheOpts {
+                unused_team_id: Some("my-team".to_string()),
+                signature: false,
+                custom_provider: None,
+                upload_chunk_size: None,
+                fallbacks: Vec::new(),
+                write_policy: RemoteCacheWritePolicy::WriteFirst,
+            }),
+            ..CacheOpts::default()
+        };
+        let api_client = APIClient::new(format!("http://localhost:{}", port), 200, "2.0.0", true)?;
+
+        // Upload the artifact from a machine without a local cache
+        let remote_cache = AsyncCache::new(
+            &opts(true),
+            &repo_root_path,
+            api_client.clone(),
+            api_auth.clone(),
+            None,
+        )?;
+        remote_cache
+            .put(
+                repo_root_path.clone(),
+                hash.clone(),
+                test_case
+                    .files
+                    .iter()
+                    .map(|f| f.path().to_owned())
+                    .collect(),
+                test_case.duration,
+            )
+            .await?;
+        remote_cache.shutdown().await?;
+
+        let async_cache =
+            AsyncCache::new(&opts(false), &repo_root_path, api_client, api_auth, None)?;
+        let prefetched = async_cache.prefetch(&hash).await?;
+        assert_matches!(
+            prefetched,
+            Some(CacheHitMetadata {
+                source: CacheSource::Remote,
+                ..
+            })
+        );
+        // The artifact is only downloaded once
+        let prefetched = async_cache.prefetch(&hash).await?;
+        assert_matches!(
+            prefetched,
+            Some(CacheHitMetadata {
+                source: CacheSource::Local,
+                ..
+            })
+        );
+        // Concurrent prefetches wait for the first download instead of starting
+        // their own
+        let other_cache = AsyncCache::new(
+            &CacheOpts {
+                override_dir: Some(Utf8PathBuf::from("other-cache")),
+                ..opts(false)
+            },
+            &repo_root_path,
+            APIClient::new(format!("http://localhost:{}", port), 200, "2.0.0", true)?,
+            api_auth.clone(),
+            None,
+        )?;
+        let (first, second) =
+            tokio::join!(other_cache.prefetch(&hash), other_cache.prefetch(&hash));
+        let mut sources = [first?, second?].map(|hit| hit.expect("prefetch should hit").source);
+        sources.sort_by_key(|source| *source == CacheSource::Local);
+        assert_eq!(sources, [CacheSource::Remote, CacheSource::Local]);
+        other_cache.shutdown().await?;
+        assert!(async_cache.prefetch("missing").await?.is_none());
+
+        async_cache.shutdown().await?;
+        handle.abort();
+        Ok(())
+    }
+
+    #[tokio::test]
+    async fn test_exists_all() -> Result<()> {
+        let port = port_scanner::request_open_port().unwrap();
+        let handle = tokio::spawn(start_test_server(port));
+
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPathBuf::try_from(repo_root.path())?;
+        let test_case = &get_test_cases()[0];
+        test_case.initialize(&repo_root_path)?;
+
+        let hit = format!("{}-exists-all", test_case.hash);
+        let miss = format!("{}-exists-all-miss", test_case.hash);
+        let opts = CacheOpts {
+            skip_filesystem: true,
+            workers: 10,
+            remote_cache_opts: Some(RemoteCacheOpts {
+                unused_team_id: Some("my-team".to_string()),
+                signature: false,
+                custom_provider: None,
+                upload_chunk_size: None,
+                fallbacks: Vec::new(),
+                write_policy: RemoteCacheWritePolicy::WriteFirst,
+            }),
+            ..CacheOpts::default()
+        };
+        let api_client = APIClient::new(format!("http://localhost:{}", port), 200, "2.0.0", true)?;
+        let api_auth = Some(APIAuth {
+            team_id: Some("my-team-id".to_string()),
+            token: "my-token".to_string(),
+            team_slug: None,
+        });
+        let async_cache = AsyncCache::new(&opts, &repo_root_path, api_client, api_auth, None)?;
+        let files: Vec<_> = test_case
+            .files
+            .iter()
+            .map(|f| f.path().to_owned())
+            .collect();
+
+        async_cache
+            .put(
+                repo_root_path.clone(),
+                hit.clone(),
+                files.clone(),
+                test_case.duration,
+            )
+            .await?;
+        async_cache.wait().await?;
+
+        let mut statuses = async_cache.exists_all([hit.clone(), miss.clone()]).await;
+        assert_matches!(
+            statuses.remove(&hit),
+            Some(Ok(Some(CacheHitMetadata {
+                source: CacheSource::Remote,
+                ..
+            })))
+        );
+        assert_matches!(statuses.remove(&miss), Some(Ok(None)));
+        assert!(async_cache.fetch(&repo_root_path, &miss).await?.is_none());
+
+        // Writing an artifact that was missing makes it visible again
+        async_cache
+            .put(
+                repo_root_path.clone(),
+                miss.clone(),
+                files,
+                test_case.duration,
+            )
+            .await?;
+        async_cache.wait().await?;
+        assert!(async_cache.exists(&miss).await?.is_some());
+
+        async_cache.shutdown().await?;
+        handle.abort();
+        Ok(())
+    }
+
+    #[tokio::test]
+    async fn test_cache_state() -> Result<()> {
+        let port = port_scanner::request_open_port().unwrap();
+        let handle = tokio::spawn(start_test_server(port));
+
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPathBuf::try_from(repo_root.path())?;
+        let test_case = &get_test_cases()[0];
+        test_case.initialize(&repo_root_path)?;
+
+        let hit = format!("{}-cache-state", test_case.hash);
+        let miss = format!("{}-cache-state-miss", test_case.hash);
+        let opts = CacheOpts {
+            workers: 10,
+            remote_cache_opts: Some(RemoteCacheOpts {
+                unused_team_id: Some("my-team".to_string()),
+                signature: false,
+                custom_provider: None,
+                upload_chunk_size: None,
+                fallbacks: Vec::new(),
+                write_policy: RemoteCacheWritePolicy::WriteFirst,
+            }),
+            ..CacheOpts::default()
+        };
+        let api_client = APIClient::new(format!("http://localhost:{}", port), 200, "2.0.0", true)?;
+        let api_auth = Some(APIAuth {
+            team_id: Some("my-team-id".to_string()),
+            token: "my-token".to_string(),
+            team_slug: None,
+        });
+        let async_cache = AsyncCache::new(&opts, &repo_root_path, api_client, api_auth, None)?;
+        let files: Vec<_> = test_case
+            .files
+            .iter()
+            .map(|f| f.path().to_owned())
+            .collect();
+
+        async_cache
+            .put(
+                repo_root_path.clone(),
+                hit.clone(),
+                files,
+                test_case.duration,
+            )
+            .await?;
+        async_cache.wait().await?;
+
+        // `exists` stops at the local hit, but both caches have the artifact
+        let state = async_cache.cache_state(&hit).await?;
+        assert_matches!(
+            state.local,
+            Some(CacheHitMetadata {
+                source: CacheSource::Local,
+                ..
+            })
+        );
+        assert_matches!(
+            state.remote,
+            Some(CacheHitMetadata {
+                source: CacheSource::Remote,
+                ..
+            })
+        );
+        assert_eq!(state.hit(), state.local);
+        assert_eq!(async_cache.cache_state(&miss).await?, CacheState::default());
+
+        async_cache.shutdown().await?;
+        handle.abort();
+        Ok(())
+    }
+
+    #[tokio::test]
+    async fn test_skip_large_uploads() -> Result<()> {
+        let port = port_scanner::request_open_port().unwrap();
+        let handle = tokio::spawn(start_test_server(port));
+
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPathBuf::try_from(repo_root.path())?;
+        let test_case = &get_test_cases()[0];
+        test_case.initialize(&repo_root_path)?;
+
+        let hash = format!("{}-large", test_case.hash);
+        let opts = CacheOpts {
+            workers: 10,
+            max_artifact_size: Some(1),
+            remote_cache_opts: Some(RemoteCacheOpts {
+                unused_team_id: Some("my-team".to_string()),
+                signature: false,
+                custom_provider: None,
+                upload_chunk_size: None,
+                fallbacks: Vec::new(),
+                write_policy: RemoteCacheWritePolicy::WriteFirst,
+            }),
+            ..CacheOpts::default()
+        };
+        let api_client = APIClient::new(format!("http://localhost:{}", port), 200, "2.0.0", true)?;
+        let api_auth = Some(APIAuth {
+            team_id: Some("my-team-id".to_string()),
+            token: "my-token".to_string(),
+            team_slug: None,
+        });
+        let async_cache = AsyncCache::new(&opts, &repo_root_path, api_client, api_auth, None)?;
+
+        async_cache
+            .put(
+                repo_root_path.clone(),
+                hash.clone(),
+                test_case
+                    .files
+                    .iter()
+                    .map(|f| f.path().to_owned())
+                    .collect(),
+                test_case.duration,
+            )
+            .await?;
+        async_cache.wait().await?;
+
+        // The artifact is still written to the local cache
+        assert!(async_cache.skipped_upload(&hash).is_some());
+        assert!(async_cache.upload_metadata(&hash).is_none());
+        assert_matches!(
+            async_cache.exists(&hash).await?,
+            Some(CacheHitMetadata {
+                source: CacheSource::Local,
+                ..
+            })
+        );
+
+        async_cache.shutdown().await?;
+        handle.abort();
+        Ok(())
+    }
+
+    #[tokio::test]
+    async fn test_resume_interrupted_writes() -> Result<()> {
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPathBuf::try_from(repo_root.path())?;
+        let test_case = &get_test_cases()[0];
+        test_case.initialize(&repo_root_path)?;
+
+        // A run that was interrupted after queueing its write
+        let files = test_case
+            .files
+            .iter()
+            .map(|f| f.path().to_owned())
+            .collect::<Vec<_>>();
+        WriteJournal::new(&repo_root_path).record(
+            &repo_root_path,
+            test_case.hash,
+            test_case.duration,
+            &files,
+        );
+
+        let opts = CacheOpts {
+            skip_remote: true,
+            workers: 10,
+            ..CacheOpts::default()
+        };
+        let api_client = APIClient::new("http://localhost:1", 200, "2.0.0", true)?;
+        let async_cache = AsyncCache::new(&opts, &repo_root_path, api_client, None, None)?;
+        assert!(async_cache.exists(test_case.hash).await?.is_none());
+
+        assert_eq!(async_cache.resume_interrupted_writes().await?, 1);
+        assert!(async_cache.exists(test_case.hash).await?.is_some());
+        // Finished writes are removed from the journal
+        assert_eq!(async_cache.resume_interrupted_writes().await?, 0);
+
+        async_cache.shutdown().await?;
+        Ok(())
+    }
+
+    #[tokio::test]
+    async fn test_drain_timeout() -> Result<()> {
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPathBuf::try_from(repo_root.path())?;
+        let test_case = &get_test_cases()[0];
+        test_case.initialize(&repo_root_path)?;
+
+        let opts = CacheOpts {
+            skip_remote: true,
+            workers: 10,
+            drain_timeout: Some(Duration::ZERO),
+            ..CacheOpts::default()
+        };
+        let api_client = APIClient::new("http://localhost:1", 200, "2.0.0", true)?;
+        let async_cache = AsyncCache::new(&opts, &repo_root_path, api_client, None, None)?;
+
+        async_cache
+            .put(
+                repo_root_path.clone(),
+                test_case.hash.to_string(),
+                test_case
+                    .files
+                    .iter()
+                    .map(|f| f.path().to_owned())
+                    .collect(),
+                test_case.duration,
+            )
+            .await?;
+        assert_eq!(async_cache.pending_writes(), 1);
+
+        // The write hasn't started by the time the cache shuts down, so it's
+        // cancelled and left for the next run to resume
+        async_cache.shutdown().await?;
+        assert_eq!(async_cache.pending_writes(), 0);
+        assert_eq!(WriteJournal::new(&repo_root_path).pending().len(), 1);
+        Ok(())
+    }
+
+    #[tokio::test]
+    async fn test_write_only() -> Result<()> {
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPathBuf::try_from(repo_root.path())?;
+        let test_case = &get_test_cases()[0];
+        test_case.initialize(&repo_root_path)?;
+
+        let opts = CacheOpts {
+            skip_remote: true,
+            write_only: true,
+            workers: 10,
+            ..CacheOpts::default()
+        };
+        let api_client = APIClient::new("http://localhost:1", 200, "2.0.0", true)?;
+        let async_cache = AsyncCache::new(&opts, &repo_root_path, api_client, None, None)?;
+
+        async_cache
+            .put(
+                repo_root_path.clone(),
+                test_case.hash.to_string(),
+                test_case
+                    .files
+                    .iter()
+                    .map(|f| f.path().to_owned())
+                    .collect(),
+                test_case.duration,
+            )
+            .await?;
+        async_cache.wait().await?;
+
+        // The artifact is written, but never read back
+        let fs_cache_path = repo_root_path.join_components(&[
+            "node_modules",
+            ".cache",
+            "turbo",
+            &format!("{}.tar.zst", test_case.hash),
+        ]);
+        assert!(fs_cache_path.exists());
+        assert!(async_cache.exists(test_case.hash).await?.is_none());
+        assert!(async_cache
+            .fetch(&repo_root_path, test_case.hash)
+            .await?
+            .is_none());
+
+        async_cache.shutdown().await?;
+        Ok(())
+    }
+
+    #[tokio::test]
+    async fn test_cache_namespaces() -> Result<()> {
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPathBuf::try_from(repo_root.path())?;
+        let test_case = &get_test_cases()[0];
+        test_case.initialize(&repo_root_path)?;
+
+        let namespaced_cache = |namespace: &str| {
+            let opts = CacheOpts {
+                skip_remote: true,
+                workers: 10,
+                namespace: Some(namespace.to_string()),
+                ..CacheOpts::default()
+            };
+            let api_client = APIClient::new("http://localhost:1", 200, "2.0.0", true)?;
+            AsyncCache::new(&opts, &repo_root_path, api_client, None, None)
+        };
+        let main_cache = namespaced_cache("main")?;
+        let feature_cache = namespaced_cache("feature/new-cache")?;
+
+        main_cache
+            .put(
+                repo_root_path.clone(),
+                test_case.hash.to_string(),
+                test_case
+                    .files
+                    .iter()
+                    .map(|f| f.path().to_owned())
+                    .collect(),
+                test_case.duration,
+            )
+            .await?;
+        main_cache.wait().await?;
+
+        // Artifacts are stored under the namespace so they can be purged together
+        let fs_cache_path = repo_root_path.join_components(&[
+            "node_modules",
+            ".cache",
+            "turbo",
+            &format!("main-{}.tar.zst", test_case.hash),
+        ]);
+        assert!(fs_cache_path.exists());
+
+        assert!(main_cache.exists(test_case.hash).await?.is_some());
+        assert!(feature_cache.exists(test_case.hash).await?.is_none());
+
+        main_cache.shutdown().await?;
+        feature_cache.shutdown().await?;
+        Ok(())
+    }
+
     async fn round_trip_test_without_fs(test_case: &TestCase, port: u16) -> Result<()> {
         let repo_root = tempdir()?;
         let repo_root_path = AbsoluteSystemPathBuf::try_from(repo_root.path())?;
@@ -226,12 +1090,28 @@ mod tests {
         let opts = CacheOpts {
             override_dir: None,
             remote_cache_read_only: false,
+            write_only: false,
             skip_remote: false,
             skip_filesystem: true,
             workers: 10,
+            queue_size: 1,
+            drain_timeout: None,
+            dereference_symlinks: false,
+            restore_mode: RestoreMode::Hardlink,
+            show_all_warnings: false,
+            namespace: None,
+            max_age: None,
+            remote_read_concurrency: None,
+            remote_write_concurrency: None,
+            max_artifact_size: None,
+            provenance: None,
             remote_cache_opts: Some(RemoteCacheOpts {
                 unused_team_id: Some("my-team".to_string()),
                 signature: false,
+                custom_provider: None,
+                upload_chunk_size: None,
+                fallbacks: Vec::new(),
+                write_policy: RemoteCacheWritePolicy::WriteFirst,
             }),
         };
 
@@ -306,12 +1186,28 @@ mod tests {
         let opts = CacheOpts {
             override_dir: None,
             remote_cache_read_only: false,
+            write_only: false,
             skip_remote: true,
             skip_filesystem: false,
             workers: 10,
+            queue_size: 1,
+            drain_timeout: None,
+            dereference_symlinks: false,
+            restore_mode: RestoreMode::Hardlink,
+            show_all_warnings: false,
+            namespace: None,
+            max_age: None,
+            remote_read_concurrency: None,
+            remote_write_concurrency: None,
+            max_artifact_size: None,
+            provenance: None,
             remote_cache_opts: Some(RemoteCacheOpts {
                 unused_team_id: Some("my-team".to_string()),
                 signature: false,
+                custom_provider: None,
+                upload_chunk_size: None,
+                fallbacks: Vec::new(),
+                write_policy: RemoteCacheWritePolicy::WriteFirst,
             }),
         };
 
@@ -396,12 +1292,28 @@ mod tests {
         let opts = CacheOpts {
             override_dir: None,
             remote_cache_read_only: false,
+            write_only: false,
             skip_remote: false,
             skip_filesystem: false,
             workers: 10,
+            queue_size: 1,
+            drain_timeout: None,
+            dereference_symlinks: false,
+            restore_mode: RestoreMode::Hardlink,
+            show_all_warnings: false,
+            namespace: None,
+            max_age: None,
+            remote_read_concurrency: None,
+            remote_write_concurrency: None,
+            max_artifact_size: None,
+            provenance: None,
             remote_cache_opts: Some(RemoteCacheOpts {
                 unused_team_id: Some("my-team".to_string()),
                 signature: false,
+                custom_provider: None,
+                upload_chunk_size: None,
+                fallbacks: Vec::new(),
+                write_policy: RemoteCacheWritePolicy::WriteFirst,
             }),
         };
 
@@ -0,0 +1,265 @@
+use std::{
+    backtrace::Backtrace,
+    io::{Read, Write},
+};
+
+use serde::{Deserialize, Serialize};
+use tar::{EntryType, Header};
+
+use crate::{
+    fs::{ArchivedArtifact, FSCache},
+    is_valid_hash, CacheError, Provenance,
+};
+
+// The first entry of every bundle
+const VERSION_PATH: &str = "turbo-cache-bundle.json";
+const BUNDLE_VERSION: u32 = 1;
+
+#[derive(Debug, Serialize, Deserialize)]
+struct BundleVersion {
+    version: u32,
+}
+
+/// An artifact in a bundle. Each one is stored as `<hash>.json` followed by
+/// `<hash>.tar.zst`, the artifact in the format used by the remote cache, so
+/// that bundles can be written and read without holding every artifact in
+/// memory.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct BundledArtifact {
+    pub hash: String,
+    pub duration: u64,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub tag: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub provenance: Option<Provenance>,
+    // The compressed size of the artifact
+    pub size: u64,
+}
+
+/// The artifacts read from a bundle. Artifacts that were already in the cache
+/// are skipped rather than overwritten.
+#[derive(Debug, Default, Clone, PartialEq, Eq, Serialize)]
+pub struct ImportedArtifacts {
+    pub imported: Vec<String>,
+    pub skipped: Vec<String>,
+}
+
+/// Writes the given artifacts from the local cache to a tarball that can be
+/// imported into the cache of another machine, e.g. one that can't reach the
+/// remote cache. Hashes that aren't in the cache are returned as an error.
+pub fn export(
+    cache: &FSCache,
+    hashes: &[String],
+    writer: impl Write,
+) -> Result<Vec<BundledArtifact>, CacheError> {
+    let mut builder = tar::Builder::new(writer);
+    append(
+        &mut builder,
+        VERSION_PATH,
+        &serialize(&BundleVersion {
+            version: BUNDLE_VERSION,
+        })?,
+    )?;
+
+    let mut exported = Vec::with_capacity(hashes.len());
+    for hash in hashes {
+        let archive = cache
+            .export_archive(hash)?
+            .ok_or_else(|| CacheError::ArtifactNotFound(hash.clone(), Backtrace::capture()))?;
+        let artifact = BundledArtifact {
+            hash: hash.clone(),
+            duration: archive.duration,
+            tag: archive.tag,
+            provenance: archive.provenance,
+            size: archive.body.len() as u64,
+        };
+        append(
+            &mut builder,
+            &format!("{hash}.json"),
+            &serialize(&artifact)?,
+        )?;
+        append(&mut builder, &format!("{hash}.tar.zst"), &archive.body)?;
+        exported.push(artifact);
+    }
+    builder.into_inner()?.flush()?;
+
+    Ok(exported)
+}
+
+/// Reads a tarball written by `export` into the local cache
+pub fn import(cache: &FSCache, reader: impl Read) -> Result<ImportedArtifacts, CacheError> {
+    let mut archive = tar::Archive::new(reader);
+    let mut entries = archive.entries()?;
+
+    let version = match entries.next() {
+        Some(entry) => {
+            let mut entry = entry?;
+            if entry.path_bytes().as_ref() != VERSION_PATH.as_bytes() {
+                return Err(invalid_bundle("missing bundle version"));
+            }
+            deserialize::<BundleVersion>(&mut entry)?.version
+        }
+        None => return Err(invalid_bundle("bundle is empty")),
+    };
+    if version != BUNDLE_VERSION {
+        return Err(invalid_bundle(&format!(
+            "unsupported bundle version {version}"
+        )));
+    }
+
+    let mut imported = ImportedArtifacts::default();
+    let mut pending: Option<BundledArtifact> = None;
+    for entry in entries {
+        let mut entry = entry?;
+        let path = String::from_utf8_lossy(&entry.path_bytes()).into_owned();
+        match (path.strip_suffix(".json"), path.strip_suffix(".tar.zst")) {
+            (Some(hash), _) => {
+                let artifact = deserialize::<BundledArtifact>(&mut entry)?;
+                // Hashes become file names in the cache directory
+                if artifact.hash != hash || !is_valid_hash(hash) {
+                    return Err(invalid_bundle(&format!("invalid artifact {path}")));
+                }
+                pending = Some(artifact);
+            }
+            (_, Some(hash)) => {
+                let artifact = pending
+                    .take()
+                    .filter(|artifact| artifact.hash == hash)
+                    .ok_or_else(|| invalid_bundle(&format!("missing metadata for {path}")))?;
+                if cache.exists(hash)?.is_some() {
+                    imported.skipped.push(artifact.hash);
+                    continue;
+                }
+                let mut body = Vec::with_capacity(artifact.size as usize);
+                entry.read_to_end(&mut body)?;
+                cache.put_archive(
+                    hash,
+                    &ArchivedArtifact {
+                        body,
+                        duration: artifact.duration,
+                        tag: artifact.tag,
+                        provenance: artifact.provenance,
+                    },
+                )?;
+                imported.imported.push(artifact.hash);
+            }
+            _ => return Err(invalid_bundle(&format!("unexpected entry {path}"))),
+        }
+    }
+
+    Ok(imported)
+}
+
+fn append(
+    builder: &mut tar::Builder<impl Write>,
+    path: &str,
+    contents: &[u8],
+) -> Result<(), CacheError> {
+    let mut header = Header::new_ustar();
+    header.set_entry_type(EntryType::Regular);
+    header.set_size(contents.len() as u64);
+    header.set_mode(0o644);
+    builder.append_data(&mut header, path, contents)?;
+    Ok(())
+}
+
+fn serialize(value: &impl Serialize) -> Result<Vec<u8>, CacheError> {
+    serde_json::to_vec(value)
+        .map_err(|err| CacheError::MetadataWriteFailure(err, Backtrace::capture()))
+}
+
+fn deserialize<T: for<'de> Deserialize<'de>>(reader: impl Read) -> Result<T, CacheError> {
+    serde_json::from_reader(reader)
+        .map_err(|err| CacheError::InvalidMetadata(err, Backtrace::capture()))
+}
+
+fn invalid_bundle(reason: &str) -> CacheError {
+    CacheError::InvalidBundle(reason.to_string(), Backtrace::capture())
+}
+
+#[cfg(test)]
+mod test {
+    use anyhow::Result;
+    use tempfile::tempdir;
+    use turbopath::AbsoluteSystemPath;
+
+    use super::*;
+    use crate::test_cases::get_test_cases;
+
+    #[test]
+    fn test_round_trip() -> Result<()> {
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPath::from_std_path(repo_root.path())?;
+        let test_case = &get_test_cases()[2];
+        test_case.initialize(repo_root_path)?;
+        let files: Vec<_> = test_case
+            .files
+            .iter()
+            .map(|f| f.path().to_owned())
+            .collect();
+        let cache = FSCache::new(None, repo_root_path, None)?;
+        cache.put(repo_root_path, test_case.hash, &files, test_case.duration)?;
+
+        let mut bundle = Vec::new();
+        let exported = export(&cache, &[test_case.hash.to_string()], &mut bundle)?;
+        assert_eq!(exported.len(), 1);
+        assert_eq!(exported[0].duration, test_case.duration);
+
+        let other_root = tempdir()?;
+        let other_root_path = AbsoluteSystemPath::from_std_path(other_root.path())?;
+        let other_cache = FSCache::new(None, other_root_path, None)?;
+        let imported = import(&other_cache, bundle.as_slice())?;
+        assert_eq!(imported.imported, vec![test_case.hash.to_string()]);
+
+        // Files kept in the blob store are included in the bundle
+        let (metadata, _) = other_cache
+            .fetch(other_root_path, test_case.hash)?
+            .expect("artifact should be restored");
+        assert_eq!(metadata.time_saved, test_case.duration);
+        for file in &test_case.files {
+            if let Some(contents) = file.contents() {
+                assert_eq!(
+                    other_root_path.resolve(file.path()).read_to_string()?,
+                    contents
+                );
+            }
+        }
+
+        let imported = import(&other_cache, bundle.as_slice())?;
+        assert_eq!(imported.skipped, vec![test_case.hash.to_string()]);
+        Ok(())
+    }
+
+    #[test]
+    fn test_missing_artifact() -> Result<()> {
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPath::from_std_path(repo_root.path())?;
+        let cache = FSCache::new(None, repo_root_path, None)?;
+
+        let result = export(&cache, &["missing".to_string()], Vec::new());
+
+        assert!(matches!(result, Err(CacheError::ArtifactNotFound(..))));
+        Ok(())
+    }
+
+    #[test]
+    fn test_rejects_invalid_hash() -> Result<()> {
+        let mut builder = tar::Builder::new(Vec::new());
+        append(&mut builder, VERSION_PATH, br#"{"version":1}"#)?;
+        append(
+            &mut builder,
+            ".hidden.json",
+            br#"{"hash":".hidden","duration":0,"size":0}"#,
+        )?;
+        let bundle = builder.into_inner()?;
+
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPath::from_std_path(repo_root.path())?;
+        let cache = FSCache::new(None, repo_root_path, None)?;
+        let result = import(&cache, bundle.as_slice());
+
+        assert!(matches!(result, Err(CacheError::InvalidBundle(..))));
+        Ok(())
+    }
+}
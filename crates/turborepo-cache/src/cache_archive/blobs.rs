@@ -0,0 +1,400 @@
+use std::{
+    backtrace::Backtrace,
+    collections::HashSet,
+    fs, io,
+    io::Read,
+    sync::{
+        atomic::{AtomicBool, AtomicUsize, Ordering},
+        Arc,
+    },
+};
+
+use sha2::{Digest, Sha256};
+use tracing::debug;
+use turbopath::{AbsoluteSystemPath, AbsoluteSystemPathBuf};
+
+use crate::{CacheError, RestoreMode};
+
+// Archive entries whose contents are in a blob store have no data of their
+// own. Instead these pax extensions record the blob's digest and size.
+pub(crate) const DIGEST_PAX_KEY: &str = "TURBO.blob.digest";
+pub(crate) const SIZE_PAX_KEY: &str = "TURBO.blob.size";
+
+// Distinguishes the temporary files of concurrent writes of the same blob
+static TEMP_FILE_COUNTER: AtomicUsize = AtomicUsize::new(0);
+
+/// Stores file contents by their SHA-256 digest so that a file that appears in
+/// many artifacts is only stored once. Artifacts reference blobs by digest and
+/// restoring a blob hard links it into place when possible, unless another
+/// restore mode is set.
+#[derive(Debug, Clone)]
+pub struct BlobStore {
+    root: AbsoluteSystemPathBuf,
+    restore_mode: RestoreMode,
+    // Cleared by the first reflink that fails, so that file systems without
+    // copy-on-write support don't pay for a failed clone on every file
+    reflinks_supported: Arc<AtomicBool>,
+}
+
+impl BlobStore {
+    pub fn new(root: AbsoluteSystemPathBuf) -> Self {
+        Self {
+            root,
+            restore_mode: RestoreMode::default(),
+            reflinks_supported: Arc::new(AtomicBool::new(true)),
+        }
+    }
+
+    pub fn with_restore_mode(mut self, restore_mode: RestoreMode) -> Self {
+        self.restore_mode = restore_mode;
+        self
+    }
+
+    pub fn root(&self) -> &AbsoluteSystemPath {
+        &self.root
+    }
+
+    fn path(&self, digest: &str) -> AbsoluteSystemPathBuf {
+        // Blobs are sharded by the first byte of their digest to keep
+        // directories small
+        self.root.join_components(&[&digest[..2], digest])
+    }
+
+    /// Adds the contents of `source` to the store, returning its digest. The
+    /// blob keeps the permissions of the first file stored with its contents.
+    pub fn add(&self, source: &AbsoluteSystemPath) -> Result<String, CacheError> {
+        let digest = hash_file(source)?;
+        let path = self.path(&digest);
+        if path.exists() {
+            return Ok(digest);
+        }
+
+        path.ensure_dir()?;
+        // Blobs are written to a temporary file and renamed into place so that
+        // a concurrent restore never sees a partially written blob
+        let temp_path = self.root.join_component(&format!(
+            "{}.{}.{}.tmp",
+            digest,
+            std::process::id(),
+            TEMP_FILE_COUNTER.fetch_add(1, Ordering::Relaxed)
+        ));
+        self.copy(source, &temp_path)?;
+        temp_path.rename(&path)?;
+
+        Ok(digest)
+    }
+
+    /// Restores the blob to `target` according to the restore mode. Hard links
+    /// share their contents, so an output that was modified in place after
+    /// being restored also modifies the blob. The blob isn't hashed again here,
+    /// callers are expected to have checked it with `verify` first.
+    pub fn restore(
+        &self,
+        digest: &str,
+        mode: u32,
+        target: &AbsoluteSystemPath,
+    ) -> Result<(), CacheError> {
+        if !is_digest(digest) {
+            return Err(CacheError::MalformedTar(Backtrace::capture()));
+        }
+        let path = self.path(digest);
+
+        match target.remove_file() {
+            Ok(()) => (),
+            Err(e) if e.kind() == io::ErrorKind::NotFound => (),
+            Err(e) => return Err(e.into()),
+        }
+
+        match self.restore_mode {
+            // A hard link shares the blob's permissions, so a file with different
+            // permissions gets a copy instead. Copies are also needed when the
+            // cache is on a different file system than the repository.
+            RestoreMode::Hardlink
+                if has_mode(&path, mode)? && fs::hard_link(&path, target).is_ok() =>
+            {
+                return Ok(())
+            }
+            _ => self.copy(&path, target)?,
+        }
+        #[cfg(unix)]
+        target.set_mode(mode)?;
+
+        Ok(())
+    }
+
+    /// Reads the contents of a blob, verifying that they still match its
+    /// digest
+    pub fn read(&self, digest: &str) -> Result<Vec<u8>, CacheError> {
+        if !is_digest(digest) {
+            return Err(CacheError::MalformedTar(Backtrace::capture()));
+        }
+        let contents = fs::read(self.path(digest))?;
+        if hex::encode(Sha256::digest(&contents)) != digest {
+            return Err(CacheError::CorruptBlob(
+                digest.to_string(),
+                Backtrace::capture(),
+            ));
+        }
+
+        Ok(contents)
+    }
+
+    /// Checks that a blob exists and still matches its digest. A blob that
+    /// doesn't is removed so that it's written again by the next artifact
+    /// that contains its contents.
+    pub fn verify(&self, digest: &str) -> Result<bool, CacheError> {
+        if !is_digest(digest) {
+            return Ok(false);
+        }
+        let path = self.path(digest);
+        let actual = match hash_file(&path) {
+            Ok(actual) => actual,
+            Err(CacheError::IO(e, _)) if e.kind() == io::ErrorKind::NotFound => return Ok(false),
+            Err(e) => return Err(e),
+        };
+        if actual != digest {
+            let _ = path.remove_file();
+            return Ok(false);
+        }
+
+        Ok(true)
+    }
+
+    /// Removes every blob that isn't in `referenced`, returning the number of
+    /// bytes removed
+    pub fn remove_unreferenced(&self, referenced: &HashSet<String>) -> Result<u64, CacheError> {
+        let shards = match fs::read_dir(&self.root) {
+            Ok(shards) => shards,
+            Err(e) if e.kind() == io::ErrorKind::NotFound => return Ok(0),
+            Err(e) => return Err(e.into()),
+        };
+
+        let mut removed_bytes = 0;
+        for shard in shards {
+            let shard = shard?;
+            if !shard.file_type()?.is_dir() {
+                continue;
+            }
+            for blob in fs::read_dir(shard.path())? {
+                let blob = blob?;
+                if blob
+                    .file_name()
+                    .to_str()
+                    .map_or(false, |digest| referenced.contains(digest))
+                {
+                    continue;
+                }
+                let Ok(metadata) = blob.metadata() else {
+                    continue;
+                };
+                match fs::remove_file(blob.path()) {
+                    Ok(()) => removed_bytes += metadata.len(),
+                    Err(e) if e.kind() == io::ErrorKind::NotFound => (),
+                    Err(e) => return Err(e.into()),
+                }
+            }
+        }
+
+        Ok(removed_bytes)
+    }
+}
+
+/// Reads the digest and size of the blob that holds an archive entry's
+/// contents, if they're stored in a blob store
+pub(crate) fn blob_reference(
+    entry: &mut tar::Entry<impl Read>,
+) -> Result<Option<(String, u64)>, CacheError> {
+    let Some(extensions) = entry.pax_extensions()? else {
+        return Ok(None);
+    };
+
+    let mut digest = None;
+    let mut size = 0;
+    for extension in extensions {
+        let extension = extension?;
+        match (extension.key(), extension.value()) {
+            (Ok(DIGEST_PAX_KEY), Ok(value)) => digest = Some(value.to_string()),
+            (Ok(SIZE_PAX_KEY), Ok(value)) => size = value.parse().unwrap_or_default(),
+            _ => (),
+        }
+    }
+
+    Ok(digest.map(|digest| (digest, size)))
+}
+
+// Digests come from archives so they're validated before being used as paths
+fn is_digest(digest: &str) -> bool {
+    digest.len() == 64 && digest.bytes().all(|byte| byte.is_ascii_hexdigit())
+}
+
+impl BlobStore {
+    // Copies a file, cloning it instead when reflinks are enabled and the file
+    // system supports them
+    fn copy(&self, source: &AbsoluteSystemPath, target: &AbsoluteSystemPath) -> io::Result<()> {
+        if self.restore_mode == RestoreMode::Reflink
+            && self.reflinks_supported.load(Ordering::Relaxed)
+        {
+            match reflink(source, target) {
+                Ok(()) => return Ok(()),
+                Err(err) => {
+                    debug!("reflinks are unsupported, falling back to copies: {err}");
+                    self.reflinks_supported.store(false, Ordering::Relaxed);
+                }
+            }
+        }
+        fs::copy(source, target).map(|_| ())
+    }
+}
+
+fn hash_file(path: &AbsoluteSystemPath) -> Result<String, CacheError> {
+    let mut hasher = Sha256::new();
+    io::copy(&mut path.open()?, &mut hasher)?;
+    Ok(hex::encode(hasher.finalize()))
+}
+
+// Clones the file with the FICLONE ioctl, which fails on file systems that
+// don't support copy-on-write, e.g. ext4
+#[cfg(target_os = "linux")]
+fn reflink(source: &AbsoluteSystemPath, target: &AbsoluteSystemPath) -> io::Result<()> {
+    use std::os::fd::AsRawFd;
+
+    // _IOW(0x94, 9, int)
+    const FICLONE: u32 = 0x40049409;
+
+    let source = source.open()?;
+    let target = fs::File::create(target)?;
+    // SAFETY: both file descriptors are valid for the duration of the call
+    let result = unsafe { libc::ioctl(target.as_raw_fd(), FICLONE as _, source.as_raw_fd()) };
+    if result == -1 {
+        return Err(io::Error::last_os_error());
+    }
+
+    Ok(())
+}
+
+// The standard library's copy already clones files where the file system
+// supports it, e.g. on APFS
+#[cfg(not(target_os = "linux"))]
+fn reflink(source: &AbsoluteSystemPath, target: &AbsoluteSystemPath) -> io::Result<()> {
+    fs::copy(source, target).map(|_| ())
+}
+
+#[cfg(unix)]
+fn has_mode(path: &AbsoluteSystemPath, mode: u32) -> Result<bool, CacheError> {
+    use std::os::unix::fs::PermissionsExt;
+    let permissions = path.symlink_metadata()?.permissions();
+    Ok(permissions.mode() & 0o7777 == mode & 0o7777)
+}
+
+#[cfg(windows)]
+fn has_mode(_path: &AbsoluteSystemPath, _mode: u32) -> Result<bool, CacheError> {
+    Ok(true)
+}
+
+#[cfg(test)]
+mod test {
+    use anyhow::Result;
+    use tempfile::tempdir;
+    use test_case::test_case;
+
+    use super::*;
+
+    #[test]
+    fn test_deduplicates_and_restores() -> Result<()> {
+        let dir = tempdir()?;
+        let root = AbsoluteSystemPath::from_std_path(dir.path())?;
+        let store = BlobStore::new(root.join_component("blobs"));
+
+        let a = root.join_component("a.js");
+        let b = root.join_component("b.js");
+        a.create_with_contents("same contents")?;
+        b.create_with_contents("same contents")?;
+        #[cfg(unix)]
+        a.set_mode(0o644)?;
+
+        let digest = store.add(&a)?;
+        assert_eq!(store.add(&b)?, digest);
+        assert_eq!(
+            fs::read_dir(store.path(&digest).parent().unwrap())?.count(),
+            1
+        );
+
+        let target = root.join_component("restored.js");
+        store.restore(&digest, 0o644, &target)?;
+        assert_eq!(target.read_to_string()?, "same contents");
+        #[cfg(unix)]
+        {
+            use std::os::unix::fs::MetadataExt;
+            // The restored file is a hard link to the blob
+            assert_eq!(target.symlink_metadata()?.nlink(), 2);
+        }
+
+        Ok(())
+    }
+
+    #[test_case(RestoreMode::Copy ; "copy")]
+    #[test_case(RestoreMode::Reflink ; "reflink")]
+    fn test_restore_without_hard_link(restore_mode: RestoreMode) -> Result<()> {
+        let dir = tempdir()?;
+        let root = AbsoluteSystemPath::from_std_path(dir.path())?;
+        let store = BlobStore::new(root.join_component("blobs")).with_restore_mode(restore_mode);
+
+        let source = root.join_component("index.js");
+        source.create_with_contents("original")?;
+        #[cfg(unix)]
+        source.set_mode(0o644)?;
+        let digest = store.add(&source)?;
+
+        let target = root.join_component("restored.js");
+        store.restore(&digest, 0o644, &target)?;
+        assert_eq!(target.read_to_string()?, "original");
+
+        // Modifying the restored output leaves the blob intact
+        target.create_with_contents("modified")?;
+        assert!(store.verify(&digest)?);
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_corrupt_blob() -> Result<()> {
+        let dir = tempdir()?;
+        let root = AbsoluteSystemPath::from_std_path(dir.path())?;
+        let store = BlobStore::new(root.join_component("blobs"));
+
+        let source = root.join_component("index.js");
+        source.create_with_contents("original")?;
+        let digest = store.add(&source)?;
+        assert!(store.verify(&digest)?);
+        // Simulates an output that was written in place after being hard linked
+        store.path(&digest).create_with_contents("modified")?;
+
+        assert!(!store.verify(&digest)?);
+        assert!(!store.path(&digest).exists());
+        assert!(!store.verify(&digest)?);
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_remove_unreferenced() -> Result<()> {
+        let dir = tempdir()?;
+        let root = AbsoluteSystemPath::from_std_path(dir.path())?;
+        let store = BlobStore::new(root.join_component("blobs"));
+
+        let kept = root.join_component("kept.js");
+        let removed = root.join_component("removed.js");
+        kept.create_with_contents("kept")?;
+        removed.create_with_contents("removed")?;
+        let kept_digest = store.add(&kept)?;
+        let removed_digest = store.add(&removed)?;
+
+        let removed_bytes = store.remove_unreferenced(&HashSet::from([kept_digest.clone()]))?;
+
+        assert_eq!(removed_bytes, "removed".len() as u64);
+        assert!(store.path(&kept_digest).exists());
+        assert!(!store.path(&removed_digest).exists());
+
+        Ok(())
+    }
+}
@@ -7,17 +7,26 @@ use std::{
 };
 
 use tar::{EntryType, Header};
-use turbopath::{AbsoluteSystemPath, AnchoredSystemPath, IntoUnix};
+use turbopath::{
+    AbsoluteSystemPath, AbsoluteSystemPathBuf, AnchoredSystemPath, IntoUnix, PathError,
+};
 
-use crate::CacheError;
+use crate::{
+    cache_archive::blobs::{BlobStore, DIGEST_PAX_KEY, SIZE_PAX_KEY},
+    CacheError,
+};
 
 pub struct CacheWriter<'a> {
     builder: tar::Builder<Box<dyn Write + 'a>>,
+    // Whether symlinks are replaced with the files they point to
+    dereference_symlinks: bool,
+    // Where the contents of regular files are stored if not in the archive
+    blob_store: Option<BlobStore>,
 }
 
 impl<'a> CacheWriter<'a> {
     // Appends data to tar builder.
-    fn append_data(
+    pub(crate) fn append_data(
         &mut self,
         header: &mut Header,
         path: impl AsRef<Path>,
@@ -26,7 +35,7 @@ impl<'a> CacheWriter<'a> {
         Ok(self.builder.append_data(header, path, body)?)
     }
 
-    fn append_link(
+    pub(crate) fn append_link(
         &mut self,
         header: &mut Header,
         path: impl AsRef<Path>,
@@ -35,6 +44,43 @@ impl<'a> CacheWriter<'a> {
         Ok(self.builder.append_link(header, path, target)?)
     }
 
+    // Symlinks are preserved by default. Dereferencing them stores the file or
+    // directory they point to instead, which is needed when the link target
+    // won't exist where the artifact is restored, e.g. pnpm's virtual store.
+    pub fn dereference_symlinks(mut self, dereference_symlinks: bool) -> Self {
+        self.dereference_symlinks = dereference_symlinks;
+        self
+    }
+
+    // Stores the contents of regular files in the blob store instead of the
+    // archive, which leaves the archive as a manifest of the artifact's entries.
+    pub fn with_blob_store(mut self, blob_store: BlobStore) -> Self {
+        self.blob_store = Some(blob_store);
+        self
+    }
+
+    // Writes a pax extended header that applies to the entry that follows it
+    fn append_pax_extensions(&mut self, records: &[(&str, &str)]) -> Result<(), CacheError> {
+        let mut data = String::new();
+        for (key, value) in records {
+            // Each record is prefixed with its length, which includes the
+            // digits of the length itself
+            let record = format!(" {key}={value}\n");
+            let mut len = record.len();
+            while record.len() + len.to_string().len() != len {
+                len = record.len() + len.to_string().len();
+            }
+            data.push_str(&len.to_string());
+            data.push_str(&record);
+        }
+
+        let mut header = Header::new_ustar();
+        header.set_entry_type(EntryType::XHeader);
+        header.set_size(data.len() as u64);
+        header.set_mode(0o644);
+        self.append_data(&mut header, "PaxHeader", data.as_bytes())
+    }
+
     pub fn finish(mut self) -> Result<(), CacheError> {
         Ok(self.builder.finish()?)
     }
@@ -44,10 +90,14 @@ impl<'a> CacheWriter<'a> {
             let zw = zstd::Encoder::new(writer, 0)?.auto_finish();
             Ok(CacheWriter {
                 builder: tar::Builder::new(Box::new(zw)),
+                dereference_symlinks: false,
+                blob_store: None,
             })
         } else {
             Ok(CacheWriter {
                 builder: tar::Builder::new(Box::new(writer)),
+                dereference_symlinks: false,
+                blob_store: None,
             })
         }
     }
@@ -71,10 +121,14 @@ impl<'a> CacheWriter<'a> {
 
             Ok(CacheWriter {
                 builder: tar::Builder::new(Box::new(zw)),
+                dereference_symlinks: false,
+                blob_store: None,
             })
         } else {
             Ok(CacheWriter {
                 builder: tar::Builder::new(Box::new(file_buffer)),
+                dereference_symlinks: false,
+                blob_store: None,
             })
         }
     }
@@ -88,18 +142,96 @@ impl<'a> CacheWriter<'a> {
         // Resolve the fully-qualified path to the file to read it.
         let source_path = anchor.resolve(file_path);
 
+        if self.dereference_symlinks {
+            return self.add_dereferenced(&source_path, file_path, &mut Vec::new());
+        }
+
         // Grab the file info to construct the header.
         let file_info = source_path.symlink_metadata()?;
 
+        self.add_entry(&source_path, file_path, &file_info)
+    }
+
+    // Adds the file, following symlinks and storing what they point to.
+    // Directories are walked so that the contents of a linked directory are
+    // included. `ancestors` holds the real paths of the directories being
+    // walked so that a link back up the tree is caught instead of recursing
+    // forever.
+    fn add_dereferenced(
+        &mut self,
+        source_path: &AbsoluteSystemPath,
+        file_path: &AnchoredSystemPath,
+        ancestors: &mut Vec<AbsoluteSystemPathBuf>,
+    ) -> Result<(), CacheError> {
+        let link_info = source_path.symlink_metadata()?;
+        // A broken symlink has nothing to dereference so we keep the link.
+        let Ok(file_info) = source_path.stat() else {
+            return self.add_entry(source_path, file_path, &link_info);
+        };
+
+        self.add_entry(source_path, file_path, &file_info)?;
+
+        // Directories matched by the output globs already have their contents
+        // listed, so we only walk linked directories and anything inside them.
+        if !(file_info.is_dir() && (link_info.is_symlink() || !ancestors.is_empty())) {
+            return Ok(());
+        }
+
+        let real_path = source_path.to_realpath()?;
+        if ancestors.contains(&real_path) {
+            return Err(CacheError::CycleDetected(Backtrace::capture()));
+        }
+        ancestors.push(real_path);
+
+        let mut children = fs::read_dir(source_path.as_std_path())?
+            .map(|entry| -> Result<String, CacheError> {
+                let name = entry?.file_name();
+                Ok(name.into_string().map_err(|name| {
+                    PathError::InvalidUnicode(name.to_string_lossy().into_owned())
+                })?)
+            })
+            .collect::<Result<Vec<_>, _>>()?;
+        // Sort so the same outputs always produce the same archive.
+        children.sort();
+
+        for child in children {
+            self.add_dereferenced(
+                &source_path.join_component(&child),
+                &file_path.join_component(&child),
+                ancestors,
+            )?;
+        }
+
+        ancestors.pop();
+
+        Ok(())
+    }
+
+    fn add_entry(
+        &mut self,
+        source_path: &AbsoluteSystemPath,
+        file_path: &AnchoredSystemPath,
+        file_info: &fs::Metadata,
+    ) -> Result<(), CacheError> {
         // Normalize the path within the cache
         let mut file_path = file_path.to_unix();
         file_path.make_canonical_for_tar(file_info.is_dir());
 
-        let mut header = Self::create_header(&file_info)?;
+        let mut header = Self::create_header(file_info)?;
 
         if matches!(header.entry_type(), EntryType::Regular) && file_info.len() > 0 {
-            let file = source_path.open()?;
-            self.append_data(&mut header, file_path.as_str(), file)?;
+            if let Some(blob_store) = &self.blob_store {
+                let digest = blob_store.add(source_path)?;
+                self.append_pax_extensions(&[
+                    (DIGEST_PAX_KEY, &digest),
+                    (SIZE_PAX_KEY, &file_info.len().to_string()),
+                ])?;
+                header.set_size(0);
+                self.append_data(&mut header, file_path.as_str(), &mut std::io::empty())?;
+            } else {
+                let file = source_path.open()?;
+                self.append_data(&mut header, file_path.as_str(), file)?;
+            }
         } else if matches!(header.entry_type(), EntryType::Symlink) {
             // We convert to a Unix path because all paths in tar should be
             // Unix-style. This will get restored to a system path.
@@ -167,7 +299,7 @@ mod tests {
     use turbopath::{AbsoluteSystemPath, AbsoluteSystemPathBuf, AnchoredSystemPathBuf};
 
     use super::*;
-    use crate::cache_archive::restore::CacheReader;
+    use crate::{cache_archive::restore::CacheReader, ArtifactEntryKind};
 
     #[derive(Debug)]
     enum FileType {
@@ -435,6 +567,55 @@ mod tests {
         Ok(())
     }
 
+    #[test]
+    fn test_dereference_symlinks() -> Result<()> {
+        let input_dir = tempdir()?;
+        let input_dir_path = AbsoluteSystemPath::new(input_dir.path().to_str().unwrap())?;
+
+        // Mimic a package whose output links into pnpm's virtual store
+        let store = input_dir_path.join_components(&["node_modules", ".pnpm", "dep"]);
+        store.create_dir_all()?;
+        store
+            .join_component("index.js")
+            .create_with_contents("export {}")?;
+        input_dir_path.join_component("dist").create_dir_all()?;
+        input_dir_path
+            .join_components(&["dist", "dep"])
+            .symlink_to_dir(store.as_str())?;
+        input_dir_path
+            .join_components(&["dist", "index.js"])
+            .symlink_to_file(store.join_component("index.js").as_str())?;
+        input_dir_path
+            .join_components(&["dist", "broken"])
+            .symlink_to_file("missing")?;
+
+        let archive_dir = tempdir()?;
+        let archive_path = AbsoluteSystemPathBuf::try_from(archive_dir.path().join("out.tar"))?;
+        let mut archive = CacheWriter::create(&archive_path)?.dereference_symlinks(true);
+        for file in ["dist", "dist/broken", "dist/dep", "dist/index.js"] {
+            archive.add_file(input_dir_path, &AnchoredSystemPathBuf::from_raw(file)?)?;
+        }
+        archive.finish()?;
+
+        let entries = CacheReader::open(&archive_path)?.entries()?;
+        let entries = entries
+            .iter()
+            .map(|entry| (entry.path.as_str(), entry.kind))
+            .collect::<Vec<_>>();
+        assert_eq!(
+            entries,
+            vec![
+                ("dist", ArtifactEntryKind::Directory),
+                ("dist/broken", ArtifactEntryKind::Symlink),
+                ("dist/dep", ArtifactEntryKind::Directory),
+                ("dist/dep/index.js", ArtifactEntryKind::File),
+                ("dist/index.js", ArtifactEntryKind::File),
+            ]
+        );
+
+        Ok(())
+    }
+
     #[test]
     fn test_compression() -> Result<()> {
         let mut buffer = Vec::new();
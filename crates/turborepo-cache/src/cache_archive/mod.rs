@@ -1,9 +1,11 @@
 #![allow(dead_code)]
+mod blobs;
 mod create;
 mod restore;
 mod restore_directory;
 mod restore_regular;
 mod restore_symlink;
 
+pub use blobs::BlobStore;
 pub use create::CacheWriter;
 pub use restore::CacheReader;
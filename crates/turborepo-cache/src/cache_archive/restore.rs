@@ -1,23 +1,33 @@
-use std::{backtrace::Backtrace, collections::HashMap, io::Read};
+use std::{
+    backtrace::Backtrace,
+    collections::{BTreeMap, HashMap},
+    io::{self, Read},
+};
 
 use petgraph::graph::DiGraph;
-use sha2::{Digest, Sha512};
+use sha2::{Digest, Sha256, Sha512};
 use tar::Entry;
-use turbopath::{AbsoluteSystemPath, AbsoluteSystemPathBuf, AnchoredSystemPathBuf};
+use turbopath::{
+    AbsoluteSystemPath, AbsoluteSystemPathBuf, AnchoredSystemPath, AnchoredSystemPathBuf,
+};
 
 use crate::{
     cache_archive::{
+        blobs::{blob_reference, BlobStore},
+        create::CacheWriter,
         restore_directory::{restore_directory, CachedDirTree},
         restore_regular::restore_regular,
         restore_symlink::{
             canonicalize_linkname, restore_symlink, restore_symlink_allow_missing_target,
         },
     },
-    CacheError,
+    ArtifactEntry, ArtifactEntryKind, CacheError,
 };
 
 pub struct CacheReader<'a> {
     reader: Box<dyn Read + 'a>,
+    // Where the contents of files are read from if they aren't in the archive
+    blob_store: Option<BlobStore>,
 }
 
 impl<'a> CacheReader<'a> {
@@ -28,7 +38,10 @@ impl<'a> CacheReader<'a> {
             Box::new(reader)
         };
 
-        Ok(CacheReader { reader })
+        Ok(CacheReader {
+            reader,
+            blob_store: None,
+        })
     }
 
     pub fn open(path: &AbsoluteSystemPathBuf) -> Result<Self, CacheError> {
@@ -41,7 +54,15 @@ impl<'a> CacheReader<'a> {
             Box::new(file)
         };
 
-        Ok(CacheReader { reader })
+        Ok(CacheReader {
+            reader,
+            blob_store: None,
+        })
+    }
+
+    pub fn with_blob_store(mut self, blob_store: BlobStore) -> Self {
+        self.blob_store = Some(blob_store);
+        self
     }
 
     pub fn get_sha(mut self) -> Result<Vec<u8>, CacheError> {
@@ -58,9 +79,122 @@ impl<'a> CacheReader<'a> {
         Ok(hasher.finalize().to_vec())
     }
 
+    /// Lists the entries of the archive without writing anything to disk
+    pub fn entries(&mut self) -> Result<Vec<ArtifactEntry>, CacheError> {
+        let mut tr = tar::Archive::new(&mut self.reader);
+        let mut entries = Vec::new();
+        for entry in tr.entries()? {
+            let mut entry = entry?;
+            let blob = blob_reference(&mut entry)?;
+            let header = entry.header();
+            let kind = match header.entry_type() {
+                tar::EntryType::Directory => ArtifactEntryKind::Directory,
+                tar::EntryType::Regular => ArtifactEntryKind::File,
+                tar::EntryType::Symlink => ArtifactEntryKind::Symlink,
+                ty => {
+                    return Err(CacheError::RestoreUnsupportedFileType(
+                        ty,
+                        Backtrace::capture(),
+                    ))
+                }
+            };
+            let path = String::from_utf8_lossy(&entry.path_bytes())
+                .trim_end_matches('/')
+                .to_string();
+            let link_target = entry
+                .link_name_bytes()
+                .map(|link_name| String::from_utf8_lossy(&link_name).to_string());
+
+            let (size, digest) = match blob {
+                Some((digest, size)) => (size, Some(digest)),
+                None => (entry.size(), None),
+            };
+
+            entries.push(ArtifactEntry {
+                path,
+                kind,
+                size,
+                mode: header.mode()?,
+                link_target,
+                digest,
+            });
+        }
+
+        Ok(entries)
+    }
+
+    /// Computes the SHA-256 digest of the contents of each of the archive's
+    /// files, keyed by path. Files kept in a blob store use the digest that
+    /// the archive references, without reading the blob.
+    pub fn checksums(&mut self) -> Result<BTreeMap<String, String>, CacheError> {
+        let mut tr = tar::Archive::new(&mut self.reader);
+        let mut checksums = BTreeMap::new();
+        for entry in tr.entries()? {
+            let mut entry = entry?;
+            let blob = blob_reference(&mut entry)?;
+            if entry.header().entry_type() != tar::EntryType::Regular {
+                continue;
+            }
+            let path = String::from_utf8_lossy(&entry.path_bytes()).to_string();
+            let digest = match blob {
+                Some((digest, _)) => digest,
+                None => {
+                    let mut hasher = Sha256::new();
+                    io::copy(&mut entry, &mut hasher)?;
+                    hex::encode(hasher.finalize())
+                }
+            };
+            checksums.insert(path, digest);
+        }
+
+        Ok(checksums)
+    }
+
+    /// Copies the archive's entries to `writer`, replacing references to the
+    /// blob store with the contents of the blobs, so that the result can be
+    /// restored without the blob store
+    pub fn write_self_contained(&mut self, writer: &mut CacheWriter) -> Result<(), CacheError> {
+        let mut tr = tar::Archive::new(&mut self.reader);
+        for entry in tr.entries()? {
+            let mut entry = entry?;
+            let blob = blob_reference(&mut entry)?;
+            let mut header = entry.header().clone();
+            let path = entry.path()?.into_owned();
+            match (blob, self.blob_store.as_ref()) {
+                (Some((digest, _)), Some(blob_store)) => {
+                    let contents = blob_store.read(&digest)?;
+                    header.set_size(contents.len() as u64);
+                    writer.append_data(&mut header, &path, contents.as_slice())?;
+                }
+                (Some(_), None) => return Err(CacheError::MalformedTar(Backtrace::capture())),
+                (None, _) if header.entry_type() == tar::EntryType::Symlink => {
+                    let target = entry
+                        .link_name()?
+                        .ok_or_else(|| CacheError::LinkTargetNotOnHeader(Backtrace::capture()))?
+                        .into_owned();
+                    writer.append_link(&mut header, &path, &target)?;
+                }
+                (None, _) => writer.append_data(&mut header, &path, &mut entry)?,
+            }
+        }
+
+        Ok(())
+    }
+
     pub fn restore(
         &mut self,
         anchor: &AbsoluteSystemPath,
+    ) -> Result<Vec<AnchoredSystemPathBuf>, CacheError> {
+        self.restore_matching(anchor, |_| true)
+    }
+
+    /// Restores only the entries whose paths are accepted by `filter`. Parent
+    /// directories of restored entries are created even if their own entries
+    /// are skipped.
+    pub fn restore_matching(
+        &mut self,
+        anchor: &AbsoluteSystemPath,
+        filter: impl Fn(&AnchoredSystemPath) -> bool,
     ) -> Result<Vec<AnchoredSystemPathBuf>, CacheError> {
         let mut restored = Vec::new();
         anchor.create_dir_all()?;
@@ -82,7 +216,14 @@ impl<'a> CacheReader<'a> {
         let dir_cache = CachedDirTree::new(anchor.to_owned());
         let mut tr = tar::Archive::new(&mut self.reader);
 
-        Self::restore_entries(&mut tr, &mut restored, dir_cache, anchor)?;
+        Self::restore_entries(
+            &mut tr,
+            &mut restored,
+            dir_cache,
+            anchor,
+            &filter,
+            self.blob_store.as_ref(),
+        )?;
         Ok(restored)
     }
 
@@ -91,6 +232,8 @@ impl<'a> CacheReader<'a> {
         restored: &mut Vec<AnchoredSystemPathBuf>,
         mut dir_cache: CachedDirTree,
         anchor: &AbsoluteSystemPath,
+        filter: &dyn Fn(&AnchoredSystemPath) -> bool,
+        blob_store: Option<&BlobStore>,
     ) -> Result<(), CacheError> {
         // On first attempt to restore it's possible that a link target doesn't exist.
         // Save them and topologically sort them.
@@ -98,7 +241,10 @@ impl<'a> CacheReader<'a> {
 
         for entry in tr.entries()? {
             let mut entry = entry?;
-            match restore_entry(&mut dir_cache, anchor, &mut entry) {
+            if !filter(&AnchoredSystemPathBuf::from_system_path(&entry.path()?)?) {
+                continue;
+            }
+            match restore_entry(&mut dir_cache, anchor, &mut entry, blob_store) {
                 Err(CacheError::LinkTargetDoesNotExist(_, _)) => {
                     symlinks.push(entry);
                 }
@@ -165,12 +311,13 @@ fn restore_entry<T: Read>(
     dir_cache: &mut CachedDirTree,
     anchor: &AbsoluteSystemPath,
     entry: &mut Entry<T>,
+    blob_store: Option<&BlobStore>,
 ) -> Result<AnchoredSystemPathBuf, CacheError> {
     let header = entry.header();
 
     match header.entry_type() {
         tar::EntryType::Directory => restore_directory(dir_cache, anchor, entry),
-        tar::EntryType::Regular => restore_regular(dir_cache, anchor, entry),
+        tar::EntryType::Regular => restore_regular(dir_cache, anchor, entry, blob_store),
         tar::EntryType::Symlink => restore_symlink(dir_cache, anchor, entry),
         ty => Err(CacheError::RestoreUnsupportedFileType(
             ty,
@@ -911,6 +1058,52 @@ mod tests {
         Ok(())
     }
 
+    #[test]
+    fn test_restore_matching() -> Result<()> {
+        let test_dir = tempdir()?;
+        let archive_path = generate_tar(
+            &test_dir,
+            &[
+                TarFile::Directory {
+                    path: AnchoredSystemPathBuf::from_raw("dist")?,
+                },
+                TarFile::File {
+                    body: b"kept".to_vec(),
+                    path: AnchoredSystemPathBuf::from_raw("dist/index.js")?,
+                },
+                TarFile::Directory {
+                    path: AnchoredSystemPathBuf::from_raw("stale")?,
+                },
+                TarFile::File {
+                    body: b"skipped".to_vec(),
+                    path: AnchoredSystemPathBuf::from_raw("stale/index.js")?,
+                },
+                TarFile::Symlink {
+                    link_path: AnchoredSystemPathBuf::from_raw("stale/link")?,
+                    link_target: AnchoredSystemPathBuf::from_raw("index.js")?,
+                },
+            ],
+        )?;
+        let output_dir = tempdir()?;
+        let anchor = AbsoluteSystemPath::from_std_path(output_dir.path())?;
+
+        let mut cache_reader = CacheReader::open(&archive_path)?;
+        let restored = cache_reader
+            .restore_matching(anchor, |path| path.to_unix().as_str() == "dist/index.js")?;
+
+        assert_eq!(
+            restored,
+            into_anchored_system_path_vec(vec!["dist/index.js"])
+        );
+        assert_eq!(
+            fs::read(anchor.join_components(&["dist", "index.js"]))?,
+            b"kept"
+        );
+        assert!(!anchor.join_component("stale").exists());
+
+        Ok(())
+    }
+
     #[test_case(Path::new("source").try_into()?, Path::new("target"), "/Users/test/target", "C:\\Users\\test\\target" ; "hello world")]
     #[test_case(Path::new("child/source").try_into()?, Path::new("../sibling/target"), "/Users/test/sibling/target", "C:\\Users\\test\\sibling\\target" ; "Unix path subdirectory traversal")]
     #[test_case(Path::new("child/source").try_into()?, Path::new("..\\sibling\\target"), "/Users/test/child/..\\sibling\\target", "C:\\Users\\test\\sibling\\target" ; "Windows path subdirectory traversal")]
@@ -1,14 +1,21 @@
-use std::{fs::OpenOptions, io, io::Read, path::Path};
+use std::{backtrace::Backtrace, fs::OpenOptions, io, io::Read, path::Path};
 
 use tar::Entry;
 use turbopath::{AbsoluteSystemPath, AnchoredSystemPath, AnchoredSystemPathBuf};
 
-use crate::{cache_archive::restore_directory::CachedDirTree, CacheError};
+use crate::{
+    cache_archive::{
+        blobs::{blob_reference, BlobStore},
+        restore_directory::CachedDirTree,
+    },
+    CacheError,
+};
 
 pub fn restore_regular(
     dir_cache: &mut CachedDirTree,
     anchor: &AbsoluteSystemPath,
     entry: &mut Entry<impl Read>,
+    blob_store: Option<&BlobStore>,
 ) -> Result<AnchoredSystemPathBuf, CacheError> {
     // Assuming this was a `turbo`-created input, we currently have an
     // RelativeUnixPath. Assuming this is malicious input we don't really care
@@ -25,6 +32,21 @@ pub fn restore_regular(
     dir_cache.safe_mkdir_file(anchor, &processed_name)?;
 
     let resolved_path = anchor.resolve(&processed_name);
+    if let Some((digest, _)) = blob_reference(entry)? {
+        let blob_store =
+            blob_store.ok_or_else(|| CacheError::MalformedTar(Backtrace::capture()))?;
+        blob_store.restore(&digest, entry.header().mode()?, &resolved_path)?;
+        return Ok(processed_name);
+    }
+
+    // The existing file may be a hard link into the local cache's blob store,
+    // so it's replaced rather than written through
+    match resolved_path.remove_file() {
+        Ok(()) => (),
+        Err(e) if e.kind() == io::ErrorKind::NotFound => (),
+        Err(e) => return Err(e.into()),
+    }
+
     let mut open_options = OpenOptions::new();
     open_options.write(true).truncate(true).create(true);
 
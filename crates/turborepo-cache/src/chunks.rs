@@ -0,0 +1,103 @@
+use std::{backtrace::Backtrace, ops::Range};
+
+use serde::{Deserialize, Serialize};
+
+use crate::CacheError;
+
+// Artifacts are zstd compressed tars, which can never start with this
+const MANIFEST_PREFIX: &[u8] = b"turbo-chunked-artifact\n";
+
+/// Stored in place of an artifact that was uploaded in chunks. Each chunk is
+/// stored as an artifact of its own under `chunk_hash`, so chunked uploads
+/// work with any implementation of the Remote Caching API.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub(crate) struct ChunkManifest {
+    // The size of every chunk in order
+    pub chunks: Vec<u64>,
+}
+
+impl ChunkManifest {
+    pub fn new(size: u64, chunk_size: u64) -> Self {
+        let chunk_size = chunk_size.max(1);
+        let chunks = (0..size)
+            .step_by(chunk_size as usize)
+            .map(|start| chunk_size.min(size - start))
+            .collect();
+        Self { chunks }
+    }
+
+    /// The range of the artifact's body that each chunk covers. Downloaded
+    /// manifests can't be trusted, so sizes that don't fit in memory are an
+    /// error rather than an overflow.
+    pub fn ranges(&self) -> Result<Vec<Range<usize>>, CacheError> {
+        let mut start = 0usize;
+        self.chunks
+            .iter()
+            .map(|size| {
+                let end = usize::try_from(*size)
+                    .ok()
+                    .and_then(|size| start.checked_add(size))
+                    .ok_or_else(|| CacheError::InvalidChunkManifest(Backtrace::capture()))?;
+                let range = start..end;
+                start = end;
+                Ok(range)
+            })
+            .collect()
+    }
+
+    pub fn to_bytes(&self) -> Vec<u8> {
+        let mut bytes = MANIFEST_PREFIX.to_vec();
+        // CORRECTNESS: the manifest only holds numbers
+        serde_json::to_writer(&mut bytes, self).unwrap();
+        bytes
+    }
+
+    /// Returns `None` when `body` is an artifact rather than a manifest
+    pub fn from_bytes(body: &[u8]) -> Option<Self> {
+        serde_json::from_slice(body.strip_prefix(MANIFEST_PREFIX)?).ok()
+    }
+}
+
+pub(crate) fn chunk_hash(hash: &str, index: usize) -> String {
+    format!("{hash}-chunk-{index}")
+}
+
+#[cfg(test)]
+mod test {
+    use test_case::test_case;
+
+    use super::*;
+
+    #[test_case(10, 4, vec![0..4, 4..8, 8..10] ; "remainder")]
+    #[test_case(8, 4, vec![0..4, 4..8] ; "exact")]
+    #[test_case(3, 4, vec![0..3] ; "smaller than a chunk")]
+    fn test_ranges(size: u64, chunk_size: u64, expected: Vec<Range<usize>>) {
+        let manifest = ChunkManifest::new(size, chunk_size);
+
+        assert_eq!(manifest.ranges().unwrap(), expected);
+    }
+
+    #[test]
+    fn test_ranges_overflow() {
+        let manifest = ChunkManifest {
+            chunks: vec![u64::MAX, 1],
+        };
+
+        assert!(matches!(
+            manifest.ranges(),
+            Err(CacheError::InvalidChunkManifest(_))
+        ));
+    }
+
+    #[test]
+    fn test_round_trip() {
+        let manifest = ChunkManifest::new(10, 4);
+
+        assert_eq!(
+            ChunkManifest::from_bytes(&manifest.to_bytes()),
+            Some(manifest)
+        );
+        assert_eq!(ChunkManifest::from_bytes(&[0x28, 0xb5, 0x2f, 0xfd]), None);
+    }
+}
@@ -1,25 +1,71 @@
-use std::{backtrace::Backtrace, fs::OpenOptions};
+use std::{
+    backtrace::Backtrace,
+    collections::{BTreeMap, HashMap, HashSet},
+    fs::OpenOptions,
+    io,
+    path::Path,
+    sync::atomic::{AtomicU64, Ordering},
+    time::{Duration, SystemTime, UNIX_EPOCH},
+};
 
 use camino::Utf8Path;
 use serde::{Deserialize, Serialize};
-use turbopath::{AbsoluteSystemPath, AbsoluteSystemPathBuf, AnchoredSystemPathBuf};
+use tracing::warn;
+use turbopath::{
+    AbsoluteSystemPath, AbsoluteSystemPathBuf, AnchoredSystemPath, AnchoredSystemPathBuf,
+};
 use turborepo_analytics::AnalyticsSender;
 use turborepo_api_client::{analytics, analytics::AnalyticsEvent};
 
 use crate::{
-    cache_archive::{CacheReader, CacheWriter},
-    CacheError, CacheHitMetadata, CacheSource,
+    cache_archive::{BlobStore, CacheReader, CacheWriter},
+    ArtifactEntryKind, ArtifactInfo, CacheError, CacheHitMetadata, CacheSource, Provenance,
+    RestoreMode,
 };
 
+/// Artifacts are stored as a manifest, `<hash>-manifest.tar`, that lists
+/// their entries while the contents of their files are kept in a blob store
+/// shared by every artifact. Artifacts written by older versions of turbo as
+/// `<hash>.tar` or `<hash>.tar.zst` archives can still be restored.
+///
+/// Every file is written to a temporary file and renamed into place, and the
+/// metadata, `<hash>-meta.json`, is written last. An artifact is only found
+/// once its metadata exists, so a write that's interrupted leaves behind an
+/// incomplete artifact that's treated as a miss rather than a partial hit.
+///
+/// The metadata also records the digest of each of the artifact's files, and
+/// artifacts are verified against it before they're restored. An artifact
+/// that has been corrupted or tampered with is removed and treated as a miss.
 pub struct FSCache {
     cache_directory: AbsoluteSystemPathBuf,
+    blob_store: BlobStore,
     analytics_recorder: Option<AnalyticsSender>,
+    dereference_symlinks: bool,
+    // Recorded in the metadata of artifacts that are written by this machine
+    provenance: Option<Provenance>,
 }
 
 #[derive(Debug, Deserialize, Serialize)]
+#[serde(rename_all = "camelCase")]
 struct CacheMetadata {
     hash: String,
     duration: u64,
+    // Seconds since the unix epoch. Metadata written by older versions of
+    // turbo doesn't have these, in which case they're 0.
+    #[serde(default)]
+    created_at: u64,
+    #[serde(default)]
+    last_accessed_at: u64,
+    // The signature of an archive that was uploaded to `turbo cache-server`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    tag: Option<String>,
+    // The SHA-256 of each file in the artifact, keyed by path. Metadata written
+    // by older versions of turbo doesn't have these, in which case only the
+    // blobs the artifact references are verified.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    checksums: Option<BTreeMap<String, String>>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    provenance: Option<Provenance>,
 }
 
 impl CacheMetadata {
@@ -27,6 +73,130 @@ impl CacheMetadata {
         serde_json::from_str(&path.read_to_string()?)
             .map_err(|e| CacheError::InvalidMetadata(e, Backtrace::capture()))
     }
+
+    fn write(&self, path: &AbsoluteSystemPath) -> Result<(), CacheError> {
+        write_atomically(path, |temp_path| {
+            let mut metadata_options = OpenOptions::new();
+            metadata_options.create(true).write(true).truncate(true);
+
+            let metadata_file = temp_path.open_with_options(metadata_options)?;
+
+            serde_json::to_writer(metadata_file, self)
+                .map_err(|e| CacheError::MetadataWriteFailure(e, Backtrace::capture()))
+        })
+    }
+
+    // The last time the artifact was written or restored, if it's known
+    fn last_used_at(&self) -> Option<u64> {
+        Some(self.created_at.max(self.last_accessed_at)).filter(|time| *time > 0)
+    }
+}
+
+/// A summary of an artifact in the local cache, as listed by `FSCache::list`
+#[derive(Debug, Clone, PartialEq, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ArtifactListing {
+    pub hash: String,
+    // The task and package directory are inferred from the task's log file,
+    // which isn't present in artifacts of tasks that don't cache their logs
+    pub task: Option<String>,
+    pub package_dir: Option<String>,
+    // The total size of the artifact's files
+    pub size: u64,
+    pub time_saved: u64,
+    // Seconds since the unix epoch
+    pub last_used_at: u64,
+    // Where the artifact was built, if the run that wrote it recorded it
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub provenance: Option<Provenance>,
+}
+
+/// An artifact as it's sent to and received from the remote cache
+#[derive(Debug, Clone, PartialEq)]
+pub struct ArchivedArtifact {
+    // A zstd compressed tar
+    pub body: Vec<u8>,
+    pub duration: u64,
+    // Set when the artifact was signed
+    pub tag: Option<String>,
+    pub provenance: Option<Provenance>,
+}
+
+/// The result of verifying an artifact against the checksums in its metadata
+#[derive(Debug, Clone, PartialEq, Serialize)]
+#[serde(rename_all = "camelCase", tag = "status")]
+pub enum Integrity {
+    Valid,
+    // The artifact's metadata has no checksums, but the blobs it references
+    // match their digests
+    Unverified,
+    // The files whose contents don't match their checksums, including any
+    // that were added or removed. Artifacts that can't be read at all don't
+    // list any files.
+    Corrupt { files: Vec<String> },
+}
+
+/// The integrity of an artifact in the local cache, as reported by
+/// `FSCache::verify_all`
+#[derive(Debug, Clone, PartialEq, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct VerifiedArtifact {
+    pub hash: String,
+    #[serde(flatten)]
+    pub integrity: Integrity,
+}
+
+/// The number and total size of artifacts removed from the local cache
+#[derive(Debug, Default, Clone, Copy, PartialEq, Serialize)]
+pub struct RemovedArtifacts {
+    pub artifacts: usize,
+    pub bytes: u64,
+}
+
+// Temporary files are renamed into place as soon as they're written, so one
+// that's older than this was left behind by a write that was interrupted
+const STALE_TEMP_FILE_AGE: Duration = Duration::from_secs(60 * 60);
+
+// Writes `path` by passing a temporary file next to it to `write` and renaming
+// that into place once it's complete, so that `path` is never seen partially
+// written
+fn write_atomically(
+    path: &AbsoluteSystemPath,
+    write: impl FnOnce(&AbsoluteSystemPath) -> Result<(), CacheError>,
+) -> Result<(), CacheError> {
+    static NEXT_ID: AtomicU64 = AtomicU64::new(0);
+    let temp_path = path
+        .parent()
+        .expect("cache files are written to the cache directory")
+        .join_component(&format!(
+            "{}.{}-{}.tmp",
+            path.file_name().unwrap_or_default(),
+            std::process::id(),
+            NEXT_ID.fetch_add(1, Ordering::Relaxed)
+        ));
+    let result = write(&temp_path).and_then(|()| Ok(temp_path.rename(path)?));
+    if result.is_err() {
+        let _ = temp_path.remove_file();
+    }
+    result
+}
+
+fn unix_seconds(time: SystemTime) -> u64 {
+    time.duration_since(UNIX_EPOCH)
+        .map(|duration| duration.as_secs())
+        .unwrap_or_default()
+}
+
+// Task logs are written to `<package dir>/.turbo/turbo-<task>.log`, with any
+// colons in the task name escaped. The root package's directory is `.`.
+fn task_from_log_path(path: &str) -> Option<(String, String)> {
+    let (log_dir, file_name) = path.rsplit_once('/').unwrap_or(("", path));
+    let task = file_name.strip_prefix("turbo-")?.strip_suffix(".log")?;
+    let package_dir = match log_dir {
+        ".turbo" => ".",
+        _ => log_dir.strip_suffix("/.turbo")?,
+    };
+    Some((package_dir.to_string(), task.replace("$colon$", ":")))
 }
 
 impl FSCache {
@@ -49,22 +219,44 @@ impl FSCache {
     ) -> Result<Self, CacheError> {
         let cache_directory = Self::resolve_cache_dir(repo_root, override_dir);
         cache_directory.create_dir_all()?;
+        let blob_store = BlobStore::new(cache_directory.join_component("blobs"));
 
         Ok(FSCache {
             cache_directory,
+            blob_store,
             analytics_recorder,
+            dereference_symlinks: false,
+            provenance: None,
         })
     }
 
+    pub fn with_provenance(mut self, provenance: Option<Provenance>) -> Self {
+        self.provenance = provenance;
+        self
+    }
+
+    // Store the targets of symlinks in outputs instead of the links themselves
+    pub fn with_dereference_symlinks(mut self, dereference_symlinks: bool) -> Self {
+        self.dereference_symlinks = dereference_symlinks;
+        self
+    }
+
+    pub fn with_restore_mode(mut self, restore_mode: RestoreMode) -> Self {
+        self.blob_store = self.blob_store.with_restore_mode(restore_mode);
+        self
+    }
+
     fn log_fetch(&self, event: analytics::CacheEvent, hash: &str, duration: u64) {
         // If analytics fails to record, it's not worth failing the cache
         if let Some(analytics_recorder) = &self.analytics_recorder {
             let analytics_event = AnalyticsEvent {
                 session_id: None,
+                run_id: None,
                 source: analytics::CacheSource::Local,
                 event,
                 hash: hash.to_string(),
                 duration,
+                bytes: None,
             };
 
             let _ = analytics_recorder.send(analytics_event);
@@ -77,31 +269,46 @@ impl FSCache {
         anchor: &AbsoluteSystemPath,
         hash: &str,
     ) -> Result<Option<(CacheHitMetadata, Vec<AnchoredSystemPathBuf>)>, CacheError> {
-        let uncompressed_cache_path = self
-            .cache_directory
-            .join_component(&format!("{}.tar", hash));
-        let compressed_cache_path = self
-            .cache_directory
-            .join_component(&format!("{}.tar.zst", hash));
+        self.fetch_matching(anchor, hash, |_| true)
+    }
 
-        let cache_path = if uncompressed_cache_path.exists() {
-            uncompressed_cache_path
-        } else if compressed_cache_path.exists() {
-            compressed_cache_path
-        } else {
+    /// Like `fetch`, but only restores the files accepted by `filter`
+    #[tracing::instrument(skip_all)]
+    pub fn fetch_matching(
+        &self,
+        anchor: &AbsoluteSystemPath,
+        hash: &str,
+        filter: impl Fn(&AnchoredSystemPath) -> bool,
+    ) -> Result<Option<(CacheHitMetadata, Vec<AnchoredSystemPathBuf>)>, CacheError> {
+        let Some(cache_path) = self.artifact_path(hash) else {
             self.log_fetch(analytics::CacheEvent::Miss, hash, 0);
             return Ok(None);
         };
 
-        let mut cache_reader = CacheReader::open(&cache_path)?;
+        let metadata_path = self
+            .cache_directory
+            .join_component(&format!("{}-meta.json", hash));
+        let mut meta = CacheMetadata::read(&metadata_path)?;
+
+        if let Integrity::Corrupt { files } = self.check_integrity(&cache_path, &meta, &filter)? {
+            warn!(
+                "removing corrupted artifact {} from the local cache, mismatched files: {:?}",
+                hash, files
+            );
+            self.remove_artifact(hash, &mut RemovedArtifacts::default())?;
+            self.log_fetch(analytics::CacheEvent::Miss, hash, 0);
+            return Ok(None);
+        }
+
+        let mut cache_reader =
+            CacheReader::open(&cache_path)?.with_blob_store(self.blob_store.clone());
 
-        let restored_files = cache_reader.restore(anchor)?;
+        let restored_files = cache_reader.restore_matching(anchor, filter)?;
 
-        let meta = CacheMetadata::read(
-            &self
-                .cache_directory
-                .join_component(&format!("{}-meta.json", hash)),
-        )?;
+        // Keep artifacts that are still in use from being garbage collected. This
+        // is best effort since failing to record it doesn't affect the restore.
+        meta.last_accessed_at = unix_seconds(SystemTime::now());
+        let _ = meta.write(&metadata_path);
 
         self.log_fetch(analytics::CacheEvent::Hit, hash, meta.duration);
 
@@ -114,16 +321,30 @@ impl FSCache {
         )))
     }
 
+    /// Reads the contents and metadata of an artifact without restoring it
     #[tracing::instrument(skip_all)]
-    pub(crate) fn exists(&self, hash: &str) -> Result<Option<CacheHitMetadata>, CacheError> {
-        let uncompressed_cache_path = self
-            .cache_directory
-            .join_component(&format!("{}.tar", hash));
-        let compressed_cache_path = self
-            .cache_directory
-            .join_component(&format!("{}.tar.zst", hash));
+    pub fn inspect(&self, hash: &str) -> Result<Option<ArtifactInfo>, CacheError> {
+        let Some(cache_path) = self.artifact_path(hash) else {
+            return Ok(None);
+        };
+
+        let entries = CacheReader::open(&cache_path)?.entries()?;
+        let meta = CacheMetadata::read(
+            &self
+                .cache_directory
+                .join_component(&format!("{}-meta.json", hash)),
+        )?;
+
+        Ok(Some(ArtifactInfo {
+            source: CacheSource::Local,
+            time_saved: meta.duration,
+            entries,
+        }))
+    }
 
-        if !uncompressed_cache_path.exists() && !compressed_cache_path.exists() {
+    #[tracing::instrument(skip_all)]
+    pub(crate) fn exists(&self, hash: &str) -> Result<Option<CacheHitMetadata>, CacheError> {
+        if self.artifact_path(hash).is_none() {
             return Ok(None);
         }
 
@@ -148,36 +369,495 @@ impl FSCache {
         hash: &str,
         files: &[AnchoredSystemPathBuf],
         duration: u64,
+    ) -> Result<(), CacheError> {
+        self.put_with_provenance(anchor, hash, files, duration, self.provenance.clone())
+    }
+
+    /// Like `put`, but for files that were built elsewhere, e.g. restored from
+    /// the remote cache
+    #[tracing::instrument(skip_all)]
+    pub fn put_with_provenance(
+        &self,
+        anchor: &AbsoluteSystemPath,
+        hash: &str,
+        files: &[AnchoredSystemPathBuf],
+        duration: u64,
+        provenance: Option<Provenance>,
     ) -> Result<(), CacheError> {
         let cache_path = self
             .cache_directory
-            .join_component(&format!("{}.tar.zst", hash));
+            .join_component(&format!("{}-manifest.tar", hash));
 
-        let mut cache_item = CacheWriter::create(&cache_path)?;
+        write_atomically(&cache_path, |temp_path| {
+            let mut cache_item = CacheWriter::create(temp_path)?
+                .dereference_symlinks(self.dereference_symlinks)
+                .with_blob_store(self.blob_store.clone());
 
-        for file in files {
-            cache_item.add_file(anchor, file)?;
-        }
+            for file in files {
+                cache_item.add_file(anchor, file)?;
+            }
+
+            cache_item.finish()
+        })?;
+        // Every file in a manifest is a blob, so its checksums are read from
+        // the manifest without hashing the files again
+        let checksums = CacheReader::open(&cache_path)?.checksums()?;
 
         let metadata_path = self
             .cache_directory
             .join_component(&format!("{}-meta.json", hash));
 
+        let now = unix_seconds(SystemTime::now());
         let meta = CacheMetadata {
             hash: hash.to_string(),
             duration,
+            created_at: now,
+            last_accessed_at: now,
+            tag: None,
+            checksums: Some(checksums),
+            provenance,
         };
+        meta.write(&metadata_path)?;
+
+        Ok(())
+    }
+
+    /// Stores an archive in the format used by the remote cache as
+    /// `<hash>.tar.zst`, so that it can be served as is and restored like any
+    /// other artifact
+    #[tracing::instrument(skip_all)]
+    pub fn put_archive(&self, hash: &str, archive: &ArchivedArtifact) -> Result<(), CacheError> {
+        let [_, _, compressed_cache_path, metadata_path] = self.artifact_paths(hash);
 
-        let mut metadata_options = OpenOptions::new();
-        metadata_options.create(true).write(true).truncate(true);
+        write_atomically(&compressed_cache_path, |temp_path| {
+            Ok(temp_path.create_with_contents(&archive.body)?)
+        })?;
 
-        let metadata_file = metadata_path.open_with_options(metadata_options)?;
+        // An archive that can't be read is stored without checksums, since
+        // restoring it fails anyway
+        let checksums = CacheReader::from_reader(archive.body.as_slice(), true)
+            .and_then(|mut reader| reader.checksums())
+            .ok();
 
-        serde_json::to_writer(metadata_file, &meta)
-            .map_err(|e| CacheError::InvalidMetadata(e, Backtrace::capture()))?;
+        let now = unix_seconds(SystemTime::now());
+        let meta = CacheMetadata {
+            hash: hash.to_string(),
+            duration: archive.duration,
+            created_at: now,
+            last_accessed_at: now,
+            tag: archive.tag.clone(),
+            checksums,
+            provenance: archive.provenance.clone(),
+        };
+        meta.write(&metadata_path)?;
+
+        Ok(())
+    }
+
+    /// Reads an archive stored by `put_archive`. Artifacts that were cached
+    /// from files on disk aren't stored as archives and aren't returned.
+    #[tracing::instrument(skip_all)]
+    pub fn read_archive(&self, hash: &str) -> Result<Option<ArchivedArtifact>, CacheError> {
+        let [_, _, compressed_cache_path, metadata_path] = self.artifact_paths(hash);
+        if !metadata_path.exists() {
+            return Ok(None);
+        }
+        let body = match std::fs::read(compressed_cache_path.as_std_path()) {
+            Ok(body) => body,
+            Err(e) if e.kind() == io::ErrorKind::NotFound => return Ok(None),
+            Err(e) => return Err(e.into()),
+        };
+        let mut meta = CacheMetadata::read(&metadata_path)?;
+        meta.last_accessed_at = unix_seconds(SystemTime::now());
+        let _ = meta.write(&metadata_path);
+
+        Ok(Some(ArchivedArtifact {
+            body,
+            duration: meta.duration,
+            tag: meta.tag,
+            provenance: meta.provenance,
+        }))
+    }
+
+    /// Reads any artifact as an archive in the format used by the remote
+    /// cache, with the contents of files that are kept in the blob store
+    /// included, e.g. to copy it to another cache
+    #[tracing::instrument(skip_all)]
+    pub fn export_archive(&self, hash: &str) -> Result<Option<ArchivedArtifact>, CacheError> {
+        let Some(cache_path) = self.artifact_path(hash) else {
+            return Ok(None);
+        };
+        let meta = CacheMetadata::read(&self.artifact_paths(hash)[3])?;
+
+        let mut body = Vec::new();
+        {
+            let mut writer = CacheWriter::from_writer(&mut body, true)?;
+            CacheReader::open(&cache_path)?
+                .with_blob_store(self.blob_store.clone())
+                .write_self_contained(&mut writer)?;
+            writer.finish()?;
+        }
+
+        Ok(Some(ArchivedArtifact {
+            body,
+            duration: meta.duration,
+            tag: meta.tag,
+            provenance: meta.provenance,
+        }))
+    }
+
+    /// Verifies an artifact against the checksums in its metadata without
+    /// restoring it, returning `None` if it isn't in the cache
+    #[tracing::instrument(skip_all)]
+    pub fn verify(&self, hash: &str) -> Result<Option<Integrity>, CacheError> {
+        let Some(cache_path) = self.artifact_path(hash) else {
+            return Ok(None);
+        };
+        let meta = match CacheMetadata::read(&self.artifact_paths(hash)[3]) {
+            Ok(meta) => meta,
+            Err(CacheError::InvalidMetadata(..)) => {
+                return Ok(Some(Integrity::Corrupt { files: Vec::new() }))
+            }
+            Err(e) => return Err(e),
+        };
+
+        Ok(Some(self.check_integrity(&cache_path, &meta, &|_| true)?))
+    }
+
+    /// Verifies every complete artifact in the cache, ordered by hash
+    #[tracing::instrument(skip_all)]
+    pub fn verify_all(&self) -> Result<Vec<VerifiedArtifact>, CacheError> {
+        let mut hashes = self.hashes()?.into_iter().collect::<Vec<_>>();
+        hashes.sort();
+
+        let mut verified = Vec::new();
+        for hash in hashes {
+            if let Some(integrity) = self.verify(&hash)? {
+                verified.push(VerifiedArtifact { hash, integrity });
+            }
+        }
+
+        Ok(verified)
+    }
+
+    // An archive or manifest that can't be read is corrupt, but failing to read
+    // a blob for any reason other than it being missing is returned as an error.
+    // Only the blobs of the files accepted by `filter` are read, since those are
+    // the ones that will be restored, and restoring them doesn't check them
+    // again.
+    fn check_integrity(
+        &self,
+        cache_path: &AbsoluteSystemPath,
+        meta: &CacheMetadata,
+        filter: &dyn Fn(&AnchoredSystemPath) -> bool,
+    ) -> Result<Integrity, CacheError> {
+        let checksums =
+            match CacheReader::open(cache_path).and_then(|mut reader| reader.checksums()) {
+                Ok(checksums) => checksums,
+                Err(_) => return Ok(Integrity::Corrupt { files: Vec::new() }),
+            };
+
+        let mut files = Vec::new();
+        if let Some(expected) = &meta.checksums {
+            files.extend(
+                expected
+                    .keys()
+                    .chain(checksums.keys())
+                    .filter(|path| expected.get(*path) != checksums.get(*path))
+                    .cloned(),
+            );
+        }
+        // Files in manifests are kept in the blob store, which also has to match
+        let is_manifest = cache_path
+            .file_name()
+            .map_or(false, |file_name| file_name.ends_with("-manifest.tar"));
+        if is_manifest {
+            // Files with the same contents share a blob, which is read once
+            let mut verified = HashMap::new();
+            for (path, digest) in &checksums {
+                let restored = AnchoredSystemPathBuf::from_system_path(Path::new(path))
+                    .map_or(true, |path| filter(&path));
+                if !restored {
+                    continue;
+                }
+                let is_valid = match verified.get(digest.as_str()) {
+                    Some(is_valid) => *is_valid,
+                    None => {
+                        let is_valid = self.blob_store.verify(digest)?;
+                        verified.insert(digest.as_str(), is_valid);
+                        is_valid
+                    }
+                };
+                if !is_valid {
+                    files.push(path.clone());
+                }
+            }
+        }
+        files.sort();
+        files.dedup();
+
+        Ok(match (files.is_empty(), meta.checksums.is_some()) {
+            (false, _) => Integrity::Corrupt { files },
+            (true, true) => Integrity::Valid,
+            (true, false) => Integrity::Unverified,
+        })
+    }
+
+    /// Removes artifacts that haven't been written or restored within
+    /// `max_age`, along with any blobs that are no longer referenced and the
+    /// temporary files of interrupted writes. Artifacts without timestamps in
+    /// their metadata fall back to the modification time of the archive.
+    #[tracing::instrument(skip_all)]
+    pub fn gc(&self, max_age: Duration) -> Result<RemovedArtifacts, CacheError> {
+        let cutoff = unix_seconds(SystemTime::now()).saturating_sub(max_age.as_secs());
+
+        let mut removed = RemovedArtifacts::default();
+        for hash in self.hashes()? {
+            let meta = CacheMetadata::read(&self.artifact_paths(&hash)[3]).ok();
+            if self.last_used_at(&hash, meta.as_ref()) < cutoff {
+                self.remove_artifact(&hash, &mut removed)?;
+            }
+        }
+        self.remove_unreferenced_blobs(&mut removed)?;
+        for dir in [&*self.cache_directory, self.blob_store.root()] {
+            remove_stale_temp_files(dir, &mut removed)?;
+        }
+
+        Ok(removed)
+    }
+
+    /// Lists the artifacts in the cache, most recently used first. Artifacts
+    /// that can't be read are skipped.
+    #[tracing::instrument(skip_all)]
+    pub fn list(&self) -> Result<Vec<ArtifactListing>, CacheError> {
+        let mut listings = Vec::new();
+        for hash in self.hashes()? {
+            let Some(cache_path) = self.artifact_path(&hash) else {
+                continue;
+            };
+            let Ok(entries) =
+                CacheReader::open(&cache_path).and_then(|mut reader| reader.entries())
+            else {
+                continue;
+            };
+            let meta = CacheMetadata::read(&self.artifact_paths(&hash)[3]).ok();
+
+            let (package_dir, task) = entries
+                .iter()
+                .find_map(|entry| task_from_log_path(&entry.path))
+                .unzip();
+            listings.push(ArtifactListing {
+                size: entries
+                    .iter()
+                    .filter(|entry| entry.kind == ArtifactEntryKind::File)
+                    .map(|entry| entry.size)
+                    .sum(),
+                time_saved: meta.as_ref().map_or(0, |meta| meta.duration),
+                last_used_at: self.last_used_at(&hash, meta.as_ref()),
+                provenance: meta.and_then(|meta| meta.provenance),
+                task,
+                package_dir,
+                hash,
+            });
+        }
+        listings.sort_by(|a, b| {
+            b.last_used_at
+                .cmp(&a.last_used_at)
+                .then_with(|| a.hash.cmp(&b.hash))
+        });
+
+        Ok(listings)
+    }
+
+    /// Removes the artifact for a single hash, returning `None` if it isn't
+    /// in the cache
+    #[tracing::instrument(skip_all)]
+    pub fn remove(&self, hash: &str) -> Result<Option<RemovedArtifacts>, CacheError> {
+        let mut removed = RemovedArtifacts::default();
+        self.remove_artifact(hash, &mut removed)?;
+        if removed.artifacts == 0 {
+            return Ok(None);
+        }
+        self.remove_unreferenced_blobs(&mut removed)?;
+
+        Ok(Some(removed))
+    }
+
+    /// Removes every artifact in the cache. The cache directory itself is
+    /// left in place so that a concurrent run can keep writing to it.
+    #[tracing::instrument(skip_all)]
+    pub fn clear(&self) -> Result<RemovedArtifacts, CacheError> {
+        let mut removed = RemovedArtifacts::default();
+        for hash in self.hashes()? {
+            self.remove_artifact(&hash, &mut removed)?;
+        }
+        self.remove_unreferenced_blobs(&mut removed)?;
+
+        Ok(removed)
+    }
+
+    // The hashes of every artifact in the cache, including any whose archive
+    // or metadata is missing
+    fn hashes(&self) -> Result<HashSet<String>, CacheError> {
+        let mut hashes = HashSet::new();
+        for entry in std::fs::read_dir(&self.cache_directory)? {
+            let file_name = entry?.file_name();
+            let Some(file_name) = file_name.to_str() else {
+                continue;
+            };
+            if let Some(hash) = file_name
+                .strip_suffix("-manifest.tar")
+                .or_else(|| file_name.strip_suffix(".tar.zst"))
+                .or_else(|| file_name.strip_suffix(".tar"))
+                .or_else(|| file_name.strip_suffix("-meta.json"))
+            {
+                hashes.insert(hash.to_string());
+            }
+        }
+
+        Ok(hashes)
+    }
+
+    // The last time the artifact was written or restored. Metadata without
+    // timestamps falls back to the modification time of the archive.
+    fn last_used_at(&self, hash: &str, meta: Option<&CacheMetadata>) -> u64 {
+        if let Some(last_used_at) = meta.and_then(|meta| meta.last_used_at()) {
+            return last_used_at;
+        }
+        let [manifest_path, uncompressed_cache_path, compressed_cache_path, _] =
+            self.artifact_paths(hash);
+        [
+            manifest_path,
+            uncompressed_cache_path,
+            compressed_cache_path,
+        ]
+        .iter()
+        .filter_map(|path| path.symlink_metadata().ok()?.modified().ok())
+        .map(unix_seconds)
+        .max()
+        .unwrap_or_default()
+    }
+
+    // The manifest or legacy archive for the hash, if there is one. The
+    // metadata is written last, so without it the artifact is incomplete.
+    fn artifact_path(&self, hash: &str) -> Option<AbsoluteSystemPathBuf> {
+        let [manifest_path, uncompressed_cache_path, compressed_cache_path, metadata_path] =
+            self.artifact_paths(hash);
+        if !metadata_path.exists() {
+            return None;
+        }
+        [
+            manifest_path,
+            uncompressed_cache_path,
+            compressed_cache_path,
+        ]
+        .into_iter()
+        .find(|path| path.exists())
+    }
+
+    fn artifact_paths(&self, hash: &str) -> [AbsoluteSystemPathBuf; 4] {
+        [
+            format!("{}-manifest.tar", hash),
+            format!("{}.tar", hash),
+            format!("{}.tar.zst", hash),
+            format!("{}-meta.json", hash),
+        ]
+        .map(|file_name| self.cache_directory.join_component(&file_name))
+    }
+
+    // Files can disappear while we're removing them if another run or clean is
+    // operating on the same cache, so missing files aren't an error. A run that
+    // is restoring or writing the artifact at the same time sees a cache miss.
+    fn remove_artifact(
+        &self,
+        hash: &str,
+        removed: &mut RemovedArtifacts,
+    ) -> Result<(), CacheError> {
+        let mut removed_any = false;
+        for path in self.artifact_paths(hash) {
+            let Ok(metadata) = path.symlink_metadata() else {
+                continue;
+            };
+            match path.remove_file() {
+                Ok(()) => {
+                    removed.bytes += metadata.len();
+                    removed_any = true;
+                }
+                Err(e) if e.kind() == io::ErrorKind::NotFound => (),
+                Err(e) => return Err(e.into()),
+            }
+        }
+        if removed_any {
+            removed.artifacts += 1;
+        }
 
         Ok(())
     }
+
+    // Blobs are shared between artifacts, so they're only removed once no
+    // remaining manifest references them. A blob stored by a concurrent run
+    // before its manifest is written can be removed, in which case that
+    // artifact is a cache miss when it's restored.
+    fn remove_unreferenced_blobs(&self, removed: &mut RemovedArtifacts) -> Result<(), CacheError> {
+        let mut referenced = HashSet::new();
+        for hash in self.hashes()? {
+            let manifest_path = self
+                .cache_directory
+                .join_component(&format!("{}-manifest.tar", hash));
+            // Legacy archives don't reference any blobs
+            let Ok(entries) =
+                CacheReader::open(&manifest_path).and_then(|mut reader| reader.entries())
+            else {
+                continue;
+            };
+            referenced.extend(entries.into_iter().filter_map(|entry| entry.digest));
+        }
+        removed.bytes += self.blob_store.remove_unreferenced(&referenced)?;
+
+        Ok(())
+    }
+}
+
+// Removes the temporary files of archives and blobs whose writes never
+// finished, e.g. because turbo crashed
+fn remove_stale_temp_files(
+    dir: &AbsoluteSystemPath,
+    removed: &mut RemovedArtifacts,
+) -> Result<(), CacheError> {
+    let entries = match std::fs::read_dir(dir) {
+        Ok(entries) => entries,
+        Err(e) if e.kind() == io::ErrorKind::NotFound => return Ok(()),
+        Err(e) => return Err(e.into()),
+    };
+
+    let cutoff = SystemTime::now() - STALE_TEMP_FILE_AGE;
+    for entry in entries {
+        let entry = entry?;
+        if !entry
+            .file_name()
+            .to_str()
+            .map_or(false, |file_name| file_name.ends_with(".tmp"))
+        {
+            continue;
+        }
+        let Ok(metadata) = entry.metadata() else {
+            continue;
+        };
+        if !metadata.is_file()
+            || metadata
+                .modified()
+                .map_or(true, |modified| modified > cutoff)
+        {
+            continue;
+        }
+        match std::fs::remove_file(entry.path()) {
+            Ok(()) => removed.bytes += metadata.len(),
+            Err(e) if e.kind() == io::ErrorKind::NotFound => (),
+            Err(e) => return Err(e.into()),
+        }
+    }
+
+    Ok(())
 }
 
 #[cfg(test)]
@@ -185,6 +865,7 @@ mod test {
     use anyhow::Result;
     use futures::future::try_join_all;
     use tempfile::tempdir;
+    use test_case::test_case;
     use turbopath::AnchoredSystemPath;
     use turborepo_analytics::start_analytics;
     use turborepo_api_client::{APIAuth, APIClient};
@@ -223,7 +904,7 @@ mod test {
             team_slug: None,
         };
         let (analytics_sender, analytics_handle) =
-            start_analytics(api_auth.clone(), api_client.clone());
+            start_analytics(api_auth.clone(), api_client.clone(), None);
 
         let cache = FSCache::new(None, repo_root_path, Some(analytics_sender.clone()))?;
 
@@ -247,6 +928,24 @@ mod test {
             }
         );
 
+        let info = cache.inspect(test_case.hash)?.unwrap();
+        assert_eq!(info.time_saved, test_case.duration);
+        assert_eq!(info.entries.len(), test_case.files.len());
+        for (expected, entry) in test_case.files.iter().zip(info.entries.iter()) {
+            assert_eq!(expected.path().to_unix().as_str(), entry.path);
+            let expected_kind = match expected.contents() {
+                Some(_) => ArtifactEntryKind::File,
+                None => ArtifactEntryKind::Directory,
+            };
+            assert_eq!(entry.kind, expected_kind);
+            assert_eq!(
+                entry.size,
+                expected
+                    .contents()
+                    .map_or(0, |contents| contents.len() as u64)
+            );
+        }
+
         assert_eq!(files.len(), test_case.files.len());
         for (expected, actual) in test_case.files.iter().zip(files.iter()) {
             let actual: &AnchoredSystemPath = actual;
@@ -262,4 +961,481 @@ mod test {
         analytics_handle.close_with_timeout().await;
         Ok(())
     }
+
+    #[test]
+    fn test_gc() -> Result<()> {
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPath::from_std_path(repo_root.path())?;
+        let test_case = &get_test_cases()[0];
+        test_case.initialize(repo_root_path)?;
+        let files: Vec<_> = test_case
+            .files
+            .iter()
+            .map(|f| f.path().to_owned())
+            .collect();
+
+        let cache = FSCache::new(None, repo_root_path, None)?;
+        cache.put(repo_root_path, "stale", &files, test_case.duration)?;
+        cache.put(repo_root_path, "fresh", &files, test_case.duration)?;
+        cache.put(repo_root_path, "legacy", &files, test_case.duration)?;
+
+        let day = 24 * 60 * 60;
+        let stale_metadata_path = cache.cache_directory.join_component("stale-meta.json");
+        let mut stale_metadata = CacheMetadata::read(&stale_metadata_path)?;
+        stale_metadata.created_at -= 10 * day;
+        stale_metadata.last_accessed_at -= 10 * day;
+        stale_metadata.write(&stale_metadata_path)?;
+        // Metadata from older versions of turbo falls back to the archive's mtime
+        cache
+            .cache_directory
+            .join_component("legacy-meta.json")
+            .create_with_contents(r#"{"hash":"legacy","duration":0}"#)?;
+
+        let removed = cache.gc(Duration::from_secs(day))?;
+
+        assert_eq!(removed.artifacts, 1);
+        assert!(removed.bytes > 0);
+        assert!(cache.exists("stale")?.is_none());
+        assert!(!stale_metadata_path.exists());
+        assert!(cache.exists("fresh")?.is_some());
+        assert!(cache.exists("legacy")?.is_some());
+        Ok(())
+    }
+
+    #[test]
+    fn test_gc_removes_stale_temp_files() -> Result<()> {
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPath::from_std_path(repo_root.path())?;
+        let cache = FSCache::new(None, repo_root_path, None)?;
+
+        let stale = cache
+            .cache_directory
+            .join_component("stale.tar.zst.1-0.tmp");
+        stale.create_with_contents("partial")?;
+        std::fs::File::options()
+            .write(true)
+            .open(stale.as_std_path())?
+            .set_modified(SystemTime::now() - 2 * STALE_TEMP_FILE_AGE)?;
+        // Could still be written by a concurrent run
+        let fresh = cache
+            .cache_directory
+            .join_component("fresh.tar.zst.1-1.tmp");
+        fresh.create_with_contents("partial")?;
+
+        let removed = cache.gc(Duration::from_secs(24 * 60 * 60))?;
+
+        assert_eq!(removed.artifacts, 0);
+        assert_eq!(removed.bytes, "partial".len() as u64);
+        assert!(!stale.exists());
+        assert!(fresh.exists());
+        Ok(())
+    }
+
+    #[test]
+    fn test_archive_round_trip() -> Result<()> {
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPath::from_std_path(repo_root.path())?;
+        let test_case = &get_test_cases()[0];
+        test_case.initialize(repo_root_path)?;
+
+        let mut body = Vec::new();
+        {
+            let mut writer = CacheWriter::from_writer(&mut body, true)?;
+            for file in &test_case.files {
+                writer.add_file(repo_root_path, file.path())?;
+            }
+        }
+        let archive = ArchivedArtifact {
+            body,
+            duration: test_case.duration,
+            tag: Some("signature".to_string()),
+            provenance: None,
+        };
+
+        let cache = FSCache::new(None, repo_root_path, None)?;
+        assert_eq!(cache.read_archive(test_case.hash)?, None);
+        cache.put_archive(test_case.hash, &archive)?;
+        assert_eq!(cache.read_archive(test_case.hash)?, Some(archive));
+
+        // Uploaded archives can be restored like any other artifact
+        let restore_root = tempdir()?;
+        let restore_root_path = AbsoluteSystemPath::from_std_path(restore_root.path())?;
+        let (metadata, files) = cache
+            .fetch(restore_root_path, test_case.hash)?
+            .expect("archive should be restored");
+        assert_eq!(metadata.time_saved, test_case.duration);
+        assert_eq!(files.len(), test_case.files.len());
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_fetch_matching_returns_restored_files() -> Result<()> {
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPath::from_std_path(repo_root.path())?;
+        let test_case = &get_test_cases()[1];
+        test_case.initialize(repo_root_path)?;
+
+        let files: Vec<_> = test_case
+            .files
+            .iter()
+            .map(|f| f.path().to_owned())
+            .collect();
+        let cache = FSCache::new(None, repo_root_path, None)?;
+        cache.put(repo_root_path, test_case.hash, &files, test_case.duration)?;
+
+        // Only the files that pass the filter are placed on disk, and the
+        // returned list must describe exactly those
+        let kept = files.last().expect("test case has files").clone();
+        let restore_root = tempdir()?;
+        let restore_root_path = AbsoluteSystemPath::from_std_path(restore_root.path())?;
+        let (_, restored) = cache
+            .fetch_matching(restore_root_path, test_case.hash, |path| path == &*kept)?
+            .expect("artifact should be restored");
+
+        assert_eq!(restored, vec![kept.clone()]);
+        assert!(restore_root_path.resolve(&kept).exists());
+        for skipped in files.iter().filter(|f| **f != kept) {
+            assert!(!restore_root_path.resolve(skipped).exists());
+        }
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_interrupted_put() -> Result<()> {
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPath::from_std_path(repo_root.path())?;
+        let test_case = &get_test_cases()[0];
+        test_case.initialize(repo_root_path)?;
+        let files: Vec<_> = test_case
+            .files
+            .iter()
+            .map(|f| f.path().to_owned())
+            .collect();
+
+        let cache = FSCache::new(None, repo_root_path, None)?;
+        cache.put(repo_root_path, test_case.hash, &files, test_case.duration)?;
+        // Every file is renamed into place
+        let temp_files = std::fs::read_dir(&cache.cache_directory)?
+            .filter(|entry| {
+                entry.as_ref().map_or(false, |entry| {
+                    entry.file_name().to_string_lossy().ends_with(".tmp")
+                })
+            })
+            .count();
+        assert_eq!(temp_files, 0);
+
+        // A put that's interrupted before its metadata is written leaves an
+        // incomplete artifact that must not be a hit
+        let [.., metadata_path] = cache.artifact_paths(test_case.hash);
+        metadata_path.remove_file()?;
+        assert!(cache.exists(test_case.hash)?.is_none());
+        assert!(cache.fetch(repo_root_path, test_case.hash)?.is_none());
+        assert!(cache.inspect(test_case.hash)?.is_none());
+
+        // and is replaced by the next put
+        cache.put(repo_root_path, test_case.hash, &files, test_case.duration)?;
+        assert!(cache.fetch(repo_root_path, test_case.hash)?.is_some());
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_remove_and_clear() -> Result<()> {
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPath::from_std_path(repo_root.path())?;
+        let test_case = &get_test_cases()[0];
+        test_case.initialize(repo_root_path)?;
+        let files: Vec<_> = test_case
+            .files
+            .iter()
+            .map(|f| f.path().to_owned())
+            .collect();
+
+        let cache = FSCache::new(None, repo_root_path, None)?;
+        for hash in ["one", "two", "three"] {
+            cache.put(repo_root_path, hash, &files, test_case.duration)?;
+        }
+        // Leftover metadata from an interrupted write is cleaned up too
+        cache
+            .cache_directory
+            .join_component("orphan-meta.json")
+            .create_with_contents(r#"{"hash":"orphan","duration":0}"#)?;
+
+        let removed = cache.remove("one")?.expect("artifact should be removed");
+        assert_eq!(removed.artifacts, 1);
+        assert!(removed.bytes > 0);
+        assert!(cache.exists("one")?.is_none());
+        assert!(cache.remove("one")?.is_none());
+
+        let removed = cache.clear()?;
+        assert_eq!(removed.artifacts, 3);
+        assert!(cache.cache_directory.exists());
+        // Only the empty blob store is left behind
+        let remaining = std::fs::read_dir(&cache.cache_directory)?
+            .map(|entry| Ok(entry?.file_name()))
+            .collect::<Result<Vec<_>>>()?;
+        assert_eq!(remaining, vec!["blobs"]);
+        assert_eq!(blob_count(&cache)?, 0);
+        Ok(())
+    }
+
+    #[test_case("apps/web/.turbo/turbo-build.log", Some(("apps/web", "build")) ; "package task")]
+    #[test_case(".turbo/turbo-lint.log", Some((".", "lint")) ; "root task")]
+    #[test_case("apps/web/.turbo/turbo-build$colon$prod.log", Some(("apps/web", "build:prod")) ; "escaped colon")]
+    #[test_case("apps/web/.turbo/turbo-build.failed", None ; "failure marker")]
+    #[test_case("apps/web/dist/turbo-build.log", None ; "not in log dir")]
+    fn test_task_from_log_path(path: &str, expected: Option<(&str, &str)>) {
+        assert_eq!(
+            task_from_log_path(path),
+            expected.map(|(dir, task)| (dir.to_string(), task.to_string()))
+        );
+    }
+
+    #[test]
+    fn test_list() -> Result<()> {
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPath::from_std_path(repo_root.path())?;
+        let log_file =
+            repo_root_path.join_components(&["apps", "web", ".turbo", "turbo-build.log"]);
+        log_file.ensure_dir()?;
+        log_file.create_with_contents("building")?;
+        let output = repo_root_path.join_components(&["apps", "web", "dist", "index.js"]);
+        output.ensure_dir()?;
+        output.create_with_contents("output")?;
+        let files = [
+            AnchoredSystemPathBuf::from_raw("apps/web/.turbo/turbo-build.log")?,
+            AnchoredSystemPathBuf::from_raw("apps/web/dist/index.js")?,
+        ];
+
+        let provenance = Provenance {
+            git_sha: Some("abc123".to_string()),
+            turbo_version: Some("2.0.0".to_string()),
+            ..Provenance::default()
+        };
+        let cache = FSCache::new(None, repo_root_path, None)?;
+        cache.put(repo_root_path, "older", &files[1..], 10)?;
+        cache.put_with_provenance(
+            repo_root_path,
+            "newer",
+            &files,
+            20,
+            Some(provenance.clone()),
+        )?;
+        let older_metadata_path = cache.cache_directory.join_component("older-meta.json");
+        let mut older_metadata = CacheMetadata::read(&older_metadata_path)?;
+        older_metadata.created_at -= 60;
+        older_metadata.last_accessed_at -= 60;
+        older_metadata.write(&older_metadata_path)?;
+
+        let listings = cache.list()?;
+
+        assert_eq!(
+            listings,
+            vec![
+                ArtifactListing {
+                    hash: "newer".to_string(),
+                    task: Some("build".to_string()),
+                    package_dir: Some("apps/web".to_string()),
+                    size: ("building".len() + "output".len()) as u64,
+                    time_saved: 20,
+                    last_used_at: listings[0].last_used_at,
+                    provenance: Some(provenance),
+                },
+                ArtifactListing {
+                    hash: "older".to_string(),
+                    task: None,
+                    package_dir: None,
+                    size: "output".len() as u64,
+                    time_saved: 10,
+                    last_used_at: older_metadata.last_accessed_at,
+                    provenance: None,
+                },
+            ]
+        );
+        Ok(())
+    }
+
+    #[test]
+    fn test_verify() -> Result<()> {
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPath::from_std_path(repo_root.path())?;
+        let test_case = &get_test_cases()[2];
+        test_case.initialize(repo_root_path)?;
+        let files: Vec<_> = test_case
+            .files
+            .iter()
+            .map(|f| f.path().to_owned())
+            .collect();
+
+        let cache = FSCache::new(None, repo_root_path, None)?;
+        cache.put(repo_root_path, "valid", &files, test_case.duration)?;
+        cache.put(repo_root_path, "corrupt", &files[..1], test_case.duration)?;
+        assert_eq!(cache.verify("valid")?, Some(Integrity::Valid));
+        assert_eq!(cache.verify("missing")?, None);
+
+        // Metadata written by older versions of turbo has no checksums
+        let metadata_path = cache.cache_directory.join_component("valid-meta.json");
+        let mut metadata = CacheMetadata::read(&metadata_path)?;
+        let checksums = metadata.checksums.take().expect("put records checksums");
+        metadata.write(&metadata_path)?;
+        assert_eq!(cache.verify("valid")?, Some(Integrity::Unverified));
+
+        // A checksum that doesn't match the manifest means it was tampered with
+        let (path, _) = checksums.iter().next().expect("artifact has files");
+        let mut tampered = checksums.clone();
+        tampered.insert(path.clone(), "0".repeat(64));
+        metadata.checksums = Some(tampered);
+        metadata.write(&metadata_path)?;
+        assert_eq!(
+            cache.verify("valid")?,
+            Some(Integrity::Corrupt {
+                files: vec![path.clone()]
+            })
+        );
+        metadata.checksums = Some(checksums);
+        metadata.write(&metadata_path)?;
+
+        // Corrupting a blob corrupts every artifact that references it
+        let entries = cache.inspect("corrupt")?.expect("artifact exists").entries;
+        let entry = entries
+            .iter()
+            .find(|entry| entry.digest.is_some())
+            .expect("artifact has a blob");
+        let digest = entry.digest.as_deref().unwrap();
+        std::fs::write(
+            cache
+                .blob_store
+                .root()
+                .join_components(&[&digest[..2], digest]),
+            "garbage",
+        )?;
+        assert_eq!(
+            cache.verify_all()?,
+            vec![
+                VerifiedArtifact {
+                    hash: "corrupt".to_string(),
+                    integrity: Integrity::Corrupt {
+                        files: vec![entry.path.clone()]
+                    },
+                },
+                VerifiedArtifact {
+                    hash: "valid".to_string(),
+                    integrity: Integrity::Corrupt {
+                        files: vec![entry.path.clone()]
+                    },
+                },
+            ]
+        );
+
+        // A corrupt artifact is a miss and is removed instead of being restored
+        let restore_root = tempdir()?;
+        let restore_root_path = AbsoluteSystemPath::from_std_path(restore_root.path())?;
+        assert!(cache.fetch(restore_root_path, "corrupt")?.is_none());
+        assert!(cache.artifact_path("corrupt").is_none());
+        assert!(!restore_root_path.join_component(&entry.path).exists());
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_fetch_matching_verifies_restored_blobs() -> Result<()> {
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPath::from_std_path(repo_root.path())?;
+        let test_case = &get_test_cases()[2];
+        test_case.initialize(repo_root_path)?;
+        let files: Vec<_> = test_case
+            .files
+            .iter()
+            .map(|f| f.path().to_owned())
+            .collect();
+
+        let cache = FSCache::new(None, repo_root_path, None)?;
+        cache.put(repo_root_path, "artifact", &files, test_case.duration)?;
+
+        let entries = cache.inspect("artifact")?.expect("artifact exists").entries;
+        let corrupt = entries
+            .iter()
+            .find(|entry| entry.path == "src/main.js")
+            .expect("artifact has src/main.js");
+        let digest = corrupt.digest.as_deref().expect("file is a blob");
+        std::fs::write(
+            cache
+                .blob_store
+                .root()
+                .join_components(&[&digest[..2], digest]),
+            "garbage",
+        )?;
+
+        // The corrupt blob isn't read when its file isn't being restored
+        let restore_root = tempdir()?;
+        let restore_root_path = AbsoluteSystemPath::from_std_path(restore_root.path())?;
+        let main_js = AnchoredSystemPathBuf::from_raw("src/main.js")?;
+        let (_, restored) = cache
+            .fetch_matching(restore_root_path, "artifact", |path| path != &*main_js)?
+            .expect("artifact is restored");
+        assert!(!restored.contains(&main_js));
+        assert!(restore_root_path.join_component("package.json").exists());
+
+        // Restoring the file finds it and treats the artifact as a miss
+        assert!(cache
+            .fetch_matching(restore_root_path, "artifact", |_| true)?
+            .is_none());
+        assert!(cache.artifact_path("artifact").is_none());
+        assert!(!restore_root_path
+            .join_components(&["src", "main.js"])
+            .exists());
+
+        Ok(())
+    }
+
+    fn blob_count(cache: &FSCache) -> Result<usize> {
+        let mut count = 0;
+        for shard in std::fs::read_dir(cache.cache_directory.join_component("blobs"))? {
+            count += std::fs::read_dir(shard?.path())?.count();
+        }
+        Ok(count)
+    }
+
+    #[test]
+    fn test_shared_blobs() -> Result<()> {
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPath::from_std_path(repo_root.path())?;
+        // Two files with distinct contents and a directory
+        let test_case = &get_test_cases()[2];
+        test_case.initialize(repo_root_path)?;
+        let files: Vec<_> = test_case
+            .files
+            .iter()
+            .map(|f| f.path().to_owned())
+            .collect();
+
+        let cache = FSCache::new(None, repo_root_path, None)?;
+        cache.put(repo_root_path, "first", &files, test_case.duration)?;
+        cache.put(repo_root_path, "second", &files, test_case.duration)?;
+        // Identical files across artifacts are only stored once
+        assert_eq!(blob_count(&cache)?, 3);
+
+        let removed = cache.remove("first")?.expect("artifact should be removed");
+        assert_eq!(removed.artifacts, 1);
+        assert_eq!(blob_count(&cache)?, 3);
+
+        // Shared blobs stay until the last artifact referencing them is removed
+        cache
+            .fetch(repo_root_path, "second")?
+            .expect("artifact should be restored");
+        for file in &test_case.files {
+            if let Some(contents) = file.contents() {
+                assert_eq!(
+                    repo_root_path.resolve(file.path()).read_to_string()?,
+                    contents
+                );
+            }
+        }
+
+        cache.remove("second")?.expect("artifact should be removed");
+        assert_eq!(blob_count(&cache)?, 0);
+        Ok(())
+    }
 }
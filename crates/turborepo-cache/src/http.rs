@@ -1,73 +1,183 @@
-use std::{backtrace::Backtrace, io::Write};
+use std::{
+    backtrace::Backtrace,
+    collections::HashMap,
+    io::Write,
+    sync::{
+        atomic::{AtomicU64, Ordering},
+        Mutex,
+    },
+    time::Instant,
+};
 
-use tracing::debug;
-use turbopath::{AbsoluteSystemPath, AbsoluteSystemPathBuf, AnchoredSystemPathBuf};
+use bytes::Bytes;
+use futures::{stream, StreamExt, TryStreamExt};
+use tokio::sync::{Semaphore, SemaphorePermit};
+use tracing::{debug, info};
+use turbopath::{
+    AbsoluteSystemPath, AbsoluteSystemPathBuf, AnchoredSystemPath, AnchoredSystemPathBuf,
+};
 use turborepo_analytics::AnalyticsSender;
 use turborepo_api_client::{
     analytics::{self, AnalyticsEvent},
-    APIAuth, APIClient, CacheClient, Response,
+    APIAuth, CacheClient, Response,
 };
 
 use crate::{
     cache_archive::{CacheReader, CacheWriter},
+    chunks::{chunk_hash, ChunkManifest},
+    fs::ArchivedArtifact,
     signature_authentication::ArtifactSignatureAuthenticator,
-    CacheError, CacheHitMetadata, CacheOpts, CacheSource,
+    ArtifactInfo, CacheError, CacheHitMetadata, CacheOpts, CacheSource, CacheUploadMetadata,
+    NetworkUsage, Provenance,
 };
 
 pub struct HTTPCache {
-    client: APIClient,
+    // Either the Vercel API or a custom provider configured in `turbo.json`
+    client: Box<dyn CacheClient + Send + Sync>,
     signer_verifier: Option<ArtifactSignatureAuthenticator>,
     repo_root: AbsoluteSystemPathBuf,
     api_auth: APIAuth,
     analytics_recorder: Option<AnalyticsSender>,
+    dereference_symlinks: bool,
+    upload_chunk_size: Option<u64>,
+    // Limit concurrent downloads and uploads separately, since proxies often
+    // rate limit one but not the other
+    read_limiter: Option<Semaphore>,
+    write_limiter: Option<Semaphore>,
+    max_artifact_size: Option<u64>,
+    // Bytes sent and received over the lifetime of the cache, which is a
+    // single run. Chunks and manifests are counted as they're transferred.
+    bytes_uploaded: AtomicU64,
+    bytes_downloaded: AtomicU64,
+    // Milliseconds spent on uploads and downloads, including misses
+    upload_millis: AtomicU64,
+    download_millis: AtomicU64,
+    // Size of each artifact that was downloaded, keyed by hash
+    downloads: Mutex<HashMap<String, u64>>,
+    // Where each downloaded artifact was built, if it was uploaded with it
+    provenances: Mutex<HashMap<String, Provenance>>,
 }
 
+// How many chunks of an artifact are uploaded or downloaded at once
+const MAX_CONCURRENT_CHUNKS: usize = 8;
+
 impl HTTPCache {
     #[tracing::instrument(skip_all)]
     pub fn new(
-        client: APIClient,
+        client: impl CacheClient + Send + Sync + 'static,
         opts: &CacheOpts,
         repo_root: AbsoluteSystemPathBuf,
         api_auth: APIAuth,
         analytics_recorder: Option<AnalyticsSender>,
     ) -> HTTPCache {
+        let upload_chunk_size = opts
+            .remote_cache_opts
+            .as_ref()
+            .and_then(|remote_cache_opts| remote_cache_opts.upload_chunk_size);
         let signer_verifier = if opts
             .remote_cache_opts
             .as_ref()
             .map_or(false, |remote_cache_opts| remote_cache_opts.signature)
         {
-            Some(ArtifactSignatureAuthenticator {
-                team_id: api_auth
+            Some(ArtifactSignatureAuthenticator::new(
+                api_auth
                     .team_id
                     .as_deref()
                     .unwrap_or_default()
                     .as_bytes()
                     .to_vec(),
-                secret_key_override: None,
-            })
+                None,
+            ))
         } else {
             None
         };
 
         HTTPCache {
-            client,
+            client: Box::new(client),
             signer_verifier,
             repo_root,
             api_auth,
             analytics_recorder,
+            dereference_symlinks: opts.dereference_symlinks,
+            upload_chunk_size,
+            read_limiter: opts
+                .remote_read_concurrency
+                .map(|limit| Semaphore::new(limit as usize)),
+            write_limiter: opts
+                .remote_write_concurrency
+                .map(|limit| Semaphore::new(limit as usize)),
+            max_artifact_size: opts.max_artifact_size,
+            bytes_uploaded: AtomicU64::new(0),
+            bytes_downloaded: AtomicU64::new(0),
+            upload_millis: AtomicU64::new(0),
+            download_millis: AtomicU64::new(0),
+            downloads: Mutex::new(HashMap::new()),
+            provenances: Mutex::new(HashMap::new()),
         }
     }
 
-    #[tracing::instrument(skip_all)]
+    /// The number of bytes uploaded and downloaded so far, and how long that
+    /// took
+    pub fn network_usage(&self) -> NetworkUsage {
+        NetworkUsage {
+            bytes_uploaded: self.bytes_uploaded.load(Ordering::Relaxed),
+            bytes_downloaded: self.bytes_downloaded.load(Ordering::Relaxed),
+            upload_duration: self.upload_millis.load(Ordering::Relaxed),
+            download_duration: self.download_millis.load(Ordering::Relaxed),
+        }
+    }
+
+    /// The size of the artifact downloaded for the given hash, if it was
+    pub fn downloaded_bytes(&self, hash: &str) -> Option<u64> {
+        self.downloads
+            .lock()
+            .expect("downloads mutex poisoned")
+            .get(hash)
+            .copied()
+    }
+
+    /// Where the artifact for the given hash was built, if it was downloaded
+    /// and uploaded with its provenance
+    pub fn provenance(&self, hash: &str) -> Option<Provenance> {
+        self.provenances
+            .lock()
+            .expect("provenances mutex poisoned")
+            .get(hash)
+            .cloned()
+    }
+
+    // Waits until another request is allowed, if requests are limited. Each
+    // chunk of an artifact is a request of its own.
+    async fn permit(limiter: &Option<Semaphore>) -> Option<SemaphorePermit<'_>> {
+        limiter.as_ref()?.acquire().await.ok()
+    }
+
+    // Whether artifacts can be verified, but not signed, so none can be
+    // uploaded
+    pub fn is_verify_only(&self) -> bool {
+        self.signer_verifier
+            .as_ref()
+            .map_or(false, |signer_verifier| signer_verifier.is_verify_only())
+    }
+
+    #[tracing::instrument(skip_all, fields(bytes = tracing::field::Empty))]
     pub async fn put(
         &self,
         anchor: &AbsoluteSystemPath,
         hash: &str,
         files: &[AnchoredSystemPathBuf],
         duration: u64,
-    ) -> Result<(), CacheError> {
+    ) -> Result<CacheUploadMetadata, CacheError> {
         let mut artifact_body = Vec::new();
         self.write(&mut artifact_body, anchor, files).await?;
+        let size = artifact_body.len() as u64;
+        if let Some(limit) = self.max_artifact_size.filter(|limit| size > *limit) {
+            return Err(CacheError::ArtifactTooLarge(
+                size,
+                limit,
+                Backtrace::capture(),
+            ));
+        }
 
         let tag = self
             .signer_verifier
@@ -75,18 +185,91 @@ impl HTTPCache {
             .map(|signer| signer.generate_tag(hash.as_bytes(), &artifact_body))
             .transpose()?;
 
+        tracing::Span::current().record("bytes", size);
+        let upload_start = Instant::now();
+        let uploaded = self
+            .upload(hash, &artifact_body, duration, tag.as_deref())
+            .await;
+        self.upload_millis
+            .fetch_add(upload_start.elapsed().as_millis() as u64, Ordering::Relaxed);
+        uploaded?;
+
+        let upload = CacheUploadMetadata {
+            bytes: artifact_body.len() as u64,
+            duration: upload_start.elapsed(),
+        };
+        info!(
+            "uploaded {hash} to remote cache: {} bytes in {}ms",
+            upload.bytes,
+            upload.duration.as_millis()
+        );
+
+        Ok(upload)
+    }
+
+    async fn upload(
+        &self,
+        hash: &str,
+        artifact_body: &[u8],
+        duration: u64,
+        tag: Option<&str>,
+    ) -> Result<(), CacheError> {
+        match self.upload_chunk_size {
+            Some(chunk_size) if artifact_body.len() as u64 > chunk_size => {
+                let manifest = ChunkManifest::new(artifact_body.len() as u64, chunk_size);
+                self.put_chunks(hash, artifact_body, &manifest, duration)
+                    .await?;
+                // The manifest is uploaded last so that it's never found
+                // without all of its chunks
+                self.put_artifact(hash, &manifest.to_bytes(), duration, tag)
+                    .await
+            }
+            _ => self.put_artifact(hash, artifact_body, duration, tag).await,
+        }
+    }
+
+    async fn put_artifact(
+        &self,
+        hash: &str,
+        body: &[u8],
+        duration: u64,
+        tag: Option<&str>,
+    ) -> Result<(), CacheError> {
+        let _permit = Self::permit(&self.write_limiter).await;
         self.client
             .put_artifact(
                 hash,
-                &artifact_body,
+                body,
                 duration,
-                tag.as_deref(),
+                tag,
                 &self.api_auth.token,
                 self.api_auth.team_id.as_deref(),
                 self.api_auth.team_slug.as_deref(),
             )
             .await?;
+        self.bytes_uploaded
+            .fetch_add(body.len() as u64, Ordering::Relaxed);
+        Ok(())
+    }
 
+    // Each chunk is a request of its own, so a chunk that fails is retried
+    // without sending the rest of the artifact again
+    async fn put_chunks(
+        &self,
+        hash: &str,
+        body: &[u8],
+        manifest: &ChunkManifest,
+        duration: u64,
+    ) -> Result<(), CacheError> {
+        debug!("uploading {hash} in {} chunks", manifest.chunks.len());
+        stream::iter(manifest.ranges()?.into_iter().enumerate())
+            .map(|(index, range)| async move {
+                self.put_artifact(&chunk_hash(hash, index), &body[range], duration, None)
+                    .await
+            })
+            .buffer_unordered(MAX_CONCURRENT_CHUNKS)
+            .try_collect::<Vec<_>>()
+            .await?;
         Ok(())
     }
 
@@ -97,7 +280,8 @@ impl HTTPCache {
         anchor: &AbsoluteSystemPath,
         files: &[AnchoredSystemPathBuf],
     ) -> Result<(), CacheError> {
-        let mut cache_archive = CacheWriter::from_writer(writer, true)?;
+        let mut cache_archive =
+            CacheWriter::from_writer(writer, true)?.dereference_symlinks(self.dereference_symlinks);
         for file in files {
             cache_archive.add_file(anchor, file)?;
         }
@@ -107,6 +291,7 @@ impl HTTPCache {
 
     #[tracing::instrument(skip_all)]
     pub async fn exists(&self, hash: &str) -> Result<Option<CacheHitMetadata>, CacheError> {
+        let _permit = Self::permit(&self.read_limiter).await;
         let Some(response) = self
             .client
             .artifact_exists(
@@ -142,15 +327,23 @@ impl HTTPCache {
         }
     }
 
-    fn log_fetch(&self, event: analytics::CacheEvent, hash: &str, duration: u64) {
+    fn log_fetch(
+        &self,
+        event: analytics::CacheEvent,
+        hash: &str,
+        duration: u64,
+        bytes: Option<u64>,
+    ) {
         // If analytics fails to record, it's not worth failing the cache
         if let Some(analytics_recorder) = &self.analytics_recorder {
             let analytics_event = AnalyticsEvent {
                 session_id: None,
+                run_id: None,
                 source: analytics::CacheSource::Remote,
                 event,
                 hash: hash.to_string(),
                 duration,
+                bytes,
             };
             debug!("logging fetch: {analytics_event:?}");
             let _ = analytics_recorder.send(analytics_event);
@@ -162,6 +355,98 @@ impl HTTPCache {
         &self,
         hash: &str,
     ) -> Result<Option<(CacheHitMetadata, Vec<AnchoredSystemPathBuf>)>, CacheError> {
+        self.fetch_matching(hash, |_| true).await
+    }
+
+    /// Like `fetch`, but only restores the files accepted by `filter`
+    #[tracing::instrument(skip_all)]
+    pub async fn fetch_matching(
+        &self,
+        hash: &str,
+        filter: impl Fn(&AnchoredSystemPath) -> bool,
+    ) -> Result<Option<(CacheHitMetadata, Vec<AnchoredSystemPathBuf>)>, CacheError> {
+        let Some((body, duration, _)) = self.retrieve(hash).await? else {
+            self.log_fetch(analytics::CacheEvent::Miss, hash, 0, None);
+            return Ok(None);
+        };
+
+        let files = Self::restore_tar(&self.repo_root, &body, filter)?;
+
+        self.log_fetch(
+            analytics::CacheEvent::Hit,
+            hash,
+            duration,
+            Some(body.len() as u64),
+        );
+        Ok(Some((
+            CacheHitMetadata {
+                source: CacheSource::Remote,
+                time_saved: duration,
+            },
+            files,
+        )))
+    }
+
+    /// Downloads an artifact and lists its contents without restoring it
+    #[tracing::instrument(skip_all)]
+    pub async fn inspect(&self, hash: &str) -> Result<Option<ArtifactInfo>, CacheError> {
+        let Some((body, duration, _)) = self.retrieve(hash).await? else {
+            return Ok(None);
+        };
+
+        let entries = CacheReader::from_reader(&body[..], true)?.entries()?;
+
+        Ok(Some(ArtifactInfo {
+            source: CacheSource::Remote,
+            time_saved: duration,
+            entries,
+        }))
+    }
+
+    /// Downloads an artifact without restoring it, e.g. to store it in the
+    /// local cache ahead of a run
+    #[tracing::instrument(skip_all)]
+    pub async fn fetch_archive(&self, hash: &str) -> Result<Option<ArchivedArtifact>, CacheError> {
+        let Some((body, duration, tag)) = self.retrieve(hash).await? else {
+            return Ok(None);
+        };
+
+        Ok(Some(ArchivedArtifact {
+            body: body.to_vec(),
+            duration,
+            tag,
+            provenance: self.provenance(hash),
+        }))
+    }
+
+    /// Downloads and verifies an artifact, returning its body, the duration
+    /// of the task that produced it and its tag if it was signed
+    #[tracing::instrument(skip_all, fields(bytes = tracing::field::Empty))]
+    async fn retrieve(
+        &self,
+        hash: &str,
+    ) -> Result<Option<(Bytes, u64, Option<String>)>, CacheError> {
+        let download_start = Instant::now();
+        let retrieved = self.download(hash).await;
+        self.download_millis.fetch_add(
+            download_start.elapsed().as_millis() as u64,
+            Ordering::Relaxed,
+        );
+        if let Ok(Some((body, ..))) = &retrieved {
+            tracing::Span::current().record("bytes", body.len());
+            self.downloads
+                .lock()
+                .expect("downloads mutex poisoned")
+                .insert(hash.to_string(), body.len() as u64);
+        }
+        retrieved
+    }
+
+    async fn download(
+        &self,
+        hash: &str,
+    ) -> Result<Option<(Bytes, u64, Option<String>)>, CacheError> {
+        let permit = Self::permit(&self.read_limiter).await;
         let Some(response) = self
             .client
             .fetch_artifact(
@@ -172,64 +457,127 @@ impl HTTPCache {
             )
             .await?
         else {
-            self.log_fetch(analytics::CacheEvent::Miss, hash, 0);
             return Ok(None);
         };
 
         let duration = Self::get_duration_from_response(&response)?;
+        if let Some(provenance) = Provenance::from_headers(response.headers()) {
+            self.provenances
+                .lock()
+                .expect("provenances mutex poisoned")
+                .insert(hash.to_string(), provenance);
+        }
+        let tag = response
+            .headers()
+            .get("x-artifact-tag")
+            .and_then(|tag| tag.to_str().ok())
+            .map(|tag| tag.to_string());
 
-        let body = if let Some(signer_verifier) = &self.signer_verifier {
+        let expected_tag = if self.signer_verifier.is_some() {
             let expected_tag = response
                 .headers()
                 .get("x-artifact-tag")
                 .ok_or(CacheError::ArtifactTagMissing(Backtrace::capture()))?;
 
-            let expected_tag = expected_tag
-                .to_str()
-                .map_err(|_| CacheError::InvalidTag(Backtrace::capture()))?
-                .to_string();
-
-            let body = response.bytes().await.map_err(|e| {
-                CacheError::ApiClientError(
-                    Box::new(turborepo_api_client::Error::ReqwestError(e)),
-                    Backtrace::capture(),
-                )
-            })?;
+            Some(
+                expected_tag
+                    .to_str()
+                    .map_err(|_| CacheError::InvalidTag(Backtrace::capture()))?
+                    .to_string(),
+            )
+        } else {
+            None
+        };
+
+        let mut body = self.read_body(response).await?;
+        // The chunks take permits of their own
+        drop(permit);
+        if let Some(manifest) = ChunkManifest::from_bytes(&body) {
+            let Some(chunked_body) = self.fetch_chunks(hash, &manifest).await? else {
+                return Ok(None);
+            };
+            body = chunked_body;
+        }
+
+        // A chunked artifact is signed as a whole
+        if let (Some(signer_verifier), Some(expected_tag)) = (&self.signer_verifier, expected_tag) {
             let is_valid = signer_verifier.validate(hash.as_bytes(), &body, &expected_tag)?;
 
             if !is_valid {
                 return Err(CacheError::InvalidTag(Backtrace::capture()));
             }
+        }
 
-            body
-        } else {
-            response.bytes().await.map_err(|e| {
-                CacheError::ApiClientError(
-                    Box::new(turborepo_api_client::Error::ReqwestError(e)),
-                    Backtrace::capture(),
-                )
-            })?
-        };
+        Ok(Some((body, duration, tag)))
+    }
 
-        let files = Self::restore_tar(&self.repo_root, &body)?;
+    // Returns `None` if any of the chunks is missing, e.g. because it was
+    // evicted, so that the artifact is treated as a miss
+    async fn fetch_chunks(
+        &self,
+        hash: &str,
+        manifest: &ChunkManifest,
+    ) -> Result<Option<Bytes>, CacheError> {
+        debug!("downloading {hash} in {} chunks", manifest.chunks.len());
+        let ranges = manifest.ranges()?;
+        let chunks = stream::iter(0..manifest.chunks.len())
+            .map(|index| async move {
+                let _permit = Self::permit(&self.read_limiter).await;
+                let Some(response) = self
+                    .client
+                    .fetch_artifact(
+                        &chunk_hash(hash, index),
+                        &self.api_auth.token,
+                        self.api_auth.team_id.as_deref(),
+                        self.api_auth.team_slug.as_deref(),
+                    )
+                    .await?
+                else {
+                    return Ok::<_, CacheError>(None);
+                };
+                Ok(Some(self.read_body(response).await?))
+            })
+            .buffered(MAX_CONCURRENT_CHUNKS)
+            .try_collect::<Vec<_>>()
+            .await?;
 
-        self.log_fetch(analytics::CacheEvent::Hit, hash, duration);
-        Ok(Some((
-            CacheHitMetadata {
-                source: CacheSource::Remote,
-                time_saved: duration,
-            },
-            files,
-        )))
+        // The body grows with the chunks that were actually received rather
+        // than being allocated up front from the manifest's sizes
+        let mut body = Vec::new();
+        for (index, (chunk, range)) in chunks.into_iter().zip(ranges).enumerate() {
+            let Some(chunk) = chunk else {
+                debug!("chunk {index} of {hash} is missing");
+                return Ok(None);
+            };
+            if chunk.len() != range.len() {
+                return Err(CacheError::CorruptChunk(index, Backtrace::capture()));
+            }
+            body.extend_from_slice(&chunk);
+        }
+
+        Ok(Some(body.into()))
+    }
+
+    async fn read_body(&self, response: Response) -> Result<Bytes, CacheError> {
+        let body = response.bytes().await.map_err(|e| {
+            CacheError::ApiClientError(
+                Box::new(turborepo_api_client::Error::ReqwestError(e)),
+                Backtrace::capture(),
+            )
+        })?;
+        self.bytes_downloaded
+            .fetch_add(body.len() as u64, Ordering::Relaxed);
+        Ok(body)
     }
 
     #[tracing::instrument(skip_all)]
     pub(crate) fn restore_tar(
         root: &AbsoluteSystemPath,
         body: &[u8],
+        filter: impl Fn(&AnchoredSystemPath) -> bool,
     ) -> Result<Vec<AnchoredSystemPathBuf>, CacheError> {
         let mut cache_reader = CacheReader::from_reader(body, true)?;
-        cache_reader.restore(root)
+        cache_reader.restore_matching(root, filter)
     }
 }
 
@@ -240,13 +588,14 @@ mod test {
     use tempfile::tempdir;
     use turbopath::AbsoluteSystemPathBuf;
     use turborepo_analytics::start_analytics;
-    use turborepo_api_client::{analytics, APIClient};
+    use turborepo_api_client::{analytics, APIClient, CacheClient};
     use turborepo_vercel_api_mock::start_test_server;
 
     use crate::{
+        chunks::{chunk_hash, ChunkManifest},
         http::{APIAuth, HTTPCache},
         test_cases::{get_test_cases, validate_analytics, TestCase},
-        CacheOpts, CacheSource,
+        CacheOpts, CacheSource, NetworkUsage, RemoteCacheOpts,
     };
 
     #[tokio::test]
@@ -284,7 +633,7 @@ mod test {
             team_slug: None,
         };
         let (analytics_recorder, analytics_handle) =
-            start_analytics(api_auth.clone(), api_client.clone());
+            start_analytics(api_auth.clone(), api_client.clone(), None);
 
         let cache = HTTPCache::new(
             api_client,
@@ -299,18 +648,35 @@ mod test {
         assert!(miss.is_none());
 
         let anchored_files: Vec<_> = files.iter().map(|f| f.path().to_owned()).collect();
-        cache
+        let upload = cache
             .put(&repo_root_path, hash, &anchored_files, duration)
             .await?;
+        let network_usage = cache.network_usage();
+        assert_eq!(network_usage.bytes_uploaded, upload.bytes);
+        assert_eq!(network_usage.bytes_downloaded, 0);
 
         let cache_response = cache.exists(hash).await?.unwrap();
 
         assert_eq!(cache_response.time_saved, duration);
         assert_eq!(cache_response.source, CacheSource::Remote);
 
+        let info = cache.inspect(hash).await?.unwrap();
+        assert_eq!(info.time_saved, duration);
+        assert_eq!(info.source, CacheSource::Remote);
+        let inspected_paths: Vec<_> = info.entries.iter().map(|entry| &entry.path).collect();
+        let expected_paths: Vec<_> = files
+            .iter()
+            .map(|f| f.path().to_unix().to_string())
+            .collect();
+        assert_eq!(inspected_paths, expected_paths.iter().collect::<Vec<_>>());
+
         let (cache_response, received_files) = cache.fetch(hash).await?.unwrap();
 
         assert_eq!(cache_response.time_saved, duration);
+        // The artifact was downloaded twice, once to inspect it and once to
+        // restore it
+        assert_eq!(cache.network_usage().bytes_downloaded, 2 * upload.bytes);
+        assert_eq!(cache.downloaded_bytes(hash), Some(upload.bytes));
 
         for (test_file, received_file) in files.iter().zip(received_files) {
             assert_eq!(&*received_file, test_file.path());
@@ -326,4 +692,96 @@ mod test {
 
         Ok(())
     }
+
+    #[tokio::test]
+    async fn test_chunked_upload() -> Result<()> {
+        let port = port_scanner::request_open_port().unwrap();
+        let handle = tokio::spawn(start_test_server(port));
+        let repo_root = tempdir()?;
+        let repo_root_path = AbsoluteSystemPathBuf::try_from(repo_root.path())?;
+        let test_case = &get_test_cases()[1];
+        test_case.initialize(&repo_root_path)?;
+
+        let api_client = APIClient::new(format!("http://localhost:{}", port), 200, "2.0.0", true)?;
+        // Chunks are still transferred one at a time when requests are limited
+        let opts = CacheOpts {
+            remote_read_concurrency: Some(1),
+            remote_write_concurrency: Some(1),
+            remote_cache_opts: Some(RemoteCacheOpts::new(None, false, None, Some(16))),
+            ..CacheOpts::default()
+        };
+        let api_auth = APIAuth {
+            team_id: Some("my-team".to_string()),
+            token: "my-token".to_string(),
+            team_slug: None,
+        };
+        let cache = HTTPCache::new(
+            api_client.clone(),
+            &opts,
+            repo_root_path.to_owned(),
+            api_auth,
+            None,
+        );
+
+        let hash = "chunked";
+        let files: Vec<_> = test_case
+            .files
+            .iter()
+            .map(|f| f.path().to_owned())
+            .collect();
+        let upload = cache
+            .put(&repo_root_path, hash, &files, test_case.duration)
+            .await?;
+
+        // The artifact itself is replaced by a manifest of its chunks
+        let manifest = api_client
+            .fetch_artifact(hash, "my-token", Some("my-team"), None)
+            .await?
+            .unwrap()
+            .bytes()
+            .await?;
+        let manifest = ChunkManifest::from_bytes(&manifest).unwrap();
+        assert!(manifest.chunks.len() > 1);
+        let ranges = manifest.ranges()?;
+        assert_eq!(ranges.last().unwrap().end as u64, upload.bytes);
+
+        let (hit, received_files) = cache.fetch(hash).await?.unwrap();
+        assert_eq!(hit.time_saved, test_case.duration);
+        assert_eq!(received_files, files);
+
+        // Chunks that don't match the manifest are reported as corrupt
+        api_client
+            .put_artifact(
+                &chunk_hash(hash, 0),
+                b"",
+                0,
+                None,
+                "my-token",
+                Some("my-team"),
+                None,
+            )
+            .await?;
+        assert!(cache.fetch(hash).await.is_err());
+
+        // Manifests with sizes that can't be allocated are rejected before
+        // any chunk is downloaded
+        let manifest = ChunkManifest {
+            chunks: vec![u64::MAX, u64::MAX],
+        };
+        api_client
+            .put_artifact(
+                hash,
+                &manifest.to_bytes(),
+                0,
+                None,
+                "my-token",
+                Some("my-team"),
+                None,
+            )
+            .await?;
+        assert!(cache.fetch(hash).await.is_err());
+
+        handle.abort();
+        Ok(())
+    }
 }
@@ -0,0 +1,176 @@
+use std::{
+    io,
+    time::{SystemTime, UNIX_EPOCH},
+};
+
+use serde::{Deserialize, Serialize};
+use tracing::debug;
+use turbopath::{AbsoluteSystemPath, AbsoluteSystemPathBuf, AnchoredSystemPathBuf};
+
+/// A cache write that was queued but hasn't finished
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub(crate) struct PendingWrite {
+    pub anchor: String,
+    pub key: String,
+    pub duration: u64,
+    pub files: Vec<AnchoredSystemPathBuf>,
+    // Milliseconds since the unix epoch
+    pub queued_at: u64,
+}
+
+/// Keeps a file for every pending write in `.turbo/pending-writes` until the
+/// write finishes. If turbo exits before then, the next run can queue the
+/// write again as long as the files haven't changed since.
+pub(crate) struct WriteJournal {
+    dir: AbsoluteSystemPathBuf,
+}
+
+impl WriteJournal {
+    pub fn new(repo_root: &AbsoluteSystemPath) -> Self {
+        Self {
+            dir: repo_root.join_components(&[".turbo", "pending-writes"]),
+        }
+    }
+
+    fn entry_path(&self, key: &str) -> AbsoluteSystemPathBuf {
+        self.dir.join_component(&format!("{key}.json"))
+    }
+
+    // Failing to journal a write only means it can't be resumed, so errors
+    // are logged rather than returned
+    pub fn record(
+        &self,
+        anchor: &AbsoluteSystemPath,
+        key: &str,
+        duration: u64,
+        files: &[AnchoredSystemPathBuf],
+    ) {
+        let write = PendingWrite {
+            anchor: anchor.to_string(),
+            key: key.to_string(),
+            duration,
+            files: files.to_vec(),
+            queued_at: now(),
+        };
+        let result = self.dir.create_dir_all().and_then(|_| {
+            let contents = serde_json::to_string(&write)?;
+            self.entry_path(key).create_with_contents(contents)
+        });
+        if let Err(err) = result {
+            debug!("failed to journal cache write for {key}: {err}");
+        }
+    }
+
+    pub fn finish(&self, key: &str) {
+        match self.entry_path(key).remove_file() {
+            Ok(()) => {}
+            Err(err) if err.kind() == io::ErrorKind::NotFound => {}
+            Err(err) => debug!("failed to remove journaled cache write for {key}: {err}"),
+        }
+    }
+
+    /// Returns the writes left behind by an interrupted run. Writes whose
+    /// files have since been removed or modified can't be resumed and are
+    /// discarded.
+    pub fn pending(&self) -> Vec<(AbsoluteSystemPathBuf, PendingWrite)> {
+        let Ok(entries) = std::fs::read_dir(&self.dir) else {
+            return Vec::new();
+        };
+
+        let mut pending = Vec::new();
+        for entry in entries.flatten() {
+            let Some(key) = entry
+                .file_name()
+                .to_str()
+                .and_then(|name| name.strip_suffix(".json"))
+                .map(|key| key.to_string())
+            else {
+                continue;
+            };
+            let resumable = self
+                .entry_path(&key)
+                .read_to_string()
+                .ok()
+                .and_then(|contents| serde_json::from_str::<PendingWrite>(&contents).ok())
+                .and_then(|write| {
+                    let anchor = AbsoluteSystemPathBuf::new(write.anchor.clone()).ok()?;
+                    unchanged_since(&anchor, &write).then_some((anchor, write))
+                });
+            match resumable {
+                Some(write) => pending.push(write),
+                None => {
+                    debug!("discarding journaled cache write for {key}");
+                    self.finish(&key);
+                }
+            }
+        }
+
+        pending
+    }
+}
+
+fn unchanged_since(anchor: &AbsoluteSystemPath, write: &PendingWrite) -> bool {
+    write.files.iter().all(|file| {
+        anchor
+            .resolve(file)
+            .symlink_metadata()
+            .ok()
+            .and_then(|metadata| metadata.modified().ok())
+            .map_or(false, |modified| to_millis(modified) <= write.queued_at)
+    })
+}
+
+fn now() -> u64 {
+    to_millis(SystemTime::now())
+}
+
+fn to_millis(time: SystemTime) -> u64 {
+    time.duration_since(UNIX_EPOCH)
+        .map_or(0, |duration| duration.as_millis() as u64)
+}
+
+#[cfg(test)]
+mod test {
+    use std::{fs::File, time::Duration};
+
+    use anyhow::Result;
+    use tempfile::tempdir;
+
+    use super::*;
+
+    #[test]
+    fn test_journal() -> Result<()> {
+        let dir = tempdir()?;
+        let repo_root = AbsoluteSystemPath::from_std_path(dir.path())?;
+        let journal = WriteJournal::new(repo_root);
+        assert!(journal.pending().is_empty());
+
+        let output = AnchoredSystemPathBuf::from_raw("dist.js")?;
+        repo_root.resolve(&output).create_with_contents("built")?;
+        journal.record(repo_root, "unchanged", 10, &[output.clone()]);
+        journal.record(repo_root, "finished", 10, &[output.clone()]);
+        journal.finish("finished");
+        let missing = AnchoredSystemPathBuf::from_raw("missing.js")?;
+        journal.record(repo_root, "missing", 10, &[missing]);
+
+        let modified = AnchoredSystemPathBuf::from_raw("modified.js")?;
+        repo_root.resolve(&modified).create_with_contents("built")?;
+        journal.record(repo_root, "modified", 10, &[modified.clone()]);
+        File::options()
+            .write(true)
+            .open(repo_root.resolve(&modified).as_std_path())?
+            .set_modified(SystemTime::now() + Duration::from_secs(60))?;
+
+        let pending = journal.pending();
+        assert_eq!(pending.len(), 1);
+        let (anchor, write) = &pending[0];
+        assert_eq!(anchor.as_path(), repo_root.as_path());
+        assert_eq!(write.key, "unchanged");
+        assert_eq!(write.files, vec![output]);
+
+        // Writes that can't be resumed are discarded
+        assert_eq!(journal.pending().len(), 1);
+        Ok(())
+    }
+}
@@ -5,12 +5,18 @@
 
 /// A wrapper for the cache that uses a worker pool to perform cache operations
 mod async_cache;
+/// Exports artifacts to a tarball that can be imported on another machine
+pub mod bundle;
 /// The core cache creation and restoration logic.
 pub mod cache_archive;
+/// Splits large artifacts into chunks for the remote cache
+mod chunks;
 /// File system cache
 pub mod fs;
 /// Remote cache
 pub mod http;
+/// Tracks pending writes so that they can be resumed after an interruption
+mod journal;
 /// A wrapper that allows reads and writes from the file system and remote
 /// cache.
 mod multiplexer;
@@ -20,12 +26,13 @@ pub mod signature_authentication;
 #[cfg(test)]
 mod test_cases;
 
-use std::{backtrace, backtrace::Backtrace};
+use std::{backtrace, backtrace::Backtrace, time::Duration};
 
 pub use async_cache::AsyncCache;
 use camino::Utf8PathBuf;
 use serde::{Deserialize, Serialize};
 use thiserror::Error;
+use turborepo_api_client::custom::CustomProvider;
 
 use crate::signature_authentication::SignatureError;
 
@@ -72,20 +79,77 @@ pub enum CacheError {
     InvalidMetadata(serde_json::Error, #[backtrace] Backtrace),
     #[error("Failed to write cache metadata file")]
     MetadataWriteFailure(serde_json::Error, #[backtrace] Backtrace),
+    #[error("cached file contents do not match their digest: {0}")]
+    CorruptBlob(String, #[backtrace] Backtrace),
+    #[error("no artifact for {0} found in the local cache")]
+    ArtifactNotFound(String, #[backtrace] Backtrace),
+    #[error("invalid cache bundle: {0}")]
+    InvalidBundle(String, #[backtrace] Backtrace),
+    #[error("artifact is {0} bytes, over the limit of {1} bytes")]
+    ArtifactTooLarge(u64, u64, #[backtrace] Backtrace),
+    #[error("chunk {0} of the artifact is corrupt")]
+    CorruptChunk(usize, #[backtrace] Backtrace),
+    #[error("chunked artifact has an invalid manifest")]
+    InvalidChunkManifest(#[backtrace] Backtrace),
     #[error("Unable to perform write as cache is shutting down")]
     CacheShuttingDown,
+    #[error("Cache operation was cancelled")]
+    Cancelled,
     #[error("Unable to determine config cache base")]
     ConfigCacheInvalidBase,
     #[error("Unable to hash config cache inputs")]
     ConfigCacheError,
 }
 
+/// Whether `hash` can be used as the name of an artifact. Hashes become file
+/// names, so anything that could escape the cache directory is rejected.
+pub fn is_valid_hash(hash: &str) -> bool {
+    !hash.is_empty()
+        && !hash.starts_with('.')
+        && hash
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || matches!(c, '-' | '_' | '.'))
+}
+
 impl From<turborepo_api_client::Error> for CacheError {
     fn from(value: turborepo_api_client::Error) -> Self {
         CacheError::ApiClientError(Box::new(value), Backtrace::capture())
     }
 }
 
+/// The type of an entry in a cache artifact
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "lowercase")]
+pub enum ArtifactEntryKind {
+    File,
+    Directory,
+    Symlink,
+}
+
+/// A single entry in a cache artifact, as stored in the archive
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ArtifactEntry {
+    pub path: String,
+    pub kind: ArtifactEntryKind,
+    pub size: u64,
+    pub mode: u32,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub link_target: Option<String>,
+    // The digest of the blob holding a file's contents when the artifact is
+    // stored in the local cache's blob store
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub digest: Option<String>,
+}
+
+/// The contents and metadata of a cache artifact, read without restoring it
+#[derive(Debug, Clone, PartialEq)]
+pub struct ArtifactInfo {
+    pub source: CacheSource,
+    pub time_saved: u64,
+    pub entries: Vec<ArtifactEntry>,
+}
+
 #[derive(Debug, Clone, PartialEq, Copy)]
 pub enum CacheSource {
     Local,
@@ -98,27 +162,297 @@ pub struct CacheHitMetadata {
     pub time_saved: u64,
 }
 
-#[derive(Debug, Default)]
+/// Whether an artifact is in the local and in the remote cache, each checked
+/// on its own rather than stopping at the first hit
+#[derive(Debug, Clone, PartialEq, Copy, Default)]
+pub struct CacheState {
+    pub local: Option<CacheHitMetadata>,
+    pub remote: Option<CacheHitMetadata>,
+}
+
+impl CacheState {
+    /// The hit that fetching the artifact would use, the local cache is
+    /// checked first
+    pub fn hit(&self) -> Option<CacheHitMetadata> {
+        self.local.or(self.remote)
+    }
+}
+
+/// The size and duration of an artifact upload to the remote cache
+#[derive(Debug, Clone, PartialEq, Copy)]
+pub struct CacheUploadMetadata {
+    pub bytes: u64,
+    pub duration: Duration,
+}
+
+/// How much of an artifact was downloaded from the remote cache for a hit,
+/// which is 0 for local hits, and how long restoring it took
+#[derive(Debug, Clone, PartialEq, Copy)]
+pub struct CacheDownloadMetadata {
+    pub bytes: u64,
+    pub duration: Duration,
+}
+
+/// The number of bytes sent to and received from the remote cache during a
+/// run, and the time spent doing so
+#[derive(Debug, Default, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct NetworkUsage {
+    pub bytes_uploaded: u64,
+    pub bytes_downloaded: u64,
+    // In milliseconds, summed over requests so concurrent transfers are
+    // counted more than once
+    pub upload_duration: u64,
+    pub download_duration: u64,
+}
+
+impl NetworkUsage {
+    pub fn is_empty(&self) -> bool {
+        self.bytes_uploaded == 0 && self.bytes_downloaded == 0
+    }
+}
+
+impl std::ops::Add for NetworkUsage {
+    type Output = Self;
+
+    fn add(self, other: Self) -> Self {
+        Self {
+            bytes_uploaded: self.bytes_uploaded + other.bytes_uploaded,
+            bytes_downloaded: self.bytes_downloaded + other.bytes_downloaded,
+            upload_duration: self.upload_duration + other.upload_duration,
+            download_duration: self.download_duration + other.download_duration,
+        }
+    }
+}
+
+#[derive(Debug, Default, Clone)]
 pub struct CacheOpts {
     pub override_dir: Option<Utf8PathBuf>,
     pub remote_cache_read_only: bool,
+    // Never read from any cache, but keep writing artifacts to them
+    pub write_only: bool,
     pub skip_remote: bool,
     pub skip_filesystem: bool,
     pub workers: u32,
+    // How many writes can wait for a worker before `put` waits for room in
+    // the queue
+    pub queue_size: u32,
+    // How long shutting down waits for writes before cancelling them, which
+    // is indefinitely when unset
+    pub drain_timeout: Option<Duration>,
+    // Store the targets of symlinks in outputs instead of the links themselves
+    pub dereference_symlinks: bool,
+    pub restore_mode: RestoreMode,
+    // Report every occurrence of a repeated warning instead of collapsing them
+    pub show_all_warnings: bool,
+    // Keeps artifacts separate from runs in other namespaces, e.g. other branches
+    pub namespace: Option<String>,
+    // Local artifacts that haven't been used for this long are removed when the
+    // cache shuts down
+    pub max_age: Option<Duration>,
+    // Limits on the number of concurrent requests to the remote cache, which
+    // are unlimited when unset
+    pub remote_read_concurrency: Option<u32>,
+    pub remote_write_concurrency: Option<u32>,
+    // Artifacts larger than this many bytes are only written to the local cache
+    pub max_artifact_size: Option<u64>,
+    // Recorded with every artifact this run writes
+    pub provenance: Option<Provenance>,
     pub remote_cache_opts: Option<RemoteCacheOpts>,
 }
 
+/// Where an artifact was built. It's stored in the artifact's metadata in the
+/// local cache and sent as headers to the remote cache, so that a restored
+/// output can be traced back to the machine and commit that produced it.
+#[derive(Debug, Default, Clone, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct Provenance {
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub git_sha: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub git_branch: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub turbo_version: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub hostname: Option<String>,
+    // The operating system and architecture, e.g. `darwin-arm64`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub platform: Option<String>,
+}
+
+const PROVENANCE_HEADERS: [&str; 5] = [
+    "x-artifact-git-sha",
+    "x-artifact-git-branch",
+    "x-artifact-turbo-version",
+    "x-artifact-hostname",
+    "x-artifact-platform",
+];
+
+impl Provenance {
+    fn fields_mut(&mut self) -> [&mut Option<String>; 5] {
+        [
+            &mut self.git_sha,
+            &mut self.git_branch,
+            &mut self.turbo_version,
+            &mut self.hostname,
+            &mut self.platform,
+        ]
+    }
+
+    /// The headers that an artifact is uploaded with. Values that can't be
+    /// sent in a header, e.g. branch names with non-ASCII characters, are left
+    /// out.
+    pub fn headers(&self) -> Vec<(String, String)> {
+        let mut provenance = self.clone();
+        PROVENANCE_HEADERS
+            .iter()
+            .zip(provenance.fields_mut())
+            .filter_map(|(name, value)| Some((name.to_string(), value.take()?)))
+            .filter(|(_, value)| {
+                value
+                    .bytes()
+                    .all(|byte| byte.is_ascii_graphic() || byte == b' ')
+            })
+            .collect()
+    }
+
+    /// Reads the provenance of a downloaded artifact, if it was uploaded
+    /// with any
+    pub fn from_headers(headers: &reqwest::header::HeaderMap) -> Option<Self> {
+        let mut provenance = Provenance::default();
+        for (name, field) in PROVENANCE_HEADERS.iter().zip(provenance.fields_mut()) {
+            *field = headers
+                .get(*name)
+                .and_then(|value| value.to_str().ok())
+                .map(|value| value.to_string());
+        }
+        (provenance != Provenance::default()).then_some(provenance)
+    }
+}
+
 #[derive(Debug, Default, Clone, Serialize, Deserialize, PartialEq, Eq)]
 pub struct RemoteCacheOpts {
     unused_team_id: Option<String>,
     signature: bool,
+    // Used in place of the Vercel API when set
+    custom_provider: Option<CustomProvider>,
+    // Artifacts larger than this many bytes are uploaded in chunks
+    upload_chunk_size: Option<u64>,
+    // Read in order after the primary remote cache misses
+    #[serde(default)]
+    fallbacks: Vec<RemoteCacheFallback>,
+    #[serde(default)]
+    write_policy: RemoteCacheWritePolicy,
+}
+
+/// A remote cache that is read when the remote caches before it miss
+#[derive(Debug, Clone, Serialize, Deserialize, PartialEq, Eq)]
+#[serde(rename_all = "camelCase")]
+pub enum RemoteCacheFallback {
+    /// The Vercel API, which is only used once the repository is linked
+    Vercel,
+    Custom(CustomProvider),
+}
+
+/// How files are restored from the local cache. Hard links are the fastest
+/// but share their contents with the cache, so an output that's modified in
+/// place after being restored also modifies the cached artifact. Copies and
+/// reflinks are independent of the cache.
+#[derive(Debug, Default, Clone, Copy, PartialEq, Eq)]
+pub enum RestoreMode {
+    #[default]
+    Hardlink,
+    Copy,
+    // Copy-on-write clones, which are copies on file systems that don't
+    // support them. Files are also cloned into the cache when they're saved.
+    Reflink,
+}
+
+/// Which of the remote caches artifacts are uploaded to
+#[derive(Debug, Default, Clone, Copy, Serialize, Deserialize, PartialEq, Eq)]
+#[serde(rename_all = "kebab-case")]
+pub enum RemoteCacheWritePolicy {
+    /// Only the first remote cache that accepts uploads, e.g. a proxy that
+    /// forwards artifacts to the caches behind it
+    #[default]
+    WriteFirst,
+    /// Every remote cache that accepts uploads
+    WriteAll,
 }
 
 impl RemoteCacheOpts {
-    pub fn new(unused_team_id: Option<String>, signature: bool) -> Self {
+    pub fn new(
+        unused_team_id: Option<String>,
+        signature: bool,
+        custom_provider: Option<CustomProvider>,
+        upload_chunk_size: Option<u64>,
+    ) -> Self {
         Self {
             unused_team_id,
             signature,
+            custom_provider,
+            upload_chunk_size,
+            fallbacks: Vec::new(),
+            write_policy: RemoteCacheWritePolicy::default(),
         }
     }
+
+    pub fn with_fallbacks(
+        mut self,
+        fallbacks: Vec<RemoteCacheFallback>,
+        write_policy: RemoteCacheWritePolicy,
+    ) -> Self {
+        self.fallbacks = fallbacks;
+        self.write_policy = write_policy;
+        self
+    }
+}
+
+#[cfg(test)]
+mod test {
+    use reqwest::header::{HeaderMap, HeaderName, HeaderValue};
+
+    use super::Provenance;
+
+    #[test]
+    fn test_provenance_headers() {
+        let provenance = Provenance {
+            git_sha: Some("abc123".to_string()),
+            git_branch: Some("feat/ünicode".to_string()),
+            turbo_version: Some("2.0.0".to_string()),
+            hostname: None,
+            platform: Some("darwin-arm64".to_string()),
+        };
+        let headers = provenance.headers();
+        // The branch can't be sent in a header
+        assert_eq!(
+            headers,
+            vec![
+                ("x-artifact-git-sha".to_string(), "abc123".to_string()),
+                ("x-artifact-turbo-version".to_string(), "2.0.0".to_string()),
+                (
+                    "x-artifact-platform".to_string(),
+                    "darwin-arm64".to_string()
+                ),
+            ]
+        );
+
+        let header_map: HeaderMap = headers
+            .into_iter()
+            .map(|(name, value)| {
+                (
+                    HeaderName::try_from(name).unwrap(),
+                    HeaderValue::try_from(value).unwrap(),
+                )
+            })
+            .collect();
+        assert_eq!(
+            Provenance::from_headers(&header_map),
+            Some(Provenance {
+                git_branch: None,
+                ..provenance
+            })
+        );
+        assert_eq!(Provenance::from_headers(&HeaderMap::new()), None);
+    }
 }
@@ -1,24 +1,86 @@
-use std::sync::atomic::{AtomicBool, Ordering};
+use std::{
+    borrow::Cow,
+    collections::{HashMap, HashSet},
+    sync::{
+        atomic::{AtomicBool, Ordering},
+        Arc, Mutex,
+    },
+    time::Duration,
+};
 
 use tracing::{debug, warn};
-use turbopath::{AbsoluteSystemPath, AnchoredSystemPathBuf};
+use turbopath::{AbsoluteSystemPath, AnchoredSystemPath, AnchoredSystemPathBuf};
 use turborepo_analytics::AnalyticsSender;
 use turborepo_api_client::{APIAuth, APIClient};
+use turborepo_ui::Warnings;
 
-use crate::{fs::FSCache, http::HTTPCache, CacheError, CacheHitMetadata, CacheOpts};
+use crate::{
+    fs::FSCache, http::HTTPCache, CacheError, CacheHitMetadata, CacheOpts, CacheSource, CacheState,
+    CacheUploadMetadata, NetworkUsage, Provenance, RemoteCacheFallback, RemoteCacheWritePolicy,
+};
 
-pub struct CacheMultiplexer {
+// The result of a successful remote fetch that can be shared with other
+// fetches of the same hash.
+type RemoteFetch = Arc<tokio::sync::Mutex<Option<(CacheHitMetadata, Vec<AnchoredSystemPathBuf>)>>>;
+
+// Removes a remote fetch from the map once the last fetch of its hash is done,
+// so the map only holds the fetches that are in progress
+struct RemoteFetchGuard<'a> {
+    remote_fetches: &'a Mutex<HashMap<String, RemoteFetch>>,
+    key: String,
+    fetch: RemoteFetch,
+}
+
+impl Drop for RemoteFetchGuard<'_> {
+    fn drop(&mut self) {
+        let mut remote_fetches = self
+            .remote_fetches
+            .lock()
+            .expect("remote fetches mutex poisoned");
+        // Fetches are only cloned while the map is locked, so if the map holds
+        // the only other reference nothing else is waiting on this fetch
+        let is_last = remote_fetches
+            .get(&self.key)
+            .is_some_and(|fetch| Arc::ptr_eq(fetch, &self.fetch))
+            && Arc::strong_count(&self.fetch) == 2;
+        if is_last {
+            remote_fetches.remove(&self.key);
+        }
+    }
+}
+
+// One of the remote caches, in the order they're read
+struct RemoteCache {
+    http: HTTPCache,
     // We use an `AtomicBool` instead of removing the cache because that would require
-    // wrapping the cache in a `Mutex` which would cause a lot of contention.
+    // wrapping the caches in a `Mutex` which would cause a lot of contention.
     // This does create a mild race condition where we might use the cache
     // even though another thread might be removing it, but that's fine.
-    should_use_http_cache: AtomicBool,
+    enabled: AtomicBool,
+}
+
+pub struct CacheMultiplexer {
     // Just for keeping track of whether we've already printed a warning about the remote cache
     // being read-only
     should_print_skipping_remote_put: AtomicBool,
     remote_cache_read_only: bool,
+    // Used by jobs that warm the cache, which must always execute their tasks
+    write_only: bool,
     fs: Option<FSCache>,
-    http: Option<HTTPCache>,
+    remotes: Vec<RemoteCache>,
+    write_policy: RemoteCacheWritePolicy,
+    // Remote fetches in progress keyed by hash. Tasks that resolve to the same
+    // hash wait on the first fetch instead of downloading the artifact again.
+    remote_fetches: Mutex<HashMap<String, RemoteFetch>>,
+    // Hashes that none of the remote caches had when they were checked, so that
+    // fetching them doesn't ask the remote caches again
+    remote_misses: Mutex<HashSet<String>>,
+    // Warnings that can repeat for every task, with repeats counted until the
+    // cache shuts down
+    warnings: Warnings,
+    // Prefixed onto every key so that namespaces don't share artifacts
+    namespace: Option<String>,
+    max_age: Option<Duration>,
 }
 
 impl CacheMultiplexer {
@@ -47,41 +109,167 @@ impl CacheMultiplexer {
                     repo_root,
                     analytics_recorder.clone(),
                 )
+                .map(|cache| {
+                    cache
+                        .with_dereference_symlinks(opts.dereference_symlinks)
+                        .with_restore_mode(opts.restore_mode)
+                        .with_provenance(opts.provenance.clone())
+                })
             })
             .transpose()?;
 
-        let http_cache = use_http_cache
-            .then_some(api_auth)
-            .flatten()
-            .map(|api_auth| {
-                HTTPCache::new(
-                    api_client,
-                    opts,
-                    repo_root.to_owned(),
-                    api_auth,
-                    analytics_recorder.clone(),
-                )
-            });
+        // Uploads record where they were built
+        let api_client = api_client.with_artifact_headers(
+            opts.provenance
+                .as_ref()
+                .map(Provenance::headers)
+                .unwrap_or_default(),
+        );
+        let remote_cache_opts = opts.remote_cache_opts.clone().unwrap_or_default();
+        // The primary remote cache is the custom provider if there is one, and the
+        // Vercel API otherwise
+        let primary = match remote_cache_opts.custom_provider {
+            Some(provider) => RemoteCacheFallback::Custom(provider),
+            None => RemoteCacheFallback::Vercel,
+        };
+        let remotes: Vec<_> = if use_http_cache {
+            // Custom providers don't require being linked to a team, or even a token
+            // if they don't use an auth header
+            let custom_auth = || {
+                api_auth.clone().unwrap_or_else(|| APIAuth {
+                    team_id: None,
+                    token: String::new(),
+                    team_slug: None,
+                })
+            };
+            std::iter::once(primary)
+                .chain(remote_cache_opts.fallbacks)
+                .map(|remote| -> Result<_, CacheError> {
+                    Ok(match remote {
+                        RemoteCacheFallback::Custom(provider) if provider.is_bazel() => {
+                            Some(HTTPCache::new(
+                                api_client.bazel_cache_client(provider)?,
+                                opts,
+                                repo_root.to_owned(),
+                                custom_auth(),
+                                analytics_recorder.clone(),
+                            ))
+                        }
+                        RemoteCacheFallback::Custom(provider) => Some(HTTPCache::new(
+                            api_client.custom_cache_client(provider),
+                            opts,
+                            repo_root.to_owned(),
+                            custom_auth(),
+                            analytics_recorder.clone(),
+                        )),
+                        RemoteCacheFallback::Vercel => api_auth.clone().map(|api_auth| {
+                            HTTPCache::new(
+                                api_client.clone(),
+                                opts,
+                                repo_root.to_owned(),
+                                api_auth,
+                                analytics_recorder.clone(),
+                            )
+                        }),
+                    })
+                })
+                .collect::<Result<Vec<_>, CacheError>>()?
+                .into_iter()
+                .flatten()
+                .map(|http| RemoteCache {
+                    http,
+                    enabled: AtomicBool::new(true),
+                })
+                .collect()
+        } else {
+            Vec::new()
+        };
 
         Ok(CacheMultiplexer {
             should_print_skipping_remote_put: AtomicBool::new(true),
-            should_use_http_cache: AtomicBool::new(http_cache.is_some()),
-            remote_cache_read_only: opts.remote_cache_read_only,
+            // Without a private key, nothing that's uploaded could be verified
+            remote_cache_read_only: opts.remote_cache_read_only
+                || (!remotes.is_empty()
+                    && remotes.iter().all(|remote| remote.http.is_verify_only())),
+            write_only: opts.write_only,
             fs: fs_cache,
-            http: http_cache,
+            remotes,
+            write_policy: remote_cache_opts.write_policy,
+            remote_fetches: Mutex::new(HashMap::new()),
+            remote_misses: Mutex::new(HashSet::new()),
+            warnings: Warnings::new(opts.show_all_warnings),
+            namespace: opts.namespace.as_deref().map(sanitize_namespace),
+            max_age: opts.max_age,
         })
     }
 
+    // Removes stale artifacts from the local cache if a max age is configured
+    pub(crate) fn collect_garbage(&self) {
+        let (Some(fs), Some(max_age)) = (&self.fs, self.max_age) else {
+            return;
+        };
+        match fs.gc(max_age) {
+            Ok(removed) => debug!(
+                "removed {} artifacts ({} bytes) from the local cache",
+                removed.artifacts, removed.bytes
+            ),
+            Err(err) => self
+                .warnings
+                .warn(format!("failed to clean up the local cache: {err}")),
+        }
+    }
+
+    fn namespaced<'a>(&self, key: &'a str) -> Cow<'a, str> {
+        match &self.namespace {
+            Some(namespace) => Cow::Owned(format!("{namespace}-{key}")),
+            None => Cow::Borrowed(key),
+        }
+    }
+
+    pub(crate) fn warnings(&self) -> &Warnings {
+        &self.warnings
+    }
+
     // This is technically a TOCTOU bug, but at worst it'll cause
     // a few extra cache requests.
-    fn get_http_cache(&self) -> Option<&HTTPCache> {
-        if self.should_use_http_cache.load(Ordering::Relaxed) {
-            self.http.as_ref()
-        } else {
-            None
+    fn remote_caches(&self) -> Vec<&RemoteCache> {
+        self.remotes
+            .iter()
+            .filter(|remote| remote.enabled.load(Ordering::Relaxed))
+            .collect()
+    }
+
+    // The remote caches that artifacts are uploaded to, out of `remotes`
+    fn write_targets<'a>(&self, remotes: &[&'a RemoteCache]) -> Vec<&'a RemoteCache> {
+        let writable = remotes
+            .iter()
+            .copied()
+            .filter(|remote| !remote.http.is_verify_only());
+        match self.write_policy {
+            RemoteCacheWritePolicy::WriteFirst => writable.take(1).collect(),
+            RemoteCacheWritePolicy::WriteAll => writable.collect(),
         }
     }
 
+    /// The number of bytes transferred to and from the remote caches. This
+    /// includes requests made before a remote cache was disabled.
+    pub fn network_usage(&self) -> NetworkUsage {
+        self.remotes
+            .iter()
+            .map(|remote| remote.http.network_usage())
+            .fold(NetworkUsage::default(), |total, usage| total + usage)
+    }
+
+    /// The number of bytes downloaded for the given hash by any of the remote
+    /// caches
+    pub fn downloaded_bytes(&self, key: &str) -> u64 {
+        let key = &*self.namespaced(key);
+        self.remotes
+            .iter()
+            .filter_map(|remote| remote.http.downloaded_bytes(key))
+            .sum()
+    }
+
     #[tracing::instrument(skip_all)]
     pub async fn put(
         &self,
@@ -89,47 +277,86 @@ impl CacheMultiplexer {
         key: &str,
         files: &[AnchoredSystemPathBuf],
         duration: u64,
-    ) -> Result<(), CacheError> {
+    ) -> Result<Option<CacheUploadMetadata>, CacheError> {
+        let key = &*self.namespaced(key);
+        self.remote_misses
+            .lock()
+            .expect("remote misses mutex poisoned")
+            .remove(key);
         self.fs
             .as_ref()
             .map(|fs| fs.put(anchor, key, files, duration))
             .transpose()?;
 
-        let http_result = match self.get_http_cache() {
-            Some(http) => {
-                if self.remote_cache_read_only {
-                    if self
-                        .should_print_skipping_remote_put
-                        .load(Ordering::Relaxed)
-                    {
-                        // Warn once per build, not per task
-                        warn!("Remote cache is read-only, skipping upload");
-                        self.should_print_skipping_remote_put
-                            .store(false, Ordering::Relaxed);
-                    }
-                    // Cache is functional but running in read-only mode, so we don't want to try to
-                    // write to it
-                    None
-                } else {
-                    let http_result = http.put(anchor, key, files, duration).await;
+        let remotes = self.remote_caches();
+        if remotes.is_empty() {
+            return Ok(None);
+        }
+        if self.remote_cache_read_only {
+            if self
+                .should_print_skipping_remote_put
+                .load(Ordering::Relaxed)
+            {
+                // Warn once per build, not per task
+                warn!("Remote cache is read-only, skipping upload");
+                self.should_print_skipping_remote_put
+                    .store(false, Ordering::Relaxed);
+            }
+            // Cache is functional but running in read-only mode, so we don't want to try to
+            // write to it
+            return Ok(None);
+        }
+
+        self.put_remote(&self.write_targets(&remotes), anchor, key, files, duration)
+            .await
+    }
+
+    // Uploads to each of `remotes` at once, reporting the first upload. A remote
+    // that fails is warned about as long as another one succeeds, the put only
+    // fails when all of them do.
+    async fn put_remote(
+        &self,
+        remotes: &[&RemoteCache],
+        anchor: &AbsoluteSystemPath,
+        key: &str,
+        files: &[AnchoredSystemPathBuf],
+        duration: u64,
+    ) -> Result<Option<CacheUploadMetadata>, CacheError> {
+        let results = futures::future::join_all(
+            remotes
+                .iter()
+                .map(|remote| remote.http.put(anchor, key, files, duration)),
+        )
+        .await;
 
-                    Some(http_result)
+        let mut upload = None;
+        let mut errors = Vec::new();
+        for (remote, result) in remotes.iter().zip(results) {
+            match result {
+                Err(CacheError::ApiClientError(
+                    box turborepo_api_client::Error::CacheDisabled { .. },
+                    ..,
+                )) => {
+                    self.warnings
+                        .warn("failed to put to http cache: cache disabled");
+                    remote.enabled.store(false, Ordering::Relaxed);
+                }
+                Err(e) => errors.push(e),
+                Ok(metadata) => {
+                    upload.get_or_insert(metadata);
                 }
             }
-            _ => None,
-        };
+        }
 
-        match http_result {
-            Some(Err(CacheError::ApiClientError(
-                box turborepo_api_client::Error::CacheDisabled { .. },
-                ..,
-            ))) => {
-                warn!("failed to put to http cache: cache disabled");
-                self.should_use_http_cache.store(false, Ordering::Relaxed);
-                Ok(())
-            }
-            Some(Err(e)) => Err(e),
-            None | Some(Ok(())) => Ok(()),
+        // The caller reports the error that's returned
+        let error = upload.is_none().then(|| errors.pop()).flatten();
+        for e in errors {
+            self.warnings
+                .warn(format!("failed to put to http cache: {e}"));
+        }
+        match error {
+            Some(e) => Err(e),
+            None => Ok(upload),
         }
     }
 
@@ -139,33 +366,174 @@ impl CacheMultiplexer {
         anchor: &AbsoluteSystemPath,
         key: &str,
     ) -> Result<Option<(CacheHitMetadata, Vec<AnchoredSystemPathBuf>)>, CacheError> {
+        self.fetch_filtered(anchor, key, &|_: &AnchoredSystemPath| true, true)
+            .await
+    }
+
+    #[tracing::instrument(skip_all)]
+    pub async fn fetch_matching(
+        &self,
+        anchor: &AbsoluteSystemPath,
+        key: &str,
+        filter: &(impl Fn(&AnchoredSystemPath) -> bool + Sync),
+    ) -> Result<Option<(CacheHitMetadata, Vec<AnchoredSystemPathBuf>)>, CacheError> {
+        self.fetch_filtered(anchor, key, filter, false).await
+    }
+
+    // Only artifacts that were restored in full are written back to the remote
+    // caches that missed, a filtered restore would upload a partial artifact
+    async fn fetch_filtered(
+        &self,
+        anchor: &AbsoluteSystemPath,
+        key: &str,
+        filter: &(impl Fn(&AnchoredSystemPath) -> bool + Sync),
+        restores_all: bool,
+    ) -> Result<Option<(CacheHitMetadata, Vec<AnchoredSystemPathBuf>)>, CacheError> {
+        if self.write_only {
+            return Ok(None);
+        }
+        let key = &*self.namespaced(key);
         if let Some(fs) = &self.fs {
-            if let response @ Ok(Some(_)) = fs.fetch(anchor, key) {
+            if let response @ Ok(Some(_)) = fs.fetch_matching(anchor, key, filter) {
                 return response;
             }
         }
 
-        if let Some(http) = self.get_http_cache() {
-            if let Ok(Some((CacheHitMetadata { source, time_saved }, files))) =
-                http.fetch(key).await
-            {
-                // Store this into fs cache. We can ignore errors here because we know
-                // we have previously successfully stored in HTTP cache, and so the overall
-                // result is a success at fetching. Storing in lower-priority caches is an
-                // optimization.
-                if let Some(fs) = &self.fs {
-                    let _ = fs.put(anchor, key, &files, time_saved);
-                }
+        let remotes = self.remote_caches();
+        if remotes.is_empty() || self.is_remote_miss(key) {
+            return Ok(None);
+        }
 
-                return Ok(Some((CacheHitMetadata { source, time_saved }, files)));
+        let remote_fetch = self.remote_fetch(key);
+        // Hold the lock for the duration of the fetch so concurrent fetches of
+        // the same hash wait for this one to finish.
+        let mut remote_fetch = remote_fetch.fetch.lock().await;
+        if let Some((metadata, files)) = remote_fetch.as_ref() {
+            // The artifact was already downloaded and restored to the same anchor
+            return Ok(Some((*metadata, files.clone())));
+        }
+        // A prefetch of the same hash may have filled the local cache while we
+        // were waiting
+        if let Some(fs) = &self.fs {
+            if let response @ Ok(Some(_)) = fs.fetch_matching(anchor, key, filter) {
+                return response;
+            }
+        }
+
+        for (i, remote) in remotes.iter().enumerate() {
+            let Ok(Some((CacheHitMetadata { source, time_saved }, files))) =
+                remote.http.fetch_matching(key, filter).await
+            else {
+                continue;
+            };
+
+            // Store this into fs cache. We can ignore errors here because we know
+            // we have previously successfully stored in HTTP cache, and so the overall
+            // result is a success at fetching. Storing in lower-priority caches is an
+            // optimization.
+            if let Some(fs) = &self.fs {
+                let _ = fs.put_with_provenance(
+                    anchor,
+                    key,
+                    &files,
+                    time_saved,
+                    remote.http.provenance(key),
+                );
+            }
+            // The same goes for the remote caches that missed, which are filled so
+            // that the next fetch is served by the first of them
+            if restores_all && i > 0 && !self.remote_cache_read_only {
+                let missed = self.write_targets(&remotes[..i]);
+                if let Err(err) = self
+                    .put_remote(&missed, anchor, key, &files, time_saved)
+                    .await
+                {
+                    debug!("failed to fill remote caches that missed: {err}");
+                }
             }
+
+            let metadata = CacheHitMetadata { source, time_saved };
+            *remote_fetch = Some((metadata, files.clone()));
+
+            return Ok(Some((metadata, files)));
+        }
+
+        Ok(None)
+    }
+
+    /// Downloads an artifact from the remote cache into the local cache without
+    /// restoring its files. Artifacts that are already in the local cache are
+    /// reported as local hits and aren't downloaded again.
+    #[tracing::instrument(skip_all)]
+    pub async fn prefetch(&self, key: &str) -> Result<Option<CacheHitMetadata>, CacheError> {
+        let Some(fs) = &self.fs else {
+            return Ok(None);
+        };
+        let key = &*self.namespaced(key);
+        if let cache_hit @ Some(_) = fs.exists(key)? {
+            return Ok(cache_hit);
+        }
+        if self.is_remote_miss(key) {
+            return Ok(None);
+        }
+
+        // Prefetches share the lock of remote fetches, so that a hash is only
+        // downloaded once however it is requested
+        let remote_fetch = self.remote_fetch(key);
+        let _remote_fetch = remote_fetch.fetch.lock().await;
+        if let cache_hit @ Some(_) = fs.exists(key)? {
+            return Ok(cache_hit);
+        }
+
+        for remote in self.remote_caches() {
+            let Some(archive) = remote.http.fetch_archive(key).await? else {
+                continue;
+            };
+            fs.put_archive(key, &archive)?;
+
+            return Ok(Some(CacheHitMetadata {
+                source: CacheSource::Remote,
+                time_saved: archive.duration,
+            }));
         }
 
         Ok(None)
     }
 
+    fn remote_fetch(&self, key: &str) -> RemoteFetchGuard<'_> {
+        let mut remote_fetches = self
+            .remote_fetches
+            .lock()
+            .expect("remote fetches mutex poisoned");
+        let fetch = remote_fetches.entry(key.to_string()).or_default().clone();
+        RemoteFetchGuard {
+            remote_fetches: &self.remote_fetches,
+            key: key.to_string(),
+            fetch,
+        }
+    }
+
+    #[cfg(test)]
+    pub(crate) fn remote_fetches_in_progress(&self) -> usize {
+        self.remote_fetches
+            .lock()
+            .expect("remote fetches mutex poisoned")
+            .len()
+    }
+
+    fn is_remote_miss(&self, key: &str) -> bool {
+        self.remote_misses
+            .lock()
+            .expect("remote misses mutex poisoned")
+            .contains(key)
+    }
+
     #[tracing::instrument(skip_all)]
     pub async fn exists(&self, key: &str) -> Result<Option<CacheHitMetadata>, CacheError> {
+        if self.write_only {
+            return Ok(None);
+        }
+        let key = &*self.namespaced(key);
         if let Some(fs) = &self.fs {
             match fs.exists(key) {
                 cache_hit @ Ok(Some(_)) => {
@@ -176,16 +544,91 @@ impl CacheMultiplexer {
             }
         }
 
-        if let Some(http) = self.get_http_cache() {
-            match http.exists(key).await {
+        let remotes = self.remote_caches();
+        if self.is_remote_miss(key) {
+            return Ok(None);
+        }
+        // Only a miss that every remote cache answered is remembered, a cache
+        // that failed to answer might have the artifact
+        let mut answered = true;
+        for remote in &remotes {
+            match remote.http.exists(key).await {
                 cache_hit @ Ok(Some(_)) => {
                     return cache_hit;
                 }
                 Ok(None) => {}
-                Err(err) => debug!("failed to check http cache: {:?}", err),
+                Err(err) => {
+                    debug!("failed to check http cache: {:?}", err);
+                    answered = false;
+                }
             }
         }
+        if answered && !remotes.is_empty() {
+            self.remote_misses
+                .lock()
+                .expect("remote misses mutex poisoned")
+                .insert(key.to_string());
+        }
 
         Ok(None)
     }
+
+    /// Checks both the filesystem and the remote caches for `key`, unlike
+    /// `exists` which stops at the first hit. A cache that fails to answer
+    /// counts as a miss.
+    #[tracing::instrument(skip_all)]
+    pub async fn cache_state(&self, key: &str) -> CacheState {
+        if self.write_only {
+            return CacheState::default();
+        }
+        let key = &*self.namespaced(key);
+        let local = self.fs.as_ref().and_then(|fs| {
+            fs.exists(key)
+                .map_err(|err| debug!("failed to check fs cache: {:?}", err))
+                .ok()
+                .flatten()
+        });
+
+        let mut remote = None;
+        if !self.is_remote_miss(key) {
+            for remote_cache in self.remote_caches() {
+                match remote_cache.http.exists(key).await {
+                    Ok(Some(hit)) => {
+                        remote = Some(hit);
+                        break;
+                    }
+                    Ok(None) => {}
+                    Err(err) => debug!("failed to check http cache: {:?}", err),
+                }
+            }
+        }
+
+        CacheState { local, remote }
+    }
+}
+
+// Namespaces are often branch names, which can contain characters that aren't
+// allowed in file names or URLs
+fn sanitize_namespace(namespace: &str) -> String {
+    namespace
+        .chars()
+        .map(|c| match c {
+            'a'..='z' | 'A'..='Z' | '0'..='9' | '-' | '_' | '.' => c,
+            _ => '-',
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod test {
+    use test_case::test_case;
+
+    use super::*;
+
+    #[test_case("staging", "staging" ; "plain")]
+    #[test_case("feature/new-cache", "feature-new-cache" ; "branch")]
+    #[test_case("release 1.0", "release-1.0" ; "whitespace")]
+    fn test_sanitize_namespace(namespace: &str, expected: &str) {
+        assert_eq!(sanitize_namespace(namespace), expected);
+    }
 }
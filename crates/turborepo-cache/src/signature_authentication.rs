@@ -3,18 +3,39 @@ use std::env;
 use base64::{prelude::BASE64_STANDARD, Engine};
 use hmac::{Hmac, Mac};
 use os_str_bytes::OsStringBytes;
-use sha2::Sha256;
+use ring::signature::{Ed25519KeyPair, KeyPair, UnparsedPublicKey, ED25519};
+use sha2::{Digest, Sha256};
 use thiserror::Error;
 
 type HmacSha256 = Hmac<Sha256>;
 
+const PRIVATE_KEY_ENV: &str = "TURBO_REMOTE_CACHE_SIGNATURE_PRIVATE_KEY";
+const PUBLIC_KEY_ENV: &str = "TURBO_REMOTE_CACHE_SIGNATURE_PUBLIC_KEY";
+
+// The DER header `openssl pkey -pubout -outform DER` puts in front of an
+// Ed25519 public key
+const ED25519_SPKI_PREFIX: &[u8] = &[
+    0x30, 0x2a, 0x30, 0x05, 0x06, 0x03, 0x2b, 0x65, 0x70, 0x03, 0x21, 0x00,
+];
+const ED25519_PUBLIC_KEY_LEN: usize = 32;
+
 #[derive(Debug, Error)]
 pub enum SignatureError {
     #[error(
         "signature secret key not found. You must specify a secret key in the \
-         TURBO_REMOTE_CACHE_SIGNATURE_KEY environment variable"
+         TURBO_REMOTE_CACHE_SIGNATURE_KEY environment variable, or a private key in the \
+         TURBO_REMOTE_CACHE_SIGNATURE_PRIVATE_KEY environment variable"
     )]
     NoSignatureSecretKey,
+    #[error(
+        "artifacts can't be signed with a public key. You must specify a private key in the \
+         TURBO_REMOTE_CACHE_SIGNATURE_PRIVATE_KEY environment variable"
+    )]
+    NoSignaturePrivateKey,
+    #[error("invalid signature private key: expected a base64 encoded PKCS#8 Ed25519 key")]
+    InvalidPrivateKey,
+    #[error("invalid signature public key: expected a base64 encoded Ed25519 key")]
+    InvalidPublicKey,
     #[error("serialization error: {0}")]
     SerializationError(#[from] serde_json::Error),
     #[error("base64 encoding error: {0}")]
@@ -26,8 +47,10 @@ pub enum SignatureError {
 #[derive(Debug)]
 pub struct ArtifactSignatureAuthenticator {
     pub(crate) team_id: Vec<u8>,
-    // An override for testing purposes (to avoid env var race conditions)
+    // Overrides for testing purposes (to avoid env var race conditions)
     pub(crate) secret_key_override: Option<Vec<u8>>,
+    pub(crate) private_key_override: Option<String>,
+    pub(crate) public_key_override: Option<String>,
 }
 
 impl ArtifactSignatureAuthenticator {
@@ -35,7 +58,59 @@ impl ArtifactSignatureAuthenticator {
         Self {
             team_id,
             secret_key_override,
+            private_key_override: None,
+            public_key_override: None,
+        }
+    }
+
+    /// Machines that only have the public key can verify artifacts, but
+    /// can't sign them, so they can't upload to the cache.
+    pub fn is_verify_only(&self) -> bool {
+        let has_key = |key_override: &Option<String>, env_var| {
+            key_override.is_some() || env::var_os(env_var).is_some()
+        };
+        !has_key(&self.private_key_override, PRIVATE_KEY_ENV)
+            && has_key(&self.public_key_override, PUBLIC_KEY_ENV)
+    }
+
+    // Gets the base64 encoded PKCS#8 key used to sign artifacts in place of the
+    // secret key, so that the secret doesn't need to be shared with every
+    // machine that only reads from the cache.
+    fn private_key(&self) -> Result<Option<Ed25519KeyPair>, SignatureError> {
+        let Some(encoded) = self
+            .private_key_override
+            .clone()
+            .or_else(|| env::var(PRIVATE_KEY_ENV).ok())
+        else {
+            return Ok(None);
+        };
+        let der = BASE64_STANDARD.decode(encoded.trim())?;
+        // `openssl genpkey` produces v1 PKCS#8 documents, which don't include
+        // the public key
+        Ed25519KeyPair::from_pkcs8_maybe_unchecked(&der)
+            .map(Some)
+            .map_err(|_| SignatureError::InvalidPrivateKey)
+    }
+
+    // Gets the key that verifies signatures made with the private key. Both a
+    // bare key and a DER encoded SubjectPublicKeyInfo are accepted.
+    fn public_key(&self) -> Result<Option<Vec<u8>>, SignatureError> {
+        let encoded = self
+            .public_key_override
+            .clone()
+            .or_else(|| env::var(PUBLIC_KEY_ENV).ok());
+        if let Some(encoded) = encoded {
+            let der = BASE64_STANDARD.decode(encoded.trim())?;
+            let key = der.strip_prefix(ED25519_SPKI_PREFIX).unwrap_or(&der);
+            if key.len() != ED25519_PUBLIC_KEY_LEN {
+                return Err(SignatureError::InvalidPublicKey);
+            }
+            return Ok(Some(key.to_vec()));
         }
+
+        Ok(self
+            .private_key()?
+            .map(|key_pair| key_pair.public_key().as_ref().to_vec()))
     }
 
     // Gets secret key from either secret key override or environment variable.
@@ -68,12 +143,30 @@ impl ArtifactSignatureAuthenticator {
         Ok(mac)
     }
 
+    // Artifacts can be large, so the private key signs a digest of the
+    // artifact rather than the artifact itself
+    fn digest(&self, hash: &[u8], artifact_body: &[u8]) -> Result<Vec<u8>, SignatureError> {
+        let mut hasher = Sha256::new();
+        hasher.update(self.construct_metadata(hash)?);
+        hasher.update(artifact_body);
+
+        Ok(hasher.finalize().to_vec())
+    }
+
     #[tracing::instrument(skip_all)]
     pub fn generate_tag_bytes(
         &self,
         hash: &[u8],
         artifact_body: &[u8],
     ) -> Result<Vec<u8>, SignatureError> {
+        if let Some(key_pair) = self.private_key()? {
+            let digest = self.digest(hash, artifact_body)?;
+            return Ok(key_pair.sign(&digest).as_ref().to_vec());
+        }
+        if self.is_verify_only() {
+            return Err(SignatureError::NoSignaturePrivateKey);
+        }
+
         let mut mac = self.get_tag_generator(hash)?;
 
         mac.update(artifact_body);
@@ -87,11 +180,8 @@ impl ArtifactSignatureAuthenticator {
         hash: &[u8],
         artifact_body: &[u8],
     ) -> Result<String, SignatureError> {
-        let mut hmac_ctx = self.get_tag_generator(hash)?;
-
-        hmac_ctx.update(artifact_body);
-        let hmac_output = hmac_ctx.finalize();
-        Ok(BASE64_STANDARD.encode(hmac_output.into_bytes()))
+        let tag = self.generate_tag_bytes(hash, artifact_body)?;
+        Ok(BASE64_STANDARD.encode(tag))
     }
 
     #[tracing::instrument(skip_all)]
@@ -101,12 +191,19 @@ impl ArtifactSignatureAuthenticator {
         artifact_body: &[u8],
         expected_tag: &str,
     ) -> Result<bool, SignatureError> {
+        let expected_bytes = BASE64_STANDARD.decode(expected_tag)?;
+        if let Some(public_key) = self.public_key()? {
+            let digest = self.digest(hash, artifact_body)?;
+            return Ok(UnparsedPublicKey::new(&ED25519, public_key)
+                .verify(&digest, &expected_bytes)
+                .is_ok());
+        }
+
         let mut mac = HmacSha256::new_from_slice(&self.secret_key()?)?;
         let message = self.construct_metadata(hash)?;
         mac.update(&message);
         mac.update(artifact_body);
 
-        let expected_bytes = BASE64_STANDARD.decode(expected_tag)?;
         Ok(mac.verify_slice(&expected_bytes).is_ok())
     }
 }
@@ -248,6 +345,8 @@ mod tests {
         let signature = ArtifactSignatureAuthenticator {
             team_id: test_case.team_id.to_vec(),
             secret_key_override: None,
+            private_key_override: None,
+            public_key_override: None,
         };
 
         let hash = test_case.artifact_hash;
@@ -273,4 +372,59 @@ mod tests {
         assert!(signature.validate(hash, artifact_body, &tag)?);
         Ok(())
     }
+
+    // Generated with `openssl genpkey -algorithm ed25519 -outform DER | base64`
+    const PRIVATE_KEY: &str = "MC4CAQAwBQYDK2VwBCIEIAPiSWawLEafwqHNa9VAIY1QRJJAt9h++CEtnmyjK3HH";
+
+    fn asymmetric_authenticator(
+        private_key: Option<&str>,
+        public_key: Option<&str>,
+    ) -> ArtifactSignatureAuthenticator {
+        ArtifactSignatureAuthenticator {
+            team_id: b"team_abc".to_vec(),
+            secret_key_override: Some(b"unused secret".to_vec()),
+            private_key_override: private_key.map(|key| key.to_string()),
+            public_key_override: public_key.map(|key| key.to_string()),
+        }
+    }
+
+    #[test]
+    fn test_asymmetric_signatures() -> Result<()> {
+        let signer = asymmetric_authenticator(Some(PRIVATE_KEY), None);
+        let public_key = BASE64_STANDARD.encode(signer.public_key()?.unwrap());
+        let verifier = asymmetric_authenticator(None, Some(&public_key));
+        assert!(!signer.is_verify_only());
+        assert!(verifier.is_verify_only());
+
+        let tag = signer.generate_tag(b"hash", b"body")?;
+        assert!(signer.validate(b"hash", b"body", &tag)?);
+        assert!(verifier.validate(b"hash", b"body", &tag)?);
+        assert!(!verifier.validate(b"other hash", b"body", &tag)?);
+        assert!(!verifier.validate(b"hash", b"other body", &tag)?);
+
+        // Signatures made with the shared secret aren't accepted
+        let hmac_tag = asymmetric_authenticator(None, None).generate_tag(b"hash", b"body")?;
+        assert!(!verifier.validate(b"hash", b"body", &hmac_tag)?);
+
+        assert!(matches!(
+            verifier.generate_tag(b"hash", b"body"),
+            Err(SignatureError::NoSignaturePrivateKey)
+        ));
+        Ok(())
+    }
+
+    #[test]
+    fn test_spki_public_key() -> Result<()> {
+        let signer = asymmetric_authenticator(Some(PRIVATE_KEY), None);
+        let public_key = signer.public_key()?.unwrap();
+        let spki = BASE64_STANDARD.encode([ED25519_SPKI_PREFIX, &public_key].concat());
+        let verifier = asymmetric_authenticator(None, Some(&spki));
+
+        assert_eq!(verifier.public_key()?, Some(public_key));
+        assert!(matches!(
+            asymmetric_authenticator(None, Some("AAAA")).public_key(),
+            Err(SignatureError::InvalidPublicKey)
+        ));
+        Ok(())
+    }
 }
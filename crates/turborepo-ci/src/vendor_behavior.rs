@@ -1,4 +1,5 @@
 type GroupPrefixFn = fn(group_name: &str) -> String;
+type ErrorAnnotationFn = fn(file: &str, title: &str, message: &str) -> String;
 
 #[derive(Clone, Debug, PartialEq)]
 pub struct VendorBehavior {
@@ -6,6 +7,9 @@ pub struct VendorBehavior {
     pub group_suffix: GroupPrefixFn,
     pub error_group_prefix: Option<GroupPrefixFn>,
     pub error_group_suffix: Option<GroupPrefixFn>,
+    /// Formats a line that the vendor shows as an error on `file`, written
+    /// when a task fails
+    pub error_annotation: Option<ErrorAnnotationFn>,
 }
 
 impl VendorBehavior {
@@ -15,6 +19,7 @@ impl VendorBehavior {
             group_suffix: suffix,
             error_group_prefix: None,
             error_group_suffix: None,
+            error_annotation: None,
         }
     }
 
@@ -23,4 +28,55 @@ impl VendorBehavior {
         self.error_group_suffix = Some(suffix);
         self
     }
+
+    pub fn with_error_annotation(mut self, annotation: ErrorAnnotationFn) -> Self {
+        self.error_annotation = Some(annotation);
+        self
+    }
+}
+
+/// A GitHub Actions `::error` workflow command. Properties and the message
+/// are escaped so that they can't end the command early.
+/// https://docs.github.com/en/actions/using-workflows/workflow-commands-for-github-actions#setting-an-error-message
+pub(crate) fn github_error_annotation(file: &str, title: &str, message: &str) -> String {
+    format!(
+        "::error file={},title={}::{}\n",
+        escape_github_property(file),
+        escape_github_property(title),
+        escape_github_data(message)
+    )
+}
+
+fn escape_github_data(data: &str) -> String {
+    data.replace('%', "%25")
+        .replace('\r', "%0D")
+        .replace('\n', "%0A")
+}
+
+fn escape_github_property(property: &str) -> String {
+    escape_github_data(property)
+        .replace(':', "%3A")
+        .replace(',', "%2C")
+}
+
+#[cfg(test)]
+mod test {
+    use super::github_error_annotation;
+
+    #[test]
+    fn test_github_error_annotation() {
+        assert_eq!(
+            github_error_annotation(
+                "packages/ui/package.json",
+                "ui#build failed",
+                "command (packages/ui) npm run build exited (1)"
+            ),
+            "::error file=packages/ui/package.json,title=ui#build failed::command (packages/ui) \
+             npm run build exited (1)\n"
+        );
+        assert_eq!(
+            github_error_annotation("a,b", "web:build", "100%\ndone"),
+            "::error file=a%2Cb,title=web%3Abuild::100%25%0Adone\n"
+        );
+    }
 }
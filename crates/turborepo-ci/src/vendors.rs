@@ -1,6 +1,6 @@
 use std::{collections::HashMap, fmt::Debug, sync::OnceLock};
 
-use crate::vendor_behavior::VendorBehavior;
+use crate::vendor_behavior::{github_error_annotation, VendorBehavior};
 
 #[derive(Clone, Debug, PartialEq)]
 pub struct VendorEnvs {
@@ -274,7 +274,8 @@ pub(crate) fn get_vendors() -> &'static [Vendor] {
                         .with_error(
                             |group_name| format!("\x1B[;31m{group_name}\x1B[;0m\n"),
                             |_| String::new(),
-                        ),
+                        )
+                        .with_error_annotation(github_error_annotation),
                     ),
                 },
                 Vendor {
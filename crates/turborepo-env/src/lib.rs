@@ -328,4 +328,23 @@ mod tests {
         let actual = super::wildcard_to_regex_pattern(pattern);
         assert_eq!(actual, expected);
     }
+
+    #[test_case(&["AWS_*", "CI"], &["AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "CI"] ; "wildcard and literal")]
+    #[test_case(&["AWS_*", "!AWS_SECRET_ACCESS_KEY"], &["AWS_ACCESS_KEY_ID"] ; "wildcard with exclusion")]
+    #[test_case(&["CI_*"], &[] ; "no match")]
+    fn test_from_wildcards(patterns: &[&str], expected: &[&str]) {
+        let env = super::EnvironmentVariableMap::from(
+            [
+                ("AWS_ACCESS_KEY_ID", "id"),
+                ("AWS_SECRET_ACCESS_KEY", "secret"),
+                ("CI", "1"),
+                ("HOME", "/home"),
+            ]
+            .into_iter()
+            .map(|(k, v)| (k.to_string(), v.to_string()))
+            .collect::<std::collections::HashMap<_, _>>(),
+        );
+        let actual = env.from_wildcards(patterns).unwrap();
+        assert_eq!(actual.names(), expected);
+    }
 }
@@ -5,7 +5,7 @@ use thiserror::Error;
 use turborepo_repository::package_graph;
 
 use crate::{
-    commands::{bin, generate, prune},
+    commands::{bench, bin, cache, cache_server, generate, graph, lint, prune},
     daemon::DaemonError,
     rewrite_json::RewriteError,
     run,
@@ -15,12 +15,20 @@ use crate::{
 pub enum Error {
     #[error("No command specified")]
     NoCommand(#[backtrace] backtrace::Backtrace),
+    #[error(transparent)]
+    Bench(#[from] bench::Error),
     #[error("{0}")]
     Bin(#[from] bin::Error, #[backtrace] backtrace::Backtrace),
     #[error(transparent)]
+    Cache(#[from] cache::Error),
+    #[error(transparent)]
+    CacheServer(#[from] cache_server::Error),
+    #[error(transparent)]
     Path(#[from] turbopath::PathError),
     #[error("at least one task must be specified")]
     NoTasks(#[backtrace] backtrace::Backtrace),
+    #[error("`turbo {0}` doesn't support --json")]
+    JsonUnsupported(&'static str),
     #[error(transparent)]
     #[diagnostic(transparent)]
     Config(#[from] crate::config::Error),
@@ -37,6 +45,10 @@ pub enum Error {
     #[error(transparent)]
     Generate(#[from] generate::Error),
     #[error(transparent)]
+    Graph(#[from] graph::Error),
+    #[error(transparent)]
+    Lint(#[from] lint::Error),
+    #[error(transparent)]
     #[diagnostic(transparent)]
     Prune(#[from] prune::Error),
     #[error(transparent)]
@@ -49,3 +61,14 @@ pub enum Error {
     #[error(transparent)]
     SerdeJson(#[from] serde_json::Error),
 }
+
+impl Error {
+    /// The code turbo exits with when a command fails with this error. Only
+    /// `turbo run` distinguishes between kinds of failures.
+    pub fn exit_code(&self) -> i32 {
+        match self {
+            Error::Run(run_error) => run_error.exit_code(),
+            _ => 1,
+        }
+    }
+}
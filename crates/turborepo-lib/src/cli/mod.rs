@@ -1,4 +1,7 @@
-use std::{backtrace, backtrace::Backtrace, env, fmt, fmt::Display, io, mem, process};
+use std::{
+    backtrace, backtrace::Backtrace, env, fmt, fmt::Display, io, mem, net::IpAddr, process,
+    time::Duration,
+};
 
 use camino::{Utf8Path, Utf8PathBuf};
 use clap::{
@@ -9,7 +12,7 @@ use clap_complete::{generate, Shell};
 pub use error::Error;
 use serde::{Deserialize, Serialize};
 use tracing::{debug, error};
-use turbopath::AbsoluteSystemPathBuf;
+use turbopath::{AbsoluteSystemPath, AbsoluteSystemPathBuf};
 use turborepo_api_client::AnonAPIClient;
 use turborepo_repository::inference::{RepoMode, RepoState};
 use turborepo_telemetry::{
@@ -24,8 +27,8 @@ use turborepo_ui::UI;
 
 use crate::{
     commands::{
-        bin, daemon, generate, info, link, login, logout, prune, run, telemetry, unlink,
-        CommandBase,
+        bench, bin, cache, cache_server, daemon, generate, graph, info, link, lint, login, logout,
+        prune, run, telemetry, unlink, CommandBase,
     },
     get_version,
     shim::TurboState,
@@ -40,8 +43,15 @@ pub const INVOCATION_DIR_ENV_VAR: &str = "TURBO_INVOCATION_DIR";
 
 // Default value for the --cache-workers argument
 const DEFAULT_NUM_WORKERS: u32 = 10;
-const SUPPORTED_GRAPH_FILE_EXTENSIONS: [&str; 8] =
-    ["svg", "png", "jpg", "pdf", "json", "html", "mermaid", "dot"];
+// Default value for the --cache-queue-size argument
+const DEFAULT_CACHE_QUEUE_SIZE: u32 = 10;
+// Default value for the cache-server --max-artifact-size argument, 512MiB
+const DEFAULT_MAX_ARTIFACT_SIZE: u64 = 512 * 1024 * 1024;
+const SUPPORTED_GRAPH_FILE_EXTENSIONS: [&str; 9] = [
+    "svg", "png", "jpg", "pdf", "json", "html", "mermaid", "mmd", "dot",
+];
+// Passing this instead of a filename to --graph prints a Mermaid graph
+pub(crate) const MERMAID_STDOUT_GRAPH: &str = "mermaid";
 
 #[derive(Copy, Clone, Debug, PartialEq, Eq, Deserialize, Serialize, ValueEnum)]
 pub enum OutputLogsMode {
@@ -101,10 +111,34 @@ impl Display for LogOrder {
     }
 }
 
+#[derive(Copy, Clone, Debug, PartialEq, Serialize, ValueEnum)]
+pub enum UIMode {
+    #[serde(rename = "stream")]
+    Stream,
+    #[serde(rename = "tui")]
+    Tui,
+}
+
+impl Default for UIMode {
+    fn default() -> Self {
+        Self::Stream
+    }
+}
+
+impl Display for UIMode {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(match self {
+            UIMode::Stream => "stream",
+            UIMode::Tui => "tui",
+        })
+    }
+}
+
 #[derive(Copy, Clone, Debug, PartialEq, Serialize, ValueEnum)]
 pub enum DryRunMode {
     Text,
     Json,
+    Table,
 }
 
 impl Display for DryRunMode {
@@ -112,10 +146,182 @@ impl Display for DryRunMode {
         f.write_str(match self {
             DryRunMode::Text => "text",
             DryRunMode::Json => "json",
+            DryRunMode::Table => "table",
+        })
+    }
+}
+
+/// Which tasks keep running after a task fails with `--continue`.
+#[derive(Copy, Clone, Debug, PartialEq, Serialize, ValueEnum)]
+pub enum ContinueMode {
+    /// Run every task, even those that depend on a failed task
+    Always,
+    /// Skip the tasks that depend on a failed task, directly or not
+    DependenciesSuccessful,
+}
+
+impl Display for ContinueMode {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(match self {
+            ContinueMode::Always => "always",
+            ContinueMode::DependenciesSuccessful => "dependencies-successful",
+        })
+    }
+}
+
+/// How `--graph` draws the tasks of a run.
+#[derive(Copy, Clone, Debug, Default, PartialEq, Serialize, ValueEnum)]
+#[serde(rename_all = "lowercase")]
+pub enum GraphMode {
+    #[default]
+    Tasks,
+    Packages,
+    Both,
+}
+
+impl Display for GraphMode {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(match self {
+            GraphMode::Tasks => "tasks",
+            GraphMode::Packages => "packages",
+            GraphMode::Both => "both",
+        })
+    }
+}
+
+/// How symlinks in task outputs are written to the cache.
+#[derive(Copy, Clone, Debug, Default, PartialEq, Serialize, ValueEnum)]
+#[serde(rename_all = "lowercase")]
+pub enum OutputSymlinks {
+    #[default]
+    Preserve,
+    Dereference,
+}
+
+impl Display for OutputSymlinks {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(match self {
+            OutputSymlinks::Preserve => "preserve",
+            OutputSymlinks::Dereference => "dereference",
+        })
+    }
+}
+
+/// How files are restored from the local cache.
+#[derive(Copy, Clone, Debug, Default, PartialEq, Serialize, ValueEnum)]
+#[serde(rename_all = "lowercase")]
+pub enum CacheRestoreMode {
+    #[default]
+    Hardlink,
+    Copy,
+    Reflink,
+}
+
+impl Display for CacheRestoreMode {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(match self {
+            CacheRestoreMode::Hardlink => "hardlink",
+            CacheRestoreMode::Copy => "copy",
+            CacheRestoreMode::Reflink => "reflink",
+        })
+    }
+}
+
+/// How a run coordinates with other runs in the same repository.
+#[derive(Copy, Clone, Debug, Default, PartialEq, Serialize, ValueEnum)]
+#[serde(rename_all = "lowercase")]
+pub enum ConcurrentRuns {
+    #[default]
+    Share,
+    Queue,
+}
+
+impl Display for ConcurrentRuns {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(match self {
+            ConcurrentRuns::Share => "share",
+            ConcurrentRuns::Queue => "queue",
+        })
+    }
+}
+
+/// How precisely `--filter` git ranges narrow down the work in a run.
+#[derive(Copy, Clone, Debug, Default, PartialEq, Serialize, ValueEnum)]
+#[serde(rename_all = "lowercase")]
+pub enum AffectedGranularity {
+    #[default]
+    Package,
+    File,
+}
+
+impl Display for AffectedGranularity {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(match self {
+            AffectedGranularity::Package => "package",
+            AffectedGranularity::File => "file",
         })
     }
 }
 
+/// The value passed to `--force`. Either a boolean that applies to every task
+/// or a list of tasks (e.g. `web#build,test,docs#*`) that should skip cache
+/// reads.
+#[derive(Clone, Debug, PartialEq, Serialize)]
+#[serde(untagged)]
+pub enum ForceMode {
+    All(bool),
+    Tasks(Vec<String>),
+}
+
+fn parse_force(s: &str) -> Result<ForceMode, String> {
+    // `TURBO_FORCE` is commonly set to `1` or `TRUE`, which shouldn't be
+    // mistaken for task names
+    match s.to_ascii_lowercase().as_str() {
+        "1" | "true" => Ok(ForceMode::All(true)),
+        "0" | "false" => Ok(ForceMode::All(false)),
+        _ => {
+            let tasks: Vec<String> = s
+                .split(',')
+                .map(str::trim)
+                .filter(|task| !task.is_empty())
+                .map(String::from)
+                .collect();
+            if tasks.is_empty() {
+                Err("expected a boolean or a comma separated list of tasks".to_string())
+            } else {
+                Ok(ForceMode::Tasks(tasks))
+            }
+        }
+    }
+}
+
+/// The value passed to `--shard`, the 1-based index of the shard to run out
+/// of the total number of shards (e.g. `2/4`).
+#[derive(Copy, Clone, Debug, PartialEq, Serialize)]
+pub struct Shard {
+    pub index: usize,
+    pub total: usize,
+}
+
+impl Display for Shard {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{}/{}", self.index, self.total)
+    }
+}
+
+fn parse_shard(s: &str) -> Result<Shard, String> {
+    let invalid = || format!("invalid shard {s}, expected <index>/<total> (e.g. 1/4)");
+    let (index, total) = s.split_once('/').ok_or_else(invalid)?;
+    let index = index.trim().parse::<usize>().map_err(|_| invalid())?;
+    let total = total.trim().parse::<usize>().map_err(|_| invalid())?;
+    if total == 0 || index == 0 || index > total {
+        return Err(format!(
+            "invalid shard {s}, index must be between 1 and the total number of shards"
+        ));
+    }
+    Ok(Shard { index, total })
+}
+
 #[derive(Copy, Clone, Debug, Default, PartialEq, Serialize, ValueEnum)]
 pub enum EnvMode {
     #[default]
@@ -168,6 +374,12 @@ pub struct Args {
     /// Specify a file to save a pprof heap profile
     #[clap(long, global = true, value_parser)]
     pub heap: Option<String>,
+    /// Output a single JSON document on stdout and write everything else,
+    /// including task logs, to stderr
+    // Commands with a `--json` flag of their own share it with this one, so
+    // it's set for them as well
+    #[clap(long, global = true)]
+    pub json: bool,
     /// Override the login endpoint
     #[clap(long, global = true, value_parser)]
     pub login: Option<String>,
@@ -260,6 +472,148 @@ pub enum DaemonCommand {
     },
     /// Shows the daemon logs
     Logs,
+    /// Calls an RPC on the running daemon and prints the response. Lists the
+    /// available RPCs if none is given. Intended for debugging the daemon
+    Client {
+        /// The RPC to call
+        #[clap(value_enum)]
+        rpc: Option<DaemonRpc>,
+    },
+}
+
+/// The daemon RPCs that can be called from `turbo daemon client`. Only RPCs
+/// that don't modify the daemon's state are exposed.
+#[derive(Copy, Clone, Debug, PartialEq, Eq, Serialize, ValueEnum)]
+#[serde(rename_all = "kebab-case")]
+pub enum DaemonRpc {
+    /// Reports the daemon's uptime and log file
+    Status,
+    /// Returns the packages the daemon has discovered, if discovery has
+    /// finished
+    DiscoverPackages,
+    /// Returns the packages the daemon has discovered, waiting for discovery
+    /// to finish
+    DiscoverPackagesBlocking,
+    /// Prints the status of tasks as runs publish them until interrupted
+    SubscribeTaskStatus,
+}
+
+#[derive(Subcommand, Clone, Debug, Serialize, PartialEq)]
+#[serde(tag = "command")]
+pub enum CacheCommand {
+    /// Lists the artifacts in the local cache, or the contents of a single
+    /// artifact
+    Ls {
+        /// The hash of the task whose artifact's contents should be listed
+        hash: Option<String>,
+        #[clap(flatten)]
+        #[serde(flatten)]
+        cache_dir: CacheDirArgs,
+        /// Output the artifacts as JSON
+        #[clap(long)]
+        json: bool,
+    },
+    /// Lists the contents and metadata of a cache artifact without restoring
+    /// it
+    Inspect {
+        /// The hash of the task whose artifact should be inspected
+        hash: String,
+        /// Inspect the artifact in the remote cache instead of the local cache
+        #[clap(long)]
+        remote: bool,
+        #[clap(flatten)]
+        #[serde(flatten)]
+        cache_dir: CacheDirArgs,
+        /// Output the artifact contents as JSON
+        #[clap(long)]
+        json: bool,
+    },
+    /// Removes artifacts from the local cache
+    #[clap(group(ArgGroup::new("clean-target").required(true)))]
+    Clean {
+        /// The hash of the task whose artifact should be removed
+        #[clap(long, group = "clean-target")]
+        hash: Option<String>,
+        /// Remove every artifact in the local cache
+        #[clap(long, group = "clean-target")]
+        all: bool,
+        #[clap(flatten)]
+        #[serde(flatten)]
+        cache_dir: CacheDirArgs,
+    },
+    /// Reports how the cache has been used by recent runs
+    Stats {
+        /// Output the statistics as JSON
+        #[clap(long)]
+        json: bool,
+    },
+    /// Removes artifacts from the local cache that haven't been used recently,
+    /// along with stale task logs and temporary files
+    Gc {
+        /// Remove artifacts that haven't been written or restored in this many
+        /// days, and logs that haven't been written in this many days
+        #[clap(long, value_name = "DAYS", value_parser = parse_days, default_value = "7")]
+        max_age: Duration,
+        #[clap(flatten)]
+        #[serde(flatten)]
+        cache_dir: CacheDirArgs,
+    },
+    /// Writes artifacts from the local cache to a tarball that can be
+    /// imported into the cache of another machine
+    #[clap(group(ArgGroup::new("export-target").required(true).multiple(true)))]
+    Export {
+        /// The file to write the tarball to
+        output: Utf8PathBuf,
+        /// The hash of an artifact to export. Can be passed multiple times
+        #[clap(long = "hash", group = "export-target")]
+        hashes: Vec<String>,
+        /// Export the artifacts of tasks matching this selector, e.g. `build`
+        /// or `web#build`. Can be passed multiple times
+        #[clap(long = "task", group = "export-target")]
+        tasks: Vec<String>,
+        /// Export every artifact in the local cache
+        #[clap(long, group = "export-target")]
+        all: bool,
+        #[clap(flatten)]
+        #[serde(flatten)]
+        cache_dir: CacheDirArgs,
+    },
+    /// Reads artifacts from a tarball written by `turbo cache export` into the
+    /// local cache
+    Import {
+        /// The tarball to read
+        input: Utf8PathBuf,
+        #[clap(flatten)]
+        #[serde(flatten)]
+        cache_dir: CacheDirArgs,
+    },
+    /// Checks artifacts in the local cache against the checksums recorded
+    /// when they were written
+    Verify {
+        /// The hash of the task whose artifact should be verified. Every
+        /// artifact is verified if this isn't passed
+        hash: Option<String>,
+        #[clap(flatten)]
+        #[serde(flatten)]
+        cache_dir: CacheDirArgs,
+        /// Output the results as JSON
+        #[clap(long)]
+        json: bool,
+    },
+}
+
+/// The `--cache-dir` flag of the commands that work on the local cache
+#[derive(Parser, Clone, Debug, Default, Serialize, PartialEq)]
+pub struct CacheDirArgs {
+    /// Override the filesystem cache directory
+    #[clap(long, value_parser = path_non_empty)]
+    pub cache_dir: Option<Utf8PathBuf>,
+}
+
+impl CacheDirArgs {
+    pub fn path(&self) -> Option<&Utf8Path> {
+        self.cache_dir.as_deref()
+    }
 }
 
 #[derive(Subcommand, Copy, Clone, Debug, Serialize, PartialEq)]
@@ -316,7 +670,9 @@ impl Args {
                     run_args.single_package = is_single_package
                 }
 
-                if let Some(Command::Run(ref mut run_args)) = args.command {
+                if let Some(Command::Run(ref mut run_args) | Command::Prefetch(ref mut run_args)) =
+                    args.command
+                {
                     run_args.single_package = is_single_package;
                 }
 
@@ -365,7 +721,10 @@ impl Args {
 
     pub fn get_tasks(&self) -> &[String] {
         match &self.command {
-            Some(Command::Run(box RunArgs { tasks, .. })) => tasks,
+            Some(
+                Command::Run(box RunArgs { tasks, .. })
+                | Command::Prefetch(box RunArgs { tasks, .. }),
+            ) => tasks,
             _ => self
                 .run_args
                 .as_ref()
@@ -422,8 +781,50 @@ impl Args {
 pub enum Command {
     // NOTE: Empty variants still have an empty struct attached so that serde serializes
     // them as `{ "Bin": {} }` instead of as `"Bin"`.
+    /// Time turbo's internals against a generated monorepo
+    #[clap(hide = true)]
+    Bench {
+        /// The number of packages to generate
+        #[clap(long, default_value_t = 100)]
+        packages: usize,
+        /// The number of source files to generate in each package
+        #[clap(long, default_value_t = 50)]
+        files: usize,
+        /// How many times to repeat each operation
+        #[clap(long, default_value_t = 3)]
+        iterations: usize,
+        /// Output the timings as JSON
+        #[clap(long)]
+        json: bool,
+    },
     /// Get the path to the Turbo binary
     Bin {},
+    /// Manage the local or remote cache
+    Cache {
+        #[clap(subcommand)]
+        #[serde(flatten)]
+        command: CacheCommand,
+    },
+    /// Serve the Remote Caching API from a local cache directory
+    CacheServer {
+        /// The address to listen on
+        #[clap(long, default_value = "127.0.0.1")]
+        host: IpAddr,
+        /// The port to listen on
+        #[clap(long, default_value_t = 3000)]
+        port: u16,
+        #[clap(flatten)]
+        #[serde(flatten)]
+        cache_dir: CacheDirArgs,
+        /// Reject uploads larger than this many bytes. Uploads are held in
+        /// memory until they're written to the cache
+        #[clap(long, value_name = "BYTES", default_value_t = DEFAULT_MAX_ARTIFACT_SIZE)]
+        max_artifact_size: u64,
+        /// Require clients to send this token as their auth token
+        #[clap(long, env = "TURBO_CACHE_SERVER_TOKEN")]
+        #[serde(skip)]
+        auth_token: Option<String>,
+    },
     /// Generate the autocompletion script for the specified shell
     #[serde(skip)]
     Completion { shell: Shell },
@@ -459,6 +860,19 @@ pub enum Command {
         #[serde(skip)]
         command: Option<Box<GenerateCommand>>,
     },
+    /// Compare the task graph and hashes against a git ref
+    Graph {
+        /// The git ref to compare the working tree against (default HEAD)
+        #[clap(long, value_name = "REF")]
+        diff: Option<String>,
+        /// Use the given selector to specify package(s) to act as
+        /// entry points
+        #[clap(short = 'F', long)]
+        filter: Vec<String>,
+        /// The tasks to compare
+        #[clap(required = true)]
+        tasks: Vec<String>,
+    },
     /// Enable or disable anonymous telemetry
     Telemetry {
         #[clap(subcommand)]
@@ -483,6 +897,9 @@ pub enum Command {
         #[clap(long, value_enum, default_value_t = LinkTarget::RemoteCache)]
         target: LinkTarget,
     },
+    /// Check workspaces for undeclared dependencies on each other, mismatched
+    /// versions of external dependencies and pipeline tasks without scripts
+    LintWorkspaces {},
     /// Login to your Vercel account
     Login {
         #[clap(long = "sso-team")]
@@ -498,6 +915,13 @@ pub enum Command {
         #[clap(long)]
         invalidate: bool,
     },
+    /// Download the remote cache artifacts of tasks into the local cache
+    /// without running them
+    ///
+    /// Task hashes are calculated exactly like `turbo run --dry-run`, so
+    /// this accepts the same flags as `turbo run`. Artifacts that are already
+    /// in the local cache aren't downloaded again.
+    Prefetch(Box<RunArgs>),
     /// Prepare a subset of your monorepo.
     Prune {
         #[clap(hide = true, long)]
@@ -534,6 +958,36 @@ pub enum Command {
     },
 }
 
+impl Command {
+    // The name of the command if it has no output that can be written as JSON
+    fn without_json_output(&self) -> Option<&'static str> {
+        match self {
+            Command::Bench { .. }
+            | Command::Bin { .. }
+            | Command::Cache { .. }
+            | Command::Info { .. }
+            | Command::LintWorkspaces { .. }
+            | Command::Prefetch(_)
+            | Command::Prune { .. }
+            | Command::Run(_)
+            | Command::Daemon {
+                command: Some(DaemonCommand::Status { .. }),
+                ..
+            } => None,
+            Command::CacheServer { .. } => Some("cache-server"),
+            Command::Completion { .. } => Some("completion"),
+            Command::Daemon { .. } => Some("daemon"),
+            Command::Generate { .. } => Some("generate"),
+            Command::Graph { .. } => Some("graph"),
+            Command::Telemetry { .. } => Some("telemetry"),
+            Command::Link { .. } => Some("link"),
+            Command::Login { .. } => Some("login"),
+            Command::Logout { .. } => Some("logout"),
+            Command::Unlink { .. } => Some("unlink"),
+        }
+    }
+}
+
 #[derive(Parser, Clone, Debug, Default, Serialize, PartialEq)]
 pub struct GenerateWorkspaceArgs {
     /// Name for the new workspace
@@ -593,7 +1047,7 @@ pub enum GenerateCommand {
 }
 
 fn validate_graph_extension(s: &str) -> Result<String, String> {
-    match s.is_empty() {
+    match s.is_empty() || s == MERMAID_STDOUT_GRAPH {
         true => Ok(s.to_string()),
         _ => match Utf8Path::new(s).extension() {
             Some(ext) if SUPPORTED_GRAPH_FILE_EXTENSIONS.contains(&ext) => Ok(s.to_string()),
@@ -617,6 +1071,28 @@ fn path_non_empty(s: &str) -> Result<Utf8PathBuf, String> {
     }
 }
 
+fn parse_days(s: &str) -> Result<Duration, String> {
+    let days = s
+        .parse::<u64>()
+        .map_err(|_| format!("invalid number of days: {s}"))?;
+    Ok(Duration::from_secs(days * 24 * 60 * 60))
+}
+
+fn parse_seconds(s: &str) -> Result<Duration, String> {
+    let seconds = s
+        .parse::<u64>()
+        .map_err(|_| format!("invalid number of seconds: {s}"))?;
+    Ok(Duration::from_secs(seconds))
+}
+
+fn namespace_non_empty(s: &str) -> Result<String, String> {
+    if s.is_empty() {
+        Err("namespace must not be empty".to_string())
+    } else {
+        Ok(s.to_string())
+    }
+}
+
 #[derive(Parser, Clone, Debug, Default, Serialize, PartialEq)]
 #[command(groups = [
     ArgGroup::new("daemon-group").multiple(false).required(false),
@@ -629,22 +1105,73 @@ pub struct RunArgs {
     /// Set the number of concurrent cache operations (default 10)
     #[clap(long, default_value_t = DEFAULT_NUM_WORKERS)]
     pub cache_workers: u32,
+    /// Set how many cache writes can wait for a cache worker before tasks
+    /// wait for room in the queue (default 10)
+    #[clap(long, default_value_t = DEFAULT_CACHE_QUEUE_SIZE)]
+    pub cache_queue_size: u32,
+    /// Stop waiting for cache writes this many seconds after the tasks
+    /// finish. Writes that haven't finished are resumed by the next run
+    #[clap(long, env = "TURBO_CACHE_DRAIN_TIMEOUT", value_name = "SECONDS", value_parser = parse_seconds)]
+    pub cache_drain_timeout: Option<Duration>,
+    /// Only share cached artifacts with runs in the same namespace, e.g. a
+    /// branch name or "staging". Artifacts are stored with the namespace as
+    /// a prefix so they can be purged independently
+    #[clap(long, env = "TURBO_CACHE_NAMESPACE", value_parser = namespace_non_empty)]
+    pub cache_namespace: Option<String>,
+    /// Remove artifacts from the local cache that haven't been used in this
+    /// many days once the run finishes
+    #[clap(long, env = "TURBO_CACHE_MAX_AGE", value_name = "DAYS", value_parser = parse_days)]
+    pub cache_max_age: Option<Duration>,
+    /// Set how files are restored from the local cache. Use "hardlink" to
+    /// link them to the cache, which is fastest but means editing a restored
+    /// output also edits the cached artifact. Use "copy" to copy them. Use
+    /// "reflink" for copy-on-write clones, which fall back to copies on file
+    /// systems that don't support them. (default hardlink)
+    #[clap(long, env = "TURBO_CACHE_RESTORE_MODE", value_enum, default_value_t = CacheRestoreMode::Hardlink)]
+    pub cache_restore_mode: CacheRestoreMode,
+    /// Set how symlinks in task outputs are cached. Use "preserve" to
+    /// store the links as they are. Use "dereference" to store the files
+    /// they point to, e.g. for outputs linked into the pnpm virtual store.
+    /// (default preserve)
+    #[clap(long, env = "TURBO_OUTPUT_SYMLINKS", value_enum, default_value_t = OutputSymlinks::Preserve)]
+    pub output_symlinks: OutputSymlinks,
     /// Limit the concurrency of task execution. Use 1 for serial (i.e.
-    /// one-at-a-time) execution.
+    /// one-at-a-time) execution. Per-task limits can be added as a comma
+    /// separated list (e.g. `10,build=4,test=16`).
     #[clap(long)]
     pub concurrency: Option<String>,
+    /// Accept commands on stdin to pause (`p`), resume (`r`) or change the
+    /// concurrency (`c <n>`) of the run while it's in progress. Ignored when
+    /// stdin isn't a terminal, with the terminal UI or with persistent tasks.
+    #[clap(long)]
+    pub scheduler_control: bool,
+    /// Set how this run coordinates with other runs in the same
+    /// repository. Use "share" to wait for tasks that another run is
+    /// executing and restore their results from the cache. Use "queue" to
+    /// also wait for other queued runs to finish before starting. (default
+    /// share)
+    #[clap(long, env = "TURBO_CONCURRENT_RUNS", value_enum, default_value_t = ConcurrentRuns::Share)]
+    pub concurrent_runs: ConcurrentRuns,
     /// Continue execution even if a task exits with an error or non-zero
-    /// exit code. The default behavior is to bail
-    #[clap(long = "continue")]
-    pub continue_execution: bool,
+    /// exit code. The default behavior is to bail. Use
+    /// "dependencies-successful" to skip the tasks that depend on a failed
+    /// task. (default always)
+    #[clap(long = "continue", value_name = "CONTINUE", value_enum, num_args = 0..=1, require_equals = true, default_missing_value = "always")]
+    pub continue_execution: Option<ContinueMode>,
     #[clap(alias = "dry", long = "dry-run", num_args = 0..=1, default_missing_value = "text")]
     pub dry_run: Option<DryRunMode>,
+    /// Print the hashes of the tasks that would be run as JSON without
+    /// checking the cache or executing them
+    #[clap(long, conflicts_with = "dry_run")]
+    pub hash_only: bool,
     /// Run turbo in single-package mode
     #[clap(long)]
     pub single_package: bool,
-    /// Ignore the existing cache (to force execution)
-    #[clap(long, env = "TURBO_FORCE", default_missing_value = "true")]
-    pub force: Option<Option<bool>>,
+    /// Ignore the existing cache (to force execution). Pass a comma separated
+    /// list of tasks (e.g. `web#build,test`) to only force those tasks, or
+    /// `web#*` to force every task of a package
+    #[clap(long, env = "TURBO_FORCE", default_missing_value = "true", value_parser = parse_force)]
+    pub force: Option<Option<ForceMode>>,
     /// Specify whether or not to do framework inference for tasks
     #[clap(long, value_name = "BOOL", action = ArgAction::Set, default_value = "true", default_missing_value = "true", num_args = 0..=1)]
     pub framework_inference: bool,
@@ -654,10 +1181,16 @@ pub struct RunArgs {
     pub global_deps: Vec<String>,
     /// Generate a graph of the task execution and output to a file when a
     /// filename is specified (.svg, .png, .jpg, .pdf, .json,
-    /// .html, .mermaid, .dot). Outputs dot graph to stdout when if no filename
-    /// is provided
+    /// .html, .mermaid, .mmd, .dot). Outputs dot graph to stdout when if no
+    /// filename is provided, or a Mermaid graph when passed `mermaid`
     #[clap(long, num_args = 0..=1, default_missing_value = "", value_parser = validate_graph_extension)]
     pub graph: Option<String>,
+    /// Set how the graph is drawn. Use "tasks" for a node per task. Use
+    /// "packages" for a node per package, with the dependencies between
+    /// their tasks collapsed into one edge. Use "both" to group the tasks of
+    /// each package into a cluster. (default tasks)
+    #[clap(long, value_enum, default_value_t = GraphMode::Tasks, requires = "graph")]
+    pub graph_mode: GraphMode,
     /// Environment variable mode.
     /// Use "loose" to pass the entire existing environment.
     /// Use "strict" to use an allowlist specified in turbo.json.
@@ -673,6 +1206,11 @@ pub struct RunArgs {
     #[clap(short = 'F', long, group = "scope-filter-group")]
     pub filter: Vec<String>,
 
+    /// Leave the given package(s) out of the run. Supports globs. The same as
+    /// '--filter=!<EXCLUDE>', which shells often need escaped
+    #[clap(long)]
+    pub exclude: Vec<String>,
+
     /// DEPRECATED: Specify package(s) to act as entry
     /// points for task execution. Supports globs.
     #[clap(long, group = "scope-filter-group")]
@@ -685,18 +1223,25 @@ pub struct RunArgs {
     #[clap(long, requires = "scope-filter-group")]
     pub ignore: Vec<String>,
 
+    /// Experimental: Use "file" to pass the files that changed in the git
+    /// range of '--filter' to jest and vitest tasks so that only the tests
+    /// related to them run. (default package)
+    #[clap(long, value_enum, default_value_t = AffectedGranularity::Package, requires = "scope-filter-group")]
+    pub affected_granularity: AffectedGranularity,
+
     //  since only works with scope, so we require it here
     // -----------------------
     /// DEPRECATED: Limit/Set scope to changed packages
     /// since a mergebase. This uses the git diff ${target_branch}...
-    /// mechanism to identify which packages have changed.
-    #[clap(long, requires = "scope")]
+    /// mechanism to identify which packages have changed. Without a ref, the
+    /// default branch of the origin remote is used.
+    #[clap(long, requires = "scope", num_args = 0..=1, default_missing_value = "")]
     pub since: Option<String>,
 
     //  include_dependencies only works with scope, so we require it here
     // -----------------------
     /// DEPRECATED: Include the dependencies of tasks in execution.
-    #[clap(long, requires = "scope")]
+    #[clap(long, requires = "scope", value_name = "BOOL", action = ArgAction::Set, default_value = "false", default_missing_value = "true", num_args = 0..=1)]
     pub include_dependencies: bool,
 
     //  no_deps only works with scope, so we require it here
@@ -704,12 +1249,32 @@ pub struct RunArgs {
     /// DEPRECATED: Exclude dependent task consumers from execution.
     #[clap(long, requires = "scope")]
     pub no_deps: bool,
+    /// DEPRECATED: Include dependent task consumers in execution. Combined
+    /// with `--include-dependencies=false` and `--only`, runs exactly the
+    /// named tasks in the scoped packages. (default true)
+    #[clap(long, requires = "scope", value_name = "BOOL", action = ArgAction::Set, default_missing_value = "true", num_args = 0..=1, conflicts_with = "no_deps")]
+    pub include_dependents: Option<bool>,
+    /// Don't scope the run to the package turbo is invoked from. Without
+    /// filters, tasks run in every package.
+    #[clap(long)]
+    pub no_package_inference: bool,
 
     /// Avoid saving task results to the cache. Useful for development/watch
     /// tasks.
     #[clap(long)]
     pub no_cache: bool,
 
+    /// Cache the logs and exit code of failed tasks so that a repeated run
+    /// replays the failure instead of executing the task again.
+    #[clap(long, conflicts_with = "no_cache")]
+    pub cache_failures: bool,
+
+    /// Only restore files from the cache that match the task's current
+    /// outputs, skipping files that were cached under a previous outputs
+    /// configuration.
+    #[clap(long)]
+    pub restore_declared_outputs: bool,
+
     // clap does not have negation flags such as --daemon and --no-daemon
     // so we need to use a group to enforce that only one of them is set.
     // we set the long name as [no-]daemon with an alias of daemon such
@@ -726,7 +1291,8 @@ pub struct RunArgs {
     /// Set type of process output logging. Use "full" to show
     /// all output. Use "hash-only" to show only turbo-computed
     /// task hashes. Use "new-only" to show only new output with
-    /// only hashes for cached tasks. Use "none" to hide process
+    /// only hashes for cached tasks. Use "errors-only" to show
+    /// only the output of failed tasks. Use "none" to hide process
     /// output. (default full)
     #[clap(long, value_enum)]
     pub output_logs: Option<OutputLogsMode>,
@@ -737,9 +1303,20 @@ pub struct RunArgs {
     /// turbo decide based on its own heuristics. (default auto)
     #[clap(long, env = "TURBO_LOG_ORDER", value_enum, default_value_t = LogOrder::Auto)]
     pub log_order: LogOrder,
+    /// Use "tui" to show a full-screen view of the tasks in the run, where
+    /// the output of each task can be viewed on its own. Only takes effect
+    /// in an interactive terminal. Use "stream" to write task logs to
+    /// stdout. (default stream)
+    #[clap(long, env = "TURBO_UI", value_enum, default_value_t = UIMode::Stream)]
+    pub ui: UIMode,
     /// Only executes the tasks specified, does not execute parent tasks.
     #[clap(long)]
     pub only: bool,
+    /// Only run one shard of the tasks, e.g. "1/4" for the first of four.
+    /// Tasks are split deterministically and each shard also runs the tasks
+    /// its tasks depend on, so shards can run on separate machines.
+    #[clap(long, value_name = "INDEX/TOTAL", value_parser = parse_shard)]
+    pub shard: Option<Shard>,
     /// Execute all tasks in parallel.
     #[clap(long)]
     pub parallel: bool,
@@ -759,13 +1336,51 @@ pub struct RunArgs {
     /// allow reading and caching artifacts using the remote cache.
     #[clap(long, env = "TURBO_REMOTE_ONLY", value_name = "BOOL", action = ArgAction::Set, default_value = "false", default_missing_value = "true", num_args = 0..=1)]
     pub remote_only: bool,
+    /// Ignore the remote cache for all tasks, even if one is configured. Only
+    /// allow reading and caching artifacts using the local filesystem cache.
+    #[clap(long, env = "TURBO_NO_REMOTE", value_name = "BOOL", action = ArgAction::Set, default_value = "false", default_missing_value = "true", num_args = 0..=1, conflicts_with = "remote_only")]
+    pub no_remote: bool,
     /// Treat remote cache as read only
     #[clap(long, env = "TURBO_REMOTE_CACHE_READ_ONLY", value_name = "BOOL", action = ArgAction::Set, default_value = "false", default_missing_value = "true", num_args = 0..=1)]
     #[serde(skip)]
     pub remote_cache_read_only: bool,
+    /// Limit the number of concurrent requests to the remote cache. Applies
+    /// to both downloads and uploads unless they're limited separately
+    #[clap(long, env = "TURBO_REMOTE_CACHE_CONCURRENCY", value_parser = clap::value_parser!(u32).range(1..))]
+    pub remote_cache_concurrency: Option<u32>,
+    /// Limit the number of concurrent downloads from the remote cache
+    #[clap(long, env = "TURBO_REMOTE_CACHE_READ_CONCURRENCY", value_parser = clap::value_parser!(u32).range(1..))]
+    pub remote_cache_read_concurrency: Option<u32>,
+    /// Limit the number of concurrent uploads to the remote cache, e.g.
+    /// for a proxy that rate limits uploads
+    #[clap(long, env = "TURBO_REMOTE_CACHE_WRITE_CONCURRENCY", value_parser = clap::value_parser!(u32).range(1..))]
+    pub remote_cache_write_concurrency: Option<u32>,
+    /// Only write artifacts larger than this many bytes to the local
+    /// cache, e.g. to stay under a remote cache provider's request size
+    /// limit
+    #[clap(
+        long,
+        env = "TURBO_REMOTE_CACHE_MAX_ARTIFACT_SIZE",
+        value_name = "BYTES"
+    )]
+    pub remote_cache_max_artifact_size: Option<u64>,
+    /// Execute every task without reading from the local or remote cache,
+    /// while still writing artifacts to them. Useful for jobs that populate
+    /// the cache for others
+    #[clap(long, env = "TURBO_CACHE_WRITE_ONLY", value_name = "BOOL", action = ArgAction::Set, default_value = "false", default_missing_value = "true", num_args = 0..=1)]
+    #[serde(skip)]
+    pub cache_write_only: bool,
     /// Generate a summary of the turbo run
     #[clap(long, env = "TURBO_RUN_SUMMARY", default_missing_value = "true")]
     pub summarize: Option<Option<bool>>,
+    /// Fail the run if the installed node or npm versions don't satisfy the
+    /// `engines` field of a package in scope
+    #[clap(long)]
+    pub strict_engines: bool,
+    /// Warn about files written by a task that aren't covered by its
+    /// `outputs` and therefore aren't cached
+    #[clap(long)]
+    pub warn_undeclared_outputs: bool,
 
     /// Use "none" to remove prefixes from task logs. Use "task" to get task id
     /// prefixing. Use "auto" to let turbo decide how to prefix the logs
@@ -776,6 +1391,11 @@ pub struct RunArgs {
     /// auto)
     #[clap(long, value_enum, default_value_t = LogPrefix::Auto)]
     pub log_prefix: LogPrefix,
+    /// Prefix each line of task output, including the logs saved to the
+    /// cache, with a timestamp. Use "wall-clock" for the local time or
+    /// "relative" for the time since the task started.
+    #[clap(long, value_enum)]
+    pub log_timestamps: Option<LogTimestamps>,
 
     // NOTE: The following two are hidden because clap displays them in the help text incorrectly:
     // > Usage: turbo [OPTIONS] [TASKS]... [-- <FORWARDED_ARGS>...] [COMMAND]
@@ -816,20 +1436,31 @@ impl RunArgs {
         track_usage!(telemetry, self.framework_inference, |val: bool| !val);
 
         // default to true
-        track_usage!(telemetry, self.continue_execution, |val| val);
         track_usage!(telemetry, self.include_dependencies, |val| val);
         track_usage!(telemetry, self.single_package, |val| val);
         track_usage!(telemetry, self.no_deps, |val| val);
+        track_usage!(telemetry, self.no_package_inference, |val| val);
         track_usage!(telemetry, self.no_cache, |val| val);
+        track_usage!(telemetry, self.cache_failures, |val| val);
+        track_usage!(telemetry, self.restore_declared_outputs, |val| val);
         track_usage!(telemetry, self.daemon, |val| val);
         track_usage!(telemetry, self.no_daemon, |val| val);
         track_usage!(telemetry, self.only, |val| val);
+        track_usage!(telemetry, self.hash_only, |val| val);
         track_usage!(telemetry, self.parallel, |val| val);
+        track_usage!(telemetry, self.scheduler_control, |val| val);
         track_usage!(telemetry, self.remote_only, |val| val);
+        track_usage!(telemetry, self.no_remote, |val| val);
         track_usage!(telemetry, self.remote_cache_read_only, |val| val);
+        track_usage!(telemetry, self.cache_write_only, |val| val);
+        track_usage!(telemetry, self.strict_engines, |val| val);
+        track_usage!(telemetry, self.warn_undeclared_outputs, |val| val);
 
         // default to None
         track_usage!(telemetry, &self.cache_dir, Option::is_some);
+        track_usage!(telemetry, &self.cache_namespace, Option::is_some);
+        track_usage!(telemetry, &self.cache_max_age, Option::is_some);
+        track_usage!(telemetry, &self.cache_drain_timeout, Option::is_some);
         track_usage!(telemetry, &self.profile, Option::is_some);
         track_usage!(telemetry, &self.force, Option::is_some);
         track_usage!(telemetry, &self.since, Option::is_some);
@@ -837,30 +1468,111 @@ impl RunArgs {
         track_usage!(telemetry, &self.anon_profile, Option::is_some);
         track_usage!(telemetry, &self.summarize, Option::is_some);
         track_usage!(telemetry, &self.experimental_space_id, Option::is_some);
+        track_usage!(telemetry, &self.include_dependents, Option::is_some);
+        track_usage!(telemetry, &self.shard, Option::is_some);
 
         // track values
+        if let Some(continue_execution) = &self.continue_execution {
+            telemetry.track_arg_value("continue", continue_execution, EventType::NonSensitive);
+        }
+
         if let Some(dry_run) = &self.dry_run {
             telemetry.track_arg_value("dry-run", dry_run, EventType::NonSensitive);
         }
 
+        if self.affected_granularity != AffectedGranularity::Package {
+            telemetry.track_arg_value(
+                "affected-granularity",
+                self.affected_granularity,
+                EventType::NonSensitive,
+            );
+        }
+
+        if self.cache_restore_mode != CacheRestoreMode::Hardlink {
+            telemetry.track_arg_value(
+                "cache-restore-mode",
+                self.cache_restore_mode,
+                EventType::NonSensitive,
+            );
+        }
+
+        if self.output_symlinks != OutputSymlinks::Preserve {
+            telemetry.track_arg_value(
+                "output-symlinks",
+                self.output_symlinks,
+                EventType::NonSensitive,
+            );
+        }
+
+        if self.concurrent_runs != ConcurrentRuns::Share {
+            telemetry.track_arg_value(
+                "concurrent-runs",
+                self.concurrent_runs,
+                EventType::NonSensitive,
+            );
+        }
+
         if self.cache_workers != DEFAULT_NUM_WORKERS {
             telemetry.track_arg_value("cache-workers", self.cache_workers, EventType::NonSensitive);
         }
 
+        if self.cache_queue_size != DEFAULT_CACHE_QUEUE_SIZE {
+            telemetry.track_arg_value(
+                "cache-queue-size",
+                self.cache_queue_size,
+                EventType::NonSensitive,
+            );
+        }
+
         if let Some(concurrency) = &self.concurrency {
             telemetry.track_arg_value("concurrency", concurrency, EventType::NonSensitive);
         }
 
+        for (arg, concurrency) in [
+            ("remote-cache-concurrency", self.remote_cache_concurrency),
+            (
+                "remote-cache-read-concurrency",
+                self.remote_cache_read_concurrency,
+            ),
+            (
+                "remote-cache-write-concurrency",
+                self.remote_cache_write_concurrency,
+            ),
+        ] {
+            if let Some(concurrency) = concurrency {
+                telemetry.track_arg_value(arg, concurrency, EventType::NonSensitive);
+            }
+        }
+
+        if let Some(size) = self.remote_cache_max_artifact_size {
+            telemetry.track_arg_value(
+                "remote-cache-max-artifact-size",
+                size,
+                EventType::NonSensitive,
+            );
+        }
+
         if !self.global_deps.is_empty() {
-            telemetry.track_arg_value("global-deps", self.cache_workers, EventType::NonSensitive);
+            telemetry.track_arg_value(
+                "global-deps:length",
+                self.global_deps.len(),
+                EventType::NonSensitive,
+            );
         }
 
         if let Some(graph) = &self.graph {
             // track the extension used only
-            let extension = Utf8Path::new(graph).extension().unwrap_or("stdout");
+            let extension = match graph.as_str() {
+                MERMAID_STDOUT_GRAPH => "stdout-mermaid",
+                graph => Utf8Path::new(graph).extension().unwrap_or("stdout"),
+            };
             telemetry.track_arg_value("graph", extension, EventType::NonSensitive);
         }
 
+        if self.graph_mode != GraphMode::default() {
+            telemetry.track_arg_value("graph-mode", self.graph_mode, EventType::NonSensitive);
+        }
+
         if self.env_mode != EnvMode::default() {
             telemetry.track_arg_value("env-mode", self.env_mode, EventType::NonSensitive);
         }
@@ -877,11 +1589,27 @@ impl RunArgs {
             telemetry.track_arg_value("log-prefix", self.log_prefix, EventType::NonSensitive);
         }
 
+        if let Some(log_timestamps) = self.log_timestamps {
+            telemetry.track_arg_value("log-timestamps", log_timestamps, EventType::NonSensitive);
+        }
+
+        if self.ui != UIMode::default() {
+            telemetry.track_arg_value("ui", self.ui, EventType::NonSensitive);
+        }
+
         // track sizes
         if !self.filter.is_empty() {
             telemetry.track_arg_value("filter:length", self.filter.len(), EventType::NonSensitive);
         }
 
+        if !self.exclude.is_empty() {
+            telemetry.track_arg_value(
+                "exclude:length",
+                self.exclude.len(),
+                EventType::NonSensitive,
+            );
+        }
+
         if !self.scope.is_empty() {
             telemetry.track_arg_value("scope:length", self.scope.len(), EventType::NonSensitive);
         }
@@ -918,6 +1646,41 @@ impl Display for LogPrefix {
     }
 }
 
+#[derive(ValueEnum, Clone, Copy, Debug, PartialEq, Serialize)]
+pub enum LogTimestamps {
+    #[serde(rename = "wall-clock")]
+    WallClock,
+    #[serde(rename = "relative")]
+    Relative,
+}
+
+impl Display for LogTimestamps {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            LogTimestamps::WallClock => write!(f, "wall-clock"),
+            LogTimestamps::Relative => write!(f, "relative"),
+        }
+    }
+}
+
+// The path of the directory packages are inferred from, relative to the repo
+// root. Either path may go through a symlink, in which case both are resolved
+// before they're compared. Returns `None` for the repo root itself or a
+// directory outside of the repo.
+fn pkg_inference_root(
+    repo_root: &AbsoluteSystemPath,
+    inference_dir: &AbsoluteSystemPath,
+) -> Option<String> {
+    let relative_path = repo_root.anchor(inference_dir).ok().or_else(|| {
+        repo_root
+            .to_realpath()
+            .ok()?
+            .anchor(&inference_dir.to_realpath().ok()?)
+            .ok()
+    })?;
+    (!relative_path.as_str().is_empty()).then(|| relative_path.to_string())
+}
+
 /// Runs the CLI by parsing arguments with clap, then either calling Rust code
 /// directly or returning a payload for the Go code to use.
 ///
@@ -980,36 +1743,47 @@ pub async fn run(
         Command::Run(Box::new(run_args))
     };
 
+    let ui = if cli_args.json {
+        if let Some(name) = command.without_json_output() {
+            return Err(Error::JsonUnsupported(name));
+        }
+        ui.with_stderr()
+    } else {
+        ui
+    };
+
     // Set some run flags if we have the data and are executing a Run
-    if let Command::Run(run_args) = &mut command {
+    if let Command::Run(run_args) | Command::Prefetch(run_args) = &mut command {
         // Don't overwrite the flag if it's already been set for whatever reason
         run_args.single_package = run_args.single_package
             || repo_state
                 .as_ref()
                 .map(|repo_state| matches!(repo_state.mode, RepoMode::SinglePackage))
                 .unwrap_or(false);
-        // If this is a run command, and we know the actual invocation path, set the
-        // inference root, as long as the user hasn't overridden the cwd
-        if cli_args.cwd.is_none() {
-            if let Ok(invocation_dir) = env::var(INVOCATION_DIR_ENV_VAR) {
-                // TODO: this calculation can probably be wrapped into the path library
-                // and made a little more robust or clear
-                let invocation_path = Utf8Path::new(&invocation_dir);
-
-                // If repo state doesn't exist, we're either local turbo running at the root
-                // (cwd), or inference failed.
-                // If repo state does exist, we're global turbo, and want to calculate
-                // package inference based on the repo root
-                let this_dir = AbsoluteSystemPathBuf::cwd()?;
-                let repo_root = repo_state.as_ref().map_or(&this_dir, |r| &r.root);
-                if let Ok(relative_path) = invocation_path.strip_prefix(repo_root) {
-                    if !relative_path.as_str().is_empty() {
-                        debug!("pkg_inference_root set to \"{}\"", relative_path);
-                        run_args.pkg_inference_root = Some(relative_path.to_string());
-                    }
-                }
-            } else {
+        // If this is a run command, and we know the directory turbo was asked to run
+        // in, set the inference root. The shim sets the invocation dir to `--cwd` if
+        // it was passed, otherwise `--cwd` is used directly.
+        let inference_dir = match env::var(INVOCATION_DIR_ENV_VAR) {
+            Ok(invocation_dir) => Some(AbsoluteSystemPathBuf::from_cwd(invocation_dir)?),
+            Err(_) => {
                 debug!("{} not set", INVOCATION_DIR_ENV_VAR);
+                cli_args
+                    .cwd
+                    .as_deref()
+                    .map(AbsoluteSystemPathBuf::from_cwd)
+                    .transpose()?
+            }
+        };
+        if let Some(inference_dir) = inference_dir {
+            // If repo state doesn't exist, we're either local turbo running at the root
+            // (cwd), or inference failed.
+            // If repo state does exist, we're global turbo, and want to calculate
+            // package inference based on the repo root
+            let this_dir = AbsoluteSystemPathBuf::cwd()?;
+            let repo_root = repo_state.as_ref().map_or(&this_dir, |r| &r.root);
+            if let Some(relative_path) = pkg_inference_root(repo_root, &inference_dir) {
+                debug!("pkg_inference_root set to \"{}\"", relative_path);
+                run_args.pkg_inference_root = Some(relative_path);
             }
         }
     }
@@ -1042,11 +1816,62 @@ pub async fn run(
     cli_args.track(&root_telemetry);
 
     let cli_result = match cli_args.command.as_ref().unwrap() {
+        Command::Bench {
+            packages,
+            files,
+            iterations,
+            json,
+        } => {
+            CommandEventBuilder::new("bench")
+                .with_parent(&root_telemetry)
+                .track_call();
+            let opts = bench::BenchOpts {
+                packages: *packages,
+                files: *files,
+                iterations: *iterations,
+            };
+            let json = *json;
+            let base = CommandBase::new(cli_args.clone(), repo_root, version, ui);
+            bench::run(&base, opts, json).await?;
+
+            Ok(0)
+        }
         Command::Bin { .. } => {
             CommandEventBuilder::new("bin")
                 .with_parent(&root_telemetry)
                 .track_call();
-            bin::run()?;
+            bin::run(cli_args.json)?;
+
+            Ok(0)
+        }
+        Command::Cache { command } => {
+            CommandEventBuilder::new("cache")
+                .with_parent(&root_telemetry)
+                .track_call();
+            let base = CommandBase::new(cli_args.clone(), repo_root, version, ui);
+            cache::run(command, &base).await?;
+
+            Ok(0)
+        }
+        Command::CacheServer {
+            host,
+            port,
+            cache_dir,
+            max_artifact_size,
+            auth_token,
+        } => {
+            CommandEventBuilder::new("cache-server")
+                .with_parent(&root_telemetry)
+                .track_call();
+            let opts = cache_server::ServerOpts {
+                host: *host,
+                port: *port,
+                cache_dir: cache_dir.path(),
+                max_artifact_size: *max_artifact_size,
+                token: auth_token.clone(),
+            };
+            let base = CommandBase::new(cli_args.clone(), repo_root, version, ui);
+            cache_server::run(&base, opts).await?;
 
             Ok(0)
         }
@@ -1085,8 +1910,21 @@ pub async fn run(
             generate::run(tag, command, &args, child_event)?;
             Ok(0)
         }
-        Command::Telemetry { command } => {
-            let event = CommandEventBuilder::new("telemetry").with_parent(&root_telemetry);
+        Command::Graph {
+            diff,
+            filter,
+            tasks,
+        } => {
+            CommandEventBuilder::new("graph")
+                .with_parent(&root_telemetry)
+                .track_call();
+            let base = CommandBase::new(cli_args.clone(), repo_root, version, ui);
+            graph::run(&base, diff.as_deref(), tasks, filter)?;
+
+            Ok(0)
+        }
+        Command::Telemetry { command } => {
+            let event = CommandEventBuilder::new("telemetry").with_parent(&root_telemetry);
             event.track_call();
             let mut base = CommandBase::new(cli_args.clone(), repo_root, version, ui);
             let child_event = event.child();
@@ -1126,6 +1964,15 @@ pub async fn run(
 
             Ok(0)
         }
+        Command::LintWorkspaces { .. } => {
+            CommandEventBuilder::new("lint-workspaces")
+                .with_parent(&root_telemetry)
+                .track_call();
+            let base = CommandBase::new(cli_args.clone(), repo_root, version, ui);
+            lint::run(&base).await?;
+
+            Ok(0)
+        }
         Command::Logout { invalidate } => {
             let event = CommandEventBuilder::new("logout").with_parent(&root_telemetry);
             event.track_call();
@@ -1200,6 +2047,19 @@ pub async fn run(
             })?;
             Ok(exit_code)
         }
+        Command::Prefetch(args) => {
+            let event = CommandEventBuilder::new("prefetch").with_parent(&root_telemetry);
+            event.track_call();
+            if args.tasks.is_empty() {
+                return Err(Error::NoTasks(backtrace::Backtrace::capture()));
+            }
+
+            let base = CommandBase::new(cli_args.clone(), repo_root, version, ui);
+
+            args.track(&event);
+            let exit_code = run::run(base, event).await?;
+            Ok(exit_code)
+        }
         Command::Prune {
             scope,
             scope_arg,
@@ -1245,7 +2105,7 @@ pub async fn run(
 
 #[cfg(test)]
 mod test {
-    use std::assert_matches::assert_matches;
+    use std::{assert_matches::assert_matches, net::IpAddr, time::Duration};
 
     use camino::Utf8PathBuf;
     use clap::Parser;
@@ -1262,6 +2122,7 @@ mod test {
     fn get_default_run_args() -> RunArgs {
         RunArgs {
             cache_workers: 10,
+            cache_queue_size: 10,
             output_logs: None,
             remote_only: false,
             framework_inference: true,
@@ -1304,7 +2165,10 @@ mod test {
     use anyhow::Result;
 
     use crate::cli::{
-        Args, Command, DryRunMode, EnvMode, LogOrder, LogPrefix, OutputLogsMode, RunArgs, Verbosity,
+        parse_force, AffectedGranularity, Args, CacheCommand, CacheDirArgs, CacheRestoreMode,
+        Command, ConcurrentRuns, ContinueMode, DaemonCommand, DaemonRpc, DryRunMode, EnvMode,
+        ForceMode, LogOrder, LogPrefix, LogTimestamps, OutputLogsMode, OutputSymlinks, RunArgs,
+        Shard, UIMode, Verbosity,
     };
 
     #[test_case::test_case(
@@ -1448,6 +2312,18 @@ mod test {
             ..Args::default()
         }
 	)]
+    #[test_case::test_case(
+		&["turbo", "run", "build", "--cache-queue-size", "50", "--cache-drain-timeout", "30"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                cache_queue_size: 50,
+                cache_drain_timeout: Some(Duration::from_secs(30)),
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        }
+	)]
     #[test_case::test_case(
 		&["turbo", "run", "build", "--cache-workers", "100"],
         Args {
@@ -1459,6 +2335,17 @@ mod test {
             ..Args::default()
         }
 	)]
+    #[test_case::test_case(
+		&["turbo", "run", "build", "--cache-namespace", "staging"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                cache_namespace: Some("staging".to_string()),
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        }
+	)]
     #[test_case::test_case(
 		&["turbo", "run", "build", "--concurrency", "20"],
         Args {
@@ -1470,12 +2357,45 @@ mod test {
             ..Args::default()
         }
 	)]
+    #[test_case::test_case(
+		&["turbo", "run", "build", "--concurrency", "10,build=4"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                concurrency: Some("10,build=4".to_string()),
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        }
+	)]
     #[test_case::test_case(
 		&["turbo", "run", "build", "--continue"],
         Args {
             command: Some(Command::Run(Box::new(RunArgs {
                 tasks: vec!["build".to_string()],
-                continue_execution: true,
+                continue_execution: Some(ContinueMode::Always),
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        }
+	)]
+    #[test_case::test_case(
+		&["turbo", "run", "--continue", "build"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                continue_execution: Some(ContinueMode::Always),
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        }
+	)]
+    #[test_case::test_case(
+		&["turbo", "run", "build", "--continue=dependencies-successful"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                continue_execution: Some(ContinueMode::DependenciesSuccessful),
                 ..get_default_run_args()
             }))),
             ..Args::default()
@@ -1503,6 +2423,17 @@ mod test {
             ..Args::default()
         }
 	)]
+    #[test_case::test_case(
+		&["turbo", "run", "build", "--dry=table"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                dry_run: Some(DryRunMode::Table),
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        }
+	)]
     #[test_case::test_case(
 		&["turbo", "run", "build", "--filter", "water", "--filter", "earth", "--filter", "fire", "--filter", "air"],
         Args {
@@ -1551,12 +2482,59 @@ mod test {
             ..Args::default()
         }
 	)]
+    #[test_case::test_case(
+		&["turbo", "run", "build", "--exclude", "@acme/legacy-*", "--exclude", "docs"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                exclude: vec!["@acme/legacy-*".to_string(), "docs".to_string()],
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        }
+	)]
     #[test_case::test_case(
 		&["turbo", "run", "build", "--force"],
         Args {
             command: Some(Command::Run(Box::new(RunArgs {
                 tasks: vec!["build".to_string()],
-                force: Some(Some(true)),
+                force: Some(Some(ForceMode::All(true))),
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        }
+	)]
+    #[test_case::test_case(
+		&["turbo", "run", "build", "--hash-only"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                hash_only: true,
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        }
+	)]
+    #[test_case::test_case(
+		&["turbo", "run", "build", "--force=false"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                force: Some(Some(ForceMode::All(false))),
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        }
+	)]
+    #[test_case::test_case(
+		&["turbo", "run", "build", "--force=web#build,test"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                force: Some(Some(ForceMode::Tasks(vec![
+                    "web#build".to_string(),
+                    "test".to_string()
+                ]))),
                 ..get_default_run_args()
             }))),
             ..Args::default()
@@ -1595,6 +2573,17 @@ mod test {
             ..Args::default()
         }
 	)]
+    #[test_case::test_case(
+		&["turbo", "run", "build", "--graph=mermaid"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                graph: Some("mermaid".to_string()),
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        }
+	)]
     #[test_case::test_case(
 		&["turbo", "run", "build", "--graph", "out.html"],
         Args {
@@ -1645,6 +2634,20 @@ mod test {
         } ;
         "include dependencies"
 	)]
+    #[test_case::test_case(
+		&["turbo", "run", "build", "--scope", "test", "--include-dependencies=false", "--include-dependents=false"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                include_dependencies: false,
+                include_dependents: Some(false),
+                scope: vec!["test".to_string()],
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        } ;
+        "exclude dependencies and dependents"
+	)]
     #[test_case::test_case(
 		&["turbo", "run", "build", "--no-cache"],
         Args {
@@ -1656,6 +2659,50 @@ mod test {
             ..Args::default()
         }
 	)]
+    #[test_case::test_case(
+		&["turbo", "run", "build", "--strict-engines"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                strict_engines: true,
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        }
+	)]
+    #[test_case::test_case(
+		&["turbo", "run", "build", "--warn-undeclared-outputs"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                warn_undeclared_outputs: true,
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        }
+	)]
+    #[test_case::test_case(
+		&["turbo", "run", "build", "--cache-failures"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                cache_failures: true,
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        }
+	)]
+    #[test_case::test_case(
+		&["turbo", "run", "build", "--restore-declared-outputs"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                restore_declared_outputs: true,
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        }
+	)]
     #[test_case::test_case(
 		&["turbo", "run", "build", "--no-daemon"],
         Args {
@@ -1746,6 +2793,73 @@ mod test {
             ..Args::default()
         }
 	)]
+    #[test_case::test_case(
+		&["turbo", "run", "build", "--log-timestamps", "relative"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                log_timestamps: Some(LogTimestamps::Relative),
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        }
+	)]
+    #[test_case::test_case(
+		&["turbo", "run", "build", "--ui", "tui"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                ui: UIMode::Tui,
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        }
+	)]
+    #[test_case::test_case(
+		&["turbo", "run", "build", "--cache-restore-mode", "copy"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                cache_restore_mode: CacheRestoreMode::Copy,
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        }
+	)]
+    #[test_case::test_case(
+		&["turbo", "run", "build", "--output-symlinks", "dereference"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                output_symlinks: OutputSymlinks::Dereference,
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        }
+	)]
+    #[test_case::test_case(
+		&["turbo", "run", "build", "--concurrent-runs", "queue"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                concurrent_runs: ConcurrentRuns::Queue,
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        }
+	)]
+    #[test_case::test_case(
+		&["turbo", "run", "test", "--filter", "[main]", "--affected-granularity", "file"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["test".to_string()],
+                filter: vec!["[main]".to_string()],
+                affected_granularity: AffectedGranularity::File,
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        }
+	)]
     #[test_case::test_case(
 		&["turbo", "run", "build", "--log-prefix", "auto"],
         Args {
@@ -1861,6 +2975,18 @@ mod test {
 		} ;
         "remote_only=false works"
 	)]
+    #[test_case::test_case(
+		&["turbo", "run", "build", "--no-remote"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                no_remote: true,
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+		} ;
+        "no_remote with no value, means true"
+	)]
     #[test_case::test_case(
 		&["turbo", "run", "build", "--scope", "foo", "--scope", "bar"],
         Args {
@@ -1885,6 +3011,19 @@ mod test {
         } ;
         "scope and since"
 	)]
+    #[test_case::test_case(
+		&["turbo", "run", "build", "--scope", "test", "--since"],
+        Args {
+            command: Some(Command::Run(Box::new(RunArgs {
+                tasks: vec!["build".to_string()],
+                scope: vec!["test".to_string()],
+                since: Some(String::new()),
+                ..get_default_run_args()
+            }))),
+            ..Args::default()
+        } ;
+        "scope and since default branch"
+	)]
     #[test_case::test_case(
 		&["turbo", "build"],
         Args {
@@ -1943,6 +3082,11 @@ mod test {
         "the following required arguments were not provided" ;
         "no-deps without filter or scope"
     )]
+    #[test_case::test_case(
+        &["turbo", "run", "build", "--remote-only", "--no-remote"],
+        "cannot be used with '--remote-only" ;
+        "remote-only and no-remote at the same time"
+    )]
     fn test_parse_run_failures(args: &[&str], expected: &str) {
         assert_matches!(
             Args::try_parse_from(args),
@@ -1950,6 +3094,17 @@ mod test {
         );
     }
 
+    #[test_case::test_case("true", ForceMode::All(true) ; "true")]
+    #[test_case::test_case("TRUE", ForceMode::All(true) ; "uppercase true")]
+    #[test_case::test_case("1", ForceMode::All(true) ; "one")]
+    #[test_case::test_case("false", ForceMode::All(false) ; "false")]
+    #[test_case::test_case("False", ForceMode::All(false) ; "mixed case false")]
+    #[test_case::test_case("0", ForceMode::All(false) ; "zero")]
+    #[test_case::test_case("web#build, test", ForceMode::Tasks(vec!["web#build".to_string(), "test".to_string()]) ; "tasks")]
+    fn test_parse_force(value: &str, expected: ForceMode) {
+        assert_eq!(parse_force(value), Ok(expected));
+    }
+
     #[test]
     fn test_parse_bin() {
         assert_eq!(
@@ -1973,6 +3128,323 @@ mod test {
         .test();
     }
 
+    #[test]
+    fn test_parse_lint_workspaces() {
+        assert_eq!(
+            Args::try_parse_from(["turbo", "lint-workspaces"]).unwrap(),
+            Args {
+                command: Some(Command::LintWorkspaces {}),
+                ..Args::default()
+            }
+        );
+    }
+
+    #[test]
+    fn test_parse_graph_diff() {
+        assert_eq!(
+            Args::try_parse_from(["turbo", "graph", "--diff", "main", "build", "--filter=web"])
+                .unwrap(),
+            Args {
+                command: Some(Command::Graph {
+                    diff: Some("main".to_string()),
+                    filter: vec!["web".to_string()],
+                    tasks: vec!["build".to_string()],
+                }),
+                ..Args::default()
+            }
+        );
+        assert_eq!(
+            Args::try_parse_from(["turbo", "graph", "build"]).unwrap(),
+            Args {
+                command: Some(Command::Graph {
+                    diff: None,
+                    filter: vec![],
+                    tasks: vec!["build".to_string()],
+                }),
+                ..Args::default()
+            }
+        );
+        assert!(Args::try_parse_from(["turbo", "graph", "--diff", "main"]).is_err());
+    }
+
+    #[test]
+    fn test_parse_bench() {
+        assert_eq!(
+            Args::try_parse_from(["turbo", "bench", "--packages", "10"]).unwrap(),
+            Args {
+                command: Some(Command::Bench {
+                    packages: 10,
+                    files: 50,
+                    iterations: 3,
+                    json: false,
+                }),
+                ..Args::default()
+            }
+        );
+    }
+
+    #[test]
+    fn test_parse_cache_server() {
+        assert_eq!(
+            Args::try_parse_from([
+                "turbo",
+                "cache-server",
+                "--port",
+                "8080",
+                "--auth-token",
+                "secret"
+            ])
+            .unwrap(),
+            Args {
+                command: Some(Command::CacheServer {
+                    host: IpAddr::from([127, 0, 0, 1]),
+                    port: 8080,
+                    cache_dir: CacheDirArgs::default(),
+                    max_artifact_size: 512 * 1024 * 1024,
+                    auth_token: Some("secret".to_string()),
+                }),
+                ..Args::default()
+            }
+        );
+    }
+
+    #[test]
+    fn test_parse_cache_ls() {
+        assert_eq!(
+            Args::try_parse_from(["turbo", "cache", "ls", "--json"]).unwrap(),
+            Args {
+                command: Some(Command::Cache {
+                    command: CacheCommand::Ls {
+                        hash: None,
+                        cache_dir: CacheDirArgs::default(),
+                        json: true,
+                    }
+                }),
+                // The global flag is set along with the command's own
+                json: true,
+                ..Args::default()
+            }
+        );
+        assert_eq!(
+            Args::try_parse_from(["turbo", "cache", "ls", "abc123"]).unwrap(),
+            Args {
+                command: Some(Command::Cache {
+                    command: CacheCommand::Ls {
+                        hash: Some("abc123".to_string()),
+                        cache_dir: CacheDirArgs::default(),
+                        json: false,
+                    }
+                }),
+                ..Args::default()
+            }
+        );
+    }
+
+    #[test]
+    fn test_parse_global_json() {
+        assert_eq!(
+            Args::try_parse_from(["turbo", "--json", "cache", "stats"]).unwrap(),
+            Args {
+                command: Some(Command::Cache {
+                    command: CacheCommand::Stats { json: true }
+                }),
+                json: true,
+                ..Args::default()
+            }
+        );
+        assert_eq!(
+            Args::try_parse_from(["turbo", "run", "build", "--json"]).unwrap(),
+            Args {
+                command: Some(Command::Run(Box::new(RunArgs {
+                    tasks: vec!["build".to_string()],
+                    ..get_default_run_args()
+                }))),
+                json: true,
+                ..Args::default()
+            }
+        );
+        assert_eq!(
+            Args::try_parse_from(["turbo", "link", "--json"])
+                .unwrap()
+                .command
+                .unwrap()
+                .without_json_output(),
+            Some("link")
+        );
+    }
+
+    #[test]
+    fn test_parse_cache_inspect() {
+        assert_eq!(
+            Args::try_parse_from(["turbo", "cache", "inspect", "abc123", "--remote", "--json"])
+                .unwrap(),
+            Args {
+                command: Some(Command::Cache {
+                    command: CacheCommand::Inspect {
+                        hash: "abc123".to_string(),
+                        remote: true,
+                        cache_dir: CacheDirArgs::default(),
+                        json: true,
+                    }
+                }),
+                json: true,
+                ..Args::default()
+            }
+        );
+        assert!(Args::try_parse_from(["turbo", "cache", "inspect"]).is_err());
+    }
+
+    #[test]
+    fn test_parse_cache_clean() {
+        assert_eq!(
+            Args::try_parse_from(["turbo", "cache", "clean", "--hash", "abc123"]).unwrap(),
+            Args {
+                command: Some(Command::Cache {
+                    command: CacheCommand::Clean {
+                        hash: Some("abc123".to_string()),
+                        all: false,
+                        cache_dir: CacheDirArgs::default(),
+                    }
+                }),
+                ..Args::default()
+            }
+        );
+        assert!(Args::try_parse_from(["turbo", "cache", "clean", "--all"]).is_ok());
+        assert!(Args::try_parse_from(["turbo", "cache", "clean"]).is_err());
+        assert!(
+            Args::try_parse_from(["turbo", "cache", "clean", "--hash", "abc123", "--all"]).is_err()
+        );
+    }
+
+    #[test]
+    fn test_parse_cache_stats() {
+        assert_eq!(
+            Args::try_parse_from(["turbo", "cache", "stats", "--json"]).unwrap(),
+            Args {
+                command: Some(Command::Cache {
+                    command: CacheCommand::Stats { json: true }
+                }),
+                json: true,
+                ..Args::default()
+            }
+        );
+    }
+
+    #[test]
+    fn test_parse_cache_gc() {
+        let day = Duration::from_secs(24 * 60 * 60);
+        assert_eq!(
+            Args::try_parse_from(["turbo", "cache", "gc"]).unwrap(),
+            Args {
+                command: Some(Command::Cache {
+                    command: CacheCommand::Gc {
+                        max_age: 7 * day,
+                        cache_dir: CacheDirArgs::default(),
+                    }
+                }),
+                ..Args::default()
+            }
+        );
+        assert_eq!(
+            Args::try_parse_from(["turbo", "run", "build", "--cache-max-age", "30"]).unwrap(),
+            Args {
+                command: Some(Command::Run(Box::new(RunArgs {
+                    tasks: vec!["build".to_string()],
+                    cache_max_age: Some(30 * day),
+                    ..get_default_run_args()
+                }))),
+                ..Args::default()
+            }
+        );
+        assert!(Args::try_parse_from(["turbo", "cache", "gc", "--max-age", "a week"]).is_err());
+    }
+
+    #[test]
+    fn test_parse_shard() {
+        assert_eq!(
+            Args::try_parse_from(["turbo", "run", "test", "--shard=2/4"]).unwrap(),
+            Args {
+                command: Some(Command::Run(Box::new(RunArgs {
+                    tasks: vec!["test".to_string()],
+                    shard: Some(Shard { index: 2, total: 4 }),
+                    ..get_default_run_args()
+                }))),
+                ..Args::default()
+            }
+        );
+        for invalid in ["0/4", "5/4", "1/0", "1", "a/b"] {
+            assert!(
+                Args::try_parse_from(["turbo", "run", "test", "--shard", invalid]).is_err(),
+                "{invalid} should be rejected"
+            );
+        }
+    }
+
+    #[test]
+    fn test_parse_cache_export() {
+        assert_eq!(
+            Args::try_parse_from([
+                "turbo",
+                "cache",
+                "export",
+                "out.tar",
+                "--hash",
+                "abc123",
+                "--task",
+                "web#build"
+            ])
+            .unwrap(),
+            Args {
+                command: Some(Command::Cache {
+                    command: CacheCommand::Export {
+                        output: Utf8PathBuf::from("out.tar"),
+                        hashes: vec!["abc123".to_string()],
+                        tasks: vec!["web#build".to_string()],
+                        all: false,
+                        cache_dir: CacheDirArgs::default(),
+                    }
+                }),
+                ..Args::default()
+            }
+        );
+        assert!(Args::try_parse_from(["turbo", "cache", "export", "out.tar", "--all"]).is_ok());
+        assert!(Args::try_parse_from(["turbo", "cache", "export", "out.tar"]).is_err());
+        assert!(Args::try_parse_from(["turbo", "cache", "import", "out.tar"]).is_ok());
+    }
+
+    #[test]
+    fn test_parse_cache_verify() {
+        assert_eq!(
+            Args::try_parse_from(["turbo", "cache", "verify", "--json"]).unwrap(),
+            Args {
+                command: Some(Command::Cache {
+                    command: CacheCommand::Verify {
+                        hash: None,
+                        cache_dir: CacheDirArgs::default(),
+                        json: true,
+                    }
+                }),
+                ..Args::default()
+            }
+        );
+        assert!(Args::try_parse_from(["turbo", "cache", "verify", "abc123"]).is_ok());
+    }
+
+    #[test]
+    fn test_parse_prefetch() {
+        assert_eq!(
+            Args::try_parse_from(["turbo", "prefetch", "build", "--filter=web"]).unwrap(),
+            Args {
+                command: Some(Command::Prefetch(Box::new(RunArgs {
+                    tasks: vec!["build".to_string()],
+                    filter: vec!["web".to_string()],
+                    ..get_default_run_args()
+                }))),
+                ..Args::default()
+            }
+        );
+    }
+
     #[test]
     fn test_parse_login() {
         assert_eq!(
@@ -2040,6 +3512,31 @@ mod test {
         .test();
     }
 
+    #[test_case::test_case(&["turbo", "daemon", "client"], None ; "no rpc")]
+    #[test_case::test_case(&["turbo", "daemon", "client", "status"], Some(DaemonRpc::Status) ; "status")]
+    #[test_case::test_case(
+        &["turbo", "daemon", "client", "discover-packages-blocking"],
+        Some(DaemonRpc::DiscoverPackagesBlocking)
+        ; "discover packages blocking"
+    )]
+    fn test_parse_daemon_client(args: &[&str], rpc: Option<DaemonRpc>) {
+        assert_eq!(
+            Args::try_parse_from(args).unwrap(),
+            Args {
+                command: Some(Command::Daemon {
+                    idle_time: "4h0m0s".to_string(),
+                    command: Some(DaemonCommand::Client { rpc }),
+                }),
+                ..Args::default()
+            }
+        );
+    }
+
+    #[test]
+    fn test_parse_daemon_client_unknown_rpc() {
+        assert!(Args::try_parse_from(["turbo", "daemon", "client", "shutdown"]).is_err());
+    }
+
     #[test]
     fn test_parse_unlink() {
         assert_eq!(
@@ -2365,5 +3862,35 @@ mod test {
         assert!(Args::try_parse_from(["turbo", "build", "--cache-dir"]).is_err());
         assert!(Args::try_parse_from(["turbo", "build", "--cache-dir="]).is_err());
         assert!(Args::try_parse_from(["turbo", "build", "--cache-dir", ""]).is_err());
+        assert!(Args::try_parse_from(["turbo", "cache", "ls", "--cache-dir", ""]).is_err());
+        assert!(Args::try_parse_from(["turbo", "cache-server", "--cache-dir="]).is_err());
+    }
+
+    #[test]
+    fn test_pkg_inference_root() -> Result<()> {
+        let tmp = tempfile::tempdir()?;
+        let tmp_path = turbopath::AbsoluteSystemPath::from_std_path(tmp.path())?;
+        let repo_root = tmp_path.join_component("repo");
+        let package_dir = repo_root.join_components(&["apps", "web"]);
+        package_dir.create_dir_all()?;
+
+        assert_eq!(
+            super::pkg_inference_root(&repo_root, &package_dir),
+            Some(["apps", "web"].join(std::path::MAIN_SEPARATOR_STR))
+        );
+        assert_eq!(super::pkg_inference_root(&repo_root, &repo_root), None);
+        assert_eq!(super::pkg_inference_root(&repo_root, tmp_path), None);
+
+        #[cfg(unix)]
+        {
+            // `--cwd` can point into the repo through a symlink
+            let link = tmp_path.join_component("link");
+            link.symlink_to_dir(package_dir.as_str())?;
+            assert_eq!(
+                super::pkg_inference_root(&repo_root, &link),
+                Some("apps/web".to_string())
+            );
+        }
+        Ok(())
     }
 }
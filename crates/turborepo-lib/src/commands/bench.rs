@@ -0,0 +1,289 @@
+//! A hidden command for tracking the performance of turbo's internals across
+//! releases. It generates a synthetic monorepo and times how long it takes
+//! to build its package graph, hash its files and write and restore its
+//! packages in the local cache.
+
+use std::{
+    io::{self, Write},
+    process::{Command, Stdio},
+    time::{Duration, Instant},
+};
+
+use camino::Utf8Path;
+use serde::Serialize;
+use tabwriter::TabWriter;
+use thiserror::Error;
+use tracing::debug;
+use turbopath::{AbsoluteSystemPath, AnchoredSystemPathBuf, PathError};
+use turborepo_cache::{fs::FSCache, CacheError};
+use turborepo_repository::{
+    package_graph::{self, PackageGraph},
+    package_json::{self, PackageJson},
+};
+use turborepo_scm::SCM;
+use turborepo_ui::{cprintln, GREY};
+
+use super::CommandBase;
+
+#[derive(Debug, Error)]
+pub enum Error {
+    #[error("failed to generate the benchmark repository: {0}")]
+    Generate(#[source] io::Error),
+    #[error(transparent)]
+    Path(#[from] PathError),
+    #[error(transparent)]
+    PackageJson(#[from] package_json::Error),
+    #[error(transparent)]
+    PackageGraph(#[from] package_graph::builder::Error),
+    #[error(transparent)]
+    Scm(#[from] turborepo_scm::Error),
+    #[error(transparent)]
+    Cache(#[from] CacheError),
+    #[error(transparent)]
+    Io(#[from] io::Error),
+    #[error(transparent)]
+    SerdeJson(#[from] serde_json::Error),
+}
+
+/// The shape of the generated monorepo
+#[derive(Debug, Clone, Copy)]
+pub struct BenchOpts {
+    pub packages: usize,
+    pub files: usize,
+    pub iterations: usize,
+}
+
+#[derive(Debug, Serialize)]
+#[serde(rename_all = "camelCase")]
+struct BenchReport {
+    packages: usize,
+    files_per_package: usize,
+    iterations: usize,
+    scm: &'static str,
+    results: Vec<Measurement>,
+}
+
+/// The timings of one operation over every iteration, in milliseconds
+#[derive(Debug, Serialize)]
+#[serde(rename_all = "camelCase")]
+struct Measurement {
+    name: &'static str,
+    min_ms: f64,
+    mean_ms: f64,
+    max_ms: f64,
+}
+
+impl Measurement {
+    fn new(name: &'static str, durations: &[Duration]) -> Self {
+        let millis = durations
+            .iter()
+            .map(|duration| duration.as_secs_f64() * 1000.0)
+            .collect::<Vec<_>>();
+        Self {
+            name,
+            min_ms: millis.iter().copied().fold(f64::INFINITY, f64::min),
+            mean_ms: millis.iter().sum::<f64>() / millis.len().max(1) as f64,
+            max_ms: millis.iter().copied().fold(0.0, f64::max),
+        }
+    }
+}
+
+pub async fn run(base: &CommandBase, opts: BenchOpts, json: bool) -> Result<(), Error> {
+    let tmp_dir = tempfile::tempdir().map_err(Error::Generate)?;
+    let dir = AbsoluteSystemPath::from_std_path(tmp_dir.path())?.to_realpath()?;
+    // Caches are kept outside of the repository so they aren't hashed
+    let repo_root = dir.join_component("repo");
+    if !json {
+        cprintln!(
+            base.ui,
+            GREY,
+            "Generating {} packages with {} files each in {}",
+            opts.packages,
+            opts.files,
+            repo_root
+        );
+    }
+    let files = generate_repo(&repo_root, opts).map_err(Error::Generate)?;
+    let scm = SCM::new(&repo_root);
+
+    let iterations = opts.iterations.max(1);
+    let mut package_graph_times = Vec::with_capacity(iterations);
+    let mut hashing_times = Vec::with_capacity(iterations);
+    let mut cache_put_times = Vec::with_capacity(iterations);
+    let mut cache_fetch_times = Vec::with_capacity(iterations);
+    for iteration in 0..iterations {
+        debug!("benchmark iteration {}", iteration);
+
+        let start = Instant::now();
+        let root_package_json = PackageJson::load(&repo_root.join_component("package.json"))?;
+        let package_graph = PackageGraph::builder(&repo_root, root_package_json)
+            .build()
+            .await?;
+        package_graph_times.push(start.elapsed());
+
+        let start = Instant::now();
+        for (_, info) in package_graph.packages() {
+            scm.get_package_file_hashes::<&str>(&repo_root, info.package_path(), &[], None)?;
+        }
+        hashing_times.push(start.elapsed());
+
+        // Each iteration starts from an empty cache so that every put writes
+        let cache_dir = dir.join_component(&format!("cache-{}", iteration));
+        let cache = FSCache::new(Some(Utf8Path::new(cache_dir.as_str())), &repo_root, None)?;
+        let start = Instant::now();
+        for (hash, package_files) in &files {
+            cache.put(&repo_root, hash, package_files, 0)?;
+        }
+        cache_put_times.push(start.elapsed());
+
+        let start = Instant::now();
+        for (hash, _) in &files {
+            cache.fetch(&repo_root, hash)?;
+        }
+        cache_fetch_times.push(start.elapsed());
+    }
+
+    let report = BenchReport {
+        packages: opts.packages,
+        files_per_package: opts.files,
+        iterations,
+        scm: match scm.is_manual() {
+            true => "manual",
+            false => "git",
+        },
+        results: vec![
+            Measurement::new("package graph", &package_graph_times),
+            Measurement::new("file hashing", &hashing_times),
+            Measurement::new("cache put", &cache_put_times),
+            Measurement::new("cache fetch", &cache_fetch_times),
+        ],
+    };
+    if json {
+        println!("{}", serde_json::to_string_pretty(&report)?);
+    } else {
+        print_report(base, &report)?;
+    }
+
+    Ok(())
+}
+
+fn print_report(base: &CommandBase, report: &BenchReport) -> Result<(), Error> {
+    cprintln!(
+        base.ui,
+        GREY,
+        "{} iterations, hashing with {}\n",
+        report.iterations,
+        report.scm
+    );
+
+    let mut tab_writer = TabWriter::new(io::stdout()).minwidth(0).padding(2);
+    writeln!(tab_writer, "Operation\tMin\tMean\tMax")?;
+    for measurement in &report.results {
+        writeln!(
+            tab_writer,
+            "{}\t{:.1}ms\t{:.1}ms\t{:.1}ms",
+            measurement.name, measurement.min_ms, measurement.mean_ms, measurement.max_ms
+        )?;
+    }
+    tab_writer.flush()?;
+
+    Ok(())
+}
+
+// Generates an npm workspace where each package depends on a couple of the
+// packages before it, so that the package graph is a DAG with some depth.
+// Returns the files of each package keyed by the hash they're cached under.
+fn generate_repo(
+    repo_root: &AbsoluteSystemPath,
+    opts: BenchOpts,
+) -> io::Result<Vec<(String, Vec<AnchoredSystemPathBuf>)>> {
+    repo_root.create_dir_all()?;
+    repo_root
+        .join_component("package.json")
+        .create_with_contents(
+            serde_json::json!({
+                "name": "bench",
+                "private": true,
+                "packageManager": "npm@10.2.4",
+                "workspaces": ["packages/*"],
+            })
+            .to_string(),
+        )?;
+    repo_root
+        .join_component("turbo.json")
+        .create_with_contents(
+            serde_json::json!({
+                "pipeline": { "build": { "dependsOn": ["^build"], "outputs": ["dist/**"] } }
+            })
+            .to_string(),
+        )?;
+
+    let mut files = Vec::with_capacity(opts.packages);
+    for i in 0..opts.packages {
+        let name = format!("pkg-{}", i);
+        let package_dir = repo_root.join_components(&["packages", &name]);
+        let dependencies = [i.checked_sub(1), (i > 1).then_some(i / 2)]
+            .into_iter()
+            .flatten()
+            .map(|dependency| (format!("pkg-{}", dependency), serde_json::json!("*")))
+            .collect::<serde_json::Map<_, _>>();
+        let package_json = package_dir.join_component("package.json");
+        package_json.ensure_dir()?;
+        package_json.create_with_contents(
+            serde_json::json!({
+                "name": name,
+                "version": "0.0.0",
+                "scripts": { "build": "echo building" },
+                "dependencies": dependencies,
+            })
+            .to_string(),
+        )?;
+
+        let mut package_files = Vec::with_capacity(opts.files);
+        for j in 0..opts.files {
+            let file = package_dir.join_components(&["src", &format!("file-{}.js", j)]);
+            file.ensure_dir()?;
+            file.create_with_contents(format!("export const value{j} = {i} * {j};\n").repeat(32))?;
+            package_files.push(
+                repo_root
+                    .anchor(&file)
+                    .map_err(|e| io::Error::new(io::ErrorKind::Other, e))?,
+            );
+        }
+        files.push((format!("bench-{}", i), package_files));
+    }
+
+    commit_repo(repo_root);
+
+    Ok(files)
+}
+
+// Hashing is fastest with git, so the repository is committed when git is
+// available. Otherwise files are hashed manually, which is reported.
+fn commit_repo(repo_root: &AbsoluteSystemPath) {
+    let git = |args: &[&str]| {
+        Command::new("git")
+            .args(args)
+            .current_dir(repo_root.as_std_path())
+            .stdout(Stdio::null())
+            .stderr(Stdio::null())
+            .status()
+            .map_or(false, |status| status.success())
+    };
+    let committed = git(&["init", "-q"])
+        && git(&["add", "-A"])
+        && git(&[
+            "-c",
+            "user.name=turbo",
+            "-c",
+            "user.email=turbo@example.com",
+            "commit",
+            "-q",
+            "--no-gpg-sign",
+            "-m",
+            "bench",
+        ]);
+    if !committed {
+        debug!("failed to commit the benchmark repository");
+    }
+}
@@ -1,5 +1,6 @@
 use std::{env::current_exe, io};
 
+use serde_json::json;
 use thiserror::Error;
 
 #[derive(Debug, Error)]
@@ -8,8 +9,12 @@ pub enum Error {
     NoCurrentExe(#[from] io::Error),
 }
 
-pub fn run() -> Result<(), Error> {
+pub fn run(json: bool) -> Result<(), Error> {
     let path = current_exe()?;
+    if json {
+        println!("{}", json!({ "path": path.to_string_lossy() }));
+        return Ok(());
+    }
     // NOTE: The Go version uses `base.UI.Output`, we should use the Rust equivalent
     // eventually.
     println!("{}", path.to_string_lossy());
@@ -0,0 +1,563 @@
+use std::{
+    fs::File,
+    io::{self, BufReader, BufWriter, Write},
+    time::{SystemTime, UNIX_EPOCH},
+};
+
+use camino::Utf8Path;
+use serde::Serialize;
+use tabwriter::TabWriter;
+use thiserror::Error;
+use turbopath::RelativeUnixPath;
+use turborepo_cache::{
+    bundle::{self, BundledArtifact, ImportedArtifacts},
+    fs::{ArtifactListing, FSCache, Integrity, RemovedArtifacts, VerifiedArtifact},
+    http::HTTPCache,
+    ArtifactEntry, ArtifactEntryKind, ArtifactInfo, CacheError, CacheOpts, CacheSource,
+    RemoteCacheOpts,
+};
+use turborepo_repository::{
+    package_graph::{self, PackageGraph},
+    package_json::{self, PackageJson},
+};
+use turborepo_ui::{cprintln, GREY};
+
+use super::CommandBase;
+use crate::{
+    cli::CacheCommand,
+    config::Error as ConfigError,
+    run::{
+        cleanup::{self, RemovedFiles},
+        summary::cache_stats::{self, CacheStats},
+        task_id::{TaskId, TaskName},
+    },
+};
+
+#[derive(Debug, Error)]
+pub enum Error {
+    #[error("no artifact for {hash} found in the {location} cache")]
+    NotFound {
+        hash: String,
+        location: &'static str,
+    },
+    #[error("remote caching is not enabled, run `turbo login` and `turbo link` to enable it")]
+    RemoteCacheDisabled,
+    #[error("no artifacts in the local cache match the given tasks")]
+    NoMatchingArtifacts,
+    #[error(
+        "{0} artifacts in the local cache are corrupted, they'll be removed the next time they're \
+         restored"
+    )]
+    CorruptArtifacts(usize),
+    #[error(transparent)]
+    Cache(#[from] CacheError),
+    #[error(transparent)]
+    Config(#[from] ConfigError),
+    #[error(transparent)]
+    Io(#[from] io::Error),
+    #[error(transparent)]
+    SerdeJson(#[from] serde_json::Error),
+    #[error(transparent)]
+    PackageJson(#[from] package_json::Error),
+    #[error(transparent)]
+    PackageGraph(#[from] package_graph::builder::Error),
+}
+
+#[derive(Serialize)]
+#[serde(rename_all = "camelCase")]
+struct ArtifactSummary<'a> {
+    hash: &'a str,
+    source: &'static str,
+    time_saved: u64,
+    entries: &'a [ArtifactEntry],
+}
+
+#[derive(Serialize)]
+#[serde(rename_all = "camelCase")]
+struct GcSummary {
+    cache: RemovedArtifacts,
+    turbo_directories: RemovedFiles,
+}
+
+pub async fn run(command: &CacheCommand, base: &CommandBase) -> Result<(), Error> {
+    // The subcommands that predate the global `--json` flag keep their own
+    let json = |flag: &bool| *flag || base.args().json;
+    match command {
+        CacheCommand::Ls {
+            hash: Some(hash),
+            cache_dir,
+            json: json_flag,
+        } => {
+            let info = inspect_local(base, cache_dir.path(), hash)?;
+            print_inspected(base, hash, &info, json(json_flag))?;
+        }
+        CacheCommand::Ls {
+            hash: None,
+            cache_dir,
+            json: json_flag,
+        } => {
+            let cache = FSCache::new(cache_dir.path(), &base.repo_root, None)?;
+            let listings = cache.list()?;
+            if json(json_flag) {
+                println!("{}", serde_json::to_string_pretty(&listings)?);
+            } else {
+                print_listings(base, &listings)?;
+            }
+        }
+        CacheCommand::Inspect {
+            hash,
+            remote,
+            cache_dir,
+            json: json_flag,
+        } => {
+            let info = match remote {
+                true => inspect_remote(base, hash).await?,
+                false => inspect_local(base, cache_dir.path(), hash)?,
+            };
+            print_inspected(base, hash, &info, json(json_flag))?;
+        }
+        CacheCommand::Clean {
+            hash,
+            all: _,
+            cache_dir,
+        } => {
+            let cache = FSCache::new(cache_dir.path(), &base.repo_root, None)?;
+            // clap requires exactly one of `--hash` or `--all`
+            let removed = match hash {
+                Some(hash) => cache.remove(hash)?.ok_or_else(|| Error::NotFound {
+                    hash: hash.to_string(),
+                    location: "local",
+                })?,
+                None => cache.clear()?,
+            };
+            if base.args().json {
+                println!("{}", serde_json::to_string_pretty(&removed)?);
+            } else {
+                print_removed(base, removed);
+            }
+        }
+        CacheCommand::Stats { json: json_flag } => {
+            let stats = CacheStats::new(&cache_stats::read(&base.repo_root)?);
+            if json(json_flag) {
+                println!("{}", serde_json::to_string_pretty(&stats)?);
+            } else {
+                print_stats(base, &stats)?;
+            }
+        }
+        CacheCommand::Gc { max_age, cache_dir } => {
+            let cache = FSCache::new(cache_dir.path(), &base.repo_root, None)?;
+            let removed = cache.gc(*max_age)?;
+
+            let root_package_json =
+                PackageJson::load(&base.repo_root.join_component("package.json"))?;
+            let package_graph = PackageGraph::builder(&base.repo_root, root_package_json)
+                .build()
+                .await?;
+            let package_dirs = package_graph
+                .packages()
+                .map(|(_, info)| info.package_path());
+            let removed_files = cleanup::clean_up(&base.repo_root, package_dirs, *max_age);
+            if base.args().json {
+                let summary = GcSummary {
+                    cache: removed,
+                    turbo_directories: removed_files,
+                };
+                println!("{}", serde_json::to_string_pretty(&summary)?);
+            } else {
+                print_removed(base, removed);
+                print_removed_files(base, removed_files);
+            }
+        }
+        CacheCommand::Export {
+            output,
+            hashes,
+            tasks,
+            all,
+            cache_dir,
+        } => {
+            let cache = FSCache::new(cache_dir.path(), &base.repo_root, None)?;
+            let hashes = select_artifacts(base, &cache, hashes, tasks, *all)?;
+            let result = bundle::export(&cache, &hashes, BufWriter::new(File::create(output)?));
+            // Don't leave a partial bundle behind
+            if result.is_err() {
+                let _ = std::fs::remove_file(output);
+            }
+            let exported = result?;
+            if base.args().json {
+                println!("{}", serde_json::to_string_pretty(&exported)?);
+            } else {
+                print_exported(base, &exported, output);
+            }
+        }
+        CacheCommand::Import { input, cache_dir } => {
+            let cache = FSCache::new(cache_dir.path(), &base.repo_root, None)?;
+            let imported = bundle::import(&cache, BufReader::new(File::open(input)?))?;
+            if base.args().json {
+                println!("{}", serde_json::to_string_pretty(&imported)?);
+            } else {
+                print_imported(base, &imported);
+            }
+        }
+        CacheCommand::Verify {
+            hash,
+            cache_dir,
+            json: json_flag,
+        } => {
+            let cache = FSCache::new(cache_dir.path(), &base.repo_root, None)?;
+            let verified = match hash {
+                Some(hash) => {
+                    let integrity = cache.verify(hash)?.ok_or_else(|| Error::NotFound {
+                        hash: hash.to_string(),
+                        location: "local",
+                    })?;
+                    vec![VerifiedArtifact {
+                        hash: hash.to_string(),
+                        integrity,
+                    }]
+                }
+                None => cache.verify_all()?,
+            };
+            if json(json_flag) {
+                println!("{}", serde_json::to_string_pretty(&verified)?);
+            } else {
+                print_verified(base, &verified);
+            }
+            let corrupt = verified
+                .iter()
+                .filter(|artifact| matches!(artifact.integrity, Integrity::Corrupt { .. }))
+                .count();
+            if corrupt > 0 {
+                return Err(Error::CorruptArtifacts(corrupt));
+            }
+        }
+    }
+
+    Ok(())
+}
+
+fn print_inspected(
+    base: &CommandBase,
+    hash: &str,
+    info: &ArtifactInfo,
+    json: bool,
+) -> Result<(), Error> {
+    if json {
+        let summary = ArtifactSummary {
+            hash,
+            source: source_name(info.source),
+            time_saved: info.time_saved,
+            entries: &info.entries,
+        };
+        println!("{}", serde_json::to_string_pretty(&summary)?);
+        Ok(())
+    } else {
+        print_artifact(base, hash, info)
+    }
+}
+
+fn print_listings(base: &CommandBase, listings: &[ArtifactListing]) -> Result<(), Error> {
+    let now = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map(|duration| duration.as_secs())
+        .unwrap_or_default();
+
+    let mut tab_writer = TabWriter::new(io::stdout()).minwidth(0).padding(2);
+    writeln!(
+        tab_writer,
+        "Hash\tTask\tPackage\tSize\tLast used\tTime saved"
+    )?;
+    for listing in listings {
+        let package = listing
+            .package_dir
+            .as_deref()
+            .map(|package_dir| package_name(base, package_dir));
+        writeln!(
+            tab_writer,
+            "{}\t{}\t{}\t{}\t{} ago\t{}ms",
+            listing.hash,
+            listing.task.as_deref().unwrap_or("-"),
+            package.as_deref().unwrap_or("-"),
+            listing.size,
+            format_age(now.saturating_sub(listing.last_used_at)),
+            listing.time_saved
+        )?;
+    }
+    tab_writer.flush()?;
+
+    cprintln!(
+        base.ui,
+        GREY,
+        "\n{} artifacts ({} bytes) in the local cache",
+        listings.len(),
+        listings.iter().map(|listing| listing.size).sum::<u64>()
+    );
+
+    Ok(())
+}
+
+// Artifacts only record the directory of the package that produced them, so
+// its name is looked up from the package.json that's currently there
+fn package_name(base: &CommandBase, package_dir: &str) -> String {
+    if package_dir == "." {
+        return "//".to_string();
+    }
+    RelativeUnixPath::new(package_dir)
+        .ok()
+        .and_then(|dir| {
+            PackageJson::load(
+                &base
+                    .repo_root
+                    .join_unix_path(dir)
+                    .join_component("package.json"),
+            )
+            .ok()
+        })
+        .and_then(|package_json| package_json.name)
+        .unwrap_or_else(|| package_dir.to_string())
+}
+
+fn format_age(seconds: u64) -> String {
+    const MINUTE: u64 = 60;
+    const HOUR: u64 = 60 * MINUTE;
+    const DAY: u64 = 24 * HOUR;
+    match seconds {
+        seconds if seconds >= DAY => format!("{}d", seconds / DAY),
+        seconds if seconds >= HOUR => format!("{}h", seconds / HOUR),
+        seconds if seconds >= MINUTE => format!("{}m", seconds / MINUTE),
+        seconds => format!("{}s", seconds),
+    }
+}
+
+fn print_stats(base: &CommandBase, stats: &CacheStats) -> Result<(), Error> {
+    if stats.runs == 0 {
+        cprintln!(base.ui, GREY, "No runs have been recorded yet");
+        return Ok(());
+    }
+
+    let total = &stats.total;
+    println!(
+        "{} runs, {} cacheable tasks\nHit rate: {:.1}% ({} local, {} remote, {} misses)\nTime \
+         saved: {}ms\nUploaded: {} bytes\n",
+        stats.runs,
+        total.tasks,
+        total.hit_rate() * 100.0,
+        total.local_hits,
+        total.remote_hits,
+        total.misses,
+        total.time_saved,
+        total.uploaded_bytes
+    );
+
+    let mut tab_writer = TabWriter::new(io::stdout()).minwidth(0).padding(2);
+    writeln!(
+        tab_writer,
+        "Task\tHit rate\tLocal\tRemote\tMisses\tTime saved\tUploaded"
+    )?;
+    for (task, usage) in &stats.by_task {
+        writeln!(
+            tab_writer,
+            "{}\t{:.1}%\t{}\t{}\t{}\t{}ms\t{} bytes",
+            task,
+            usage.hit_rate() * 100.0,
+            usage.local_hits,
+            usage.remote_hits,
+            usage.misses,
+            usage.time_saved,
+            usage.uploaded_bytes
+        )?;
+    }
+    tab_writer.flush()?;
+
+    Ok(())
+}
+
+fn print_removed(base: &CommandBase, removed: RemovedArtifacts) {
+    cprintln!(
+        base.ui,
+        GREY,
+        "Removed {} artifacts ({} bytes) from the local cache",
+        removed.artifacts,
+        removed.bytes
+    );
+}
+
+fn print_removed_files(base: &CommandBase, removed: RemovedFiles) {
+    cprintln!(
+        base.ui,
+        GREY,
+        "Removed {} stale logs and traces ({} bytes) from .turbo directories",
+        removed.files,
+        removed.bytes
+    );
+}
+
+// Artifacts only record the task that produced them in their logs, so the
+// artifacts of tasks that don't cache their logs can only be selected by hash
+fn select_artifacts(
+    base: &CommandBase,
+    cache: &FSCache,
+    hashes: &[String],
+    tasks: &[String],
+    all: bool,
+) -> Result<Vec<String>, Error> {
+    let mut selected = hashes.to_vec();
+    if !all && tasks.is_empty() {
+        return Ok(selected);
+    }
+
+    let selectors = tasks
+        .iter()
+        .map(|task| TaskName::from(task.as_str()))
+        .collect::<Vec<_>>();
+    let mut matched = false;
+    for listing in cache.list()? {
+        let matches = all
+            || listing
+                .task
+                .as_deref()
+                .zip(listing.package_dir.as_deref())
+                .map_or(false, |(task, package_dir)| {
+                    let package = package_name(base, package_dir);
+                    let task_id = TaskId::new(&package, task);
+                    selectors.iter().any(|selector| selector.matches(&task_id))
+                });
+        if matches {
+            matched = true;
+            if !selected.contains(&listing.hash) {
+                selected.push(listing.hash);
+            }
+        }
+    }
+    if !matched && !tasks.is_empty() {
+        return Err(Error::NoMatchingArtifacts);
+    }
+
+    Ok(selected)
+}
+
+fn print_exported(base: &CommandBase, exported: &[BundledArtifact], output: &Utf8Path) {
+    cprintln!(
+        base.ui,
+        GREY,
+        "Exported {} artifacts ({} bytes) to {}",
+        exported.len(),
+        exported.iter().map(|artifact| artifact.size).sum::<u64>(),
+        output
+    );
+}
+
+fn print_imported(base: &CommandBase, imported: &ImportedArtifacts) {
+    cprintln!(
+        base.ui,
+        GREY,
+        "Imported {} artifacts into the local cache, skipped {} that were already in it",
+        imported.imported.len(),
+        imported.skipped.len()
+    );
+}
+
+fn print_verified(base: &CommandBase, verified: &[VerifiedArtifact]) {
+    let mut unverified = 0;
+    for artifact in verified {
+        match &artifact.integrity {
+            Integrity::Valid => (),
+            Integrity::Unverified => unverified += 1,
+            Integrity::Corrupt { files } if files.is_empty() => {
+                println!("{}: can't be read", artifact.hash)
+            }
+            Integrity::Corrupt { files } => {
+                println!("{}: {} don't match", artifact.hash, files.join(", "))
+            }
+        }
+    }
+
+    cprintln!(
+        base.ui,
+        GREY,
+        "Verified {} artifacts in the local cache, {} have no checksums",
+        verified.len(),
+        unverified
+    );
+}
+
+fn inspect_local(
+    base: &CommandBase,
+    cache_dir: Option<&Utf8Path>,
+    hash: &str,
+) -> Result<ArtifactInfo, Error> {
+    let cache = FSCache::new(cache_dir, &base.repo_root, None)?;
+    cache.inspect(hash)?.ok_or_else(|| Error::NotFound {
+        hash: hash.to_string(),
+        location: "local",
+    })
+}
+
+async fn inspect_remote(base: &CommandBase, hash: &str) -> Result<ArtifactInfo, Error> {
+    let config = base.config()?;
+    let api_auth = base.api_auth()?.ok_or(Error::RemoteCacheDisabled)?;
+    let opts = CacheOpts {
+        remote_cache_opts: Some(RemoteCacheOpts::new(
+            config.team_id().map(|team_id| team_id.to_string()),
+            config.signature(),
+            config.remote_cache_provider().cloned(),
+            config.upload_chunk_size(),
+        )),
+        ..Default::default()
+    };
+    let api_client = base.api_client()?;
+    let cache = match config.remote_cache_provider() {
+        Some(provider) if provider.is_bazel() => HTTPCache::new(
+            api_client
+                .bazel_cache_client(provider.clone())
+                .map_err(CacheError::from)?,
+            &opts,
+            base.repo_root.clone(),
+            api_auth,
+            None,
+        ),
+        Some(provider) => HTTPCache::new(
+            api_client.custom_cache_client(provider.clone()),
+            &opts,
+            base.repo_root.clone(),
+            api_auth,
+            None,
+        ),
+        None => HTTPCache::new(api_client, &opts, base.repo_root.clone(), api_auth, None),
+    };
+    cache.inspect(hash).await?.ok_or_else(|| Error::NotFound {
+        hash: hash.to_string(),
+        location: "remote",
+    })
+}
+
+fn source_name(source: CacheSource) -> &'static str {
+    match source {
+        CacheSource::Local => "local",
+        CacheSource::Remote => "remote",
+    }
+}
+
+fn print_artifact(base: &CommandBase, hash: &str, info: &ArtifactInfo) -> Result<(), Error> {
+    cprintln!(
+        base.ui,
+        GREY,
+        "{} ({} cache, saves {}ms)\n",
+        hash,
+        source_name(info.source),
+        info.time_saved
+    );
+
+    let mut tab_writer = TabWriter::new(io::stdout()).minwidth(0).padding(2);
+    writeln!(tab_writer, "Mode\tSize\tPath")?;
+    for entry in &info.entries {
+        let path = match (&entry.kind, &entry.link_target) {
+            (ArtifactEntryKind::Symlink, Some(target)) => format!("{} -> {}", entry.path, target),
+            (ArtifactEntryKind::Directory, _) => format!("{}/", entry.path),
+            _ => entry.path.clone(),
+        };
+        writeln!(tab_writer, "{:o}\t{}\t{}", entry.mode, entry.size, path)?;
+    }
+    tab_writer.flush()?;
+
+    Ok(())
+}
@@ -0,0 +1,355 @@
+//! A self-hosted remote cache. Serves the artifact endpoints of the Remote
+//! Caching API from a local cache directory, so that CI machines can share a
+//! cache without any other infrastructure.
+
+use std::{
+    io,
+    net::{IpAddr, SocketAddr, TcpListener},
+    sync::Arc,
+};
+
+use axum::{
+    body::Bytes,
+    extract::{DefaultBodyLimit, Path, State},
+    http::{header, HeaderMap, HeaderName, HeaderValue, Request, StatusCode},
+    middleware::{self, Next},
+    response::{IntoResponse, Response},
+    routing::{get, post},
+    Json, Router,
+};
+use camino::Utf8Path;
+use subtle::ConstantTimeEq;
+use thiserror::Error;
+use tracing::{debug, warn};
+use turborepo_cache::{
+    fs::{ArchivedArtifact, FSCache},
+    is_valid_hash, CacheError, Provenance,
+};
+use turborepo_ui::{cprintln, GREY};
+use turborepo_vercel_api::{CachingStatus, CachingStatusResponse};
+
+use super::CommandBase;
+
+#[derive(Debug, Error)]
+pub enum Error {
+    #[error("failed to serve the cache on {addr}: {source}")]
+    Serve {
+        addr: SocketAddr,
+        #[source]
+        source: io::Error,
+    },
+    #[error(transparent)]
+    Cache(#[from] CacheError),
+}
+
+pub struct ServerOpts<'a> {
+    pub host: IpAddr,
+    pub port: u16,
+    pub cache_dir: Option<&'a Utf8Path>,
+    // Uploads are read into memory, so their size has to be limited
+    pub max_artifact_size: u64,
+    // Clients must send this as a bearer token when it's set
+    pub token: Option<String>,
+}
+
+#[derive(Clone)]
+struct ServerState {
+    cache: Arc<FSCache>,
+    token: Option<Arc<str>>,
+}
+
+pub async fn run(base: &CommandBase, opts: ServerOpts<'_>) -> Result<(), Error> {
+    let cache = FSCache::new(opts.cache_dir, &base.repo_root, None)?;
+    let addr = SocketAddr::new(opts.host, opts.port);
+    if opts.token.is_none() && !opts.host.is_loopback() {
+        warn!("no token is set, anyone who can reach {addr} can read and write the cache");
+    }
+
+    let app = router(
+        ServerState {
+            cache: Arc::new(cache),
+            token: opts.token.map(Arc::from),
+        },
+        usize::try_from(opts.max_artifact_size).unwrap_or(usize::MAX),
+    );
+    // Binding up front reports the actual address when the port is 0
+    let listener = TcpListener::bind(addr).map_err(|source| Error::Serve { addr, source })?;
+    let addr = listener.local_addr().unwrap_or(addr);
+    cprintln!(
+        base.ui,
+        GREY,
+        "Serving the cache at http://{}. Press Ctrl+C to stop",
+        addr
+    );
+
+    let handle = axum_server::Handle::new();
+    let shutdown_handle = handle.clone();
+    tokio::spawn(async move {
+        if tokio::signal::ctrl_c().await.is_ok() {
+            shutdown_handle.graceful_shutdown(None);
+        }
+    });
+    axum_server::from_tcp(listener)
+        .handle(handle)
+        .serve(app.into_make_service())
+        .await
+        .map_err(|source| Error::Serve { addr, source })?;
+
+    Ok(())
+}
+
+fn router(state: ServerState, max_artifact_size: usize) -> Router {
+    Router::new()
+        .route("/v8/artifacts/status", get(status))
+        // Usage events are accepted but not recorded
+        .route("/v8/artifacts/events", post(|| async { StatusCode::OK }))
+        .route(
+            "/v8/artifacts/:hash",
+            get(get_artifact).head(head_artifact).put(put_artifact),
+        )
+        .route_layer(middleware::from_fn_with_state(state.clone(), authorize))
+        // Artifacts are often larger than the default limit of 2MB
+        .layer(DefaultBodyLimit::max(max_artifact_size))
+        .with_state(state)
+}
+
+async fn authorize<B>(
+    State(state): State<ServerState>,
+    request: Request<B>,
+    next: Next<B>,
+) -> Response {
+    if let Some(token) = &state.token {
+        let authorized = request
+            .headers()
+            .get(header::AUTHORIZATION)
+            .and_then(|value| value.to_str().ok())
+            .and_then(|value| value.strip_prefix("Bearer "))
+            // Compared in constant time so that the token can't be guessed
+            // from how long the comparison takes
+            .map_or(false, |bearer| {
+                bool::from(bearer.as_bytes().ct_eq(token.as_bytes()))
+            });
+        if !authorized {
+            return StatusCode::UNAUTHORIZED.into_response();
+        }
+    }
+
+    next.run(request).await
+}
+
+async fn status() -> Json<CachingStatusResponse> {
+    Json(CachingStatusResponse {
+        status: CachingStatus::Enabled,
+    })
+}
+
+async fn read_artifact(
+    state: &ServerState,
+    hash: String,
+) -> Result<Option<ArchivedArtifact>, StatusCode> {
+    if !is_valid_hash(&hash) {
+        return Err(StatusCode::BAD_REQUEST);
+    }
+    let cache = state.cache.clone();
+    tokio::task::spawn_blocking(move || cache.read_archive(&hash))
+        .await
+        .map_err(|_| StatusCode::INTERNAL_SERVER_ERROR)?
+        .map_err(|err| {
+            debug!("failed to read artifact: {err}");
+            StatusCode::INTERNAL_SERVER_ERROR
+        })
+}
+
+fn artifact_headers(artifact: &ArchivedArtifact) -> HeaderMap {
+    let mut headers = HeaderMap::new();
+    headers.insert("x-artifact-duration", HeaderValue::from(artifact.duration));
+    if let Some(tag) = artifact
+        .tag
+        .as_deref()
+        .and_then(|tag| HeaderValue::from_str(tag).ok())
+    {
+        headers.insert("x-artifact-tag", tag);
+    }
+    for (name, value) in artifact.provenance.iter().flat_map(Provenance::headers) {
+        if let (Ok(name), Ok(value)) = (HeaderName::try_from(name), HeaderValue::try_from(value)) {
+            headers.insert(name, value);
+        }
+    }
+    headers
+}
+
+async fn get_artifact(
+    State(state): State<ServerState>,
+    Path(hash): Path<String>,
+) -> Result<Response, StatusCode> {
+    let artifact = read_artifact(&state, hash)
+        .await?
+        .ok_or(StatusCode::NOT_FOUND)?;
+    let mut headers = artifact_headers(&artifact);
+    headers.insert(
+        header::CONTENT_TYPE,
+        HeaderValue::from_static("application/octet-stream"),
+    );
+
+    Ok((headers, artifact.body).into_response())
+}
+
+async fn head_artifact(
+    State(state): State<ServerState>,
+    Path(hash): Path<String>,
+) -> Result<HeaderMap, StatusCode> {
+    let artifact = read_artifact(&state, hash)
+        .await?
+        .ok_or(StatusCode::NOT_FOUND)?;
+
+    Ok(artifact_headers(&artifact))
+}
+
+async fn put_artifact(
+    State(state): State<ServerState>,
+    Path(hash): Path<String>,
+    headers: HeaderMap,
+    body: Bytes,
+) -> StatusCode {
+    if !is_valid_hash(&hash) {
+        return StatusCode::BAD_REQUEST;
+    }
+    let header_value = |name: &str| {
+        headers
+            .get(name)
+            .and_then(|value| value.to_str().ok())
+            .map(|value| value.to_string())
+    };
+    let Some(duration) = header_value("x-artifact-duration")
+        .map(|duration| duration.parse::<u64>())
+        .transpose()
+        .ok()
+        .map(Option::unwrap_or_default)
+    else {
+        return StatusCode::BAD_REQUEST;
+    };
+    let archive = ArchivedArtifact {
+        body: body.to_vec(),
+        duration,
+        tag: header_value("x-artifact-tag"),
+        provenance: Provenance::from_headers(&headers),
+    };
+
+    let cache = state.cache.clone();
+    let result = tokio::task::spawn_blocking(move || cache.put_archive(&hash, &archive)).await;
+    match result {
+        Ok(Ok(())) => StatusCode::ACCEPTED,
+        Ok(Err(err)) => {
+            debug!("failed to write artifact: {err}");
+            StatusCode::INTERNAL_SERVER_ERROR
+        }
+        Err(_) => StatusCode::INTERNAL_SERVER_ERROR,
+    }
+}
+
+#[cfg(test)]
+mod test {
+    use anyhow::Result;
+    use reqwest::Method;
+    use tempfile::tempdir;
+    use test_case::test_case;
+    use turbopath::AbsoluteSystemPath;
+    use turborepo_api_client::{APIClient, CacheClient};
+
+    use super::*;
+
+    #[test_case("abc123", true ; "hash")]
+    #[test_case("main-abc123", true ; "namespaced")]
+    #[test_case("", false ; "empty")]
+    #[test_case("..", false ; "parent")]
+    #[test_case("../abc123", false ; "traversal")]
+    #[test_case(".abc123", false ; "hidden")]
+    fn test_is_valid_hash(hash: &str, expected: bool) {
+        assert_eq!(is_valid_hash(hash), expected);
+    }
+
+    async fn serve(token: Option<&str>) -> Result<(tempfile::TempDir, String)> {
+        let dir = tempdir()?;
+        let repo_root = AbsoluteSystemPath::from_std_path(dir.path())?;
+        let cache = FSCache::new(None, repo_root, None)?;
+        let app = router(
+            ServerState {
+                cache: Arc::new(cache),
+                token: token.map(Arc::from),
+            },
+            16,
+        );
+        let listener = TcpListener::bind("127.0.0.1:0")?;
+        let url = format!("http://{}", listener.local_addr()?);
+        tokio::spawn(axum_server::from_tcp(listener).serve(app.into_make_service()));
+        Ok((dir, url))
+    }
+
+    #[tokio::test]
+    async fn test_round_trip() -> Result<()> {
+        let (_dir, url) = serve(Some("secret")).await?;
+        let provenance = Provenance {
+            git_sha: Some("abc123".to_string()),
+            hostname: Some("ci-runner".to_string()),
+            ..Provenance::default()
+        };
+        let client =
+            APIClient::new(url, 10, "2.0.0", false)?.with_artifact_headers(provenance.headers());
+
+        assert!(client
+            .get_artifact("abc123", "secret", None, None, Method::GET)
+            .await?
+            .is_none());
+        client
+            .put_artifact("abc123", b"archive", 42, Some("tag"), "secret", None, None)
+            .await?;
+
+        let response = client
+            .artifact_exists("abc123", "secret", None, None)
+            .await?
+            .expect("artifact should exist");
+        assert_eq!(response.headers()["x-artifact-duration"], "42");
+        let response = client
+            .fetch_artifact("abc123", "secret", None, None)
+            .await?
+            .expect("artifact should be fetched");
+        assert_eq!(response.headers()["x-artifact-tag"], "tag");
+        assert_eq!(
+            Provenance::from_headers(response.headers()),
+            Some(provenance)
+        );
+        assert_eq!(response.bytes().await?.as_ref(), b"archive");
+
+        Ok(())
+    }
+
+    #[tokio::test]
+    async fn test_rejects_bad_token() -> Result<()> {
+        let (_dir, url) = serve(Some("secret")).await?;
+        let client = APIClient::new(url, 10, "2.0.0", false)?;
+
+        let result = client
+            .put_artifact("abc123", b"archive", 42, None, "wrong", None, None)
+            .await;
+
+        assert!(result.is_err());
+        Ok(())
+    }
+
+    #[tokio::test]
+    async fn test_rejects_large_artifact() -> Result<()> {
+        let (_dir, url) = serve(None).await?;
+        let client = APIClient::new(url, 10, "2.0.0", false)?;
+
+        let result = client
+            .put_artifact("abc123", &[0u8; 17], 42, None, "", None, None)
+            .await;
+
+        assert!(result.is_err());
+        assert!(client
+            .get_artifact("abc123", "", None, None, Method::GET)
+            .await?
+            .is_none());
+        Ok(())
+    }
+}
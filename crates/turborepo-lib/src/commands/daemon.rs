@@ -1,6 +1,7 @@
 use std::time::Duration;
 
 use camino::Utf8PathBuf;
+use clap::ValueEnum;
 use futures::FutureExt;
 use pidlock::PidlockError::AlreadyOwned;
 use serde_json::json;
@@ -13,7 +14,7 @@ use which::which;
 
 use super::CommandBase;
 use crate::{
-    cli::DaemonCommand,
+    cli::{DaemonCommand, DaemonRpc},
     daemon::{
         endpoint::SocketOpenError, CloseReason, DaemonConnector, DaemonConnectorError, DaemonError,
         Paths,
@@ -27,7 +28,9 @@ const DAEMON_NOT_RUNNING_MESSAGE: &str =
 /// Runs the daemon command.
 pub async fn daemon_client(command: &DaemonCommand, base: &CommandBase) -> Result<(), DaemonError> {
     let (can_start_server, can_kill_server) = match command {
-        DaemonCommand::Status { .. } | DaemonCommand::Logs => (false, false),
+        DaemonCommand::Status { .. } | DaemonCommand::Logs | DaemonCommand::Client { .. } => {
+            (false, false)
+        }
         DaemonCommand::Stop => (false, true),
         DaemonCommand::Restart | DaemonCommand::Start => (true, true),
         DaemonCommand::Clean { .. } => (false, true),
@@ -162,6 +165,55 @@ pub async fn daemon_client(command: &DaemonCommand, base: &CommandBase) -> Resul
             }
             println!("Done");
         }
+        DaemonCommand::Client { rpc: None } => {
+            println!("available RPCs:");
+            for rpc in DaemonRpc::value_variants() {
+                let name = rpc.to_possible_value().expect("no daemon rpcs are skipped");
+                println!(
+                    "  {:<28}{}",
+                    name.get_name(),
+                    color!(base.ui, GREY, "{}", name.get_help().unwrap_or_default())
+                );
+            }
+        }
+        DaemonCommand::Client { rpc: Some(rpc) } => {
+            let mut client = match connector.connect().await {
+                Ok(client) => client,
+                Err(DaemonConnectorError::NotRunning) => {
+                    println!(
+                        "{} {}",
+                        color!(base.ui, BOLD_RED, "x"),
+                        DAEMON_NOT_RUNNING_MESSAGE
+                    );
+                    return Ok(());
+                }
+                Err(e) => {
+                    return Err(e.into());
+                }
+            };
+            match rpc {
+                DaemonRpc::Status => println!("{:#?}", client.status().await?),
+                DaemonRpc::DiscoverPackages => {
+                    println!("{:#?}", client.discover_packages().await?)
+                }
+                DaemonRpc::DiscoverPackagesBlocking => {
+                    println!("{:#?}", client.discover_packages_blocking().await?)
+                }
+                DaemonRpc::SubscribeTaskStatus => {
+                    let mut updates = client.subscribe_task_status().await?;
+                    loop {
+                        let update = tokio::select! {
+                            update = updates.message() => update?,
+                            _ = ctrl_c() => None,
+                        };
+                        match update {
+                            Some(update) => println!("{:#?}", update),
+                            None => break,
+                        }
+                    }
+                }
+            }
+        }
     };
 
     Ok(())
@@ -0,0 +1,273 @@
+use std::{
+    collections::BTreeMap,
+    env, io,
+    process::{Command, Output},
+};
+
+use serde::Deserialize;
+use tempfile::TempDir;
+use thiserror::Error;
+use tracing::debug;
+use turbopath::{AbsoluteSystemPath, AbsoluteSystemPathBuf};
+use turborepo_ui::{cprintln, BOLD_GREEN, BOLD_RED, GREY, UI, YELLOW};
+
+use super::CommandBase;
+
+// Only show the start of the hashes so changed tasks stay on one line
+const HASH_LENGTH: usize = 8;
+// Without a ref, the uncommitted changes are compared
+const DEFAULT_REF: &str = "HEAD";
+
+#[derive(Debug, Error)]
+pub enum Error {
+    #[error("failed to check out {git_ref}: {stderr}")]
+    Checkout { git_ref: String, stderr: String },
+    #[error("failed to calculate task hashes for {location}: {stderr}")]
+    Hashes { location: String, stderr: String },
+    #[error(transparent)]
+    Io(#[from] io::Error),
+    #[error(transparent)]
+    Path(#[from] turbopath::PathError),
+    #[error(transparent)]
+    SerdeJson(#[from] serde_json::Error),
+}
+
+/// The output of `turbo run --hash-only`
+#[derive(Debug, Deserialize)]
+#[serde(rename_all = "camelCase")]
+struct TaskHashes {
+    global_hash: String,
+    tasks: BTreeMap<String, String>,
+}
+
+#[derive(Debug, Default, PartialEq)]
+struct GraphDiff<'a> {
+    added: Vec<&'a str>,
+    removed: Vec<&'a str>,
+    // Task id along with its hash at the ref and in the working tree
+    changed: Vec<(&'a str, &'a str, &'a str)>,
+}
+
+impl<'a> GraphDiff<'a> {
+    fn new(before: &'a TaskHashes, after: &'a TaskHashes) -> Self {
+        let mut diff = Self::default();
+        for (task_id, hash) in &after.tasks {
+            match before.tasks.get(task_id) {
+                None => diff.added.push(task_id.as_str()),
+                Some(before_hash) if before_hash != hash => {
+                    diff.changed
+                        .push((task_id.as_str(), before_hash.as_str(), hash.as_str()))
+                }
+                Some(_) => (),
+            }
+        }
+        diff.removed = before
+            .tasks
+            .keys()
+            .filter(|task_id| !after.tasks.contains_key(*task_id))
+            .map(|task_id| task_id.as_str())
+            .collect();
+
+        diff
+    }
+
+    fn is_empty(&self) -> bool {
+        self.added.is_empty() && self.removed.is_empty() && self.changed.is_empty()
+    }
+}
+
+// A detached checkout of a git ref that is removed when dropped
+struct Worktree {
+    repo_root: AbsoluteSystemPathBuf,
+    path: AbsoluteSystemPathBuf,
+    // Held so the parent directory lives as long as the worktree
+    _dir: TempDir,
+}
+
+impl Worktree {
+    fn add(repo_root: &AbsoluteSystemPath, git_ref: &str) -> Result<Self, Error> {
+        let dir = tempfile::tempdir()?;
+        let path = AbsoluteSystemPathBuf::try_from(dir.path())?.join_component("worktree");
+        // The ref comes from the user, so it mustn't be read as an option
+        let output = git(
+            repo_root,
+            &["worktree", "add", "--detach", "--", path.as_str(), git_ref],
+        )?;
+        if !output.status.success() {
+            return Err(Error::Checkout {
+                git_ref: git_ref.to_string(),
+                stderr: String::from_utf8_lossy(&output.stderr).trim().to_string(),
+            });
+        }
+
+        Ok(Self {
+            repo_root: repo_root.to_owned(),
+            path,
+            _dir: dir,
+        })
+    }
+}
+
+impl Drop for Worktree {
+    fn drop(&mut self) {
+        match git(
+            &self.repo_root,
+            &["worktree", "remove", "--force", self.path.as_str()],
+        ) {
+            Ok(output) if output.status.success() => (),
+            Ok(output) => debug!(
+                "failed to remove worktree {}: {}",
+                self.path,
+                String::from_utf8_lossy(&output.stderr)
+            ),
+            Err(e) => debug!("failed to remove worktree {}: {}", self.path, e),
+        }
+    }
+}
+
+fn git(cwd: &AbsoluteSystemPath, args: &[&str]) -> Result<Output, Error> {
+    Ok(Command::new("git")
+        .args(args)
+        .current_dir(cwd.as_std_path())
+        .output()?)
+}
+
+// Runs this binary with `--hash-only` so both sides of the comparison are
+// hashed by the same version of turbo. The worktree of the ref doesn't have
+// dependencies installed, which hashing doesn't need since it only reads the
+// lockfile and the files that git doesn't ignore. The daemon is skipped so one
+// isn't started for a worktree that is about to be removed.
+fn task_hashes(
+    cwd: &AbsoluteSystemPath,
+    location: &str,
+    tasks: &[String],
+    filter: &[String],
+) -> Result<TaskHashes, Error> {
+    let mut command = Command::new(env::current_exe()?);
+    command
+        .args([
+            "--skip-infer",
+            "--cwd",
+            cwd.as_str(),
+            "run",
+            "--hash-only",
+            "--no-daemon",
+        ])
+        .args(filter.iter().map(|filter| format!("--filter={filter}")))
+        .args(tasks);
+    let output = command.output()?;
+    if !output.status.success() {
+        return Err(Error::Hashes {
+            location: location.to_string(),
+            stderr: String::from_utf8_lossy(&output.stderr).trim().to_string(),
+        });
+    }
+
+    Ok(serde_json::from_slice(&output.stdout)?)
+}
+
+pub fn run(
+    base: &CommandBase,
+    git_ref: Option<&str>,
+    tasks: &[String],
+    filter: &[String],
+) -> Result<(), Error> {
+    let git_ref = git_ref.unwrap_or(DEFAULT_REF);
+    let after = task_hashes(&base.repo_root, "the working tree", tasks, filter)?;
+    let before = {
+        let worktree = Worktree::add(&base.repo_root, git_ref)?;
+        task_hashes(&worktree.path, git_ref, tasks, filter)?
+    };
+
+    print_diff(base.ui, git_ref, &before, &after);
+
+    Ok(())
+}
+
+fn print_diff(ui: UI, git_ref: &str, before: &TaskHashes, after: &TaskHashes) {
+    cprintln!(ui, GREY, "• Comparing task graph against {}", git_ref);
+    if before.global_hash != after.global_hash {
+        cprintln!(
+            ui,
+            YELLOW,
+            "• Global hash changed: {} -> {}",
+            short_hash(&before.global_hash),
+            short_hash(&after.global_hash)
+        );
+    }
+
+    let diff = GraphDiff::new(before, after);
+    if diff.is_empty() {
+        cprintln!(ui, GREY, "• No tasks changed");
+        return;
+    }
+
+    println!();
+    for task_id in &diff.added {
+        cprintln!(ui, BOLD_GREEN, "+ {}", task_id);
+    }
+    for task_id in &diff.removed {
+        cprintln!(ui, BOLD_RED, "- {}", task_id);
+    }
+    for (task_id, before_hash, after_hash) in &diff.changed {
+        cprintln!(
+            ui,
+            YELLOW,
+            "~ {} ({} -> {})",
+            task_id,
+            short_hash(before_hash),
+            short_hash(after_hash)
+        );
+    }
+    println!();
+    cprintln!(
+        ui,
+        GREY,
+        "{} added, {} removed, {} changed",
+        diff.added.len(),
+        diff.removed.len(),
+        diff.changed.len()
+    );
+}
+
+fn short_hash(hash: &str) -> &str {
+    hash.get(..HASH_LENGTH).unwrap_or(hash)
+}
+
+#[cfg(test)]
+mod test {
+    use super::*;
+
+    fn hashes(tasks: &[(&str, &str)]) -> TaskHashes {
+        TaskHashes {
+            global_hash: "global".to_string(),
+            tasks: tasks
+                .iter()
+                .map(|(task_id, hash)| (task_id.to_string(), hash.to_string()))
+                .collect(),
+        }
+    }
+
+    #[test]
+    fn test_graph_diff() {
+        let before = hashes(&[("docs#build", "a"), ("web#build", "b"), ("web#test", "c")]);
+        let after = hashes(&[("web#build", "d"), ("web#lint", "e"), ("web#test", "c")]);
+
+        assert_eq!(
+            GraphDiff::new(&before, &after),
+            GraphDiff {
+                added: vec!["web#lint"],
+                removed: vec!["docs#build"],
+                changed: vec![("web#build", "b", "d")],
+            }
+        );
+    }
+
+    #[test]
+    fn test_graph_diff_unchanged() {
+        let before = hashes(&[("web#build", "a")]);
+        let after = hashes(&[("web#build", "a")]);
+
+        assert!(GraphDiff::new(&before, &after).is_empty());
+    }
+}
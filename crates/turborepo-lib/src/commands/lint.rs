@@ -0,0 +1,571 @@
+//! Checks a monorepo for common mistakes in how its workspaces are set up:
+//! workspaces that import each other without depending on each other, external
+//! dependencies that workspaces disagree on the version of, and pipeline tasks
+//! that no workspace has a script for.
+
+use std::{
+    collections::{BTreeMap, BTreeSet},
+    fmt,
+    sync::OnceLock,
+};
+
+use regex::Regex;
+use serde::Serialize;
+use thiserror::Error;
+use turbopath::AnchoredSystemPath;
+use turborepo_repository::{
+    package_graph::{self, PackageGraph, PackageName, ROOT_PKG_NAME},
+    package_json::{self, PackageJson},
+    package_manager::PackageManager,
+};
+use turborepo_scm::SCM;
+use turborepo_ui::{cprintln, GREY, UI, YELLOW};
+
+use super::CommandBase;
+use crate::{config, run::task_id::TaskName, turbo_json::TurboJson};
+
+// Only files with these extensions are searched for imports
+const SOURCE_EXTENSIONS: &[&str] = &["js", "jsx", "ts", "tsx", "mjs", "cjs", "mts", "cts"];
+
+#[derive(Debug, Error)]
+pub enum Error {
+    #[error("found {0} problems in the workspaces")]
+    Problems(usize),
+    #[error(transparent)]
+    Config(#[from] config::Error),
+    #[error(transparent)]
+    PackageJson(#[from] package_json::Error),
+    #[error(transparent)]
+    PackageGraph(#[from] package_graph::builder::Error),
+    #[error(transparent)]
+    Scm(#[from] turborepo_scm::Error),
+    #[error(transparent)]
+    SerdeJson(#[from] serde_json::Error),
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Serialize)]
+#[serde(rename_all = "kebab-case")]
+enum Check {
+    UndeclaredDependency,
+    VersionMismatch,
+    MissingScript,
+}
+
+impl fmt::Display for Check {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(match self {
+            Check::UndeclaredDependency => "undeclared-dependency",
+            Check::VersionMismatch => "version-mismatch",
+            Check::MissingScript => "missing-script",
+        })
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, PartialOrd, Ord, Serialize)]
+#[serde(rename_all = "camelCase")]
+struct Problem {
+    check: Check,
+    package: String,
+    message: String,
+    // How to fix the problem by hand. Nothing is changed automatically.
+    fix: String,
+}
+
+// The parts of a workspace that the checks look at
+struct Workspace<'a> {
+    name: &'a str,
+    // Relative to the repository root
+    package_json_path: String,
+    package_json: &'a PackageJson,
+}
+
+impl Workspace<'_> {
+    fn declares(&self, dependency: &str) -> bool {
+        let package_json = self.package_json;
+        [
+            &package_json.dependencies,
+            &package_json.dev_dependencies,
+            &package_json.peer_dependencies,
+            &package_json.optional_dependencies,
+        ]
+        .into_iter()
+        .flatten()
+        .any(|dependencies| dependencies.contains_key(dependency))
+    }
+}
+
+pub async fn run(base: &CommandBase) -> Result<(), Error> {
+    let root_package_json = PackageJson::load(&base.repo_root.join_component("package.json"))?;
+    let package_graph = PackageGraph::builder(&base.repo_root, root_package_json.clone())
+        .build()
+        .await?;
+
+    let mut workspaces = package_graph
+        .packages()
+        .map(|(name, info)| Workspace {
+            name: match name {
+                PackageName::Root => ROOT_PKG_NAME,
+                PackageName::Other(name) => name,
+            },
+            package_json_path: info.package_json_path().to_unix().to_string(),
+            package_json: &info.package_json,
+        })
+        .collect::<Vec<_>>();
+    workspaces.sort_by_key(|workspace| workspace.name);
+
+    let imports = find_imports(base, &package_graph)?;
+    let mut problems =
+        undeclared_dependencies(&workspaces, &imports, package_graph.package_manager());
+    problems.extend(version_mismatches(&workspaces));
+    // A repository without a turbo.json has no pipeline to check
+    match TurboJson::load(
+        &base.repo_root,
+        AnchoredSystemPath::empty(),
+        &root_package_json,
+        false,
+    ) {
+        Ok(turbo_json) => {
+            let tasks = turbo_json.pipeline.into_iter().map(|(task, _)| task);
+            problems.extend(missing_scripts(&workspaces, tasks));
+        }
+        Err(config::Error::NoTurboJSON) => (),
+        Err(e) => return Err(e.into()),
+    }
+    problems.sort();
+
+    if base.args().json {
+        println!("{}", serde_json::to_string_pretty(&problems)?);
+    } else {
+        print_problems(base.ui, &problems);
+    }
+
+    match problems.len() {
+        0 => Ok(()),
+        count => Err(Error::Problems(count)),
+    }
+}
+
+fn print_problems(ui: UI, problems: &[Problem]) {
+    for problem in problems {
+        cprintln!(
+            ui,
+            YELLOW,
+            "{} {}: {}",
+            problem.check,
+            problem.package,
+            problem.message
+        );
+        cprintln!(ui, GREY, "  fix: {}", problem.fix);
+    }
+    if problems.is_empty() {
+        cprintln!(ui, GREY, "No problems found");
+    }
+}
+
+// The packages imported by the source files of each workspace, keyed by
+// workspace name. Files are listed through the SCM so that ignored files, e.g.
+// build outputs and node_modules, aren't searched. The root workspace contains
+// every other workspace, so it isn't searched at all.
+fn find_imports(
+    base: &CommandBase,
+    package_graph: &PackageGraph,
+) -> Result<BTreeMap<String, BTreeSet<String>>, Error> {
+    let scm = SCM::new(&base.repo_root);
+    let package_dirs = package_graph
+        .packages()
+        .filter(|(name, _)| !matches!(name, PackageName::Root))
+        .map(|(_, info)| info.package_path().to_unix())
+        .collect::<Vec<_>>();
+
+    let mut imports = BTreeMap::new();
+    for (name, info) in package_graph.packages() {
+        let PackageName::Other(name) = name else {
+            continue;
+        };
+        let package_path = info.package_path();
+        let package_dir = package_path.to_unix();
+        // Workspaces nested inside of this one have their own imports
+        let nested_dirs = package_dirs
+            .iter()
+            .filter_map(|dir| dir.strip_prefix(&package_dir).ok())
+            .filter(|dir| !dir.as_str().is_empty())
+            .collect::<Vec<_>>();
+
+        let files =
+            scm.get_package_file_hashes::<&str>(&base.repo_root, package_path, &[], None)?;
+        let mut imported = BTreeSet::new();
+        for file in files.keys() {
+            let is_source = file
+                .as_str()
+                .rsplit_once('.')
+                .map_or(false, |(_, extension)| {
+                    SOURCE_EXTENSIONS.contains(&extension)
+                });
+            if !is_source || nested_dirs.iter().any(|dir| file.strip_prefix(dir).is_ok()) {
+                continue;
+            }
+            let path = base.repo_root.resolve(package_path).join_unix_path(file);
+            // Files that can't be read, e.g. because they were deleted since
+            // they were listed, don't have any imports
+            let Ok(source) = path.read_to_string() else {
+                continue;
+            };
+            imported.extend(imported_packages(&source).map(|package| package.to_string()));
+        }
+        imports.insert(name.clone(), imported);
+    }
+
+    Ok(imports)
+}
+
+fn import_regex() -> &'static Regex {
+    static RE: OnceLock<Regex> = OnceLock::new();
+    RE.get_or_init(|| {
+        Regex::new(
+            r#"(?m)(?:\bfrom\s*|\bimport\s*\(\s*|\brequire\s*\(\s*|^\s*import\s+)["']([^"'\s]+)["']"#,
+        )
+        .unwrap()
+    })
+}
+
+// The names of the packages imported by a source file, found by matching
+// `import` and `require` statements rather than parsing the file
+fn imported_packages(source: &str) -> impl Iterator<Item = &str> {
+    import_regex()
+        .captures_iter(source)
+        .filter_map(|captures| package_name(captures.get(1)?.as_str()))
+}
+
+// The package that an import specifier refers to, or `None` for relative
+// imports, absolute paths and Node builtins
+fn package_name(specifier: &str) -> Option<&str> {
+    if specifier.starts_with(['.', '/']) || specifier.starts_with("node:") {
+        return None;
+    }
+    let end = match specifier.strip_prefix('@') {
+        Some(scoped) => {
+            let name_start = scoped.find('/')? + 1;
+            scoped[name_start..]
+                .find('/')
+                .map_or(specifier.len(), |name_len| 1 + name_start + name_len)
+        }
+        None => specifier.find('/').unwrap_or(specifier.len()),
+    };
+    Some(&specifier[..end])
+}
+
+fn undeclared_dependencies(
+    workspaces: &[Workspace],
+    imports: &BTreeMap<String, BTreeSet<String>>,
+    package_manager: &PackageManager,
+) -> Vec<Problem> {
+    // Package managers that support the workspace protocol can link workspaces
+    // regardless of their version
+    let version = match package_manager {
+        PackageManager::Npm | PackageManager::Yarn => "*",
+        PackageManager::Berry
+        | PackageManager::Pnpm
+        | PackageManager::Pnpm6
+        | PackageManager::Bun => "workspace:*",
+    };
+
+    let internal = workspaces
+        .iter()
+        .map(|workspace| workspace.name)
+        .collect::<BTreeSet<_>>();
+    let mut problems = Vec::new();
+    for workspace in workspaces {
+        let Some(imported) = imports.get(workspace.name) else {
+            continue;
+        };
+        for package in imported {
+            if package == workspace.name
+                || !internal.contains(package.as_str())
+                || workspace.declares(package)
+            {
+                continue;
+            }
+            problems.push(Problem {
+                check: Check::UndeclaredDependency,
+                package: workspace.name.to_string(),
+                message: format!("imports {package} without depending on it"),
+                fix: format!(
+                    "add \"{package}\": \"{version}\" to the dependencies in {}",
+                    workspace.package_json_path
+                ),
+            });
+        }
+    }
+
+    problems
+}
+
+// Peer dependencies are left out since they're expected to be ranges that
+// differ from the versions that are installed. Dependencies on other
+// workspaces and local paths are left out too.
+fn version_mismatches(workspaces: &[Workspace]) -> Vec<Problem> {
+    let internal = workspaces
+        .iter()
+        .map(|workspace| workspace.name)
+        .collect::<BTreeSet<_>>();
+
+    // Dependency name -> version -> workspaces that use it
+    let mut versions: BTreeMap<&str, BTreeMap<&str, Vec<&Workspace>>> = BTreeMap::new();
+    for workspace in workspaces {
+        let package_json = workspace.package_json;
+        let dependencies = [
+            &package_json.dependencies,
+            &package_json.dev_dependencies,
+            &package_json.optional_dependencies,
+        ]
+        .into_iter()
+        .flatten()
+        .flatten();
+        for (name, version) in dependencies {
+            if internal.contains(name.as_str())
+                || ["workspace:", "file:", "link:"]
+                    .iter()
+                    .any(|protocol| version.starts_with(protocol))
+            {
+                continue;
+            }
+            versions
+                .entry(name.as_str())
+                .or_default()
+                .entry(version.as_str())
+                .or_default()
+                .push(workspace);
+        }
+    }
+
+    let mut problems = Vec::new();
+    for (name, versions) in versions {
+        if versions.len() < 2 {
+            continue;
+        }
+        // Suggest the version used by the most workspaces. Ties go to the
+        // version that sorts last, which is usually the newest.
+        let Some((expected, users)) = versions
+            .iter()
+            .max_by(|(a, a_users), (b, b_users)| a_users.len().cmp(&b_users.len()).then(a.cmp(b)))
+        else {
+            continue;
+        };
+        for (version, mismatched) in &versions {
+            if version == expected {
+                continue;
+            }
+            for workspace in mismatched {
+                problems.push(Problem {
+                    check: Check::VersionMismatch,
+                    package: workspace.name.to_string(),
+                    message: format!(
+                        "depends on {name}@{version}, but {} other workspaces use {expected}",
+                        users.len()
+                    ),
+                    fix: format!(
+                        "change {name} to \"{expected}\" in {}",
+                        workspace.package_json_path
+                    ),
+                });
+            }
+        }
+    }
+
+    problems
+}
+
+fn missing_scripts<'a>(
+    workspaces: &[Workspace],
+    tasks: impl Iterator<Item = TaskName<'a>>,
+) -> Vec<Problem> {
+    let mut problems = Vec::new();
+    for task in tasks {
+        match task.package() {
+            Some(package) => {
+                let Some(workspace) = workspaces
+                    .iter()
+                    .find(|workspace| workspace.name == package)
+                else {
+                    problems.push(Problem {
+                        check: Check::MissingScript,
+                        package: package.to_string(),
+                        message: format!(
+                            "{task} is in the pipeline, but there's no {package} workspace"
+                        ),
+                        fix: format!("remove \"{task}\" from turbo.json"),
+                    });
+                    continue;
+                };
+                if !workspace.package_json.scripts.contains_key(task.task()) {
+                    problems.push(Problem {
+                        check: Check::MissingScript,
+                        package: package.to_string(),
+                        message: format!(
+                            "{task} is in the pipeline, but there's no {} script",
+                            task.task()
+                        ),
+                        fix: format!(
+                            "add a \"{}\" script to {} or remove \"{task}\" from turbo.json",
+                            task.task(),
+                            workspace.package_json_path
+                        ),
+                    });
+                }
+            }
+            None => {
+                if !workspaces
+                    .iter()
+                    .any(|workspace| workspace.package_json.scripts.contains_key(task.task()))
+                {
+                    problems.push(Problem {
+                        check: Check::MissingScript,
+                        package: ROOT_PKG_NAME.to_string(),
+                        message: format!(
+                            "{task} is in the pipeline, but no workspace has a {task} script"
+                        ),
+                        fix: format!(
+                            "add a \"{task}\" script to a workspace or remove \"{task}\" from \
+                             turbo.json"
+                        ),
+                    });
+                }
+            }
+        }
+    }
+
+    problems
+}
+
+#[cfg(test)]
+mod test {
+    use test_case::test_case;
+
+    use super::*;
+
+    fn package_json(value: serde_json::Value) -> PackageJson {
+        serde_json::from_value(value).unwrap()
+    }
+
+    fn workspace<'a>(name: &'a str, package_json: &'a PackageJson) -> Workspace<'a> {
+        Workspace {
+            name,
+            package_json_path: format!("packages/{name}/package.json"),
+            package_json,
+        }
+    }
+
+    #[test_case("import { Button } from \"ui\";", &["ui"] ; "named import")]
+    #[test_case("import \"ui/styles.css\";", &["ui"] ; "side effect import")]
+    #[test_case("const { a } = require('@repo/utils/strings');", &["@repo/utils"] ; "scoped require")]
+    #[test_case("const ui = await import(\"@repo/ui\");", &["@repo/ui"] ; "dynamic import")]
+    #[test_case("export * from \"config\";", &["config"] ; "re-export")]
+    #[test_case("import a from \"./a\";\nimport fs from \"node:fs\";", &[] ; "relative and builtin")]
+    fn test_imported_packages(source: &str, expected: &[&str]) {
+        assert_eq!(imported_packages(source).collect::<Vec<_>>(), expected);
+    }
+
+    #[test_case(PackageManager::Pnpm, "workspace:*" ; "pnpm")]
+    #[test_case(PackageManager::Npm, "*" ; "npm")]
+    fn test_undeclared_dependencies(package_manager: PackageManager, version: &str) {
+        let web = package_json(serde_json::json!({ "devDependencies": { "config": "*" } }));
+        let ui = package_json(serde_json::json!({}));
+        let config = package_json(serde_json::json!({}));
+        let workspaces = [
+            workspace("config", &config),
+            workspace("ui", &ui),
+            workspace("web", &web),
+        ];
+        let imports = [(
+            "web".to_string(),
+            ["config", "react", "ui", "web"]
+                .into_iter()
+                .map(String::from)
+                .collect(),
+        )]
+        .into_iter()
+        .collect();
+
+        assert_eq!(
+            undeclared_dependencies(&workspaces, &imports, &package_manager),
+            vec![Problem {
+                check: Check::UndeclaredDependency,
+                package: "web".to_string(),
+                message: "imports ui without depending on it".to_string(),
+                fix: format!(
+                    "add \"ui\": \"{version}\" to the dependencies in packages/web/package.json"
+                ),
+            }]
+        );
+    }
+
+    #[test]
+    fn test_version_mismatches() {
+        let web = package_json(serde_json::json!({
+            "dependencies": { "react": "^18.2.0", "ui": "workspace:*" },
+            "peerDependencies": { "typescript": "^4.0.0" }
+        }));
+        let docs = package_json(serde_json::json!({
+            "dependencies": { "react": "^18.2.0" },
+            "devDependencies": { "typescript": "^5.3.0" }
+        }));
+        let admin = package_json(serde_json::json!({ "dependencies": { "react": "^17.0.2" } }));
+        let ui = package_json(serde_json::json!({ "devDependencies": { "typescript": "^5.3.0" } }));
+        let workspaces = [
+            workspace("admin", &admin),
+            workspace("docs", &docs),
+            workspace("ui", &ui),
+            workspace("web", &web),
+        ];
+
+        assert_eq!(
+            version_mismatches(&workspaces),
+            vec![Problem {
+                check: Check::VersionMismatch,
+                package: "admin".to_string(),
+                message: "depends on react@^17.0.2, but 2 other workspaces use ^18.2.0".to_string(),
+                fix: "change react to \"^18.2.0\" in packages/admin/package.json".to_string(),
+            }]
+        );
+    }
+
+    #[test]
+    fn test_missing_scripts() {
+        let root = package_json(serde_json::json!({ "scripts": { "format": "prettier" } }));
+        let web = package_json(serde_json::json!({ "scripts": { "build": "next build" } }));
+        let workspaces = [workspace("//", &root), workspace("web", &web)];
+        let tasks = [
+            "build",
+            "//#format",
+            "web#build",
+            "web#test",
+            "deploy",
+            "docs#build",
+        ]
+        .into_iter()
+        .map(TaskName::from);
+
+        let problems = missing_scripts(&workspaces, tasks);
+
+        assert_eq!(
+            problems
+                .iter()
+                .map(|problem| (problem.package.as_str(), problem.message.as_str()))
+                .collect::<Vec<_>>(),
+            vec![
+                (
+                    "web",
+                    "web#test is in the pipeline, but there's no test script"
+                ),
+                (
+                    "//",
+                    "deploy is in the pipeline, but no workspace has a deploy script"
+                ),
+                (
+                    "docs",
+                    "docs#build is in the pipeline, but there's no docs workspace"
+                ),
+            ]
+        );
+    }
+}
@@ -10,11 +10,16 @@ use crate::{
     Args,
 };
 
+pub(crate) mod bench;
 pub(crate) mod bin;
+pub(crate) mod cache;
+pub(crate) mod cache_server;
 pub(crate) mod daemon;
 pub(crate) mod generate;
+pub(crate) mod graph;
 pub(crate) mod info;
 pub(crate) mod link;
+pub(crate) mod lint;
 pub(crate) mod login;
 pub(crate) mod logout;
 pub(crate) mod prune;
@@ -65,6 +70,8 @@ impl CommandBase {
             .with_team_slug(self.args.team.clone())
             .with_token(self.args.token.clone())
             .with_timeout(self.args.remote_cache_timeout)
+            // An absent flag shouldn't disable preflight set via env or turbo.json
+            .with_preflight(self.args.preflight.then_some(true))
             .build()
     }
 
@@ -115,12 +122,15 @@ impl CommandBase {
 
     pub fn api_client(&self) -> Result<APIClient, ConfigError> {
         let config = self.config()?;
-        let args = self.args();
 
         let api_url = config.api_url();
         let timeout = config.timeout();
+        let preflight = config.preflight();
 
-        APIClient::new(api_url, timeout, self.version, args.preflight)
+        let retries = config.retries();
+
+        APIClient::new(api_url, timeout, self.version, preflight)
+            .map(|client| client.with_max_retries(retries))
             .map_err(ConfigError::ApiClient)
     }
 
@@ -1,9 +1,13 @@
 #[cfg(unix)]
 use std::os::unix::fs::PermissionsExt;
-use std::sync::OnceLock;
+use std::{
+    io::{self, Write},
+    sync::OnceLock,
+};
 
 use lazy_static::lazy_static;
 use miette::Diagnostic;
+use serde::Serialize;
 use tracing::trace;
 use turbopath::{
     AbsoluteSystemPathBuf, AnchoredSystemPath, AnchoredSystemPathBuf, RelativeUnixPath,
@@ -105,11 +109,18 @@ pub async fn prune(
         return Err(Error::BunUnsupported);
     }
 
-    println!(
+    // Stdout is reserved for the summary when it's printed as JSON
+    let json = base.args().json;
+    let mut progress: Box<dyn Write> = match json {
+        true => Box::new(io::stderr()),
+        false => Box::new(io::stdout()),
+    };
+    writeln!(
+        progress,
         "Generating pruned monorepo for {} in {}",
         base.ui.apply(BOLD.apply_to(scope.join(", "))),
         base.ui.apply(BOLD.apply_to(&prune.out_directory)),
-    );
+    )?;
 
     if let Some(workspace_config_path) = prune
         .package_graph
@@ -149,7 +160,7 @@ pub async fn prune(
                     .to_string(),
             );
 
-            println!(" - Added {workspace}");
+            writeln!(progress, " - Added {workspace}")?;
             workspace_names.push(workspace);
         }
     }
@@ -232,9 +243,26 @@ pub async fn prune(
         prune.copy_file(package_json(), Some(CopyDestination::Docker))?;
     }
 
+    if json {
+        let summary = PruneSummary {
+            out_dir: prune.out_directory.to_string(),
+            docker: prune.docker,
+            packages: &workspace_names,
+        };
+        println!("{}", serde_json::to_string_pretty(&summary)?);
+    }
+
     Ok(())
 }
 
+#[derive(Serialize)]
+#[serde(rename_all = "camelCase")]
+struct PruneSummary<'a> {
+    out_dir: String,
+    docker: bool,
+    packages: &'a [String],
+}
+
 struct Prune<'a> {
     package_graph: PackageGraph,
     root: AbsoluteSystemPathBuf,
@@ -6,7 +6,9 @@ use serde::Deserialize;
 use struct_iterable::Iterable;
 use thiserror::Error;
 use turbopath::{AbsoluteSystemPathBuf, AnchoredSystemPath};
+use turborepo_api_client::custom::CustomProvider;
 use turborepo_auth::{TURBO_TOKEN_DIR, TURBO_TOKEN_FILE, VERCEL_TOKEN_DIR, VERCEL_TOKEN_FILE};
+use turborepo_cache::{RemoteCacheFallback, RemoteCacheWritePolicy};
 use turborepo_dirs::config_dir;
 use turborepo_errors::TURBO_SITE;
 use turborepo_repository::package_json::{Error as PackageJsonError, PackageJson};
@@ -111,6 +113,37 @@ pub enum Error {
         #[source_code]
         text: NamedSource,
     },
+    #[error("`inputsFrom` can only refer to tasks in the same package")]
+    InvalidInputsFrom {
+        #[label("task from another package found here")]
+        span: Option<SourceSpan>,
+        #[source_code]
+        text: NamedSource,
+    },
+    #[error("Preset \"{preset}\" not found, expected a turbo.json at {path}")]
+    PresetNotFound {
+        preset: String,
+        path: String,
+        #[label("preset referenced here")]
+        span: Option<SourceSpan>,
+        #[source_code]
+        text: NamedSource,
+    },
+    #[error("Preset \"{preset}\" is outside of the repository")]
+    PresetOutsideRepo {
+        preset: String,
+        #[label("preset referenced here")]
+        span: Option<SourceSpan>,
+        #[source_code]
+        text: NamedSource,
+    },
+    #[error("Presets cannot extend other turbo.json files")]
+    NestedPreset {
+        #[label("extends found here")]
+        span: Option<SourceSpan>,
+        #[source_code]
+        text: NamedSource,
+    },
     #[error("No \"extends\" key found")]
     NoExtends {
         #[label("add extends key here")]
@@ -128,7 +161,11 @@ pub enum Error {
     InvalidRemoteCacheEnabled,
     #[error("TURBO_REMOTE_CACHE_TIMEOUT: error parsing timeout.")]
     InvalidRemoteCacheTimeout(#[source] std::num::ParseIntError),
-    #[error("TURBO_PREFLIGHT should be either 1 or 0.")]
+    #[error("TURBO_REMOTE_CACHE_RETRIES: error parsing retries.")]
+    InvalidRemoteCacheRetries(#[source] std::num::ParseIntError),
+    #[error("TURBO_REMOTE_CACHE_UPLOAD_CHUNK_SIZE: error parsing chunk size.")]
+    InvalidRemoteCacheUploadChunkSize(#[source] std::num::ParseIntError),
+    #[error("TURBO_PREFLIGHT should be either 1, 0, true or false.")]
     InvalidPreflight,
     #[error(transparent)]
     #[diagnostic(transparent)]
@@ -147,6 +184,7 @@ macro_rules! create_builder {
 const DEFAULT_API_URL: &str = "https://vercel.com/api";
 const DEFAULT_LOGIN_URL: &str = "https://vercel.com";
 const DEFAULT_TIMEOUT: u64 = 30;
+const DEFAULT_RETRIES: u32 = 2;
 
 // We intentionally don't derive Serialize so that different parts
 // of the code that want to display the config can tune how they
@@ -174,8 +212,14 @@ pub struct ConfigurationOptions {
     pub(crate) signature: Option<bool>,
     pub(crate) preflight: Option<bool>,
     pub(crate) timeout: Option<u64>,
+    pub(crate) retries: Option<u32>,
+    // In megabytes
+    pub(crate) upload_chunk_size: Option<u64>,
     pub(crate) enabled: Option<bool>,
     pub(crate) spaces_id: Option<String>,
+    pub(crate) remote_cache_provider: Option<CustomProvider>,
+    pub(crate) remote_cache_fallbacks: Option<Vec<RemoteCacheFallback>>,
+    pub(crate) remote_cache_write_policy: Option<RemoteCacheWritePolicy>,
 }
 
 #[derive(Default)]
@@ -229,9 +273,42 @@ impl ConfigurationOptions {
         self.timeout.unwrap_or(DEFAULT_TIMEOUT)
     }
 
+    pub fn retries(&self) -> u32 {
+        self.retries.unwrap_or(DEFAULT_RETRIES)
+    }
+
+    /// The size in bytes above which artifacts are uploaded in chunks. Chunked
+    /// uploads are disabled when unset or set to 0.
+    pub fn upload_chunk_size(&self) -> Option<u64> {
+        self.upload_chunk_size
+            .filter(|megabytes| *megabytes > 0)
+            .map(|megabytes| megabytes.saturating_mul(1024 * 1024))
+    }
+
     pub fn spaces_id(&self) -> Option<&str> {
         self.spaces_id.as_deref()
     }
+
+    pub fn remote_cache_provider(&self) -> Option<&CustomProvider> {
+        self.remote_cache_provider.as_ref()
+    }
+
+    pub fn remote_cache_fallbacks(&self) -> &[RemoteCacheFallback] {
+        self.remote_cache_fallbacks.as_deref().unwrap_or_default()
+    }
+
+    pub fn remote_cache_write_policy(&self) -> RemoteCacheWritePolicy {
+        self.remote_cache_write_policy.unwrap_or_default()
+    }
+
+    // Whether any of the remote caches can be used without being linked
+    pub fn has_custom_remote_cache(&self) -> bool {
+        self.remote_cache_provider.is_some()
+            || self
+                .remote_cache_fallbacks()
+                .iter()
+                .any(|fallback| matches!(fallback, RemoteCacheFallback::Custom(_)))
+    }
 }
 
 // Maps Some("") to None to emulate how Go handles empty strings
@@ -298,10 +375,15 @@ fn get_env_var_config(
     turbo_mapping.insert(OsString::from("turbo_teamid"), "team_id");
     turbo_mapping.insert(OsString::from("turbo_token"), "token");
     turbo_mapping.insert(OsString::from("turbo_remote_cache_timeout"), "timeout");
+    turbo_mapping.insert(OsString::from("turbo_remote_cache_retries"), "retries");
+    turbo_mapping.insert(
+        OsString::from("turbo_remote_cache_upload_chunk_size"),
+        "upload_chunk_size",
+    );
+    turbo_mapping.insert(OsString::from("turbo_preflight"), "preflight");
 
     // We do not enable new config sources:
     // turbo_mapping.insert(String::from("turbo_signature"), "signature"); // new
-    // turbo_mapping.insert(String::from("turbo_preflight"), "preflight"); // new
     // turbo_mapping.insert(String::from("turbo_remote_cache_enabled"), "enabled");
 
     let mut output_map = HashMap::new();
@@ -336,9 +418,9 @@ fn get_env_var_config(
 
     // Process preflight
     let preflight = if let Some(preflight) = output_map.get("preflight") {
-        match preflight.as_str() {
-            "0" => Some(false),
-            "1" => Some(true),
+        match preflight.to_ascii_lowercase().as_str() {
+            "0" | "false" => Some(false),
+            "1" | "true" => Some(true),
             _ => return Err(Error::InvalidPreflight),
         }
     } else {
@@ -367,6 +449,27 @@ fn get_env_var_config(
         None
     };
 
+    // Process retries
+    let retries = if let Some(retries) = output_map.get("retries") {
+        Some(
+            retries
+                .parse::<u32>()
+                .map_err(Error::InvalidRemoteCacheRetries)?,
+        )
+    } else {
+        None
+    };
+
+    // Process upload chunk size
+    let upload_chunk_size = if let Some(size) = output_map.get("upload_chunk_size") {
+        Some(
+            size.parse::<u64>()
+                .map_err(Error::InvalidRemoteCacheUploadChunkSize)?,
+        )
+    } else {
+        None
+    };
+
     // We currently don't pick up a Spaces ID via env var, we likely won't
     // continue using the Spaces name, we can add an env var when we have the
     // name we want to stick with.
@@ -386,7 +489,12 @@ fn get_env_var_config(
 
         // Processed numbers
         timeout,
+        retries,
+        upload_chunk_size,
         spaces_id,
+        remote_cache_provider: None,
+        remote_cache_fallbacks: None,
+        remote_cache_write_policy: None,
     };
 
     Ok(output)
@@ -430,7 +538,12 @@ fn get_override_env_var_config(
         preflight: None,
         enabled: None,
         timeout: None,
+        retries: None,
+        upload_chunk_size: None,
         spaces_id: None,
+        remote_cache_provider: None,
+        remote_cache_fallbacks: None,
+        remote_cache_write_policy: None,
     };
 
     Ok(output)
@@ -648,9 +761,24 @@ impl TurborepoConfigBuilder {
                     if let Some(timeout) = current_source_config.timeout {
                         acc.timeout = Some(timeout);
                     }
+                    if let Some(retries) = current_source_config.retries {
+                        acc.retries = Some(retries);
+                    }
+                    if let Some(size) = current_source_config.upload_chunk_size {
+                        acc.upload_chunk_size = Some(size);
+                    }
                     if let Some(spaces_id) = current_source_config.spaces_id {
                         acc.spaces_id = Some(spaces_id);
                     }
+                    if let Some(provider) = current_source_config.remote_cache_provider {
+                        acc.remote_cache_provider = Some(provider);
+                    }
+                    if let Some(fallbacks) = current_source_config.remote_cache_fallbacks {
+                        acc.remote_cache_fallbacks = Some(fallbacks);
+                    }
+                    if let Some(write_policy) = current_source_config.remote_cache_write_policy {
+                        acc.remote_cache_write_policy = Some(write_policy);
+                    }
 
                     acc
                 })
@@ -664,11 +792,13 @@ mod test {
     use std::{collections::HashMap, ffi::OsString};
 
     use tempfile::TempDir;
+    use test_case::test_case;
     use turbopath::AbsoluteSystemPathBuf;
 
     use crate::config::{
         get_env_var_config, get_override_env_var_config, ConfigurationOptions,
-        TurborepoConfigBuilder, DEFAULT_API_URL, DEFAULT_LOGIN_URL, DEFAULT_TIMEOUT,
+        TurborepoConfigBuilder, DEFAULT_API_URL, DEFAULT_LOGIN_URL, DEFAULT_RETRIES,
+        DEFAULT_TIMEOUT,
     };
 
     #[test]
@@ -684,6 +814,12 @@ mod test {
         assert!(!defaults.preflight());
         assert_eq!(defaults.timeout(), DEFAULT_TIMEOUT);
         assert_eq!(defaults.spaces_id(), None);
+        assert_eq!(defaults.remote_cache_provider(), None);
+        assert!(defaults.remote_cache_fallbacks().is_empty());
+        assert_eq!(
+            defaults.remote_cache_write_policy(),
+            RemoteCacheWritePolicy::WriteFirst
+        );
     }
 
     #[test]
@@ -716,6 +852,52 @@ mod test {
         assert_eq!(turbo_remote_cache_timeout, config.timeout.unwrap());
     }
 
+    #[test]
+    fn test_env_retries() {
+        let mut env: HashMap<OsString, OsString> = HashMap::new();
+        assert_eq!(get_env_var_config(&env).unwrap().retries(), DEFAULT_RETRIES);
+
+        env.insert("turbo_remote_cache_retries".into(), "5".into());
+        assert_eq!(get_env_var_config(&env).unwrap().retries(), 5);
+
+        env.insert("turbo_remote_cache_retries".into(), "-1".into());
+        assert!(get_env_var_config(&env).is_err());
+    }
+
+    #[test]
+    fn test_env_upload_chunk_size() {
+        let mut env: HashMap<OsString, OsString> = HashMap::new();
+        assert_eq!(get_env_var_config(&env).unwrap().upload_chunk_size(), None);
+
+        env.insert("turbo_remote_cache_upload_chunk_size".into(), "64".into());
+        assert_eq!(
+            get_env_var_config(&env).unwrap().upload_chunk_size(),
+            Some(64 * 1024 * 1024)
+        );
+
+        env.insert("turbo_remote_cache_upload_chunk_size".into(), "0".into());
+        assert_eq!(get_env_var_config(&env).unwrap().upload_chunk_size(), None);
+    }
+
+    #[test_case("1", true ; "one")]
+    #[test_case("0", false ; "zero")]
+    #[test_case("true", true ; "true")]
+    #[test_case("FALSE", false ; "uppercase false")]
+    #[test_case("True", true ; "mixed case true")]
+    fn test_env_preflight(value: &str, expected: bool) {
+        let mut env: HashMap<OsString, OsString> = HashMap::new();
+        env.insert("turbo_preflight".into(), value.into());
+        let config = get_env_var_config(&env).unwrap();
+        assert_eq!(config.preflight(), expected);
+    }
+
+    #[test]
+    fn test_env_preflight_invalid() {
+        let mut env: HashMap<OsString, OsString> = HashMap::new();
+        env.insert("turbo_preflight".into(), "yes".into());
+        assert!(get_env_var_config(&env).is_err());
+    }
+
     #[test]
     fn test_empty_env_setting() {
         let mut env: HashMap<OsString, OsString> = HashMap::new();
@@ -144,6 +144,39 @@ impl<T> DaemonClient<T> {
 
         Ok(response)
     }
+
+    /// Returns the package.json and turbo.json files of every package the
+    /// daemon has discovered, already parsed.
+    pub async fn get_package_configs(&mut self) -> Result<Vec<proto::PackageConfig>, DaemonError> {
+        let response = self
+            .client
+            .get_package_configs(proto::GetPackageConfigsRequest {})
+            .await?
+            .into_inner();
+
+        Ok(response.package_configs)
+    }
+
+    pub async fn publish_task_status(
+        &mut self,
+        update: proto::TaskStatusUpdate,
+    ) -> Result<(), DaemonError> {
+        self.client.publish_task_status(update).await?;
+
+        Ok(())
+    }
+
+    /// Streams the task status updates that runs publish from now on
+    pub async fn subscribe_task_status(
+        &mut self,
+    ) -> Result<tonic::Streaming<proto::TaskStatusUpdate>, DaemonError> {
+        let response = self
+            .client
+            .subscribe_task_status(proto::SubscribeTaskStatusRequest {})
+            .await?;
+
+        Ok(response.into_inner())
+    }
 }
 
 impl DaemonClient<DaemonConnector> {
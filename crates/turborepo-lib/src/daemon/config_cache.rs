@@ -0,0 +1,185 @@
+//! Memoizes the parsing of package.json and turbo.json files. Entries are keyed
+//! by the hash of a file's contents, so an edited file is parsed again while an
+//! unchanged one, or one that was changed back, is served from memory.
+
+use std::{
+    num::NonZeroUsize,
+    str::FromStr,
+    sync::{Arc, Mutex},
+};
+
+use lru::LruCache;
+use sha2::{Digest, Sha256};
+use turbopath::{AbsoluteSystemPath, AnchoredSystemPath};
+use turborepo_repository::package_json::PackageJson;
+
+use crate::turbo_json::{
+    validate_extends, validate_no_package_task_syntax, RawTurboJson, TurboJson,
+};
+
+// Enough for the package.json and turbo.json of a couple thousand packages
+const DEFAULT_CAPACITY: usize = 4096;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash)]
+enum ConfigKind {
+    PackageJson,
+    TurboJson,
+}
+
+/// A least recently used cache of parsed config files. Parsed files are stored
+/// serialized as JSON since that is how they are sent to clients.
+pub struct ConfigCache {
+    // Files that fail to parse are stored as `None` so that they're only
+    // parsed once as well
+    entries: Mutex<LruCache<(ConfigKind, String), Option<Arc<str>>>>,
+}
+
+impl Default for ConfigCache {
+    fn default() -> Self {
+        Self::new(NonZeroUsize::new(DEFAULT_CAPACITY).expect("capacity is non-zero"))
+    }
+}
+
+impl ConfigCache {
+    pub fn new(capacity: NonZeroUsize) -> Self {
+        Self {
+            entries: Mutex::new(LruCache::new(capacity)),
+        }
+    }
+
+    /// Returns the package.json at `path`, or `None` if it isn't valid
+    pub fn package_json(&self, path: &AbsoluteSystemPath) -> std::io::Result<Option<Arc<str>>> {
+        let contents = path.read_to_string()?;
+        Ok(
+            self.get_or_parse(ConfigKind::PackageJson, &contents, |contents| {
+                // Clients get the whole package.json and pick the fields they need
+                let package_json = PackageJson::from_str(contents).ok()?;
+                serde_json::to_string(&package_json).ok()
+            }),
+        )
+    }
+
+    /// Returns the workspace turbo.json at `path`, or `None` if it isn't
+    /// valid. Root turbo.json files are validated differently and aren't
+    /// supported.
+    pub fn turbo_json(
+        &self,
+        repo_root: &AbsoluteSystemPath,
+        path: &AbsoluteSystemPath,
+    ) -> std::io::Result<Option<Arc<str>>> {
+        let contents = path.read_to_string()?;
+        Ok(
+            self.get_or_parse(ConfigKind::TurboJson, &contents, |contents| {
+                let anchored_path = repo_root.anchor(path).ok()?;
+                parse_workspace_turbo_json(contents, &anchored_path)
+            }),
+        )
+    }
+
+    fn get_or_parse(
+        &self,
+        kind: ConfigKind,
+        contents: &str,
+        parse: impl FnOnce(&str) -> Option<String>,
+    ) -> Option<Arc<str>> {
+        let key = (kind, hex::encode(Sha256::digest(contents.as_bytes())));
+        if let Some(entry) = self.entries.lock().expect("lock poisoned").get(&key) {
+            return entry.clone();
+        }
+
+        // Parsing happens outside of the lock so that files can be parsed
+        // concurrently. Two requests for the same new file may both parse it.
+        let entry = parse(contents).map(Arc::from);
+        self.entries
+            .lock()
+            .expect("lock poisoned")
+            .put(key, entry.clone());
+        entry
+    }
+}
+
+// Applies the same checks as the engine builder does for workspace turbo.json
+// files so that clients can use the result without checking it again
+fn parse_workspace_turbo_json(contents: &str, path: &AnchoredSystemPath) -> Option<String> {
+    let raw_turbo_json = RawTurboJson::parse(contents, path).ok()?;
+    let turbo_json = TurboJson::try_from(raw_turbo_json.clone()).ok()?;
+    if !turbo_json
+        .validate(&[validate_no_package_task_syntax, validate_extends])
+        .is_empty()
+    {
+        return None;
+    }
+    serde_json::to_string(&raw_turbo_json).ok()
+}
+
+#[cfg(test)]
+mod test {
+    use anyhow::Result;
+    use tempfile::tempdir;
+
+    use super::*;
+
+    #[test]
+    fn test_memoizes_by_contents() -> Result<()> {
+        let dir = tempdir()?;
+        let repo_root = AbsoluteSystemPath::from_std_path(dir.path())?;
+        let package_json = repo_root.join_components(&["packages", "ui", "package.json"]);
+        package_json.ensure_dir()?;
+        package_json.create_with_contents(r#"{"name": "ui"}"#)?;
+
+        let cache = ConfigCache::default();
+        let first = cache.package_json(&package_json)?.unwrap();
+        let second = cache.package_json(&package_json)?.unwrap();
+        assert!(Arc::ptr_eq(&first, &second));
+
+        package_json
+            .create_with_contents(r#"{"name": "ui", "version": "1.0.0", "files": ["dist"]}"#)?;
+        let changed = cache.package_json(&package_json)?.unwrap();
+        assert!(!Arc::ptr_eq(&first, &changed));
+        let changed = PackageJson::from_str(&changed)?;
+        assert_eq!(changed.version.as_deref(), Some("1.0.0"));
+        // Unstructured fields are kept for clients
+        assert!(changed.other.contains_key("files"));
+
+        Ok(())
+    }
+
+    #[test]
+    fn test_evicts_least_recently_used() {
+        let cache = ConfigCache::new(NonZeroUsize::new(1).unwrap());
+        let parse = |contents: &str| Some(contents.to_string());
+
+        let a = cache.get_or_parse(ConfigKind::PackageJson, "a", parse);
+        cache.get_or_parse(ConfigKind::PackageJson, "b", parse);
+        let a_again = cache.get_or_parse(ConfigKind::PackageJson, "a", parse);
+
+        assert!(!Arc::ptr_eq(&a.unwrap(), &a_again.unwrap()));
+    }
+
+    #[test]
+    fn test_turbo_json() -> Result<()> {
+        let dir = tempdir()?;
+        let repo_root = AbsoluteSystemPath::from_std_path(dir.path())?;
+        let valid = repo_root.join_components(&["packages", "ui", "turbo.json"]);
+        let invalid = repo_root.join_components(&["packages", "web", "turbo.json"]);
+        valid.ensure_dir()?;
+        invalid.ensure_dir()?;
+        valid.create_with_contents(
+            r#"{
+                // comments are allowed
+                "extends": ["//"],
+                "pipeline": { "build": { "outputs": ["dist/**"] } }
+            }"#,
+        )?;
+        // Workspace configs can't use package task syntax
+        invalid.create_with_contents(r#"{ "extends": ["//"], "pipeline": { "ui#build": {} } }"#)?;
+
+        let cache = ConfigCache::default();
+        let json = cache.turbo_json(repo_root, &valid)?.unwrap();
+        let turbo_json = TurboJson::try_from(serde_json::from_str::<RawTurboJson>(&json)?)?;
+        assert_eq!(turbo_json.extends.value, vec!["//".to_string()]);
+        assert_eq!(cache.turbo_json(repo_root, &invalid)?, None);
+
+        Ok(())
+    }
+}
@@ -23,6 +23,7 @@
 mod bump_timeout;
 mod bump_timeout_layer;
 mod client;
+mod config_cache;
 mod connector;
 mod default_timeout_layer;
 pub(crate) mod endpoint;
@@ -100,7 +101,7 @@ pub(crate) mod proto {
     /// - Bump the minor version if adding new features, such that clients can
     ///   mandate at least some set of features on the target server.
     /// - Bump the patch version if making backwards compatible bug fixes.
-    pub const VERSION: &str = "1.11.0";
+    pub const VERSION: &str = "1.13.0";
 
     impl From<PackageManager> for turborepo_repository::package_manager::PackageManager {
         fn from(pm: PackageManager) -> Self {
@@ -18,12 +18,13 @@ use semver::Version;
 use thiserror::Error;
 use tokio::{
     select,
-    sync::{mpsc, oneshot},
+    sync::{broadcast, mpsc, oneshot},
     task::JoinHandle,
 };
+use tokio_stream::wrappers::ReceiverStream;
 use tonic::{server::NamedService, transport::Server};
 use tower::ServiceBuilder;
-use tracing::{error, info, trace, warn};
+use tracing::{debug, error, info, trace, warn};
 use turbopath::{AbsoluteSystemPath, AbsoluteSystemPathBuf};
 use turborepo_filewatch::{
     cookies::CookieWriter,
@@ -36,7 +37,9 @@ use turborepo_repository::{
     package_manager,
 };
 
-use super::{bump_timeout::BumpTimeout, endpoint::SocketOpenError, proto};
+use super::{
+    bump_timeout::BumpTimeout, config_cache::ConfigCache, endpoint::SocketOpenError, proto,
+};
 use crate::daemon::{
     bump_timeout_layer::BumpTimeoutLayer, default_timeout_layer::DefaultTimeoutLayer,
     endpoint::listen_socket, Paths,
@@ -261,8 +264,15 @@ struct TurboGrpcServiceInner {
     start_time: Instant,
     log_file: AbsoluteSystemPathBuf,
     package_discovery: Arc<WatchingPackageDiscovery>,
+    repo_root: AbsoluteSystemPathBuf,
+    config_cache: Arc<ConfigCache>,
+    task_status: broadcast::Sender<proto::TaskStatusUpdate>,
 }
 
+// How many task status updates are buffered for each subscriber before the
+// oldest are dropped
+const TASK_STATUS_CAPACITY: usize = 1024;
+
 // we have a grpc service that uses watching package discovery, and where the
 // watching package hasher also uses watching package discovery as well as
 // falling back to a local package hasher
@@ -303,6 +313,9 @@ impl TurboGrpcServiceInner {
                 times_saved: Arc::new(Mutex::new(HashMap::new())),
                 start_time: Instant::now(),
                 log_file,
+                repo_root,
+                config_cache: Arc::new(ConfigCache::default()),
+                task_status: broadcast::channel(TASK_STATUS_CAPACITY).0,
             },
             exit_root_watch,
             watch_root_handle,
@@ -504,14 +517,7 @@ impl proto::turbod_server::Turbod for TurboGrpcServiceInner {
                     package_manager: proto::PackageManager::from(packages.package_manager).into(),
                 })
             })
-            .map_err(|e| match e {
-                turborepo_repository::discovery::Error::Unavailable => {
-                    tonic::Status::unavailable("package discovery unavailable")
-                }
-                turborepo_repository::discovery::Error::Failed(e) => {
-                    tonic::Status::internal(format!("{}", e))
-                }
-            })
+            .map_err(discovery_error)
     }
 
     async fn discover_packages_blocking(
@@ -534,14 +540,104 @@ impl proto::turbod_server::Turbod for TurboGrpcServiceInner {
                     package_manager: proto::PackageManager::from(packages.package_manager).into(),
                 })
             })
-            .map_err(|e| match e {
-                turborepo_repository::discovery::Error::Unavailable => {
-                    tonic::Status::unavailable("package discovery unavailable")
-                }
-                turborepo_repository::discovery::Error::Failed(e) => {
-                    tonic::Status::internal(format!("{}", e))
+            .map_err(discovery_error)
+    }
+
+    async fn get_package_configs(
+        &self,
+        _request: tonic::Request<proto::GetPackageConfigsRequest>,
+    ) -> Result<tonic::Response<proto::GetPackageConfigsResponse>, tonic::Status> {
+        let packages = self
+            .package_discovery
+            .discover_packages()
+            .await
+            .map_err(discovery_error)?;
+
+        let repo_root = self.repo_root.clone();
+        let config_cache = self.config_cache.clone();
+        let package_configs = tokio::task::spawn_blocking(move || {
+            packages
+                .workspaces
+                .into_iter()
+                .map(|workspace| {
+                    // A package.json that can't be parsed fails the whole request
+                    // so that the client reports the error itself
+                    let package_json = config_cache
+                        .package_json(&workspace.package_json)?
+                        .ok_or_else(|| {
+                            std::io::Error::new(
+                                std::io::ErrorKind::InvalidData,
+                                format!("unable to parse {}", workspace.package_json),
+                            )
+                        })?;
+                    let turbo_json = match &workspace.turbo_json {
+                        Some(path) => config_cache.turbo_json(&repo_root, path)?,
+                        None => None,
+                    };
+                    Ok(proto::PackageConfig {
+                        package_json_path: workspace.package_json.to_string(),
+                        package_json: package_json.to_string(),
+                        turbo_json_path: workspace.turbo_json.map(|path| path.to_string()),
+                        turbo_json: turbo_json.map(|json| json.to_string()),
+                    })
+                })
+                .collect::<Result<Vec<_>, std::io::Error>>()
+        })
+        .await
+        .map_err(|e| tonic::Status::internal(e.to_string()))?
+        .map_err(|e| tonic::Status::internal(e.to_string()))?;
+
+        Ok(tonic::Response::new(proto::GetPackageConfigsResponse {
+            package_configs,
+        }))
+    }
+
+    async fn publish_task_status(
+        &self,
+        request: tonic::Request<proto::TaskStatusUpdate>,
+    ) -> Result<tonic::Response<proto::PublishTaskStatusResponse>, tonic::Status> {
+        // Sending only fails when there are no subscribers
+        let _ = self.task_status.send(request.into_inner());
+        Ok(tonic::Response::new(proto::PublishTaskStatusResponse {}))
+    }
+
+    type SubscribeTaskStatusStream = ReceiverStream<Result<proto::TaskStatusUpdate, tonic::Status>>;
+
+    async fn subscribe_task_status(
+        &self,
+        _request: tonic::Request<proto::SubscribeTaskStatusRequest>,
+    ) -> Result<tonic::Response<Self::SubscribeTaskStatusStream>, tonic::Status> {
+        let mut updates = self.task_status.subscribe();
+        let (tx, rx) = mpsc::channel(TASK_STATUS_CAPACITY);
+        tokio::spawn(async move {
+            loop {
+                let update = match updates.recv().await {
+                    Ok(update) => update,
+                    Err(broadcast::error::RecvError::Lagged(skipped)) => {
+                        debug!("task status subscriber lagged, skipped {skipped} updates");
+                        continue;
+                    }
+                    Err(broadcast::error::RecvError::Closed) => break,
+                };
+                // The subscriber has disconnected
+                if tx.send(Ok(update)).await.is_err() {
+                    break;
                 }
-            })
+            }
+        });
+
+        Ok(tonic::Response::new(ReceiverStream::new(rx)))
+    }
+}
+
+fn discovery_error(error: turborepo_repository::discovery::Error) -> tonic::Status {
+    match error {
+        turborepo_repository::discovery::Error::Unavailable => {
+            tonic::Status::unavailable("package discovery unavailable")
+        }
+        turborepo_repository::discovery::Error::Failed(e) => {
+            tonic::Status::internal(format!("{}", e))
+        }
     }
 }
 
@@ -795,4 +891,52 @@ mod test {
             .expect("server exited");
         assert_matches!(close_reason, Ok(CloseReason::Shutdown));
     }
+
+    #[tokio::test(flavor = "multi_thread")]
+    async fn test_task_status() {
+        use tokio_stream::StreamExt;
+
+        use super::TurboGrpcServiceInner;
+        use crate::daemon::proto::{self, turbod_server::Turbod};
+
+        let tempdir = tempfile::tempdir().unwrap();
+        let repo_root = AbsoluteSystemPathBuf::try_from(tempdir.path())
+            .unwrap()
+            .to_realpath()
+            .unwrap();
+        let (shutdown_tx, _shutdown_rx) = tokio::sync::mpsc::channel(1);
+        let (service, exit_root_watch, _) = TurboGrpcServiceInner::new(
+            MockDiscovery,
+            repo_root.clone(),
+            shutdown_tx,
+            repo_root.join_component("daemon.log"),
+        );
+
+        let update = proto::TaskStatusUpdate {
+            run_id: "run".to_string(),
+            task_id: "web#build".to_string(),
+            package: "web".to_string(),
+            task: "build".to_string(),
+            state: proto::TaskState::Succeeded.into(),
+            exit_code: Some(0),
+            ..Default::default()
+        };
+        // Updates published before subscribing aren't replayed
+        service
+            .publish_task_status(tonic::Request::new(update.clone()))
+            .await
+            .unwrap();
+        let mut updates = service
+            .subscribe_task_status(tonic::Request::new(proto::SubscribeTaskStatusRequest {}))
+            .await
+            .unwrap()
+            .into_inner();
+        service
+            .publish_task_status(tonic::Request::new(update.clone()))
+            .await
+            .unwrap();
+
+        assert_eq!(updates.next().await.unwrap().unwrap(), update);
+        exit_root_watch.send(()).ok();
+    }
 }
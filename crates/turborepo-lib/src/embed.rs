@@ -0,0 +1,224 @@
+//! Runs tasks from another program without shelling out to the `turbo`
+//! binary. Package graph construction, hashing, caching and scheduling are
+//! the same as `turbo run`, and the program is told about each task as it
+//! starts and finishes.
+//!
+//! ```ignore
+//! let options = RunOptions {
+//!     tasks: vec!["build".to_string()],
+//!     filter: vec!["web...".to_string()],
+//!     ..Default::default()
+//! };
+//! let exit_code = turborepo_lib::embed::run(&repo, options, |event| {
+//!     println!("{} {:?}", event.task_id, event.state);
+//! }, std::future::pending())
+//! .await?;
+//! ```
+
+use std::{future::Future, sync::Arc, time::Duration};
+
+use clap::Parser;
+use thiserror::Error;
+use turbopath::AbsoluteSystemPath;
+use turborepo_repository::inference::{RepoMode, RepoState};
+use turborepo_telemetry::events::command::CommandEventBuilder;
+use turborepo_ui::UI;
+
+use crate::{
+    cli::{Args, Command},
+    commands::CommandBase,
+    daemon::proto,
+    get_version, run,
+    run::Run,
+    signal::SignalHandler,
+};
+
+#[derive(Debug, Error)]
+pub enum Error {
+    #[error("invalid run options: {0}")]
+    Options(#[from] clap::Error),
+    #[error(transparent)]
+    Inference(#[from] turborepo_repository::inference::Error),
+    #[error(transparent)]
+    Run(#[from] run::Error),
+}
+
+/// The options of a run. These mirror the flags of `turbo run`, anything not
+/// covered here can be passed through `extra_args` as it would be on the
+/// command line.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct RunOptions {
+    /// The tasks to run, e.g. `build` or `web#build`
+    pub tasks: Vec<String>,
+    /// Package selectors, with the same syntax as `--filter`
+    pub filter: Vec<String>,
+    /// Ignore the existing cache
+    pub force: bool,
+    /// Keep running tasks after one of them fails
+    pub continue_on_error: bool,
+    /// Task concurrency, with the same syntax as `--concurrency`
+    pub concurrency: Option<String>,
+    /// Override the filesystem cache directory
+    pub cache_dir: Option<String>,
+    /// Any other `turbo run` flags, e.g. `--output-logs=none`
+    pub extra_args: Vec<String>,
+    /// Arguments passed through to every task, as after `--`
+    pub pass_through_args: Vec<String>,
+}
+
+impl RunOptions {
+    fn to_args(&self) -> Vec<String> {
+        let mut args = vec!["turbo".to_string(), "run".to_string()];
+        args.extend(self.tasks.iter().cloned());
+        args.extend(
+            self.filter
+                .iter()
+                .map(|filter| format!("--filter={filter}")),
+        );
+        if self.force {
+            args.push("--force".to_string());
+        }
+        if self.continue_on_error {
+            args.push("--continue".to_string());
+        }
+        if let Some(concurrency) = &self.concurrency {
+            args.push(format!("--concurrency={concurrency}"));
+        }
+        if let Some(cache_dir) = &self.cache_dir {
+            args.push(format!("--cache-dir={cache_dir}"));
+        }
+        args.extend(self.extra_args.iter().cloned());
+        if !self.pass_through_args.is_empty() {
+            args.push("--".to_string());
+            args.extend(self.pass_through_args.iter().cloned());
+        }
+        args
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum TaskState {
+    Running,
+    Cached,
+    Succeeded,
+    Failed,
+}
+
+/// A change in the state of one task of a run
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct TaskEvent {
+    pub run_id: String,
+    /// The task in `package#task` form
+    pub task_id: String,
+    pub package: String,
+    pub task: String,
+    pub hash: String,
+    pub state: TaskState,
+    /// Only set once a task that executed has finished
+    pub exit_code: Option<i32>,
+    /// How long the task took, zero while it is running
+    pub duration: Duration,
+}
+
+impl From<&proto::TaskStatusUpdate> for TaskEvent {
+    fn from(update: &proto::TaskStatusUpdate) -> Self {
+        let state = match update.state() {
+            proto::TaskState::Running => TaskState::Running,
+            proto::TaskState::Cached => TaskState::Cached,
+            proto::TaskState::Succeeded => TaskState::Succeeded,
+            proto::TaskState::Failed => TaskState::Failed,
+        };
+        Self {
+            run_id: update.run_id.clone(),
+            task_id: update.task_id.clone(),
+            package: update.package.clone(),
+            task: update.task.clone(),
+            hash: update.hash.clone(),
+            state,
+            exit_code: update.exit_code,
+            duration: Duration::from_millis(update.duration_msec),
+        }
+    }
+}
+
+/// Runs tasks in the repository containing `dir` and returns the exit code
+/// `turbo run` would have exited with. `on_event` is called as each task
+/// starts and finishes. Once `stop` completes, the running tasks are stopped
+/// the same way they are when `turbo run` is interrupted.
+///
+/// Task logs are written to stdout as usual, pass `--output-logs=none` in
+/// `RunOptions::extra_args` to silence them.
+pub async fn run(
+    dir: &AbsoluteSystemPath,
+    options: RunOptions,
+    on_event: impl Fn(TaskEvent) + Send + Sync + 'static,
+    stop: impl Future<Output = ()> + Send + 'static,
+) -> Result<i32, Error> {
+    let repo_state = RepoState::infer(dir)?;
+    let mut args = Args::try_parse_from(options.to_args())?;
+    if let Some(Command::Run(run_args)) = &mut args.command {
+        run_args.single_package |= repo_state.mode == RepoMode::SinglePackage;
+    }
+
+    let base = CommandBase::new(args, repo_state.root, get_version(), UI::infer());
+    let api_auth = base.api_auth().map_err(run::Error::from)?;
+    let api_client = base.api_client().map_err(run::Error::from)?;
+    let run = Run::new(base, api_auth)?
+        .with_task_status_listener(Arc::new(move |update| on_event(TaskEvent::from(update))));
+
+    let handler = SignalHandler::new(async move {
+        stop.await;
+        Some(())
+    });
+    let run_fut = run.run(&handler, CommandEventBuilder::new("run"), api_client);
+    let handler_fut = handler.done();
+    tokio::select! {
+        biased;
+        _ = handler_fut => Ok(1),
+        result = run_fut => {
+            handler.close().await;
+            Ok(result?)
+        }
+    }
+}
+
+#[cfg(test)]
+mod test {
+    use super::RunOptions;
+
+    #[test]
+    fn test_options_to_args() {
+        let options = RunOptions {
+            tasks: vec!["build".to_string(), "test".to_string()],
+            filter: vec!["web...".to_string()],
+            force: true,
+            continue_on_error: true,
+            concurrency: Some("4".to_string()),
+            cache_dir: Some("/tmp/cache".to_string()),
+            extra_args: vec!["--output-logs=none".to_string()],
+            pass_through_args: vec!["--watch".to_string()],
+        };
+        assert_eq!(
+            options.to_args(),
+            vec![
+                "turbo",
+                "run",
+                "build",
+                "test",
+                "--filter=web...",
+                "--force",
+                "--continue",
+                "--concurrency=4",
+                "--cache-dir=/tmp/cache",
+                "--output-logs=none",
+                "--",
+                "--watch",
+            ]
+        );
+    }
+
+    #[test]
+    fn test_default_options_to_args() {
+        assert_eq!(RunOptions::default().to_args(), vec!["turbo", "run"]);
+    }
+}
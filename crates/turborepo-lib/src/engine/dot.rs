@@ -2,10 +2,16 @@ use std::io;
 
 use petgraph::{visit::EdgeRef, Graph};
 
-use super::{Built, Engine, TaskNode};
+use super::{package_groups::PackageGroups, Built, Engine, TaskNode};
+use crate::cli::GraphMode;
 
 impl Engine<Built> {
-    pub fn dot_graph<W: io::Write>(&self, writer: W, is_single: bool) -> Result<(), io::Error> {
+    pub fn dot_graph<W: io::Write>(
+        &self,
+        writer: W,
+        is_single: bool,
+        mode: GraphMode,
+    ) -> Result<(), io::Error> {
         let display_node = match is_single {
             true => |node: &TaskNode| match node {
                 TaskNode::Root => node.to_string(),
@@ -13,7 +19,15 @@ impl Engine<Built> {
             },
             false => |node: &TaskNode| node.to_string(),
         };
-        render_graph(&self.task_graph, display_node, writer)
+        match mode {
+            GraphMode::Tasks => render_graph(&self.task_graph, display_node, writer),
+            GraphMode::Packages => {
+                render_package_graph(&PackageGroups::new(&self.task_graph), writer)
+            }
+            GraphMode::Both => {
+                render_clustered_graph(&PackageGroups::new(&self.task_graph), writer)
+            }
+        }
     }
 }
 
@@ -54,6 +68,55 @@ fn render_graph<N>(
     Ok(())
 }
 
+// A node per package. Packages are listed on their own so that packages
+// without any dependencies are still drawn.
+fn render_package_graph(
+    groups: &PackageGroups,
+    mut writer: impl io::Write,
+) -> Result<(), io::Error> {
+    writer.write_all(GRAPH_PRELUDE.as_bytes())?;
+    for package in groups.tasks.keys() {
+        writeln!(writer, "\t\t\"[root] {package}\"")?;
+    }
+    for (source, target) in groups.package_edges.keys() {
+        writeln!(writer, "\t\t\"[root] {source}\" -> \"[root] {target}\"")?;
+    }
+    writer.write_all("\t}\n}\n\n".as_bytes())?;
+    Ok(())
+}
+
+// A cluster per package. Dependencies between packages are drawn between the
+// clusters using one of the task dependencies they stand in for, which relies
+// on `compound` being set.
+fn render_clustered_graph(
+    groups: &PackageGroups,
+    mut writer: impl io::Write,
+) -> Result<(), io::Error> {
+    writer.write_all("\ndigraph {\n\tcompound = \"true\"\n\tnewrank = \"true\"\n".as_bytes())?;
+    for (package, tasks) in &groups.tasks {
+        writeln!(writer, "\tsubgraph \"cluster_{package}\" {{")?;
+        writeln!(writer, "\t\tlabel = \"{package}\"")?;
+        for task in tasks {
+            writeln!(writer, "\t\t\"[root] {task}\"")?;
+        }
+        for (source, target) in &groups.task_edges {
+            if tasks.contains(source) {
+                writeln!(writer, "\t\t\"[root] {source}\" -> \"[root] {target}\"")?;
+            }
+        }
+        writeln!(writer, "\t}}")?;
+    }
+    for ((source_package, target_package), (source, target)) in &groups.package_edges {
+        writeln!(
+            writer,
+            "\t\"[root] {source}\" -> \"[root] {target}\" [ltail = \"cluster_{source_package}\", \
+             lhead = \"cluster_{target_package}\"]"
+        )?;
+    }
+    writer.write_all("}\n\n".as_bytes())?;
+    Ok(())
+}
+
 #[cfg(test)]
 mod test {
     use pretty_assertions::assert_eq;
@@ -76,6 +139,43 @@ mod test {
 \tsubgraph \"root\" {
 \t\t\"[root] ___ROOT___\" -> \"[root] build\"
 \t}
+}\n\n"
+        );
+    }
+
+    #[test]
+    fn test_clustered_graph_output() {
+        let pair = |a: &str, b: &str| (a.to_string(), b.to_string());
+        let groups = PackageGroups {
+            tasks: [
+                ("ui".to_string(), ["ui#build".to_string()].into()),
+                (
+                    "web".to_string(),
+                    ["web#build".to_string(), "web#test".to_string()].into(),
+                ),
+            ]
+            .into(),
+            task_edges: [pair("web#test", "web#build")].into(),
+            package_edges: [(pair("web", "ui"), pair("web#build", "ui#build"))].into(),
+        };
+        let mut bytes = Vec::new();
+        render_clustered_graph(&groups, &mut bytes).unwrap();
+        assert_eq!(
+            String::from_utf8(bytes).unwrap(),
+            "\ndigraph {
+\tcompound = \"true\"
+\tnewrank = \"true\"
+\tsubgraph \"cluster_ui\" {
+\t\tlabel = \"ui\"
+\t\t\"[root] ui#build\"
+\t}
+\tsubgraph \"cluster_web\" {
+\t\tlabel = \"web\"
+\t\t\"[root] web#build\"
+\t\t\"[root] web#test\"
+\t\t\"[root] web#test\" -> \"[root] web#build\"
+\t}
+\t\"[root] web#build\" -> \"[root] ui#build\" [ltail = \"cluster_web\", lhead = \"cluster_ui\"]
 }\n\n"
         );
     }
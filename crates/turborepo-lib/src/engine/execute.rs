@@ -1,7 +1,11 @@
-use std::sync::{Arc, Mutex};
+use std::{
+    cmp::{Ordering, Reverse},
+    collections::{BinaryHeap, HashMap},
+    sync::{Arc, Mutex},
+};
 
 use futures::{stream::FuturesUnordered, StreamExt};
-use tokio::sync::{mpsc, oneshot, Semaphore};
+use tokio::sync::{mpsc, oneshot, watch, OwnedSemaphorePermit, Semaphore};
 use tracing::log::debug;
 use turborepo_graph_utils::Walker;
 
@@ -17,11 +21,17 @@ pub struct Message<T, U> {
 // the future
 type VisitorData = TaskId<'static>;
 type VisitorResult = Result<(), StopExecution>;
+// A task's priority along with where to send its permit once it may run
+type PermitRequest = (i32, oneshot::Sender<OwnedSemaphorePermit>);
 
-#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+#[derive(Debug, Clone)]
 pub struct ExecutionOptions {
     parallel: bool,
     concurrency: usize,
+    // Limits for tasks with a given name or `package#task` id, applied in
+    // addition to `concurrency`
+    task_concurrency: HashMap<String, usize>,
+    control: Option<SchedulerControl>,
 }
 
 impl ExecutionOptions {
@@ -29,6 +39,172 @@ impl ExecutionOptions {
         Self {
             parallel,
             concurrency,
+            task_concurrency: HashMap::new(),
+            control: None,
+        }
+    }
+
+    pub fn with_task_concurrency(mut self, task_concurrency: HashMap<String, usize>) -> Self {
+        self.task_concurrency = task_concurrency;
+        self
+    }
+
+    /// Use the given control to pause, resume, or resize the scheduler while
+    /// the engine is executing. The control's concurrency takes precedence
+    /// over `concurrency`.
+    pub fn with_control(mut self, control: SchedulerControl) -> Self {
+        self.control = Some(control);
+        self
+    }
+}
+
+/// A handle for adjusting how the engine dispatches new tasks mid-run.
+/// Tasks that are already running are never affected.
+#[derive(Debug, Clone)]
+pub struct SchedulerControl {
+    paused: Arc<watch::Sender<bool>>,
+    semaphore: Arc<Semaphore>,
+    concurrency: Arc<Mutex<usize>>,
+}
+
+impl SchedulerControl {
+    pub fn new(concurrency: usize) -> Self {
+        let (paused, _) = watch::channel(false);
+        Self {
+            paused: Arc::new(paused),
+            semaphore: Arc::new(Semaphore::new(concurrency)),
+            concurrency: Arc::new(Mutex::new(concurrency)),
+        }
+    }
+
+    /// Stop dispatching new tasks until `resume` is called
+    pub fn pause(&self) {
+        self.paused.send_replace(true);
+    }
+
+    pub fn resume(&self) {
+        self.paused.send_replace(false);
+    }
+
+    pub fn is_paused(&self) -> bool {
+        *self.paused.borrow()
+    }
+
+    pub fn concurrency(&self) -> usize {
+        *self.concurrency.lock().expect("lock poisoned")
+    }
+
+    /// Changes the number of tasks that can run at once. Lowering the limit
+    /// takes effect as running tasks finish.
+    pub fn set_concurrency(&self, concurrency: usize) {
+        let concurrency = concurrency.max(1);
+        let mut current = self.concurrency.lock().expect("lock poisoned");
+        if concurrency > *current {
+            self.semaphore.add_permits(concurrency - *current);
+        } else if concurrency < *current {
+            let excess = (*current - concurrency) as u32;
+            let semaphore = self.semaphore.clone();
+            // Permits held by running tasks can only be removed once they're released
+            tokio::spawn(async move {
+                if let Ok(permits) = semaphore.acquire_many_owned(excess).await {
+                    permits.forget();
+                }
+            });
+        }
+        *current = concurrency;
+    }
+
+    async fn acquire(&self) -> OwnedSemaphorePermit {
+        let mut paused = self.paused.subscribe();
+        loop {
+            if paused.wait_for(|paused| !paused).await.is_err() {
+                debug!("Scheduler control dropped while paused");
+            }
+            let permit = self.semaphore.clone().acquire_owned().await.expect(
+                "Graph concurrency semaphore closed while tasks are still attempting to acquire \
+                 permits",
+            );
+            // We might have been paused while waiting for a permit
+            if !self.is_paused() {
+                return permit;
+            }
+        }
+    }
+
+    async fn wait_for_resume(&self) {
+        let mut paused = self.paused.subscribe();
+        if paused.wait_for(|paused| !paused).await.is_err() {
+            debug!("Scheduler control dropped while paused");
+        }
+    }
+}
+
+// A ready task waiting for a concurrency permit
+struct Waiter {
+    priority: i32,
+    // Tasks with the same priority get permits in the order they became ready
+    sequence: Reverse<usize>,
+    permit: oneshot::Sender<OwnedSemaphorePermit>,
+}
+
+impl Waiter {
+    fn key(&self) -> (i32, Reverse<usize>) {
+        (self.priority, self.sequence)
+    }
+}
+
+impl PartialEq for Waiter {
+    fn eq(&self, other: &Self) -> bool {
+        self.key() == other.key()
+    }
+}
+
+impl Eq for Waiter {}
+
+impl PartialOrd for Waiter {
+    fn partial_cmp(&self, other: &Self) -> Option<Ordering> {
+        Some(self.cmp(other))
+    }
+}
+
+impl Ord for Waiter {
+    fn cmp(&self, other: &Self) -> Ordering {
+        self.key().cmp(&other.key())
+    }
+}
+
+/// Hands out permits from `control` to the waiting task with the highest
+/// priority. Returns once every sender of `waiters` is dropped and no task is
+/// left waiting.
+async fn dispatch(control: SchedulerControl, mut waiters: mpsc::UnboundedReceiver<PermitRequest>) {
+    let mut queue = BinaryHeap::new();
+    let mut sequence = 0;
+    let mut enqueue = |queue: &mut BinaryHeap<Waiter>, (priority, permit): PermitRequest| {
+        queue.push(Waiter {
+            priority,
+            sequence: Reverse(sequence),
+            permit,
+        });
+        sequence += 1;
+    };
+    loop {
+        if queue.is_empty() {
+            match waiters.recv().await {
+                Some(waiter) => enqueue(&mut queue, waiter),
+                None => return,
+            }
+        }
+        let mut permit = control.acquire().await;
+        // Tasks that became ready while we waited for a permit get to compete for it
+        while let Ok(waiter) = waiters.try_recv() {
+            enqueue(&mut queue, waiter);
+        }
+        while let Some(waiter) = queue.pop() {
+            match waiter.permit.send(permit) {
+                Ok(()) => break,
+                // The task stopped waiting, so the permit goes to the next one
+                Err(returned) => permit = returned,
+            }
         }
     }
 }
@@ -52,6 +228,16 @@ impl From<mpsc::error::SendError<Message<VisitorData, VisitorResult>>> for Execu
 #[derive(Debug, Clone, Copy)]
 pub struct StopExecution;
 
+// A limit for a `package#task` id takes precedence over one for the task name
+fn task_sema<'a>(
+    task_semas: &'a HashMap<String, Arc<Semaphore>>,
+    task_id: &TaskId,
+) -> Option<&'a Arc<Semaphore>> {
+    task_semas
+        .get(&task_id.to_string())
+        .or_else(|| task_semas.get(task_id.task()))
+}
+
 impl Engine {
     /// Execute a task graph by sending task ids to the visitor
     /// while respecting concurrency limits.
@@ -72,17 +258,29 @@ impl Engine {
         let ExecutionOptions {
             parallel,
             concurrency,
+            task_concurrency,
+            control,
         } = options;
-        let sema = Arc::new(Semaphore::new(concurrency));
+        let control = control.unwrap_or_else(|| SchedulerControl::new(concurrency));
+        let task_semas: Arc<HashMap<String, Arc<Semaphore>>> = Arc::new(
+            task_concurrency
+                .into_iter()
+                .map(|(task, limit)| (task, Arc::new(Semaphore::new(limit))))
+                .collect(),
+        );
         let mut tasks: FuturesUnordered<tokio::task::JoinHandle<Result<(), ExecuteError>>> =
             FuturesUnordered::new();
+        let (waiters, waiting) = mpsc::unbounded_channel();
+        tokio::spawn(dispatch(control.clone(), waiting));
 
         let (walker, mut nodes) = Walker::new(&self.task_graph).walk();
         let walker = Arc::new(Mutex::new(walker));
 
         while let Some((node_id, done)) = nodes.recv().await {
             let visitor = visitor.clone();
-            let sema = sema.clone();
+            let control = control.clone();
+            let waiters = waiters.clone();
+            let task_semas = task_semas.clone();
             let walker = walker.clone();
             let this = self.clone();
 
@@ -102,13 +300,37 @@ impl Engine {
                     return Ok(());
                 };
 
-                // Acquire the semaphore unless parallel
-                let _permit = match parallel {
-                    false => Some(sema.acquire().await.expect(
-                        "Graph concurrency semaphore closed while tasks are still attempting to \
+                // Acquire the task specific permit first so tasks waiting on their own
+                // limit don't hold on to a global permit
+                let _task_permit = match task_sema(&task_semas, task_id) {
+                    Some(task_sema) if !parallel => Some(task_sema.acquire().await.expect(
+                        "Task concurrency semaphore closed while tasks are still attempting to \
                          acquire permits",
                     )),
-                    true => None,
+                    _ => None,
+                };
+
+                // Acquire the semaphore unless parallel, parallel runs are still paused
+                let _permit = match parallel {
+                    false => {
+                        let priority = this
+                            .task_definitions
+                            .get(task_id)
+                            .map_or(0, |definition| definition.priority);
+                        let (permit, receiver) = oneshot::channel();
+                        waiters
+                            .send((priority, permit))
+                            .expect("scheduler stopped while tasks are waiting for permits");
+                        Some(
+                            receiver
+                                .await
+                                .expect("scheduler stopped while tasks are waiting for permits"),
+                        )
+                    }
+                    true => {
+                        control.wait_for_resume().await;
+                        None
+                    }
                 };
 
                 let (message, result) = Message::new(task_id.clone());
@@ -149,3 +371,102 @@ impl<T, U> Message<T, U> {
         (Self { info, callback }, receiver)
     }
 }
+
+#[cfg(test)]
+mod test {
+    use std::time::Duration;
+
+    use tokio::time::timeout;
+
+    use super::*;
+
+    // Long enough for a permit that's available to be acquired
+    const WAIT: Duration = Duration::from_millis(100);
+
+    #[tokio::test]
+    async fn test_scheduler_control() {
+        let control = SchedulerControl::new(1);
+        let first = timeout(WAIT, control.acquire()).await.unwrap();
+
+        // Paused, so a free permit isn't handed out
+        control.pause();
+        drop(first);
+        assert!(timeout(WAIT, control.acquire()).await.is_err());
+
+        control.resume();
+        let first = timeout(WAIT, control.acquire()).await.unwrap();
+        assert!(timeout(WAIT, control.acquire()).await.is_err());
+
+        // Raising the limit makes room right away
+        control.set_concurrency(2);
+        assert_eq!(control.concurrency(), 2);
+        let second = timeout(WAIT, control.acquire()).await.unwrap();
+
+        // Lowering it takes effect as running tasks finish, so the first
+        // permit that's released is taken out of circulation
+        control.set_concurrency(1);
+        assert_eq!(control.concurrency(), 1);
+        tokio::task::yield_now().await;
+        drop(second);
+        assert!(timeout(WAIT, control.acquire()).await.is_err());
+        drop(first);
+        let _third = timeout(WAIT, control.acquire()).await.unwrap();
+
+        // The limit never drops below one
+        control.set_concurrency(0);
+        assert_eq!(control.concurrency(), 1);
+    }
+
+    #[test]
+    fn test_task_sema() {
+        let task_semas = HashMap::from([
+            ("build".to_string(), Arc::new(Semaphore::new(4))),
+            ("web#build".to_string(), Arc::new(Semaphore::new(1))),
+            ("docs#lint".to_string(), Arc::new(Semaphore::new(2))),
+        ]);
+        let limit = |task_id: &str| {
+            task_sema(&task_semas, &TaskId::try_from(task_id).unwrap())
+                .map(|sema| sema.available_permits())
+        };
+        assert_eq!(limit("web#build"), Some(1));
+        assert_eq!(limit("docs#build"), Some(4));
+        assert_eq!(limit("docs#lint"), Some(2));
+        assert_eq!(limit("web#lint"), None);
+    }
+
+    #[tokio::test]
+    async fn test_dispatch_by_priority() {
+        let control = SchedulerControl::new(1);
+        let (waiters, waiting) = mpsc::unbounded_channel();
+        let mut receivers = Vec::new();
+        for (name, priority) in [("lint", 0), ("build", 1), ("format", -1), ("test", 0)] {
+            let (permit, receiver) = oneshot::channel();
+            waiters.send((priority, permit)).unwrap();
+            receivers.push((name, receiver));
+        }
+        // Every task is already waiting when the first permit is handed out
+        drop(waiters);
+        let dispatcher = tokio::spawn(dispatch(control, waiting));
+
+        let mut order = Vec::new();
+        while !receivers.is_empty() {
+            let (index, permit) = loop {
+                let ready = receivers
+                    .iter_mut()
+                    .enumerate()
+                    .find_map(|(index, (_, receiver))| {
+                        receiver.try_recv().ok().map(|permit| (index, permit))
+                    });
+                match ready {
+                    Some(ready) => break ready,
+                    None => tokio::task::yield_now().await,
+                }
+            };
+            order.push(receivers.remove(index).0);
+            drop(permit);
+        }
+        dispatcher.await.unwrap();
+
+        assert_eq!(order, vec!["build", "lint", "test", "format"]);
+    }
+}
@@ -4,7 +4,8 @@ use itertools::Itertools;
 use petgraph::{visit::EdgeRef, Graph};
 use rand::{distributions::Uniform, prelude::Distribution, Rng, SeedableRng};
 
-use super::{Built, Engine, TaskNode};
+use super::{package_groups::PackageGroups, Built, Engine, TaskNode};
+use crate::cli::GraphMode;
 
 struct CapitalLetters;
 
@@ -23,8 +24,20 @@ fn generate_id<R: Rng>(rng: &mut R) -> String {
 }
 
 impl Engine<Built> {
-    pub fn mermaid_graph<W: io::Write>(&self, writer: W, is_single: bool) -> Result<(), io::Error> {
-        render_graph(writer, &self.task_graph, is_single)
+    pub fn mermaid_graph<W: io::Write>(
+        &self,
+        writer: W,
+        is_single: bool,
+        mode: GraphMode,
+    ) -> Result<(), io::Error> {
+        match mode {
+            GraphMode::Tasks => render_graph(writer, &self.task_graph, is_single),
+            GraphMode::Packages | GraphMode::Both => render_package_graph(
+                writer,
+                &PackageGroups::new(&self.task_graph),
+                mode == GraphMode::Both,
+            ),
+        }
     }
 }
 
@@ -80,3 +93,52 @@ fn render_graph<W: io::Write>(
     }
     Ok(())
 }
+
+// Dependencies between packages are drawn between their nodes, or between
+// their subgraphs when `clustered` is set
+fn render_package_graph<W: io::Write>(
+    mut writer: W,
+    groups: &PackageGroups,
+    clustered: bool,
+) -> Result<(), io::Error> {
+    let mut rng = rand::rngs::SmallRng::seed_from_u64(4u64);
+    let package_ids = groups
+        .tasks
+        .keys()
+        .map(|package| (package.as_str(), generate_id(&mut rng)))
+        .collect::<HashMap<_, _>>();
+
+    writeln!(writer, "graph TD")?;
+    for (package, tasks) in &groups.tasks {
+        let package_id = &package_ids[package.as_str()];
+        if !clustered {
+            writeln!(writer, "\t{package_id}(\"{package}\")")?;
+            continue;
+        }
+        writeln!(writer, "\tsubgraph {package_id} [\"{package}\"]")?;
+        let task_ids = tasks
+            .iter()
+            .map(|task| (task.as_str(), generate_id(&mut rng)))
+            .collect::<HashMap<_, _>>();
+        for task in tasks {
+            writeln!(writer, "\t\t{}(\"{task}\")", task_ids[task.as_str()])?;
+        }
+        for (source, target) in &groups.task_edges {
+            if let (Some(source_id), Some(target_id)) =
+                (task_ids.get(source.as_str()), task_ids.get(target.as_str()))
+            {
+                writeln!(writer, "\t\t{source_id} --> {target_id}")?;
+            }
+        }
+        writeln!(writer, "\tend")?;
+    }
+    for (source, target) in groups.package_edges.keys() {
+        writeln!(
+            writer,
+            "\t{} --> {}",
+            package_ids[source.as_str()],
+            package_ids[target.as_str()]
+        )?;
+    }
+    Ok(())
+}
@@ -3,6 +3,8 @@ mod execute;
 
 mod dot;
 mod mermaid;
+mod package_groups;
+mod shard;
 
 use std::{
     collections::{HashMap, HashSet},
@@ -10,7 +12,7 @@ use std::{
 };
 
 pub use builder::{EngineBuilder, Error as BuilderError};
-pub use execute::{ExecuteError, ExecutionOptions, Message, StopExecution};
+pub use execute::{ExecuteError, ExecutionOptions, Message, SchedulerControl, StopExecution};
 use miette::{Diagnostic, NamedSource, SourceSpan};
 use petgraph::Graph;
 use thiserror::Error;
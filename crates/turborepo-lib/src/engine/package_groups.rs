@@ -0,0 +1,98 @@
+use std::collections::{BTreeMap, BTreeSet};
+
+use petgraph::{visit::EdgeRef, Graph};
+
+use super::TaskNode;
+
+/// The task graph grouped by package. The graph of a large repository has
+/// too many edges to read, so the dependencies between the tasks of two
+/// packages are collapsed into a single edge between the packages.
+///
+/// The root node is left out, since every task without dependencies points
+/// to it.
+#[derive(Debug, Default, PartialEq)]
+pub(crate) struct PackageGroups {
+    // The tasks of each package
+    pub tasks: BTreeMap<String, BTreeSet<String>>,
+    // Dependencies between tasks of the same package
+    pub task_edges: BTreeSet<(String, String)>,
+    // Dependencies between packages, along with one of the task dependencies
+    // that each stands in for
+    pub package_edges: BTreeMap<(String, String), (String, String)>,
+}
+
+impl PackageGroups {
+    pub fn new(graph: &Graph<TaskNode, ()>) -> Self {
+        let mut groups = Self::default();
+        for node in graph.node_weights() {
+            if let TaskNode::Task(task_id) = node {
+                groups
+                    .tasks
+                    .entry(task_id.package().to_string())
+                    .or_default()
+                    .insert(task_id.to_string());
+            }
+        }
+
+        for edge in graph.edge_references() {
+            let (Some(TaskNode::Task(source)), Some(TaskNode::Task(target))) = (
+                graph.node_weight(edge.source()),
+                graph.node_weight(edge.target()),
+            ) else {
+                continue;
+            };
+            let task_edge = (source.to_string(), target.to_string());
+            if source.package() == target.package() {
+                groups.task_edges.insert(task_edge);
+            } else {
+                // Keep the first task dependency so the output is stable
+                let package_edge = (source.package().to_string(), target.package().to_string());
+                let representative = groups
+                    .package_edges
+                    .entry(package_edge)
+                    .or_insert_with(|| task_edge.clone());
+                if task_edge < *representative {
+                    *representative = task_edge;
+                }
+            }
+        }
+
+        groups
+    }
+}
+
+#[cfg(test)]
+mod test {
+    use pretty_assertions::assert_eq;
+
+    use super::*;
+    use crate::run::task_id::TaskId;
+
+    #[test]
+    fn test_collapses_package_edges() {
+        let mut graph = Graph::new();
+        let root = graph.add_node(TaskNode::Root);
+        let web_build = graph.add_node(TaskNode::Task(TaskId::new("web", "build")));
+        let web_test = graph.add_node(TaskNode::Task(TaskId::new("web", "test")));
+        let ui_build = graph.add_node(TaskNode::Task(TaskId::new("ui", "build")));
+        let ui_lint = graph.add_node(TaskNode::Task(TaskId::new("ui", "lint")));
+        graph.add_edge(web_test, web_build, ());
+        graph.add_edge(web_build, ui_build, ());
+        graph.add_edge(web_test, ui_lint, ());
+        graph.add_edge(ui_build, root, ());
+        graph.add_edge(ui_lint, root, ());
+
+        let groups = PackageGroups::new(&graph);
+
+        let pair = |a: &str, b: &str| (a.to_string(), b.to_string());
+        assert_eq!(groups.tasks.keys().collect::<Vec<_>>(), vec!["ui", "web"]);
+        assert_eq!(
+            groups.task_edges,
+            BTreeSet::from([pair("web#test", "web#build")])
+        );
+        assert_eq!(
+            groups.package_edges,
+            BTreeMap::from([(pair("web", "ui"), pair("web#build", "ui#build"))])
+        );
+    }
+}
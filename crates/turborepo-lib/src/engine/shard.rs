@@ -0,0 +1,159 @@
+use std::collections::{HashMap, HashSet};
+
+use petgraph::{visit::Dfs, Direction};
+
+use super::{Built, Engine, TaskNode};
+
+impl Engine<Built> {
+    /// Returns the part of the task graph that shard `index` (1-based) out of
+    /// `total` is responsible for. The tasks that no other task depends on are
+    /// sorted and dealt out to the shards in turn, and each shard keeps
+    /// everything its tasks depend on. Dependencies that are shared between
+    /// shards run in each of them, so shards should share a remote cache.
+    pub fn shard(&self, index: usize, total: usize) -> Engine<Built> {
+        let mut entrypoints = self
+            .task_graph
+            .node_indices()
+            .filter(|node| {
+                matches!(self.task_graph[*node], TaskNode::Task(_))
+                    && self
+                        .task_graph
+                        .neighbors_directed(*node, Direction::Incoming)
+                        .next()
+                        .is_none()
+            })
+            .collect::<Vec<_>>();
+        entrypoints.sort_by(|a, b| self.task_graph[*a].cmp(&self.task_graph[*b]));
+
+        let mut included = HashSet::from([self.root_index]);
+        for entrypoint in entrypoints.into_iter().skip(index - 1).step_by(total) {
+            let mut dfs = Dfs::new(&self.task_graph, entrypoint);
+            while let Some(node) = dfs.next(&self.task_graph) {
+                included.insert(node);
+            }
+        }
+
+        let task_graph = self.task_graph.filter_map(
+            |node, weight| included.contains(&node).then(|| weight.clone()),
+            |_, _| Some(()),
+        );
+        let mut root_index = None;
+        let mut task_lookup = HashMap::new();
+        for node in task_graph.node_indices() {
+            match &task_graph[node] {
+                TaskNode::Root => root_index = Some(node),
+                TaskNode::Task(task_id) => {
+                    task_lookup.insert(task_id.clone(), node);
+                }
+            }
+        }
+        let task_definitions = self
+            .task_definitions
+            .iter()
+            .filter(|(task_id, _)| task_lookup.contains_key(*task_id))
+            .map(|(task_id, definition)| (task_id.clone(), definition.clone()))
+            .collect();
+        let task_locations = self
+            .task_locations
+            .iter()
+            .filter(|(task_id, _)| task_lookup.contains_key(*task_id))
+            .map(|(task_id, location)| (task_id.clone(), location.clone()))
+            .collect();
+
+        Engine {
+            marker: std::marker::PhantomData,
+            task_graph,
+            root_index: root_index.expect("root node is always kept"),
+            task_lookup,
+            task_definitions,
+            task_locations,
+        }
+    }
+}
+
+#[cfg(test)]
+mod test {
+    use std::collections::BTreeSet;
+
+    use pretty_assertions::assert_eq;
+    use test_case::test_case;
+
+    use super::*;
+    use crate::{run::task_id::TaskId, task_graph::TaskDefinition};
+
+    // web#test and docs#test depend on their own builds, which both depend on
+    // ui#build. ui#lint doesn't depend on anything.
+    fn engine() -> Engine<Built> {
+        let mut engine = Engine::new();
+        for task in [
+            "web#test",
+            "web#build",
+            "docs#test",
+            "docs#build",
+            "ui#build",
+            "ui#lint",
+        ] {
+            let task_id = TaskId::try_from(task).unwrap().into_owned();
+            engine.get_index(&task_id);
+            engine.add_definition(task_id, TaskDefinition::default());
+        }
+        for (from, to) in [
+            ("web#test", "web#build"),
+            ("docs#test", "docs#build"),
+            ("web#build", "ui#build"),
+            ("docs#build", "ui#build"),
+        ] {
+            let from = engine.get_index(&TaskId::try_from(from).unwrap().into_owned());
+            let to = engine.get_index(&TaskId::try_from(to).unwrap().into_owned());
+            engine.task_graph.add_edge(from, to, ());
+        }
+        engine.connect_to_root(&TaskId::new("ui", "build"));
+        engine.connect_to_root(&TaskId::new("ui", "lint"));
+        engine.seal()
+    }
+
+    fn tasks(engine: &Engine) -> BTreeSet<String> {
+        engine
+            .tasks()
+            .filter_map(|node| match node {
+                TaskNode::Task(task_id) => Some(task_id.to_string()),
+                TaskNode::Root => None,
+            })
+            .collect()
+    }
+
+    #[test_case(1, 1, &["docs#build", "docs#test", "ui#build", "ui#lint", "web#build", "web#test"] ; "single shard")]
+    #[test_case(1, 2, &["docs#build", "docs#test", "ui#build", "web#build", "web#test"] ; "first of two")]
+    #[test_case(2, 2, &["ui#lint"] ; "second of two")]
+    #[test_case(1, 3, &["docs#build", "docs#test", "ui#build"] ; "first of three")]
+    #[test_case(3, 3, &["ui#build", "web#build", "web#test"] ; "third of three")]
+    #[test_case(2, 4, &["ui#lint"] ; "second of four")]
+    #[test_case(4, 4, &[] ; "more shards than tasks")]
+    fn test_shard(index: usize, total: usize, expected: &[&str]) {
+        let shard = engine().shard(index, total);
+        let expected = expected
+            .iter()
+            .map(|s| s.to_string())
+            .collect::<BTreeSet<_>>();
+        assert_eq!(tasks(&shard), expected);
+        for task_id in shard.task_lookup.keys() {
+            assert!(shard.task_definition(task_id).is_some());
+        }
+    }
+
+    #[test]
+    fn test_shard_keeps_dependencies() {
+        let shard = engine().shard(3, 3);
+        let web_build = TaskId::new("web", "build");
+        let dependencies = shard.dependencies(&web_build).unwrap();
+        assert_eq!(
+            dependencies,
+            HashSet::from([&TaskNode::Task(TaskId::new("ui", "build"))])
+        );
+        let ui_build = TaskId::new("ui", "build");
+        assert_eq!(
+            shard.dependencies(&ui_build).unwrap(),
+            HashSet::from([&TaskNode::Root])
+        );
+    }
+}
@@ -17,6 +17,7 @@ mod cli;
 mod commands;
 mod config;
 mod daemon;
+pub mod embed;
 mod engine;
 
 mod framework;
@@ -41,6 +42,7 @@ pub use crate::{
     cli::Args,
     daemon::{DaemonClient, DaemonConnector, Paths as DaemonPaths},
     run::package_discovery::DaemonPackageDiscovery,
+    shim::repo_root,
 };
 
 pub fn get_version() -> &'static str {
@@ -56,6 +58,16 @@ pub fn main() -> Result<i32, shim::Error> {
     shim::run()
 }
 
+/// Whether turbo was invoked with the global `--json` flag, in which case
+/// stdout is reserved for a single JSON document and errors must go to
+/// stderr. Arguments after `--` belong to the tasks and are ignored.
+pub fn is_json_output() -> bool {
+    std::env::args()
+        .skip(1)
+        .take_while(|arg| arg != "--")
+        .any(|arg| arg == "--json")
+}
+
 #[cfg(all(feature = "native-tls", feature = "rustls-tls"))]
 compile_error!("You can't enable both the `native-tls` and `rustls-tls` feature.");
 
@@ -1,12 +1,16 @@
-use std::backtrace;
+use std::{backtrace, collections::HashMap};
 
 use thiserror::Error;
 use turbopath::AnchoredSystemPathBuf;
-use turborepo_cache::CacheOpts;
+use turborepo_cache::{CacheOpts, RestoreMode};
 
 use crate::{
-    cli::{Command, DryRunMode, EnvMode, LogOrder, LogPrefix, OutputLogsMode, RunArgs},
-    run::task_id::TaskId,
+    cli::{
+        AffectedGranularity, CacheRestoreMode, Command, ConcurrentRuns, ContinueMode, DryRunMode,
+        EnvMode, ForceMode, GraphMode, LogOrder, LogPrefix, LogTimestamps, OutputLogsMode,
+        OutputSymlinks, RunArgs, Shard, UIMode, MERMAID_STDOUT_GRAPH,
+    },
+    run::task_id::{TaskId, TaskName, TASK_DELIMITER},
     Args,
 };
 
@@ -26,6 +30,21 @@ pub enum Error {
          or equal to 1: {1}"
     )]
     ConcurrencyOutOfBounds(#[backtrace] backtrace::Backtrace, String),
+    #[error(
+        "invalid value for --concurrency CLI flag. Only one global limit may be given alongside \
+         per-task limits: {0}"
+    )]
+    MultipleGlobalConcurrency(String),
+    #[error(
+        "invalid value for --concurrency CLI flag. Per-task limits are given as `<task>=<limit>` \
+         or `<package>#<task>=<limit>`: {0}"
+    )]
+    InvalidTaskConcurrency(String),
+    #[error(
+        "turbo prefetch downloads artifacts into the local cache and can't be used with \
+         --remote-only"
+    )]
+    PrefetchWithoutLocalCache,
     #[error(transparent)]
     Path(#[from] turbopath::PathError),
 }
@@ -55,7 +74,9 @@ impl Opts {
             cmd.push_str(" --parallel");
         }
 
-        if self.run_opts.continue_on_error {
+        if self.run_opts.skip_dependents_of_failures {
+            cmd.push_str(" --continue=dependencies-successful");
+        } else if self.run_opts.continue_on_error {
             cmd.push_str(" --continue");
         }
 
@@ -63,6 +84,7 @@ impl Opts {
             match dry {
                 DryRunMode::Json => cmd.push_str(" --dry=json"),
                 DryRunMode::Text => cmd.push_str(" --dry"),
+                DryRunMode::Table => cmd.push_str(" --dry=table"),
             }
         }
 
@@ -83,11 +105,29 @@ impl<'a> TryFrom<&'a Args> for Opts {
     type Error = self::Error;
 
     fn try_from(args: &'a Args) -> Result<Self, Self::Error> {
-        let Some(Command::Run(run_args)) = &args.command else {
-            return Err(Error::ExpectedRun);
+        let (run_args, prefetch) = match &args.command {
+            Some(Command::Run(run_args)) => (run_args, false),
+            Some(Command::Prefetch(run_args)) => (run_args, true),
+            _ => return Err(Error::ExpectedRun),
         };
-        let run_opts = RunOpts::try_from(run_args.as_ref())?;
-        let cache_opts = CacheOpts::from(run_args.as_ref());
+        let mut run_opts = RunOpts::try_from(run_args.as_ref())?;
+        if prefetch {
+            // Tasks are hashed but never executed
+            run_opts.prefetch = true;
+            run_opts.hash_only = true;
+        }
+        if args.json {
+            run_opts.json = true;
+            // A dry run can only be written as JSON
+            run_opts.dry_run = run_opts.dry_run.map(|_| DryRunMode::Json);
+        }
+        let cache_opts = CacheOpts {
+            show_all_warnings: u8::from(args.verbosity) > 0,
+            ..CacheOpts::from(run_args.as_ref())
+        };
+        if prefetch && cache_opts.skip_filesystem {
+            return Err(Error::PrefetchWithoutLocalCache);
+        }
         let scope_opts = ScopeOpts::try_from(run_args.as_ref())?;
         let runcache_opts = RunCacheOpts::from(run_args.as_ref());
 
@@ -103,16 +143,35 @@ impl<'a> TryFrom<&'a Args> for Opts {
 #[derive(Debug, Default)]
 pub struct RunCacheOpts {
     pub(crate) skip_reads: bool,
+    // Tasks that should skip cache reads even if `skip_reads` is false
+    pub(crate) force_tasks: Vec<TaskName<'static>>,
     pub(crate) skip_writes: bool,
+    pub(crate) cache_failures: bool,
+    pub(crate) restore_declared_outputs: bool,
     pub(crate) task_output_mode_override: Option<OutputLogsMode>,
+    // Reported once the run finishes, since the cache only knows the hashes
+    // of the artifacts it skips
+    pub(crate) max_artifact_size: Option<u64>,
 }
 
 impl<'a> From<&'a RunArgs> for RunCacheOpts {
     fn from(args: &'a RunArgs) -> Self {
+        let (skip_reads, force_tasks) = match args.force.clone().flatten() {
+            Some(ForceMode::All(force)) => (force, Vec::new()),
+            Some(ForceMode::Tasks(tasks)) => {
+                (false, tasks.into_iter().map(TaskName::from).collect())
+            }
+            None => (false, Vec::new()),
+        };
         RunCacheOpts {
-            skip_reads: args.force.flatten().is_some_and(|f| f),
+            // Outputs that are already on disk would otherwise be reused
+            skip_reads: skip_reads || args.cache_write_only,
+            force_tasks,
             skip_writes: args.no_cache,
+            cache_failures: args.cache_failures,
+            restore_declared_outputs: args.restore_declared_outputs,
             task_output_mode_override: args.output_logs,
+            max_artifact_size: args.remote_cache_max_artifact_size,
         }
     }
 }
@@ -121,21 +180,42 @@ impl<'a> From<&'a RunArgs> for RunCacheOpts {
 pub struct RunOpts {
     pub(crate) tasks: Vec<String>,
     pub(crate) concurrency: u32,
+    // Per-task name concurrency limits, applied in addition to `concurrency`
+    pub(crate) task_concurrency: HashMap<String, u32>,
+    // Read commands from stdin to pause, resume or resize the scheduler
+    pub(crate) scheduler_control: bool,
     pub(crate) parallel: bool,
     pub(crate) env_mode: EnvMode,
     // Whether or not to infer the framework for each workspace.
     pub(crate) framework_inference: bool,
     pub profile: Option<String>,
     pub(crate) continue_on_error: bool,
+    // Set by `--continue=dependencies-successful`, tasks that depend on a
+    // failed task are skipped rather than run
+    pub(crate) skip_dependents_of_failures: bool,
     pub(crate) pass_through_args: Vec<String>,
+    pub(crate) affected_granularity: AffectedGranularity,
+    pub(crate) concurrent_runs: ConcurrentRuns,
     pub(crate) only: bool,
+    pub(crate) shard: Option<Shard>,
     pub(crate) dry_run: Option<DryRunMode>,
+    pub(crate) hash_only: bool,
+    // Download the artifacts of the hashed tasks into the local cache instead
+    // of printing their hashes
+    pub(crate) prefetch: bool,
+    // Print the run summary as JSON on stdout, with task logs on stderr
+    pub(crate) json: bool,
     pub graph: Option<GraphOpts>,
+    pub(crate) graph_mode: GraphMode,
     pub(crate) daemon: Option<bool>,
     pub(crate) single_package: bool,
     pub log_prefix: ResolvedLogPrefix,
     pub log_order: ResolvedLogOrder,
+    pub(crate) log_timestamps: Option<LogTimestamps>,
+    pub(crate) ui_mode: UIMode,
     pub summarize: Option<Option<bool>>,
+    pub(crate) strict_engines: bool,
+    pub(crate) warn_undeclared_outputs: bool,
     pub(crate) experimental_space_id: Option<String>,
     pub is_github_actions: bool,
 }
@@ -158,6 +238,7 @@ impl RunOpts {
 #[derive(Debug)]
 pub enum GraphOpts {
     Stdout,
+    MermaidStdout,
     File(String),
 }
 
@@ -179,15 +260,17 @@ impl<'a> TryFrom<&'a RunArgs> for RunOpts {
     type Error = self::Error;
 
     fn try_from(args: &'a RunArgs) -> Result<Self, Self::Error> {
-        let concurrency = args
+        let (concurrency, task_concurrency) = args
             .concurrency
             .as_deref()
-            .map(parse_concurrency)
+            .map(parse_concurrency_overrides)
             .transpose()?
-            .unwrap_or(DEFAULT_CONCURRENCY);
+            .unwrap_or_default();
+        let concurrency = concurrency.unwrap_or(DEFAULT_CONCURRENCY);
 
         let graph = args.graph.as_deref().map(|file| match file {
             "" => GraphOpts::Stdout,
+            MERMAID_STDOUT_GRAPH => GraphOpts::MermaidStdout,
             f => GraphOpts::File(f.to_string()),
         });
 
@@ -212,25 +295,74 @@ impl<'a> TryFrom<&'a RunArgs> for RunOpts {
             tasks: args.tasks.clone(),
             log_prefix,
             log_order,
+            log_timestamps: args.log_timestamps,
+            ui_mode: args.ui,
             summarize: args.summarize,
+            strict_engines: args.strict_engines,
+            warn_undeclared_outputs: args.warn_undeclared_outputs,
             experimental_space_id: args.experimental_space_id.clone(),
             framework_inference: args.framework_inference,
             env_mode: args.env_mode,
             concurrency,
+            task_concurrency,
+            scheduler_control: args.scheduler_control,
             parallel: args.parallel,
             profile: args.profile.clone(),
-            continue_on_error: args.continue_execution,
+            continue_on_error: args.continue_execution.is_some(),
+            skip_dependents_of_failures: args.continue_execution
+                == Some(ContinueMode::DependenciesSuccessful),
             pass_through_args: args.pass_through_args.clone(),
+            affected_granularity: args.affected_granularity,
+            concurrent_runs: args.concurrent_runs,
             only: args.only,
+            shard: args.shard,
             daemon: args.daemon(),
             single_package: args.single_package,
             graph,
+            graph_mode: args.graph_mode,
             dry_run: args.dry_run,
+            hash_only: args.hash_only,
+            prefetch: false,
+            json: false,
             is_github_actions,
         })
     }
 }
 
+// Parses a comma separated list of a global limit and per-task limits e.g.
+// `10,build=4,test=16`
+fn parse_concurrency_overrides(
+    concurrency_raw: &str,
+) -> Result<(Option<u32>, HashMap<String, u32>), self::Error> {
+    let mut concurrency = None;
+    let mut task_concurrency = HashMap::new();
+    for entry in concurrency_raw.split(',') {
+        match entry.split_once('=') {
+            Some((task, limit)) => {
+                let valid = match task.split_once(TASK_DELIMITER) {
+                    Some((package, task)) => {
+                        !package.is_empty() && !task.is_empty() && !task.contains(TASK_DELIMITER)
+                    }
+                    None => !task.is_empty(),
+                };
+                if !valid {
+                    return Err(Error::InvalidTaskConcurrency(entry.to_string()));
+                }
+                task_concurrency.insert(task.to_string(), parse_concurrency(limit)?);
+            }
+            None if concurrency.is_none() => {
+                concurrency = Some(parse_concurrency(entry)?);
+            }
+            None => {
+                return Err(Error::MultipleGlobalConcurrency(
+                    concurrency_raw.to_string(),
+                ))
+            }
+        }
+    }
+    Ok((concurrency, task_concurrency))
+}
+
 fn parse_concurrency(concurrency_raw: &str) -> Result<u32, self::Error> {
     if let Some(percent) = concurrency_raw.strip_suffix('%') {
         let percent = percent.parse::<f64>()?;
@@ -278,6 +410,16 @@ pub struct LegacyFilter {
 }
 
 impl LegacyFilter {
+    /// Whether `--since` was passed without a ref, meaning the repository's
+    /// default branch should be compared against
+    pub fn since_default_branch(&self) -> bool {
+        self.since.as_deref() == Some("")
+    }
+
+    pub fn set_since(&mut self, since: String) {
+        self.since = Some(since);
+    }
+
     pub fn as_filter_pattern(&self) -> Vec<String> {
         let prefix = if self.skip_dependents { "" } else { "..." };
         let suffix = if self.include_dependencies { "..." } else { "" };
@@ -322,12 +464,13 @@ impl<'a> TryFrom<&'a RunArgs> for ScopeOpts {
         let pkg_inference_root = args
             .pkg_inference_root
             .as_ref()
+            .filter(|_| !args.no_package_inference)
             .map(AnchoredSystemPathBuf::from_raw)
             .transpose()?;
 
         let legacy_filter = LegacyFilter {
             include_dependencies: args.include_dependencies,
-            skip_dependents: args.no_deps,
+            skip_dependents: args.no_deps || args.include_dependents == Some(false),
             entrypoints: args.scope.clone(),
             since: args.since.clone(),
         };
@@ -335,7 +478,12 @@ impl<'a> TryFrom<&'a RunArgs> for ScopeOpts {
             global_deps: args.global_deps.clone(),
             pkg_inference_root,
             legacy_filter,
-            filter_patterns: args.filter.clone(),
+            filter_patterns: args
+                .filter
+                .iter()
+                .cloned()
+                .chain(args.exclude.iter().map(|exclude| format!("!{exclude}")))
+                .collect(),
             ignore_patterns: args.ignore.clone(),
         })
     }
@@ -346,8 +494,27 @@ impl<'a> From<&'a RunArgs> for CacheOpts {
         CacheOpts {
             override_dir: run_args.cache_dir.clone(),
             skip_filesystem: run_args.remote_only,
+            skip_remote: run_args.no_remote,
             remote_cache_read_only: run_args.remote_cache_read_only,
+            write_only: run_args.cache_write_only,
             workers: run_args.cache_workers,
+            queue_size: run_args.cache_queue_size,
+            drain_timeout: run_args.cache_drain_timeout,
+            dereference_symlinks: run_args.output_symlinks == OutputSymlinks::Dereference,
+            restore_mode: match run_args.cache_restore_mode {
+                CacheRestoreMode::Hardlink => RestoreMode::Hardlink,
+                CacheRestoreMode::Copy => RestoreMode::Copy,
+                CacheRestoreMode::Reflink => RestoreMode::Reflink,
+            },
+            namespace: run_args.cache_namespace.clone(),
+            max_age: run_args.cache_max_age,
+            remote_read_concurrency: run_args
+                .remote_cache_read_concurrency
+                .or(run_args.remote_cache_concurrency),
+            remote_write_concurrency: run_args
+                .remote_cache_write_concurrency
+                .or(run_args.remote_cache_concurrency),
+            max_artifact_size: run_args.remote_cache_max_artifact_size,
             ..CacheOpts::default()
         }
     }
@@ -373,13 +540,18 @@ impl ScopeOpts {
 
 #[cfg(test)]
 mod test {
+    use std::collections::HashMap;
+
     use test_case::test_case;
     use turborepo_cache::CacheOpts;
 
-    use super::{LegacyFilter, RunOpts};
+    use super::{parse_concurrency_overrides, LegacyFilter, RunOpts};
     use crate::{
-        cli::DryRunMode,
+        cli::{
+            AffectedGranularity, ConcurrentRuns, DryRunMode, ForceMode, GraphMode, RunArgs, UIMode,
+        },
         opts::{Opts, RunCacheOpts, ScopeOpts},
+        run::task_id::TaskName,
     };
 
     #[test_case(LegacyFilter {
@@ -414,6 +586,7 @@ mod test {
         pass_through_args: Vec<String>,
         parallel: bool,
         continue_on_error: bool,
+        skip_dependents_of_failures: bool,
         dry_run: Option<DryRunMode>,
         legacy_filter: Option<LegacyFilter>,
     }
@@ -494,6 +667,15 @@ mod test {
         },
         "turbo run build --filter=my-app --parallel --continue"
     )]
+    #[test_case    (
+        TestCaseOpts {
+            tasks: vec!["build".to_string()],
+            continue_on_error: true,
+            skip_dependents_of_failures: true,
+            ..Default::default()
+        },
+        "turbo run build --continue=dependencies-successful"
+    )]
     #[test_case    (
         TestCaseOpts {
             filter_patterns: vec!["my-app".to_string()],
@@ -512,24 +694,47 @@ mod test {
         },
         "turbo run build --filter=my-app --dry=json"
     )]
+    #[test_case    (
+        TestCaseOpts {
+            filter_patterns: vec!["my-app".to_string()],
+            tasks: vec!["build".to_string()],
+            dry_run: Some(DryRunMode::Table),
+            ..Default::default()
+        },
+        "turbo run build --filter=my-app --dry=table"
+    )]
     fn test_synthesize_command(opts_input: TestCaseOpts, expected: &str) {
         let run_opts = RunOpts {
             tasks: opts_input.tasks,
             concurrency: 10,
+            task_concurrency: HashMap::new(),
+            scheduler_control: false,
             parallel: opts_input.parallel,
             env_mode: crate::cli::EnvMode::Loose,
             framework_inference: true,
             profile: None,
             continue_on_error: opts_input.continue_on_error,
+            skip_dependents_of_failures: opts_input.skip_dependents_of_failures,
             pass_through_args: opts_input.pass_through_args,
+            affected_granularity: AffectedGranularity::Package,
+            concurrent_runs: ConcurrentRuns::Share,
             only: opts_input.only,
+            shard: None,
             dry_run: opts_input.dry_run,
+            hash_only: false,
+            prefetch: false,
+            json: false,
             graph: None,
+            graph_mode: GraphMode::Tasks,
             daemon: None,
             single_package: false,
             log_prefix: crate::opts::ResolvedLogPrefix::Task,
             log_order: crate::opts::ResolvedLogOrder::Stream,
+            log_timestamps: None,
+            ui_mode: UIMode::Stream,
             summarize: None,
+            strict_engines: false,
+            warn_undeclared_outputs: false,
             experimental_space_id: None,
             is_github_actions: false,
         };
@@ -552,4 +757,119 @@ mod test {
         let synthesized = opts.synthesize_command();
         assert_eq!(synthesized, expected);
     }
+
+    #[test_case(None, false, &[] ; "no force")]
+    #[test_case(Some(ForceMode::All(true)), true, &[] ; "force all")]
+    #[test_case(Some(ForceMode::All(false)), false, &[] ; "force disabled")]
+    #[test_case(
+        Some(ForceMode::Tasks(vec!["web#build".into(), "test".into()])),
+        false,
+        &["web#build", "test"]
+        ; "force tasks"
+    )]
+    fn test_force_opts(force: Option<ForceMode>, skip_reads: bool, force_tasks: &[&str]) {
+        let args = RunArgs {
+            force: Some(force),
+            ..RunArgs::default()
+        };
+        let runcache_opts = RunCacheOpts::from(&args);
+        assert_eq!(runcache_opts.skip_reads, skip_reads);
+        assert_eq!(
+            runcache_opts.force_tasks,
+            force_tasks
+                .iter()
+                .map(|task| TaskName::from(task.to_string()))
+                .collect::<Vec<_>>()
+        );
+    }
+
+    #[test]
+    fn test_exclude_filter_patterns() {
+        let args = RunArgs {
+            filter: vec!["./apps/*".to_string()],
+            exclude: vec!["@acme/legacy-*".to_string(), "docs".to_string()],
+            ..RunArgs::default()
+        };
+        let scope_opts = ScopeOpts::try_from(&args).unwrap();
+        assert_eq!(
+            scope_opts.filter_patterns,
+            vec!["./apps/*", "!@acme/legacy-*", "!docs"]
+        );
+    }
+
+    #[test_case(false, None, false, &["...web"] ; "defaults")]
+    #[test_case(true, None, false, &["...web..."] ; "include dependencies")]
+    #[test_case(false, Some(false), false, &["web"] ; "exclude dependents")]
+    #[test_case(false, None, true, &["web"] ; "no deps")]
+    #[test_case(true, Some(true), false, &["...web..."] ; "include both")]
+    fn test_legacy_scope_patterns(
+        include_dependencies: bool,
+        include_dependents: Option<bool>,
+        no_deps: bool,
+        expected: &[&str],
+    ) {
+        let args = RunArgs {
+            scope: vec!["web".to_string()],
+            include_dependencies,
+            include_dependents,
+            no_deps,
+            ..RunArgs::default()
+        };
+        let scope_opts = ScopeOpts::try_from(&args).unwrap();
+        assert_eq!(scope_opts.legacy_filter.as_filter_pattern(), expected);
+    }
+
+    #[test_case(None, None, None, None, None ; "unlimited")]
+    #[test_case(Some(4), None, None, Some(4), Some(4) ; "shared limit")]
+    #[test_case(Some(4), None, Some(1), Some(4), Some(1) ; "write override")]
+    #[test_case(None, Some(16), None, Some(16), None ; "read only")]
+    fn test_remote_cache_concurrency(
+        concurrency: Option<u32>,
+        read_concurrency: Option<u32>,
+        write_concurrency: Option<u32>,
+        expected_read: Option<u32>,
+        expected_write: Option<u32>,
+    ) {
+        let args = RunArgs {
+            remote_cache_concurrency: concurrency,
+            remote_cache_read_concurrency: read_concurrency,
+            remote_cache_write_concurrency: write_concurrency,
+            ..RunArgs::default()
+        };
+        let cache_opts = CacheOpts::from(&args);
+        assert_eq!(cache_opts.remote_read_concurrency, expected_read);
+        assert_eq!(cache_opts.remote_write_concurrency, expected_write);
+    }
+
+    #[test_case("20", Some(20), &[] ; "global only")]
+    #[test_case("build=4,test=16", None, &[("build", 4), ("test", 16)] ; "tasks only")]
+    #[test_case("10,build=4", Some(10), &[("build", 4)] ; "global and task")]
+    #[test_case("build=4,web#build=1", None, &[("build", 4), ("web#build", 1)] ; "package task")]
+    #[test_case("//#lint=1", None, &[("//#lint", 1)] ; "root task")]
+    fn test_parse_concurrency_overrides(
+        raw: &str,
+        concurrency: Option<u32>,
+        task_concurrency: &[(&str, u32)],
+    ) {
+        let (actual_concurrency, actual_task_concurrency) =
+            parse_concurrency_overrides(raw).unwrap();
+        assert_eq!(actual_concurrency, concurrency);
+        assert_eq!(
+            actual_task_concurrency,
+            task_concurrency
+                .iter()
+                .map(|(task, limit)| (task.to_string(), *limit))
+                .collect::<HashMap<_, _>>()
+        );
+    }
+
+    #[test_case("10,20" ; "multiple global")]
+    #[test_case("build=0" ; "task out of bounds")]
+    #[test_case("=4" ; "missing task")]
+    #[test_case("#build=4" ; "missing package")]
+    #[test_case("web#=4" ; "missing package task")]
+    #[test_case("web#build#test=4" ; "extra delimiter")]
+    fn test_parse_concurrency_overrides_error(raw: &str) {
+        assert!(parse_concurrency_overrides(raw).is_err());
+    }
 }
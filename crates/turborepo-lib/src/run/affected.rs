@@ -0,0 +1,229 @@
+use std::{
+    collections::{HashMap, HashSet},
+    str::FromStr,
+};
+
+use thiserror::Error;
+use turbopath::{AbsoluteSystemPath, AnchoredSystemPath, AnchoredSystemPathBuf};
+use turborepo_repository::package_graph::{PackageGraph, PackageName};
+use turborepo_scm::SCM;
+
+use crate::run::scope::target_selector::TargetSelector;
+
+#[derive(Debug, Error)]
+pub enum Error {
+    #[error(
+        "--affected-granularity=file requires a --filter with a git range, e.g. --filter=...[main]"
+    )]
+    MissingRange,
+    #[error("failed to find changed files: {0}")]
+    Scm(#[from] turborepo_scm::Error),
+}
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum TestRunner {
+    Jest,
+    Vitest,
+}
+
+impl TestRunner {
+    // We only support scripts that invoke the runner directly since otherwise
+    // we can't know where the arguments will end up
+    fn detect(script: &str) -> Option<Self> {
+        let mut words = script.split_whitespace();
+        match words.next()? {
+            "jest" => Some(Self::Jest),
+            // The files are passed to `vitest related`, which can't be used if the
+            // script already picks a command like `vitest run` or filters the tests
+            "vitest" if words.all(|word| word.starts_with('-')) => Some(Self::Vitest),
+            _ => None,
+        }
+    }
+
+    fn args(&self, files: &[String]) -> Vec<String> {
+        // None of the changed files might be covered by a test
+        let mut args = match self {
+            // Jest runs every test that depends on one of the files
+            Self::Jest => vec!["--passWithNoTests", "--findRelatedTests"],
+            // So does `vitest related`, which would otherwise keep watching
+            Self::Vitest => vec!["related", "--run", "--passWithNoTests"],
+        }
+        .into_iter()
+        .map(String::from)
+        .collect::<Vec<_>>();
+        args.extend(files.iter().cloned());
+        args
+    }
+}
+
+/// The files that changed in the git range of the run's filters, grouped by
+/// the package that contains them.
+#[derive(Debug, Default, PartialEq)]
+pub struct AffectedFiles {
+    // Paths are relative to the package directory and sorted so that task
+    // hashes are stable
+    by_package: HashMap<PackageName, Vec<String>>,
+}
+
+impl AffectedFiles {
+    pub fn new(
+        repo_root: &AbsoluteSystemPath,
+        scm: &SCM,
+        pkg_graph: &PackageGraph,
+        filters: &[String],
+    ) -> Result<Self, Error> {
+        let selectors = filters
+            .iter()
+            .filter_map(|filter| TargetSelector::from_str(filter).ok())
+            .filter(|selector| !selector.exclude && !selector.from_ref.is_empty())
+            .collect::<Vec<_>>();
+        if selectors.is_empty() {
+            return Err(Error::MissingRange);
+        }
+
+        let mut changed_files = HashSet::new();
+        for selector in &selectors {
+            changed_files.extend(scm.changed_files(
+                repo_root,
+                Some(&selector.from_ref),
+                selector.to_ref(),
+            )?);
+        }
+        // Deleted files can't be passed along to a test runner
+        changed_files.retain(|file| repo_root.resolve(file).exists());
+
+        Ok(Self::from_changed_files(
+            pkg_graph
+                .packages()
+                .map(|(name, info)| (name, info.package_path())),
+            changed_files,
+        ))
+    }
+
+    fn from_changed_files<'a>(
+        packages: impl Iterator<Item = (&'a PackageName, &'a AnchoredSystemPath)>,
+        changed_files: impl IntoIterator<Item = AnchoredSystemPathBuf>,
+    ) -> Self {
+        let packages = packages
+            .map(|(name, path)| (name, path.to_unix()))
+            .collect::<Vec<_>>();
+
+        let mut by_package: HashMap<PackageName, Vec<String>> = HashMap::new();
+        for file in changed_files {
+            let file = file.to_unix();
+            // A file belongs to the most deeply nested package that contains it
+            let owner = packages
+                .iter()
+                .filter_map(|(name, path)| {
+                    let relative = file.strip_prefix(path).ok()?;
+                    Some((path.as_str().len(), *name, relative))
+                })
+                .max_by_key(|(depth, ..)| *depth);
+            if let Some((_, name, relative)) = owner {
+                by_package
+                    .entry(name.clone())
+                    .or_default()
+                    .push(relative.into_inner());
+            }
+        }
+        for files in by_package.values_mut() {
+            files.sort();
+        }
+
+        Self { by_package }
+    }
+
+    /// Returns the arguments to narrow a task's script down to the changed
+    /// files. Tasks whose packages only changed through their dependencies
+    /// still run in full, as do scripts that aren't a supported test runner.
+    pub fn args_for_task(&self, package: &PackageName, script: &str) -> Option<Vec<String>> {
+        let runner = TestRunner::detect(script)?;
+        let files = self.by_package.get(package)?;
+        Some(runner.args(files))
+    }
+}
+
+#[cfg(test)]
+mod test {
+    use test_case::test_case;
+
+    use super::*;
+
+    #[test_case("jest", Some(TestRunner::Jest) ; "jest")]
+    #[test_case("vitest", Some(TestRunner::Vitest) ; "vitest")]
+    #[test_case("vitest --coverage", Some(TestRunner::Vitest) ; "vitest with options")]
+    #[test_case("vitest run --coverage", None ; "vitest with command")]
+    #[test_case("vitest src/utils", None ; "vitest with filter")]
+    #[test_case("cross-env CI=1 jest", None ; "wrapped runner")]
+    #[test_case("mocha", None ; "unsupported runner")]
+    fn test_detect_runner(script: &str, expected: Option<TestRunner>) {
+        assert_eq!(TestRunner::detect(script), expected);
+    }
+
+    #[test]
+    fn test_args_for_task() {
+        let web = PackageName::from("web");
+        let ui = PackageName::from("ui");
+        let docs = PackageName::from("docs");
+        let web_path = AnchoredSystemPathBuf::from_raw("apps/web").unwrap();
+        let ui_path = AnchoredSystemPathBuf::from_raw("apps/web/ui").unwrap();
+        let docs_path = AnchoredSystemPathBuf::from_raw("apps/docs").unwrap();
+        let packages = [(&web, &*web_path), (&ui, &*ui_path), (&docs, &*docs_path)];
+
+        let affected = AffectedFiles::from_changed_files(
+            packages.into_iter(),
+            [
+                "apps/web/src/b.ts",
+                "apps/web/src/a.ts",
+                "apps/web/ui/button.ts",
+            ]
+            .into_iter()
+            .map(|file| AnchoredSystemPathBuf::from_raw(file).unwrap()),
+        );
+
+        assert_eq!(
+            affected.args_for_task(&web, "jest"),
+            Some(vec![
+                "--passWithNoTests".to_string(),
+                "--findRelatedTests".to_string(),
+                "src/a.ts".to_string(),
+                "src/b.ts".to_string(),
+            ])
+        );
+        assert_eq!(
+            affected.args_for_task(&ui, "vitest --coverage"),
+            Some(vec![
+                "related".to_string(),
+                "--run".to_string(),
+                "--passWithNoTests".to_string(),
+                "button.ts".to_string()
+            ])
+        );
+        assert_eq!(affected.args_for_task(&ui, "vitest run"), None);
+        assert_eq!(affected.args_for_task(&web, "mocha"), None);
+        assert_eq!(affected.args_for_task(&docs, "jest"), None);
+    }
+
+    #[test]
+    fn test_vitest_source_only_change() {
+        let web = PackageName::from("web");
+        let web_path = AnchoredSystemPathBuf::from_raw("apps/web").unwrap();
+
+        // No test file changed, so the tests to run have to be found through
+        // the imports of the changed source file rather than by its path
+        let affected = AffectedFiles::from_changed_files(
+            [(&web, &*web_path)].into_iter(),
+            [AnchoredSystemPathBuf::from_raw("apps/web/src/math.ts").unwrap()],
+        );
+
+        assert_eq!(
+            affected.args_for_task(&web, "vitest"),
+            Some(vec![
+                "related".to_string(),
+                "--run".to_string(),
+                "--passWithNoTests".to_string(),
+                "src/math.ts".to_string(),
+            ])
+        );
+    }
+}
@@ -1,24 +1,35 @@
-use std::{io::Write, sync::Arc, time::Duration};
+use std::{
+    collections::{HashMap, HashSet},
+    io::Write,
+    path::{Path, MAIN_SEPARATOR},
+    str::FromStr,
+    sync::Arc,
+    time::{Duration, SystemTime},
+};
 
 use console::StyledObject;
 use tracing::debug;
 use turbopath::{
     AbsoluteSystemPath, AbsoluteSystemPathBuf, AnchoredSystemPath, AnchoredSystemPathBuf,
 };
-use turborepo_cache::{AsyncCache, CacheError, CacheHitMetadata, CacheSource};
+use turborepo_cache::{
+    AsyncCache, CacheDownloadMetadata, CacheError, CacheHitMetadata, CacheSource, CacheState,
+    CacheUploadMetadata, NetworkUsage,
+};
 use turborepo_repository::package_graph::PackageInfo;
 use turborepo_scm::SCM;
 use turborepo_telemetry::events::{task::PackageTaskEventBuilder, TrackedErrors};
 use turborepo_ui::{
     color, replay_logs, ColorSelector, LogWriter, PrefixedUI, PrefixedWriter, GREY, UI,
 };
+use wax::Program;
 
 use crate::{
     cli::OutputLogsMode,
     daemon::{DaemonClient, DaemonConnector},
     hash::{FileHashes, TurboHash},
     opts::RunCacheOpts,
-    run::task_id::TaskId,
+    run::task_id::{TaskId, TaskName},
     task_graph::{TaskDefinition, TaskOutputs},
 };
 
@@ -40,13 +51,28 @@ pub enum Error {
     Scm(#[from] turborepo_scm::Error),
     #[error(transparent)]
     Path(#[from] turbopath::PathError),
+    #[error("Error accessing failure marker: {0}")]
+    FailureMarker(#[source] std::io::Error),
+    #[error("Invalid output glob: {0}")]
+    OutputGlob(#[from] wax::BuildError),
+}
+
+impl Error {
+    /// Whether the cache was cancelled because the run is being interrupted
+    pub fn is_cancelled(&self) -> bool {
+        matches!(self, Error::Cache(turborepo_cache::CacheError::Cancelled))
+    }
 }
 
 pub struct RunCache {
     task_output_mode: Option<OutputLogsMode>,
     cache: AsyncCache,
     reads_disabled: bool,
+    force_tasks: Vec<TaskName<'static>>,
     writes_disabled: bool,
+    cache_failures: bool,
+    restore_declared_outputs: bool,
+    max_artifact_size: Option<u64>,
     repo_root: AbsoluteSystemPathBuf,
     color_selector: ColorSelector,
     daemon_client: Option<DaemonClient<DaemonConnector>>,
@@ -72,7 +98,11 @@ impl RunCache {
             task_output_mode,
             cache,
             reads_disabled: opts.skip_reads,
+            force_tasks: opts.force_tasks.clone(),
             writes_disabled: opts.skip_writes,
+            cache_failures: opts.cache_failures,
+            restore_declared_outputs: opts.restore_declared_outputs,
+            max_artifact_size: opts.max_artifact_size,
             repo_root: repo_root.to_owned(),
             color_selector,
             daemon_client,
@@ -88,10 +118,17 @@ impl RunCache {
         task_id: TaskId<'static>,
         hash: &str,
     ) -> TaskCache {
+        let package_dir = workspace_info.package_path().to_owned();
         let log_file_path = self
             .repo_root
             .resolve(workspace_info.package_path())
             .resolve(&TaskDefinition::workspace_relative_log_file(task_id.task()));
+        let failure_marker_path = self
+            .repo_root
+            .resolve(workspace_info.package_path())
+            .resolve(&TaskDefinition::workspace_relative_failure_marker(
+                task_id.task(),
+            ));
         let repo_relative_globs =
             task_definition.repo_relative_hashable_outputs(&task_id, workspace_info.package_path());
 
@@ -100,7 +137,18 @@ impl RunCache {
             task_output_mode = task_output_mode_override;
         }
 
-        let caching_disabled = !task_definition.cache;
+        // A task that doesn't cache its logs and has no outputs has nothing to cache.
+        // Persistent tasks run until they're interrupted, so restoring one would
+        // skip starting the dev server instead of replaying a result.
+        let caching_disabled = !task_definition.cache
+            || task_definition.persistent
+            || repo_relative_globs.inclusions.is_empty();
+        let reads_disabled = self.reads_disabled
+            || task_definition.always_run
+            || self
+                .force_tasks
+                .iter()
+                .any(|selector| is_forced(selector, &task_id));
 
         TaskCache {
             expanded_outputs: Vec::new(),
@@ -110,16 +158,78 @@ impl RunCache {
             task_id,
             task_output_mode,
             caching_disabled,
+            cache_logs: task_definition.cache_logs,
+            always_run: task_definition.always_run,
+            reads_disabled,
             log_file_path,
+            failure_marker_path,
+            cached_failure: None,
+            package_dir,
             daemon_client: self.daemon_client.clone(),
             ui: self.ui,
+            pending_write: None,
         }
     }
 
+    /// Waits for all pending cache writes to finish
+    pub async fn wait_for_writes(&self) {
+        // Ignore errors coming from cache already shutting down
+        self.cache.wait().await.ok();
+    }
+
+    pub fn upload_metadata(&self, hash: &str) -> Option<CacheUploadMetadata> {
+        self.cache.upload_metadata(hash)
+    }
+
+    pub fn download_metadata(&self, hash: &str) -> Option<CacheDownloadMetadata> {
+        self.cache.download_metadata(hash)
+    }
+
+    /// The number of bytes transferred to and from the remote cache during
+    /// this run
+    pub fn network_usage(&self) -> NetworkUsage {
+        self.cache.network_usage()
+    }
+
+    /// The limit on the size of uploaded artifacts, if there is one
+    pub fn max_artifact_size(&self) -> Option<u64> {
+        self.max_artifact_size
+    }
+
+    /// Returns the size of the artifact for the given hash if it was only
+    /// written to the local cache because it was too large to upload
+    pub fn skipped_upload(&self, hash: &str) -> Option<u64> {
+        self.cache.skipped_upload(hash)
+    }
+
+    /// Downloads an artifact from the remote cache into the local cache
+    /// without restoring it
+    pub async fn prefetch(&self, hash: &str) -> Result<Option<CacheHitMetadata>, CacheError> {
+        self.cache.prefetch(hash).await
+    }
+
+    /// Checks which of the hashes are cached without downloading them
+    pub async fn exists_all(
+        &self,
+        hashes: impl IntoIterator<Item = String>,
+    ) -> HashMap<String, Result<Option<CacheHitMetadata>, CacheError>> {
+        self.cache.exists_all(hashes).await
+    }
+
     pub async fn shutdown_cache(&self) {
         // Ignore errors coming from cache already shutting down
         self.cache.shutdown().await.ok();
     }
+
+    /// The number of cache writes that are queued or in progress
+    pub fn pending_writes(&self) -> usize {
+        self.cache.pending_writes()
+    }
+
+    /// Stops the cache transfers in progress instead of waiting for them
+    pub fn cancel_cache(&self) {
+        self.cache.cancel();
+    }
 }
 
 pub struct TaskCache {
@@ -129,10 +239,20 @@ pub struct TaskCache {
     hash: String,
     task_output_mode: OutputLogsMode,
     caching_disabled: bool,
+    cache_logs: bool,
+    // Tasks that always run still capture logs, but never touch the cache
+    always_run: bool,
+    reads_disabled: bool,
     log_file_path: AbsoluteSystemPathBuf,
+    failure_marker_path: AbsoluteSystemPathBuf,
+    // Exit code of the failed run that was restored from the cache
+    cached_failure: Option<i32>,
+    package_dir: AnchoredSystemPathBuf,
     daemon_client: Option<DaemonClient<DaemonConnector>>,
     ui: UI,
     task_id: TaskId<'static>,
+    // Resolves once the outputs saved by this task have been written
+    pending_write: Option<tokio::sync::oneshot::Receiver<()>>,
 }
 
 impl TaskCache {
@@ -164,17 +284,20 @@ impl TaskCache {
         let mut log_writer = LogWriter::default();
         let prefixed_writer = PrefixedWriter::new(self.run_cache.ui, prefix, writer);
 
-        if self.caching_disabled || self.run_cache.writes_disabled {
-            log_writer.with_prefixed_writer(prefixed_writer);
-            return Ok(log_writer);
-        }
-
-        log_writer.with_log_file(&self.log_file_path)?;
-
-        if !matches!(
+        let shows_output = !matches!(
             self.task_output_mode,
             OutputLogsMode::None | OutputLogsMode::HashOnly | OutputLogsMode::ErrorsOnly
-        ) {
+        );
+        let caches_logs =
+            self.always_run || !(self.caching_disabled || self.run_cache.writes_disabled);
+
+        // Logs that aren't shown are still written to the log file of a task
+        // that isn't cached, so that they can be replayed if it fails
+        if caches_logs || !shows_output {
+            log_writer.with_log_file(&self.log_file_path)?;
+        }
+
+        if shows_output {
             log_writer.with_prefixed_writer(prefixed_writer);
         }
 
@@ -185,12 +308,19 @@ impl TaskCache {
         self.run_cache.cache.exists(&self.hash).await
     }
 
+    /// Where the task's artifact is cached, checking the local and the remote
+    /// caches separately
+    pub async fn cache_state(&self) -> Result<CacheState, CacheError> {
+        self.run_cache.cache.cache_state(&self.hash).await
+    }
+
+    #[tracing::instrument(skip_all)]
     pub async fn restore_outputs(
         &mut self,
         prefixed_ui: &mut PrefixedUI<impl Write>,
         telemetry: &PackageTaskEventBuilder,
     ) -> Result<Option<CacheHitMetadata>, Error> {
-        if self.caching_disabled || self.run_cache.reads_disabled {
+        if self.caching_disabled || self.reads_disabled {
             if !matches!(
                 self.task_output_mode,
                 OutputLogsMode::None | OutputLogsMode::ErrorsOnly
@@ -229,14 +359,30 @@ impl TaskCache {
         let has_changed_outputs = changed_output_count > 0;
 
         let cache_status = if has_changed_outputs {
-            // Note that we currently don't use the output globs when restoring, but we
-            // could in the future to avoid doing unnecessary file I/O. We also
-            // need to pass along the exclusion globs as well.
-            let cache_status = self
-                .run_cache
-                .cache
-                .fetch(&self.run_cache.repo_root, &self.hash)
-                .await?;
+            let cache_status = if self.run_cache.restore_declared_outputs {
+                // The artifact may have been cached by a task whose outputs have
+                // since changed, so only restore what the task currently declares
+                let matcher = OutputsMatcher::new(&self.repo_relative_globs)?;
+                let failure_marker = AnchoredSystemPathBuf::relative_path_between(
+                    &self.run_cache.repo_root,
+                    &self.failure_marker_path,
+                );
+                self.run_cache
+                    .cache
+                    .fetch_matching(
+                        &self.run_cache.repo_root,
+                        &self.hash,
+                        &|path: &AnchoredSystemPath| {
+                            path == &*failure_marker || matcher.is_match(path)
+                        },
+                    )
+                    .await?
+            } else {
+                self.run_cache
+                    .cache
+                    .fetch(&self.run_cache.repo_root, &self.hash)
+                    .await?
+            };
 
             let Some((cache_hit_metadata, restored_files)) = cache_status else {
                 if !matches!(
@@ -252,6 +398,45 @@ impl TaskCache {
                 return Ok(None);
             };
 
+            let failure_marker = AnchoredSystemPathBuf::relative_path_between(
+                &self.run_cache.repo_root,
+                &self.failure_marker_path,
+            );
+            if restored_files.contains(&failure_marker) {
+                if !self.run_cache.cache_failures {
+                    // Without --cache-failures a cached failure is treated as a miss.
+                    // The marker has to be restored to find that out, but it
+                    // mustn't be left in the workspace.
+                    self.failure_marker_path
+                        .remove_file()
+                        .map_err(Error::FailureMarker)?;
+                    if !matches!(
+                        self.task_output_mode,
+                        OutputLogsMode::None | OutputLogsMode::ErrorsOnly
+                    ) {
+                        prefixed_ui.output(format!(
+                            "cache miss (cached failure ignored), executing {}",
+                            color!(self.ui, GREY, "{}", self.hash)
+                        ));
+                    }
+                    return Ok(None);
+                }
+
+                // Failed runs are never reported to the daemon as that would
+                // allow skipping the restore, and with it the failure.
+                let exit_code = self.read_failure_marker()?;
+                self.expanded_outputs = restored_files;
+                self.cached_failure = Some(exit_code);
+                if !matches!(self.task_output_mode, OutputLogsMode::None) {
+                    prefixed_ui.output(format!(
+                        "cache hit, replaying failure logs {}",
+                        color!(self.ui, GREY, "{}", self.hash)
+                    ));
+                    self.replay_log_file(prefixed_ui)?;
+                }
+                return Ok(Some(cache_hit_metadata));
+            }
+
             self.expanded_outputs = restored_files;
 
             if let Some(daemon_client) = &mut self.daemon_client {
@@ -290,6 +475,14 @@ impl TaskCache {
         };
 
         match self.task_output_mode {
+            // The logs on disk aren't from this hash if they weren't cached
+            OutputLogsMode::Full if !self.cache_logs => {
+                prefixed_ui.output(format!(
+                    "cache hit{}, logs not cached {}",
+                    more_context,
+                    color!(self.ui, GREY, "{}", self.hash)
+                ));
+            }
             OutputLogsMode::HashOnly | OutputLogsMode::NewOnly => {
                 prefixed_ui.output(format!(
                     "cache hit{}, suppressing logs {}",
@@ -314,12 +507,13 @@ impl TaskCache {
         Ok(cache_status)
     }
 
+    #[tracing::instrument(skip_all)]
     pub async fn save_outputs(
         &mut self,
         duration: Duration,
         telemetry: &PackageTaskEventBuilder,
     ) -> Result<(), Error> {
-        if self.caching_disabled || self.run_cache.writes_disabled {
+        if self.caching_disabled || self.run_cache.writes_disabled || self.always_run {
             return Ok(());
         }
 
@@ -341,15 +535,17 @@ impl TaskCache {
             })
             .collect::<Vec<_>>();
         relative_paths.sort();
-        self.run_cache
+        let pending_write = self
+            .run_cache
             .cache
-            .put(
+            .put_and_notify(
                 self.run_cache.repo_root.clone(),
                 self.hash.clone(),
                 relative_paths.clone(),
                 duration.as_millis() as u64,
             )
             .await?;
+        self.pending_write = Some(pending_write);
 
         if let Some(daemon_client) = self.daemon_client.as_mut() {
             let notify_result = daemon_client
@@ -374,11 +570,194 @@ impl TaskCache {
         Ok(())
     }
 
+    /// Caches the logs of a failed run along with a marker recording its exit
+    /// code. This is a no-op unless `--cache-failures` was passed.
+    #[tracing::instrument(skip_all)]
+    pub async fn save_failure(&mut self, duration: Duration, exit_code: i32) -> Result<(), Error> {
+        if self.caching_disabled
+            || self.run_cache.writes_disabled
+            || self.always_run
+            || !self.run_cache.cache_failures
+        {
+            return Ok(());
+        }
+
+        debug!("caching failure: exit code {exit_code}");
+
+        self.failure_marker_path
+            .ensure_dir()
+            .map_err(Error::FailureMarker)?;
+        self.failure_marker_path
+            .create_with_contents(exit_code.to_string())
+            .map_err(Error::FailureMarker)?;
+
+        let mut relative_paths = vec![AnchoredSystemPathBuf::relative_path_between(
+            &self.run_cache.repo_root,
+            &self.failure_marker_path,
+        )];
+        if self.cache_logs && self.log_file_path.exists() {
+            relative_paths.push(AnchoredSystemPathBuf::relative_path_between(
+                &self.run_cache.repo_root,
+                &self.log_file_path,
+            ));
+        }
+        relative_paths.sort();
+        let pending_write = self
+            .run_cache
+            .cache
+            .put_and_notify(
+                self.run_cache.repo_root.clone(),
+                self.hash.clone(),
+                relative_paths.clone(),
+                duration.as_millis() as u64,
+            )
+            .await?;
+        self.pending_write = Some(pending_write);
+
+        self.expanded_outputs = relative_paths;
+
+        Ok(())
+    }
+
+    fn read_failure_marker(&self) -> Result<i32, Error> {
+        let contents = self
+            .failure_marker_path
+            .read_to_string()
+            .map_err(Error::FailureMarker)?;
+        contents.trim().parse().map_err(|_| {
+            Error::FailureMarker(std::io::Error::new(
+                std::io::ErrorKind::InvalidData,
+                format!("invalid exit code: {contents}"),
+            ))
+        })
+    }
+
+    /// The exit code of a failed run that was replayed from the cache
+    pub fn cached_failure(&self) -> Option<i32> {
+        self.cached_failure
+    }
+
+    /// Returns a receiver that resolves once the outputs saved by this task
+    /// have been written, if any were saved
+    pub fn take_pending_write(&mut self) -> Option<tokio::sync::oneshot::Receiver<()>> {
+        self.pending_write.take()
+    }
+
+    /// Whether the cache is checked before the task runs, meaning that running
+    /// the task is a rebuild after a cache miss rather than a cache bypass
+    pub fn reads_enabled(&self) -> bool {
+        !self.caching_disabled && !self.reads_disabled
+    }
+
+    /// Finds files in the package that were written after `since` but aren't
+    /// covered by the task's outputs, meaning they weren't cached. Files that
+    /// the outputs deliberately exclude aren't reported, and neither are files
+    /// in `nested_packages`, the directories of the workspace packages when
+    /// this is a root task. This should be called after `save_outputs`.
+    pub fn undeclared_outputs(
+        &self,
+        since: SystemTime,
+        nested_packages: &[AnchoredSystemPathBuf],
+    ) -> Result<Vec<AnchoredSystemPathBuf>, Error> {
+        if self.caching_disabled || self.run_cache.writes_disabled || self.always_run {
+            return Ok(Vec::new());
+        }
+
+        let package_glob = |glob: &str| -> Result<globwalk::ValidatedGlob, Error> {
+            let mut repo_relative_glob = self.package_dir.to_string();
+            if !repo_relative_glob.is_empty() {
+                repo_relative_glob.push(MAIN_SEPARATOR);
+            }
+            repo_relative_glob.push_str(glob);
+            Ok(globwalk::ValidatedGlob::from_str(&repo_relative_glob)?)
+        };
+        let inclusions = [package_glob("**")?];
+        let mut exclusions = vec![
+            package_glob("**/node_modules/**")?,
+            package_glob("**/.turbo/**")?,
+            package_glob(".git/**")?,
+        ];
+        for package in nested_packages {
+            exclusions.push(globwalk::ValidatedGlob::from_str(&format!(
+                "{package}{MAIN_SEPARATOR}**"
+            ))?);
+        }
+        let written_files = globwalk::globwalk(
+            &self.run_cache.repo_root,
+            &inclusions,
+            &exclusions,
+            globwalk::WalkType::Files,
+        )?;
+
+        let outputs = self.expanded_outputs.iter().collect::<HashSet<_>>();
+        let matcher = OutputsMatcher::new(&self.repo_relative_globs)?;
+        let mut undeclared = written_files
+            .into_iter()
+            .filter(|path| {
+                path.symlink_metadata()
+                    .ok()
+                    .and_then(|metadata| metadata.modified().ok())
+                    .map_or(false, |modified| modified >= since)
+            })
+            .map(|path| {
+                AnchoredSystemPathBuf::relative_path_between(&self.run_cache.repo_root, &path)
+            })
+            .filter(|path| !outputs.contains(path) && !matcher.is_excluded(path))
+            .collect::<Vec<_>>();
+        undeclared.sort();
+
+        Ok(undeclared)
+    }
+
     pub fn expanded_outputs(&self) -> &[AnchoredSystemPathBuf] {
         &self.expanded_outputs
     }
 }
 
+// `--force` selectors are task names, with `<package>#*` selecting every task
+// of a package
+fn is_forced(selector: &TaskName, task_id: &TaskId) -> bool {
+    match selector.task() {
+        "*" => selector.in_workspace(task_id.package()),
+        _ => selector.matches(task_id),
+    }
+}
+
+// Matches repo relative paths against a task's output globs
+struct OutputsMatcher {
+    inclusions: Vec<wax::Glob<'static>>,
+    exclusions: Vec<wax::Glob<'static>>,
+}
+
+impl OutputsMatcher {
+    fn new(outputs: &TaskOutputs) -> Result<Self, Error> {
+        let compile = |globs: Vec<globwalk::ValidatedGlob>| {
+            globs
+                .iter()
+                .map(|glob| Ok(wax::Glob::new(glob.as_str())?.into_owned()))
+                .collect::<Result<Vec<_>, Error>>()
+        };
+        Ok(Self {
+            inclusions: compile(outputs.validated_inclusions()?)?,
+            exclusions: compile(outputs.validated_exclusions()?)?,
+        })
+    }
+
+    fn is_match(&self, path: &AnchoredSystemPath) -> bool {
+        let path = path.to_unix();
+        let path = Path::new(path.as_str());
+        self.inclusions.iter().any(|glob| glob.is_match(path))
+            && !self.exclusions.iter().any(|glob| glob.is_match(path))
+    }
+
+    // Whether the path is matched by one of the `!` globs of the outputs
+    fn is_excluded(&self, path: &AnchoredSystemPath) -> bool {
+        let path = path.to_unix();
+        let path = Path::new(path.as_str());
+        self.exclusions.iter().any(|glob| glob.is_match(path))
+    }
+}
+
 #[derive(Clone)]
 pub struct ConfigCache {
     hash: String,
@@ -463,3 +842,207 @@ impl ConfigCache {
         Ok(FileHashes(hash_object).hash())
     }
 }
+
+#[cfg(test)]
+mod test {
+    use std::{
+        sync::Arc,
+        time::{Duration, SystemTime},
+    };
+
+    use tempfile::tempdir;
+    use test_case::test_case;
+    use turbopath::{AbsoluteSystemPath, AbsoluteSystemPathBuf, AnchoredSystemPathBuf};
+    use turborepo_api_client::APIClient;
+    use turborepo_cache::{AsyncCache, CacheOpts};
+    use turborepo_repository::package_graph::PackageInfo;
+    use turborepo_telemetry::events::task::PackageTaskEventBuilder;
+    use turborepo_ui::{ColorSelector, PrefixedUI, UI};
+
+    use super::{is_forced, OutputsMatcher, RunCache, TaskCache};
+    use crate::{
+        opts::RunCacheOpts,
+        run::task_id::{TaskId, TaskName},
+        task_graph::{TaskDefinition, TaskOutputs},
+    };
+
+    fn run_cache(repo_root: &AbsoluteSystemPath, opts: &RunCacheOpts) -> Arc<RunCache> {
+        let cache_opts = CacheOpts {
+            skip_remote: true,
+            workers: 1,
+            ..CacheOpts::default()
+        };
+        let api_client = APIClient::new("http://localhost:1", 200, "2.0.0", true).unwrap();
+        let cache = AsyncCache::new(&cache_opts, repo_root, api_client, None, None).unwrap();
+        Arc::new(RunCache::new(
+            cache,
+            repo_root,
+            opts,
+            ColorSelector::default(),
+            None,
+            UI::new(true),
+            false,
+        ))
+    }
+
+    fn task_cache(run_cache: &Arc<RunCache>, package_json_path: &str, task_id: &str) -> TaskCache {
+        let package = PackageInfo {
+            package_json_path: AnchoredSystemPathBuf::from_raw(package_json_path).unwrap(),
+            ..PackageInfo::default()
+        };
+        run_cache.task_cache(
+            &TaskDefinition::default(),
+            &package,
+            TaskId::try_from(task_id).unwrap().into_owned(),
+            "abc123",
+        )
+    }
+
+    // A cached failure is only read back with --cache-failures, so an
+    // unreadable marker is a plain miss without it
+    #[test_case(false, "3", None ; "ignored")]
+    #[test_case(false, "not an exit code", None ; "ignored unreadable")]
+    #[test_case(true, "3", Some(Ok(3)) ; "replayed")]
+    #[test_case(true, "not an exit code", Some(Err(())) ; "replayed unreadable")]
+    #[tokio::test]
+    async fn test_restore_failure(
+        cache_failures: bool,
+        marker: &str,
+        expected: Option<Result<i32, ()>>,
+    ) {
+        let repo_root = tempdir().unwrap();
+        let repo_root = AbsoluteSystemPathBuf::try_from(repo_root.path()).unwrap();
+        let run_cache = run_cache(
+            &repo_root,
+            &RunCacheOpts {
+                cache_failures,
+                ..RunCacheOpts::default()
+            },
+        );
+        let mut task_cache = task_cache(&run_cache, "apps/web/package.json", "web#build");
+
+        // Cache a failure, then remove the marker so that it has to be restored
+        let failure_marker = task_cache.failure_marker_path.clone();
+        failure_marker.ensure_dir().unwrap();
+        failure_marker.create_with_contents(marker).unwrap();
+        run_cache
+            .cache
+            .put(
+                repo_root.clone(),
+                "abc123".to_string(),
+                vec![AnchoredSystemPathBuf::relative_path_between(
+                    &repo_root,
+                    &failure_marker,
+                )],
+                0,
+            )
+            .await
+            .unwrap();
+        run_cache.wait_for_writes().await;
+        failure_marker.remove_file().unwrap();
+
+        let mut output = Vec::new();
+        let mut errors = Vec::new();
+        let mut prefixed_ui = PrefixedUI::new(UI::new(true), &mut output, &mut errors);
+        let telemetry = PackageTaskEventBuilder::new("web", "build");
+        let restored = task_cache
+            .restore_outputs(&mut prefixed_ui, &telemetry)
+            .await;
+        match expected {
+            None => {
+                assert!(restored.unwrap().is_none());
+                assert!(!failure_marker.exists());
+            }
+            Some(Ok(exit_code)) => {
+                assert!(restored.unwrap().is_some());
+                assert_eq!(task_cache.cached_failure(), Some(exit_code));
+            }
+            Some(Err(())) => assert!(restored.is_err()),
+        }
+    }
+
+    #[test_case(
+        "package.json",
+        "//#build",
+        &["apps/web"],
+        &["out.txt", "scripts/gen.js"]
+        ; "root task"
+    )]
+    #[test_case(
+        "apps/web/package.json",
+        "web#build",
+        &[],
+        &["apps/web/dist/index.js", "apps/web/out.txt"]
+        ; "package task"
+    )]
+    #[tokio::test]
+    async fn test_undeclared_outputs(
+        package_json_path: &str,
+        task_id: &str,
+        nested_packages: &[&str],
+        expected: &[&str],
+    ) {
+        let repo_root = tempdir().unwrap();
+        let repo_root = AbsoluteSystemPathBuf::try_from(repo_root.path()).unwrap();
+        let run_cache = run_cache(&repo_root, &RunCacheOpts::default());
+        let task_cache = task_cache(&run_cache, package_json_path, task_id);
+
+        let since = SystemTime::now() - Duration::from_secs(1);
+        for file in [
+            "out.txt",
+            "scripts/gen.js",
+            "node_modules/.cache/root",
+            ".turbo/turbo-build.log",
+            "apps/web/out.txt",
+            "apps/web/dist/index.js",
+            "apps/web/node_modules/.cache/web",
+            "apps/web/src/node_modules/nested",
+            "apps/web/.turbo/turbo-build.log",
+        ] {
+            let path = repo_root.join_components(&file.split('/').collect::<Vec<_>>());
+            path.ensure_dir().unwrap();
+            path.create_with_contents("").unwrap();
+        }
+
+        let nested_packages = nested_packages
+            .iter()
+            .map(|dir| AnchoredSystemPathBuf::from_raw(dir).unwrap())
+            .collect::<Vec<_>>();
+        let expected = expected
+            .iter()
+            .map(|file| AnchoredSystemPathBuf::from_raw(file).unwrap())
+            .collect::<Vec<_>>();
+        assert_eq!(
+            task_cache
+                .undeclared_outputs(since, &nested_packages)
+                .unwrap(),
+            expected
+        );
+    }
+
+    #[test_case("web#build", "web#build", true ; "task in package")]
+    #[test_case("web#build", "docs#build", false ; "task in other package")]
+    #[test_case("build", "docs#build", true ; "task in every package")]
+    #[test_case("build", "docs#test", false ; "other task")]
+    #[test_case("web#*", "web#test", true ; "every task in package")]
+    #[test_case("web#*", "docs#test", false ; "every task in other package")]
+    fn test_is_forced(selector: &str, task_id: &str, expected: bool) {
+        let selector = TaskName::from(selector);
+        let task_id = TaskId::try_from(task_id).unwrap();
+        assert_eq!(is_forced(&selector, &task_id), expected);
+    }
+
+    #[test_case("dist/index.js", true, false ; "included")]
+    #[test_case("dist/index.js.map", false, true ; "excluded")]
+    #[test_case("src/index.js", false, false ; "not declared")]
+    fn test_outputs_matcher(path: &str, is_match: bool, is_excluded: bool) {
+        let outputs = TaskOutputs {
+            inclusions: vec!["dist/**".to_string()],
+            exclusions: vec!["dist/**/*.map".to_string()],
+        };
+        let matcher = OutputsMatcher::new(&outputs).unwrap();
+        let path = AnchoredSystemPathBuf::from_raw(path).unwrap();
+        assert_eq!(matcher.is_match(&path), is_match);
+        assert_eq!(matcher.is_excluded(&path), is_excluded);
+    }
+}
@@ -0,0 +1,217 @@
+//! Removes files that turbo leaves in `.turbo` directories once they're no
+//! longer useful: the logs of tasks that haven't run in a while, the trace
+//! directories of runs that were interrupted before they could clean up, and
+//! the summaries of all but the most recent runs.
+
+use std::{
+    ffi::OsStr,
+    fs, io,
+    path::{Path, PathBuf},
+    time::{Duration, SystemTime},
+};
+
+use serde::Serialize;
+use tracing::debug;
+use turbopath::{AbsoluteSystemPath, AnchoredSystemPath};
+
+/// The number and total size of files removed
+#[derive(Debug, Default, Clone, Copy, PartialEq, Serialize)]
+pub struct RemovedFiles {
+    pub files: usize,
+    pub bytes: u64,
+}
+
+/// Removes files that haven't been modified within `max_age`. This is best
+/// effort, files that can't be removed are logged and skipped.
+pub fn clean_up<'a>(
+    repo_root: &AbsoluteSystemPath,
+    package_dirs: impl IntoIterator<Item = &'a AnchoredSystemPath>,
+    max_age: Duration,
+) -> RemovedFiles {
+    let cutoff = SystemTime::now()
+        .checked_sub(max_age)
+        .unwrap_or(SystemTime::UNIX_EPOCH);
+    let mut removed = RemovedFiles::default();
+
+    for package_dir in package_dirs {
+        let log_dir = repo_root.resolve(package_dir).join_component(".turbo");
+        for (path, metadata) in stale_entries(&log_dir, cutoff) {
+            if metadata.is_file() && is_task_log(&path) {
+                remove(&path, &metadata, &mut removed);
+            }
+        }
+    }
+
+    // Traces are written to `.turbo/<task hash>` and read back once the task
+    // finishes
+    let turbo_dir = repo_root.join_component(".turbo");
+    for (path, metadata) in stale_entries(&turbo_dir, cutoff) {
+        if metadata.is_dir() && path.file_name().map_or(false, is_task_hash) {
+            remove(&path, &metadata, &mut removed);
+        }
+    }
+
+    removed
+}
+
+/// Removes all but the `keep` most recent run summaries from `runs_dir`.
+/// Summaries are named after the run's KSUID, which sorts by creation time.
+pub fn prune_run_summaries(runs_dir: &AbsoluteSystemPath, keep: usize) -> RemovedFiles {
+    let mut summaries = fs::read_dir(runs_dir)
+        .into_iter()
+        .flatten()
+        .flatten()
+        .filter(|entry| entry.path().extension() == Some(OsStr::new("json")))
+        .filter_map(|entry| Some((entry.path(), entry.metadata().ok()?)))
+        .filter(|(_, metadata)| metadata.is_file())
+        .collect::<Vec<_>>();
+    summaries.sort_by(|(a, _), (b, _)| b.cmp(a));
+
+    let mut removed = RemovedFiles::default();
+    for (path, metadata) in summaries.iter().skip(keep) {
+        remove(path, metadata, &mut removed);
+    }
+    removed
+}
+
+// Logs are written as `turbo-<task>.log`, and failed tasks also get a
+// `turbo-<task>.failed` marker
+fn is_task_log(path: &Path) -> bool {
+    path.file_name()
+        .and_then(|file_name| file_name.to_str())
+        .and_then(|file_name| file_name.strip_prefix("turbo-"))
+        .map_or(false, |file_name| {
+            file_name.ends_with(".log") || file_name.ends_with(".failed")
+        })
+}
+
+fn is_task_hash(file_name: &OsStr) -> bool {
+    file_name.to_str().map_or(false, |file_name| {
+        file_name.len() == 16 && file_name.chars().all(|c| c.is_ascii_hexdigit())
+    })
+}
+
+fn stale_entries(
+    dir: &AbsoluteSystemPath,
+    cutoff: SystemTime,
+) -> impl Iterator<Item = (PathBuf, fs::Metadata)> {
+    fs::read_dir(dir)
+        .into_iter()
+        .flatten()
+        .flatten()
+        .filter_map(move |entry| {
+            let metadata = entry.metadata().ok()?;
+            let modified = metadata.modified().ok()?;
+            (modified < cutoff).then(|| (entry.path(), metadata))
+        })
+}
+
+// Trace directories only hold files
+fn dir_size(path: &Path) -> io::Result<u64> {
+    Ok(fs::read_dir(path)?
+        .flatten()
+        .filter_map(|entry| entry.metadata().ok())
+        .filter(|metadata| metadata.is_file())
+        .map(|metadata| metadata.len())
+        .sum())
+}
+
+fn remove(path: &Path, metadata: &fs::Metadata, removed: &mut RemovedFiles) {
+    let result = match metadata.is_dir() {
+        true => dir_size(path).and_then(|size| fs::remove_dir_all(path).map(|_| size)),
+        false => fs::remove_file(path).map(|_| metadata.len()),
+    };
+    match result {
+        Ok(size) => {
+            removed.files += 1;
+            removed.bytes += size;
+        }
+        Err(e) if e.kind() == io::ErrorKind::NotFound => {}
+        Err(e) => debug!("failed to remove {}: {e}", path.display()),
+    }
+}
+
+#[cfg(test)]
+mod test {
+    use std::fs::File;
+
+    use anyhow::Result;
+    use tempfile::tempdir;
+
+    use super::*;
+
+    fn age(path: &AbsoluteSystemPath, by: Duration) -> Result<()> {
+        File::options()
+            .read(true)
+            .open(path.as_std_path())?
+            .set_modified(SystemTime::now() - by)?;
+        Ok(())
+    }
+
+    #[test]
+    fn test_clean_up() -> Result<()> {
+        let dir = tempdir()?;
+        let repo_root = AbsoluteSystemPath::from_std_path(dir.path())?;
+        let day = Duration::from_secs(24 * 60 * 60);
+        let web = AnchoredSystemPath::new("web")?;
+        let log_dir = repo_root.resolve(web).join_component(".turbo");
+        log_dir.create_dir_all()?;
+
+        let stale_log = log_dir.join_component("turbo-build.log");
+        stale_log.create_with_contents("built")?;
+        age(&stale_log, 10 * day)?;
+        let stale_marker = log_dir.join_component("turbo-build.failed");
+        stale_marker.create_with_contents("1")?;
+        age(&stale_marker, 10 * day)?;
+        let fresh_log = log_dir.join_component("turbo-test.log");
+        fresh_log.create_with_contents("tested")?;
+        // Files that turbo didn't write are left alone
+        let other = log_dir.join_component("other.log");
+        other.create_with_contents("other")?;
+        age(&other, 10 * day)?;
+
+        let trace_dir = repo_root.join_components(&[".turbo", "0123456789abcdef"]);
+        trace_dir.create_dir_all()?;
+        trace_dir
+            .join_component("trace.json")
+            .create_with_contents("{}")?;
+        age(&trace_dir, 10 * day)?;
+        let runs_dir = repo_root.join_components(&[".turbo", "runs"]);
+        runs_dir.create_dir_all()?;
+        age(&runs_dir, 10 * day)?;
+
+        let removed = clean_up(repo_root, [AnchoredSystemPath::empty(), web], day);
+
+        assert_eq!(removed, RemovedFiles { files: 3, bytes: 8 });
+        assert!(!stale_log.exists());
+        assert!(!stale_marker.exists());
+        assert!(!trace_dir.exists());
+        assert!(fresh_log.exists());
+        assert!(other.exists());
+        assert!(runs_dir.exists());
+        Ok(())
+    }
+
+    #[test]
+    fn test_prune_run_summaries() -> Result<()> {
+        let dir = tempdir()?;
+        let runs_dir = AbsoluteSystemPath::from_std_path(dir.path())?;
+        for id in ["2a", "2b", "2c", "2d"] {
+            runs_dir
+                .join_component(&format!("{id}.json"))
+                .create_with_contents("{}")?;
+        }
+        let other = runs_dir.join_component("notes.txt");
+        other.create_with_contents("notes")?;
+
+        let removed = prune_run_summaries(runs_dir, 2);
+
+        assert_eq!(removed, RemovedFiles { files: 2, bytes: 4 });
+        assert!(!runs_dir.join_component("2a.json").exists());
+        assert!(!runs_dir.join_component("2b.json").exists());
+        assert!(runs_dir.join_component("2c.json").exists());
+        assert!(runs_dir.join_component("2d.json").exists());
+        assert!(other.exists());
+        Ok(())
+    }
+}
@@ -0,0 +1,138 @@
+//! Validation of the `engines` field of package.json files against the
+//! versions of the tools installed on the machine running `turbo`.
+
+use std::{collections::BTreeMap, fmt, io, process::Command};
+
+use node_semver::{Range, Version};
+use tracing::debug;
+use turborepo_repository::{package_graph::PackageName, package_json::PackageJson};
+use turborepo_ui::{cwrite, cwriteln, BOLD_YELLOW_REVERSE, UI, YELLOW};
+use which::which;
+
+/// The engines that turbo knows how to check
+const ENGINES: [&str; 2] = ["node", "npm"];
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct EngineMismatch {
+    pub package: String,
+    pub engine: String,
+    pub range: String,
+    pub installed: String,
+}
+
+impl fmt::Display for EngineMismatch {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(
+            f,
+            "{} requires {} {}, but {} is installed",
+            self.package, self.engine, self.range, self.installed
+        )
+    }
+}
+
+/// The installed version of each engine we were able to detect
+#[derive(Debug, Default)]
+pub struct EngineVersions(BTreeMap<&'static str, Version>);
+
+impl EngineVersions {
+    pub fn detect() -> Self {
+        let versions = ENGINES
+            .into_iter()
+            .filter_map(|engine| {
+                let version = installed_version(engine)?;
+                debug!("detected {engine} version {version}");
+                Some((engine, version))
+            })
+            .collect();
+        Self(versions)
+    }
+}
+
+fn installed_version(engine: &str) -> Option<Version> {
+    let binary = which(engine).ok()?;
+    let output = Command::new(binary).arg("--version").output().ok()?;
+    if !output.status.success() {
+        return None;
+    }
+    let stdout = String::from_utf8(output.stdout).ok()?;
+    Version::parse(stdout.trim().trim_start_matches('v')).ok()
+}
+
+/// Checks the `engines` of each package against the installed versions.
+/// Engines that we couldn't detect and ranges we can't parse are skipped.
+pub fn check_engines<'a>(
+    packages: impl Iterator<Item = (&'a PackageName, &'a PackageJson)>,
+    versions: &EngineVersions,
+) -> Vec<EngineMismatch> {
+    let mut mismatches = Vec::new();
+    for (package, package_json) in packages {
+        let Some(engines) = &package_json.engines else {
+            continue;
+        };
+        for (engine, range) in engines {
+            let Some(installed) = versions.0.get(engine.as_str()) else {
+                continue;
+            };
+            let Ok(parsed_range) = Range::parse(range) else {
+                debug!("unable to parse {engine} range {range} for {package}");
+                continue;
+            };
+            if !parsed_range.satisfies(installed) {
+                mismatches.push(EngineMismatch {
+                    package: package.to_string(),
+                    engine: engine.clone(),
+                    range: range.clone(),
+                    installed: installed.to_string(),
+                });
+            }
+        }
+    }
+    mismatches.sort_by(|a, b| (&a.package, &a.engine).cmp(&(&b.package, &b.engine)));
+    mismatches
+}
+
+pub fn write_engine_warnings(ui: UI, mismatches: &[EngineMismatch]) -> Result<(), io::Error> {
+    let stderr = io::stderr();
+    for mismatch in mismatches {
+        cwrite!(&stderr, ui, BOLD_YELLOW_REVERSE, " WARNING ")?;
+        cwriteln!(&stderr, ui, YELLOW, " {mismatch}")?;
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod test {
+    use serde_json::json;
+    use test_case::test_case;
+
+    use super::*;
+
+    fn versions(node: &str, npm: &str) -> EngineVersions {
+        EngineVersions(
+            [
+                ("node", Version::parse(node).unwrap()),
+                ("npm", Version::parse(npm).unwrap()),
+            ]
+            .into_iter()
+            .collect(),
+        )
+    }
+
+    #[test_case(json!({"node": ">=18"}), vec![] ; "satisfied")]
+    #[test_case(json!({"node": "^16"}), vec![("node", "^16")] ; "node mismatch")]
+    #[test_case(json!({"node": "^16", "npm": "<9"}), vec![("node", "^16"), ("npm", "<9")] ; "both mismatch")]
+    #[test_case(json!({"yarn": "^1"}), vec![] ; "unknown engine")]
+    fn test_check_engines(engines: serde_json::Value, expected: Vec<(&str, &str)>) {
+        let package = PackageName::from("web");
+        let package_json = PackageJson::from_value(json!({ "engines": engines })).unwrap();
+        let mismatches = check_engines(
+            std::iter::once((&package, &package_json)),
+            &versions("18.17.0", "9.6.7"),
+        );
+        let actual = mismatches
+            .iter()
+            .map(|m| (m.engine.as_str(), m.range.as_str()))
+            .collect::<Vec<_>>();
+        assert_eq!(actual, expected);
+    }
+}
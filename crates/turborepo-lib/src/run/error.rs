@@ -1,8 +1,9 @@
 use miette::Diagnostic;
+use serde::Serialize;
 use thiserror::Error;
 use turborepo_repository::package_graph;
 
-use super::graph_visualizer;
+use super::{affected, engines::EngineMismatch, graph_visualizer, hooks};
 use crate::{
     config, daemon, engine,
     engine::ValidateError,
@@ -47,6 +48,108 @@ pub enum Error {
     TaskHash(#[from] task_hash::Error),
     #[error(transparent)]
     Visitor(#[from] task_graph::VisitorError),
+    #[error("installed engines do not satisfy package requirements")]
+    Engines(#[related] Vec<EngineMismatchError>),
+    #[error("failed to serialize task hashes: {0}")]
+    HashOnly(serde_json::Error),
+    #[error("failed to serialize prefetch summary: {0}")]
+    Prefetch(serde_json::Error),
     #[error("error registering signal handler: {0}")]
     SignalHandler(std::io::Error),
+    #[error(transparent)]
+    Affected(#[from] affected::Error),
+    #[error(transparent)]
+    Hooks(#[from] hooks::Error),
+    #[error("unable to find the default branch to compare against: {0}")]
+    DefaultBranch(#[source] turborepo_scm::Error),
+}
+
+/// The broad cause of a failed run. Infrastructure and config failures exit
+/// with their own codes so that CI can tell flaky infrastructure that is worth
+/// retrying apart from real failures.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub enum FailureKind {
+    // A task exited unsuccessfully, turbo exits with the task's exit code
+    Task,
+    // turbo couldn't do its own work, e.g. a cache, daemon or IO error
+    Infrastructure,
+    // The repository's configuration or the invocation is invalid
+    Config,
+}
+
+impl FailureKind {
+    // EX_TEMPFAIL from sysexits.h, a temporary failure that can be retried
+    pub const INFRASTRUCTURE_EXIT_CODE: i32 = 75;
+    // EX_CONFIG from sysexits.h
+    pub const CONFIG_EXIT_CODE: i32 = 78;
+}
+
+impl Error {
+    pub fn failure_kind(&self) -> FailureKind {
+        match self {
+            Error::EngineValidation(_)
+            | Error::Builder(_)
+            | Error::Env(_)
+            | Error::Opts(_)
+            | Error::PackageJson(_)
+            | Error::PackageManager(_)
+            | Error::Config(_)
+            | Error::PackageGraphBuilder(_)
+            | Error::Scope(_)
+            | Error::DefaultBranch(_)
+            | Error::Engines(_)
+            | Error::Affected(affected::Error::MissingRange)
+            | Error::Hooks(hooks::Error::Rejected { .. })
+            | Error::Graph(graph_visualizer::Error::InvalidFilename { .. })
+            | Error::Visitor(task_graph::VisitorError::RecursiveTurbo { .. }) => {
+                FailureKind::Config
+            }
+            Error::Graph(_)
+            | Error::DaemonConnector(_)
+            | Error::Cache(_)
+            | Error::Path(_)
+            | Error::GlobalHash(_)
+            | Error::TaskHash(_)
+            | Error::Visitor(_)
+            | Error::HashOnly(_)
+            | Error::Prefetch(_)
+            | Error::SignalHandler(_)
+            | Error::Affected(_)
+            | Error::Hooks(_) => FailureKind::Infrastructure,
+        }
+    }
+
+    pub fn exit_code(&self) -> i32 {
+        match self.failure_kind() {
+            FailureKind::Config => FailureKind::CONFIG_EXIT_CODE,
+            // Task failures are reported through the run's exit code rather than
+            // as an error, so anything else is turbo's own failure.
+            FailureKind::Task | FailureKind::Infrastructure => {
+                FailureKind::INFRASTRUCTURE_EXIT_CODE
+            }
+        }
+    }
+}
+
+#[derive(Debug, Error, Diagnostic)]
+#[error("{0}")]
+pub struct EngineMismatchError(pub EngineMismatch);
+
+#[cfg(test)]
+mod test {
+    use std::io;
+
+    use super::*;
+
+    #[test]
+    fn test_failure_exit_codes() {
+        let config_error = Error::Engines(vec![]);
+        assert_eq!(config_error.failure_kind(), FailureKind::Config);
+        assert_eq!(config_error.exit_code(), 78);
+
+        let infra_error = Error::SignalHandler(io::Error::new(io::ErrorKind::Other, "signal"));
+        assert_eq!(infra_error.failure_kind(), FailureKind::Infrastructure);
+        assert_eq!(infra_error.exit_code(), 75);
+    }
 }
@@ -9,7 +9,7 @@ use turbopath::{AbsoluteSystemPath, AbsoluteSystemPathBuf};
 use turborepo_ui::{cprintln, cwrite, cwriteln, BOLD, BOLD_YELLOW_REVERSE, UI, YELLOW};
 use which::which;
 
-use crate::{engine::Engine, opts::GraphOpts, spawn_child};
+use crate::{cli::GraphMode, engine::Engine, opts::GraphOpts, spawn_child};
 
 #[derive(Debug, Error)]
 pub enum Error {
@@ -27,18 +27,22 @@ pub enum Error {
 pub(crate) fn write_graph(
     ui: UI,
     graph_opts: &GraphOpts,
+    mode: GraphMode,
     engine: &Engine,
     single_package: bool,
     cwd: &AbsoluteSystemPath,
 ) -> Result<(), Error> {
     match graph_opts {
-        GraphOpts::Stdout => render_dot_graph(std::io::stdout(), engine, single_package)?,
+        GraphOpts::Stdout => render_dot_graph(std::io::stdout(), engine, single_package, mode)?,
+        GraphOpts::MermaidStdout => engine
+            .mermaid_graph(std::io::stdout(), single_package, mode)
+            .map_err(Error::GraphOutput)?,
         GraphOpts::File(raw_filename) => {
             let (filename, extension) = filename_and_extension(cwd, raw_filename)?;
-            if extension == "mermaid" {
-                render_mermaid_graph(&filename, engine, single_package)?;
+            if extension == "mermaid" || extension == "mmd" {
+                render_mermaid_graph(&filename, engine, single_package, mode)?;
             } else if extension == "html" {
-                render_html(&filename, engine, single_package)?;
+                render_html(&filename, engine, single_package, mode)?;
             } else if let Ok(dot_path) = which("dot") {
                 let mut cmd = Command::new(dot_path);
                 cmd.stdin(Stdio::piped())
@@ -46,11 +50,11 @@ pub(crate) fn write_graph(
                     .current_dir(cwd);
                 let child = spawn_child(cmd).map_err(Error::Graphviz)?;
                 let stdin = child.take_stdin().expect("graphviz should have a stdin");
-                render_dot_graph(stdin, engine, single_package)?;
+                render_dot_graph(stdin, engine, single_package, mode)?;
                 child.wait().map_err(Error::Graphviz)?;
             } else {
                 write_graphviz_warning(ui).map_err(Error::GraphOutput)?;
-                render_dot_graph(std::io::stdout(), engine, single_package)?;
+                render_dot_graph(std::io::stdout(), engine, single_package, mode)?;
             }
             print!("\n✔ Generated task graph in ");
             cprintln!(ui, BOLD, "{filename}");
@@ -70,6 +74,7 @@ fn render_mermaid_graph(
     filename: &AbsoluteSystemPath,
     engine: &Engine,
     single_package: bool,
+    mode: GraphMode,
 ) -> Result<(), Error> {
     let mut opts = OpenOptions::new();
     opts.truncate(true).create(true).write(true);
@@ -77,7 +82,7 @@ fn render_mermaid_graph(
         .open_with_options(opts)
         .map_err(Error::GraphOutput)?;
     engine
-        .mermaid_graph(file, single_package)
+        .mermaid_graph(file, single_package, mode)
         .map_err(Error::GraphOutput)
 }
 
@@ -85,9 +90,10 @@ fn render_dot_graph<W: io::Write>(
     writer: W,
     engine: &Engine,
     single_package: bool,
+    mode: GraphMode,
 ) -> Result<(), Error> {
     engine
-        .dot_graph(writer, single_package)
+        .dot_graph(writer, single_package, mode)
         .map_err(Error::GraphOutput)
 }
 
@@ -113,6 +119,7 @@ fn render_html(
     filename: &AbsoluteSystemPath,
     engine: &Engine,
     single_package: bool,
+    mode: GraphMode,
 ) -> Result<(), Error> {
     let mut opts = OpenOptions::new();
     opts.truncate(true).create(true).write(true);
@@ -120,7 +127,7 @@ fn render_html(
         .open_with_options(opts)
         .map_err(Error::GraphOutput)?;
     let mut graph_buffer = Vec::new();
-    render_dot_graph(&mut graph_buffer, engine, single_package)?;
+    render_dot_graph(&mut graph_buffer, engine, single_package, mode)?;
     let graph_string = String::from_utf8(graph_buffer).expect("graph rendering should be UTF-8");
 
     file.write_all(HTML_PREFIX.as_bytes())
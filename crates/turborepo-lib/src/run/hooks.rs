@@ -0,0 +1,288 @@
+use std::process::{ExitStatus, Stdio};
+
+use serde::Serialize;
+use thiserror::Error;
+use tokio::{io::AsyncWriteExt, process::Command};
+use tracing::{debug, warn};
+use turbopath::{AbsoluteSystemPath, AbsoluteSystemPathBuf};
+
+use crate::turbo_json::HooksConfig;
+
+#[derive(Debug, Error)]
+pub enum Error {
+    #[error("{event} hook '{hook}' exited with {status}")]
+    Rejected {
+        event: HookEvent,
+        hook: String,
+        status: ExitStatus,
+    },
+    #[error("failed to run {event} hook '{hook}': {source}")]
+    Io {
+        event: HookEvent,
+        hook: String,
+        #[source]
+        source: std::io::Error,
+    },
+    #[error("failed to serialize {0} hook payload: {1}")]
+    Payload(HookEvent, #[source] serde_json::Error),
+}
+
+/// The points in a run where hooks are invoked
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub enum HookEvent {
+    PreRun,
+    PostTask,
+    PostRun,
+}
+
+impl std::fmt::Display for HookEvent {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(match self {
+            HookEvent::PreRun => "preRun",
+            HookEvent::PostTask => "postTask",
+            HookEvent::PostRun => "postRun",
+        })
+    }
+}
+
+#[derive(Debug, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct PreRunPayload<'a> {
+    pub run_id: &'a str,
+    pub command: &'a str,
+    pub tasks: Vec<String>,
+}
+
+#[derive(Debug, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct PostTaskPayload<'a> {
+    pub run_id: &'a str,
+    pub task_id: String,
+    pub package: &'a str,
+    pub task: &'a str,
+    pub hash: &'a str,
+    pub status: TaskStatus,
+    pub exit_code: Option<i32>,
+    pub duration_ms: u128,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub enum TaskStatus {
+    Cached,
+    Succeeded,
+    Failed,
+}
+
+#[derive(Debug, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct PostRunPayload<'a> {
+    pub run_id: &'a str,
+    pub command: &'a str,
+    pub exit_code: i32,
+}
+
+// Every payload records which event it was sent for so that a single script
+// can be used for several events
+#[derive(Serialize)]
+struct Envelope<'a, T> {
+    event: HookEvent,
+    #[serde(flatten)]
+    payload: &'a T,
+}
+
+/// Runs the hooks declared in the root `turbo.json`. Hooks are executables,
+/// or JavaScript files that are run with `node`, and receive a JSON payload
+/// describing the event on stdin. They run from the repository root.
+#[derive(Debug, Clone)]
+pub struct Hooks {
+    repo_root: AbsoluteSystemPathBuf,
+    config: HooksConfig,
+}
+
+impl Hooks {
+    pub fn new(repo_root: &AbsoluteSystemPath, config: HooksConfig) -> Self {
+        Self {
+            repo_root: repo_root.to_owned(),
+            config,
+        }
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.config.pre_run.is_empty()
+            && self.config.post_task.is_empty()
+            && self.config.post_run.is_empty()
+    }
+
+    /// Runs the pre-run hooks. A hook that exits unsuccessfully stops the run
+    /// before any tasks are executed.
+    pub async fn pre_run(&self, payload: &PreRunPayload<'_>) -> Result<(), Error> {
+        self.run_all(HookEvent::PreRun, &self.config.pre_run, payload)
+            .await
+    }
+
+    /// Runs the post-task hooks. Failures are only reported as warnings since
+    /// the task has already finished.
+    pub async fn post_task(&self, payload: &PostTaskPayload<'_>) {
+        if let Err(e) = self
+            .run_all(HookEvent::PostTask, &self.config.post_task, payload)
+            .await
+        {
+            warn!("{e}");
+        }
+    }
+
+    /// Runs the post-run hooks. Failures are only reported as warnings so
+    /// they don't change the run's exit code.
+    pub async fn post_run(&self, payload: &PostRunPayload<'_>) {
+        if let Err(e) = self
+            .run_all(HookEvent::PostRun, &self.config.post_run, payload)
+            .await
+        {
+            warn!("{e}");
+        }
+    }
+
+    async fn run_all(
+        &self,
+        event: HookEvent,
+        hooks: &[String],
+        payload: &impl Serialize,
+    ) -> Result<(), Error> {
+        if hooks.is_empty() {
+            return Ok(());
+        }
+        let payload = serde_json::to_vec(&Envelope { event, payload })
+            .map_err(|e| Error::Payload(event, e))?;
+        for hook in hooks {
+            self.run_one(event, hook, &payload).await?;
+        }
+
+        Ok(())
+    }
+
+    async fn run_one(&self, event: HookEvent, hook: &str, payload: &[u8]) -> Result<(), Error> {
+        let io_error = |source| Error::Io {
+            event,
+            hook: hook.to_string(),
+            source,
+        };
+
+        debug!("running {event} hook {hook}");
+        let mut child = self
+            .command(hook)
+            .current_dir(&self.repo_root)
+            .env("TURBO_HOOK_EVENT", event.to_string())
+            .stdin(Stdio::piped())
+            .kill_on_drop(true)
+            .spawn()
+            .map_err(io_error)?;
+
+        if let Some(mut stdin) = child.stdin.take() {
+            // A hook that doesn't read its payload closes stdin early, which
+            // isn't an error
+            match stdin.write_all(payload).await {
+                Err(e) if e.kind() != std::io::ErrorKind::BrokenPipe => return Err(io_error(e)),
+                _ => (),
+            }
+        }
+
+        let status = child.wait().await.map_err(io_error)?;
+        if !status.success() {
+            return Err(Error::Rejected {
+                event,
+                hook: hook.to_string(),
+                status,
+            });
+        }
+
+        Ok(())
+    }
+
+    fn command(&self, hook: &str) -> Command {
+        if is_js_file(hook) {
+            let mut command = Command::new("node");
+            command.arg(self.repo_root.as_std_path().join(hook));
+            command
+        } else if hook.contains('/') {
+            // Relative paths are resolved from the repository root rather than
+            // the directory turbo was invoked from
+            Command::new(self.repo_root.as_std_path().join(hook))
+        } else {
+            Command::new(hook)
+        }
+    }
+}
+
+fn is_js_file(hook: &str) -> bool {
+    [".js", ".mjs", ".cjs"]
+        .iter()
+        .any(|extension| hook.ends_with(extension))
+}
+
+#[cfg(test)]
+mod test {
+    use test_case::test_case;
+
+    use super::*;
+
+    #[test_case("./scripts/policy.js", true ; "js")]
+    #[test_case("hooks/report.mjs", true ; "esm")]
+    #[test_case("hooks/report.cjs", true ; "commonjs")]
+    #[test_case("./scripts/policy.sh", false ; "shell script")]
+    #[test_case("notify", false ; "executable on path")]
+    fn test_is_js_file(hook: &str, expected: bool) {
+        assert_eq!(is_js_file(hook), expected);
+    }
+
+    #[test]
+    fn test_payload() {
+        let payload = PostRunPayload {
+            run_id: "abc",
+            command: "turbo run build",
+            exit_code: 1,
+        };
+        let json = serde_json::to_value(Envelope {
+            event: HookEvent::PostRun,
+            payload: &payload,
+        })
+        .unwrap();
+
+        assert_eq!(
+            json,
+            serde_json::json!({
+                "event": "postRun",
+                "runId": "abc",
+                "command": "turbo run build",
+                "exitCode": 1,
+            })
+        );
+    }
+
+    #[cfg(unix)]
+    #[tokio::test]
+    async fn test_pre_run_rejection() {
+        let dir = tempfile::tempdir().unwrap();
+        let repo_root = AbsoluteSystemPath::from_std_path(dir.path()).unwrap();
+        let hooks = Hooks::new(
+            repo_root,
+            HooksConfig {
+                pre_run: vec!["true".to_string(), "false".to_string()],
+                ..Default::default()
+            },
+        );
+        let payload = PreRunPayload {
+            run_id: "abc",
+            command: "turbo run build",
+            tasks: vec!["build".to_string()],
+        };
+
+        let result = hooks.pre_run(&payload).await;
+
+        assert!(matches!(
+            result,
+            Err(Error::Rejected { hook, .. }) if hook == "false"
+        ));
+    }
+}
@@ -0,0 +1,113 @@
+//! Coordinates concurrent runs in the same repository. A run holds the lock
+//! for a task from before its outputs are restored until they've been written
+//! to the cache, so that another run waiting on the same task can restore its
+//! results instead of racing it on the same files.
+
+use std::time::Duration;
+
+use pidlock::{PidFileError, Pidlock, PidlockError};
+use tracing::warn;
+use turbopath::{AbsoluteSystemPath, AbsoluteSystemPathBuf};
+
+use super::task_id::TaskId;
+
+const POLL_INTERVAL: Duration = Duration::from_millis(100);
+
+pub(crate) fn run_lock_path(repo_root: &AbsoluteSystemPath) -> AbsoluteSystemPathBuf {
+    repo_root.join_components(&[".turbo", "locks", "run.pid"])
+}
+
+pub(crate) fn task_lock_path(
+    repo_root: &AbsoluteSystemPath,
+    task_id: &TaskId,
+) -> AbsoluteSystemPathBuf {
+    // Task ids can contain characters that aren't allowed in file names, e.g.
+    // `@repo/ui#build`. Collisions only mean that two tasks take turns.
+    let name = task_id
+        .to_string()
+        .chars()
+        .map(|c| match c {
+            'a'..='z' | 'A'..='Z' | '0'..='9' | '-' | '.' => c,
+            _ => '_',
+        })
+        .collect::<String>();
+    repo_root.join_components(&[".turbo", "locks", &format!("{name}.pid")])
+}
+
+/// Acquires the lock at `path`, waiting for as long as another process holds
+/// it. `on_wait` is called once if the lock is held. Locks left behind by
+/// processes that have exited are taken over.
+///
+/// Failing to take a lock shouldn't fail the run, so errors are logged and
+/// `None` is returned.
+pub(crate) async fn acquire(path: &AbsoluteSystemPath, on_wait: impl FnOnce()) -> Option<Pidlock> {
+    let mut on_wait = Some(on_wait);
+    loop {
+        let mut lock = Pidlock::new(path.as_std_path().to_owned());
+        match lock.acquire() {
+            Ok(()) => return Some(lock),
+            // Another process created the lock file, but may not have written
+            // its pid to it yet
+            Err(
+                PidlockError::AlreadyOwned
+                | PidlockError::LockExists(_)
+                | PidlockError::File(PidFileError::Invalid { .. }),
+            ) => {
+                if let Some(on_wait) = on_wait.take() {
+                    on_wait();
+                }
+                tokio::time::sleep(POLL_INTERVAL).await;
+            }
+            Err(err) => {
+                warn!("unable to lock {path}: {err}");
+                return None;
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod test {
+    use std::sync::{
+        atomic::{AtomicBool, Ordering},
+        Arc,
+    };
+
+    use tempfile::tempdir;
+
+    use super::*;
+
+    #[test]
+    fn test_task_lock_path() {
+        let dir = tempdir().unwrap();
+        let repo_root = AbsoluteSystemPath::from_std_path(dir.path()).unwrap();
+        let path = task_lock_path(repo_root, &TaskId::new("@repo/ui", "build"));
+
+        assert_eq!(path.file_name(), Some("_repo_ui_build.pid"));
+    }
+
+    #[tokio::test]
+    async fn test_acquire_waits_for_release() {
+        let dir = tempdir().unwrap();
+        let repo_root = AbsoluteSystemPath::from_std_path(dir.path()).unwrap();
+        let path = run_lock_path(repo_root);
+        let lock = acquire(&path, || panic!("lock isn't held")).await;
+        assert!(lock.is_some());
+
+        let waited = Arc::new(AtomicBool::new(false));
+        let second = tokio::spawn({
+            let waited = waited.clone();
+            async move {
+                acquire(&path, || waited.store(true, Ordering::SeqCst))
+                    .await
+                    .is_some()
+            }
+        });
+        tokio::time::sleep(POLL_INTERVAL * 2).await;
+        assert!(waited.load(Ordering::SeqCst));
+        assert!(!second.is_finished());
+
+        drop(lock);
+        assert!(second.await.unwrap());
+    }
+}
@@ -1,17 +1,24 @@
 #![allow(dead_code)]
 
+pub(crate) mod affected;
 mod cache;
+pub(crate) mod cleanup;
+mod engines;
 mod error;
 pub(crate) mod global_hash;
 mod graph_visualizer;
+pub(crate) mod hooks;
+pub(crate) mod lock;
 pub(crate) mod package_discovery;
+mod prefetch;
 mod scope;
 pub(crate) mod summary;
 pub mod task_access;
 pub mod task_id;
+pub(crate) mod task_status;
 
 use std::{
-    collections::HashSet,
+    collections::{BTreeMap, BTreeSet, HashMap, HashSet},
     io::{ErrorKind, IsTerminal, Write},
     sync::Arc,
     time::SystemTime,
@@ -20,11 +27,14 @@ use std::{
 pub use cache::{ConfigCache, RunCache, TaskCache};
 use chrono::{DateTime, Local};
 use rayon::iter::ParallelBridge;
-use tracing::debug;
+use serde::Serialize;
+use svix_ksuid::{Ksuid, KsuidLike};
+use tokio::task::JoinHandle;
+use tracing::{debug, warn};
 use turbopath::{AbsoluteSystemPathBuf, AnchoredSystemPath};
 use turborepo_analytics::{start_analytics, AnalyticsHandle, AnalyticsSender};
 use turborepo_api_client::{APIAuth, APIClient};
-use turborepo_cache::{AsyncCache, RemoteCacheOpts};
+use turborepo_cache::{AsyncCache, CacheOpts, Provenance, RemoteCacheOpts};
 use turborepo_ci::Vendor;
 use turborepo_env::EnvironmentVariableMap;
 use turborepo_errors::Spanned;
@@ -39,10 +49,14 @@ use turborepo_telemetry::events::{
     repo::{RepoEventBuilder, RepoType},
     EventBuilder, TrackedErrors,
 };
-use turborepo_ui::{cprint, cprintln, ColorSelector, BOLD_GREY, GREY, UI};
+use turborepo_ui::{
+    cprint, cprintln,
+    tui::{AppExit, AppReceiver, AppSender},
+    ColorSelector, BOLD_GREY, GREY, UI,
+};
 #[cfg(feature = "daemon-package-discovery")]
 use {
-    crate::run::package_discovery::DaemonPackageDiscovery,
+    crate::run::package_discovery::{DaemonPackageConfigs, DaemonPackageDiscovery},
     std::time::Duration,
     turborepo_repository::discovery::{
         Error as DiscoveryError, FallbackPackageDiscovery, LocalPackageDiscoveryBuilder,
@@ -51,15 +65,23 @@ use {
 };
 
 use self::task_id::TaskName;
-pub use crate::run::error::Error;
+pub use crate::run::error::{Error, FailureKind};
 use crate::{
-    cli::{DryRunMode, EnvMode},
+    cli::{AffectedGranularity, ConcurrentRuns, DryRunMode, EnvMode, UIMode},
     commands::CommandBase,
     daemon::DaemonConnector,
-    engine::{Engine, EngineBuilder},
+    engine::{Engine, EngineBuilder, TaskNode},
     opts::Opts,
     process::ProcessManager,
-    run::{global_hash::get_global_hash_inputs, summary::RunTracker, task_access::TaskAccess},
+    run::{
+        affected::AffectedFiles,
+        error::EngineMismatchError,
+        global_hash::get_global_hash_inputs,
+        hooks::{Hooks, PostRunPayload, PreRunPayload},
+        summary::{RunTracker, SCMState},
+        task_access::TaskAccess,
+        task_status::{TaskStatusListener, TaskStatusPublisher},
+    },
     shim::TurboState,
     signal::{SignalHandler, SignalSubscriber},
     task_graph::Visitor,
@@ -67,6 +89,27 @@ use crate::{
     turbo_json::TurboJson,
 };
 
+/// The output of `turbo run --hash-only`
+#[derive(Serialize)]
+#[serde(rename_all = "camelCase")]
+struct TaskHashes<'a> {
+    global_hash: &'a str,
+    tasks: BTreeMap<String, String>,
+}
+
+// How long to wait for the last task status updates to be sent to the daemon
+const TASK_STATUS_FLUSH_TIMEOUT: std::time::Duration = std::time::Duration::from_secs(1);
+// How often the number of cache writes left is updated while shutting down
+const CACHE_PROGRESS_INTERVAL: std::time::Duration = std::time::Duration::from_millis(250);
+
+fn cache_writes_message(pending: usize) -> String {
+    match pending {
+        0 => "...Finishing writing to cache...".to_string(),
+        1 => "...Waiting for 1 cache write to finish...".to_string(),
+        pending => format!("...Waiting for {pending} cache writes to finish..."),
+    }
+}
+
 pub struct Run {
     processes: ProcessManager,
     opts: Opts,
@@ -74,6 +117,7 @@ pub struct Run {
     repo_root: AbsoluteSystemPathBuf,
     ui: UI,
     version: &'static str,
+    task_status_listener: Option<TaskStatusListener>,
 }
 
 impl Run {
@@ -82,25 +126,42 @@ impl Run {
         let mut opts: Opts = base.args().try_into()?;
         let config = base.config()?;
         let is_linked = turborepo_api_client::is_linked(&api_auth);
-        if !is_linked {
+        // A custom provider is used in place of, or alongside, the linked Vercel team
+        let has_custom_provider = config.has_custom_remote_cache();
+        if !is_linked && !has_custom_provider {
             opts.cache_opts.skip_remote = true;
         } else if let Some(enabled) = config.enabled {
             // We're linked, but if the user has explicitly enabled or disabled, use that
-            // value
-            opts.cache_opts.skip_remote = !enabled;
+            // value. `--no-remote` still takes precedence.
+            opts.cache_opts.skip_remote |= !enabled;
         }
         // Note that we don't currently use the team_id value here. In the future, we
         // should probably verify that we only use the signature value when the
         // configured team_id matches the final resolved team_id.
         let unused_remote_cache_opts_team_id = config.team_id().map(|team_id| team_id.to_string());
         let signature = config.signature();
-        opts.cache_opts.remote_cache_opts = Some(RemoteCacheOpts::new(
-            unused_remote_cache_opts_team_id,
-            signature,
-        ));
+        opts.cache_opts.remote_cache_opts = Some(
+            RemoteCacheOpts::new(
+                unused_remote_cache_opts_team_id,
+                signature,
+                config.remote_cache_provider().cloned(),
+                config.upload_chunk_size(),
+            )
+            .with_fallbacks(
+                config.remote_cache_fallbacks().to_vec(),
+                config.remote_cache_write_policy(),
+            ),
+        );
         if opts.run_opts.experimental_space_id.is_none() {
             opts.run_opts.experimental_space_id = config.spaces_id().map(|s| s.to_owned());
         }
+        if opts.scope_opts.legacy_filter.since_default_branch() {
+            let default_branch = SCM::new(&base.repo_root)
+                .default_branch(&base.repo_root)
+                .map_err(Error::DefaultBranch)?;
+            debug!("comparing against default branch {default_branch}");
+            opts.scope_opts.legacy_filter.set_since(default_branch);
+        }
         let version = base.version();
         let CommandBase { repo_root, ui, .. } = base;
         Ok(Self {
@@ -110,9 +171,31 @@ impl Run {
             repo_root,
             ui,
             version,
+            task_status_listener: None,
         })
     }
 
+    /// Calls `listener` with the status of each task as the run progresses
+    pub fn with_task_status_listener(mut self, listener: TaskStatusListener) -> Self {
+        self.task_status_listener = Some(listener);
+        self
+    }
+
+    // Recorded with the artifacts this run writes, so that they can be traced
+    // back to the commit and machine that built them
+    fn provenance(&self, scm: &SCM) -> Provenance {
+        let scm_state = SCMState::get(&EnvironmentVariableMap::infer(), scm, &self.repo_root);
+        Provenance {
+            git_sha: scm_state.sha,
+            git_branch: scm_state.branch,
+            turbo_version: Some(self.version.to_string()),
+            hostname: hostname::get()
+                .ok()
+                .and_then(|hostname| hostname.into_string().ok()),
+            platform: Some(TurboState::platform_name().to_string()),
+        }
+    }
+
     fn connect_process_manager(&self, signal_subscriber: SignalSubscriber) {
         let manager = self.processes.clone();
         tokio::spawn(async move {
@@ -121,19 +204,57 @@ impl Run {
         });
     }
 
+    // Runs the terminal UI on its own thread. Pressing Ctrl-C in the UI doesn't
+    // send an interrupt, so the tasks of the run are stopped from here instead.
+    fn start_ui(&self, engine: &Engine, receiver: AppReceiver) -> JoinHandle<()> {
+        let tasks = engine
+            .tasks()
+            .filter_map(|task| match task {
+                TaskNode::Task(task_id) => Some(task_id.to_string()),
+                TaskNode::Root => None,
+            })
+            .collect();
+        let manager = self.processes.clone();
+        tokio::spawn(async move {
+            let exit =
+                tokio::task::spawn_blocking(move || turborepo_ui::tui::run_app(tasks, receiver))
+                    .await;
+            match exit {
+                Ok(Ok(AppExit::Interrupted)) => manager.stop().await,
+                Ok(Ok(AppExit::Finished)) => {}
+                Ok(Err(e)) => warn!("terminal UI exited early: {e}"),
+                Err(e) => warn!("terminal UI panicked: {e}"),
+            }
+        })
+    }
+
     fn initialize_analytics(
         api_auth: Option<APIAuth>,
         api_client: APIClient,
+        run_id: Ksuid,
     ) -> Option<(AnalyticsSender, AnalyticsHandle)> {
         // If there's no API auth, we don't want to record analytics
         let api_auth = api_auth?;
         api_auth
             .is_linked()
-            .then(|| start_analytics(api_auth, api_client))
+            .then(|| start_analytics(api_auth, api_client, Some(run_id.to_string())))
     }
 
-    fn print_run_prelude(&self, filtered_pkgs: &HashSet<PackageName>) {
+    fn print_run_prelude(
+        &self,
+        filtered_pkgs: &HashSet<PackageName>,
+        inferred_package: Option<&str>,
+    ) {
         let targets_list = self.opts.run_opts.tasks.join(", ");
+        if let Some(inferred_package) = inferred_package {
+            cprintln!(
+                self.ui,
+                GREY,
+                "• Scoped to {} since turbo was invoked from its directory. Pass \
+                 --no-package-inference to run in every package",
+                inferred_package
+            );
+        }
         if self.opts.run_opts.single_package {
             cprint!(self.ui, GREY, "{}", "• Running");
             cprint!(self.ui, BOLD_GREY, " {}\n", targets_list);
@@ -153,6 +274,9 @@ impl Run {
             cprint!(self.ui, BOLD_GREY, "{}", targets_list);
             cprint!(self.ui, GREY, " in {} packages\n", filtered_pkgs.len());
         }
+        if let Some(shard) = self.opts.run_opts.shard {
+            cprintln!(self.ui, GREY, "• Running shard {}", shard);
+        }
 
         let use_http_cache = !self.opts.cache_opts.skip_remote;
         if use_http_cache {
@@ -162,6 +286,43 @@ impl Run {
         }
     }
 
+    // Warns about, or with --strict-engines fails on, packages in scope whose
+    // `engines` aren't satisfied by the installed node and npm
+    fn check_engines(
+        &self,
+        pkg_dep_graph: &PackageGraph,
+        filtered_pkgs: &HashSet<PackageName>,
+    ) -> Result<(), Error> {
+        let packages = filtered_pkgs
+            .iter()
+            .chain(std::iter::once(&PackageName::Root))
+            .collect::<HashSet<_>>()
+            .into_iter()
+            .filter_map(|package| Some((package, pkg_dep_graph.package_json(package)?)))
+            .filter(|(_, package_json)| package_json.engines.is_some())
+            .collect::<Vec<_>>();
+        // Avoid spawning node and npm unless there's something to check
+        if packages.is_empty() {
+            return Ok(());
+        }
+
+        let mismatches =
+            engines::check_engines(packages.into_iter(), &engines::EngineVersions::detect());
+        if mismatches.is_empty() {
+            return Ok(());
+        }
+        if self.opts.run_opts.strict_engines {
+            return Err(Error::Engines(
+                mismatches.into_iter().map(EngineMismatchError).collect(),
+            ));
+        }
+        if let Err(err) = engines::write_engine_warnings(self.ui, &mismatches) {
+            debug!("failed to write engine warnings: {err}");
+        }
+
+        Ok(())
+    }
+
     #[tracing::instrument(skip(self, signal_handler, api_client))]
     pub async fn run(
         &self,
@@ -169,7 +330,9 @@ impl Run {
         telemetry: CommandEventBuilder,
         api_client: APIClient,
     ) -> Result<i32, Error> {
+        let run_id = Ksuid::new(None, None);
         tracing::trace!(
+            run_id = %run_id,
             platform = %TurboState::platform_name(),
             start_time = SystemTime::now().duration_since(SystemTime::UNIX_EPOCH).expect("system time after epoch").as_micros(),
             turbo_version = %TurboState::version(),
@@ -177,16 +340,18 @@ impl Run {
             "performing run on {:?}",
             TurboState::platform_name(),
         );
+        debug!("run id: {}", run_id);
         let start_at = Local::now();
         if let Some(subscriber) = signal_handler.subscribe() {
             self.connect_process_manager(subscriber);
         }
 
         let (analytics_sender, analytics_handle) =
-            Self::initialize_analytics(self.api_auth.clone(), api_client.clone()).unzip();
+            Self::initialize_analytics(self.api_auth.clone(), api_client.clone(), run_id).unzip();
 
         let result = self
             .run_with_analytics(
+                run_id,
                 start_at,
                 api_client,
                 analytics_sender,
@@ -206,6 +371,7 @@ impl Run {
     // to close the AnalyticsHandle regardless of whether the run succeeds or not
     async fn run_with_analytics(
         &self,
+        run_id: Ksuid,
         start_at: DateTime<Local>,
         api_client: APIClient,
         analytics_sender: Option<AnalyticsSender>,
@@ -282,9 +448,26 @@ impl Run {
             }
         };
 
+        // The daemon memoizes parsing package.json and turbo.json files, so we use
+        // its results when they're available instead of parsing every file again
+        #[cfg(feature = "daemon-package-discovery")]
+        let mut daemon_configs = match (&daemon, is_single_package) {
+            (Some(daemon), false) => DaemonPackageConfigs::fetch(daemon)
+                .await
+                .map_err(|e| debug!("failed to fetch package configs from daemon: {e}"))
+                .ok(),
+            _ => None,
+        };
+
         let mut pkg_dep_graph = {
             let builder = PackageGraph::builder(&self.repo_root, root_package_json.clone())
                 .with_single_package_mode(self.opts.run_opts.single_package);
+            #[cfg(feature = "daemon-package-discovery")]
+            let builder = builder.with_package_jsons(
+                daemon_configs
+                    .as_mut()
+                    .map(|configs| std::mem::take(&mut configs.package_jsons)),
+            );
 
             #[cfg(feature = "daemon-package-discovery")]
             let graph = match (&daemon, self.opts.run_opts.daemon) {
@@ -355,13 +538,55 @@ impl Run {
         run_telemetry.track_run_type(self.opts.run_opts.dry_run.is_some());
 
         let scm = scm.await.expect("detecting scm panicked");
+        let cache_opts = CacheOpts {
+            provenance: Some(self.provenance(&scm)),
+            ..self.opts.cache_opts.clone()
+        };
         let async_cache = AsyncCache::new(
-            &self.opts.cache_opts,
+            &cache_opts,
             &self.repo_root,
             api_client.clone(),
             self.api_auth.clone(),
             analytics_sender,
         )?;
+        // Held until the run finishes
+        let _run_lock = if self.opts.run_opts.concurrent_runs == ConcurrentRuns::Queue
+            && self.opts.run_opts.dry_run.is_none()
+            && !self.opts.run_opts.hash_only
+        {
+            lock::acquire(&lock::run_lock_path(&self.repo_root), || {
+                cprintln!(
+                    self.ui,
+                    GREY,
+                    "• Waiting for other queued runs in this repository to finish"
+                );
+            })
+            .await
+        } else {
+            None
+        };
+        if self.opts.run_opts.dry_run.is_none() {
+            let resumed = async_cache.resume_interrupted_writes().await?;
+            if resumed > 0 {
+                cprintln!(
+                    self.ui,
+                    GREY,
+                    "• Resumed {} cache writes left unfinished by an interrupted run",
+                    resumed
+                );
+            }
+            // Nothing has started writing logs yet, so none of them are in use
+            if let Some(max_age) = self.opts.cache_opts.max_age {
+                let package_dirs = pkg_dep_graph
+                    .packages()
+                    .map(|(_, info)| info.package_path());
+                let removed = cleanup::clean_up(&self.repo_root, package_dirs, max_age);
+                debug!(
+                    "removed {} stale files ({} bytes) from .turbo directories",
+                    removed.files, removed.bytes
+                );
+            }
+        }
 
         // restore config from task access trace if it's enabled
         let task_access = TaskAccess::new(self.repo_root.clone(), async_cache.clone(), &scm);
@@ -403,13 +628,32 @@ impl Run {
             filtered_pkgs
         };
 
+        #[cfg(feature = "daemon-package-discovery")]
+        let workspace_turbo_jsons = daemon_configs
+            .map(|mut configs| configs.turbo_jsons(&self.repo_root, &pkg_dep_graph))
+            .unwrap_or_default();
+        #[cfg(not(feature = "daemon-package-discovery"))]
+        let workspace_turbo_jsons = HashMap::new();
+
         let env_at_execution_start = EnvironmentVariableMap::infer();
-        let mut engine = self.build_engine(&pkg_dep_graph, &root_turbo_json, &filtered_pkgs)?;
+        let mut engine = self.build_engine(
+            &pkg_dep_graph,
+            &root_turbo_json,
+            &workspace_turbo_jsons,
+            &filtered_pkgs,
+        )?;
 
-        if self.opts.run_opts.dry_run.is_none() && self.opts.run_opts.graph.is_none() {
-            self.print_run_prelude(&filtered_pkgs);
+        if self.opts.run_opts.dry_run.is_none()
+            && self.opts.run_opts.graph.is_none()
+            && !self.opts.run_opts.hash_only
+        {
+            let inferred_package =
+                scope::inferred_package(&self.opts.scope_opts, &self.repo_root, &pkg_dep_graph);
+            self.print_run_prelude(&filtered_pkgs, inferred_package.as_deref());
         }
 
+        self.check_engines(&pkg_dep_graph, &filtered_pkgs)?;
+
         let root_workspace = pkg_dep_graph
             .package_info(&PackageName::Root)
             .expect("must have root workspace");
@@ -419,12 +663,23 @@ impl Run {
         let root_external_dependencies_hash =
             is_monorepo.then(|| get_external_deps_hash(&root_workspace.transitive_dependencies));
 
+        // Globs passed with `--global-deps` are hashed alongside the ones from
+        // `globalDependencies`
+        let global_deps = root_turbo_json
+            .global_deps
+            .iter()
+            .chain(&self.opts.scope_opts.global_deps)
+            .cloned()
+            .collect::<BTreeSet<_>>()
+            .into_iter()
+            .collect::<Vec<_>>();
+
         let mut global_hash_inputs = get_global_hash_inputs(
             root_external_dependencies_hash.as_deref(),
             &self.repo_root,
             pkg_dep_graph.package_manager(),
             pkg_dep_graph.lockfile(),
-            &root_turbo_json.global_deps,
+            &global_deps,
             &env_at_execution_start,
             &root_turbo_json.global_env,
             root_turbo_json.global_pass_through_env.as_deref(),
@@ -440,6 +695,30 @@ impl Run {
 
         let color_selector = ColorSelector::default();
 
+        // The terminal UI is only shown for tasks that execute and only when
+        // someone is watching
+        let ui = (self.opts.run_opts.ui_mode == UIMode::Tui
+            && self.opts.run_opts.dry_run.is_none()
+            && !self.opts.run_opts.hash_only
+            && !self.opts.run_opts.json
+            && std::io::stdout().is_terminal())
+        .then(AppSender::new);
+        let task_status_listener = match &ui {
+            Some((sender, _)) => Some(task_status::ui_listener(
+                sender.clone(),
+                self.task_status_listener.clone(),
+            )),
+            None => self.task_status_listener.clone(),
+        };
+
+        // Subscribers to task status are only interested in tasks that execute
+        let task_status = Some((daemon.clone(), task_status_listener))
+            .filter(|_| self.opts.run_opts.dry_run.is_none() && !self.opts.run_opts.hash_only)
+            .filter(|(client, listener)| client.is_some() || listener.is_some())
+            .map(|(client, listener)| {
+                TaskStatusPublisher::new(client, listener, run_id.to_string())
+            });
+
         let runcache = Arc::new(RunCache::new(
             async_cache,
             &self.repo_root,
@@ -452,9 +731,25 @@ impl Run {
         if let Some(subscriber) = signal_handler.subscribe() {
             let runcache = runcache.clone();
             tokio::spawn(async move {
-                let _guard = subscriber.listen().await;
-                let spinner = turborepo_ui::start_spinner("...Finishing writing to cache...");
-                runcache.shutdown_cache().await;
+                let guard = subscriber.listen().await;
+                // An interrupted run shouldn't wait on large transfers, the uploads
+                // that are cut short are resumed by the next run
+                if guard.is_interrupt() {
+                    runcache.cancel_cache();
+                }
+                let spinner =
+                    turborepo_ui::start_spinner(&cache_writes_message(runcache.pending_writes()));
+                let shutdown = runcache.shutdown_cache();
+                tokio::pin!(shutdown);
+                let mut progress = tokio::time::interval(CACHE_PROGRESS_INTERVAL);
+                loop {
+                    tokio::select! {
+                        _ = &mut shutdown => break,
+                        _ = progress.tick() => {
+                            spinner.set_message(cache_writes_message(runcache.pending_writes()));
+                        }
+                    }
+                }
                 spinner.finish_and_clear();
             });
         }
@@ -478,13 +773,19 @@ impl Run {
 
         if self.opts.run_opts.parallel {
             pkg_dep_graph.remove_package_dependencies();
-            engine = self.build_engine(&pkg_dep_graph, &root_turbo_json, &filtered_pkgs)?;
+            engine = self.build_engine(
+                &pkg_dep_graph,
+                &root_turbo_json,
+                &workspace_turbo_jsons,
+                &filtered_pkgs,
+            )?;
         }
 
         if let Some(graph_opts) = &self.opts.run_opts.graph {
             graph_visualizer::write_graph(
                 self.ui,
                 graph_opts,
+                self.opts.run_opts.graph_mode,
                 &engine,
                 self.opts.run_opts.single_package,
                 // Note that cwd used to be pulled from CommandBase, which had it set
@@ -508,6 +809,7 @@ impl Run {
         };
 
         let run_tracker = RunTracker::new(
+            run_id,
             start_at,
             self.opts.synthesize_command(),
             self.opts.scope_opts.pkg_inference_root.as_deref(),
@@ -521,9 +823,33 @@ impl Run {
             &scm,
         );
 
+        let command = self.opts.synthesize_command();
+        let run_id_str = run_id.to_string();
+        // Hooks only run when tasks are going to be executed
+        let hooks = (self.opts.run_opts.dry_run.is_none() && !self.opts.run_opts.hash_only)
+            .then(|| Arc::new(Hooks::new(&self.repo_root, root_turbo_json.hooks.clone())))
+            .filter(|hooks| !hooks.is_empty());
+        if let Some(hooks) = &hooks {
+            let mut tasks = engine
+                .tasks()
+                .filter_map(|task| match task {
+                    TaskNode::Task(task_id) => Some(task_id.to_string()),
+                    TaskNode::Root => None,
+                })
+                .collect::<Vec<_>>();
+            tasks.sort();
+            hooks
+                .pre_run(&PreRunPayload {
+                    run_id: &run_id_str,
+                    command: &command,
+                    tasks,
+                })
+                .await?;
+        }
+
         let mut visitor = Visitor::new(
             pkg_dep_graph.clone(),
-            runcache,
+            runcache.clone(),
             run_tracker,
             task_access,
             &self.opts.run_opts,
@@ -535,24 +861,107 @@ impl Run {
             self.processes.clone(),
             &self.repo_root,
             global_env,
+            &scm,
         );
 
         if self.opts.run_opts.dry_run.is_some() {
             visitor.dry_run();
         }
 
+        if self.opts.run_opts.hash_only {
+            visitor.hash_only();
+        }
+
+        if let Some(hooks) = &hooks {
+            visitor.hooks(hooks.clone());
+        }
+
+        let task_status_handle = task_status.map(|(publisher, handle)| {
+            visitor.task_status(publisher);
+            handle
+        });
+
+        if self.opts.run_opts.affected_granularity == AffectedGranularity::File {
+            visitor.affected_files(AffectedFiles::new(
+                &self.repo_root,
+                &scm,
+                &pkg_dep_graph,
+                &self.opts.scope_opts.get_filters(),
+            )?);
+        }
+
         // we look for this log line to mark the start of the run
         // in benchmarks, so please don't remove it
         debug!("running visitor");
 
-        let errors = visitor.visit(engine.clone(), &run_telemetry).await?;
+        let ui = ui.map(|(sender, receiver)| {
+            visitor.tui(sender.clone());
+            (sender, self.start_ui(&engine, receiver))
+        });
+
+        let errors = visitor.visit(engine.clone(), &run_telemetry).await;
+
+        // The terminal has to be restored before anything else is printed
+        if let Some((sender, handle)) = ui {
+            sender.stop();
+            handle.await.ok();
+        }
+        let errors = errors?;
+
+        if self.opts.run_opts.prefetch {
+            // Tasks that don't cache their results have nothing to download
+            let hashes = visitor
+                .task_hashes()
+                .into_iter()
+                .filter(|(task_id, _)| {
+                    engine
+                        .task_definition(task_id)
+                        .map_or(false, |definition| definition.cache)
+                })
+                .collect();
+            let summary =
+                prefetch::prefetch(&runcache, hashes, self.opts.cache_opts.workers as usize).await;
+            if self.opts.run_opts.json {
+                let json = serde_json::to_string_pretty(&summary).map_err(Error::Prefetch)?;
+                println!("{json}");
+            } else {
+                summary.print(self.ui);
+            }
+            return Ok(0);
+        }
+
+        if self.opts.run_opts.hash_only {
+            let hashes = TaskHashes {
+                global_hash: &global_hash,
+                tasks: visitor
+                    .task_hashes()
+                    .into_iter()
+                    .map(|(task_id, hash)| (task_id.to_string(), hash))
+                    .collect(),
+            };
+            let json = serde_json::to_string_pretty(&hashes).map_err(Error::HashOnly)?;
+            println!("{json}");
+            return Ok(0);
+        }
 
+        // A failing task takes precedence over a task that couldn't be spawned
+        let failure = if errors
+            .iter()
+            .any(|err| err.failure_kind() == FailureKind::Task)
+        {
+            Some(FailureKind::Task)
+        } else {
+            (!errors.is_empty()).then_some(FailureKind::Infrastructure)
+        };
         let exit_code = errors
             .iter()
             .filter_map(|err| err.exit_code())
             .max()
             // We hit some error, it shouldn't be exit code 0
-            .unwrap_or(if errors.is_empty() { 0 } else { 1 });
+            .unwrap_or(match failure {
+                Some(_) => FailureKind::INFRASTRUCTURE_EXIT_CODE,
+                None => 0,
+            });
 
         let error_prefix = if self.opts.run_opts.is_github_actions {
             "::error::"
@@ -566,6 +975,7 @@ impl Run {
         visitor
             .finish(
                 exit_code,
+                failure,
                 filtered_pkgs,
                 global_hash_inputs,
                 &engine,
@@ -574,13 +984,30 @@ impl Run {
             )
             .await?;
 
+        if let Some(hooks) = &hooks {
+            hooks
+                .post_run(&PostRunPayload {
+                    run_id: &run_id_str,
+                    command: &command,
+                    exit_code,
+                })
+                .await;
+        }
+
+        // Give the last updates a chance to reach the daemon and listener
+        if let Some(handle) = task_status_handle {
+            let _ = tokio::time::timeout(TASK_STATUS_FLUSH_TIMEOUT, handle).await;
+        }
+
         Ok(exit_code)
     }
 
+    #[tracing::instrument(skip_all)]
     fn build_engine(
         &self,
         pkg_dep_graph: &PackageGraph,
         root_turbo_json: &TurboJson,
+        workspace_turbo_jsons: &HashMap<PackageName, TurboJson>,
         filtered_pkgs: &HashSet<PackageName>,
     ) -> Result<Engine, Error> {
         let engine = EngineBuilder::new(
@@ -590,8 +1017,10 @@ impl Run {
         )
         .with_root_tasks(root_turbo_json.pipeline.keys().cloned())
         .with_turbo_jsons(Some(
-            Some((PackageName::Root, root_turbo_json.clone()))
+            workspace_turbo_jsons
+                .clone()
                 .into_iter()
+                .chain(Some((PackageName::Root, root_turbo_json.clone())))
                 .collect(),
         ))
         .with_tasks_only(self.opts.run_opts.only)
@@ -601,6 +1030,10 @@ impl Run {
             Spanned::new(TaskName::from(task.as_str()).into_owned())
         }))
         .build()?;
+        let engine = match self.opts.run_opts.shard {
+            Some(shard) => engine.shard(shard.index, shard.total),
+            None => engine,
+        };
 
         if !self.opts.run_opts.parallel {
             engine
@@ -1,7 +1,16 @@
-use turbopath::AbsoluteSystemPathBuf;
-use turborepo_repository::discovery::{DiscoveryResponse, Error, PackageDiscovery, WorkspaceData};
+use std::collections::HashMap;
 
-use crate::daemon::{proto::PackageManager, DaemonClient};
+use turbopath::{AbsoluteSystemPath, AbsoluteSystemPathBuf};
+use turborepo_repository::{
+    discovery::{DiscoveryResponse, Error, PackageDiscovery, WorkspaceData},
+    package_graph::{PackageGraph, PackageName},
+    package_json::PackageJson,
+};
+
+use crate::{
+    daemon::{proto::PackageManager, DaemonClient},
+    turbo_json::{RawTurboJson, TurboJson},
+};
 
 #[derive(Debug)]
 pub struct DaemonPackageDiscovery<C> {
@@ -71,3 +80,66 @@ impl<C: Clone + Send + Sync> PackageDiscovery for DaemonPackageDiscovery<C> {
         })
     }
 }
+
+/// The package.json and turbo.json files of every workspace, already parsed
+/// by the daemon.
+#[derive(Debug, Default)]
+pub struct DaemonPackageConfigs {
+    pub package_jsons: HashMap<AbsoluteSystemPathBuf, PackageJson>,
+    // Keyed by the path of the package.json of the workspace they belong to.
+    // Workspaces whose turbo.json the daemon couldn't validate are left out so
+    // that the engine builder reads them and reports the error.
+    turbo_jsons: HashMap<AbsoluteSystemPathBuf, TurboJson>,
+}
+
+impl DaemonPackageConfigs {
+    pub async fn fetch<C: Clone>(daemon: &DaemonClient<C>) -> Result<Self, Error> {
+        tracing::debug!("fetching package configs from daemon");
+
+        let mut daemon = daemon.clone();
+        let package_configs = daemon
+            .get_package_configs()
+            .await
+            .map_err(|e| Error::Failed(Box::new(e)))?;
+
+        let mut configs = Self::default();
+        for config in package_configs {
+            let package_json_path =
+                AbsoluteSystemPathBuf::new(config.package_json_path).expect("absolute");
+            let package_json = PackageJson::parse_structured(&config.package_json)
+                .map_err(|e| Error::Failed(Box::new(e)))?;
+            if let Some(turbo_json) = config.turbo_json {
+                let raw_turbo_json: RawTurboJson =
+                    serde_json::from_str(&turbo_json).map_err(|e| Error::Failed(Box::new(e)))?;
+                let turbo_json =
+                    TurboJson::try_from(raw_turbo_json).map_err(|e| Error::Failed(Box::new(e)))?;
+                configs
+                    .turbo_jsons
+                    .insert(package_json_path.clone(), turbo_json);
+            }
+            configs
+                .package_jsons
+                .insert(package_json_path, package_json);
+        }
+
+        Ok(configs)
+    }
+
+    /// Returns the workspace turbo.json files keyed by the workspace they
+    /// belong to
+    pub fn turbo_jsons(
+        &mut self,
+        repo_root: &AbsoluteSystemPath,
+        package_graph: &PackageGraph,
+    ) -> HashMap<PackageName, TurboJson> {
+        package_graph
+            .packages()
+            .filter(|(name, _)| **name != PackageName::Root)
+            .filter_map(|(name, info)| {
+                let package_json_path = repo_root.resolve(info.package_json_path());
+                let turbo_json = self.turbo_jsons.remove(&package_json_path)?;
+                Some((name.clone(), turbo_json))
+            })
+            .collect()
+    }
+}
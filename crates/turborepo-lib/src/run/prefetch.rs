@@ -0,0 +1,150 @@
+//! Downloads the remote cache artifacts of a run's tasks into the local cache
+//! without executing them, so that CI can warm the cache while dependencies
+//! are still installing.
+
+use std::collections::HashMap;
+
+use futures::{stream, StreamExt};
+use serde::Serialize;
+use tracing::warn;
+use turborepo_cache::{CacheError, CacheHitMetadata, CacheSource};
+use turborepo_ui::{cprintln, GREY, UI};
+
+use super::{task_id::TaskId, RunCache};
+
+/// The task ids of a prefetch grouped by what happened to their artifacts
+#[derive(Debug, Default, PartialEq, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub(crate) struct PrefetchSummary {
+    pub downloaded: Vec<String>,
+    // Already in the local cache
+    pub cached: Vec<String>,
+    // Not in the remote cache, these tasks will be executed by the next run
+    pub missing: Vec<String>,
+    pub failed: Vec<String>,
+}
+
+impl PrefetchSummary {
+    fn record(&mut self, task_id: String, result: Result<Option<CacheHitMetadata>, CacheError>) {
+        match result {
+            Ok(Some(CacheHitMetadata {
+                source: CacheSource::Remote,
+                ..
+            })) => self.downloaded.push(task_id),
+            Ok(Some(_)) => self.cached.push(task_id),
+            Ok(None) => self.missing.push(task_id),
+            // The next run falls back to fetching the artifact itself
+            Err(err) => {
+                warn!("failed to prefetch {task_id}: {err}");
+                self.failed.push(task_id);
+            }
+        }
+    }
+
+    pub fn print(&self, ui: UI) {
+        cprintln!(
+            ui,
+            GREY,
+            "• Downloaded {} artifacts into the local cache",
+            self.downloaded.len()
+        );
+        if !self.cached.is_empty() {
+            cprintln!(
+                ui,
+                GREY,
+                "• {} artifacts were already in the local cache",
+                self.cached.len()
+            );
+        }
+        if !self.missing.is_empty() {
+            cprintln!(
+                ui,
+                GREY,
+                "• {} tasks have no artifact in the remote cache",
+                self.missing.len()
+            );
+        }
+        if !self.failed.is_empty() {
+            cprintln!(
+                ui,
+                GREY,
+                "• {} artifacts failed to download",
+                self.failed.len()
+            );
+        }
+    }
+}
+
+/// Downloads the artifact for each task hash, with up to `workers` downloads
+/// at a time. Every hash is checked up front so that only artifacts the remote
+/// cache has are downloaded.
+pub(crate) async fn prefetch(
+    cache: &RunCache,
+    hashes: HashMap<TaskId<'static>, String>,
+    workers: usize,
+) -> PrefetchSummary {
+    let mut statuses = cache.exists_all(hashes.values().cloned()).await;
+
+    let mut summary = PrefetchSummary::default();
+    let mut downloads = Vec::new();
+    for (task_id, hash) in hashes {
+        match statuses.remove(&hash) {
+            Some(Ok(Some(CacheHitMetadata {
+                source: CacheSource::Remote,
+                ..
+            }))) => downloads.push((task_id, hash)),
+            // Tasks can share a hash, in which case only the first one is checked
+            None => downloads.push((task_id, hash)),
+            Some(status) => summary.record(task_id.to_string(), status),
+        }
+    }
+
+    let results = stream::iter(downloads)
+        .map(|(task_id, hash)| async move { (task_id, cache.prefetch(&hash).await) })
+        .buffer_unordered(workers.max(1))
+        .collect::<Vec<_>>()
+        .await;
+    for (task_id, result) in results {
+        summary.record(task_id.to_string(), result);
+    }
+    for task_ids in [
+        &mut summary.downloaded,
+        &mut summary.cached,
+        &mut summary.missing,
+        &mut summary.failed,
+    ] {
+        task_ids.sort();
+    }
+
+    summary
+}
+
+#[cfg(test)]
+mod test {
+    use super::*;
+
+    #[test]
+    fn test_record() {
+        let hit = |source| {
+            Ok(Some(CacheHitMetadata {
+                source,
+                time_saved: 0,
+            }))
+        };
+        let mut summary = PrefetchSummary::default();
+        summary.record("web#build".to_string(), hit(CacheSource::Remote));
+        summary.record("docs#build".to_string(), hit(CacheSource::Local));
+        summary.record("web#test".to_string(), Ok(None));
+        summary.record("docs#test".to_string(), Err(CacheError::CacheShuttingDown));
+
+        assert_eq!(
+            summary,
+            PrefetchSummary {
+                downloaded: vec!["web#build".to_string()],
+                cached: vec!["docs#build".to_string()],
+                missing: vec!["web#test".to_string()],
+                failed: vec!["docs#test".to_string()],
+            }
+        );
+    }
+}
@@ -1,5 +1,6 @@
 use std::collections::HashSet;
 
+use tracing::debug;
 use turbopath::{AbsoluteSystemPath, AnchoredSystemPathBuf};
 use turborepo_repository::{
     change_mapper::{
@@ -86,14 +87,24 @@ impl<'a> GitChangeDetector for ScopeChangeDetector<'a> {
         from_ref: &str,
         to_ref: &str,
     ) -> Result<HashSet<PackageName>, ChangeMapError> {
+        // Compare against the point the branch forked from `from_ref` rather
+        // than its tip, so that commits made to `from_ref` since then, e.g.
+        // other merged branches, don't count as changes. The merge base can't
+        // be found in shallow clones without enough history, in which case
+        // `from_ref` itself is used.
+        let mut base_ref = from_ref.to_owned();
         let mut changed_files = HashSet::new();
         if !from_ref.is_empty() {
+            match self.scm.merge_base(self.turbo_root, from_ref, to_ref) {
+                Ok(merge_base) => base_ref = merge_base,
+                Err(err) => debug!("unable to find merge base of {from_ref} and {to_ref}: {err}"),
+            }
             changed_files = self
                 .scm
-                .changed_files(self.turbo_root, Some(from_ref), to_ref)?;
+                .changed_files(self.turbo_root, Some(&base_ref), to_ref)?;
         }
 
-        let lockfile_contents = self.get_lockfile_contents(from_ref, &changed_files);
+        let lockfile_contents = self.get_lockfile_contents(&base_ref, &changed_files);
 
         match self
             .change_mapper
@@ -23,6 +23,12 @@ use crate::{
     turbo_json::TurboJson,
 };
 
+#[derive(Debug, Clone, Copy)]
+enum Walk {
+    Dependencies,
+    Dependents,
+}
+
 pub struct PackageInference {
     package_name: Option<String>,
     directory_root: AnchoredSystemPathBuf,
@@ -71,6 +77,11 @@ impl PackageInference {
         }
     }
 
+    // The package turbo was invoked from, if it was invoked at or within one
+    pub fn into_package_name(self) -> Option<String> {
+        self.package_name
+    }
+
     pub fn apply(&self, selector: &mut TargetSelector) {
         // if the name pattern is provided, do not attempt inference
         if !selector.name_pattern.is_empty() {
@@ -271,6 +282,9 @@ impl<'a, T: GitChangeDetector> FilterResolver<'a, T> {
 
         for selector in selectors {
             let selector_packages = self.filter_graph_with_selector(&selector)?;
+            // `foo...` only selects direct dependencies unless given a depth,
+            // while `...foo` selects all dependents
+            let dependency_depth = Some(selector.dependency_depth.unwrap_or(1));
 
             if selector_packages.is_empty() {
                 unmatched_selectors.push(selector);
@@ -278,41 +292,28 @@ impl<'a, T: GitChangeDetector> FilterResolver<'a, T> {
             }
 
             for package in selector_packages {
-                let node = package_graph::PackageNode::Workspace(package.clone());
-
                 if selector.include_dependencies {
-                    let dependencies = self.pkg_graph.immediate_dependencies(&node);
-                    let dependencies = dependencies
-                        .iter()
-                        .flatten()
-                        .map(|i| i.as_package_name().to_owned())
-                        .collect::<Vec<_>>();
-
-                    // flatmap through the option, the set, and then the optional package name
-                    walked_dependencies.extend(dependencies);
+                    walked_dependencies.extend(self.walk_graph(
+                        &package,
+                        Walk::Dependencies,
+                        dependency_depth,
+                    ));
                 }
 
                 if selector.include_dependents {
-                    let dependents = self.pkg_graph.ancestors(&node);
-                    for dependent in dependents.iter().map(|i| i.as_package_name()) {
-                        walked_dependents.insert(dependent.clone());
-
+                    let dependents =
+                        self.walk_graph(&package, Walk::Dependents, selector.dependent_depth);
+                    for dependent in dependents {
                         // get the dependent's dependencies
                         if selector.include_dependencies {
-                            let dependent_node =
-                                package_graph::PackageNode::Workspace(dependent.to_owned());
-
-                            let dependent_dependencies =
-                                self.pkg_graph.immediate_dependencies(&dependent_node);
-
-                            let dependent_dependencies = dependent_dependencies
-                                .iter()
-                                .flatten()
-                                .map(|i| i.as_package_name().to_owned())
-                                .collect::<HashSet<_>>();
-
-                            walked_dependent_dependencies.extend(dependent_dependencies);
+                            walked_dependent_dependencies.extend(self.walk_graph(
+                                &dependent,
+                                Walk::Dependencies,
+                                dependency_depth,
+                            ));
                         }
+
+                        walked_dependents.insert(dependent);
                     }
                 }
 
@@ -339,6 +340,51 @@ impl<'a, T: GitChangeDetector> FilterResolver<'a, T> {
         Ok(all_packages)
     }
 
+    /// Returns the packages that can be reached from `package` by following at
+    /// most `depth` dependency edges in the given direction, or any number of
+    /// them if `depth` is unset. `package` itself isn't included.
+    ///
+    /// Example:
+    /// a -> b -> c
+    ///
+    /// walk_graph(a, Dependencies, Some(1)) = {b}
+    /// walk_graph(a, Dependencies, None) = {b, c}
+    fn walk_graph(
+        &self,
+        package: &PackageName,
+        walk: Walk,
+        depth: Option<usize>,
+    ) -> HashSet<PackageName> {
+        let start = package_graph::PackageNode::Workspace(package.clone());
+        let mut visited = HashSet::new();
+        let mut frontier = vec![&start];
+        let mut remaining = depth;
+
+        while !frontier.is_empty() && remaining != Some(0) {
+            remaining = remaining.map(|depth| depth - 1);
+            frontier = frontier
+                .into_iter()
+                .filter_map(|node| match walk {
+                    Walk::Dependencies => self.pkg_graph.immediate_dependencies(node),
+                    Walk::Dependents => self.pkg_graph.immediate_ancestors(node),
+                })
+                .flatten()
+                // the synthetic root that packages without dependencies point
+                // to isn't a package that can be selected
+                .filter(|node| {
+                    !matches!(node, package_graph::PackageNode::Root)
+                        && **node != start
+                        && visited.insert(*node)
+                })
+                .collect();
+        }
+
+        visited
+            .into_iter()
+            .map(|node| node.as_package_name().to_owned())
+            .collect()
+    }
+
     fn filter_graph_with_selector(
         &self,
         selector: &TargetSelector,
@@ -816,6 +862,95 @@ mod test {
         &["project-0", "project-1"] ;
         "select dependents excluding package itself"
     )]
+    #[test_case(
+        vec![
+            TargetSelector {
+                include_dependents: true,
+                dependent_depth: Some(1),
+                name_pattern: "project-2".to_string(),
+                ..Default::default()
+            }
+        ],
+        None,
+        &["project-1", "project-2"] ;
+        "select direct dependents"
+    )]
+    #[test_case(
+        vec![
+            TargetSelector {
+                include_dependencies: true,
+                name_pattern: "project-0".to_string(),
+                ..Default::default()
+            }
+        ],
+        None,
+        &["project-0", "project-1", "project-5"] ;
+        "select package with direct dependencies"
+    )]
+    #[test_case(
+        vec![
+            TargetSelector {
+                include_dependencies: true,
+                dependency_depth: Some(1),
+                name_pattern: "project-0".to_string(),
+                ..Default::default()
+            }
+        ],
+        None,
+        &["project-0", "project-1", "project-5"] ;
+        "select package with dependencies up to depth 1"
+    )]
+    #[test_case(
+        vec![
+            TargetSelector {
+                include_dependencies: true,
+                dependency_depth: Some(2),
+                name_pattern: "project-0".to_string(),
+                ..Default::default()
+            }
+        ],
+        None,
+        &["project-0", "project-1", "project-2", "project-4", "project-5"] ;
+        "select package with dependencies up to depth 2"
+    )]
+    #[test_case(
+        vec![
+            TargetSelector {
+                include_dependencies: true,
+                dependency_depth: Some(3),
+                name_pattern: "project-0".to_string(),
+                ..Default::default()
+            }
+        ],
+        None,
+        &["project-0", "project-1", "project-2", "project-3", "project-4", "project-5"] ;
+        "select package with dependencies up to depth 3"
+    )]
+    #[test_case(
+        vec![
+            TargetSelector {
+                include_dependents: true,
+                name_pattern: "project-3".to_string(),
+                ..Default::default()
+            }
+        ],
+        None,
+        &["project-0", "project-1", "project-2", "project-3"] ;
+        "select package with all dependents"
+    )]
+    #[test_case(
+        vec![
+            TargetSelector {
+                include_dependents: true,
+                dependent_depth: Some(2),
+                name_pattern: "project-3".to_string(),
+                ..Default::default()
+            }
+        ],
+        None,
+        &["project-1", "project-2", "project-3"] ;
+        "select package with dependents up to depth 2"
+    )]
     #[test_case(
         vec![
             TargetSelector {
@@ -968,8 +1103,9 @@ mod test {
                 ("packages/project-0", "project-5"),
                 ("packages/project-1", "project-2"),
                 ("packages/project-1", "project-4"),
+                ("project-2", "project-3"),
             ],
-            &["project-3", "project-5/packages/project-6"],
+            &["project-5/packages/project-6"],
             package_inference,
             TestChangeDetector::new(&[]),
         );
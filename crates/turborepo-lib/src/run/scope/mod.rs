@@ -1,7 +1,7 @@
 mod change_detector;
 mod filter;
 mod simple_glob;
-mod target_selector;
+pub(crate) mod target_selector;
 
 use std::collections::HashSet;
 
@@ -35,3 +35,17 @@ pub fn resolve_packages(
     )?
     .resolve(&opts.get_filters())
 }
+
+/// The package the run is scoped to because turbo was invoked from inside of
+/// it, if no filters were passed to scope it otherwise
+pub fn inferred_package(
+    opts: &ScopeOpts,
+    turbo_root: &AbsoluteSystemPath,
+    pkg_graph: &PackageGraph,
+) -> Option<String> {
+    if !opts.get_filters().is_empty() {
+        return None;
+    }
+    let pkg_inference_path = opts.pkg_inference_root.as_ref()?;
+    PackageInference::calculate(turbo_root, pkg_inference_path, pkg_graph).into_package_name()
+}
@@ -9,6 +9,11 @@ pub struct TargetSelector {
     pub include_dependencies: bool,
     pub match_dependencies: bool,
     pub include_dependents: bool,
+    /// How many levels of dependencies to include, only direct dependencies if
+    /// unset
+    pub dependency_depth: Option<usize>,
+    /// How many levels of dependents to include, all of them if unset
+    pub dependent_depth: Option<usize>,
     pub exclude: bool,
     pub exclude_self: bool,
     pub follow_prod_deps_only: bool,
@@ -46,6 +51,21 @@ impl FromStr for TargetSelector {
             None => (false, raw_selector),
         };
 
+        // `foo...2` and `2...foo` limit how far the dependencies and dependents
+        // of `foo` are followed
+        let (selector, dependency_depth) = match selector.rsplit_once("...") {
+            Some((rest, depth)) if is_depth(depth) => {
+                (&selector[..rest.len() + 3], Some(parse_depth(depth)?))
+            }
+            _ => (selector, None),
+        };
+        let (selector, dependent_depth) = match selector.split_once("...") {
+            Some((depth, _)) if is_depth(depth) => {
+                (&selector[depth.len()..], Some(parse_depth(depth)?))
+            }
+            _ => (selector, None),
+        };
+
         let mut exclude_self = false;
         let include_dependencies = selector.strip_suffix("...");
 
@@ -89,6 +109,8 @@ impl FromStr for TargetSelector {
                         exclude,
                         include_dependencies,
                         include_dependents,
+                        dependency_depth,
+                        dependent_depth,
                         parent_dir: relative_path?,
                         raw: raw_selector.to_string(),
                         ..Default::default()
@@ -99,6 +121,8 @@ impl FromStr for TargetSelector {
                         exclude_self,
                         include_dependencies,
                         include_dependents,
+                        dependency_depth,
+                        dependent_depth,
                         name_pattern: selector.to_string(),
                         raw: raw_selector.to_string(),
                         ..Default::default()
@@ -165,6 +189,8 @@ impl FromStr for TargetSelector {
             exclude_self,
             include_dependencies,
             include_dependents,
+            dependency_depth,
+            dependent_depth,
             match_dependencies: pre_add_dependencies,
             name_pattern,
             parent_dir,
@@ -185,6 +211,20 @@ pub enum InvalidSelectorError {
 
     #[error("selector \"{0}\" must have a reference, directory, or name pattern")]
     InvalidSelector(String),
+    #[error("invalid depth \"{0}\": must be a positive number")]
+    InvalidDepth(String),
+}
+
+fn is_depth(depth: &str) -> bool {
+    !depth.is_empty() && depth.bytes().all(|b| b.is_ascii_digit())
+}
+
+fn parse_depth(depth: &str) -> Result<usize, InvalidSelectorError> {
+    depth
+        .parse()
+        .ok()
+        .filter(|depth| *depth > 0)
+        .ok_or_else(|| InvalidSelectorError::InvalidDepth(depth.to_string()))
 }
 
 /// checks if the selector is a filesystem path
@@ -227,6 +267,10 @@ mod test {
     #[test_case("...foo...", TargetSelector { name_pattern: "foo".to_string(), raw: "...foo...".to_string(), include_dependents: true, include_dependencies: true, ..Default::default() }; "dot dot dot foo dot dot dot")]
     #[test_case("foo^...", TargetSelector { name_pattern: "foo".to_string(), raw: "foo^...".to_string(), include_dependencies: true, exclude_self: true, ..Default::default() }; "foo caret dot dot dot")]
     #[test_case("...^foo", TargetSelector { name_pattern: "foo".to_string(), raw: "...^foo".to_string(), include_dependents: true, exclude_self: true, ..Default::default() }; "dot dot dot caret foo")]
+    #[test_case("foo...2", TargetSelector { name_pattern: "foo".to_string(), raw: "foo...2".to_string(), include_dependencies: true, dependency_depth: Some(2), ..Default::default() }; "foo dot dot dot depth")]
+    #[test_case("1...foo", TargetSelector { name_pattern: "foo".to_string(), raw: "1...foo".to_string(), include_dependents: true, dependent_depth: Some(1), ..Default::default() }; "depth dot dot dot foo")]
+    #[test_case("1...^foo^...3", TargetSelector { name_pattern: "foo".to_string(), raw: "1...^foo^...3".to_string(), include_dependents: true, dependent_depth: Some(1), include_dependencies: true, dependency_depth: Some(3), exclude_self: true, ..Default::default() }; "depth dot dot dot caret foo caret dot dot dot depth")]
+    #[test_case("...[master]...2", TargetSelector { raw: "...[master]...2".to_string(), from_ref: "master".to_string(), include_dependencies: true, dependency_depth: Some(2), include_dependents: true, ..Default::default() }; "dot dot dot master square brackets dot dot dot depth")]
     #[test_case("../foo", TargetSelector { raw: "../foo".to_string(), parent_dir: AnchoredSystemPathBuf::try_from(if cfg!(windows) { "..\\foo" } else { "../foo" }).unwrap(), ..Default::default() }; "dot dot slash foo")]
     #[test_case("./foo", TargetSelector { raw: "./foo".to_string(), parent_dir: AnchoredSystemPathBuf::try_from("foo").unwrap(), ..Default::default() }; "dot slash foo")]
     #[test_case("./foo/*", TargetSelector { raw: "./foo/*".to_string(), parent_dir: AnchoredSystemPathBuf::try_from(if cfg!(windows) { "foo\\*" } else { "foo/*" }).unwrap(), ..Default::default() }; "dot slash foo star")]
@@ -263,6 +307,7 @@ mod test {
 
     #[test_case("{}" ; "curly brackets")]
     #[test_case("......[master]" ; "......[master]")]
+    #[test_case("foo...0" ; "zero depth")]
     fn parse_target_selector_invalid(raw_selector: &str) {
         let result = TargetSelector::from_str(raw_selector);
 
@@ -0,0 +1,267 @@
+//! Records how every task used the cache so that `turbo cache stats` can
+//! report on the cache across runs. Each run appends one JSON line per
+//! cacheable task to `.turbo/cache-stats.jsonl`.
+
+use std::{
+    collections::BTreeMap,
+    fs::OpenOptions,
+    io::{self, BufRead, BufReader, Write},
+};
+
+use serde::{Deserialize, Serialize};
+use tracing::debug;
+use turbopath::{AbsoluteSystemPath, AbsoluteSystemPathBuf};
+
+use super::task::{CacheSource, CacheStatus, TaskSummary};
+
+// Once the file grows past this size, the oldest half of it is dropped
+const MAX_FILE_SIZE: u64 = 4 * 1024 * 1024;
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct CacheStatsRecord {
+    pub run_id: String,
+    // Milliseconds since the unix epoch
+    pub ended_at: i64,
+    pub task_id: String,
+    pub task: String,
+    pub hash: String,
+    pub status: CacheStatus,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub source: Option<CacheSource>,
+    pub time_saved: u64,
+    // Bytes uploaded to the remote cache
+    #[serde(default)]
+    pub uploaded_bytes: u64,
+}
+
+impl CacheStatsRecord {
+    // Tasks that can't be cached are left out since they'd always be misses
+    pub(super) fn from_task(run_id: &str, ended_at: i64, task: &TaskSummary) -> Option<Self> {
+        if !task.shared.resolved_task_definition.cache {
+            return None;
+        }
+        Some(Self {
+            run_id: run_id.to_string(),
+            ended_at,
+            task_id: task.task_id.to_string(),
+            task: task.task.clone(),
+            hash: task.shared.hash.clone(),
+            status: task.shared.cache.status,
+            source: task.shared.cache.source,
+            time_saved: task.shared.cache.time_saved,
+            uploaded_bytes: task.shared.upload.map_or(0, |upload| upload.bytes),
+        })
+    }
+}
+
+pub fn stats_path(repo_root: &AbsoluteSystemPath) -> AbsoluteSystemPathBuf {
+    repo_root.join_components(&[".turbo", "cache-stats.jsonl"])
+}
+
+/// Appends the records of a run to the stats file
+pub(super) fn append(
+    repo_root: &AbsoluteSystemPath,
+    records: &[CacheStatsRecord],
+) -> io::Result<()> {
+    if records.is_empty() {
+        return Ok(());
+    }
+    let path = stats_path(repo_root);
+    path.ensure_dir()?;
+
+    let mut lines = Vec::new();
+    for record in records {
+        serde_json::to_writer(&mut lines, record)?;
+        lines.push(b'\n');
+    }
+    let mut options = OpenOptions::new();
+    options.create(true).append(true);
+    // A single write keeps the lines of concurrent runs from interleaving
+    path.open_with_options(options)?.write_all(&lines)?;
+
+    if path.as_std_path().metadata()?.len() > MAX_FILE_SIZE {
+        truncate(&path)?;
+    }
+
+    Ok(())
+}
+
+fn truncate(path: &AbsoluteSystemPath) -> io::Result<()> {
+    let contents = path.read_to_string()?;
+    let lines = contents.lines().collect::<Vec<_>>();
+    let kept = &lines[lines.len() / 2..];
+    debug!(
+        "dropping {} old cache stats records",
+        lines.len() - kept.len()
+    );
+
+    let mut truncated = kept.join("\n");
+    truncated.push('\n');
+    path.create_with_contents(truncated)
+}
+
+/// Reads every record in the stats file. Lines that can't be parsed, such as
+/// one that was cut off by an interrupted write, are skipped.
+pub fn read(repo_root: &AbsoluteSystemPath) -> io::Result<Vec<CacheStatsRecord>> {
+    let file = match stats_path(repo_root).open() {
+        Ok(file) => file,
+        Err(e) if e.kind() == io::ErrorKind::NotFound => return Ok(Vec::new()),
+        Err(e) => return Err(e),
+    };
+
+    let mut records = Vec::new();
+    for line in BufReader::new(file).lines() {
+        if let Ok(record) = serde_json::from_str(&line?) {
+            records.push(record);
+        }
+    }
+
+    Ok(records)
+}
+
+/// Cache usage aggregated over a set of records
+#[derive(Debug, Default, Clone, PartialEq, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct CacheUsage {
+    pub tasks: usize,
+    pub local_hits: usize,
+    pub remote_hits: usize,
+    pub misses: usize,
+    pub time_saved: u64,
+    pub uploaded_bytes: u64,
+}
+
+impl CacheUsage {
+    fn add(&mut self, record: &CacheStatsRecord) {
+        self.tasks += 1;
+        match (record.status, record.source) {
+            (CacheStatus::Hit, Some(CacheSource::Remote)) => self.remote_hits += 1,
+            (CacheStatus::Hit, _) => self.local_hits += 1,
+            (CacheStatus::Miss, _) => self.misses += 1,
+        }
+        self.time_saved += record.time_saved;
+        self.uploaded_bytes += record.uploaded_bytes;
+    }
+
+    pub fn hit_rate(&self) -> f64 {
+        match self.tasks {
+            0 => 0.0,
+            tasks => (self.local_hits + self.remote_hits) as f64 / tasks as f64,
+        }
+    }
+}
+
+#[derive(Debug, Default, Clone, PartialEq, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct CacheStats {
+    pub runs: usize,
+    #[serde(flatten)]
+    pub total: CacheUsage,
+    // Keyed by task name, so a task is counted together across packages
+    pub by_task: BTreeMap<String, CacheUsage>,
+}
+
+impl CacheStats {
+    pub fn new(records: &[CacheStatsRecord]) -> Self {
+        let mut stats = Self::default();
+        let mut last_run_id = None;
+        for record in records {
+            // Records are appended a run at a time
+            if last_run_id != Some(&record.run_id) {
+                stats.runs += 1;
+                last_run_id = Some(&record.run_id);
+            }
+            stats.total.add(record);
+            stats
+                .by_task
+                .entry(record.task.clone())
+                .or_default()
+                .add(record);
+        }
+        stats
+    }
+}
+
+#[cfg(test)]
+mod test {
+    use anyhow::Result;
+    use tempfile::tempdir;
+
+    use super::*;
+
+    fn record(
+        run_id: &str,
+        task: &str,
+        status: CacheStatus,
+        source: Option<CacheSource>,
+    ) -> CacheStatsRecord {
+        CacheStatsRecord {
+            run_id: run_id.to_string(),
+            ended_at: 0,
+            task_id: format!("web#{task}"),
+            task: task.to_string(),
+            hash: "abc123".to_string(),
+            status,
+            source,
+            time_saved: match status {
+                CacheStatus::Hit => 100,
+                CacheStatus::Miss => 0,
+            },
+            uploaded_bytes: match status {
+                CacheStatus::Hit => 0,
+                CacheStatus::Miss => 10,
+            },
+        }
+    }
+
+    #[test]
+    fn test_append_and_read() -> Result<()> {
+        let dir = tempdir()?;
+        let repo_root = AbsoluteSystemPath::from_std_path(dir.path())?;
+        assert_eq!(read(repo_root)?, vec![]);
+
+        let first = record("1", "build", CacheStatus::Miss, None);
+        let second = record("2", "build", CacheStatus::Hit, Some(CacheSource::Local));
+        append(repo_root, &[first.clone()])?;
+        append(repo_root, &[second.clone()])?;
+        // An interrupted write leaves a partial line behind
+        let mut options = OpenOptions::new();
+        options.append(true);
+        stats_path(repo_root)
+            .open_with_options(options)?
+            .write_all(br#"{"runId":"3","#)?;
+
+        assert_eq!(read(repo_root)?, vec![first, second]);
+        Ok(())
+    }
+
+    #[test]
+    fn test_stats() {
+        let records = [
+            record("1", "build", CacheStatus::Miss, None),
+            record("1", "lint", CacheStatus::Miss, None),
+            record("2", "build", CacheStatus::Hit, Some(CacheSource::Local)),
+            record("2", "lint", CacheStatus::Hit, Some(CacheSource::Remote)),
+            record("3", "build", CacheStatus::Hit, Some(CacheSource::Local)),
+        ];
+
+        let stats = CacheStats::new(&records);
+
+        assert_eq!(stats.runs, 3);
+        assert_eq!(
+            stats.total,
+            CacheUsage {
+                tasks: 5,
+                local_hits: 2,
+                remote_hits: 1,
+                misses: 2,
+                time_saved: 300,
+                uploaded_bytes: 20,
+            }
+        );
+        assert_eq!(stats.total.hit_rate(), 0.6);
+        assert_eq!(stats.by_task["build"].hit_rate(), 2.0 / 3.0);
+        assert_eq!(stats.by_task["lint"].remote_hits, 1);
+    }
+}
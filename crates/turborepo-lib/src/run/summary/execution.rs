@@ -4,10 +4,11 @@ use chrono::{DateTime, Local};
 use serde::Serialize;
 use tokio::sync::mpsc;
 use turbopath::{AbsoluteSystemPathBuf, AnchoredSystemPath};
+use turborepo_cache::NetworkUsage;
 use turborepo_ui::{color, cprintln, BOLD, BOLD_GREEN, BOLD_RED, MAGENTA, UI, YELLOW};
 
 use super::TurboDuration;
-use crate::run::{summary::task::TaskSummary, task_id::TaskId};
+use crate::run::{summary::task::TaskSummary, task_id::TaskId, FailureKind};
 
 // Just used to make changing the type that gets passed to the state management
 // thread easy
@@ -38,11 +39,19 @@ pub struct ExecutionSummary<'a> {
     cached: usize,
     // number of tasks that started
     attempted: usize,
+    // number of tasks that weren't run because a task they depend on failed
+    skipped: usize,
     pub(crate) start_time: i64,
     pub(crate) end_time: i64,
     #[serde(skip)]
     duration: TurboDuration,
     pub(crate) exit_code: i32,
+    // what caused the run to fail, if it did
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub(crate) failure_kind: Option<FailureKind>,
+    // bytes transferred to and from the remote cache
+    #[serde(skip_serializing_if = "NetworkUsage::is_empty")]
+    network_usage: NetworkUsage,
 }
 
 impl<'a> ExecutionSummary<'a> {
@@ -51,8 +60,10 @@ impl<'a> ExecutionSummary<'a> {
         state: SummaryState,
         package_inference_root: Option<&'a AnchoredSystemPath>,
         exit_code: i32,
+        failure_kind: Option<FailureKind>,
         start_time: DateTime<Local>,
         end_time: DateTime<Local>,
+        network_usage: NetworkUsage,
     ) -> Self {
         let duration = TurboDuration::new(&start_time, &end_time);
         Self {
@@ -61,18 +72,27 @@ impl<'a> ExecutionSummary<'a> {
             failed: state.failed,
             cached: state.cached,
             attempted: state.attempted,
+            skipped: state.skipped,
             // We're either at some path in the repo, or at the root, which is an empty path
             repo_path: package_inference_root.unwrap_or_else(|| AnchoredSystemPath::empty()),
             start_time: start_time.timestamp_millis(),
             end_time: end_time.timestamp_millis(),
             duration,
             exit_code,
+            failure_kind,
+            network_usage,
         }
     }
 
     /// We implement this on `ExecutionSummary` and not `RunSummary` because
     /// the `execution` field is nullable (due to normalize).
-    pub fn print(&self, ui: UI, path: AbsoluteSystemPathBuf, failed_tasks: Vec<&TaskSummary>) {
+    pub fn print(
+        &self,
+        ui: UI,
+        path: Option<AbsoluteSystemPathBuf>,
+        failed_tasks: Vec<&TaskSummary>,
+        skipped_tasks: Vec<&TaskSummary>,
+    ) {
         let maybe_full_turbo = if self.cached == self.attempted && self.attempted > 0 {
             match std::env::var("TERM_PROGRAM").as_deref() {
                 Ok("Apple_Terminal") => color!(ui, MAGENTA, ">>> FULL TURBO").to_string(),
@@ -110,7 +130,20 @@ impl<'a> ExecutionSummary<'a> {
             ),
         ];
 
-        if path.exists() {
+        if !self.network_usage.is_empty() {
+            line_data.push((
+                "Remote cache",
+                format!(
+                    "{} bytes downloaded in {}ms, {} bytes uploaded in {}ms",
+                    self.network_usage.bytes_downloaded,
+                    self.network_usage.download_duration,
+                    self.network_usage.bytes_uploaded,
+                    self.network_usage.upload_duration
+                ),
+            ));
+        }
+
+        if let Some(path) = path.filter(|path| path.exists()) {
             line_data.push(("Summary", path.to_string()));
         }
 
@@ -123,6 +156,15 @@ impl<'a> ExecutionSummary<'a> {
             line_data.push(("Failed", formatted.join(", ")));
         }
 
+        if !skipped_tasks.is_empty() {
+            let mut formatted: Vec<_> = skipped_tasks
+                .iter()
+                .map(|task| color!(ui, YELLOW, "{}", task.task_id).to_string())
+                .collect();
+            formatted.sort();
+            line_data.push(("Skipped", formatted.join(", ")));
+        }
+
         let max_length = line_data
             .iter()
             .map(|(header, _)| header.len())
@@ -168,6 +210,7 @@ pub struct SummaryState {
     pub failed: usize,
     pub cached: usize,
     pub success: usize,
+    pub skipped: usize,
     pub tasks: Vec<TaskState>,
 }
 
@@ -184,6 +227,7 @@ impl SummaryState {
             Event::BuildFailed => self.failed += 1,
             Event::Cached => self.cached += 1,
             Event::Built => self.success += 1,
+            Event::Skipped => self.skipped += 1,
             Event::Canceled => (),
         }
     }
@@ -210,6 +254,8 @@ enum Event {
     BuildFailed,
     Cached,
     Built,
+    // Not run because a task it depends on failed
+    Skipped,
     // Canceled due to external signal or internal failure
     Canceled,
 }
@@ -222,13 +268,16 @@ pub struct TaskExecutionSummary {
     #[serde(skip_serializing_if = "Option::is_none")]
     pub error: Option<String>,
     pub exit_code: Option<i32>,
+    // Only set for tasks skipped by `--continue=dependencies-successful`
+    #[serde(skip_serializing_if = "std::ops::Not::not")]
+    pub skipped: bool,
 }
 
 impl TaskExecutionSummary {
     pub fn is_failure(&self) -> bool {
         // We consider None as a failure as it indicates the task failed to start
         // or was killed in a manner where we didn't collect an exit code.
-        !matches!(self.exit_code, Some(0))
+        !self.skipped && !matches!(self.exit_code, Some(0))
     }
 }
 
@@ -322,6 +371,34 @@ impl TaskTracker<()> {
             .await
             .expect("execution summary state thread finished")
     }
+
+    // Track that the task wasn't run because `dependency` failed
+    pub async fn skipped(self, dependency: &TaskId<'_>) -> TaskExecutionSummary {
+        let Self {
+            sender, task_id, ..
+        } = self;
+
+        let now = Local::now().timestamp_millis();
+        let execution = TaskExecutionSummary {
+            start_time: now,
+            end_time: now,
+            exit_code: None,
+            error: Some(format!("skipped because {dependency} failed")),
+            skipped: true,
+        };
+
+        sender
+            .send(TrackerMessage {
+                event: Event::Skipped,
+                state: Some(TaskState {
+                    task_id,
+                    execution: Some(execution.clone()),
+                }),
+            })
+            .await
+            .expect("execution summary state thread finished");
+        execution
+    }
 }
 
 impl TaskTracker<chrono::DateTime<Local>> {
@@ -343,6 +420,7 @@ impl TaskTracker<chrono::DateTime<Local>> {
             // Go synthesizes a zero exit code on cache hits
             exit_code: Some(0),
             error: None,
+            skipped: false,
         };
 
         let state = TaskState {
@@ -372,6 +450,7 @@ impl TaskTracker<chrono::DateTime<Local>> {
             end_time: ended_at.timestamp_millis(),
             exit_code: Some(exit_code),
             error: None,
+            skipped: false,
         };
 
         let state = TaskState {
@@ -405,6 +484,7 @@ impl TaskTracker<chrono::DateTime<Local>> {
             end_time: ended_at.timestamp_millis(),
             exit_code,
             error: Some(error.to_string()),
+            skipped: false,
         };
 
         let state = TaskState {
@@ -488,6 +568,33 @@ mod test {
         );
     }
 
+    #[tokio::test]
+    async fn test_skipped_task() {
+        let summary = ExecutionTracker::new();
+        let lib = TaskId::new("lib", "build");
+        let app = TaskId::new("app", "build");
+        summary
+            .task_tracker(lib.clone())
+            .start()
+            .await
+            .build_failed(Some(1), "big bad error")
+            .await;
+        summary.task_tracker(app.clone()).skipped(&lib).await;
+
+        let state = summary.finish().await.unwrap();
+        assert_eq!(state.attempted, 1, "skipped tasks are never started");
+        assert_eq!(state.failed, 1);
+        assert_eq!(state.skipped, 1);
+        let app_state = state.tasks.iter().find(|task| task.task_id == app).unwrap();
+        let execution = app_state.execution.as_ref().unwrap();
+        assert!(execution.skipped);
+        assert!(!execution.is_failure());
+        assert_eq!(
+            execution.error.as_deref(),
+            Some("skipped because lib#build failed")
+        );
+    }
+
     #[tokio::test]
     async fn test_timing() {
         let summary = ExecutionTracker::new();
@@ -518,7 +625,8 @@ mod test {
             start_time: 123,
             end_time: 234,
             exit_code: Some(0),
-            error: None
+            error: None,
+            skipped: false,
         },
         json!({ "startTime": 123, "endTime": 234, "exitCode": 0 })
         ; "success"
@@ -529,10 +637,28 @@ mod test {
             end_time: 234,
             exit_code: Some(1),
             error: Some("cannot find anything".into()),
+            skipped: false,
         },
         json!({ "startTime": 123, "endTime": 234, "exitCode": 1, "error": "cannot find anything" })
         ; "failure"
     )]
+    #[test_case(
+        TaskExecutionSummary {
+            start_time: 123,
+            end_time: 123,
+            exit_code: None,
+            error: Some("skipped because lib#build failed".into()),
+            skipped: true,
+        },
+        json!({
+            "startTime": 123,
+            "endTime": 123,
+            "exitCode": null,
+            "error": "skipped because lib#build failed",
+            "skipped": true
+        })
+        ; "skipped"
+    )]
     fn test_serialization(value: impl serde::Serialize, expected: serde_json::Value) {
         assert_eq!(serde_json::to_value(value).unwrap(), expected);
     }
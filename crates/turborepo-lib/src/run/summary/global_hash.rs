@@ -28,6 +28,8 @@ pub struct GlobalEnvVarSummary<'a> {
 #[derive(Debug, Serialize)]
 #[serde(rename_all = "camelCase")]
 pub struct GlobalHashSummary<'a> {
+    // The hash of all of the inputs below, which is part of every task hash
+    pub hash: String,
     pub root_key: &'static str,
     pub files: BTreeMap<RelativeUnixPathBuf, String>,
     pub hash_of_external_dependencies: &'a str,
@@ -38,7 +40,8 @@ pub struct GlobalHashSummary<'a> {
 impl<'a> TryFrom<GlobalHashableInputs<'a>> for GlobalHashSummary<'a> {
     type Error = Error;
     #[allow(clippy::too_many_arguments)]
-    fn try_from(global_hashable_inputs: GlobalHashableInputs<'a>) -> Result<Self, Self::Error> {
+    fn try_from(mut global_hashable_inputs: GlobalHashableInputs<'a>) -> Result<Self, Self::Error> {
+        let hash = global_hashable_inputs.calculate_global_hash_from_inputs();
         let GlobalHashableInputs {
             global_cache_key,
             global_file_hash_map,
@@ -63,6 +66,7 @@ impl<'a> TryFrom<GlobalHashableInputs<'a>> for GlobalHashSummary<'a> {
             .transpose()?;
 
         Ok(Self {
+            hash,
             root_key: global_cache_key,
             files: global_file_hash_map.into_iter().collect(),
             // This can be empty in single package mode
@@ -3,6 +3,7 @@
 //! A tracker tracks the live data and then gets turned into a summary for
 //! displaying it We have this split because the tracker representation is not
 //! exactly what we want to display to the user.
+pub(crate) mod cache_stats;
 #[allow(dead_code)]
 mod duration;
 mod execution;
@@ -18,23 +19,26 @@ pub use duration::TurboDuration;
 pub use execution::{TaskExecutionSummary, TaskTracker};
 pub use global_hash::GlobalHashSummary;
 use itertools::Itertools;
+pub(crate) use scm::SCMState;
 use serde::Serialize;
 pub use spaces::{SpacesTaskClient, SpacesTaskInformation};
 use svix_ksuid::{Ksuid, KsuidLike};
 use tabwriter::TabWriter;
 use thiserror::Error;
-use tracing::{error, log::warn};
+use tracing::{debug, error, log::warn};
 use turbopath::{AbsoluteSystemPath, AbsoluteSystemPathBuf, AnchoredSystemPath};
 use turborepo_api_client::{spaces::CreateSpaceRunPayload, APIAuth, APIClient};
+use turborepo_cache::NetworkUsage;
 use turborepo_env::EnvironmentVariableMap;
 use turborepo_repository::package_graph::{PackageGraph, PackageName};
 use turborepo_scm::SCM;
 use turborepo_ui::{color, cprintln, cwriteln, BOLD, BOLD_CYAN, GREY, UI};
 
 use self::{
-    execution::TaskState, task::SinglePackageTaskSummary, task_factory::TaskSummaryFactory,
+    cache_stats::CacheStatsRecord, execution::TaskState, task::SinglePackageTaskSummary,
+    task_factory::TaskSummaryFactory,
 };
-use super::task_id::TaskId;
+use super::{cleanup, task_id::TaskId, FailureKind};
 use crate::{
     cli,
     cli::DryRunMode,
@@ -76,11 +80,22 @@ pub enum Error {
 // of env vars (unknown run summary versions will be ignored on the server)
 const RUN_SUMMARY_SCHEMA_VERSION: &str = "1";
 
+// Number of characters of the task hash shown by `--dry=table`
+const DRY_TABLE_HASH_LENGTH: usize = 8;
+
+// Summaries of older runs are removed from `.turbo/runs` so that writing one
+// after every run doesn't grow it forever
+const MAX_SAVED_SUMMARIES: usize = 100;
+
 #[derive(Debug)]
 enum RunType {
     Real,
+    // A real run whose summary is printed as JSON in place of the usual
+    // execution summary
+    RealJson,
     DryText,
     DryJson,
+    DryTable,
 }
 
 // Can't reuse `cli::EnvMode` because the serialization
@@ -124,6 +139,10 @@ pub struct RunSummary<'a> {
     repo_root: &'a AbsoluteSystemPath,
     #[serde(skip)]
     should_save: bool,
+    // The path of the summary is only printed when `--summarize` is passed, so
+    // that the summary saved after every run doesn't add to the output
+    #[serde(skip)]
+    should_print_path: bool,
     #[serde(skip)]
     run_type: RunType,
     #[serde(skip)]
@@ -133,6 +152,7 @@ pub struct RunSummary<'a> {
 /// We use this to track the run, so it's constructed before the run.
 #[derive(Debug)]
 pub struct RunTracker {
+    id: Ksuid,
     scm: SCMState,
     version: &'static str,
     started_at: DateTime<Local>,
@@ -145,6 +165,7 @@ pub struct RunTracker {
 impl RunTracker {
     #[allow(clippy::too_many_arguments)]
     pub fn new(
+        id: Ksuid,
         started_at: DateTime<Local>,
         synthesized_command: String,
         package_inference_root: Option<&AnchoredSystemPath>,
@@ -176,6 +197,7 @@ impl RunTracker {
             );
 
         RunTracker {
+            id,
             scm,
             version,
             started_at,
@@ -200,20 +222,26 @@ impl RunTracker {
         repo_root: &'a AbsoluteSystemPath,
         package_inference_root: Option<&'a AnchoredSystemPath>,
         exit_code: i32,
+        failure: Option<FailureKind>,
         end_time: DateTime<Local>,
         run_opts: &'a RunOpts,
         packages: HashSet<PackageName>,
         global_hash_summary: GlobalHashSummary<'a>,
         global_env_mode: EnvMode,
         task_factory: TaskSummaryFactory<'a>,
+        network_usage: NetworkUsage,
     ) -> Result<RunSummary<'a>, Error> {
         let single_package = run_opts.single_package;
-        let should_save = run_opts.summarize.flatten().is_some_and(|s| s);
+        // Summaries are saved unless turned off with `--summarize=false`
+        let should_save = run_opts.summarize.flatten() != Some(false);
+        let should_print_path = run_opts.summarize.flatten() == Some(true);
 
         let run_type = match run_opts.dry_run {
+            None if run_opts.json => RunType::RealJson,
             None => RunType::Real,
             Some(DryRunMode::Json) => RunType::DryJson,
             Some(DryRunMode::Text) => RunType::DryText,
+            Some(DryRunMode::Table) => RunType::DryTable,
         };
 
         let summary_state = self.execution_tracker.finish().await?;
@@ -229,12 +257,14 @@ impl RunTracker {
             summary_state,
             package_inference_root,
             exit_code,
+            failure,
             self.started_at,
             end_time,
+            network_usage,
         );
 
         Ok(RunSummary {
-            id: Ksuid::new(None, None),
+            id: self.id,
             version: RUN_SUMMARY_SCHEMA_VERSION.to_string(),
             turbo_version: self.version,
             packages: packages.into_iter().sorted().collect(),
@@ -248,6 +278,7 @@ impl RunTracker {
             monorepo: !single_package,
             repo_root,
             should_save,
+            should_print_path,
             run_type,
             spaces_client_handle: self.spaces_client_handle,
         })
@@ -261,12 +292,14 @@ impl RunTracker {
         global_hash_summary,
         engine,
         hash_tracker,
-        env_at_execution_start
+        env_at_execution_start,
+        global_env_vars
     ))]
     #[allow(clippy::too_many_arguments)]
     pub async fn finish<'a>(
         self,
         exit_code: i32,
+        failure: Option<FailureKind>,
         pkg_dep_graph: &PackageGraph,
         ui: UI,
         repo_root: &'a AbsoluteSystemPath,
@@ -278,6 +311,8 @@ impl RunTracker {
         engine: &'a Engine,
         hash_tracker: TaskHashTracker,
         env_at_execution_start: &'a EnvironmentVariableMap,
+        global_env_vars: &'a EnvironmentVariableMap,
+        network_usage: NetworkUsage,
     ) -> Result<(), Error> {
         let end_time = Local::now();
 
@@ -286,6 +321,7 @@ impl RunTracker {
             engine,
             hash_tracker,
             env_at_execution_start,
+            global_env_vars,
             run_opts,
             global_env_mode,
         );
@@ -295,12 +331,14 @@ impl RunTracker {
                 repo_root,
                 package_inference_root,
                 exit_code,
+                failure,
                 end_time,
                 run_opts,
                 packages,
                 global_hash_summary,
                 global_env_mode.into(),
                 task_factory,
+                network_usage,
             )
             .await?;
 
@@ -313,6 +351,10 @@ impl RunTracker {
         self.execution_tracker.task_tracker(task_id)
     }
 
+    pub fn id(&self) -> Ksuid {
+        self.id
+    }
+
     pub fn spaces_enabled(&self) -> bool {
         self.spaces_client_handle.is_some()
     }
@@ -381,7 +423,10 @@ impl<'a> RunSummary<'a> {
         pkg_dep_graph: &PackageGraph,
         ui: UI,
     ) -> Result<(), Error> {
-        if matches!(self.run_type, RunType::DryJson | RunType::DryText) {
+        if matches!(
+            self.run_type,
+            RunType::DryJson | RunType::DryText | RunType::DryTable
+        ) {
             return self.close_dry_run(pkg_dep_graph, ui);
         }
 
@@ -391,10 +436,17 @@ impl<'a> RunSummary<'a> {
             }
         }
 
-        if let Some(execution) = &self.execution {
-            let path = self.get_path();
+        if let Err(err) = self.record_cache_stats(end_time) {
+            warn!("Error writing cache stats: {}", err)
+        }
+
+        if matches!(self.run_type, RunType::RealJson) {
+            print!("{}", self.format_json()?);
+        } else if let Some(execution) = &self.execution {
+            let path = self.should_print_path.then(|| self.get_path());
             let failed_tasks = self.get_failed_tasks();
-            execution.print(ui, path, failed_tasks);
+            let skipped_tasks = self.get_skipped_tasks();
+            execution.print(ui, path, failed_tasks, skipped_tasks);
         }
 
         if let Some(spaces_client_handle) = self.spaces_client_handle.take() {
@@ -430,7 +482,11 @@ impl<'a> RunSummary<'a> {
         Self::print_errors(&result.errors);
 
         if let Some(run) = result.run {
-            println!("Run: {}\n", run.url);
+            // Stdout is reserved for the summary in JSON mode
+            match self.run_type {
+                RunType::RealJson => eprintln!("Run: {}\n", run.url),
+                _ => println!("Run: {}\n", run.url),
+            }
         }
     }
 
@@ -452,9 +508,55 @@ impl<'a> RunSummary<'a> {
             return Ok(());
         }
 
+        if matches!(self.run_type, RunType::DryTable) {
+            return self.format_and_print_table();
+        }
+
         self.format_and_print_text(pkg_dep_graph, ui)
     }
 
+    /// Prints one row per task, which stays readable for large task graphs
+    /// where the per-task blocks of `--dry` don't.
+    fn format_and_print_table(&mut self) -> Result<(), Error> {
+        self.normalize();
+
+        let mut tab_writer = TabWriter::new(io::stdout()).minwidth(0).padding(2);
+        if self.monorepo {
+            writeln!(tab_writer, "Package\tTask\tHash\tCache\tDependencies")?;
+        } else {
+            writeln!(tab_writer, "Task\tHash\tCache\tDependencies")?;
+        }
+        for task in &self.tasks {
+            let hash = task
+                .shared
+                .hash
+                .get(..DRY_TABLE_HASH_LENGTH)
+                .unwrap_or(&task.shared.hash);
+            let cache = match (task.shared.cache.local, task.shared.cache.remote) {
+                (true, _) => "LOCAL",
+                (false, true) => "REMOTE",
+                (false, false) => "MISS",
+            };
+            let dependencies = task.shared.dependencies.len();
+            if self.monorepo {
+                writeln!(
+                    tab_writer,
+                    "{}\t{}\t{}\t{}\t{}",
+                    task.package, task.task, hash, cache, dependencies
+                )?;
+            } else {
+                writeln!(
+                    tab_writer,
+                    "{}\t{}\t{}\t{}",
+                    task.task, hash, cache, dependencies
+                )?;
+            }
+        }
+        tab_writer.flush()?;
+
+        Ok(())
+    }
+
     fn format_and_print_text(&mut self, pkg_dep_graph: &PackageGraph, ui: UI) -> Result<(), Error> {
         self.normalize();
 
@@ -797,12 +899,39 @@ impl<'a> RunSummary<'a> {
             .collect()
     }
 
+    fn get_skipped_tasks(&self) -> Vec<&TaskSummary> {
+        self.tasks
+            .iter()
+            .filter(|task| task.shared.execution.as_ref().map_or(false, |e| e.skipped))
+            .collect()
+    }
+
+    fn record_cache_stats(&self, end_time: DateTime<Local>) -> Result<(), Error> {
+        let run_id = self.id.to_string();
+        let records = self
+            .tasks
+            .iter()
+            .filter_map(|task| {
+                CacheStatsRecord::from_task(&run_id, end_time.timestamp_millis(), task)
+            })
+            .collect::<Vec<_>>();
+
+        Ok(cache_stats::append(self.repo_root, &records)?)
+    }
+
     fn save(&mut self) -> Result<(), Error> {
         let json = self.format_json()?;
 
         let summary_path = self.get_path();
         summary_path.ensure_dir()?;
+        summary_path.create_with_contents(json)?;
 
-        Ok(summary_path.create_with_contents(json)?)
+        let runs_dir = self.repo_root.join_components(&[".turbo", "runs"]);
+        let removed = cleanup::prune_run_summaries(&runs_dir, MAX_SAVED_SUMMARIES);
+        if removed.files > 0 {
+            debug!("removed {} old run summaries", removed.files);
+        }
+
+        Ok(())
     }
 }
@@ -1,8 +1,8 @@
 use std::collections::BTreeMap;
 
-use serde::Serialize;
+use serde::{Deserialize, Serialize};
 use turbopath::{AnchoredSystemPathBuf, RelativeUnixPathBuf};
-use turborepo_cache::CacheHitMetadata;
+use turborepo_cache::{CacheDownloadMetadata, CacheHitMetadata, CacheUploadMetadata};
 use turborepo_env::{DetailedMap, EnvironmentVariableMap};
 
 use super::{execution::TaskExecutionSummary, EnvMode};
@@ -10,33 +10,61 @@ use crate::{
     cli::OutputLogsMode,
     run::task_id::TaskId,
     task_graph::{TaskDefinition, TaskOutputs},
+    task_hash::HashingMetrics,
 };
 
 #[derive(Debug, Serialize, Clone)]
 #[serde(rename_all = "camelCase")]
 pub struct TaskCacheSummary {
-    // Deprecated, but keeping around for --dry=json
+    // Deprecated outside of dry runs, which check both caches
     pub local: bool,
-    // Deprecated, but keeping around for --dry=json
+    // Deprecated outside of dry runs, which check both caches
     pub remote: bool,
-    status: CacheStatus,
+    pub status: CacheStatus,
     // Present unless a cache miss
     #[serde(skip_serializing_if = "Option::is_none")]
-    source: Option<CacheSource>,
+    pub source: Option<CacheSource>,
     // 0 if a cache miss
-    time_saved: u64,
+    pub time_saved: u64,
 }
 
 #[derive(Debug, Serialize, Copy, Clone)]
+#[serde(rename_all = "camelCase")]
+pub struct TaskUploadSummary {
+    // Size of the uploaded artifact in bytes
+    pub bytes: u64,
+    // Time spent uploading the artifact in milliseconds
+    duration: u64,
+}
+
+#[derive(Debug, Serialize, Copy, Clone)]
+#[serde(rename_all = "camelCase")]
+pub struct TaskDownloadSummary {
+    // Bytes downloaded from the remote cache, 0 for a local hit
+    pub bytes: u64,
+    // Time spent fetching and restoring the artifact in milliseconds
+    duration: u64,
+}
+
+#[derive(Debug, Serialize, Copy, Clone)]
+#[serde(rename_all = "camelCase")]
+pub struct TaskHashingSummary {
+    // Time spent hashing the task inputs in milliseconds
+    duration: u64,
+    // Number of input files that were hashed
+    file_count: usize,
+}
+
+#[derive(Debug, Serialize, Deserialize, Copy, Clone, PartialEq, Eq)]
 #[serde(rename_all = "UPPERCASE")]
-enum CacheStatus {
+pub enum CacheStatus {
     Hit,
     Miss,
 }
 
-#[derive(Debug, Serialize, Copy, Clone)]
+#[derive(Debug, Serialize, Deserialize, Copy, Clone, PartialEq, Eq)]
 #[serde(rename_all = "UPPERCASE")]
-enum CacheSource {
+pub enum CacheSource {
     Local,
     Remote,
 }
@@ -67,6 +95,12 @@ pub(crate) struct SharedTaskSummary<T> {
     pub inputs: BTreeMap<RelativeUnixPathBuf, String>,
     pub hash_of_external_dependencies: String,
     pub cache: TaskCacheSummary,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub upload: Option<TaskUploadSummary>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub download: Option<TaskDownloadSummary>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub hashing: Option<TaskHashingSummary>,
     pub command: String,
     pub cli_arguments: Vec<String>,
     pub outputs: Option<Vec<String>>,
@@ -82,6 +116,9 @@ pub(crate) struct SharedTaskSummary<T> {
     pub env_mode: EnvMode,
     pub environment_variables: TaskEnvVarSummary,
     pub dot_env: Option<Vec<RelativeUnixPathBuf>>,
+    // Only reported for dry runs
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub strict_environment_variables: Option<TaskStrictEnvSummary>,
     #[serde(skip_serializing_if = "Option::is_none")]
     pub execution: Option<TaskExecutionSummary>,
 }
@@ -97,9 +134,13 @@ pub struct TaskEnvConfiguration {
 #[serde(rename_all = "camelCase")]
 pub struct TaskSummaryTaskDefinition {
     outputs: Vec<String>,
-    cache: bool,
+    pub cache: bool,
+    cache_logs: bool,
+    always_run: bool,
     depends_on: Vec<String>,
     inputs: Vec<String>,
+    root_inputs: Vec<String>,
+    inputs_from: Vec<String>,
     output_mode: OutputLogsMode,
     persistent: bool,
     env: Vec<String>,
@@ -117,6 +158,18 @@ pub struct TaskEnvVarSummary {
     pub pass_through: Option<Vec<String>>,
 }
 
+/// The names of the environment variables a task would see under strict mode
+#[derive(Debug, Serialize, Clone, Default, PartialEq)]
+#[serde(rename_all = "camelCase")]
+pub struct TaskStrictEnvSummary {
+    // Included in the task hash, either directly or through the global hash
+    pub hashed: Vec<String>,
+    // Available to the task, but not included in the task hash
+    pub passed_through: Vec<String>,
+    // Removed from the task's environment
+    pub stripped: Vec<String>,
+}
+
 impl TaskCacheSummary {
     pub fn cache_miss() -> Self {
         Self {
@@ -129,6 +182,33 @@ impl TaskCacheSummary {
     }
 }
 
+impl From<CacheUploadMetadata> for TaskUploadSummary {
+    fn from(upload: CacheUploadMetadata) -> Self {
+        Self {
+            bytes: upload.bytes,
+            duration: upload.duration.as_millis() as u64,
+        }
+    }
+}
+
+impl From<CacheDownloadMetadata> for TaskDownloadSummary {
+    fn from(download: CacheDownloadMetadata) -> Self {
+        Self {
+            bytes: download.bytes,
+            duration: download.duration.as_millis() as u64,
+        }
+    }
+}
+
+impl From<HashingMetrics> for TaskHashingSummary {
+    fn from(metrics: HashingMetrics) -> Self {
+        Self {
+            duration: metrics.duration.as_millis() as u64,
+            file_count: metrics.file_count,
+        }
+    }
+}
+
 impl From<Option<CacheHitMetadata>> for TaskCacheSummary {
     fn from(response: Option<CacheHitMetadata>) -> Self {
         match response {
@@ -141,7 +221,8 @@ impl From<Option<CacheHitMetadata>> for TaskCacheSummary {
                 // was a local cache hit, and we return remote: false here. That's misleading
                 // because it does not mean that there is no remote cache hit,
                 // it _could_ mean that we never checked the remote cache. These
-                // fields are being deprecated for this reason.
+                // fields are being deprecated for this reason. Dry runs check both
+                // caches and overwrite them.
                 let (local, remote) = match source {
                     CacheSource::Local => (true, false),
                     CacheSource::Remote => (false, true),
@@ -199,6 +280,28 @@ impl TaskEnvVarSummary {
     }
 }
 
+impl TaskStrictEnvSummary {
+    pub fn new(
+        task_env_vars: &EnvironmentVariableMap,
+        global_env_vars: &EnvironmentVariableMap,
+        strict_env: &EnvironmentVariableMap,
+        env_at_execution_start: &EnvironmentVariableMap,
+    ) -> Self {
+        let mut hashed = task_env_vars.clone();
+        hashed.union(global_env_vars);
+        let mut passed_through = strict_env.clone();
+        passed_through.difference(&hashed);
+        let mut stripped = env_at_execution_start.clone();
+        stripped.difference(strict_env);
+
+        Self {
+            hashed: hashed.names(),
+            passed_through: passed_through.names(),
+            stripped: stripped.names(),
+        }
+    }
+}
+
 impl From<TaskSummary> for SinglePackageTaskSummary {
     fn from(value: TaskSummary) -> Self {
         let TaskSummary {
@@ -219,6 +322,9 @@ impl From<SharedTaskSummary<TaskId<'static>>> for SharedTaskSummary<String> {
             inputs,
             hash_of_external_dependencies,
             cache,
+            upload,
+            download,
+            hashing,
             command,
             cli_arguments,
             outputs,
@@ -233,6 +339,7 @@ impl From<SharedTaskSummary<TaskId<'static>>> for SharedTaskSummary<String> {
             env_mode,
             environment_variables,
             dot_env,
+            strict_environment_variables,
             ..
         } = value;
         Self {
@@ -240,6 +347,9 @@ impl From<SharedTaskSummary<TaskId<'static>>> for SharedTaskSummary<String> {
             inputs,
             hash_of_external_dependencies,
             cache,
+            upload,
+            download,
+            hashing,
             command,
             cli_arguments,
             outputs,
@@ -261,6 +371,7 @@ impl From<SharedTaskSummary<TaskId<'static>>> for SharedTaskSummary<String> {
             env_mode,
             environment_variables,
             dot_env,
+            strict_environment_variables,
         }
     }
 }
@@ -274,14 +385,20 @@ impl From<TaskDefinition> for TaskSummaryTaskDefinition {
                     exclusions,
                 },
             cache,
+            cache_logs,
+            always_run,
             mut env,
             pass_through_env,
             dot_env,
             topological_dependencies,
             task_dependencies,
             mut inputs,
+            mut root_inputs,
+            mut inputs_from,
             output_mode,
             persistent,
+            // Only affects the order tasks are dispatched in
+            priority: _,
         } = value;
 
         let mut outputs = inclusions;
@@ -305,12 +422,18 @@ impl From<TaskDefinition> for TaskSummaryTaskDefinition {
         outputs.sort();
         env.sort();
         inputs.sort();
+        root_inputs.sort();
+        inputs_from.sort();
 
         Self {
             outputs,
             cache,
+            cache_logs,
+            always_run,
             depends_on,
             inputs,
+            root_inputs,
+            inputs_from,
             output_mode,
             persistent,
             env,
@@ -363,13 +486,18 @@ mod test {
         TaskSummaryTaskDefinition {
             outputs: vec!["foo".into()],
             cache: true,
+            cache_logs: true,
             ..Default::default()
         },
         json!({
             "outputs": ["foo"],
             "cache": true,
+            "cacheLogs": true,
+            "alwaysRun": false,
             "dependsOn": [],
             "inputs": [],
+            "rootInputs": [],
+            "inputsFrom": [],
             "outputMode": "full",
             "persistent": false,
             "env": [],
@@ -381,4 +509,31 @@ mod test {
     fn test_serialization(value: impl serde::Serialize, expected: serde_json::Value) {
         assert_eq!(serde_json::to_value(value).unwrap(), expected);
     }
+
+    #[test]
+    fn test_strict_env_summary() {
+        let env = |names: &[&str]| {
+            EnvironmentVariableMap::from(
+                names
+                    .iter()
+                    .map(|name| (name.to_string(), "value".to_string()))
+                    .collect::<std::collections::HashMap<_, _>>(),
+            )
+        };
+        let summary = TaskStrictEnvSummary::new(
+            &env(&["API_URL"]),
+            &env(&["CI"]),
+            &env(&["API_URL", "CI", "PATH", "AWS_TOKEN"]),
+            &env(&["API_URL", "CI", "PATH", "AWS_TOKEN", "HOME", "NPM_TOKEN"]),
+        );
+
+        assert_eq!(
+            summary,
+            TaskStrictEnvSummary {
+                hashed: vec!["API_URL".to_string(), "CI".to_string()],
+                passed_through: vec!["AWS_TOKEN".to_string(), "PATH".to_string()],
+                stripped: vec!["HOME".to_string(), "NPM_TOKEN".to_string()],
+            }
+        );
+    }
 }
@@ -5,7 +5,7 @@ use turborepo_repository::package_graph::{PackageGraph, PackageInfo, PackageName
 
 use super::{
     execution::TaskExecutionSummary,
-    task::{SharedTaskSummary, TaskEnvVarSummary},
+    task::{SharedTaskSummary, TaskCacheSummary, TaskEnvVarSummary, TaskStrictEnvSummary},
     EnvMode, SinglePackageTaskSummary, TaskSummary,
 };
 use crate::{
@@ -22,6 +22,8 @@ pub struct TaskSummaryFactory<'a> {
     engine: &'a Engine,
     hash_tracker: TaskHashTracker,
     env_at_start: &'a EnvironmentVariableMap,
+    // The environment variables included in the global hash
+    global_env_vars: &'a EnvironmentVariableMap,
     run_opts: &'a RunOpts,
     global_env_mode: cli::EnvMode,
 }
@@ -40,6 +42,7 @@ impl<'a> TaskSummaryFactory<'a> {
         engine: &'a Engine,
         hash_tracker: TaskHashTracker,
         env_at_start: &'a EnvironmentVariableMap,
+        global_env_vars: &'a EnvironmentVariableMap,
         run_opts: &'a RunOpts,
         global_env_mode: cli::EnvMode,
     ) -> Self {
@@ -48,6 +51,7 @@ impl<'a> TaskSummaryFactory<'a> {
             engine,
             hash_tracker,
             env_at_start,
+            global_env_vars,
             run_opts,
             global_env_mode,
         }
@@ -141,7 +145,28 @@ impl<'a> TaskSummaryFactory<'a> {
             .env_vars(task_id)
             .expect("env var map is inserted at the same time as hash");
 
-        let cache_summary = self.hash_tracker.cache_status(task_id).into();
+        let strict_environment_variables =
+            self.hash_tracker.strict_env(task_id).map(|strict_env| {
+                TaskStrictEnvSummary::new(
+                    &env_vars.all,
+                    self.global_env_vars,
+                    &strict_env,
+                    self.env_at_start,
+                )
+            });
+
+        let mut cache_summary: TaskCacheSummary = self.hash_tracker.cache_status(task_id).into();
+        if let Some(cache_state) = self.hash_tracker.cache_state(task_id) {
+            cache_summary.local = cache_state.local.is_some();
+            cache_summary.remote = cache_state.remote.is_some();
+        }
+        let upload = self.hash_tracker.upload_metadata(task_id).map(Into::into);
+        let download = self.hash_tracker.download_metadata(task_id).map(Into::into);
+        // Timings aren't stable between runs so they're left out of dry runs
+        let hashing = match self.run_opts.dry_run {
+            Some(_) => None,
+            None => self.hash_tracker.hashing_metrics(task_id).map(Into::into),
+        };
 
         let (dependencies, dependents) = self.dependencies_and_dependents(task_id, display_task);
 
@@ -158,6 +183,9 @@ impl<'a> TaskSummaryFactory<'a> {
                 &workspace_info.transitive_dependencies,
             ),
             cache: cache_summary,
+            upload,
+            download,
+            hashing,
             command,
             cli_arguments: self.run_opts.pass_through_args.to_vec(),
             outputs: match task_definition.outputs.inclusions.is_empty() {
@@ -198,6 +226,7 @@ impl<'a> TaskSummaryFactory<'a> {
             )
             .expect("invalid glob in task definition should have been caught earlier"),
             dot_env: task_definition.dot_env.clone(),
+            strict_environment_variables,
             execution,
         })
     }
@@ -223,6 +223,13 @@ impl<'a> TaskName<'a> {
             .map_or(true, |task_id| task_id.package() == workspace)
     }
 
+    // Checks if the task id is referred to by this task name.
+    // e.g. `build` matches `web#build` and `docs#build`, but `web#build` only
+    // matches `web#build`
+    pub fn matches(&self, task_id: &TaskId) -> bool {
+        self.task() == task_id.task() && self.in_workspace(task_id.package())
+    }
+
     pub fn into_owned(self) -> TaskName<'static> {
         let TaskName { package, task } = self;
         TaskName {
@@ -261,4 +268,15 @@ mod test {
     fn test_task_name_roundtrip(input: &str) {
         assert_eq!(input, TaskName::from(input).to_string());
     }
+
+    #[test_case("build", "web#build", true ; "global task")]
+    #[test_case("web#build", "web#build", true ; "workspace task")]
+    #[test_case("web#build", "docs#build", false ; "different workspace")]
+    #[test_case("build", "web#test", false ; "different task")]
+    #[test_case("//#build", "//#build", true ; "root task")]
+    fn test_task_name_matches(task_name: &str, task_id: &str, expected: bool) {
+        let task_name = TaskName::from(task_name);
+        let task_id = TaskId::try_from(task_id).unwrap();
+        assert_eq!(task_name.matches(&task_id), expected);
+    }
 }
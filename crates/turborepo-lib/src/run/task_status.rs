@@ -0,0 +1,106 @@
+//! Publishes the status of every task in a run to the daemon, which streams
+//! it to subscribers such as editor extensions while the run is in progress,
+//! to an in-process listener when turbo is embedded in another program, and
+//! to the terminal UI.
+
+use std::{
+    sync::Arc,
+    time::{Duration, SystemTime, UNIX_EPOCH},
+};
+
+use tokio::{sync::mpsc, task::JoinHandle};
+use tracing::debug;
+use turborepo_ui::tui::{AppSender, TaskResult};
+
+use super::task_id::TaskId;
+pub(crate) use crate::daemon::proto::TaskState;
+use crate::daemon::{proto, DaemonClient, DaemonConnector, DaemonError};
+
+/// Receives every task status update of a run in the order they happened
+pub type TaskStatusListener = Arc<dyn Fn(&proto::TaskStatusUpdate) + Send + Sync>;
+
+/// Shows every task status update in the terminal UI before passing it on to
+/// `next`
+pub fn ui_listener(ui: AppSender, next: Option<TaskStatusListener>) -> TaskStatusListener {
+    Arc::new(move |update| {
+        let task = update.task_id.clone();
+        match update.state() {
+            TaskState::Running => ui.start_task(task),
+            TaskState::Cached => ui.end_task(task, TaskResult::CacheHit),
+            TaskState::Succeeded => ui.end_task(task, TaskResult::Success),
+            TaskState::Failed => ui.end_task(task, TaskResult::Failure),
+        }
+        if let Some(next) = &next {
+            next(update);
+        }
+    })
+}
+
+#[derive(Clone)]
+pub struct TaskStatusPublisher {
+    run_id: String,
+    updates: mpsc::UnboundedSender<proto::TaskStatusUpdate>,
+}
+
+impl TaskStatusPublisher {
+    /// Updates are sent one at a time in the background so that they arrive
+    /// in order without holding up the tasks that publish them. The returned
+    /// handle finishes once every publisher is dropped and the remaining
+    /// updates are sent.
+    pub fn new(
+        mut client: Option<DaemonClient<DaemonConnector>>,
+        listener: Option<TaskStatusListener>,
+        run_id: String,
+    ) -> (Self, JoinHandle<()>) {
+        let (updates, mut rx) = mpsc::unbounded_channel::<proto::TaskStatusUpdate>();
+        let handle = tokio::spawn(async move {
+            while let Some(update) = rx.recv().await {
+                if let Some(listener) = &listener {
+                    listener(&update);
+                }
+                let Some(daemon) = client.as_mut() else {
+                    continue;
+                };
+                match daemon.publish_task_status(update).await {
+                    Ok(()) => {}
+                    // The daemon predates task status updates
+                    Err(DaemonError::VersionMismatch(_)) => {
+                        debug!("daemon doesn't support task status updates");
+                        client = None;
+                    }
+                    Err(e) => debug!("failed to publish task status: {e}"),
+                }
+                if client.is_none() && listener.is_none() {
+                    break;
+                }
+            }
+        });
+
+        (Self { run_id, updates }, handle)
+    }
+
+    pub fn publish(
+        &self,
+        task_id: &TaskId,
+        hash: &str,
+        state: TaskState,
+        exit_code: Option<i32>,
+        duration: Duration,
+    ) {
+        let update = proto::TaskStatusUpdate {
+            run_id: self.run_id.clone(),
+            task_id: task_id.to_string(),
+            package: task_id.package().to_string(),
+            task: task_id.task().to_string(),
+            hash: hash.to_string(),
+            state: state.into(),
+            exit_code,
+            duration_msec: duration.as_millis() as u64,
+            timestamp_msec: SystemTime::now()
+                .duration_since(UNIX_EPOCH)
+                .map_or(0, |now| now.as_millis() as i64),
+        };
+        // Sending only fails once nothing is left to receive updates
+        let _ = self.updates.send(update);
+    }
+}
@@ -5,6 +5,7 @@ use std::{
     path::PathBuf,
     process,
     process::Stdio,
+    sync::OnceLock,
     time::Duration,
 };
 
@@ -77,6 +78,24 @@ pub enum Error {
     Path(#[from] turbopath::PathError),
 }
 
+impl Error {
+    pub fn exit_code(&self) -> i32 {
+        match self {
+            Error::Cli(cli_error) => cli_error.exit_code(),
+            _ => 1,
+        }
+    }
+}
+
+// The root of the repository turbo is running in, once it has been inferred
+static REPO_ROOT: OnceLock<AbsoluteSystemPathBuf> = OnceLock::new();
+
+/// The root of the repository turbo is running in, if it has been inferred.
+/// Crash reports are written to its `.turbo` directory.
+pub fn repo_root() -> Option<&'static AbsoluteSystemPath> {
+    REPO_ROOT.get().map(|root| root.as_ref())
+}
+
 // all arguments that result in a stdout that much be directly parsable and
 // should not be paired with additional output (from the update notifier for
 // example)
@@ -104,8 +123,9 @@ fn turbo_version_has_shim(version: &str) -> bool {
 
 #[derive(Debug)]
 struct ShimArgs {
+    // The directory turbo runs in, which is `--cwd` if it was passed. The repo
+    // root is discovered from here, and packages are inferred from it.
     cwd: AbsoluteSystemPathBuf,
-    invocation_dir: AbsoluteSystemPathBuf,
     skip_infer: bool,
     verbosity: usize,
     force_update_check: bool,
@@ -208,14 +228,10 @@ impl ShimArgs {
         }
 
         let invocation_dir = AbsoluteSystemPathBuf::cwd()?;
-        let cwd = cwds
-            .pop()
-            .map(|(cwd, _)| cwd)
-            .unwrap_or_else(|| invocation_dir.clone());
+        let cwd = cwds.pop().map(|(cwd, _)| cwd).unwrap_or(invocation_dir);
 
         Ok(ShimArgs {
             cwd,
-            invocation_dir,
             skip_infer,
             verbosity,
             force_update_check,
@@ -585,10 +601,7 @@ fn run_correct_turbo(
         try_check_for_updates(&shim_args, &turbo_state.version);
 
         if turbo_state.local_is_self() {
-            env::set_var(
-                cli::INVOCATION_DIR_ENV_VAR,
-                shim_args.invocation_dir.as_path(),
-            );
+            env::set_var(cli::INVOCATION_DIR_ENV_VAR, shim_args.cwd.as_path());
             debug!("Currently running turbo is local turbo.");
             Ok(cli::run(Some(repo_state), subscriber, ui)?)
         } else {
@@ -598,10 +611,7 @@ fn run_correct_turbo(
         try_check_for_updates(&shim_args, get_version());
         // cli::run checks for this env var, rather than an arg, so that we can support
         // calling old versions without passing unknown flags.
-        env::set_var(
-            cli::INVOCATION_DIR_ENV_VAR,
-            shim_args.invocation_dir.as_path(),
-        );
+        env::set_var(cli::INVOCATION_DIR_ENV_VAR, shim_args.cwd.as_path());
         debug!("Running command as global turbo");
         Ok(cli::run(Some(repo_state), subscriber, ui)?)
     }
@@ -660,10 +670,7 @@ fn spawn_local_turbo(
         .args(&raw_args)
         // rather than passing an argument that local turbo might not understand, set
         // an environment variable that can be optionally used
-        .env(
-            cli::INVOCATION_DIR_ENV_VAR,
-            shim_args.invocation_dir.as_path(),
-        )
+        .env(cli::INVOCATION_DIR_ENV_VAR, shim_args.cwd.as_path())
         .current_dir(cwd)
         .stdout(Stdio::inherit())
         .stderr(Stdio::inherit());
@@ -759,12 +766,14 @@ pub fn run() -> Result<i32, Error> {
     if is_turbo_binary_path_set() {
         let repo_state = RepoState::infer(&args.cwd)?;
         debug!("Repository Root: {}", repo_state.root);
+        let _ = REPO_ROOT.set(repo_state.root.clone());
         return Ok(cli::run(Some(repo_state), &subscriber, ui)?);
     }
 
     match RepoState::infer(&args.cwd) {
         Ok(repo_state) => {
             debug!("Repository Root: {}", repo_state.root);
+            let _ = REPO_ROOT.set(repo_state.root.clone());
             run_correct_turbo(repo_state, args, &subscriber, ui)
         }
         Err(err) => {
@@ -19,13 +19,21 @@ pub struct SignalHandler {
 struct HandlerState {
     subscribers: Vec<oneshot::Sender<oneshot::Sender<()>>>,
     is_closing: bool,
+    // Whether the shutdown was caused by the signal rather than `close`
+    interrupted: bool,
 }
 
-pub struct SignalSubscriber(oneshot::Receiver<oneshot::Sender<()>>);
+pub struct SignalSubscriber {
+    receiver: oneshot::Receiver<oneshot::Sender<()>>,
+    state: Arc<Mutex<HandlerState>>,
+}
 
 /// SubscriberGuard should be kept until a subscriber is done processing the
 /// signal
-pub struct SubscriberGuard(oneshot::Sender<()>);
+pub struct SubscriberGuard {
+    _done: oneshot::Sender<()>,
+    interrupted: bool,
+}
 
 impl SignalHandler {
     /// Construct a new SignalHandler that will alert any subscribers when
@@ -36,20 +44,21 @@ impl SignalHandler {
         let worker_state = state.clone();
         let (close, mut rx) = mpsc::channel::<()>(1);
         tokio::spawn(async move {
-            tokio::select! {
+            let interrupted = tokio::select! {
                 // We don't care if we get a signal or if we are unable to receive signals
                 // Either way we start the shutdown.
-                _ = signal_source => {},
+                signal = signal_source => signal.is_some(),
                 // We don't care if a close message was sent or if all handlers are dropped.
                 // Either way start the shutdown process.
-                _ = rx.recv() => {}
-            }
+                _ = rx.recv() => false,
+            };
 
             let mut callbacks = {
                 let mut state = worker_state.lock().expect("lock poisoned");
                 // Mark ourselves as closing to prevent any additional subscribers from being
                 // added
                 state.is_closing = true;
+                state.interrupted = interrupted;
                 state
                     .subscribers
                     .drain(..)
@@ -77,7 +86,10 @@ impl SignalHandler {
             .lock()
             .expect("poisoned lock")
             .add_subscriber()
-            .map(SignalSubscriber)
+            .map(|receiver| SignalSubscriber {
+                receiver,
+                state: self.state.clone(),
+            })
     }
 
     /// Send message to signal handler that it should shut down and alert
@@ -109,10 +121,22 @@ impl SignalSubscriber {
     /// Wait until signal is received by the signal handler
     pub async fn listen(self) -> SubscriberGuard {
         let callback = self
-            .0
+            .receiver
             .await
             .expect("signal handler worker thread exited without alerting subscribers");
-        SubscriberGuard(callback)
+        let interrupted = self.state.lock().expect("lock poisoned").interrupted;
+        SubscriberGuard {
+            _done: callback,
+            interrupted,
+        }
+    }
+}
+
+impl SubscriberGuard {
+    /// Whether a signal was received, as opposed to the handler being closed
+    /// once the work it guards finished
+    pub fn is_interrupt(&self) -> bool {
+        self.interrupted
     }
 }
 
@@ -148,6 +172,7 @@ mod test {
         });
 
         let _guard = subscriber.listen().await;
+        assert!(_guard.is_interrupt());
         assert_matches!(
             is_done.try_recv(),
             Err(oneshot::error::TryRecvError::Empty),
@@ -172,6 +197,7 @@ mod test {
         });
 
         let _guard = subscriber.listen().await;
+        assert!(!_guard.is_interrupt());
         assert_matches!(
             is_close_done.try_recv(),
             Err(oneshot::error::TryRecvError::Empty),
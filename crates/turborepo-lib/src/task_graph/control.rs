@@ -0,0 +1,167 @@
+//! Reads scheduler commands from stdin so that a run can be paused, resumed,
+//! or resized without being killed. This is opt-in with `--scheduler-control`.
+//!
+//! Commands are entered one per line:
+//! - `p` pauses dispatching of new tasks
+//! - `r` resumes dispatching
+//! - `c <n>` sets the concurrency limit to `n`
+
+use std::{
+    io::BufRead,
+    sync::{
+        atomic::{AtomicBool, Ordering},
+        Arc,
+    },
+};
+
+use tokio::{sync::mpsc, task::JoinHandle};
+use tracing::debug;
+use turborepo_ui::{cprintln, GREY, UI};
+
+use crate::engine::SchedulerControl;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum ControlCommand {
+    Pause,
+    Resume,
+    Concurrency(usize),
+}
+
+impl ControlCommand {
+    fn parse(line: &str) -> Option<Self> {
+        let mut parts = line.split_whitespace();
+        let command = match parts.next()? {
+            "p" | "pause" => Self::Pause,
+            "r" | "resume" => Self::Resume,
+            "c" | "concurrency" => Self::Concurrency(parts.next()?.parse().ok()?),
+            _ => return None,
+        };
+        // Ignore anything with trailing input as it likely wasn't meant for us
+        parts.next().is_none().then_some(command)
+    }
+}
+
+/// Listens for scheduler commands until it's dropped or stdin is closed.
+pub struct ControlListener {
+    stopped: Arc<AtomicBool>,
+    commands: JoinHandle<()>,
+}
+
+impl Drop for ControlListener {
+    fn drop(&mut self) {
+        self.stopped.store(true, Ordering::Relaxed);
+        self.commands.abort();
+    }
+}
+
+/// Starts listening for commands on stdin. The returned listener should be
+/// dropped when the run finishes.
+pub fn listen(control: SchedulerControl, ui: UI) -> ControlListener {
+    let (sender, mut receiver) = mpsc::unbounded_channel();
+    let stopped = Arc::new(AtomicBool::new(false));
+
+    // Reads from stdin block so they get their own thread instead of tying up
+    // the runtime. The thread isn't joined, it exits once it notices the
+    // listener was dropped.
+    std::thread::spawn({
+        let stopped = stopped.clone();
+        move || {
+            let stdin = std::io::stdin();
+            let mut line = String::new();
+            while wait_for_input(&stopped) {
+                line.clear();
+                if !matches!(stdin.lock().read_line(&mut line), Ok(read) if read > 0) {
+                    break;
+                }
+                let Some(command) = ControlCommand::parse(&line) else {
+                    debug!("ignoring unknown scheduler command: {}", line.trim());
+                    continue;
+                };
+                if stopped.load(Ordering::Relaxed) || sender.send(command).is_err() {
+                    break;
+                }
+            }
+        }
+    });
+
+    let commands = tokio::spawn(async move {
+        while let Some(command) = receiver.recv().await {
+            match command {
+                ControlCommand::Pause => {
+                    control.pause();
+                    cprintln!(
+                        ui,
+                        GREY,
+                        "• Paused, running tasks will finish. Enter `r` to resume"
+                    );
+                }
+                ControlCommand::Resume => {
+                    control.resume();
+                    cprintln!(ui, GREY, "• Resumed");
+                }
+                ControlCommand::Concurrency(concurrency) => {
+                    control.set_concurrency(concurrency);
+                    cprintln!(ui, GREY, "• Concurrency set to {}", control.concurrency());
+                }
+            }
+        }
+    });
+
+    ControlListener { stopped, commands }
+}
+
+// How often a waiting reader checks whether the listener was dropped
+#[cfg(unix)]
+const POLL_INTERVAL_MS: libc::c_int = 100;
+
+// Waits for a line to be entered, returning false if the listener is dropped
+// first
+#[cfg(unix)]
+fn wait_for_input(stopped: &AtomicBool) -> bool {
+    use std::os::fd::AsRawFd;
+
+    let mut stdin = libc::pollfd {
+        fd: std::io::stdin().as_raw_fd(),
+        events: libc::POLLIN,
+        revents: 0,
+    };
+    while !stopped.load(Ordering::Relaxed) {
+        // SAFETY: `stdin` is a valid pollfd for the duration of the call and
+        // we only pass one of them
+        let ready = unsafe { libc::poll(&mut stdin, 1, POLL_INTERVAL_MS) };
+        match ready {
+            0 => continue,
+            -1 if std::io::Error::last_os_error().kind() == std::io::ErrorKind::Interrupted => {
+                continue
+            }
+            // Either there's input or stdin was closed, which the read reports
+            _ => return true,
+        }
+    }
+    false
+}
+
+// Without a portable way to wait on stdin, the reader only notices the
+// listener was dropped after the next line is entered
+#[cfg(not(unix))]
+fn wait_for_input(stopped: &AtomicBool) -> bool {
+    !stopped.load(Ordering::Relaxed)
+}
+
+#[cfg(test)]
+mod test {
+    use test_case::test_case;
+
+    use super::ControlCommand;
+
+    #[test_case("p", Some(ControlCommand::Pause) ; "pause")]
+    #[test_case(" resume ", Some(ControlCommand::Resume) ; "resume")]
+    #[test_case("c 4", Some(ControlCommand::Concurrency(4)) ; "concurrency")]
+    #[test_case("c", None ; "concurrency missing value")]
+    #[test_case("c four", None ; "concurrency invalid value")]
+    #[test_case("p now", None ; "trailing input")]
+    #[test_case("", None ; "empty")]
+    fn test_parse_command(line: &str, expected: Option<ControlCommand>) {
+        assert_eq!(ControlCommand::parse(line), expected);
+    }
+}
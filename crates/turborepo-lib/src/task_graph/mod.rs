@@ -1,3 +1,5 @@
+mod control;
+mod timestamps;
 mod visitor;
 
 use std::str::FromStr;
@@ -44,6 +46,14 @@ pub struct TaskDefinition {
     pub outputs: TaskOutputs,
     pub(crate) cache: bool,
 
+    // Whether or not the task logs are included in the cached outputs. If this is
+    // false and there are no outputs, then nothing is cached for the task.
+    pub(crate) cache_logs: bool,
+
+    // Whether the task should be executed on every run, even if its inputs haven't
+    // changed. Logs are still captured, but nothing is read from or written to the cache.
+    pub(crate) always_run: bool,
+
     // This field is custom-marshalled from `env` and `depends_on``
     pub(crate) env: Vec<String>,
 
@@ -67,27 +77,46 @@ pub struct TaskDefinition {
     // we can conclude that any cached outputs or logs for this Task should be invalidated.
     pub(crate) inputs: Vec<String>,
 
+    // RootInputs are globs, relative to the repository root, of files that the task
+    // depends on in every package. Unlike globalDependencies, changing one of these
+    // files only invalidates the tasks that declare them.
+    pub(crate) root_inputs: Vec<String>,
+
+    // InputsFrom are tasks in the same package whose outputs are inputs of this task,
+    // e.g. the files generated by a codegen task. They're hashed after those tasks run,
+    // which is why they're also task dependencies.
+    pub(crate) inputs_from: Vec<String>,
+
     // OutputMode determines how we should log the output.
     pub(crate) output_mode: OutputLogsMode,
 
     // Persistent indicates whether the Task is expected to exit or not
     // Tasks marked Persistent do not exit (e.g. --watch mode or dev servers)
     pub persistent: bool,
+
+    // Priority is how eagerly the task is dispatched once it's ready. Ready tasks
+    // with a higher priority get the next free slot first.
+    pub(crate) priority: i32,
 }
 
 impl Default for TaskDefinition {
     fn default() -> Self {
         Self {
             cache: true,
+            cache_logs: true,
+            always_run: false,
             outputs: Default::default(),
             env: Default::default(),
             pass_through_env: Default::default(),
             topological_dependencies: Default::default(),
             task_dependencies: Default::default(),
             inputs: Default::default(),
+            root_inputs: Default::default(),
+            inputs_from: Default::default(),
             output_mode: Default::default(),
             persistent: Default::default(),
             dot_env: Default::default(),
+            priority: Default::default(),
         }
     }
 }
@@ -103,6 +132,8 @@ impl FromIterator<RawTaskDefinition> for RawTaskDefinition {
 }
 
 const LOG_DIR: &str = ".turbo";
+// Scratch directories are kept in `.turbo/tmp` of each workspace
+const SCRATCH_DIR: &str = "tmp";
 
 impl TaskDefinition {
     pub fn workspace_relative_log_file(task_name: &str) -> AnchoredSystemPathBuf {
@@ -111,6 +142,32 @@ impl TaskDefinition {
         log_dir.join_component(&task_log_filename(task_name))
     }
 
+    /// The marker written alongside the logs when a failed task is cached.
+    /// Its contents are the exit code of the task.
+    pub fn workspace_relative_failure_marker(task_name: &str) -> AnchoredSystemPathBuf {
+        let log_dir = AnchoredSystemPath::new(LOG_DIR)
+            .expect("LOG_DIR should be a valid AnchoredSystemPathBuf");
+        log_dir.join_component(&task_failure_marker_filename(task_name))
+    }
+
+    /// The directory a task can write temporary files to, which is passed to
+    /// it as `TURBO_SCRATCH_DIR`. It's emptied before every run of the task
+    /// and its contents are never hashed or cached.
+    pub fn workspace_relative_scratch_dir(task_name: &str) -> AnchoredSystemPathBuf {
+        let log_dir = AnchoredSystemPath::new(LOG_DIR)
+            .expect("LOG_DIR should be a valid AnchoredSystemPathBuf");
+        log_dir
+            .join_component(SCRATCH_DIR)
+            .join_component(&task_name.replace(':', "$colon$"))
+    }
+
+    /// Whether a workspace or repo relative unix path is inside a scratch
+    /// directory
+    pub fn is_scratch_path(path: &str) -> bool {
+        let scratch_dir = format!("{LOG_DIR}/{SCRATCH_DIR}/");
+        path.starts_with(&scratch_dir) || path.contains(&format!("/{scratch_dir}"))
+    }
+
     fn sharable_workspace_relative_log_file(task_name: &str) -> RelativeUnixPathBuf {
         let log_dir = RelativeUnixPathBuf::new(LOG_DIR)
             .expect("LOG_DIR should be a valid relative unix path");
@@ -118,8 +175,11 @@ impl TaskDefinition {
     }
 
     pub fn hashable_outputs(&self, task_name: &TaskId) -> TaskOutputs {
-        let mut inclusion_outputs =
-            vec![Self::sharable_workspace_relative_log_file(task_name.task()).to_string()];
+        let mut inclusion_outputs = Vec::new();
+        if self.cache_logs {
+            inclusion_outputs
+                .push(Self::sharable_workspace_relative_log_file(task_name.task()).to_string());
+        }
         inclusion_outputs.extend_from_slice(&self.outputs.inclusions[..]);
 
         let mut hashable = TaskOutputs {
@@ -149,6 +209,12 @@ impl TaskDefinition {
         // the processing in the rest of the function converts this to be repo
         // relative.
         let mut repo_relative_globs = self.hashable_outputs(task_name);
+        // Scratch directories are never cached, even when they match an output.
+        // This isn't part of the hashable outputs so that it doesn't change
+        // task hashes.
+        repo_relative_globs
+            .exclusions
+            .push(format!("{LOG_DIR}/{SCRATCH_DIR}/**"));
 
         for input in repo_relative_globs.inclusions.iter_mut() {
             let relative_input = make_glob_repo_relative(input.as_str());
@@ -168,11 +234,16 @@ fn task_log_filename(task_name: &str) -> String {
     format!("turbo-{}.log", task_name.replace(':', "$colon$"))
 }
 
+fn task_failure_marker_filename(task_name: &str) -> String {
+    format!("turbo-{}.failed", task_name.replace(':', "$colon$"))
+}
+
 #[cfg(test)]
 mod test {
     use std::path::MAIN_SEPARATOR_STR;
 
     use pretty_assertions::assert_eq;
+    use test_case::test_case;
 
     use super::*;
 
@@ -205,11 +276,43 @@ mod test {
                     format!("{relative_prefix}.next/**/*"),
                     format!("{relative_prefix}.turbo/turbo-build.log"),
                 ],
-                exclusions: vec![format!("{relative_prefix}.next/bad-file")],
+                exclusions: vec![
+                    format!("{relative_prefix}.next/bad-file"),
+                    format!("{relative_prefix}.turbo/tmp/**"),
+                ],
             }
         );
     }
 
+    #[test]
+    fn test_hashable_outputs_without_logs() {
+        let task_defn = TaskDefinition {
+            outputs: TaskOutputs {
+                inclusions: vec!["dist/**".to_string()],
+                exclusions: vec![],
+            },
+            cache_logs: false,
+            ..Default::default()
+        };
+
+        let task_id = TaskId::new("foo", "build");
+        assert_eq!(
+            task_defn.hashable_outputs(&task_id),
+            TaskOutputs {
+                inclusions: vec!["dist/**".to_string()],
+                exclusions: vec![],
+            }
+        );
+    }
+
+    #[test_case(".turbo/tmp/build/out.txt", true ; "workspace scratch file")]
+    #[test_case("apps/web/.turbo/tmp/build", true ; "repo relative scratch file")]
+    #[test_case(".turbo/turbo-build.log", false ; "log file")]
+    #[test_case("src/.turbo/tmpfile", false ; "similar name")]
+    fn test_is_scratch_path(path: &str, expected: bool) {
+        assert_eq!(TaskDefinition::is_scratch_path(path), expected);
+    }
+
     #[test]
     fn test_escape_log_file() {
         let build_log = TaskDefinition::workspace_relative_log_file("build");
@@ -232,4 +335,14 @@ mod test {
         .unwrap();
         assert_eq!(build_log, build_expected);
     }
+
+    #[test]
+    fn test_escape_failure_marker() {
+        let marker = TaskDefinition::workspace_relative_failure_marker("build:prod");
+        let expected = AnchoredSystemPathBuf::from_raw(
+            [".turbo", "turbo-build$colon$prod.failed"].join(MAIN_SEPARATOR_STR),
+        )
+        .unwrap();
+        assert_eq!(marker, expected);
+    }
 }
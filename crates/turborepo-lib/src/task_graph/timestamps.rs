@@ -0,0 +1,86 @@
+use std::{io::Write, time::Instant};
+
+use chrono::Local;
+
+use crate::cli::LogTimestamps;
+
+/// Prefixes every line of task output with a timestamp before passing it on.
+/// Task output is written a line at a time, so each write starts a new line.
+pub struct TimestampedWriter<W> {
+    timestamps: Option<LogTimestamps>,
+    start: Instant,
+    writer: W,
+}
+
+impl<W: Write> TimestampedWriter<W> {
+    pub fn new(timestamps: Option<LogTimestamps>, writer: W) -> Self {
+        Self {
+            timestamps,
+            start: Instant::now(),
+            writer,
+        }
+    }
+
+    fn timestamp(&self) -> Option<String> {
+        Some(match self.timestamps? {
+            LogTimestamps::WallClock => format!("[{}] ", Local::now().format("%H:%M:%S%.3f")),
+            LogTimestamps::Relative => {
+                format!("[+{:.3}s] ", self.start.elapsed().as_secs_f64())
+            }
+        })
+    }
+}
+
+impl<W: Write> Write for TimestampedWriter<W> {
+    fn write(&mut self, buf: &[u8]) -> std::io::Result<usize> {
+        match self.timestamp() {
+            // The line is written in one go so that the prefixes added further
+            // down are only written once
+            Some(timestamp) => {
+                let mut line = timestamp.into_bytes();
+                line.extend_from_slice(buf);
+                self.writer.write_all(&line)?;
+                Ok(buf.len())
+            }
+            None => self.writer.write(buf),
+        }
+    }
+
+    fn flush(&mut self) -> std::io::Result<()> {
+        self.writer.flush()
+    }
+}
+
+#[cfg(test)]
+mod test {
+    use std::io::Write;
+
+    use regex::Regex;
+    use test_case::test_case;
+
+    use super::TimestampedWriter;
+    use crate::cli::LogTimestamps;
+
+    #[test_case(None, r"^one\ntwo\n$" ; "none")]
+    #[test_case(
+        Some(LogTimestamps::WallClock),
+        r"^\[\d{2}:\d{2}:\d{2}\.\d{3}\] one\n\[\d{2}:\d{2}:\d{2}\.\d{3}\] two\n$"
+        ; "wall clock"
+    )]
+    #[test_case(
+        Some(LogTimestamps::Relative),
+        r"^\[\+\d+\.\d{3}s\] one\n\[\+\d+\.\d{3}s\] two\n$"
+        ; "relative"
+    )]
+    fn test_timestamped_writer(timestamps: Option<LogTimestamps>, expected: &str) {
+        let mut output = Vec::new();
+        let mut writer = TimestampedWriter::new(timestamps, &mut output);
+        writer.write_all(b"one\n").unwrap();
+        writer.write_all(b"two\n").unwrap();
+        let output = String::from_utf8(output).unwrap();
+        assert!(
+            Regex::new(expected).unwrap().is_match(&output),
+            "{output:?} doesn't match {expected}"
+        );
+    }
+}
@@ -1,44 +1,55 @@
 use std::{
     borrow::Cow,
-    collections::HashSet,
-    io::Write,
+    collections::{HashMap, HashSet},
+    io::{IsTerminal, Write},
     sync::{Arc, Mutex, OnceLock},
-    time::{Duration, Instant},
+    time::{Duration, Instant, SystemTime},
 };
 
 use console::{Style, StyledObject};
 use futures::{stream::FuturesUnordered, StreamExt};
 use regex::Regex;
 use tokio::sync::{mpsc, oneshot};
-use tracing::{debug, error, Instrument, Span};
-use turbopath::{AbsoluteSystemPath, AbsoluteSystemPathBuf, AnchoredSystemPath};
+use tracing::{debug, error, warn, Instrument, Span};
+use turbopath::{
+    AbsoluteSystemPath, AbsoluteSystemPathBuf, AnchoredSystemPath, AnchoredSystemPathBuf,
+};
 use turborepo_ci::{Vendor, VendorBehavior};
 use turborepo_env::{EnvironmentVariableMap, ResolvedEnvMode};
 use turborepo_repository::{
     package_graph::{PackageGraph, PackageName, ROOT_PKG_NAME},
     package_manager::PackageManager,
 };
+use turborepo_scm::SCM;
 use turborepo_telemetry::events::{
     generic::GenericEventBuilder, task::PackageTaskEventBuilder, EventBuilder, TrackedErrors,
 };
-use turborepo_ui::{ColorSelector, OutputClient, OutputSink, OutputWriter, PrefixedUI, UI};
+use turborepo_ui::{
+    tui::{AppSender, TuiTask},
+    ColorSelector, OutputClient, OutputSink, OutputWriter, PrefixedUI, UI,
+};
 use which::which;
 
 use crate::{
-    cli::EnvMode,
-    engine::{Engine, ExecutionOptions, StopExecution},
+    cli::{EnvMode, LogTimestamps},
+    engine::{Engine, ExecutionOptions, SchedulerControl, StopExecution, TaskNode},
     opts::RunOpts,
     process::{ChildExit, Command, ProcessManager},
     run::{
+        affected::AffectedFiles,
         global_hash::GlobalHashableInputs,
+        hooks::{Hooks, PostTaskPayload, TaskStatus},
+        lock,
         summary::{
             self, GlobalHashSummary, RunTracker, SpacesTaskClient, SpacesTaskInformation,
             TaskExecutionSummary, TaskTracker,
         },
         task_access::TaskAccess,
         task_id::TaskId,
-        RunCache, TaskCache,
+        task_status::{TaskState, TaskStatusPublisher},
+        FailureKind, RunCache, TaskCache,
     },
+    task_graph::{timestamps::TimestampedWriter, TaskDefinition},
     task_hash::{self, PackageInputsHashes, TaskHashTracker, TaskHashTrackerState, TaskHasher},
 };
 
@@ -46,6 +57,13 @@ use crate::{
 pub struct Visitor<'a> {
     color_cache: ColorSelector,
     dry: bool,
+    hash_only: bool,
+    // Set when tests should only run for the files that changed
+    affected_files: Option<AffectedFiles>,
+    hooks: Option<Arc<Hooks>>,
+    task_status: Option<TaskStatusPublisher>,
+    // Set when task output is shown in the terminal UI
+    tui: Option<AppSender>,
     global_env: EnvironmentVariableMap,
     global_env_mode: EnvMode,
     manager: ProcessManager,
@@ -100,9 +118,12 @@ impl<'a> Visitor<'a> {
         manager: ProcessManager,
         repo_root: &'a AbsoluteSystemPath,
         global_env: EnvironmentVariableMap,
+        scm: &'a SCM,
     ) -> Self {
         let task_hasher = TaskHasher::new(
             package_inputs_hashes,
+            scm,
+            repo_root,
             run_opts,
             env_at_execution_start,
             global_hash,
@@ -113,6 +134,11 @@ impl<'a> Visitor<'a> {
         Self {
             color_cache,
             dry: false,
+            hash_only: false,
+            affected_files: None,
+            hooks: None,
+            task_status: None,
+            tui: None,
             global_env_mode,
             manager,
             run_opts,
@@ -136,15 +162,47 @@ impl<'a> Visitor<'a> {
     ) -> Result<Vec<TaskError>, Error> {
         let concurrency = self.run_opts.concurrency as usize;
         let (node_sender, mut node_stream) = mpsc::channel(concurrency);
+        let control = SchedulerControl::new(concurrency);
+        // Scheduler commands are only read if someone could be typing them and
+        // nothing else needs stdin, like the terminal UI or a persistent task.
+        // The listener stops when it's dropped at the end of the run.
+        let _control_listener = (self.run_opts.scheduler_control
+            && !self.dry
+            && !self.hash_only
+            && self.tui.is_none()
+            && !engine
+                .task_definitions()
+                .values()
+                .any(|definition| definition.persistent)
+            && std::io::stdin().is_terminal())
+        .then(|| super::control::listen(control.clone(), self.ui));
         let engine_handle = {
             let engine = engine.clone();
-            tokio::spawn(engine.execute(ExecutionOptions::new(false, concurrency), node_sender))
+            let options = ExecutionOptions::new(false, concurrency)
+                .with_task_concurrency(
+                    self.run_opts
+                        .task_concurrency
+                        .iter()
+                        .map(|(task, limit)| (task.clone(), *limit as usize))
+                        .collect(),
+                )
+                .with_control(control);
+            tokio::spawn(engine.execute(options, node_sender))
         };
         let mut tasks = FuturesUnordered::new();
         let errors = Arc::new(Mutex::new(Vec::new()));
+        // Tasks that failed or were skipped, so that their dependents can be
+        // skipped with `--continue=dependencies-successful`
+        let failed_tasks = Arc::new(Mutex::new(HashSet::new()));
         let span = Span::current();
 
-        let factory = ExecContextFactory::new(self, errors.clone(), self.manager.clone(), &engine);
+        let factory = ExecContextFactory::new(
+            self,
+            errors.clone(),
+            failed_tasks.clone(),
+            self.manager.clone(),
+            &engine,
+        );
 
         while let Some(message) = node_stream.recv().await {
             let span = tracing::debug_span!(parent: &span, "queue_task", task = %message.info);
@@ -198,6 +256,44 @@ impl<'a> Visitor<'a> {
             package_task_event.track_env_mode(&task_env_mode.to_string());
 
             let dependency_set = engine.dependencies(&info).ok_or(Error::MissingDefinition)?;
+            let failed_dependency = match self.run_opts.skip_dependents_of_failures {
+                true => {
+                    let failed_tasks = failed_tasks.lock().expect("lock poisoned");
+                    dependency_set.iter().find_map(|node| match node {
+                        TaskNode::Task(task) if failed_tasks.contains(task) => Some(task.clone()),
+                        _ => None,
+                    })
+                }
+                false => None,
+            };
+
+            let affected_args = self
+                .affected_files
+                .as_ref()
+                .zip(command.as_deref())
+                .and_then(|(affected_files, command)| {
+                    affected_files.args_for_task(&package_name, command)
+                })
+                .unwrap_or_default();
+
+            // The outputs of the tasks in `inputsFrom` are inputs of this task. Those
+            // tasks are dependencies, so they've already run.
+            let generated_inputs = task_definition
+                .inputs_from
+                .iter()
+                .filter_map(|task| {
+                    engine.task_definition(&TaskId::new(info.package(), task).into_owned())
+                })
+                .flat_map(|definition| {
+                    let outputs = &definition.outputs;
+                    outputs.inclusions.iter().cloned().chain(
+                        outputs
+                            .exclusions
+                            .iter()
+                            .map(|exclusion| format!("!{exclusion}")),
+                    )
+                })
+                .collect::<Vec<_>>();
 
             let task_hash_telemetry = package_task_event.child();
             let task_hash = self.task_hasher.calculate_task_hash(
@@ -206,16 +302,36 @@ impl<'a> Visitor<'a> {
                 task_env_mode,
                 workspace_info,
                 dependency_set,
+                &affected_args,
+                &generated_inputs,
                 task_hash_telemetry,
             )?;
 
             debug!("task {} hash is {}", info, task_hash);
+            // In hash only mode we only need the hash, dropping the callback marks the
+            // task as finished so dependent tasks can be hashed.
+            if self.hash_only {
+                continue;
+            }
             // We do this calculation earlier than we do in Go due to the `task_hasher`
             // being !Send. In the future we can look at doing this right before
             // task execution instead.
             let execution_env =
                 self.task_hasher
                     .env(&info, task_env_mode, task_definition, &self.global_env)?;
+            // Reported in dry runs so that tasks can be audited before strict mode
+            // is enforced
+            if self.dry {
+                let strict_env = self.task_hasher.env(
+                    &info,
+                    ResolvedEnvMode::Strict,
+                    task_definition,
+                    &self.global_env,
+                )?;
+                self.task_hasher
+                    .task_hash_tracker()
+                    .insert_strict_env(info.clone().into_owned(), strict_env);
+            }
 
             let task_cache = self.run_cache.task_cache(
                 task_definition,
@@ -238,6 +354,23 @@ impl<'a> Visitor<'a> {
                     }));
                 }
                 false => {
+                    if let Some(failed_dependency) = failed_dependency {
+                        failed_tasks
+                            .lock()
+                            .expect("lock poisoned")
+                            .insert(info.clone().into_owned());
+                        // Tasks without a script never run, so only their dependents are
+                        // affected. Dropping the callback lets the engine move on to them.
+                        if command.as_deref().map_or(false, |s| !s.is_empty()) {
+                            self.report_skipped(&info, &task_hash, &failed_dependency);
+                            let tracker = self.run_tracker.track_task(info.into_owned());
+                            tasks.push(tokio::spawn(async move {
+                                tracker.skipped(&failed_dependency).await;
+                            }));
+                        }
+                        continue;
+                    }
+
                     // TODO(gsoltis): if/when we fix https://github.com/vercel/turbo/issues/937
                     // the following block should never get hit. In the meantime, keep it after
                     // hashing so that downstream tasks can count on the hash existing
@@ -258,12 +391,13 @@ impl<'a> Visitor<'a> {
                         execution_env,
                         persistent,
                         self.task_access.clone(),
+                        affected_args,
                     );
 
                     let vendor_behavior =
                         Vendor::infer().and_then(|vendor| vendor.behavior.as_ref());
 
-                    let output_client = self.output_client(&info, vendor_behavior);
+                    let output_client = self.output_client(&info, vendor_behavior, persistent);
                     let tracker = self.run_tracker.track_task(info.clone().into_owned());
                     let spaces_client = self.run_tracker.spaces_task_client();
                     let parent_span = Span::current();
@@ -316,6 +450,7 @@ impl<'a> Visitor<'a> {
     pub(crate) async fn finish(
         self,
         exit_code: i32,
+        failure: Option<FailureKind>,
         packages: HashSet<PackageName>,
         global_hash_inputs: GlobalHashableInputs<'_>,
         engine: &Engine,
@@ -329,15 +464,49 @@ impl<'a> Visitor<'a> {
             repo_root,
             global_env_mode,
             task_hasher,
+            run_cache,
             ..
         } = self;
 
+        let task_hash_tracker = task_hasher.task_hash_tracker();
+        let summarize = run_opts.summarize.flatten().is_some_and(|s| s);
+        // Uploads happen in the background so we wait for them to finish in order to
+        // include them in the summary, or to report the ones that were skipped. The
+        // cache waits for them on shutdown regardless.
+        run_cache.wait_for_writes().await;
+        let network_usage = run_cache.network_usage();
+        if summarize || run_cache.max_artifact_size().is_some() {
+            for (task_id, hash) in task_hash_tracker.hashes() {
+                if let Some(upload) = run_cache.upload_metadata(&hash) {
+                    task_hash_tracker.insert_upload_metadata(task_id.clone(), upload);
+                }
+                if let Some(download) = run_cache.download_metadata(&hash) {
+                    task_hash_tracker.insert_download_metadata(task_id.clone(), download);
+                }
+                if let (Some(size), Some(limit)) = (
+                    run_cache.skipped_upload(&hash),
+                    run_cache.max_artifact_size(),
+                ) {
+                    warn!(
+                        "skipped uploading {task_id} to the remote cache: its artifact is {size} \
+                         bytes, over the limit of {limit} bytes"
+                    );
+                }
+            }
+        }
+
+        let global_env_vars = global_hash_inputs
+            .resolved_env_vars
+            .as_ref()
+            .map(|env_vars| env_vars.all.clone())
+            .unwrap_or_default();
         let global_hash_summary = GlobalHashSummary::try_from(global_hash_inputs)?;
 
         Ok(self
             .run_tracker
             .finish(
                 exit_code,
+                failure,
                 &package_graph,
                 ui,
                 repo_root,
@@ -347,14 +516,18 @@ impl<'a> Visitor<'a> {
                 global_hash_summary,
                 global_env_mode,
                 engine,
-                task_hasher.task_hash_tracker(),
+                task_hash_tracker,
                 env_at_execution_start,
+                &global_env_vars,
+                network_usage,
             )
             .await?)
     }
 
     fn sink(run_opts: &RunOpts) -> OutputSink<StdWriter> {
-        let (out, err) = if run_opts.should_redirect_stderr_to_stdout() {
+        let (out, err) = if run_opts.json {
+            (std::io::stderr().into(), std::io::stderr().into())
+        } else if run_opts.should_redirect_stderr_to_stdout() {
             (std::io::stdout().into(), std::io::stdout().into())
         } else {
             (std::io::stdout().into(), std::io::stderr().into())
@@ -366,8 +539,20 @@ impl<'a> Visitor<'a> {
         &self,
         task_id: &TaskId,
         vendor_behavior: Option<&VendorBehavior>,
+        persistent: bool,
     ) -> OutputClient<impl std::io::Write> {
-        let behavior = match self.run_opts.log_order {
+        // Every task has its own pane in the terminal UI, so there is nothing
+        // for grouping to untangle
+        let log_order = match self.tui {
+            Some(_) => crate::opts::ResolvedLogOrder::Stream,
+            None => self.run_opts.log_order,
+        };
+        let behavior = match log_order {
+            // Grouped output is only written once a task finishes, which a
+            // persistent task never does, so its output is always streamed
+            crate::opts::ResolvedLogOrder::Grouped if persistent => {
+                turborepo_ui::OutputClientBehavior::Passthrough
+            }
             crate::opts::ResolvedLogOrder::Stream if self.run_tracker.spaces_enabled() => {
                 turborepo_ui::OutputClientBehavior::InMemoryBuffer
             }
@@ -377,8 +562,14 @@ impl<'a> Visitor<'a> {
             crate::opts::ResolvedLogOrder::Grouped => turborepo_ui::OutputClientBehavior::Grouped,
         };
 
-        let mut logger = self.sink.logger(behavior);
-        if let Some(vendor_behavior) = vendor_behavior {
+        let mut logger = match &self.tui {
+            Some(tui) => {
+                let task = tui.task(task_id.to_string());
+                OutputSink::new(task.clone().into(), task.into()).logger(behavior)
+            }
+            None => self.sink.logger(behavior),
+        };
+        if let Some(vendor_behavior) = vendor_behavior.filter(|_| self.tui.is_none()) {
             let group_name = if self.run_opts.single_package {
                 task_id.task().to_string()
             } else {
@@ -401,6 +592,8 @@ impl<'a> Visitor<'a> {
 
     fn prefix<'b>(&self, task_id: &'b TaskId) -> Cow<'b, str> {
         match self.run_opts.log_prefix {
+            // The terminal UI shows the output of each task on its own
+            _ if self.tui.is_some() => "".into(),
             crate::opts::ResolvedLogPrefix::Task if self.run_opts.single_package => {
                 task_id.task().into()
             }
@@ -411,6 +604,26 @@ impl<'a> Visitor<'a> {
         }
     }
 
+    fn report_skipped(&self, task_id: &TaskId, task_hash: &str, failed_dependency: &TaskId) {
+        let output_client = self.output_client(task_id, None, false);
+        let prefix = self
+            .color_cache
+            .prefix_with_color(task_hash, &self.prefix(task_id));
+        Self::prefixed_ui(
+            self.ui,
+            self.run_opts.is_github_actions,
+            &output_client,
+            prefix,
+        )
+        .warn(format!(
+            "skipped because {} failed",
+            self.display_task_id(failed_dependency)
+        ));
+        if let Err(e) = output_client.finish(false) {
+            error!("unable to flush output client: {e}");
+        }
+    }
+
     // Task ID as displayed in error messages
     fn display_task_id(&self, task_id: &TaskId) -> String {
         match self.run_opts.single_package {
@@ -449,6 +662,31 @@ impl<'a> Visitor<'a> {
     pub fn dry_run(&mut self) {
         self.dry = true;
     }
+
+    pub fn hash_only(&mut self) {
+        self.hash_only = true;
+    }
+
+    pub fn affected_files(&mut self, affected_files: AffectedFiles) {
+        self.affected_files = Some(affected_files);
+    }
+
+    pub fn hooks(&mut self, hooks: Arc<Hooks>) {
+        self.hooks = Some(hooks);
+    }
+
+    pub fn task_status(&mut self, publisher: TaskStatusPublisher) {
+        self.task_status = Some(publisher);
+    }
+
+    pub fn tui(&mut self, sender: AppSender) {
+        self.tui = Some(sender);
+    }
+
+    /// Returns the hashes of every task that has been visited keyed by task id
+    pub fn task_hashes(&self) -> HashMap<TaskId<'static>, String> {
+        self.task_hasher.task_hash_tracker().hashes()
+    }
 }
 
 // A tiny enum that allows us to use the same type for stdout and stderr without
@@ -457,6 +695,7 @@ enum StdWriter {
     Out(std::io::Stdout),
     Err(std::io::Stderr),
     Null(std::io::Sink),
+    Tui(TuiTask),
 }
 
 impl StdWriter {
@@ -465,6 +704,7 @@ impl StdWriter {
             StdWriter::Out(out) => out,
             StdWriter::Err(err) => err,
             StdWriter::Null(null) => null,
+            StdWriter::Tui(task) => task,
         }
     }
 }
@@ -487,6 +727,12 @@ impl From<std::io::Sink> for StdWriter {
     }
 }
 
+impl From<TuiTask> for StdWriter {
+    fn from(value: TuiTask) -> Self {
+        Self::Tui(value)
+    }
+}
+
 impl std::io::Write for StdWriter {
     fn write(&mut self, buf: &[u8]) -> std::io::Result<usize> {
         self.writer().write(buf)
@@ -502,6 +748,28 @@ fn turbo_regex() -> &'static Regex {
     RE.get_or_init(|| Regex::new(r"(?:^|\s)turbo(?:$|\s)").unwrap())
 }
 
+// Empties a task's scratch directory before it runs. The directory holding
+// every scratch directory gets a `.gitignore` so that scratch files are
+// ignored by git even if `.turbo` isn't.
+fn reset_scratch_dir(scratch_dir: &AbsoluteSystemPath) -> std::io::Result<()> {
+    match scratch_dir.remove_dir_all() {
+        Ok(()) => (),
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => (),
+        Err(e) => return Err(e),
+    }
+    scratch_dir.create_dir_all()?;
+
+    let gitignore = scratch_dir
+        .parent()
+        .expect("scratch directories are nested in .turbo/tmp")
+        .join_component(".gitignore");
+    if !gitignore.exists() {
+        gitignore.create_with_contents("*\n")?;
+    }
+
+    Ok(())
+}
+
 // Error that comes from the execution of the task
 #[derive(Debug, thiserror::Error, Clone)]
 #[error("{task_id}: {cause}")]
@@ -517,16 +785,27 @@ enum TaskErrorCause {
     Spawn { msg: String },
     #[error("command {command} exited ({exit_code})")]
     Exit { command: String, exit_code: i32 },
+    #[error("cached failure exited ({exit_code})")]
+    CachedExit { exit_code: i32 },
 }
 
 impl TaskError {
     pub fn exit_code(&self) -> Option<i32> {
         match self.cause {
-            TaskErrorCause::Exit { exit_code, .. } => Some(exit_code),
+            TaskErrorCause::Exit { exit_code, .. } | TaskErrorCause::CachedExit { exit_code } => {
+                Some(exit_code)
+            }
             _ => None,
         }
     }
 
+    pub fn failure_kind(&self) -> FailureKind {
+        match self.cause {
+            TaskErrorCause::Spawn { .. } => FailureKind::Infrastructure,
+            TaskErrorCause::Exit { .. } | TaskErrorCause::CachedExit { .. } => FailureKind::Task,
+        }
+    }
+
     fn from_spawn(task_id: String, err: std::io::Error) -> Self {
         Self {
             task_id,
@@ -559,6 +838,7 @@ impl TaskErrorCause {
 struct ExecContextFactory<'a> {
     visitor: &'a Visitor<'a>,
     errors: Arc<Mutex<Vec<TaskError>>>,
+    failed_tasks: Arc<Mutex<HashSet<TaskId<'static>>>>,
     manager: ProcessManager,
     engine: &'a Arc<Engine>,
 }
@@ -567,12 +847,14 @@ impl<'a> ExecContextFactory<'a> {
     pub fn new(
         visitor: &'a Visitor,
         errors: Arc<Mutex<Vec<TaskError>>>,
+        failed_tasks: Arc<Mutex<HashSet<TaskId<'static>>>>,
         manager: ProcessManager,
         engine: &'a Arc<Engine>,
     ) -> Self {
         Self {
             visitor,
             errors,
+            failed_tasks,
             manager,
             engine,
         }
@@ -588,13 +870,36 @@ impl<'a> ExecContextFactory<'a> {
         execution_env: EnvironmentVariableMap,
         persistent: bool,
         task_access: TaskAccess,
+        affected_args: Vec<String>,
     ) -> ExecContext {
         let task_id_for_display = self.visitor.display_task_id(&task_id);
-        let pass_through_args = self.visitor.run_opts.args_for_task(&task_id);
+        // Persistent tasks never finish, so they'd hold the lock forever
+        let task_lock_path =
+            (!persistent).then(|| lock::task_lock_path(self.visitor.repo_root, &task_id));
+        // Files a root task writes into the packages belong to those packages
+        let nested_packages = if self.visitor.run_opts.warn_undeclared_outputs
+            && task_id.package() == ROOT_PKG_NAME
+        {
+            self.visitor
+                .package_graph
+                .packages()
+                .filter(|(name, _)| **name != PackageName::Root)
+                .map(|(_, info)| info.package_path().to_owned())
+                .collect()
+        } else {
+            Vec::new()
+        };
+        let mut pass_through_args = self.visitor.run_opts.args_for_task(&task_id);
+        if !affected_args.is_empty() {
+            pass_through_args
+                .get_or_insert_with(Vec::new)
+                .extend(affected_args);
+        }
         ExecContext {
             engine: self.engine.clone(),
             ui: self.visitor.ui,
             is_github_actions: self.visitor.run_opts.is_github_actions,
+            vendor_behavior: Vendor::infer().and_then(|vendor| vendor.behavior.as_ref()),
             pretty_prefix: self
                 .visitor
                 .color_cache
@@ -604,15 +909,25 @@ impl<'a> ExecContextFactory<'a> {
             task_cache,
             hash_tracker: self.visitor.task_hasher.task_hash_tracker(),
             package_manager: *self.visitor.package_graph.package_manager(),
+            repo_root: self.visitor.repo_root.to_owned(),
             workspace_directory,
             manager: self.manager.clone(),
             task_hash,
+            run_id: self.visitor.run_tracker.id().to_string(),
             execution_env,
             continue_on_error: self.visitor.run_opts.continue_on_error,
+            warn_undeclared_outputs: self.visitor.run_opts.warn_undeclared_outputs,
+            nested_packages,
+            log_timestamps: self.visitor.run_opts.log_timestamps,
             pass_through_args,
             errors: self.errors.clone(),
+            failed_tasks: self.failed_tasks.clone(),
             persistent,
             task_access,
+            hooks: self.visitor.hooks.clone(),
+            task_status: self.visitor.task_status.clone(),
+            task_lock_path,
+            task_lock: None,
         }
     }
 
@@ -633,21 +948,34 @@ struct ExecContext {
     engine: Arc<Engine>,
     ui: UI,
     is_github_actions: bool,
+    vendor_behavior: Option<&'static VendorBehavior>,
     pretty_prefix: StyledObject<String>,
     task_id: TaskId<'static>,
     task_id_for_display: String,
     task_cache: TaskCache,
     hash_tracker: TaskHashTracker,
     package_manager: PackageManager,
+    repo_root: AbsoluteSystemPathBuf,
     workspace_directory: AbsoluteSystemPathBuf,
     manager: ProcessManager,
     task_hash: String,
+    run_id: String,
     execution_env: EnvironmentVariableMap,
     continue_on_error: bool,
+    warn_undeclared_outputs: bool,
+    // The package directories that are excluded from a root task's undeclared
+    // outputs
+    nested_packages: Vec<AnchoredSystemPathBuf>,
+    log_timestamps: Option<LogTimestamps>,
     pass_through_args: Option<Vec<String>>,
     errors: Arc<Mutex<Vec<TaskError>>>,
+    failed_tasks: Arc<Mutex<HashSet<TaskId<'static>>>>,
     persistent: bool,
     task_access: TaskAccess,
+    hooks: Option<Arc<Hooks>>,
+    task_status: Option<TaskStatusPublisher>,
+    task_lock_path: Option<AbsoluteSystemPathBuf>,
+    task_lock: Option<pidlock::Pidlock>,
 }
 
 enum ExecOutcome {
@@ -669,9 +997,14 @@ enum SuccessOutcome {
 
 impl ExecContext {
     pub async fn execute_dry_run(&mut self, tracker: TaskTracker<()>) {
-        if let Ok(Some(status)) = self.task_cache.exists().await {
+        // Both caches are checked so the summary can tell where each artifact is
+        if let Ok(cache_state) = self.task_cache.cache_state().await {
+            if let Some(status) = cache_state.hit() {
+                self.hash_tracker
+                    .insert_cache_status(self.task_id.clone(), status);
+            }
             self.hash_tracker
-                .insert_cache_status(self.task_id.clone(), status);
+                .insert_cache_state(self.task_id.clone(), cache_state);
         }
 
         tracker.dry_run().await;
@@ -686,17 +1019,43 @@ impl ExecContext {
         telemetry: &PackageTaskEventBuilder,
     ) {
         let tracker = tracker.start().await;
+        let task_start = Instant::now();
+        if let Some(task_status) = &self.task_status {
+            task_status.publish(
+                &self.task_id,
+                &self.task_hash,
+                TaskState::Running,
+                None,
+                Duration::ZERO,
+            );
+        }
         let span = tracing::debug_span!("execute_task", task = %self.task_id.task());
         span.follows_from(parent_span_id);
         let mut result = self
             .execute_inner(&output_client, telemetry)
-            .instrument(span)
+            .instrument(span.clone())
             .await;
 
+        // Runs waiting on this task restore its results from the cache, so the
+        // lock is held until they've been written
+        if let Some(task_lock) = self.task_lock.take() {
+            if let Some(written) = self.task_cache.take_pending_write() {
+                tokio::spawn(async move {
+                    written.await.ok();
+                    drop(task_lock);
+                });
+            }
+        }
+
+        if let ExecOutcome::Task { message, .. } = &result {
+            self.annotate_error(&output_client, message);
+        }
+
         // If the task resulted in an error, do not group in order to better highlight
         // the error.
         let is_error = matches!(result, ExecOutcome::Task { .. });
-        let logs = match output_client.finish(is_error) {
+        let flush_span = tracing::debug_span!(parent: &span, "flush_logs");
+        let logs = match flush_span.in_scope(|| output_client.finish(is_error)) {
             Ok(logs) => logs,
             Err(e) => {
                 telemetry.track_error(TrackedErrors::DaemonFailedToMarkOutputsAsCached);
@@ -706,6 +1065,30 @@ impl ExecContext {
             }
         };
 
+        let hook_outcome = match &result {
+            ExecOutcome::Success(SuccessOutcome::CacheHit) => Some((TaskStatus::Cached, Some(0))),
+            ExecOutcome::Success(SuccessOutcome::Run) => Some((TaskStatus::Succeeded, Some(0))),
+            ExecOutcome::Task { exit_code, .. } => Some((TaskStatus::Failed, *exit_code)),
+            // The run is being shut down
+            ExecOutcome::Internal => None,
+        };
+        if let Some((task_status, (status, exit_code))) =
+            self.task_status.as_ref().zip(hook_outcome)
+        {
+            let state = match status {
+                TaskStatus::Cached => TaskState::Cached,
+                TaskStatus::Succeeded => TaskState::Succeeded,
+                TaskStatus::Failed => TaskState::Failed,
+            };
+            task_status.publish(
+                &self.task_id,
+                &self.task_hash,
+                state,
+                exit_code,
+                task_start.elapsed(),
+            );
+        }
+
         match result {
             ExecOutcome::Success(outcome) => {
                 let task_summary = match outcome {
@@ -725,6 +1108,11 @@ impl ExecContext {
                 self.manager.stop().await;
             }
             ExecOutcome::Task { exit_code, message } => {
+                // Recorded before the callback is sent so that dependents see it
+                self.failed_tasks
+                    .lock()
+                    .expect("lock poisoned")
+                    .insert(self.task_id.clone());
                 let task_summary = tracker.build_failed(exit_code, message).await;
                 callback
                     .send(match self.continue_on_error {
@@ -756,6 +1144,22 @@ impl ExecContext {
                 }
             }
         }
+
+        // Hooks run after the callback is sent so they don't hold up dependent tasks
+        if let Some((hooks, (status, exit_code))) = self.hooks.as_ref().zip(hook_outcome) {
+            hooks
+                .post_task(&PostTaskPayload {
+                    run_id: &self.run_id,
+                    task_id: self.task_id.to_string(),
+                    package: self.task_id.package(),
+                    task: self.task_id.task(),
+                    hash: &self.task_hash,
+                    status,
+                    exit_code,
+                    duration_ms: task_start.elapsed().as_millis(),
+                })
+                .await;
+        }
     }
 
     async fn execute_inner(
@@ -771,6 +1175,14 @@ impl ExecContext {
             self.pretty_prefix.clone(),
         );
 
+        if let Some(task_lock_path) = &self.task_lock_path {
+            self.task_lock = lock::acquire(task_lock_path, || {
+                prefixed_ui
+                    .output("waiting for another run in this repository to finish this task");
+            })
+            .await;
+        }
+
         match self
             .task_cache
             .restore_outputs(&mut prefixed_ui, telemetry)
@@ -784,9 +1196,28 @@ impl ExecContext {
                 );
                 self.hash_tracker
                     .insert_cache_status(self.task_id.clone(), status);
+                if let Some(exit_code) = self.task_cache.cached_failure() {
+                    let error = TaskErrorCause::CachedExit { exit_code };
+                    let message = error.to_string();
+                    if self.continue_on_error {
+                        prefixed_ui.warn("cached failure, but continuing...");
+                    } else {
+                        prefixed_ui.error(format!("cached failure: {error}"));
+                    }
+                    self.errors.lock().expect("lock poisoned").push(TaskError {
+                        task_id: self.task_id_for_display.clone(),
+                        cause: error,
+                    });
+                    return ExecOutcome::Task {
+                        exit_code: Some(exit_code),
+                        message,
+                    };
+                }
                 return ExecOutcome::Success(SuccessOutcome::CacheHit);
             }
             Ok(None) => (),
+            // The run was interrupted while the artifact was being fetched
+            Err(e) if e.is_cancelled() => return ExecOutcome::Internal,
             Err(e) => {
                 telemetry.track_error(TrackedErrors::ErrorFetchingFromCache);
                 prefixed_ui.error(format!("error fetching from cache: {e}"));
@@ -797,6 +1228,19 @@ impl ExecContext {
             return ExecOutcome::Internal;
         };
 
+        let scratch_dir =
+            self.workspace_directory
+                .resolve(&TaskDefinition::workspace_relative_scratch_dir(
+                    self.task_id.task(),
+                ));
+        if let Err(e) = reset_scratch_dir(&scratch_dir) {
+            error!(
+                "failed to create scratch directory for \"{}\": {e}",
+                self.task_id
+            );
+            return ExecOutcome::Internal;
+        }
+
         let mut cmd = Command::new(package_manager_binary);
         let mut args = vec!["run".to_string(), self.task_id.task().to_string()];
         if let Some(pass_through_args) = &self.pass_through_args {
@@ -815,6 +1259,15 @@ impl ExecContext {
         cmd.envs(self.execution_env.iter());
         // Always last to make sure it overwrites any user configured env var.
         cmd.env("TURBO_HASH", &self.task_hash);
+        cmd.env("TURBO_RUN_ID", &self.run_id);
+        cmd.env("TURBO_TASK", self.task_id.task());
+        cmd.env("TURBO_PACKAGE", self.task_id.package());
+        cmd.env("TURBO_REPO_ROOT", self.repo_root.as_str());
+        cmd.env("TURBO_SCRATCH_DIR", scratch_dir.as_str());
+        cmd.env(
+            "TURBO_CACHE_MISS",
+            self.task_cache.reads_enabled().to_string(),
+        );
         // enable task access tracing
 
         // set the trace file env var - frameworks that support this can use it to
@@ -835,7 +1288,7 @@ impl ExecContext {
             .task_cache
             .output_writer(self.pretty_prefix.clone(), output_client.stdout())
         {
-            Ok(w) => w,
+            Ok(w) => TimestampedWriter::new(self.log_timestamps, w),
             Err(e) => {
                 telemetry.track_error(TrackedErrors::FailedToCaptureOutputs);
                 error!("failed to capture outputs for \"{}\": {e}", self.task_id);
@@ -843,7 +1296,12 @@ impl ExecContext {
             }
         };
 
-        let mut process = match self.manager.spawn(cmd, Duration::from_millis(500)) {
+        let spawn_time = SystemTime::now();
+        // Spawning and waiting on the process share a span so the profile shows
+        // how long the task's command took as a whole
+        let process_span = tracing::debug_span!("run_process");
+        let spawned = process_span.in_scope(|| self.manager.spawn(cmd, Duration::from_millis(500)));
+        let mut process = match spawned {
             Some(Ok(child)) => child,
             // Turbo was unable to spawn a process
             Some(Err(e)) => {
@@ -865,7 +1323,11 @@ impl ExecContext {
             }
         };
 
-        let exit_status = match process.wait_with_piped_outputs(&mut stdout_writer).await {
+        let exit_status = match process
+            .wait_with_piped_outputs(&mut stdout_writer)
+            .instrument(process_span)
+            .await
+        {
             Ok(Some(exit_status)) => exit_status,
             Err(e) => {
                 telemetry.track_error(TrackedErrors::FailedToPipeOutputs);
@@ -901,6 +1363,9 @@ impl ExecContext {
                             self.task_id.clone(),
                             self.task_cache.expanded_outputs().to_vec(),
                         );
+                        if self.warn_undeclared_outputs {
+                            self.report_undeclared_outputs(&mut prefixed_ui, spawn_time);
+                        }
                     }
                 }
 
@@ -915,6 +1380,9 @@ impl ExecContext {
                 if let Err(e) = self.task_cache.on_error(&mut prefixed_ui) {
                     error!("error reading logs: {e}");
                 }
+                if let Err(e) = self.task_cache.save_failure(task_duration, code).await {
+                    error!("error caching failure: {e}");
+                }
                 let error = TaskErrorCause::from_execution(process.label().to_string(), code);
                 let message = error.to_string();
                 if self.continue_on_error {
@@ -939,6 +1407,65 @@ impl ExecContext {
         }
     }
 
+    // Points the CI vendor's error annotation at the package.json of the
+    // package whose task failed, so that the failure shows up on the package
+    fn annotate_error(&self, output_client: &OutputClient<impl Write>, message: &str) {
+        let Some(error_annotation) = self.vendor_behavior.and_then(|b| b.error_annotation) else {
+            return;
+        };
+        let Ok(package_dir) = self.repo_root.anchor(&self.workspace_directory) else {
+            return;
+        };
+        let package_dir = package_dir.to_unix();
+        let file = match package_dir.as_str() {
+            "" => "package.json".to_string(),
+            dir => format!("{dir}/package.json"),
+        };
+        let annotation = error_annotation(
+            &file,
+            &format!("{} failed", self.task_id_for_display),
+            message,
+        );
+        if let Err(e) = output_client.stdout().write_all(annotation.as_bytes()) {
+            debug!("unable to write error annotation: {e}");
+        }
+    }
+
+    fn report_undeclared_outputs<W: Write>(
+        &self,
+        prefixed_ui: &mut PrefixedUI<W>,
+        spawn_time: SystemTime,
+    ) {
+        const MAX_LISTED_FILES: usize = 10;
+        let undeclared = match self
+            .task_cache
+            .undeclared_outputs(spawn_time, &self.nested_packages)
+        {
+            Ok(undeclared) => undeclared,
+            Err(e) => {
+                debug!("unable to check for undeclared outputs: {e}");
+                return;
+            }
+        };
+        if undeclared.is_empty() {
+            return;
+        }
+
+        prefixed_ui.warn(format!(
+            "{} files were written that are not covered by `outputs` and were not cached:",
+            undeclared.len()
+        ));
+        for file in undeclared.iter().take(MAX_LISTED_FILES) {
+            prefixed_ui.warn(format!("  {file}"));
+        }
+        if undeclared.len() > MAX_LISTED_FILES {
+            prefixed_ui.warn(format!(
+                "  ...and {} more",
+                undeclared.len() - MAX_LISTED_FILES
+            ));
+        }
+    }
+
     fn spaces_task_info(
         &self,
         task_id: TaskId<'static>,
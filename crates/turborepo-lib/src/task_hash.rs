@@ -1,17 +1,20 @@
 use std::{
     collections::{HashMap, HashSet},
     sync::{Arc, Mutex},
+    time::{Duration, Instant},
 };
 
 use rayon::prelude::*;
 use serde::Serialize;
 use thiserror::Error;
 use tracing::{debug, Span};
-use turbopath::{AbsoluteSystemPath, AnchoredSystemPath, AnchoredSystemPathBuf};
-use turborepo_cache::CacheHitMetadata;
+use turbopath::{
+    AbsoluteSystemPath, AnchoredSystemPath, AnchoredSystemPathBuf, RelativeUnixPathBuf,
+};
+use turborepo_cache::{CacheDownloadMetadata, CacheHitMetadata, CacheState, CacheUploadMetadata};
 use turborepo_env::{BySource, DetailedMap, EnvironmentVariableMap, ResolvedEnvMode};
 use turborepo_repository::package_graph::{PackageInfo, PackageName};
-use turborepo_scm::SCM;
+use turborepo_scm::{package_deps::GitHashes, SCM};
 use turborepo_telemetry::events::{
     generic::GenericEventBuilder, task::PackageTaskEventBuilder, EventBuilder,
 };
@@ -25,6 +28,10 @@ use crate::{
     task_graph::TaskDefinition,
 };
 
+// A special input for root tasks that limits the files they're hashed with to
+// the ones that aren't inside another workspace
+const INPUT_ROOT_FILES: &str = "$TURBO_ROOT_FILES$";
+
 #[derive(Debug, Error)]
 pub enum Error {
     #[error("missing pipeline entry {0}")]
@@ -63,6 +70,16 @@ impl TaskHashable<'_> {
 pub struct PackageInputsHashes {
     hashes: HashMap<TaskId<'static>, String>,
     expanded_hashes: HashMap<TaskId<'static>, FileHashes>,
+    metrics: HashMap<TaskId<'static>, HashingMetrics>,
+}
+
+/// How long it took to hash the inputs of a task and how many files were
+/// hashed. Useful for tracking down packages with an excessive number of
+/// inputs.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct HashingMetrics {
+    pub duration: Duration,
+    pub file_count: usize,
 }
 
 impl PackageInputsHashes {
@@ -79,7 +96,13 @@ impl PackageInputsHashes {
 
         let span = Span::current();
 
-        let (hashes, expanded_hashes): (HashMap<_, _>, HashMap<_, _>) = all_tasks
+        let package_dirs = workspaces
+            .iter()
+            .filter(|(name, _)| !matches!(name, PackageName::Root))
+            .map(|(_, info)| info.package_path().to_unix())
+            .collect::<Vec<_>>();
+
+        let results = all_tasks
             .filter_map(|task| {
                 let span = tracing::info_span!(parent: &span, "calculate_file_hash", ?task);
                 let _enter = span.enter();
@@ -114,16 +137,34 @@ impl PackageInputsHashes {
                     .parent()
                     .unwrap_or_else(|| AnchoredSystemPath::new("").unwrap());
 
+                // The marker is never a glob, so it's removed from every task's
+                // inputs even though it only has an effect on root tasks
+                let root_files_only = matches!(workspace_name, PackageName::Root)
+                    && task_definition
+                        .inputs
+                        .iter()
+                        .any(|input| input == INPUT_ROOT_FILES);
+                let inputs = task_definition
+                    .inputs
+                    .iter()
+                    .map(String::as_str)
+                    .filter(|input| *input != INPUT_ROOT_FILES)
+                    .collect::<Vec<_>>();
+
+                let start = Instant::now();
                 let scm_telemetry = package_task_event.child();
                 let mut hash_object = match scm.get_package_file_hashes(
                     repo_root,
                     package_path,
-                    &task_definition.inputs,
+                    &inputs,
                     Some(scm_telemetry),
                 ) {
                     Ok(hash_object) => hash_object,
                     Err(err) => return Some(Err(err.into())),
                 };
+                if root_files_only {
+                    retain_root_package_files(&mut hash_object, &package_dirs);
+                }
                 if let Some(dot_env) = &task_definition.dot_env {
                     if !dot_env.is_empty() {
                         let absolute_package_path = repo_root.resolve(package_path);
@@ -140,24 +181,81 @@ impl PackageInputsHashes {
                         }
                     }
                 }
+                if !task_definition.root_inputs.is_empty() {
+                    let root_object = match scm.get_package_file_hashes(
+                        repo_root,
+                        AnchoredSystemPath::empty(),
+                        &task_definition.root_inputs,
+                        None,
+                    ) {
+                        Ok(root_object) => root_object,
+                        Err(err) => return Some(Err(err.into())),
+                    };
+
+                    for (key, value) in root_object {
+                        let key = match root_input_key(package_path, &key) {
+                            Ok(key) => key,
+                            Err(err) => return Some(Err(err.into())),
+                        };
+                        hash_object.insert(key, value);
+                    }
+                }
+
+                hash_object.retain(|path, _| !TaskDefinition::is_scratch_path(path.as_str()));
 
                 let file_hashes = FileHashes(hash_object);
                 let hash = file_hashes.clone().hash();
+                let metrics = HashingMetrics {
+                    duration: start.elapsed(),
+                    file_count: file_hashes.0.len(),
+                };
+                debug!(
+                    "hashed {} files for {} in {:?}",
+                    metrics.file_count, task_id, metrics.duration
+                );
 
-                Some(Ok((
-                    (task_id.clone(), hash),
-                    (task_id.clone(), file_hashes),
-                )))
+                Some(Ok((task_id.clone(), hash, file_hashes, metrics)))
             })
-            .collect::<Result<_, _>>()?;
+            .collect::<Result<Vec<_>, Error>>()?;
+
+        let mut package_inputs_hashes = PackageInputsHashes::default();
+        for (task_id, hash, file_hashes, metrics) in results {
+            package_inputs_hashes.hashes.insert(task_id.clone(), hash);
+            package_inputs_hashes
+                .expanded_hashes
+                .insert(task_id.clone(), file_hashes);
+            package_inputs_hashes.metrics.insert(task_id, metrics);
+        }
 
-        Ok(PackageInputsHashes {
-            hashes,
-            expanded_hashes,
-        })
+        Ok(package_inputs_hashes)
     }
 }
 
+/// Root inputs are hashed relative to the repository root, but the rest of the
+/// task's inputs are keyed relative to the package. This converts a root
+/// relative path to the package relative form, e.g. `patches/foo.patch` becomes
+/// `../../patches/foo.patch` for a package in `packages/ui`.
+fn root_input_key(
+    package_path: &AnchoredSystemPath,
+    path: &RelativeUnixPathBuf,
+) -> Result<RelativeUnixPathBuf, turbopath::PathError> {
+    let mut key = "../".repeat(package_path.components().count());
+    key.push_str(path.as_str());
+    RelativeUnixPathBuf::new(key)
+}
+
+/// The root package contains every other package, so by default a root task
+/// is hashed with every file in the repository. Root tasks that opt in with
+/// `$TURBO_ROOT_FILES$` only depend on the files that belong to the root
+/// package itself.
+fn retain_root_package_files(hashes: &mut GitHashes, package_dirs: &[RelativeUnixPathBuf]) {
+    hashes.retain(|path, _| {
+        !package_dirs
+            .iter()
+            .any(|dir| !dir.as_str().is_empty() && path.strip_prefix(dir).is_ok())
+    });
+}
+
 #[derive(Default, Debug, Clone)]
 pub struct TaskHashTracker {
     state: Arc<Mutex<TaskHashTrackerState>>,
@@ -174,13 +272,28 @@ pub struct TaskHashTrackerState {
     package_task_outputs: HashMap<TaskId<'static>, Vec<AnchoredSystemPathBuf>>,
     #[serde(skip)]
     package_task_cache: HashMap<TaskId<'static>, CacheHitMetadata>,
+    // Where each artifact is cached, only tracked for dry runs
+    #[serde(skip)]
+    package_task_cache_state: HashMap<TaskId<'static>, CacheState>,
+    #[serde(skip)]
+    package_task_uploads: HashMap<TaskId<'static>, CacheUploadMetadata>,
+    #[serde(skip)]
+    package_task_downloads: HashMap<TaskId<'static>, CacheDownloadMetadata>,
     #[serde(skip)]
     package_task_inputs_expanded_hashes: HashMap<TaskId<'static>, FileHashes>,
+    #[serde(skip)]
+    package_task_hashing_metrics: HashMap<TaskId<'static>, HashingMetrics>,
+    // The environment each task would get under strict mode, only tracked for
+    // dry runs
+    #[serde(skip)]
+    package_task_strict_env: HashMap<TaskId<'static>, EnvironmentVariableMap>,
 }
 
 /// Caches package-inputs hashes, and package-task hashes.
 pub struct TaskHasher<'a> {
     hashes: HashMap<TaskId<'static>, String>,
+    scm: &'a SCM,
+    repo_root: &'a AbsoluteSystemPath,
     run_opts: &'a RunOpts,
     env_at_execution_start: &'a EnvironmentVariableMap,
     global_hash: &'a str,
@@ -190,6 +303,8 @@ pub struct TaskHasher<'a> {
 impl<'a> TaskHasher<'a> {
     pub fn new(
         package_inputs_hashes: PackageInputsHashes,
+        scm: &'a SCM,
+        repo_root: &'a AbsoluteSystemPath,
         run_opts: &'a RunOpts,
         env_at_execution_start: &'a EnvironmentVariableMap,
         global_hash: &'a str,
@@ -197,17 +312,29 @@ impl<'a> TaskHasher<'a> {
         let PackageInputsHashes {
             hashes,
             expanded_hashes,
+            metrics,
         } = package_inputs_hashes;
         Self {
             hashes,
+            scm,
+            repo_root,
             run_opts,
             env_at_execution_start,
             global_hash,
-            task_hash_tracker: TaskHashTracker::new(expanded_hashes),
+            task_hash_tracker: TaskHashTracker::new(expanded_hashes, metrics),
         }
     }
 
-    #[tracing::instrument(skip(self, task_definition, task_env_mode, workspace, dependency_set))]
+    #[allow(clippy::too_many_arguments)]
+    #[tracing::instrument(skip(
+        self,
+        task_definition,
+        task_env_mode,
+        workspace,
+        dependency_set,
+        affected_args,
+        generated_inputs
+    ))]
     pub fn calculate_task_hash(
         &self,
         task_id: &TaskId<'static>,
@@ -215,15 +342,25 @@ impl<'a> TaskHasher<'a> {
         task_env_mode: ResolvedEnvMode,
         workspace: &PackageInfo,
         dependency_set: HashSet<&TaskNode>,
+        // Arguments that narrow the task down to changed files
+        affected_args: &[String],
+        // Globs of the files generated by the tasks in `inputsFrom`
+        generated_inputs: &[String],
         telemetry: PackageTaskEventBuilder,
     ) -> Result<String, Error> {
         let do_framework_inference = self.run_opts.framework_inference;
         let is_monorepo = !self.run_opts.single_package;
 
-        let hash_of_files = self
-            .hashes
-            .get(task_id)
-            .ok_or_else(|| Error::MissingPackageFileHash(task_id.to_string()))?;
+        let generated_hash_of_files;
+        let hash_of_files = if generated_inputs.is_empty() {
+            self.hashes
+                .get(task_id)
+                .ok_or_else(|| Error::MissingPackageFileHash(task_id.to_string()))?
+        } else {
+            generated_hash_of_files =
+                self.hash_generated_inputs(task_id, workspace, generated_inputs)?;
+            &generated_hash_of_files
+        };
         let mut explicit_env_var_map = EnvironmentVariableMap::default();
         let mut all_env_var_map = EnvironmentVariableMap::default();
         let mut matching_env_var_map = EnvironmentVariableMap::default();
@@ -313,6 +450,14 @@ impl<'a> TaskHasher<'a> {
             hashable_env_pairs
         );
 
+        let pass_through_args = self
+            .run_opts
+            .pass_through_args
+            .iter()
+            .chain(affected_args)
+            .cloned()
+            .collect::<Vec<_>>();
+
         let package_dir = workspace.package_path().to_unix();
         let is_root_package = package_dir.is_empty();
         // We wrap in an Option to mimic Go's serialization of nullable values
@@ -327,7 +472,7 @@ impl<'a> TaskHasher<'a> {
             task: task_id.task(),
             outputs,
 
-            pass_through_args: &self.run_opts.pass_through_args,
+            pass_through_args: &pass_through_args,
             env: &task_definition.env,
             resolved_env_vars: hashable_env_pairs,
             pass_through_env: task_definition
@@ -350,6 +495,35 @@ impl<'a> TaskHasher<'a> {
         Ok(task_hash)
     }
 
+    /// Adds the files generated by the tasks in `inputsFrom` to the task's file
+    /// hashes and returns the new hash of its files. Generated files don't
+    /// exist until those tasks have run, so unlike the rest of the task's
+    /// inputs they can't be hashed before the run starts.
+    fn hash_generated_inputs(
+        &self,
+        task_id: &TaskId<'static>,
+        workspace: &PackageInfo,
+        generated_inputs: &[String],
+    ) -> Result<String, Error> {
+        let mut file_hashes = self
+            .task_hash_tracker
+            .get_expanded_inputs(task_id)
+            .ok_or_else(|| Error::MissingPackageFileHash(task_id.to_string()))?;
+        let generated = self.scm.get_package_file_hashes(
+            self.repo_root,
+            workspace.package_path(),
+            generated_inputs,
+            None,
+        )?;
+        debug!("hashed {} generated files for {}", generated.len(), task_id);
+        file_hashes.0.extend(generated);
+
+        let hash = file_hashes.clone().hash();
+        self.task_hash_tracker
+            .insert_expanded_inputs(task_id.clone(), file_hashes);
+        Ok(hash)
+    }
+
     /// Gets the hashes of a task's dependencies. Because the visitor
     /// receives the nodes in topological order, we know that all of
     /// the dependencies have been processed before the current task.
@@ -459,10 +633,14 @@ pub fn get_external_deps_hash(
 }
 
 impl TaskHashTracker {
-    pub fn new(input_expanded_hashes: HashMap<TaskId<'static>, FileHashes>) -> Self {
+    pub fn new(
+        input_expanded_hashes: HashMap<TaskId<'static>, FileHashes>,
+        hashing_metrics: HashMap<TaskId<'static>, HashingMetrics>,
+    ) -> Self {
         Self {
             state: Arc::new(Mutex::new(TaskHashTrackerState {
                 package_task_inputs_expanded_hashes: input_expanded_hashes,
+                package_task_hashing_metrics: hashing_metrics,
                 ..Default::default()
             })),
         }
@@ -473,6 +651,11 @@ impl TaskHashTracker {
         state.package_task_hashes.get(task_id).cloned()
     }
 
+    pub fn hashes(&self) -> HashMap<TaskId<'static>, String> {
+        let state = self.state.lock().expect("hash tracker mutex poisoned");
+        state.package_task_hashes.clone()
+    }
+
     fn insert_hash(
         &self,
         task_id: TaskId<'static>,
@@ -526,6 +709,40 @@ impl TaskHashTracker {
         state.package_task_cache.insert(task_id, cache_status);
     }
 
+    pub fn cache_state(&self, task_id: &TaskId) -> Option<CacheState> {
+        let state = self.state.lock().expect("hash tracker mutex poisoned");
+        state.package_task_cache_state.get(task_id).copied()
+    }
+
+    pub fn insert_cache_state(&self, task_id: TaskId<'static>, cache_state: CacheState) {
+        let mut state = self.state.lock().expect("hash tracker mutex poisoned");
+        state.package_task_cache_state.insert(task_id, cache_state);
+    }
+
+    pub fn upload_metadata(&self, task_id: &TaskId) -> Option<CacheUploadMetadata> {
+        let state = self.state.lock().expect("hash tracker mutex poisoned");
+        state.package_task_uploads.get(task_id).copied()
+    }
+
+    pub fn insert_upload_metadata(&self, task_id: TaskId<'static>, upload: CacheUploadMetadata) {
+        let mut state = self.state.lock().expect("hash tracker mutex poisoned");
+        state.package_task_uploads.insert(task_id, upload);
+    }
+
+    pub fn download_metadata(&self, task_id: &TaskId) -> Option<CacheDownloadMetadata> {
+        let state = self.state.lock().expect("hash tracker mutex poisoned");
+        state.package_task_downloads.get(task_id).copied()
+    }
+
+    pub fn insert_download_metadata(
+        &self,
+        task_id: TaskId<'static>,
+        download: CacheDownloadMetadata,
+    ) {
+        let mut state = self.state.lock().expect("hash tracker mutex poisoned");
+        state.package_task_downloads.insert(task_id, download);
+    }
+
     pub fn get_expanded_inputs(&self, task_id: &TaskId) -> Option<FileHashes> {
         let state = self.state.lock().expect("hash tracker mutex poisoned");
         state
@@ -533,11 +750,253 @@ impl TaskHashTracker {
             .get(task_id)
             .cloned()
     }
+
+    fn insert_expanded_inputs(&self, task_id: TaskId<'static>, file_hashes: FileHashes) {
+        let mut state = self.state.lock().expect("hash tracker mutex poisoned");
+        state
+            .package_task_inputs_expanded_hashes
+            .insert(task_id, file_hashes);
+    }
+
+    pub fn hashing_metrics(&self, task_id: &TaskId) -> Option<HashingMetrics> {
+        let state = self.state.lock().expect("hash tracker mutex poisoned");
+        state.package_task_hashing_metrics.get(task_id).copied()
+    }
+
+    pub fn strict_env(&self, task_id: &TaskId) -> Option<EnvironmentVariableMap> {
+        let state = self.state.lock().expect("hash tracker mutex poisoned");
+        state.package_task_strict_env.get(task_id).cloned()
+    }
+
+    pub fn insert_strict_env(&self, task_id: TaskId<'static>, env: EnvironmentVariableMap) {
+        let mut state = self.state.lock().expect("hash tracker mutex poisoned");
+        state.package_task_strict_env.insert(task_id, env);
+    }
 }
 
 #[cfg(test)]
 mod test {
+    use clap::Parser;
+    use test_case::test_case;
+
     use super::*;
+    use crate::cli::RunArgs;
+
+    #[test_case("", "patches/foo.patch", "patches/foo.patch" ; "root package")]
+    #[test_case("apps/web", "patches/foo.patch", "../../patches/foo.patch" ; "nested package")]
+    #[test_case("tooling", "scripts/build.sh", "../scripts/build.sh" ; "top level package")]
+    fn test_root_input_key(package_path: &str, path: &str, expected: &str) {
+        let package_path = AnchoredSystemPathBuf::from_raw(package_path).unwrap();
+        let key = root_input_key(&package_path, &RelativeUnixPathBuf::new(path).unwrap()).unwrap();
+        assert_eq!(key.as_str(), expected);
+    }
+
+    #[test]
+    fn test_retain_root_package_files() {
+        let mut hashes = [
+            "package.json",
+            "turbo.json",
+            "scripts/check.sh",
+            "apps/web/package.json",
+            "apps/web-legacy/src/index.ts",
+            "packages/ui/src/button.tsx",
+        ]
+        .into_iter()
+        .map(|path| (RelativeUnixPathBuf::new(path).unwrap(), "hash".to_string()))
+        .collect::<GitHashes>();
+        let package_dirs = ["apps/web", "packages/ui"]
+            .into_iter()
+            .map(|dir| RelativeUnixPathBuf::new(dir).unwrap())
+            .collect::<Vec<_>>();
+
+        retain_root_package_files(&mut hashes, &package_dirs);
+
+        let mut retained = hashes.keys().map(|path| path.as_str()).collect::<Vec<_>>();
+        retained.sort();
+        assert_eq!(
+            retained,
+            vec![
+                "apps/web-legacy/src/index.ts",
+                "package.json",
+                "scripts/check.sh",
+                "turbo.json"
+            ]
+        );
+    }
+
+    // A repository with a root package and apps/web
+    fn make_repo(repo_root: &AbsoluteSystemPath) {
+        for (path, contents) in [
+            ("package.json", "{}"),
+            ("turbo.json", "{}"),
+            ("apps/web/package.json", r#"{"name": "web"}"#),
+            ("apps/web/src/index.ts", "export {}"),
+        ] {
+            let file = repo_root.join_unix_path(RelativeUnixPathBuf::new(path).unwrap());
+            file.ensure_dir().unwrap();
+            file.create_with_contents(contents).unwrap();
+        }
+    }
+
+    // Hashes the files of `task_id` in a repository made by `make_repo`
+    fn task_file_hash(repo_root: &AbsoluteSystemPath, task_id: &str, inputs: &[&str]) -> String {
+        let root_info = PackageInfo {
+            package_json_path: AnchoredSystemPathBuf::from_raw("package.json").unwrap(),
+            ..Default::default()
+        };
+        let web_info = PackageInfo {
+            package_json_path: AnchoredSystemPathBuf::from_raw("apps/web/package.json").unwrap(),
+            ..Default::default()
+        };
+        let (root, web) = (PackageName::Root, PackageName::from("web"));
+        let workspaces = HashMap::from([(&root, &root_info), (&web, &web_info)]);
+
+        let task_id = TaskId::try_from(task_id).unwrap().into_owned();
+        let task_definitions = HashMap::from([(
+            task_id.clone(),
+            TaskDefinition {
+                inputs: inputs.iter().map(|input| input.to_string()).collect(),
+                ..Default::default()
+            },
+        )]);
+        let tasks = [TaskNode::Task(task_id.clone())];
+
+        let hashes = PackageInputsHashes::calculate_file_hashes(
+            &SCM::new(repo_root),
+            tasks.par_iter(),
+            workspaces,
+            &task_definitions,
+            repo_root,
+            &GenericEventBuilder::new(),
+        )
+        .unwrap();
+        hashes.hashes[&task_id].clone()
+    }
+
+    #[test_case(&[], true ; "no inputs")]
+    #[test_case(&["$TURBO_ROOT_FILES$"], false ; "root files")]
+    #[test_case(&["$TURBO_ROOT_FILES$", "!README.md"], false ; "root files with exclusion")]
+    fn test_root_task_package_file_change(inputs: &[&str], invalidated: bool) {
+        let tmp = tempfile::tempdir().unwrap();
+        let repo_root = AbsoluteSystemPath::new(tmp.path().to_str().unwrap()).unwrap();
+        make_repo(repo_root);
+        let root_task_hash = |inputs: &[&str]| task_file_hash(repo_root, "//#check", inputs);
+
+        let before = root_task_hash(inputs);
+        repo_root
+            .join_components(&["apps", "web", "src", "index.ts"])
+            .create_with_contents("export const changed = true")
+            .unwrap();
+        let after_package_change = root_task_hash(inputs);
+        assert_eq!(before != after_package_change, invalidated);
+
+        // A root file always invalidates the task
+        repo_root
+            .join_component("turbo.json")
+            .create_with_contents(r#"{"pipeline": {}}"#)
+            .unwrap();
+        assert_ne!(after_package_change, root_task_hash(inputs));
+    }
+
+    #[test_case(&["$TURBO_ROOT_FILES$"], &[] ; "only root files")]
+    #[test_case(&["$TURBO_ROOT_FILES$", "src/**"], &["src/**"] ; "root files with other inputs")]
+    fn test_root_files_outside_root_task(inputs: &[&str], expected_inputs: &[&str]) {
+        let tmp = tempfile::tempdir().unwrap();
+        let repo_root = AbsoluteSystemPath::new(tmp.path().to_str().unwrap()).unwrap();
+        make_repo(repo_root);
+
+        // The marker is ignored rather than hashed as a glob that matches nothing
+        assert_eq!(
+            task_file_hash(repo_root, "web#build", inputs),
+            task_file_hash(repo_root, "web#build", expected_inputs)
+        );
+    }
+
+    // Hashes web#build, which declares `env`, with `vars` set when the run
+    // started
+    fn env_task_hash(
+        repo_root: &AbsoluteSystemPath,
+        env: &[&str],
+        vars: &[(&str, &str)],
+    ) -> String {
+        let web_info = PackageInfo {
+            package_json_path: AnchoredSystemPathBuf::from_raw("apps/web/package.json").unwrap(),
+            ..Default::default()
+        };
+        let web = PackageName::from("web");
+        let task_id = TaskId::new("web", "build");
+        let task_definition = TaskDefinition {
+            env: env.iter().map(|var| var.to_string()).collect(),
+            ..Default::default()
+        };
+        let task_definitions = HashMap::from([(task_id.clone(), task_definition.clone())]);
+        let tasks = [TaskNode::Task(task_id.clone())];
+
+        let scm = SCM::new(repo_root);
+        let hashes = PackageInputsHashes::calculate_file_hashes(
+            &scm,
+            tasks.par_iter(),
+            HashMap::from([(&web, &web_info)]),
+            &task_definitions,
+            repo_root,
+            &GenericEventBuilder::new(),
+        )
+        .unwrap();
+        let run_opts =
+            RunOpts::try_from(&RunArgs::try_parse_from(["run", "build"]).unwrap()).unwrap();
+        let env_at_execution_start = EnvironmentVariableMap::from(
+            vars.iter()
+                .map(|(name, value)| (name.to_string(), value.to_string()))
+                .collect::<HashMap<_, _>>(),
+        );
+        let task_hasher = TaskHasher::new(
+            hashes,
+            &scm,
+            repo_root,
+            &run_opts,
+            &env_at_execution_start,
+            "global",
+        );
+
+        task_hasher
+            .calculate_task_hash(
+                &task_id,
+                &task_definition,
+                ResolvedEnvMode::Loose,
+                &web_info,
+                HashSet::new(),
+                &[],
+                &[],
+                PackageTaskEventBuilder::new("web", "build"),
+            )
+            .unwrap()
+    }
+
+    #[test]
+    fn test_task_env_changes_hash() {
+        let tmp = tempfile::tempdir().unwrap();
+        let repo_root = AbsoluteSystemPath::new(tmp.path().to_str().unwrap()).unwrap();
+        make_repo(repo_root);
+        let task_hash = |env: &[&str], vars: &[(&str, &str)]| env_task_hash(repo_root, env, vars);
+
+        let before = task_hash(&["API_URL"], &[("API_URL", "https://a.example")]);
+        assert_ne!(
+            before,
+            task_hash(&["API_URL"], &[("API_URL", "https://b.example")])
+        );
+        // Variables that the task doesn't declare don't affect its hash
+        assert_eq!(
+            before,
+            task_hash(
+                &["API_URL"],
+                &[("API_URL", "https://a.example"), ("NODE_ENV", "test")]
+            )
+        );
+        assert_eq!(
+            task_hash(&[], &[("API_URL", "https://a.example")]),
+            task_hash(&[], &[("API_URL", "https://b.example")])
+        );
+    }
 
     #[test]
     fn test_hash_tracker_is_send_and_sync() {
@@ -10,6 +10,8 @@ use serde::{Deserialize, Serialize};
 use struct_iterable::Iterable;
 use tracing::debug;
 use turbopath::{AbsoluteSystemPath, AnchoredSystemPath, RelativeUnixPathBuf};
+use turborepo_api_client::custom::CustomProvider;
+use turborepo_cache::{RemoteCacheFallback, RemoteCacheWritePolicy};
 use turborepo_errors::Spanned;
 use turborepo_repository::{package_graph::ROOT_PKG_NAME, package_json::PackageJson};
 
@@ -18,13 +20,14 @@ use crate::{
     config::{ConfigurationOptions, Error, InvalidEnvPrefixError},
     run::{
         task_access::{TaskAccessTraceFile, TASK_ACCESS_CONFIG_PATH},
-        task_id::{TaskId, TaskName},
+        task_id::{TaskId, TaskName, TASK_DELIMITER},
     },
     task_graph::{TaskDefinition, TaskOutputs},
     unescape::UnescapedString,
 };
 
 pub mod parser;
+mod presets;
 
 #[derive(Serialize, Deserialize, Debug, Default, PartialEq, Clone)]
 #[serde(rename_all = "camelCase")]
@@ -54,10 +57,19 @@ pub struct TurboJson {
     pub(crate) global_env: Vec<String>,
     pub(crate) global_pass_through_env: Option<Vec<String>>,
     pub(crate) pipeline: Pipeline,
+    pub(crate) hooks: HooksConfig,
+}
+
+/// The commands to run at each point in a run's lifecycle. See `run::hooks`.
+#[derive(Debug, Default, Clone, PartialEq)]
+pub struct HooksConfig {
+    pub pre_run: Vec<String>,
+    pub post_task: Vec<String>,
+    pub post_run: Vec<String>,
 }
 
 // Iterable is required to enumerate allowed keys
-#[derive(Clone, Debug, Default, Iterable, Serialize)]
+#[derive(Clone, Debug, Default, Iterable, Serialize, Deserialize)]
 #[serde(rename_all = "camelCase")]
 pub(crate) struct RawRemoteCacheOptions {
     #[serde(skip_serializing_if = "Option::is_none")]
@@ -75,7 +87,46 @@ pub(crate) struct RawRemoteCacheOptions {
     #[serde(skip_serializing_if = "Option::is_none")]
     timeout: Option<u64>,
     #[serde(skip_serializing_if = "Option::is_none")]
+    retries: Option<u32>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    upload_chunk_size: Option<u64>,
+    #[serde(skip_serializing_if = "Option::is_none")]
     enabled: Option<bool>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    provider: Option<CustomProvider>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    fallbacks: Option<Vec<RemoteCacheFallback>>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    write_policy: Option<RemoteCacheWritePolicy>,
+}
+
+// Iterable is required to enumerate allowed keys
+#[derive(Clone, Debug, Default, Iterable, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub(crate) struct RawHooks {
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pre_run: Option<Vec<UnescapedString>>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    post_task: Option<Vec<UnescapedString>>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    post_run: Option<Vec<UnescapedString>>,
+}
+
+impl From<RawHooks> for HooksConfig {
+    fn from(raw_hooks: RawHooks) -> Self {
+        let commands = |hooks: Option<Vec<UnescapedString>>| {
+            hooks
+                .into_iter()
+                .flatten()
+                .map(String::from)
+                .collect::<Vec<_>>()
+        };
+        Self {
+            pre_run: commands(raw_hooks.pre_run),
+            post_task: commands(raw_hooks.post_task),
+            post_run: commands(raw_hooks.post_run),
+        }
+    }
 }
 
 impl From<&RawRemoteCacheOptions> for ConfigurationOptions {
@@ -88,13 +139,21 @@ impl From<&RawRemoteCacheOptions> for ConfigurationOptions {
             signature: remote_cache_opts.signature,
             preflight: remote_cache_opts.preflight,
             timeout: remote_cache_opts.timeout,
+            retries: remote_cache_opts.retries,
+            upload_chunk_size: remote_cache_opts.upload_chunk_size,
             enabled: remote_cache_opts.enabled,
+            remote_cache_provider: remote_cache_opts.provider.clone(),
+            remote_cache_fallbacks: remote_cache_opts.fallbacks.clone(),
+            remote_cache_write_policy: remote_cache_opts.write_policy,
             ..Self::default()
         }
     }
 }
 
-#[derive(Serialize, Default, Debug, Clone, Iterable)]
+// Deserialize is only used for turbo.json files that have already been parsed
+// and validated, e.g. by the daemon. Files on disk are parsed with biome so
+// that errors point to their source.
+#[derive(Serialize, Deserialize, Default, Debug, Clone, Iterable)]
 #[serde(rename_all = "camelCase")]
 // The raw deserialized turbo.json file.
 pub struct RawTurboJson {
@@ -128,9 +187,12 @@ pub struct RawTurboJson {
     // Configuration options when interfacing with the remote cache
     #[serde(skip_serializing_if = "Option::is_none")]
     pub(crate) remote_cache: Option<RawRemoteCacheOptions>,
+    // Commands that are run at points in the run's lifecycle
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub(crate) hooks: Option<RawHooks>,
 }
 
-#[derive(Serialize, Default, Debug, PartialEq, Clone)]
+#[derive(Serialize, Deserialize, Default, Debug, PartialEq, Clone)]
 #[serde(transparent)]
 pub struct Pipeline(BTreeMap<TaskName<'static>, Spanned<RawTaskDefinition>>);
 
@@ -158,12 +220,16 @@ impl DerefMut for Pipeline {
     }
 }
 
-#[derive(Serialize, Default, Debug, PartialEq, Clone, Iterable)]
+#[derive(Serialize, Deserialize, Default, Debug, PartialEq, Clone, Iterable)]
 #[serde(rename_all = "camelCase")]
 pub struct RawTaskDefinition {
-    #[serde(skip_serializing_if = "Spanned::is_none")]
+    #[serde(default, skip_serializing_if = "Spanned::is_none")]
     cache: Spanned<Option<bool>>,
     #[serde(skip_serializing_if = "Option::is_none")]
+    cache_logs: Option<Spanned<bool>>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    always_run: Option<Spanned<bool>>,
+    #[serde(skip_serializing_if = "Option::is_none")]
     depends_on: Option<Spanned<Vec<Spanned<UnescapedString>>>>,
     #[serde(skip_serializing_if = "Option::is_none")]
     dot_env: Option<Spanned<Vec<UnescapedString>>>,
@@ -172,6 +238,10 @@ pub struct RawTaskDefinition {
     #[serde(skip_serializing_if = "Option::is_none")]
     inputs: Option<Vec<Spanned<UnescapedString>>>,
     #[serde(skip_serializing_if = "Option::is_none")]
+    root_inputs: Option<Vec<Spanned<UnescapedString>>>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    inputs_from: Option<Vec<Spanned<UnescapedString>>>,
+    #[serde(skip_serializing_if = "Option::is_none")]
     pass_through_env: Option<Vec<Spanned<UnescapedString>>>,
     #[serde(skip_serializing_if = "Option::is_none")]
     persistent: Option<Spanned<bool>>,
@@ -179,6 +249,38 @@ pub struct RawTaskDefinition {
     outputs: Option<Vec<Spanned<UnescapedString>>>,
     #[serde(skip_serializing_if = "Option::is_none")]
     output_mode: Option<Spanned<OutputLogsMode>>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    priority: Option<Spanned<TaskPriority>>,
+}
+
+/// How eagerly a task is dispatched once it's ready to run, when more tasks
+/// are ready than can run at once. Either one of the named levels or a
+/// numeric weight, tasks with higher weights are dispatched first.
+#[derive(Serialize, Deserialize, Debug, PartialEq, Eq, Clone, Copy)]
+#[serde(untagged)]
+pub enum TaskPriority {
+    Level(PriorityLevel),
+    Weight(i32),
+}
+
+#[derive(Serialize, Deserialize, Debug, PartialEq, Eq, Clone, Copy)]
+#[serde(rename_all = "lowercase")]
+pub enum PriorityLevel {
+    High,
+    Normal,
+    Low,
+}
+
+impl TaskPriority {
+    // The named levels are equivalent to the weights 1, 0 and -1
+    pub fn weight(&self) -> i32 {
+        match self {
+            TaskPriority::Level(PriorityLevel::High) => 1,
+            TaskPriority::Level(PriorityLevel::Normal) => 0,
+            TaskPriority::Level(PriorityLevel::Low) => -1,
+            TaskPriority::Weight(weight) => *weight,
+        }
+    }
 }
 
 macro_rules! set_field {
@@ -198,13 +300,18 @@ impl RawTaskDefinition {
         if other.cache.value.is_some() {
             self.cache = other.cache;
         }
+        set_field!(self, other, cache_logs);
+        set_field!(self, other, always_run);
         set_field!(self, other, depends_on);
         set_field!(self, other, inputs);
+        set_field!(self, other, root_inputs);
+        set_field!(self, other, inputs_from);
         set_field!(self, other, output_mode);
         set_field!(self, other, persistent);
         set_field!(self, other, env);
         set_field!(self, other, pass_through_env);
         set_field!(self, other, dot_env);
+        set_field!(self, other, priority);
     }
 }
 
@@ -287,6 +394,27 @@ impl TryFrom<RawTaskDefinition> for TaskDefinition {
             }
         }
 
+        // Generated inputs can only be hashed once the tasks that generate them
+        // have run, so they're dependencies as well
+        let mut inputs_from = Vec::new();
+        for generator in raw_task.inputs_from.unwrap_or_default() {
+            if generator.contains(TASK_DELIMITER)
+                || generator.starts_with(TOPOLOGICAL_PIPELINE_DELIMITER)
+            {
+                let (span, text) = generator.span_and_text("turbo.json");
+                return Err(Error::InvalidInputsFrom { span, text });
+            }
+            let task_name = TaskName::from(generator.value.to_string());
+            if !task_dependencies
+                .iter()
+                .any(|dependency| dependency.value == task_name)
+            {
+                task_dependencies.push(generator.to(task_name));
+            }
+            inputs_from.push(generator.into_inner().into());
+        }
+        inputs_from.sort();
+
         task_dependencies.sort_by(|a, b| a.value.cmp(&b.value));
         topological_dependencies.sort_by(|a, b| a.value.cmp(&b.value));
 
@@ -302,23 +430,8 @@ impl TryFrom<RawTaskDefinition> for TaskDefinition {
             .transpose()?
             .unwrap_or_default();
 
-        let inputs = raw_task
-            .inputs
-            .unwrap_or_default()
-            .into_iter()
-            .map(|input| {
-                if Utf8Path::new(&input.value).is_absolute() {
-                    let (span, text) = input.span_and_text("turbo.json");
-                    Err(Error::AbsolutePathInConfig {
-                        field: "inputs",
-                        span,
-                        text,
-                    })
-                } else {
-                    Ok(input.to_string())
-                }
-            })
-            .collect::<Result<Vec<_>, _>>()?;
+        let inputs = relative_globs(raw_task.inputs, "inputs")?;
+        let root_inputs = relative_globs(raw_task.root_inputs, "rootInputs")?;
 
         let pass_through_env = raw_task
             .pass_through_env
@@ -349,18 +462,42 @@ impl TryFrom<RawTaskDefinition> for TaskDefinition {
         Ok(TaskDefinition {
             outputs,
             cache: cache.into_inner().unwrap_or(true),
+            cache_logs: raw_task.cache_logs.map_or(true, |cache_logs| *cache_logs),
+            always_run: raw_task.always_run.map_or(false, |always_run| *always_run),
             topological_dependencies,
             task_dependencies,
             env,
             inputs,
+            root_inputs,
+            inputs_from,
             pass_through_env,
             dot_env,
             output_mode: *raw_task.output_mode.unwrap_or_default(),
             persistent: *raw_task.persistent.unwrap_or_default(),
+            priority: raw_task.priority.map_or(0, |priority| priority.weight()),
         })
     }
 }
 
+/// Validates that none of the globs are absolute paths
+fn relative_globs(
+    globs: Option<Vec<Spanned<UnescapedString>>>,
+    field: &'static str,
+) -> Result<Vec<String>, Error> {
+    globs
+        .unwrap_or_default()
+        .into_iter()
+        .map(|glob| {
+            if Utf8Path::new(&glob.value).is_absolute() {
+                let (span, text) = glob.span_and_text("turbo.json");
+                Err(Error::AbsolutePathInConfig { field, span, text })
+            } else {
+                Ok(glob.to_string())
+            }
+        })
+        .collect()
+}
+
 impl RawTurboJson {
     pub(crate) fn read(
         repo_root: &AbsoluteSystemPath,
@@ -501,6 +638,7 @@ impl TryFrom<RawTurboJson> for TurboJson {
                 })
                 .transpose()?,
             pipeline: raw_turbo.pipeline.unwrap_or_default(),
+            hooks: raw_turbo.hooks.map(HooksConfig::from).unwrap_or_default(),
             // copy these over, we don't need any changes here.
             extends: raw_turbo
                 .extends
@@ -528,7 +666,13 @@ impl TurboJson {
             );
         }
 
-        let turbo_from_files = Self::read(repo_root, &dir.join_component(CONFIG_FILE));
+        let turbo_from_files = Self::read(repo_root, &dir.join_component(CONFIG_FILE)).and_then(
+            |turbo_json| match dir.as_str().is_empty() {
+                // Only the root turbo.json can extend presets, workspaces extend the root
+                true => turbo_json.with_presets(repo_root),
+                false => Ok(turbo_json),
+            },
+        );
         let turbo_from_trace =
             Self::read(repo_root, &dir.join_components(&TASK_ACCESS_CONFIG_PATH));
 
@@ -729,14 +873,16 @@ mod tests {
     use tempfile::tempdir;
     use test_case::test_case;
     use turbopath::{AbsoluteSystemPath, AnchoredSystemPath, RelativeUnixPathBuf};
+    use turborepo_api_client::custom::CustomProvider;
+    use turborepo_cache::{RemoteCacheFallback, RemoteCacheWritePolicy};
     use turborepo_repository::package_json::PackageJson;
 
-    use super::{Pipeline, RawTurboJson, Spanned};
+    use super::{HooksConfig, Pipeline, RawTurboJson, Spanned};
     use crate::{
         cli::OutputLogsMode,
         run::task_id::TaskName,
         task_graph::{TaskDefinition, TaskOutputs},
-        turbo_json::{RawTaskDefinition, TurboJson},
+        turbo_json::{PriorityLevel, RawTaskDefinition, TaskPriority, TurboJson},
         unescape::UnescapedString,
     };
 
@@ -759,6 +905,16 @@ mod tests {
             ..TurboJson::default()
         }
     )]
+    #[test_case(r#"{ "hooks": { "preRun": ["./scripts/policy.js"], "postRun": ["report-run"] } }"#,
+        TurboJson {
+            hooks: HooksConfig {
+                pre_run: vec!["./scripts/policy.js".to_string()],
+                post_run: vec!["report-run".to_string()],
+                ..HooksConfig::default()
+            },
+            ..TurboJson::default()
+        }
+    ; "hooks")]
     #[test_case(r#"{ "//": "A comment"}"#, TurboJson::default() ; "faux comment")]
     fn test_get_root_turbo_no_synthesizing(
         turbo_json_content: &str,
@@ -881,6 +1037,79 @@ mod tests {
         TaskDefinition::default()
     ; "just persistent"
     )]
+    #[test_case(
+        r#"{ "cacheLogs": false }"#,
+        RawTaskDefinition {
+            cache_logs: Some(Spanned::new(false).with_range(15..20)),
+            ..RawTaskDefinition::default()
+        },
+        TaskDefinition {
+            cache_logs: false,
+            ..Default::default()
+        }
+    ; "cache logs disabled"
+    )]
+    #[test_case(
+        r#"{ "alwaysRun": true }"#,
+        RawTaskDefinition {
+            always_run: Some(Spanned::new(true).with_range(15..19)),
+            ..RawTaskDefinition::default()
+        },
+        TaskDefinition {
+            always_run: true,
+            ..Default::default()
+        }
+    ; "always run"
+    )]
+    #[test_case(
+        r#"{ "priority": "high" }"#,
+        RawTaskDefinition {
+            priority: Some(Spanned::new(TaskPriority::Level(PriorityLevel::High)).with_range(14..20)),
+            ..RawTaskDefinition::default()
+        },
+        TaskDefinition {
+            priority: 1,
+            ..Default::default()
+        }
+    ; "priority level"
+    )]
+    #[test_case(
+        r#"{ "priority": -5 }"#,
+        RawTaskDefinition {
+            priority: Some(Spanned::new(TaskPriority::Weight(-5)).with_range(14..16)),
+            ..RawTaskDefinition::default()
+        },
+        TaskDefinition {
+            priority: -5,
+            ..Default::default()
+        }
+    ; "priority weight"
+    )]
+    #[test_case(
+        r#"{ "rootInputs": ["patches/**"] }"#,
+        RawTaskDefinition {
+            root_inputs: Some(vec![Spanned::<UnescapedString>::new("patches/**".into()).with_range(17..29)]),
+            ..RawTaskDefinition::default()
+        },
+        TaskDefinition {
+            root_inputs: vec!["patches/**".to_string()],
+            ..Default::default()
+        }
+    ; "root inputs"
+    )]
+    #[test_case(
+        r#"{ "inputsFrom": ["codegen"] }"#,
+        RawTaskDefinition {
+            inputs_from: Some(vec![Spanned::<UnescapedString>::new("codegen".into()).with_range(17..26)]),
+            ..RawTaskDefinition::default()
+        },
+        TaskDefinition {
+            inputs_from: vec!["codegen".to_string()],
+            task_dependencies: vec![Spanned::<TaskName<'_>>::new("codegen".into()).with_range(17..26)],
+            ..Default::default()
+        }
+    ; "inputs from"
+    )]
     #[test_case(
         r#"{ "dotEnv": [] }"#,
         RawTaskDefinition {
@@ -917,9 +1146,14 @@ mod tests {
             pass_through_env: Some(vec![Spanned::<UnescapedString>::new("AWS_SECRET_KEY".into()).with_range(134..150)]),
             outputs: Some(vec![Spanned::<UnescapedString>::new("package/a/dist".into()).with_range(175..191)]),
             cache: Spanned::new(Some(false)).with_range(213..218),
+            cache_logs: None,
+            always_run: None,
             inputs: Some(vec![Spanned::<UnescapedString>::new("package/a/src/**".into()).with_range(241..259)]),
+            root_inputs: None,
+            inputs_from: None,
             output_mode: Some(Spanned::new(OutputLogsMode::Full).with_range(286..292)),
             persistent: Some(Spanned::new(true).with_range(318..322)),
+            priority: None,
         },
         TaskDefinition {
           dot_env: Some(vec![RelativeUnixPathBuf::new("package/a/.env").unwrap()]),
@@ -929,12 +1163,17 @@ mod tests {
               exclusions: vec![],
           },
           cache: false,
+          cache_logs: true,
+          always_run: false,
           inputs: vec!["package/a/src/**".to_string()],
+          root_inputs: vec![],
+          inputs_from: vec![],
           output_mode: OutputLogsMode::Full,
           pass_through_env: Some(vec!["AWS_SECRET_KEY".to_string()]),
           task_dependencies: vec![Spanned::<TaskName<'_>>::new("cli#build".into()).with_range(26..37)],
           topological_dependencies: vec![],
           persistent: true,
+          priority: 0,
         }
       ; "full"
     )]
@@ -957,9 +1196,14 @@ mod tests {
             pass_through_env: Some(vec![Spanned::<UnescapedString>::new("AWS_SECRET_KEY".into()).with_range(152..168)]),
             outputs: Some(vec![Spanned::<UnescapedString>::new("package\\a\\dist".into()).with_range(197..215)]),
             cache: Spanned::new(Some(false)).with_range(241..246),
+            cache_logs: None,
+            always_run: None,
             inputs: Some(vec![Spanned::<UnescapedString>::new("package\\a\\src\\**".into()).with_range(273..294)]),
+            root_inputs: None,
+            inputs_from: None,
             output_mode: Some(Spanned::new(OutputLogsMode::Full).with_range(325..331)),
             persistent: Some(Spanned::new(true).with_range(361..365)),
+            priority: None,
         },
         TaskDefinition {
             dot_env: Some(vec![RelativeUnixPathBuf::new("package\\a\\.env").unwrap()]),
@@ -969,12 +1213,17 @@ mod tests {
                 exclusions: vec![],
             },
             cache: false,
+            cache_logs: true,
+            always_run: false,
             inputs: vec!["package\\a\\src\\**".to_string()],
+            root_inputs: vec![],
+            inputs_from: vec![],
             output_mode: OutputLogsMode::Full,
             pass_through_env: Some(vec!["AWS_SECRET_KEY".to_string()]),
             task_dependencies: vec![Spanned::<TaskName<'_>>::new("cli#build".into()).with_range(30..41)],
             topological_dependencies: vec![],
             persistent: true,
+            priority: 0,
         }
       ; "full (windows)"
     )]
@@ -1090,4 +1339,107 @@ mod tests {
             .map(|mode| mode.into_inner());
         assert_eq!(actual, expected);
     }
+
+    #[test_case(
+        json!({ "baseUrl": "https://cache.example.com", "authHeader": "x-api-key: {token}" }),
+        Some(CustomProvider {
+            base_url: "https://cache.example.com".to_string(),
+            auth_header: Some("x-api-key: {token}".to_string()),
+            get_path: "/{hash}".to_string(),
+            put_path: "/{hash}".to_string(),
+        })
+        ; "default paths"
+    )]
+    #[test_case(
+        json!({ "baseUrl": "https://cache.example.com", "getPath": "/artifacts/{hash}", "putPath": "/upload/{hash}" }),
+        Some(CustomProvider {
+            base_url: "https://cache.example.com".to_string(),
+            auth_header: None,
+            get_path: "/artifacts/{hash}".to_string(),
+            put_path: "/upload/{hash}".to_string(),
+        })
+        ; "custom paths"
+    )]
+    #[test_case(
+        json!({ "baseUrl": "grpcs://cache.example.com/main" }),
+        Some(CustomProvider {
+            base_url: "grpcs://cache.example.com/main".to_string(),
+            auth_header: None,
+            get_path: "/{hash}".to_string(),
+            put_path: "/{hash}".to_string(),
+        })
+        ; "bazel remote cache"
+    )]
+    #[test_case(json!({ "getPath": "/{hash}" }), None ; "missing base url")]
+    #[test_case(json!({ "baseUrl": "https://cache.example.com", "putPath": "/artifact" }), None ; "path without hash")]
+    #[test_case(json!({ "baseUrl": "https://cache.example.com", "authHeader": "{token}" }), None ; "header without name")]
+    fn test_parsing_remote_cache_provider(
+        provider: serde_json::Value,
+        expected: Option<CustomProvider>,
+    ) {
+        let json = RawTurboJson::parse_from_serde(json!({
+            "remoteCache": {
+                "provider": provider,
+            }
+        }));
+
+        let actual = json
+            .ok()
+            .and_then(|j| j.remote_cache)
+            .and_then(|remote_cache| remote_cache.provider);
+        assert_eq!(actual, expected);
+    }
+
+    #[test_case(json!(["vercel"]), Some(vec![RemoteCacheFallback::Vercel]) ; "vercel")]
+    #[test_case(
+        json!([{ "baseUrl": "https://cache.example.com" }, "vercel"]),
+        Some(vec![
+            RemoteCacheFallback::Custom(CustomProvider {
+                base_url: "https://cache.example.com".to_string(),
+                auth_header: None,
+                get_path: "/{hash}".to_string(),
+                put_path: "/{hash}".to_string(),
+            }),
+            RemoteCacheFallback::Vercel,
+        ])
+        ; "provider then vercel"
+    )]
+    #[test_case(json!(["s3"]), None ; "unknown name")]
+    #[test_case(json!([{ "getPath": "/{hash}" }]), None ; "provider without base url")]
+    fn test_parsing_remote_cache_fallbacks(
+        fallbacks: serde_json::Value,
+        expected: Option<Vec<RemoteCacheFallback>>,
+    ) {
+        let json = RawTurboJson::parse_from_serde(json!({
+            "remoteCache": {
+                "fallbacks": fallbacks,
+            }
+        }));
+
+        let actual = json
+            .ok()
+            .and_then(|j| j.remote_cache)
+            .and_then(|remote_cache| remote_cache.fallbacks);
+        assert_eq!(actual, expected);
+    }
+
+    #[test_case("write-first", Some(RemoteCacheWritePolicy::WriteFirst) ; "write first")]
+    #[test_case("write-all", Some(RemoteCacheWritePolicy::WriteAll) ; "write all")]
+    #[test_case("write-some", None ; "unknown")]
+    fn test_parsing_remote_cache_write_policy(
+        write_policy: &str,
+        expected: Option<RemoteCacheWritePolicy>,
+    ) {
+        let json = RawTurboJson::parse_from_serde(json!({
+            "remoteCache": {
+                "writePolicy": write_policy,
+            }
+        }));
+
+        let actual = json
+            .ok()
+            .and_then(|j| j.remote_cache)
+            .and_then(|remote_cache| remote_cache.write_policy);
+        assert_eq!(actual, expected);
+    }
 }
@@ -18,9 +18,11 @@ use miette::{Diagnostic, SourceSpan};
 use struct_iterable::Iterable;
 use thiserror::Error;
 use turbopath::AnchoredSystemPath;
+use turborepo_api_client::custom::{CustomProvider, DEFAULT_ARTIFACT_PATH};
+use turborepo_cache::{RemoteCacheFallback, RemoteCacheWritePolicy};
 use turborepo_errors::WithMetadata;
 
-use super::RawRemoteCacheOptions;
+use super::{PriorityLevel, RawHooks, RawRemoteCacheOptions, TaskPriority};
 use crate::{
     cli::OutputLogsMode,
     config::ConfigurationOptions,
@@ -122,6 +124,33 @@ impl Deserializable for OutputLogsMode {
     }
 }
 
+impl Deserializable for TaskPriority {
+    fn deserialize(
+        value: &impl DeserializableValue,
+        name: &str,
+        diagnostics: &mut Vec<DeserializationDiagnostic>,
+    ) -> Option<Self> {
+        // Weights aren't strings, so that isn't worth reporting
+        let mut not_a_string = Vec::new();
+        if let Some(level) = String::deserialize(value, name, &mut not_a_string) {
+            return match level.as_str() {
+                "high" => Some(TaskPriority::Level(PriorityLevel::High)),
+                "normal" => Some(TaskPriority::Level(PriorityLevel::Normal)),
+                "low" => Some(TaskPriority::Level(PriorityLevel::Low)),
+                _ => {
+                    diagnostics.push(DeserializationDiagnostic::new_unknown_value(
+                        &level,
+                        value.range(),
+                        &["high", "normal", "low"],
+                    ));
+                    None
+                }
+            };
+        }
+        i32::deserialize(value, name, diagnostics).map(TaskPriority::Weight)
+    }
+}
+
 impl Deserializable for TaskName<'static> {
     fn deserialize(
         value: &impl DeserializableValue,
@@ -213,6 +242,16 @@ impl DeserializationVisitor for RawTaskDefinitionVisitor {
                         result.cache = Spanned::new(Some(cache)).with_range(range);
                     }
                 }
+                "cacheLogs" => {
+                    if let Some(cache_logs) = bool::deserialize(&value, &key_text, diagnostics) {
+                        result.cache_logs = Some(Spanned::new(cache_logs).with_range(range));
+                    }
+                }
+                "alwaysRun" => {
+                    if let Some(always_run) = bool::deserialize(&value, &key_text, diagnostics) {
+                        result.always_run = Some(Spanned::new(always_run).with_range(range));
+                    }
+                }
                 "dependsOn" => {
                     if let Some(depends_on) = Vec::deserialize(&value, &key_text, diagnostics) {
                         result.depends_on = Some(Spanned::new(depends_on).with_range(range));
@@ -233,6 +272,16 @@ impl DeserializationVisitor for RawTaskDefinitionVisitor {
                         result.inputs = Some(inputs);
                     }
                 }
+                "rootInputs" => {
+                    if let Some(root_inputs) = Vec::deserialize(&value, &key_text, diagnostics) {
+                        result.root_inputs = Some(root_inputs);
+                    }
+                }
+                "inputsFrom" => {
+                    if let Some(inputs_from) = Vec::deserialize(&value, &key_text, diagnostics) {
+                        result.inputs_from = Some(inputs_from);
+                    }
+                }
                 "passThroughEnv" => {
                     if let Some(pass_through_env) = Vec::deserialize(&value, &key_text, diagnostics)
                     {
@@ -256,6 +305,13 @@ impl DeserializationVisitor for RawTaskDefinitionVisitor {
                         result.output_mode = Some(Spanned::new(output_mode).with_range(range));
                     }
                 }
+                "priority" => {
+                    if let Some(priority) =
+                        TaskPriority::deserialize(&value, &key_text, diagnostics)
+                    {
+                        result.priority = Some(Spanned::new(priority).with_range(range));
+                    }
+                }
                 unknown_key => {
                     diagnostics.push(create_unknown_key_diagnostic_from_struct(
                         &result,
@@ -399,11 +455,54 @@ impl DeserializationVisitor for RawRemoteCacheOptionsVisitor {
                         result.timeout = Some(timeout);
                     }
                 }
+                "retries" => {
+                    if let Some(retries) = u32::deserialize(&value, &key_text, diagnostics) {
+                        result.retries = Some(retries);
+                    }
+                }
+                "uploadChunkSize" => {
+                    if let Some(size) = u64::deserialize(&value, &key_text, diagnostics) {
+                        result.upload_chunk_size = Some(size);
+                    }
+                }
                 "enabled" => {
                     if let Some(enabled) = bool::deserialize(&value, &key_text, diagnostics) {
                         result.enabled = Some(enabled);
                     }
                 }
+                "provider" => {
+                    if let Some(provider) =
+                        value.deserialize(CustomProviderVisitor, &key_text, diagnostics)
+                    {
+                        result.provider = Some(provider);
+                    }
+                }
+                "fallbacks" => {
+                    if let Some(fallbacks) =
+                        Vec::<RawRemoteCacheFallback>::deserialize(&value, &key_text, diagnostics)
+                    {
+                        result.fallbacks =
+                            Some(fallbacks.into_iter().map(|fallback| fallback.0).collect());
+                    }
+                }
+                "writePolicy" => {
+                    if let Some(write_policy) = String::deserialize(&value, &key_text, diagnostics)
+                    {
+                        match write_policy.as_str() {
+                            "write-first" => {
+                                result.write_policy = Some(RemoteCacheWritePolicy::WriteFirst)
+                            }
+                            "write-all" => {
+                                result.write_policy = Some(RemoteCacheWritePolicy::WriteAll)
+                            }
+                            _ => diagnostics.push(DeserializationDiagnostic::new_unknown_value(
+                                &write_policy,
+                                value.range(),
+                                &["write-first", "write-all"],
+                            )),
+                        }
+                    }
+                }
                 unknown_key => diagnostics.push(create_unknown_key_diagnostic_from_struct(
                     &result,
                     unknown_key,
@@ -415,6 +514,110 @@ impl DeserializationVisitor for RawRemoteCacheOptionsVisitor {
     }
 }
 
+// A fallback is either "vercel" or an object with the same fields as
+// `remoteCache.provider`
+struct RawRemoteCacheFallback(RemoteCacheFallback);
+
+impl Deserializable for RawRemoteCacheFallback {
+    fn deserialize(
+        value: &impl DeserializableValue,
+        name: &str,
+        diagnostics: &mut Vec<DeserializationDiagnostic>,
+    ) -> Option<Self> {
+        // Objects aren't strings, so that isn't worth reporting
+        let mut not_a_string = Vec::new();
+        if let Some(text) = String::deserialize(value, name, &mut not_a_string) {
+            if text == "vercel" {
+                return Some(Self(RemoteCacheFallback::Vercel));
+            }
+            diagnostics.push(DeserializationDiagnostic::new_unknown_value(
+                &text,
+                value.range(),
+                &["vercel"],
+            ));
+            return None;
+        }
+        value
+            .deserialize(CustomProviderVisitor, name, diagnostics)
+            .map(|provider| Self(RemoteCacheFallback::Custom(provider)))
+    }
+}
+
+struct CustomProviderVisitor;
+
+impl DeserializationVisitor for CustomProviderVisitor {
+    type Output = CustomProvider;
+
+    const EXPECTED_TYPE: VisitableType = VisitableType::MAP;
+
+    fn visit_map(
+        self,
+        members: impl Iterator<Item = Option<(impl DeserializableValue, impl DeserializableValue)>>,
+        _: TextRange,
+        _name: &str,
+        diagnostics: &mut Vec<DeserializationDiagnostic>,
+    ) -> Option<Self::Output> {
+        let mut base_url = None;
+        let mut auth_header = None;
+        let mut get_path = None;
+        let mut put_path = None;
+        for (key, value) in members.flatten() {
+            let Some(key_text) = Text::deserialize(&key, "", diagnostics) else {
+                continue;
+            };
+            let field = match key_text.text() {
+                "baseUrl" => &mut base_url,
+                "authHeader" => &mut auth_header,
+                "getPath" => &mut get_path,
+                "putPath" => &mut put_path,
+                unknown_key => {
+                    diagnostics.push(DeserializationDiagnostic::new_unknown_key(
+                        unknown_key,
+                        key.range(),
+                        &["baseUrl", "authHeader", "getPath", "putPath"],
+                    ));
+                    continue;
+                }
+            };
+            if let Some(text) = UnescapedString::deserialize(&value, &key_text, diagnostics) {
+                *field = Some(String::from(text));
+            }
+        }
+
+        let Some(base_url) = base_url else {
+            diagnostics.push(DeserializationDiagnostic::new(
+                "remoteCache.provider requires a baseUrl",
+            ));
+            return None;
+        };
+        if auth_header
+            .as_deref()
+            .is_some_and(|header| !header.contains(':'))
+        {
+            diagnostics.push(DeserializationDiagnostic::new(
+                "remoteCache.provider.authHeader must be in the form of \"<name>: <value>\"",
+            ));
+            return None;
+        }
+        let get_path = get_path.unwrap_or_else(|| DEFAULT_ARTIFACT_PATH.to_string());
+        let put_path = put_path.unwrap_or_else(|| DEFAULT_ARTIFACT_PATH.to_string());
+        // Without the hash every task would share a single artifact
+        if !get_path.contains("{hash}") || !put_path.contains("{hash}") {
+            diagnostics.push(DeserializationDiagnostic::new(
+                "remoteCache.provider paths must include {hash}",
+            ));
+            return None;
+        }
+
+        Some(CustomProvider {
+            base_url,
+            auth_header,
+            get_path,
+            put_path,
+        })
+    }
+}
+
 struct ConfigurationOptionsVisitor;
 
 impl DeserializationVisitor for ConfigurationOptionsVisitor {
@@ -490,6 +693,16 @@ impl DeserializationVisitor for ConfigurationOptionsVisitor {
                         result.timeout = Some(timeout);
                     }
                 }
+                "retries" => {
+                    if let Some(retries) = u32::deserialize(&value, &key_text, diagnostics) {
+                        result.retries = Some(retries);
+                    }
+                }
+                "uploadChunkSize" => {
+                    if let Some(size) = u64::deserialize(&value, &key_text, diagnostics) {
+                        result.upload_chunk_size = Some(size);
+                    }
+                }
                 "enabled" => {
                     if let Some(enabled) = bool::deserialize(&value, &key_text, diagnostics) {
                         result.enabled = Some(enabled);
@@ -507,6 +720,56 @@ impl DeserializationVisitor for ConfigurationOptionsVisitor {
     }
 }
 
+impl Deserializable for RawHooks {
+    fn deserialize(
+        value: &impl DeserializableValue,
+        name: &str,
+        diagnostics: &mut Vec<DeserializationDiagnostic>,
+    ) -> Option<Self> {
+        value.deserialize(RawHooksVisitor, name, diagnostics)
+    }
+}
+
+struct RawHooksVisitor;
+
+impl DeserializationVisitor for RawHooksVisitor {
+    type Output = RawHooks;
+
+    const EXPECTED_TYPE: VisitableType = VisitableType::MAP;
+
+    fn visit_map(
+        self,
+        members: impl Iterator<Item = Option<(impl DeserializableValue, impl DeserializableValue)>>,
+        _: TextRange,
+        _name: &str,
+        diagnostics: &mut Vec<DeserializationDiagnostic>,
+    ) -> Option<Self::Output> {
+        let mut result = RawHooks::default();
+        for (key, value) in members.flatten() {
+            let Some(key_text) = Text::deserialize(&key, "", diagnostics) else {
+                continue;
+            };
+            let hooks = match key_text.text() {
+                "preRun" => &mut result.pre_run,
+                "postTask" => &mut result.post_task,
+                "postRun" => &mut result.post_run,
+                unknown_key => {
+                    diagnostics.push(create_unknown_key_diagnostic_from_struct(
+                        &result,
+                        unknown_key,
+                        key.range(),
+                    ));
+                    continue;
+                }
+            };
+            if let Some(commands) = Vec::deserialize(&value, &key_text, diagnostics) {
+                *hooks = Some(commands);
+            }
+        }
+        Some(result)
+    }
+}
+
 impl Deserializable for RawTurboJson {
     fn deserialize(
         value: &impl DeserializableValue,
@@ -595,6 +858,11 @@ impl DeserializationVisitor for RawTurboJsonVisitor {
                         result.remote_cache = Some(remote_cache);
                     }
                 }
+                "hooks" => {
+                    if let Some(hooks) = RawHooks::deserialize(&value, &key_text, diagnostics) {
+                        result.hooks = Some(hooks);
+                    }
+                }
                 // Allow for faux-comments at the top level
                 "//" => {}
                 unknown_key => {
@@ -648,6 +916,8 @@ impl WithMetadata for Pipeline {
 
 impl WithMetadata for RawTaskDefinition {
     fn add_text(&mut self, text: Arc<str>) {
+        self.cache_logs.add_text(text.clone());
+        self.always_run.add_text(text.clone());
         self.depends_on.add_text(text.clone());
         if let Some(depends_on) = &mut self.depends_on {
             depends_on.value.add_text(text.clone());
@@ -655,13 +925,18 @@ impl WithMetadata for RawTaskDefinition {
         self.dot_env.add_text(text.clone());
         self.env.add_text(text.clone());
         self.inputs.add_text(text.clone());
+        self.root_inputs.add_text(text.clone());
+        self.inputs_from.add_text(text.clone());
         self.pass_through_env.add_text(text.clone());
         self.persistent.add_text(text.clone());
         self.outputs.add_text(text.clone());
-        self.output_mode.add_text(text);
+        self.output_mode.add_text(text.clone());
+        self.priority.add_text(text);
     }
 
     fn add_path(&mut self, path: Arc<str>) {
+        self.cache_logs.add_path(path.clone());
+        self.always_run.add_path(path.clone());
         self.depends_on.add_path(path.clone());
         if let Some(depends_on) = &mut self.depends_on {
             depends_on.value.add_path(path.clone());
@@ -669,10 +944,13 @@ impl WithMetadata for RawTaskDefinition {
         self.dot_env.add_path(path.clone());
         self.env.add_path(path.clone());
         self.inputs.add_path(path.clone());
+        self.root_inputs.add_path(path.clone());
+        self.inputs_from.add_path(path.clone());
         self.pass_through_env.add_path(path.clone());
         self.persistent.add_path(path.clone());
         self.outputs.add_path(path.clone());
-        self.output_mode.add_path(path);
+        self.output_mode.add_path(path.clone());
+        self.priority.add_path(path);
     }
 }
 
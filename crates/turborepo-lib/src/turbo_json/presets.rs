@@ -0,0 +1,245 @@
+use std::collections::btree_map::Entry;
+
+use turbopath::{AbsoluteSystemPath, AnchoredSystemPathBuf, PathError, RelativeUnixPathBuf};
+
+use super::{TurboJson, CONFIG_FILE};
+use crate::config::Error;
+
+impl TurboJson {
+    /// Applies the presets listed under `extends` in the root turbo.json.
+    /// A preset is either a path to a JSON file relative to the repository
+    /// root, or the name of an installed package with a turbo.json at its
+    /// root. Presets are applied in order and the root turbo.json overrides
+    /// all of them.
+    pub(crate) fn with_presets(self, repo_root: &AbsoluteSystemPath) -> Result<TurboJson, Error> {
+        if self.extends.is_empty() {
+            return Ok(self);
+        }
+
+        let mut turbo_json = TurboJson::default();
+        for preset in self.extends.iter() {
+            let path = preset_path(preset).map_err(|_| {
+                let (span, text) = self.extends.span_and_text("turbo.json");
+                Error::AbsolutePathInConfig {
+                    field: "extends",
+                    span,
+                    text,
+                }
+            })?;
+            if !repo_root.contains(&repo_root.resolve(&path)) {
+                let (span, text) = self.extends.span_and_text("turbo.json");
+                return Err(Error::PresetOutsideRepo {
+                    preset: preset.clone(),
+                    span,
+                    text,
+                });
+            }
+            let preset_json = match TurboJson::read(repo_root, &path) {
+                Err(Error::Io(_)) => {
+                    let (span, text) = self.extends.span_and_text("turbo.json");
+                    return Err(Error::PresetNotFound {
+                        preset: preset.clone(),
+                        path: path.as_str().to_string(),
+                        span,
+                        text,
+                    });
+                }
+                result => result?,
+            };
+            if !preset_json.extends.is_empty() {
+                let (span, text) = preset_json.extends.span_and_text(path.as_str());
+                return Err(Error::NestedPreset { span, text });
+            }
+            turbo_json.merge(preset_json);
+        }
+        turbo_json.merge(self);
+
+        Ok(turbo_json)
+    }
+
+    // Overrides everything in `self` that `other` sets. Task definitions are
+    // merged field by field while global dependencies and environment variables
+    // are combined.
+    fn merge(&mut self, other: TurboJson) {
+        self.text = other.text;
+        self.path = other.path;
+        self.extends = other.extends;
+
+        self.global_deps.extend(other.global_deps);
+        self.global_deps.sort();
+        self.global_deps.dedup();
+        self.global_env.extend(other.global_env);
+        self.global_env.sort();
+        self.global_env.dedup();
+        if other.global_pass_through_env.is_some() {
+            self.global_pass_through_env = other.global_pass_through_env;
+        }
+        if other.global_dot_env.is_some() {
+            self.global_dot_env = other.global_dot_env;
+        }
+
+        for (task_name, mut task_definition) in other.pipeline {
+            match self.pipeline.entry(task_name) {
+                Entry::Occupied(mut entry) => {
+                    let mut merged = entry.get().value.clone();
+                    merged.merge(task_definition.value);
+                    task_definition.value = merged;
+                    entry.insert(task_definition);
+                }
+                Entry::Vacant(entry) => {
+                    entry.insert(task_definition);
+                }
+            }
+        }
+
+        let hooks = other.hooks;
+        if !hooks.pre_run.is_empty() {
+            self.hooks.pre_run = hooks.pre_run;
+        }
+        if !hooks.post_task.is_empty() {
+            self.hooks.post_task = hooks.post_task;
+        }
+        if !hooks.post_run.is_empty() {
+            self.hooks.post_run = hooks.post_run;
+        }
+    }
+}
+
+// Presets ending in `.json` are files, anything else names a package that is
+// expected to be installed in the root node_modules
+fn preset_path(preset: &str) -> Result<AnchoredSystemPathBuf, PathError> {
+    let path = match preset.ends_with(".json") {
+        true => RelativeUnixPathBuf::new(preset)?,
+        false => RelativeUnixPathBuf::new(format!("node_modules/{preset}/{CONFIG_FILE}"))?,
+    };
+    Ok(path.to_anchored_system_path_buf().clean())
+}
+
+#[cfg(test)]
+mod test {
+    use std::fs;
+
+    use anyhow::Result;
+    use tempfile::tempdir;
+    use test_case::test_case;
+    use turbopath::{
+        AbsoluteSystemPath, AnchoredSystemPath, RelativeUnixPath, RelativeUnixPathBuf,
+    };
+    use turborepo_repository::package_json::PackageJson;
+
+    use super::preset_path;
+    use crate::{config::Error, run::task_id::TaskName, turbo_json::TurboJson};
+
+    #[test_case("./presets/base.json", "presets/base.json" ; "file")]
+    #[test_case("@acme/turbo-config", "node_modules/@acme/turbo-config/turbo.json" ; "package")]
+    fn test_preset_path(preset: &str, expected: &str) {
+        let expected = RelativeUnixPathBuf::new(expected)
+            .unwrap()
+            .to_anchored_system_path_buf();
+        assert_eq!(preset_path(preset).unwrap(), expected);
+    }
+
+    #[test]
+    fn test_absolute_preset_path() {
+        assert!(preset_path("/presets/base.json").is_err());
+    }
+
+    fn load(files: &[(&str, &str)]) -> Result<TurboJson, Error> {
+        let root_dir = tempdir().unwrap();
+        let repo_root = AbsoluteSystemPath::from_std_path(root_dir.path()).unwrap();
+        for (path, contents) in files {
+            let path = repo_root.join_unix_path(RelativeUnixPath::new(path).unwrap());
+            path.ensure_dir().unwrap();
+            fs::write(path, contents).unwrap();
+        }
+        TurboJson::load(
+            repo_root,
+            AnchoredSystemPath::empty(),
+            &PackageJson::default(),
+            false,
+        )
+    }
+
+    #[test]
+    fn test_extends_presets() -> Result<()> {
+        let turbo_json = load(&[
+            (
+                "turbo.json",
+                r#"{
+                    "extends": ["@acme/turbo-config", "./turbo.base.json"],
+                    "globalEnv": ["CI"],
+                    "pipeline": { "build": { "outputs": ["build/**"] } }
+                }"#,
+            ),
+            (
+                "node_modules/@acme/turbo-config/turbo.json",
+                r#"{
+                    "globalDependencies": ["tsconfig.base.json"],
+                    "pipeline": {
+                        "build": { "dependsOn": ["^build"], "outputs": ["dist/**"] },
+                        "lint": {}
+                    }
+                }"#,
+            ),
+            (
+                "turbo.base.json",
+                r#"{ "globalEnv": ["NODE_ENV"], "pipeline": { "test": { "cache": false } } }"#,
+            ),
+        ])?;
+
+        assert_eq!(turbo_json.extends.len(), 2);
+        assert_eq!(turbo_json.global_deps, vec!["tsconfig.base.json"]);
+        assert_eq!(turbo_json.global_env, vec!["CI", "NODE_ENV"]);
+        let tasks = turbo_json.pipeline.keys().cloned().collect::<Vec<_>>();
+        assert_eq!(
+            tasks,
+            vec![
+                TaskName::from("build"),
+                TaskName::from("lint"),
+                TaskName::from("test")
+            ]
+        );
+
+        let build = serde_json::to_value(&turbo_json.pipeline[&TaskName::from("build")].value)?;
+        assert_eq!(
+            build,
+            serde_json::json!({ "dependsOn": ["^build"], "outputs": ["build/**"] })
+        );
+
+        Ok(())
+    }
+
+    #[test_case(
+        &[("turbo.json", r#"{ "extends": ["./missing.json"] }"#)],
+        "Preset \"./missing.json\" not found, expected a turbo.json at missing.json"
+        ; "missing preset"
+    )]
+    #[test_case(
+        &[
+            ("turbo.json", r#"{ "extends": ["./base.json"] }"#),
+            ("base.json", r#"{ "extends": ["./other.json"] }"#),
+            ("other.json", "{}"),
+        ],
+        "Presets cannot extend other turbo.json files"
+        ; "nested preset"
+    )]
+    #[test_case(
+        &[("turbo.json", r#"{ "extends": ["../shared/turbo.json"] }"#)],
+        "Preset \"../shared/turbo.json\" is outside of the repository"
+        ; "parent directory"
+    )]
+    #[test_case(
+        &[("turbo.json", r#"{ "extends": ["./presets/../../base.json"] }"#)],
+        "Preset \"./presets/../../base.json\" is outside of the repository"
+        ; "escapes after cleaning"
+    )]
+    #[test_case(
+        &[("turbo.json", r#"{ "extends": ["../../etc"] }"#)],
+        "Preset \"../../etc\" is outside of the repository"
+        ; "package name escapes"
+    )]
+    fn test_invalid_presets(files: &[(&str, &str)], expected: &str) {
+        let err = load(files).unwrap_err();
+        assert_eq!(err.to_string(), expected);
+    }
+}
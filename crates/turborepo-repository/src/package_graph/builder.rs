@@ -269,7 +269,7 @@ impl<'a, T: PackageDiscovery> BuildState<'a, ResolvedPackageManager, T> {
             None => {
                 let mut jsons = HashMap::new();
                 for path in self.package_discovery.discover_packages().await?.workspaces {
-                    let json = PackageJson::load(&path.package_json)?;
+                    let json = PackageJson::load_structured(&path.package_json)?;
                     jsons.insert(path.package_json, json);
                 }
                 Ok::<_, Error>(jsons)
@@ -219,7 +219,6 @@ impl PackageGraph {
     /// a -> b -> c
     ///
     /// immediate_ancestors(c) -> {b}
-    #[allow(dead_code)]
     pub fn immediate_ancestors(&self, package: &PackageNode) -> Option<HashSet<&PackageNode>> {
         let index = self.node_lookup.get(package)?;
         Some(
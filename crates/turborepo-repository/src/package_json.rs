@@ -30,11 +30,75 @@ pub struct PackageJson {
     pub resolutions: Option<BTreeMap<String, String>>,
     #[serde(skip_serializing_if = "Option::is_none")]
     pub pnpm: Option<PnpmConfig>,
-    // Unstructured fields kept for round trip capabilities
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub engines: Option<BTreeMap<String, String>>,
+    // Unstructured fields kept for round trip capabilities. Empty when loaded
+    // with `load_structured`.
     #[serde(flatten)]
     pub other: BTreeMap<String, Value>,
 }
 
+// The structured fields of a package.json. Without a flattened field, serde
+// skips unknown fields instead of buffering the whole file, which adds up in
+// repositories with thousands of packages.
+//
+// Loading these fields can't be deferred to the packages in scope: filters are
+// resolved against the package graph, which needs the name, dependencies and
+// scripts of every package before the scope is known. The unstructured fields
+// are the only part that no run reads, so they're the part that's skipped.
+#[derive(Debug, Deserialize)]
+#[serde(rename_all = "camelCase")]
+struct StructuredPackageJson {
+    name: Option<String>,
+    version: Option<String>,
+    package_manager: Option<String>,
+    dependencies: Option<BTreeMap<String, String>>,
+    dev_dependencies: Option<BTreeMap<String, String>>,
+    optional_dependencies: Option<BTreeMap<String, String>>,
+    peer_dependencies: Option<BTreeMap<String, String>>,
+    #[serde(rename = "turbo", default)]
+    legacy_turbo_config: Option<Value>,
+    #[serde(default)]
+    scripts: BTreeMap<String, String>,
+    resolutions: Option<BTreeMap<String, String>>,
+    pnpm: Option<PnpmConfig>,
+    engines: Option<BTreeMap<String, String>>,
+}
+
+impl From<StructuredPackageJson> for PackageJson {
+    fn from(value: StructuredPackageJson) -> Self {
+        let StructuredPackageJson {
+            name,
+            version,
+            package_manager,
+            dependencies,
+            dev_dependencies,
+            optional_dependencies,
+            peer_dependencies,
+            legacy_turbo_config,
+            scripts,
+            resolutions,
+            pnpm,
+            engines,
+        } = value;
+        Self {
+            name,
+            version,
+            package_manager,
+            dependencies,
+            dev_dependencies,
+            optional_dependencies,
+            peer_dependencies,
+            legacy_turbo_config,
+            scripts,
+            resolutions,
+            pnpm,
+            engines,
+            other: BTreeMap::new(),
+        }
+    }
+}
+
 #[derive(Debug, Clone, Serialize, Deserialize, PartialEq, Eq)]
 #[serde(rename_all = "camelCase")]
 pub struct PnpmConfig {
@@ -60,6 +124,20 @@ impl PackageJson {
         Self::from_str(&contents)
     }
 
+    /// Loads a package.json without its unstructured fields. Use this for
+    /// package.json files that are read rather than written back out, e.g.
+    /// those of every package in the workspace.
+    pub fn load_structured(path: &AbsoluteSystemPath) -> Result<PackageJson, Error> {
+        tracing::debug!("loading package.json from {}", path);
+        let contents = path.read_to_string()?;
+        Self::parse_structured(&contents)
+    }
+
+    pub fn parse_structured(contents: &str) -> Result<PackageJson, Error> {
+        let package_json: StructuredPackageJson = serde_json::from_str(contents)?;
+        Ok(package_json.into())
+    }
+
     // Utility method for easy construction of package.json during testing
     pub fn from_value(value: serde_json::Value) -> Result<PackageJson, Error> {
         let package_json: PackageJson = serde_json::from_value(value)?;
@@ -102,6 +180,31 @@ mod test {
         assert_eq!(actual, json);
     }
 
+    #[test]
+    fn test_parse_structured() -> Result<()> {
+        let contents = json!({
+            "name": "foo",
+            "version": "1.0.0",
+            "dependencies": {"bar": "workspace:*"},
+            "scripts": {"build": "tsc"},
+            "engines": {"node": ">=18"},
+            "turbo": {},
+            "jest": {"preset": "ts-jest"},
+            "files": ["dist"],
+        });
+        let full = PackageJson::from_value(contents.clone())?;
+        let structured = PackageJson::parse_structured(&contents.to_string())?;
+
+        assert_eq!(
+            structured,
+            PackageJson {
+                other: BTreeMap::new(),
+                ..full
+            }
+        );
+        Ok(())
+    }
+
     #[test]
     fn test_legacy_turbo_config() -> Result<()> {
         let contents = r#"{"turbo": {}}"#;
@@ -28,9 +28,44 @@ use crate::{
     package_manager::{bun::BunDetector, npm::NpmDetector, pnpm::PnpmDetector, yarn::YarnDetector},
 };
 
-#[derive(Debug, Deserialize)]
+#[derive(Debug, Default, Deserialize)]
 struct PnpmWorkspace {
-    pub packages: Vec<String>,
+    // Commenting out every entry leaves `packages` empty, and commenting out
+    // an entry's value leaves an empty item
+    pub packages: Option<Vec<Option<String>>>,
+}
+
+impl PnpmWorkspace {
+    fn from_yaml(contents: &str) -> Result<Self, Error> {
+        let mut value: serde_yaml::Value = serde_yaml::from_str(contents)?;
+        // Anchors merged into a mapping with `<<` are only resolved on request
+        value.apply_merge()?;
+        // A file with nothing but comments is an empty document
+        if value.is_null() {
+            return Ok(Self::default());
+        }
+        Ok(serde_yaml::from_value(value)?)
+    }
+
+    /// The workspace globs, written the way the rest of the globs are. pnpm
+    /// ignores surrounding whitespace and a leading `./`, including after the
+    /// `!` of a negation.
+    fn globs(self) -> Vec<String> {
+        self.packages
+            .into_iter()
+            .flatten()
+            .flatten()
+            .filter_map(|glob| {
+                let glob = glob.trim();
+                let (negation, glob) = match glob.strip_prefix('!') {
+                    Some(glob) => ("!", glob.trim_start()),
+                    None => ("", glob),
+                };
+                let glob = glob.trim_start_matches("./");
+                (!glob.is_empty()).then(|| format!("{negation}{glob}"))
+            })
+            .collect()
+    }
 }
 
 #[derive(Debug, Deserialize)]
@@ -357,11 +392,11 @@ impl PackageManager {
                 let source = self.workspace_glob_source(root_path);
                 let workspace_yaml = fs::read_to_string(source)
                     .map_err(|_| Error::Workspace(MissingWorkspaceError::from(self)))?;
-                let pnpm_workspace: PnpmWorkspace = serde_yaml::from_str(&workspace_yaml)?;
-                if pnpm_workspace.packages.is_empty() {
+                let globs = PnpmWorkspace::from_yaml(&workspace_yaml)?.globs();
+                if globs.is_empty() {
                     return Err(MissingWorkspaceError::from(self).into());
                 } else {
-                    pnpm_workspace.packages
+                    globs
                 }
             }
             PackageManager::Berry
@@ -600,6 +635,7 @@ mod tests {
 
     use pretty_assertions::assert_eq;
     use tempfile::tempdir;
+    use test_case::test_case;
     use turbopath::AbsoluteSystemPathBuf;
 
     use super::*;
@@ -888,6 +924,27 @@ mod tests {
         Ok(())
     }
 
+    #[test_case("packages:\n  - apps/*\n  - '!apps/legacy'\n", &["apps/*", "!apps/legacy"] ; "negation")]
+    #[test_case("packages:\n  - ./apps/*\n  - '! ./apps/legacy'\n", &["apps/*", "!apps/legacy"] ; "leading dot slash")]
+    #[test_case("packages:\n  # - apps/*\n  - packages/* # shared\n", &["packages/*"] ; "comments")]
+    #[test_case("packages:\n  -\n  - packages/*\n", &["packages/*"] ; "empty item")]
+    #[test_case("packages:\n# - apps/*\n", &[] ; "commented out")]
+    #[test_case("# packages:\n# - apps/*\n", &[] ; "only comments")]
+    #[test_case(
+        "packages:\n  - &apps apps/*\n  - packages/*\ncatalog:\n  globs: *apps\n",
+        &["apps/*", "packages/*"]
+        ; "anchor"
+    )]
+    #[test_case(
+        "base: &base\n  packages: [apps/*]\n<<: *base\n",
+        &["apps/*"]
+        ; "merged anchor"
+    )]
+    fn test_pnpm_workspace_globs(contents: &str, expected: &[&str]) {
+        let globs = PnpmWorkspace::from_yaml(contents).unwrap().globs();
+        assert_eq!(globs, expected);
+    }
+
     #[test]
     fn test_workspace_globs_trailing_slash() {
         let globs =
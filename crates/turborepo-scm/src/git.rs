@@ -21,6 +21,30 @@ impl SCM {
         }
     }
 
+    /// Returns the name of the branch that `origin/HEAD` points to, e.g.
+    /// `origin/main`. CI checkouts often don't set `origin/HEAD`, so the usual
+    /// default branch names are tried after it.
+    pub fn default_branch(&self, path: &AbsoluteSystemPath) -> Result<String, Error> {
+        match self {
+            Self::Git(git) => git.default_branch(),
+            Self::Manual => Err(Error::GitRequired(path.to_owned())),
+        }
+    }
+
+    /// Returns the best common ancestor of the two commits, which is the
+    /// point a branch forked from its base.
+    pub fn merge_base(
+        &self,
+        path: &AbsoluteSystemPath,
+        first_commit: &str,
+        second_commit: &str,
+    ) -> Result<String, Error> {
+        match self {
+            Self::Git(git) => git.merge_base(first_commit, second_commit),
+            Self::Manual => Err(Error::GitRequired(path.to_owned())),
+        }
+    }
+
     pub fn changed_files(
         &self,
         turbo_root: &AbsoluteSystemPath,
@@ -92,6 +116,42 @@ impl Git {
         Ok(output.trim().to_owned())
     }
 
+    fn default_branch(&self) -> Result<String, Error> {
+        if let Ok(output) =
+            self.execute_git_command(&["symbolic-ref", "--short", "refs/remotes/origin/HEAD"], "")
+        {
+            return Ok(String::from_utf8(output)?.trim().to_owned());
+        }
+
+        ["origin/main", "origin/master", "main", "master"]
+            .into_iter()
+            .find(|branch| {
+                self.execute_git_command(
+                    &[
+                        "rev-parse",
+                        "--verify",
+                        "--quiet",
+                        &format!("{branch}^{{commit}}"),
+                    ],
+                    "",
+                )
+                .is_ok()
+            })
+            .map(str::to_owned)
+            .ok_or_else(|| {
+                Error::Git(
+                    "unable to detect the default branch, origin/HEAD is not set".to_owned(),
+                    Backtrace::capture(),
+                )
+            })
+    }
+
+    fn merge_base(&self, first_commit: &str, second_commit: &str) -> Result<String, Error> {
+        let output = self.execute_git_command(&["merge-base", first_commit, second_commit], "")?;
+        let output = String::from_utf8(output)?;
+        Ok(output.trim().to_owned())
+    }
+
     fn changed_files(
         &self,
         turbo_root: &AbsoluteSystemPath,
@@ -236,7 +296,7 @@ mod tests {
     use which::which;
 
     use super::previous_content;
-    use crate::{git::changed_files, Error};
+    use crate::{git::changed_files, Error, SCM};
 
     fn setup_repository() -> Result<(TempDir, Repository), Error> {
         let repo_root = tempfile::tempdir()?;
@@ -382,6 +442,17 @@ mod tests {
 
         assert_eq!(merge_base, second_commit_oid);
 
+        let scm_root = AbsoluteSystemPathBuf::try_from(repo_root.path())?;
+        let scm = SCM::new(&scm_root);
+        assert_eq!(
+            scm.merge_base(
+                &scm_root,
+                &third_commit_oid.to_string(),
+                &fourth_commit_oid.to_string()
+            )?,
+            second_commit_oid.to_string()
+        );
+
         let files = changed_files(
             repo_root.path().to_path_buf(),
             repo_root.path().to_path_buf(),
@@ -397,6 +468,38 @@ mod tests {
         Ok(())
     }
 
+    #[test]
+    fn test_default_branch() -> Result<(), Error> {
+        let (repo_root, repo) = setup_repository()?;
+        fs::write(repo_root.path().join("foo.js"), "let z = 0;")?;
+        let commit_oid = commit_file(&repo, Path::new("foo.js"), None);
+
+        let scm_root = AbsoluteSystemPathBuf::try_from(repo_root.path())?;
+        // Without origin/HEAD, the usual default branch names are tried
+        repo.reference("refs/remotes/origin/main", commit_oid, false, "")
+            .unwrap();
+        assert_eq!(
+            SCM::new(&scm_root).default_branch(&scm_root)?,
+            "origin/main"
+        );
+
+        repo.reference("refs/remotes/origin/trunk", commit_oid, false, "")
+            .unwrap();
+        repo.reference_symbolic(
+            "refs/remotes/origin/HEAD",
+            "refs/remotes/origin/trunk",
+            false,
+            "",
+        )
+        .unwrap();
+        assert_eq!(
+            SCM::new(&scm_root).default_branch(&scm_root)?,
+            "origin/trunk"
+        );
+
+        Ok(())
+    }
+
     #[test]
     fn test_changed_files() -> Result<(), Error> {
         let (repo_root, repo) = setup_repository()?;
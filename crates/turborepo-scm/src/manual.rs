@@ -80,7 +80,9 @@ pub(crate) fn get_package_file_hashes_without_git<S: AsRef<str>>(
             includes.push(g);
         }
     }
-    let include_pattern = if includes.is_empty() {
+    // Inputs that only exclude files still only match the config files here,
+    // the rest come from the default files
+    let include_pattern = if inputs.is_empty() {
         None
     } else {
         // Add in package.json and turbo.json to input patterns. Both file paths are
@@ -451,5 +453,19 @@ mod tests {
         .unwrap();
 
         assert_eq!(hashes, expected);
+
+        // inputs that only exclude files start from the default files
+        let mut expected =
+            get_package_file_hashes_without_git::<&str>(&turbo_root, &pkg_path, &[], false)
+                .unwrap();
+        expected.remove(&RelativeUnixPathBuf::new("some-dir/excluded-file").unwrap());
+        let hashes = get_package_file_hashes_without_git(
+            &turbo_root,
+            &pkg_path,
+            &["!some-dir/excluded-file"],
+            true,
+        )
+        .unwrap();
+        assert_eq!(hashes, expected);
     }
 }
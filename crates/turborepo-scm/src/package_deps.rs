@@ -11,6 +11,21 @@ pub type GitHashes = HashMap<RelativeUnixPathBuf, String>;
 
 const INPUT_INCLUDE_DEFAULT_FILES: &str = "$TURBO_DEFAULT$";
 
+// Whether the files that are hashed without any inputs should be hashed along
+// with the given inputs. That's the case if the inputs contain
+// "$TURBO_DEFAULT$", or if every input is an exclusion as there would be
+// nothing to exclude the files from otherwise. NOTE: we intentionally don't
+// remove "$TURBO_DEFAULT$" from the inputs if it exists in the off chance that
+// the user has a file named "$TURBO_DEFAULT$" in their package (pls no).
+fn include_default_files<S: AsRef<str>>(inputs: &[S]) -> bool {
+    let only_exclusions =
+        !inputs.is_empty() && inputs.iter().all(|input| input.as_ref().starts_with('!'));
+    only_exclusions
+        || inputs
+            .iter()
+            .any(|input| input.as_ref() == INPUT_INCLUDE_DEFAULT_FILES)
+}
+
 impl SCM {
     pub fn get_hashes_for_files(
         &self,
@@ -33,14 +48,7 @@ impl SCM {
         inputs: &[S],
         telemetry: Option<PackageTaskEventBuilder>,
     ) -> Result<GitHashes, Error> {
-        // If the inputs contain "$TURBO_DEFAULT$", we need to include the "default"
-        // file hashes as well. NOTE: we intentionally don't remove
-        // "$TURBO_DEFAULT$" from the inputs if it exists in the off chance that
-        // the user has a file named "$TURBO_DEFAULT$" in their package (pls
-        // no).
-        let include_default_files = inputs
-            .iter()
-            .any(|input| input.as_ref() == INPUT_INCLUDE_DEFAULT_FILES);
+        let include_default_files = include_default_files(inputs);
 
         match self {
             SCM::Manual => {
@@ -124,7 +132,7 @@ impl Git {
             return self.get_package_file_hashes_from_index(turbo_root, package_path);
         }
 
-        // we have inputs, but no $TURBO_DEFAULT$
+        // we have inputs, but no $TURBO_DEFAULT$ and something to include
         if !include_default_files {
             return self.get_package_file_hashes_from_inputs(
                 turbo_root,
@@ -134,7 +142,7 @@ impl Git {
             );
         }
 
-        // we have inputs, and $TURBO_DEFAULT$
+        // we have inputs, and $TURBO_DEFAULT$ or only exclusions
         self.get_package_file_hashes_from_inputs_and_index(turbo_root, package_path, inputs)
     }
 
@@ -585,6 +593,15 @@ mod tests {
                     "$TURBO_DEFAULT$",
                 ],
             ),
+            (
+                &["!committed-file"],
+                &[
+                    "uncommitted-file",
+                    "package.json",
+                    "turbo.json",
+                    "dir/nested-file",
+                ],
+            ),
             (
                 &["!committed-file", "$TURBO_DEFAULT$", "dir/ignored-file"],
                 &[
@@ -603,9 +620,7 @@ mod tests {
                 let value = all_expected.get(&key).unwrap().clone();
                 (key, value)
             }));
-            let include_default_files = inputs
-                .iter()
-                .any(|input| input == &INPUT_INCLUDE_DEFAULT_FILES);
+            let include_default_files = include_default_files(inputs);
 
             let hashes = git
                 .get_package_file_hashes(&repo_root, &package_path, inputs, include_default_files)
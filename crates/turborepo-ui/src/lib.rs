@@ -6,7 +6,8 @@ mod color_selector;
 mod logs;
 mod output;
 mod prefixed;
-mod tui;
+pub mod tui;
+mod warnings;
 
 use std::{borrow::Cow, env, f64::consts::PI, time::Duration};
 
@@ -21,6 +22,7 @@ pub use crate::{
     output::{OutputClient, OutputClientBehavior, OutputSink, OutputWriter},
     prefixed::{PrefixedUI, PrefixedWriter},
     tui::{TaskTable, TerminalPane},
+    warnings::Warnings,
 };
 
 #[derive(Debug, Error)]
@@ -73,7 +75,12 @@ macro_rules! cprintln {
 
         let colored_str = $color.apply_to(formatted_str);
 
-        println!("{}", $ui.apply(colored_str))
+        let ui = &$ui;
+        if ui.to_stderr {
+            eprintln!("{}", ui.apply(colored_str))
+        } else {
+            println!("{}", ui.apply(colored_str))
+        }
     }};
 }
 
@@ -84,7 +91,12 @@ macro_rules! cprint {
 
         let colored_str = $color.apply_to(formatted_str);
 
-        print!("{}", $ui.apply(colored_str))
+        let ui = &$ui;
+        if ui.to_stderr {
+            eprint!("{}", ui.apply(colored_str))
+        } else {
+            print!("{}", ui.apply(colored_str))
+        }
     }};
 }
 
@@ -114,11 +126,24 @@ macro_rules! cwriteln {
 #[derive(Debug, Clone, Copy)]
 pub struct UI {
     pub should_strip_ansi: bool,
+    // Print to stderr instead of stdout, which is kept for machine readable
+    // output
+    pub to_stderr: bool,
 }
 
 impl UI {
     pub fn new(should_strip_ansi: bool) -> Self {
-        Self { should_strip_ansi }
+        Self {
+            should_strip_ansi,
+            to_stderr: false,
+        }
+    }
+
+    pub fn with_stderr(self) -> Self {
+        Self {
+            to_stderr: true,
+            ..self
+        }
     }
 
     /// Infer the color choice from environment variables and checking if stdout
@@ -133,7 +158,7 @@ impl UI {
                     _ => None,
                 });
         let should_strip_ansi = env_setting.unwrap_or_else(|| !atty::is(atty::Stream::Stdout));
-        Self { should_strip_ansi }
+        Self::new(should_strip_ansi)
     }
 
     /// Apply the UI color mode to the given styled object
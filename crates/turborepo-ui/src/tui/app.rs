@@ -0,0 +1,281 @@
+use std::{
+    io::{self, Stdout},
+    sync::mpsc,
+    time::{Duration, Instant},
+};
+
+use crossterm::{
+    event::{KeyCode, KeyEvent, KeyEventKind, KeyModifiers},
+    terminal::{disable_raw_mode, enable_raw_mode, EnterAlternateScreen, LeaveAlternateScreen},
+};
+use ratatui::{
+    backend::{Backend, CrosstermBackend},
+    layout::{Constraint, Direction, Layout, Rect},
+    Frame, Terminal,
+};
+use tracing::debug;
+
+use super::{event::Event, handle::AppReceiver, Error, TaskTable, TerminalPane};
+
+const FRAMERATE: Duration = Duration::from_millis(50);
+// Share of the screen width given to the output of the selected task
+const PANE_WIDTH_PERCENT: u16 = 70;
+
+/// Why the terminal UI exited
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum AppExit {
+    /// The run finished
+    Finished,
+    /// The user pressed Ctrl-C, the run should be stopped
+    Interrupted,
+}
+
+struct App {
+    table: TaskTable,
+    pane: TerminalPane<()>,
+    exit: Option<AppExit>,
+}
+
+impl App {
+    fn new(rows: u16, cols: u16, tasks: Vec<String>) -> Self {
+        let mut app = Self {
+            table: TaskTable::new(tasks.clone()),
+            pane: TerminalPane::new(
+                rows,
+                pane_width(cols),
+                tasks.into_iter().map(|task| (task, None)),
+            ),
+            exit: None,
+        };
+        // Start with the first task selected so there is always output to show
+        app.next();
+        app
+    }
+
+    fn next(&mut self) {
+        self.table.next();
+        self.select_pane();
+    }
+
+    fn previous(&mut self) {
+        self.table.previous();
+        self.select_pane();
+    }
+
+    fn select_pane(&mut self) {
+        if let Some(task) = self.table.selected() {
+            // Every task in the table has a pane
+            self.pane.select(task).ok();
+        }
+    }
+}
+
+/// Takes over the terminal to show the tasks of a run and the output of the
+/// selected one until `receiver` is told that the run is over or the user
+/// interrupts it. The terminal is restored before returning.
+pub fn run_app(tasks: Vec<String>, receiver: AppReceiver) -> Result<AppExit, Error> {
+    let mut terminal = startup()?;
+    let size = terminal.size()?;
+    let mut app = App::new(size.height, size.width, tasks);
+
+    let result = run_app_inner(&mut terminal, &mut app, &receiver);
+    cleanup(terminal)?;
+    result
+}
+
+fn run_app_inner<B: Backend>(
+    terminal: &mut Terminal<B>,
+    app: &mut App,
+    receiver: &AppReceiver,
+) -> Result<AppExit, Error> {
+    let mut last_render = Instant::now();
+    terminal.draw(|f| view(app, f))?;
+    loop {
+        if let Some(exit) = app.exit {
+            return Ok(exit);
+        }
+        if let Some(event) = poll(receiver, last_render + FRAMERATE)? {
+            update(app, event)?;
+        }
+        if last_render.elapsed() >= FRAMERATE {
+            app.table.tick();
+            terminal.draw(|f| view(app, f))?;
+            last_render = Instant::now();
+        }
+    }
+}
+
+/// Waits until `deadline` for the next event, user input takes precedence
+/// over updates from the run
+fn poll(receiver: &AppReceiver, deadline: Instant) -> Result<Option<Event>, Error> {
+    if crossterm::event::poll(Duration::ZERO)? {
+        if let Some(event) = input(crossterm::event::read()?) {
+            return Ok(Some(event));
+        }
+    }
+    match receiver.recv(deadline.saturating_duration_since(Instant::now())) {
+        Ok(event) => Ok(Some(event)),
+        Err(mpsc::RecvTimeoutError::Timeout) => Ok(None),
+        // Every sender is gone so nothing will tell us to stop
+        Err(mpsc::RecvTimeoutError::Disconnected) => Ok(Some(Event::Stop)),
+    }
+}
+
+fn input(event: crossterm::event::Event) -> Option<Event> {
+    match event {
+        crossterm::event::Event::Key(KeyEvent {
+            code,
+            modifiers,
+            kind: KeyEventKind::Press,
+            ..
+        }) => match code {
+            // Raw mode keeps Ctrl-C from sending an interrupt to the process
+            KeyCode::Char('c') if modifiers == KeyModifiers::CONTROL => Some(Event::Interrupt),
+            KeyCode::Up | KeyCode::Char('k') => Some(Event::Up),
+            KeyCode::Down | KeyCode::Char('j') => Some(Event::Down),
+            _ => None,
+        },
+        crossterm::event::Event::Resize(cols, rows) => Some(Event::Resize { rows, cols }),
+        _ => None,
+    }
+}
+
+fn update(app: &mut App, event: Event) -> Result<(), Error> {
+    match event {
+        Event::StartTask { task } => {
+            if let Err(e) = app.table.start_task(&task) {
+                debug!("unable to start {task}: {e}");
+            }
+        }
+        Event::TaskOutput { task, output } => {
+            if let Err(e) = app.pane.process_output(&task, &output) {
+                debug!("unable to process output of {task}: {e}");
+            }
+        }
+        Event::EndTask { task, result } => {
+            if let Err(e) = app.table.finish_task(&task, result) {
+                debug!("unable to finish {task}: {e}");
+            }
+        }
+        Event::Resize { rows, cols } => {
+            // The pane's borders take up two rows and two columns
+            app.pane
+                .resize(rows.saturating_sub(2), pane_width(cols).saturating_sub(2))?;
+        }
+        Event::Up => app.previous(),
+        Event::Down => app.next(),
+        Event::Interrupt => app.exit = Some(AppExit::Interrupted),
+        Event::Stop => app.exit = Some(AppExit::Finished),
+    }
+    Ok(())
+}
+
+fn view(app: &mut App, f: &mut Frame) {
+    let (table_area, pane_area) = layout(f.size());
+    app.table.stateful_render(f, table_area);
+    f.render_widget(&app.pane, pane_area);
+}
+
+fn layout(area: Rect) -> (Rect, Rect) {
+    let areas = Layout::default()
+        .direction(Direction::Horizontal)
+        .constraints([
+            Constraint::Length(area.width - pane_width(area.width)),
+            Constraint::Length(pane_width(area.width)),
+        ])
+        .split(area);
+    (areas[0], areas[1])
+}
+
+fn pane_width(cols: u16) -> u16 {
+    (cols as u32 * PANE_WIDTH_PERCENT as u32 / 100) as u16
+}
+
+fn startup() -> io::Result<Terminal<CrosstermBackend<Stdout>>> {
+    enable_raw_mode()?;
+    let mut stdout = io::stdout();
+    crossterm::execute!(stdout, EnterAlternateScreen)?;
+    let mut terminal = Terminal::new(CrosstermBackend::new(stdout))?;
+    terminal.hide_cursor()?;
+    Ok(terminal)
+}
+
+fn cleanup(mut terminal: Terminal<CrosstermBackend<Stdout>>) -> io::Result<()> {
+    crossterm::execute!(terminal.backend_mut(), LeaveAlternateScreen)?;
+    disable_raw_mode()?;
+    terminal.show_cursor()
+}
+
+#[cfg(test)]
+mod test {
+    use super::*;
+    use crate::tui::TaskResult;
+
+    #[test]
+    fn test_task_output() {
+        let mut app = App::new(10, 40, vec!["a".to_string(), "b".to_string()]);
+        assert_eq!(
+            app.table.selected(),
+            Some("a"),
+            "first task starts selected"
+        );
+        update(&mut app, Event::Down).unwrap();
+        assert_eq!(app.table.selected(), Some("b"));
+        update(
+            &mut app,
+            Event::TaskOutput {
+                task: "b".to_string(),
+                output: b"hello\r\n".to_vec(),
+            },
+        )
+        .unwrap();
+        // Output for tasks the UI doesn't know about is ignored
+        update(
+            &mut app,
+            Event::TaskOutput {
+                task: "missing".to_string(),
+                output: b"hello\r\n".to_vec(),
+            },
+        )
+        .unwrap();
+    }
+
+    #[test]
+    fn test_exit() {
+        let mut app = App::new(10, 40, vec!["a".to_string()]);
+        update(
+            &mut app,
+            Event::StartTask {
+                task: "a".to_string(),
+            },
+        )
+        .unwrap();
+        update(
+            &mut app,
+            Event::EndTask {
+                task: "a".to_string(),
+                result: TaskResult::Success,
+            },
+        )
+        .unwrap();
+        // Tasks that never started are ignored
+        update(
+            &mut app,
+            Event::EndTask {
+                task: "b".to_string(),
+                result: TaskResult::Failure,
+            },
+        )
+        .unwrap();
+        assert_eq!(app.exit, None);
+        update(&mut app, Event::Interrupt).unwrap();
+        assert_eq!(app.exit, Some(AppExit::Interrupted));
+    }
+
+    #[test]
+    fn test_layout() {
+        let (table, pane) = layout(Rect::new(0, 0, 100, 20));
+        assert_eq!(table, Rect::new(0, 0, 30, 20));
+        assert_eq!(pane, Rect::new(30, 0, 70, 20));
+    }
+}
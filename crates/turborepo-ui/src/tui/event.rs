@@ -0,0 +1,14 @@
+use super::task::TaskResult;
+
+pub enum Event {
+    StartTask { task: String },
+    TaskOutput { task: String, output: Vec<u8> },
+    EndTask { task: String, result: TaskResult },
+    Resize { rows: u16, cols: u16 },
+    Up,
+    Down,
+    // The user asked for the run to be stopped
+    Interrupt,
+    // The run has finished
+    Stop,
+}
@@ -0,0 +1,115 @@
+use std::{
+    io::{self, Write},
+    sync::mpsc,
+    time::Duration,
+};
+
+use super::{event::Event, task::TaskResult};
+
+/// Sends updates about a run to the terminal UI
+#[derive(Debug, Clone)]
+pub struct AppSender {
+    primary: mpsc::Sender<Event>,
+}
+
+/// Receives the updates sent by an `AppSender`
+pub struct AppReceiver {
+    primary: mpsc::Receiver<Event>,
+}
+
+/// Writes the output of a single task to its pane in the terminal UI
+#[derive(Debug, Clone)]
+pub struct TuiTask {
+    name: String,
+    handle: AppSender,
+}
+
+impl AppSender {
+    pub fn new() -> (Self, AppReceiver) {
+        let (primary, rx) = mpsc::channel();
+        (Self { primary }, AppReceiver { primary: rx })
+    }
+
+    /// Construct a writer for the output of `task`
+    pub fn task(&self, task: String) -> TuiTask {
+        TuiTask {
+            name: task,
+            handle: self.clone(),
+        }
+    }
+
+    /// Mark the given task as started
+    pub fn start_task(&self, task: String) {
+        self.send(Event::StartTask { task });
+    }
+
+    /// Mark the given task as finished
+    pub fn end_task(&self, task: String, result: TaskResult) {
+        self.send(Event::EndTask { task, result });
+    }
+
+    /// Tell the terminal UI that the run is over and it should exit
+    pub fn stop(&self) {
+        self.send(Event::Stop);
+    }
+
+    fn send(&self, event: Event) {
+        // Sending only fails once the UI has exited, at which point there is
+        // nothing left to show the update
+        let _ = self.primary.send(event);
+    }
+}
+
+impl AppReceiver {
+    /// Waits up to `timeout` for the next event
+    pub(super) fn recv(&self, timeout: Duration) -> Result<Event, mpsc::RecvTimeoutError> {
+        self.primary.recv_timeout(timeout)
+    }
+}
+
+impl TuiTask {
+    pub fn name(&self) -> &str {
+        &self.name
+    }
+}
+
+impl Write for TuiTask {
+    fn write(&mut self, buf: &[u8]) -> io::Result<usize> {
+        // Task output is usually written for a terminal in cooked mode where a
+        // newline also returns the cursor to the start of the line, which
+        // isn't the case for the emulated terminal of a pane
+        let mut output = Vec::with_capacity(buf.len());
+        for byte in buf {
+            if *byte == b'\n' {
+                output.push(b'\r');
+            }
+            output.push(*byte);
+        }
+        self.handle.send(Event::TaskOutput {
+            task: self.name.clone(),
+            output,
+        });
+        Ok(buf.len())
+    }
+
+    fn flush(&mut self) -> io::Result<()> {
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod test {
+    use super::*;
+
+    #[test]
+    fn test_task_output_returns_cursor() {
+        let (sender, receiver) = AppSender::new();
+        let mut task = sender.task("web#build".to_string());
+        task.write_all(b"one\ntwo\n").unwrap();
+        let Ok(Event::TaskOutput { task, output }) = receiver.recv(Duration::ZERO) else {
+            panic!("expected task output");
+        };
+        assert_eq!(task, "web#build");
+        assert_eq!(output, b"one\r\ntwo\r\n");
+    }
+}
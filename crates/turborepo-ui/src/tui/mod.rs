@@ -1,10 +1,16 @@
+mod app;
+mod event;
+mod handle;
 mod pane;
 mod table;
 mod task;
 mod task_duration;
 
+pub use app::{run_app, AppExit};
+pub use handle::{AppReceiver, AppSender, TuiTask};
 pub use pane::TerminalPane;
 pub use table::TaskTable;
+pub use task::TaskResult;
 
 #[derive(Debug, thiserror::Error)]
 pub enum Error {
@@ -12,4 +18,6 @@ pub enum Error {
     TaskNotFound { name: String },
     #[error("Unable to write to stdin for '{name}': {e}")]
     Stdin { name: String, e: std::io::Error },
+    #[error("Unable to draw terminal UI: {0}")]
+    Io(#[from] std::io::Error),
 }
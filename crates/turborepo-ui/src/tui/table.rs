@@ -11,7 +11,7 @@ use ratatui::{
 };
 
 use super::{
-    task::{Finished, Planned, Running, Task},
+    task::{Finished, Planned, Running, Task, TaskResult},
     task_duration::TaskDuration,
 };
 
@@ -20,7 +20,7 @@ const FOOTER_TEXT: &str = "Use arrow keys to navigate";
 /// A widget that renders a table of their tasks and their current status
 ///
 /// The table contains finished tasks, running tasks, and planned tasks rendered
-/// in that order.
+/// in that order. Finished tasks are colored by how they finished.
 pub struct TaskTable {
     // Start of the run and the current time
     start: Instant,
@@ -101,7 +101,7 @@ impl TaskTable {
 
     /// Mark the given running task as finished
     /// Errors if given task wasn't a running task
-    pub fn finish_task(&mut self, task: &str) -> Result<(), &'static str> {
+    pub fn finish_task(&mut self, task: &str, result: TaskResult) -> Result<(), &'static str> {
         let running_idx = self
             .running
             .iter()
@@ -110,7 +110,7 @@ impl TaskTable {
         let old_row_idx = self.finished.len() + running_idx;
         let new_row_idx = self.finished.len();
         let running = self.running.remove(running_idx);
-        self.finished.push(running.finish());
+        self.finished.push(running.finish(result));
 
         if let Some(selected_row) = self.scroll.selected() {
             // If task that was just started is selected, then update selection to follow
@@ -173,8 +173,13 @@ impl TaskTable {
 
     fn finished_rows(&self, duration_width: u16) -> impl Iterator<Item = Row> + '_ {
         self.finished.iter().map(move |task| {
+            let style = match task.result() {
+                TaskResult::Success => Style::default().fg(Color::Green),
+                TaskResult::Failure => Style::default().fg(Color::Red),
+                TaskResult::CacheHit => Style::default().fg(Color::Magenta),
+            };
             Row::new(vec![
-                Cell::new(task.name()),
+                Cell::new(task.name()).style(style),
                 Cell::new(TaskDuration::new(
                     duration_width,
                     self.start,
@@ -211,9 +216,9 @@ impl TaskTable {
     }
 
     /// Convenience method which renders and updates scroll state
-    pub fn stateful_render(&mut self, frame: &mut ratatui::Frame) {
+    pub fn stateful_render(&mut self, frame: &mut ratatui::Frame, area: Rect) {
         let mut scroll = self.scroll.clone();
-        frame.render_stateful_widget(&*self, frame.size(), &mut scroll);
+        frame.render_stateful_widget(&*self, area, &mut scroll);
         self.scroll = scroll;
     }
 
@@ -313,7 +318,7 @@ mod test {
         table.start_task("a").unwrap();
         assert_eq!(table.scroll.selected(), Some(0), "b stays selected");
         assert_eq!(table.selected(), Some("b"), "selected b");
-        table.finish_task("a").unwrap();
+        table.finish_task("a", TaskResult::Success).unwrap();
         assert_eq!(table.scroll.selected(), Some(1), "b stays selected");
         assert_eq!(table.selected(), Some("b"), "selected b");
     }
@@ -339,15 +344,36 @@ mod test {
         table.previous();
         assert_eq!(table.scroll.selected(), Some(0), "selected c");
         assert_eq!(table.selected(), Some("c"), "selected c");
-        table.finish_task("a").unwrap();
+        table.finish_task("a", TaskResult::Success).unwrap();
         assert_eq!(table.scroll.selected(), Some(1), "c stays selected");
         assert_eq!(table.selected(), Some("c"), "selected c");
         table.previous();
-        table.finish_task("c").unwrap();
+        table.finish_task("c", TaskResult::Success).unwrap();
         assert_eq!(table.scroll.selected(), Some(0), "a stays selected");
         assert_eq!(table.selected(), Some("a"), "selected a");
     }
 
+    #[test]
+    fn test_finished_tasks_colored_by_result() {
+        let mut table = TaskTable::new(vec!["a".to_string(), "b".to_string(), "c".to_string()]);
+        for task in ["a", "b", "c"] {
+            table.start_task(task).unwrap();
+        }
+        table.finish_task("a", TaskResult::Failure).unwrap();
+        table.finish_task("b", TaskResult::CacheHit).unwrap();
+        table.finish_task("c", TaskResult::Success).unwrap();
+        let area = Rect::new(0, 0, 14, 7);
+        let mut buffer = Buffer::empty(area);
+        let mut scroll = table.scroll.clone();
+        StatefulWidget::render(&table, area, &mut buffer, &mut scroll);
+        assert_eq!(buffer.get(0, 2).symbol(), "a");
+        assert_eq!(buffer.get(0, 2).fg, Color::Red);
+        assert_eq!(buffer.get(0, 3).symbol(), "b");
+        assert_eq!(buffer.get(0, 3).fg, Color::Magenta);
+        assert_eq!(buffer.get(0, 4).symbol(), "c");
+        assert_eq!(buffer.get(0, 4).fg, Color::Green);
+    }
+
     #[test]
     fn test_footer_always_rendered() {
         let table = TaskTable::new(vec!["a".to_string(), "b".to_string(), "c".to_string()]);
@@ -12,6 +12,15 @@ pub struct Running {
 pub struct Finished {
     start: Instant,
     end: Instant,
+    result: TaskResult,
+}
+
+/// How a task finished
+#[derive(Debug, PartialEq, Eq, PartialOrd, Ord, Clone, Copy)]
+pub enum TaskResult {
+    Success,
+    Failure,
+    CacheHit,
 }
 
 #[derive(Debug, PartialEq, Eq, PartialOrd, Ord, Clone)]
@@ -45,7 +54,7 @@ impl Task<Planned> {
 }
 
 impl Task<Running> {
-    pub fn finish(self) -> Task<Finished> {
+    pub fn finish(self, result: TaskResult) -> Task<Finished> {
         let Task {
             name,
             state: Running { start },
@@ -55,6 +64,7 @@ impl Task<Running> {
             state: Finished {
                 start,
                 end: Instant::now(),
+                result,
             },
         }
     }
@@ -72,4 +82,8 @@ impl Task<Finished> {
     pub fn end(&self) -> Instant {
         self.state.end
     }
+
+    pub fn result(&self) -> TaskResult {
+        self.state.result
+    }
 }
@@ -0,0 +1,104 @@
+use std::{fmt::Display, sync::Mutex};
+
+use tracing::warn;
+
+/// Collects warnings that can fire many times over the course of a run, e.g.
+/// once per task when the remote cache is unreachable. The first occurrence of
+/// each distinct message is reported as it happens, and how many times it
+/// repeated after that is reported on `flush`. When `verbose` is set every
+/// occurrence is reported as it happens instead.
+#[derive(Debug, Default)]
+pub struct Warnings {
+    verbose: bool,
+    // Distinct messages in the order they were first seen along with how many
+    // times they repeated since they were last reported
+    messages: Mutex<Vec<(String, usize)>>,
+}
+
+impl Warnings {
+    pub fn new(verbose: bool) -> Self {
+        Self {
+            verbose,
+            messages: Mutex::default(),
+        }
+    }
+
+    pub fn warn(&self, message: impl Display) {
+        let message = message.to_string();
+        if self.verbose || self.record(&message) {
+            warn!("{message}");
+        }
+    }
+
+    /// Reports how many times each warning repeated since it was last
+    /// reported. Warnings that repeat after a flush are counted again, so this
+    /// can be called whenever the run might stop, e.g. once it's interrupted.
+    pub fn flush(&self) {
+        for line in self.repeats() {
+            warn!("{line}");
+        }
+    }
+
+    // Returns whether this is the first occurrence of the message
+    fn record(&self, message: &str) -> bool {
+        let mut messages = self.messages.lock().expect("warnings mutex poisoned");
+        match messages.iter_mut().find(|(seen, _)| seen == message) {
+            Some((_, repeats)) => {
+                *repeats += 1;
+                false
+            }
+            None => {
+                messages.push((message.to_string(), 0));
+                true
+            }
+        }
+    }
+
+    fn repeats(&self) -> Vec<String> {
+        let mut messages = self.messages.lock().expect("warnings mutex poisoned");
+        messages
+            .iter_mut()
+            .filter(|(_, repeats)| *repeats > 0)
+            .map(|(message, repeats)| match std::mem::take(repeats) {
+                1 => format!("{message} (repeated 1 more time)"),
+                repeats => format!("{message} (repeated {repeats} more times)"),
+            })
+            .collect()
+    }
+}
+
+#[cfg(test)]
+mod test {
+    use super::*;
+
+    #[test]
+    fn test_collapses_repeats() {
+        let warnings = Warnings::new(false);
+        assert!(warnings.record("remote cache unavailable"));
+        assert!(warnings.record("no outputs found"));
+        assert!(!warnings.record("remote cache unavailable"));
+        assert!(!warnings.record("remote cache unavailable"));
+
+        assert_eq!(
+            warnings.repeats(),
+            vec!["remote cache unavailable (repeated 2 more times)"]
+        );
+        assert!(warnings.repeats().is_empty());
+
+        // A warning that was already reported isn't reported in full again
+        assert!(!warnings.record("remote cache unavailable"));
+        assert_eq!(
+            warnings.repeats(),
+            vec!["remote cache unavailable (repeated 1 more time)"]
+        );
+    }
+
+    #[test]
+    fn test_verbose_does_not_collect() {
+        let warnings = Warnings::new(true);
+        warnings.warn("remote cache unavailable");
+        warnings.warn("remote cache unavailable");
+
+        assert!(warnings.repeats().is_empty());
+    }
+}
@@ -148,16 +148,25 @@ pub enum CacheEvent {
 pub struct AnalyticsEvent {
     #[serde(skip_serializing_if = "Option::is_none")]
     pub session_id: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub run_id: Option<String>,
     pub source: CacheSource,
     pub event: CacheEvent,
     pub hash: String,
     pub duration: u64,
+    // The size of the artifact that was downloaded, for remote cache hits
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub bytes: Option<u64>,
 }
 
 impl AnalyticsEvent {
     pub fn set_session_id(&mut self, id: String) {
         self.session_id = Some(id);
     }
+
+    pub fn set_run_id(&mut self, id: String) {
+        self.run_id = Some(id);
+    }
 }
 
 #[cfg(test)]
@@ -169,33 +178,51 @@ mod tests {
     #[test_case(
       AnalyticsEvent {
         session_id: Some("session-id".to_string()),
+        run_id: None,
         source: CacheSource::Local,
         event: CacheEvent::Hit,
         hash: "this-is-my-hash".to_string(),
         duration: 58,
+        bytes: None,
       },
       "with-id-local-hit"
     )]
     #[test_case(
       AnalyticsEvent {
         session_id: Some("session-id".to_string()),
+        run_id: None,
         source: CacheSource::Remote,
         event: CacheEvent::Miss,
         hash: "this-is-my-hash-2".to_string(),
         duration: 21,
+        bytes: None,
       },
       "with-id-remote-miss"
     )]
     #[test_case(
       AnalyticsEvent {
         session_id: None,
+        run_id: None,
         source: CacheSource::Remote,
         event: CacheEvent::Miss,
         hash: "this-is-my-hash-2".to_string(),
         duration: 21,
+        bytes: None,
       },
       "without-id-remote-miss"
     )]
+    #[test_case(
+      AnalyticsEvent {
+        session_id: Some("session-id".to_string()),
+        run_id: None,
+        source: CacheSource::Remote,
+        event: CacheEvent::Hit,
+        hash: "this-is-my-hash-3".to_string(),
+        duration: 21,
+        bytes: Some(1024),
+      },
+      "with-id-remote-hit"
+    )]
     fn test_serialize_analytics_event(event: AnalyticsEvent, name: &str) {
         let json = serde_json::to_string(&event).unwrap();
         insta::assert_json_snapshot!(name, json);
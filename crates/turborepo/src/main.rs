@@ -16,8 +16,13 @@ fn main() -> Result<()> {
     std::panic::set_hook(Box::new(panic_handler));
 
     let exit_code = turborepo_lib::main().unwrap_or_else(|err| {
-        println!("{:?}", Report::new(err));
-        1
+        let exit_code = err.exit_code();
+        if turborepo_lib::is_json_output() {
+            eprintln!("{:?}", Report::new(err));
+        } else {
+            println!("{:?}", Report::new(err));
+        }
+        exit_code
     });
 
     process::exit(exit_code)
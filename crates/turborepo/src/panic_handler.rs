@@ -1,5 +1,18 @@
+use std::{
+    cmp::Reverse,
+    env, fs, io,
+    path::PathBuf,
+    time::{SystemTime, UNIX_EPOCH},
+};
+
+use clap::CommandFactory;
 use human_panic::report::{Method, Report};
-use turborepo_lib::get_version;
+use sha2::{Digest, Sha256};
+use turborepo_lib::{get_version, Args};
+
+// Values shorter than this are too likely to match unrelated parts of the
+// report, e.g. line numbers in the backtrace, to be replaced in it
+const MIN_REPLACED_LEN: usize = 3;
 
 pub fn panic_handler(panic_info: &std::panic::PanicInfo) {
     let cause = panic_info
@@ -14,13 +27,14 @@ pub fn panic_handler(panic_info: &std::panic::PanicInfo) {
 
     let report = Report::new("turbo", get_version(), Method::Panic, explanation, cause);
 
-    let report_message = match report.persist() {
-        Ok(f) => {
+    let report_message = match write_crash_report(&report) {
+        Ok(path) => {
             format!(
-                "A report has been written to {}\n
+                "A crash report has been written to {}\n
 Please open an issue at https://github.com/vercel/turbo/issues/new/choose \
-                 and include this file",
-                f.display()
+                 and include this file. Argument values and paths in the report have been hashed, \
+                 but please check it for anything you don't want to share.",
+                path.display()
             )
         }
         Err(e) => {
@@ -41,3 +55,246 @@ Please open an issue at \
         report_message
     );
 }
+
+// Writes the report to `.turbo/crash-<timestamp>.log` in the repository, or to
+// the temp directory if turbo crashed before the repository was found
+fn write_crash_report(report: &Report) -> io::Result<PathBuf> {
+    let contents = report
+        .serialize()
+        .ok_or_else(|| io::Error::new(io::ErrorKind::Other, "unable to serialize report"))?;
+    let args = env::args().skip(1).collect::<Vec<_>>();
+    let (arguments, values) = redact_args(&args);
+    // The panic message and the backtrace can contain the same values
+    let contents = redact_text(&contents, &replacements(&values));
+
+    let timestamp = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map(|duration| duration.as_secs())
+        .unwrap_or_default();
+    let path = match turborepo_lib::repo_root() {
+        Some(repo_root) => {
+            let dir = repo_root.as_std_path().join(".turbo");
+            fs::create_dir_all(&dir)?;
+            dir.join(format!("crash-{timestamp}.log"))
+        }
+        None => env::temp_dir().join(format!("turbo-crash-{timestamp}.log")),
+    };
+    fs::write(&path, format!("{contents}arguments = {arguments:?}\n"))?;
+
+    Ok(path)
+}
+
+// Flags and subcommand names are kept so the report shows how turbo was
+// invoked, but any values are hashed since they can contain tokens, paths, or
+// package names. Everything after `--` is passed to tasks, so it's all hashed.
+// Returns the redacted arguments along with the values that were hashed.
+fn redact_args(args: &[String]) -> (Vec<String>, Vec<&str>) {
+    let root = Args::command();
+    let mut command = root.clone();
+    let mut passed_through = false;
+    let mut redacted = Vec::with_capacity(args.len());
+    let mut values = Vec::new();
+    for arg in args {
+        if passed_through {
+            redacted.push(redact(arg));
+            values.push(arg.as_str());
+            continue;
+        }
+        match arg.split_once('=') {
+            _ if arg == "--" => {
+                passed_through = true;
+                redacted.push(arg.clone());
+            }
+            Some((flag, value)) if flag.starts_with('-') => {
+                redacted.push(format!("{flag}={}", redact(value)));
+                values.push(value);
+            }
+            _ if arg.starts_with("--") => redacted.push(arg.clone()),
+            // A short flag can have its value attached, e.g. `-Fweb`
+            _ if arg.starts_with('-') => {
+                let mut chars = arg[1..].chars();
+                match chars.next() {
+                    Some(short)
+                        if !chars.as_str().is_empty() && takes_value(&root, &command, short) =>
+                    {
+                        let value = chars.as_str();
+                        redacted.push(format!("-{short}{}", redact(value)));
+                        values.push(value);
+                    }
+                    _ => redacted.push(arg.clone()),
+                }
+            }
+            _ => match command.find_subcommand(arg).cloned() {
+                Some(subcommand) => {
+                    command = subcommand;
+                    redacted.push(arg.clone());
+                }
+                None => {
+                    redacted.push(redact(arg));
+                    values.push(arg.as_str());
+                }
+            },
+        }
+    }
+
+    (redacted, values)
+}
+
+// Whether the short flag takes a value, either in `command` or as a global
+// flag. Unknown flags are assumed to take one, so that it's redacted.
+fn takes_value(root: &clap::Command, command: &clap::Command, short: char) -> bool {
+    command
+        .get_arguments()
+        .chain(root.get_arguments())
+        .find(|arg| arg.get_short() == Some(short))
+        .map_or(true, |arg| arg.get_action().takes_values())
+}
+
+// The values to replace in the report, longest first so that a value that
+// contains another one is replaced as a whole
+fn replacements(values: &[&str]) -> Vec<(String, String)> {
+    let mut replacements = values
+        .iter()
+        .map(|value| (value.to_string(), redact(value)))
+        .collect::<Vec<_>>();
+    if let Some(repo_root) = turborepo_lib::repo_root() {
+        replacements.push((repo_root.to_string(), "<repo root>".to_string()));
+    }
+    if let Ok(cwd) = env::current_dir() {
+        replacements.push((cwd.display().to_string(), "<cwd>".to_string()));
+    }
+    if let Some(home) = env::var_os("HOME").or_else(|| env::var_os("USERPROFILE")) {
+        replacements.push((home.to_string_lossy().to_string(), "<home>".to_string()));
+    }
+    replacements.retain(|(value, _)| value.len() >= MIN_REPLACED_LEN);
+    replacements.sort_by_key(|(value, _)| Reverse(value.len()));
+    replacements
+}
+
+// Replaces the values in a single pass, so that a replacement is never
+// matched by a later value
+fn redact_text(text: &str, replacements: &[(String, String)]) -> String {
+    let mut redacted = String::with_capacity(text.len());
+    let mut rest = text;
+    'text: while let Some(c) = rest.chars().next() {
+        for (value, replacement) in replacements {
+            if let Some(after) = rest.strip_prefix(value.as_str()) {
+                redacted.push_str(replacement);
+                rest = after;
+                continue 'text;
+            }
+        }
+        redacted.push(c);
+        rest = &rest[c.len_utf8()..];
+    }
+
+    redacted
+}
+
+// SHA-256 rather than the std hasher, whose output can change between Rust
+// releases, so the same value hashes the same in every report
+fn redact(value: &str) -> String {
+    let digest = Sha256::digest(value.as_bytes());
+    format!("<{}>", hex::encode(&digest[..8]))
+}
+
+#[cfg(test)]
+mod test {
+    use super::*;
+
+    fn strings(args: &[&str]) -> Vec<String> {
+        args.iter().map(|arg| arg.to_string()).collect()
+    }
+
+    #[test]
+    fn test_redact_args() {
+        let args = strings(&["run", "build", "--token=secret", "-v", "--filter", "web"]);
+
+        let (redacted, values) = redact_args(&args);
+
+        assert_eq!(redacted[0], "run");
+        assert_eq!(redacted[1], redact("build"));
+        assert_eq!(redacted[2], format!("--token={}", redact("secret")));
+        assert_eq!(redacted[3], "-v");
+        assert_eq!(redacted[4], "--filter");
+        assert_eq!(values, vec!["build", "secret", "web"]);
+        assert!(redacted.iter().all(|arg| !arg.contains("secret")));
+        assert!(redacted.iter().all(|arg| !arg.contains("web")));
+    }
+
+    #[test]
+    fn test_redact_args_short_flags() {
+        let args = strings(&["run", "build", "-Fweb", "-vv", "-F=docs"]);
+
+        let (redacted, values) = redact_args(&args);
+
+        assert_eq!(
+            redacted,
+            vec![
+                "run".to_string(),
+                redact("build"),
+                format!("-F{}", redact("web")),
+                "-vv".to_string(),
+                format!("-F={}", redact("docs")),
+            ]
+        );
+        assert_eq!(values, vec!["build", "web", "docs"]);
+    }
+
+    #[test]
+    fn test_redact_args_subcommands() {
+        let args = strings(&["cache", "stats", "stats", "--json"]);
+        let (redacted, _) = redact_args(&args);
+        // A subcommand name is only kept where a subcommand can be
+        assert_eq!(
+            redacted,
+            vec![
+                "cache".to_string(),
+                "stats".to_string(),
+                redact("stats"),
+                "--json".to_string()
+            ]
+        );
+
+        let args = strings(&["run", "test", "--", "--reporter", "run"]);
+        let (redacted, _) = redact_args(&args);
+        assert_eq!(
+            redacted,
+            vec![
+                "run".to_string(),
+                redact("test"),
+                "--".to_string(),
+                redact("--reporter"),
+                redact("run")
+            ]
+        );
+    }
+
+    #[test]
+    fn test_redact_is_stable() {
+        // The first 8 bytes of the SHA-256 digest of "secret"
+        assert_eq!(redact("secret"), "<2bb80d537b1da3e3>");
+    }
+
+    #[test]
+    fn test_redact_text() {
+        let replacements = vec![
+            ("/home/me/repo".to_string(), "<repo root>".to_string()),
+            ("/home/me".to_string(), "<home>".to_string()),
+            ("web".to_string(), redact("web")),
+        ];
+        let text = "cause = \"no package web in /home/me/repo/apps\"\nbacktrace = \
+                    \"/home/me/.cargo/registry/src/lib.rs:10\"";
+
+        let redacted = redact_text(text, &replacements);
+
+        assert_eq!(
+            redacted,
+            format!(
+                "cause = \"no package {} in <repo root>/apps\"\nbacktrace = \
+                 \"<home>/.cargo/registry/src/lib.rs:10\"",
+                redact("web")
+            )
+        );
+    }
+}
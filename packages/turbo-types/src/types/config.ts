@@ -41,6 +41,19 @@ export interface WorkspaceSchema extends BaseSchema {
 }
 
 export interface RootSchema extends BaseSchema {
+  /**
+   * Presets to inherit configuration from. Each preset is either a path to a
+   * JSON file relative to the repository root, or the name of a package in the
+   * root node_modules that has a turbo.json.
+   *
+   * Presets are applied in order, and this file overrides all of them.
+   *
+   * Documentation: https://turbo.build/repo/docs/reference/configuration#extends
+   *
+   * @defaultValue []
+   */
+  extends?: Array<string>;
+
   /**
    * A list of globs to include in the set of implicit global hash dependencies.
    *
@@ -111,6 +124,17 @@ export interface RootSchema extends BaseSchema {
    * @defaultValue `{}`
    */
   remoteCache?: RemoteCache;
+
+  /**
+   * Commands that are run at points in a run's lifecycle. Each command is an
+   * executable, or a JavaScript file that is run with `node`, and receives a
+   * JSON description of the event on stdin.
+   *
+   * Documentation: https://turbo.build/repo/docs/reference/configuration#hooks
+   *
+   * @defaultValue `{}`
+   */
+  hooks?: Hooks;
 }
 
 export interface Pipeline {
@@ -183,8 +207,8 @@ export interface Pipeline {
    * The set of glob patterns indicating a task's cacheable filesystem outputs.
    *
    * Turborepo captures task logs for all tasks. This enables us to cache tasks whose runs
-   * produce no artifacts other than logs (such as linters). Logs are always treated as a
-   * cacheable artifact and never need to be specified.
+   * produce no artifacts other than logs (such as linters). Logs are treated as a
+   * cacheable artifact unless `cacheLogs` is false and never need to be specified.
    *
    * Documentation: https://turbo.build/repo/docs/reference/configuration#outputs
    *
@@ -203,6 +227,32 @@ export interface Pipeline {
    */
   cache?: boolean;
 
+  /**
+   * Whether or not to cache the task's log file alongside its outputs.
+   *
+   * When false, only the task's outputs are cached and logs are not replayed
+   * on a cache hit.
+   *
+   * Documentation: https://turbo.build/repo/docs/reference/configuration#cachelogs
+   *
+   * @defaultValue true
+   */
+  cacheLogs?: boolean;
+
+  /**
+   * Whether or not to run the task on every invocation, even if its inputs
+   * haven't changed.
+   *
+   * Tasks that always run still respect `dependsOn` ordering and have their
+   * logs captured, but nothing is read from or written to the cache. This is
+   * useful for tasks with side effects such as `deploy`.
+   *
+   * Documentation: https://turbo.build/repo/docs/reference/configuration#alwaysrun
+   *
+   * @defaultValue false
+   */
+  alwaysRun?: boolean;
+
   /**
    * The set of glob patterns to consider as inputs to this task.
    *
@@ -220,6 +270,32 @@ export interface Pipeline {
    */
   inputs?: Array<string>;
 
+  /**
+   * A list of globs, relative to the root of the repository, of files that
+   * this task depends on in every package.
+   *
+   * Unlike globalDependencies, changes to these files only cause a cache miss
+   * for the tasks that list them.
+   *
+   * Documentation: https://turbo.build/repo/docs/reference/configuration#rootinputs
+   *
+   * @defaultValue []
+   */
+  rootInputs?: Array<string>;
+
+  /**
+   * A list of tasks in the same package whose outputs are inputs of this
+   * task, e.g. the files generated by a codegen task.
+   *
+   * The listed tasks are dependencies of this task, and the files matching
+   * their outputs are hashed once they have run.
+   *
+   * Documentation: https://turbo.build/repo/docs/reference/configuration#inputsfrom
+   *
+   * @defaultValue []
+   */
+  inputsFrom?: Array<string>;
+
   /**
    * Output mode for the task.
    *
@@ -249,6 +325,20 @@ export interface Pipeline {
    * @defaultValue false
    */
   persistent?: boolean;
+
+  /**
+   * How eagerly the task is started once it's ready to run, when more tasks
+   * are ready than `--concurrency` allows. Ready tasks with a higher priority
+   * are started first.
+   *
+   * Either `"high"`, `"normal"` or `"low"`, or a numeric weight. The named
+   * levels are equivalent to the weights 1, 0 and -1.
+   *
+   * Documentation: https://turbo.build/repo/docs/reference/configuration#priority
+   *
+   * @defaultValue normal
+   */
+  priority?: "high" | "normal" | "low" | number;
 }
 
 export interface RemoteCache {
@@ -271,6 +361,111 @@ export interface RemoteCache {
    * @defaultValue true
    */
   enabled?: boolean;
+
+  /**
+   * When enabled, every remote cache request is preceded by an OPTIONS request
+   * for authorization. Useful when the remote cache sits behind a gateway that
+   * requires CORS-style preflight requests.
+   *
+   * @defaultValue false
+   */
+  preflight?: boolean;
+
+  /**
+   * How many times a request that fails with a transient error, such as a
+   * dropped connection or a 5xx response, is retried.
+   *
+   * @defaultValue 2
+   */
+  retries?: number;
+
+  /**
+   * Artifacts larger than this many megabytes are uploaded in chunks of this
+   * size, several at a time. Set to 0 to upload every artifact in a single
+   * request.
+   *
+   * @defaultValue 0
+   */
+  uploadChunkSize?: number;
+
+  /**
+   * Use any artifact store that speaks HTTP as the remote cache instead of
+   * the Vercel Remote Cache API.
+   * Documentation: https://turbo.build/repo/docs/core-concepts/remote-caching#custom-providers
+   */
+  provider?: RemoteCacheProvider;
+
+  /**
+   * Remote caches to read, in order, when the primary remote cache misses.
+   * Use "vercel" for the Vercel Remote Cache of the linked team.
+   * Documentation: https://turbo.build/repo/docs/core-concepts/remote-caching#multiple-remote-caches
+   *
+   * @defaultValue []
+   */
+  fallbacks?: Array<"vercel" | RemoteCacheProvider>;
+
+  /**
+   * Which remote caches artifacts are uploaded to. Use "write-first" to only
+   * upload to the first remote cache, or "write-all" to upload to every one.
+   *
+   * @defaultValue "write-first"
+   */
+  writePolicy?: "write-first" | "write-all";
+}
+
+export interface RemoteCacheProvider {
+  /**
+   * The URL that artifact paths are appended to. Use a `grpc://` or `grpcs://`
+   * URL for a cache that implements the Bazel Remote Execution API, with the
+   * instance name as the path.
+   */
+  baseUrl: string;
+
+  /**
+   * A header sent with every request in the form of `<name>: <value>`.
+   * `{token}` is replaced with the token turbo is configured with.
+   *
+   * @example "Authorization: Bearer {token}"
+   */
+  authHeader?: string;
+
+  /**
+   * The path artifacts are downloaded from. Must include `{hash}`.
+   *
+   * @defaultValue "/{hash}"
+   */
+  getPath?: string;
+
+  /**
+   * The path artifacts are uploaded to. Must include `{hash}`.
+   *
+   * @defaultValue "/{hash}"
+   */
+  putPath?: string;
+}
+
+export interface Hooks {
+  /**
+   * Run before any tasks are executed. A hook that exits unsuccessfully stops
+   * the run.
+   *
+   * @defaultValue []
+   */
+  preRun?: Array<string>;
+
+  /**
+   * Run each time a task finishes.
+   *
+   * @defaultValue []
+   */
+  postTask?: Array<string>;
+
+  /**
+   * Run once all tasks have finished.
+   *
+   * @defaultValue []
+   */
+  postRun?: Array<string>;
 }
 
 export type OutputMode =
@@ -19,7 +19,7 @@ Bad flag with an implied run command should display run flags
   
     tip: to pass '--bad-flag' as a value, use '-- --bad-flag'
   
-  Usage: turbo(\.exe)? <--cache-dir <CACHE_DIR>|--cache-workers <CACHE_WORKERS>|--concurrency <CONCURRENCY>|--continue|--dry-run [<DRY_RUN>]|--single-package|--filter <FILTER>|--force [<FORCE>]|--framework-inference [<BOOL>]|--global-deps <GLOBAL_DEPS>|--graph [<GRAPH>]|--env-mode [<ENV_MODE>]|--ignore <IGNORE>|--include-dependencies|--no-cache|--no-daemon|--no-deps|--output-logs <OUTPUT_LOGS>|--log-order <LOG_ORDER>|--only|--parallel|--pkg-inference-root <PKG_INFERENCE_ROOT>|--profile <PROFILE>|--remote-only [<BOOL>]|--scope <SCOPE>|--since <SINCE>|--summarize [<SUMMARIZE>]|--log-prefix <LOG_PREFIX>|TASKS|PASS_THROUGH_ARGS|--experimental-space-id <EXPERIMENTAL_SPACE_ID>> (re)
+  Usage: turbo(\.exe)? <--cache-dir <CACHE_DIR>|--cache-workers <CACHE_WORKERS>|--cache-queue-size <CACHE_QUEUE_SIZE>|--cache-drain-timeout <SECONDS>|--cache-namespace <CACHE_NAMESPACE>|--cache-max-age <DAYS>|--cache-restore-mode <CACHE_RESTORE_MODE>|--output-symlinks <OUTPUT_SYMLINKS>|--concurrency <CONCURRENCY>|--scheduler-control|--concurrent-runs <CONCURRENT_RUNS>|--continue[=<CONTINUE>]|--dry-run [<DRY_RUN>]|--hash-only|--single-package|--filter <FILTER>|--force [<FORCE>]|--framework-inference [<BOOL>]|--global-deps <GLOBAL_DEPS>|--graph [<GRAPH>]|--graph-mode <GRAPH_MODE>|--env-mode [<ENV_MODE>]|--exclude <EXCLUDE>|--ignore <IGNORE>|--affected-granularity <AFFECTED_GRANULARITY>|--include-dependencies [<BOOL>]|--no-cache|--cache-failures|--restore-declared-outputs|--no-daemon|--no-deps|--include-dependents [<BOOL>]|--no-package-inference|--output-logs <OUTPUT_LOGS>|--log-order <LOG_ORDER>|--ui <UI>|--only|--shard <INDEX/TOTAL>|--parallel|--pkg-inference-root <PKG_INFERENCE_ROOT>|--profile <PROFILE>|--remote-only [<BOOL>]|--no-remote [<BOOL>]|--remote-cache-concurrency <REMOTE_CACHE_CONCURRENCY>|--remote-cache-read-concurrency <REMOTE_CACHE_READ_CONCURRENCY>|--remote-cache-write-concurrency <REMOTE_CACHE_WRITE_CONCURRENCY>|--remote-cache-max-artifact-size <BYTES>|--cache-write-only [<BOOL>]|--scope <SCOPE>|--since [<SINCE>]|--summarize [<SUMMARIZE>]|--strict-engines|--warn-undeclared-outputs|--log-prefix <LOG_PREFIX>|--log-timestamps <LOG_TIMESTAMPS>|TASKS|PASS_THROUGH_ARGS|--experimental-space-id <EXPERIMENTAL_SPACE_ID>> (re)
   
   For more information, try '--help'.
   
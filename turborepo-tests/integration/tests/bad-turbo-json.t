@@ -21,7 +21,7 @@ Run build with package task in non-root turbo.json
    12 |       }
       `----
   
-  [1]
+  [78]
 
 
 
@@ -45,7 +45,7 @@ Run build with invalid env var
    8 |       "outputs": []
      `----
   
-  [1]
+  [78]
 
 
 
@@ -63,7 +63,7 @@ Run in single package mode even though we have a task with package syntax
    18 | 
       `----
   
-  [1]
+  [78]
 
 
 
@@ -104,4 +104,4 @@ Run build with syntax errors in turbo.json
    15 | 
       `----
   
-  [1]
+  [78]
@@ -60,5 +60,5 @@ Run build with invalid env var
    8 |       "outputs": []
      `----
   
-  [1]
+  [78]
 
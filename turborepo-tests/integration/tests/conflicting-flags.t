@@ -39,7 +39,7 @@ Setup
    ERROR  the following required arguments were not provided:
     --scope <SCOPE>
   
-  Usage: turbo(\.exe)? run --scope <SCOPE> --include-dependencies (re)
+  Usage: turbo(\.exe)? run --scope <SCOPE> --include-dependencies \[<BOOL>\] (re)
   
   For more information, try '--help'.
   
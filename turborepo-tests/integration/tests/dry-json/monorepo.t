@@ -10,6 +10,7 @@ Setup
 
   $ cat tmpjson.log | jq .globalCacheInputs
   {
+    "hash": "[0-9a-f]{16}", (re)
     "rootKey": "HEY STELLLLLLLAAAAAAAAAAAAA",
     "files": {
       "foo.txt": "eebae5f3ca7b5831e429e947b7d61edd0de69236"
@@ -45,7 +46,7 @@ Setup
   ]
 
 # Validate output of my-app#build task
-  $ cat tmpjson.log | jq '.tasks | map(select(.taskId == "my-app#build")) | .[0]'
+  $ cat tmpjson.log | jq '.tasks | map(select(.taskId == "my-app#build")) | .[0] | del(.strictEnvironmentVariables)'
   {
     "taskId": "my-app#build",
     "task": "build",
@@ -79,8 +80,12 @@ Setup
         "banana.txt"
       ],
       "cache": true,
+      "cacheLogs": true,
+      "alwaysRun": false,
       "dependsOn": [],
       "inputs": [],
+      "rootInputs": [],
+      "inputsFrom": [],
       "outputMode": "full",
       "persistent": false,
       "env": [],
@@ -107,7 +112,7 @@ Setup
   }
 
 # Validate output of util#build task
-  $ cat tmpjson.log | jq '.tasks | map(select(.taskId == "util#build")) | .[0]'
+  $ cat tmpjson.log | jq '.tasks | map(select(.taskId == "util#build")) | .[0] | del(.strictEnvironmentVariables)'
   {
     "taskId": "util#build",
     "task": "build",
@@ -134,8 +139,12 @@ Setup
     "resolvedTaskDefinition": {
       "outputs": [],
       "cache": true,
+      "cacheLogs": true,
+      "alwaysRun": false,
       "dependsOn": [],
       "inputs": [],
+      "rootInputs": [],
+      "inputsFrom": [],
       "outputMode": "full",
       "persistent": false,
       "env": [
@@ -183,4 +192,4 @@ Tasks that don't exist throw an error
   
   Error:   x could not find task `doesnotexist` in project
   
-  [1]
+  [78]
@@ -3,13 +3,14 @@ Setup
   $ rm turbo.json
   $ git commit -am "Delete turbo config" --quiet
 
-  $ ${TURBO} run build --dry=json
+  $ ${TURBO} run build --dry=json | jq 'del(.tasks[].strictEnvironmentVariables)'
   {
     "id": "[a-zA-Z0-9]+", (re)
     "version": "1",
     "turboVersion": "[a-z0-9\.-]+", (re)
     "monorepo": false,
     "globalCacheInputs": {
+      "hash": "[0-9a-f]{16}", (re)
       "rootKey": "HEY STELLLLLLLAAAAAAAAAAAAA",
       "files": {
         "package-lock.json": "1c117cce37347befafe3a9cba1b8a609b3600021",
@@ -57,8 +58,12 @@ Setup
         "resolvedTaskDefinition": {
           "outputs": [],
           "cache": false,
+          "cacheLogs": true,
+          "alwaysRun": false,
           "dependsOn": [],
           "inputs": [],
+          "rootInputs": [],
+          "inputsFrom": [],
           "outputMode": "full",
           "persistent": false,
           "env": [],
@@ -87,4 +92,3 @@ Setup
       "branch": ".+" (re)
     }
   }
-  
@@ -1,13 +1,14 @@
 Setup
   $ . ${TESTDIR}/../../../helpers/setup_integration_test.sh single_package
 
-  $ ${TURBO} run build --dry=json
+  $ ${TURBO} run build --dry=json | jq 'del(.tasks[].strictEnvironmentVariables)'
   {
     "id": "[a-zA-Z0-9]+", (re)
     "version": "1",
     "turboVersion": "[a-z0-9\.-]+", (re)
     "monorepo": false,
     "globalCacheInputs": {
+      "hash": "[0-9a-f]{16}", (re)
       "rootKey": "HEY STELLLLLLLAAAAAAAAAAAAA",
       "files": {
         "package-lock.json": "1c117cce37347befafe3a9cba1b8a609b3600021",
@@ -61,8 +62,12 @@ Setup
             "foo.txt"
           ],
           "cache": true,
+          "cacheLogs": true,
+          "alwaysRun": false,
           "dependsOn": [],
           "inputs": [],
+          "rootInputs": [],
+          "inputsFrom": [],
           "outputMode": "full",
           "persistent": false,
           "env": [],
@@ -91,4 +96,3 @@ Setup
       "branch": ".+" (re)
     }
   }
-  
@@ -72,3 +72,10 @@ Setup
 # Run the task with NODE_ENV set and see it in summary. Use util package so it's just one package
   $ NODE_ENV=banana ${TURBO} run build --dry --filter=util | grep "Environment Variables"
   [1]
+
+# Dry run as a table prints one row per task
+  $ ${TURBO} run build --dry=table
+  Package\s+Task\s+Hash\s+Cache\s+Dependencies\s* (re)
+  another\s+build\s+[0-9a-f]{8}\s+MISS\s+0\s* (re)
+  my-app\s+build\s+[0-9a-f]{8}\s+MISS\s+0\s* (re)
+  util\s+build\s+[0-9a-f]{8}\s+MISS\s+0\s* (re)
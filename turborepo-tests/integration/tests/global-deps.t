@@ -24,3 +24,37 @@ Run a build
   Cached:    0 cached, 1 total
     Time:\s*[\.0-9]+m?s  (re)
   
+
+Files matched by --global-deps are hashed along with globalDependencies
+  $ echo "extra" > extra.txt
+  $ ${TURBO} build -F my-app --output-logs=hash-only --global-deps=extra.txt
+  \xe2\x80\xa2 Packages in scope: my-app (esc)
+  \xe2\x80\xa2 Running build in 1 packages (esc)
+  \xe2\x80\xa2 Remote caching disabled (esc)
+  my-app:build: cache miss, executing [0-9a-f]+ (re)
+  
+   Tasks:    1 successful, 1 total
+  Cached:    0 cached, 1 total
+    Time:\s*[\.0-9]+m?s  (re)
+  
+  $ ${TURBO} build -F my-app --output-logs=hash-only --global-deps=extra.txt
+  \xe2\x80\xa2 Packages in scope: my-app (esc)
+  \xe2\x80\xa2 Running build in 1 packages (esc)
+  \xe2\x80\xa2 Remote caching disabled (esc)
+  my-app:build: cache hit, suppressing logs [0-9a-f]+ (re)
+  
+   Tasks:    1 successful, 1 total
+  Cached:    1 cached, 1 total
+    Time:\s*[\.0-9]+m?s >>> FULL TURBO (re)
+  
+  $ echo "changed" > extra.txt
+  $ ${TURBO} build -F my-app --output-logs=hash-only --global-deps=extra.txt
+  \xe2\x80\xa2 Packages in scope: my-app (esc)
+  \xe2\x80\xa2 Running build in 1 packages (esc)
+  \xe2\x80\xa2 Remote caching disabled (esc)
+  my-app:build: cache miss, executing [0-9a-f]+ (re)
+  
+   Tasks:    1 successful, 1 total
+  Cached:    0 cached, 1 total
+    Time:\s*[\.0-9]+m?s  (re)
+  
@@ -0,0 +1,46 @@
+Setup
+  $ . ${TESTDIR}/../../helpers/setup_integration_test.sh
+
+Without --diff the working tree is compared against HEAD
+  $ ${TURBO} graph build
+  \xe2\x80\xa2 Comparing task graph against HEAD (esc)
+  \xe2\x80\xa2 No tasks changed (esc)
+
+Commit a change to util, which only changes the hash of its own task
+  $ echo "export const util = 1;" > packages/util/index.js
+  $ git add . && git commit -m "add util source" --quiet
+  $ ${TURBO} graph --diff HEAD^ build
+  \xe2\x80\xa2 Comparing task graph against HEAD^ (esc)
+  
+  ~ util#build \([0-9a-f]{8} -> [0-9a-f]{8}\) (re)
+  
+  0 added, 0 removed, 1 changed
+  $ ${TURBO} graph --diff HEAD^ build --filter=my-app
+  \xe2\x80\xa2 Comparing task graph against HEAD^ (esc)
+  \xe2\x80\xa2 No tasks changed (esc)
+
+A change to a global dependency changes every task
+  $ echo "global change" >> foo.txt
+  $ ${TURBO} graph build --filter=util --filter=my-app
+  \xe2\x80\xa2 Comparing task graph against HEAD (esc)
+  \xe2\x80\xa2 Global hash changed: [0-9a-f]{8} -> [0-9a-f]{8} (re)
+  
+  ~ my-app#build \([0-9a-f]{8} -> [0-9a-f]{8}\) (re)
+  ~ util#build \([0-9a-f]{8} -> [0-9a-f]{8}\) (re)
+  
+  0 added, 0 removed, 2 changed
+  $ git checkout foo.txt --quiet
+
+The worktree of the ref is removed afterwards
+  $ git worktree list | wc -l | tr -d ' '
+  1
+
+An unknown ref fails to check out
+  $ ${TURBO} graph --diff does-not-exist build 2>&1 | grep -o "failed to check out does-not-exist"
+  failed to check out does-not-exist
+
+A ref that looks like an option isn't passed to git as one
+  $ ${TURBO} graph --diff=--orphan build 2>&1 | grep -o "failed to check out --orphan"
+  failed to check out --orphan
+  $ git worktree list | wc -l | tr -d ' '
+  1
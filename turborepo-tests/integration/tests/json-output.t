@@ -0,0 +1,30 @@
+Setup
+  $ . ${TESTDIR}/../../../helpers/setup_integration_test.sh
+
+With --json stdout only has the JSON document, whichever side of the subcommand it's on
+  $ ${TURBO} --json cache stats 2>/dev/null | jq '.runs'
+  0
+  $ ${TURBO} cache stats --json 2>/dev/null | jq -c 'keys | map(select(. == "byTask" or . == "runs"))'
+  ["byTask","runs"]
+
+A failing run still writes its summary to stdout, and the task logs to stderr
+  $ ${TURBO} run maybefails --filter=my-app --json > out.json 2> err.log
+  [1]
+  $ jq '.execution.exitCode' out.json
+  1
+  $ jq -r '.tasks[].taskId' out.json
+  my-app#maybefails
+  $ grep -c "my-app:maybefails" err.log > /dev/null
+
+Errors are written to stderr, leaving stdout empty
+  $ ${TURBO} run doesnotexist --json > out.json 2> err.log
+  [1]
+  $ cat out.json
+  $ test -s err.log
+
+Commands without JSON output refuse the flag
+  $ ${TURBO} --json link > out.json 2> err.log
+  [1]
+  $ cat out.json
+  $ grep -o "turbo link. doesn't support --json" err.log
+  turbo link` doesn't support --json
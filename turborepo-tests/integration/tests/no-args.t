@@ -8,17 +8,22 @@ Make sure exit code is 2 when no args are passed
   Usage: turbo(\.exe)? \[OPTIONS\] \[COMMAND\] (re)
   
   Commands:
-    bin         Get the path to the Turbo binary
-    completion  Generate the autocompletion script for the specified shell
-    daemon      Runs the Turborepo background daemon
-    generate    Generate a new app / package
-    telemetry   Enable or disable anonymous telemetry
-    link        Link your local directory to a Vercel organization and enable remote caching
-    login       Login to your Vercel account
-    logout      Logout to your Vercel account
-    prune       Prepare a subset of your monorepo
-    run         Run tasks across projects in your monorepo
-    unlink      Unlink the current directory from your Vercel organization and disable Remote Caching
+    bin              Get the path to the Turbo binary
+    cache            Manage the local or remote cache
+    cache-server     Serve the Remote Caching API from a local cache directory
+    completion       Generate the autocompletion script for the specified shell
+    daemon           Runs the Turborepo background daemon
+    generate         Generate a new app / package
+    graph            Compare the task graph and hashes against a git ref
+    telemetry        Enable or disable anonymous telemetry
+    link             Link your local directory to a Vercel organization and enable remote caching
+    lint-workspaces  Check workspaces for undeclared dependencies on each other, mismatched versions of external dependencies and pipeline tasks without scripts
+    login            Login to your Vercel account
+    logout           Logout to your Vercel account
+    prefetch         Download the remote cache artifacts of tasks into the local cache without running them
+    prune            Prepare a subset of your monorepo
+    run              Run tasks across projects in your monorepo
+    unlink           Unlink the current directory from your Vercel organization and disable Remote Caching
   
   Options:
         --version                         
@@ -29,6 +34,7 @@ Make sure exit code is 2 when no args are passed
         --cpuprofile <CPU_PROFILE>        Specify a file to save a cpu profile
         --cwd <CWD>                       The directory in which to run turbo
         --heap <HEAP>                     Specify a file to save a pprof heap profile
+        --json                            Output a single JSON document on stdout and write everything else, including task logs, to stderr
         --login <LOGIN>                   Override the login endpoint
         --no-color                        Suppress color usage in the terminal
         --preflight                       When enabled, turbo will precede HTTP requests with an OPTIONS request for authorization
@@ -44,46 +50,82 @@ Make sure exit code is 2 when no args are passed
             Override the filesystem cache directory
         --cache-workers <CACHE_WORKERS>
             Set the number of concurrent cache operations (default 10) [default: 10]
+        --cache-queue-size <CACHE_QUEUE_SIZE>
+            Set how many cache writes can wait for a cache worker before tasks wait for room in the queue (default 10) [default: 10]
+        --cache-drain-timeout <SECONDS>
+            Stop waiting for cache writes this many seconds after the tasks finish. Writes that haven't finished are resumed by the next run [env: TURBO_CACHE_DRAIN_TIMEOUT=]
+        --cache-namespace <CACHE_NAMESPACE>
+            Only share cached artifacts with runs in the same namespace, e.g. a branch name or "staging". Artifacts are stored with the namespace as a prefix so they can be purged independently [env: TURBO_CACHE_NAMESPACE=]
+        --cache-max-age <DAYS>
+            Remove artifacts from the local cache that haven't been used in this many days once the run finishes [env: TURBO_CACHE_MAX_AGE=]
+        --cache-restore-mode <CACHE_RESTORE_MODE>
+            Set how files are restored from the local cache. Use "hardlink" to link them to the cache, which is fastest but means editing a restored output also edits the cached artifact. Use "copy" to copy them. Use "reflink" for copy-on-write clones, which fall back to copies on file systems that don't support them. (default hardlink) [env: TURBO_CACHE_RESTORE_MODE=] [default: hardlink] [possible values: hardlink, copy, reflink]
+        --output-symlinks <OUTPUT_SYMLINKS>
+            Set how symlinks in task outputs are cached. Use "preserve" to store the links as they are. Use "dereference" to store the files they point to, e.g. for outputs linked into the pnpm virtual store. (default preserve) [env: TURBO_OUTPUT_SYMLINKS=] [default: preserve] [possible values: preserve, dereference]
         --concurrency <CONCURRENCY>
-            Limit the concurrency of task execution. Use 1 for serial (i.e. one-at-a-time) execution
-        --continue
-            Continue execution even if a task exits with an error or non-zero exit code. The default behavior is to bail
+            Limit the concurrency of task execution. Use 1 for serial (i.e. one-at-a-time) execution. Per-task limits can be added as a comma separated list (e.g. `10,build=4,test=16`)
+        --scheduler-control
+            Accept commands on stdin to pause (`p`), resume (`r`) or change the concurrency (`c <n>`) of the run while it's in progress. Ignored when stdin isn't a terminal, with the terminal UI or with persistent tasks
+        --concurrent-runs <CONCURRENT_RUNS>
+            Set how this run coordinates with other runs in the same repository. Use "share" to wait for tasks that another run is executing and restore their results from the cache. Use "queue" to also wait for other queued runs to finish before starting. (default share) [env: TURBO_CONCURRENT_RUNS=] [default: share] [possible values: share, queue]
+        --continue[=<CONTINUE>]
+            Continue execution even if a task exits with an error or non-zero exit code. The default behavior is to bail. Use "dependencies-successful" to skip the tasks that depend on a failed task. (default always) [possible values: always, dependencies-successful]
         --dry-run [<DRY_RUN>]
-            [possible values: text, json]
+            [possible values: text, json, table]
+        --hash-only
+            Print the hashes of the tasks that would be run as JSON without checking the cache or executing them
         --single-package
             Run turbo in single-package mode
         --force [<FORCE>]
-            Ignore the existing cache (to force execution) [env: TURBO_FORCE=] [possible values: true, false]
+            Ignore the existing cache (to force execution). Pass a comma separated list of tasks (e.g. `web#build,test`) to only force those tasks, or `web#*` to force every task of a package [env: TURBO_FORCE=]
         --framework-inference [<BOOL>]
             Specify whether or not to do framework inference for tasks [default: true] [possible values: true, false]
         --global-deps <GLOBAL_DEPS>
             Specify glob of global filesystem dependencies to be hashed. Useful for .env and files
         --graph [<GRAPH>]
-            Generate a graph of the task execution and output to a file when a filename is specified (.svg, .png, .jpg, .pdf, .json, .html, .mermaid, .dot). Outputs dot graph to stdout when if no filename is provided
+            Generate a graph of the task execution and output to a file when a filename is specified (.svg, .png, .jpg, .pdf, .json, .html, .mermaid, .mmd, .dot). Outputs dot graph to stdout when if no filename is provided, or a Mermaid graph when passed `mermaid`
+        --graph-mode <GRAPH_MODE>
+            Set how the graph is drawn. Use "tasks" for a node per task. Use "packages" for a node per package, with the dependencies between their tasks collapsed into one edge. Use "both" to group the tasks of each package into a cluster. (default tasks) [default: tasks] [possible values: tasks, packages, both]
         --env-mode [<ENV_MODE>]
             Environment variable mode. Use "loose" to pass the entire existing environment. Use "strict" to use an allowlist specified in turbo.json. Use "infer" to defer to existence of "passThroughEnv" or "globalPassThroughEnv" in turbo.json. (default infer) [default: infer] [possible values: infer, loose, strict]
     -F, --filter <FILTER>
             Use the given selector to specify package(s) to act as entry points. The syntax mirrors pnpm's syntax, and additional documentation and examples can be found in turbo's documentation https://turbo.build/repo/docs/reference/command-line-reference/run#--filter
+        --exclude <EXCLUDE>
+            Leave the given package(s) out of the run. Supports globs. The same as '--filter=!<EXCLUDE>', which shells often need escaped
         --scope <SCOPE>
             DEPRECATED: Specify package(s) to act as entry points for task execution. Supports globs
         --ignore <IGNORE>
             Files to ignore when calculating changed files from '--filter'. Supports globs
-        --since <SINCE>
-            DEPRECATED: Limit/Set scope to changed packages since a mergebase. This uses the git diff ${target_branch}... mechanism to identify which packages have changed
-        --include-dependencies
-            DEPRECATED: Include the dependencies of tasks in execution
+        --affected-granularity <AFFECTED_GRANULARITY>
+            Experimental: Use "file" to pass the files that changed in the git range of '--filter' to jest and vitest tasks so that only the tests related to them run. (default package) [default: package] [possible values: package, file]
+        --since [<SINCE>]
+            DEPRECATED: Limit/Set scope to changed packages since a mergebase. This uses the git diff ${target_branch}... mechanism to identify which packages have changed. Without a ref, the default branch of the origin remote is used
+        --include-dependencies [<BOOL>]
+            DEPRECATED: Include the dependencies of tasks in execution [default: false] [possible values: true, false]
         --no-deps
             DEPRECATED: Exclude dependent task consumers from execution
+        --include-dependents [<BOOL>]
+            DEPRECATED: Include dependent task consumers in execution. Combined with `--include-dependencies=false` and `--only`, runs exactly the named tasks in the scoped packages. (default true) [possible values: true, false]
+        --no-package-inference
+            Don't scope the run to the package turbo is invoked from. Without filters, tasks run in every package
         --no-cache
             Avoid saving task results to the cache. Useful for development/watch tasks
+        --cache-failures
+            Cache the logs and exit code of failed tasks so that a repeated run replays the failure instead of executing the task again
+        --restore-declared-outputs
+            Only restore files from the cache that match the task's current outputs, skipping files that were cached under a previous outputs configuration
         --[no-]daemon
             Force turbo to either use or not use the local daemon. If unset turbo will use the default detection logic
         --output-logs <OUTPUT_LOGS>
-            Set type of process output logging. Use "full" to show all output. Use "hash-only" to show only turbo-computed task hashes. Use "new-only" to show only new output with only hashes for cached tasks. Use "none" to hide process output. (default full) [possible values: full, none, hash-only, new-only, errors-only]
+            Set type of process output logging. Use "full" to show all output. Use "hash-only" to show only turbo-computed task hashes. Use "new-only" to show only new output with only hashes for cached tasks. Use "errors-only" to show only the output of failed tasks. Use "none" to hide process output. (default full) [possible values: full, none, hash-only, new-only, errors-only]
         --log-order <LOG_ORDER>
             Set type of task output order. Use "stream" to show output as soon as it is available. Use "grouped" to show output when a command has finished execution. Use "auto" to let turbo decide based on its own heuristics. (default auto) [env: TURBO_LOG_ORDER=] [default: auto] [possible values: auto, stream, grouped]
+        --ui <UI>
+            Use "tui" to show a full-screen view of the tasks in the run, where the output of each task can be viewed on its own. Only takes effect in an interactive terminal. Use "stream" to write task logs to stdout. (default stream) [env: TURBO_UI=] [default: stream] [possible values: stream, tui]
         --only
             Only executes the tasks specified, does not execute parent tasks
+        --shard <INDEX/TOTAL>
+            Only run one shard of the tasks, e.g. "1/4" for the first of four. Tasks are split deterministically and each shard also runs the tasks its tasks depend on, so shards can run on separate machines
         --parallel
             Execute all tasks in parallel
         --profile <PROFILE>
@@ -92,12 +134,30 @@ Make sure exit code is 2 when no args are passed
             File to write turbo's performance profile output into. All identifying data omitted from the profile
         --remote-only [<BOOL>]
             Ignore the local filesystem cache for all tasks. Only allow reading and caching artifacts using the remote cache [env: TURBO_REMOTE_ONLY=] [default: false] [possible values: true, false]
+        --no-remote [<BOOL>]
+            Ignore the remote cache for all tasks, even if one is configured. Only allow reading and caching artifacts using the local filesystem cache [env: TURBO_NO_REMOTE=] [default: false] [possible values: true, false]
         --remote-cache-read-only [<BOOL>]
             Treat remote cache as read only [env: TURBO_REMOTE_CACHE_READ_ONLY=] [default: false] [possible values: true, false]
+        --remote-cache-concurrency <REMOTE_CACHE_CONCURRENCY>
+            Limit the number of concurrent requests to the remote cache. Applies to both downloads and uploads unless they're limited separately [env: TURBO_REMOTE_CACHE_CONCURRENCY=]
+        --remote-cache-read-concurrency <REMOTE_CACHE_READ_CONCURRENCY>
+            Limit the number of concurrent downloads from the remote cache [env: TURBO_REMOTE_CACHE_READ_CONCURRENCY=]
+        --remote-cache-write-concurrency <REMOTE_CACHE_WRITE_CONCURRENCY>
+            Limit the number of concurrent uploads to the remote cache, e.g. for a proxy that rate limits uploads [env: TURBO_REMOTE_CACHE_WRITE_CONCURRENCY=]
+        --remote-cache-max-artifact-size <BYTES>
+            Only write artifacts larger than this many bytes to the local cache, e.g. to stay under a remote cache provider's request size limit [env: TURBO_REMOTE_CACHE_MAX_ARTIFACT_SIZE=]
+        --cache-write-only [<BOOL>]
+            Execute every task without reading from the local or remote cache, while still writing artifacts to them. Useful for jobs that populate the cache for others [env: TURBO_CACHE_WRITE_ONLY=] [default: false] [possible values: true, false]
         --summarize [<SUMMARIZE>]
             Generate a summary of the turbo run [env: TURBO_RUN_SUMMARY=] [possible values: true, false]
+        --strict-engines
+            Fail the run if the installed node or npm versions don't satisfy the `engines` field of a package in scope
+        --warn-undeclared-outputs
+            Warn about files written by a task that aren't covered by its `outputs` and therefore aren't cached
         --log-prefix <LOG_PREFIX>
             Use "none" to remove prefixes from task logs. Use "task" to get task id prefixing. Use "auto" to let turbo decide how to prefix the logs based on the execution environment. In most cases this will be the same as "task". Note that tasks running in parallel interleave their logs, so removing prefixes can make it difficult to associate logs with tasks. Use --log-order=grouped to prevent interleaving. (default auto) [default: auto] [possible values: auto, none, task]
+        --log-timestamps <LOG_TIMESTAMPS>
+            Prefix each line of task output, including the logs saved to the cache, with a timestamp. Use "wall-clock" for the local time or "relative" for the time since the task started [possible values: wall-clock, relative]
   [1]
 
   $ ${TURBO} run
@@ -24,4 +24,4 @@
    6 |     },
      `----
   
-  [1]
+  [78]
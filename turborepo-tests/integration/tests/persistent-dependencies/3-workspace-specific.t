@@ -36,4 +36,4 @@
    6 |     },
      `----
   
-  [1]
+  [78]
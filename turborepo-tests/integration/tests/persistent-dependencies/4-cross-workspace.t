@@ -18,4 +18,4 @@
    6 |       "persistent": true
      `----
   
-  [1]
+  [78]
@@ -24,4 +24,4 @@
    6 |       "persistent": true
      `----
   
-  [1]
+  [78]
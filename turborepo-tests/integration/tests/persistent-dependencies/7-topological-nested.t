@@ -31,4 +31,4 @@
    6 |       "persistent": true
      `----
   
-  [1]
+  [78]
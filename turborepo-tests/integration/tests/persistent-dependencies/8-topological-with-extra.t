@@ -30,4 +30,4 @@
    9 |     },
      `----
   
-  [1]
+  [78]
@@ -23,4 +23,4 @@
    14 |     },
       `----
   
-  [1]
+  [78]
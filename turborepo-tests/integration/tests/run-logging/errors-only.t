@@ -90,3 +90,16 @@ Setup
 
 
 
+# [ ] error exit
+# [ ] outputMode: errors-only
+# [x] --ouptut-logs=errors-only
+# [x] --no-cache
+  $ ${TURBO} run build --output-logs=errors-only --no-cache --force
+  \xe2\x80\xa2 Packages in scope: app-a (esc)
+  \xe2\x80\xa2 Running build in 1 packages (esc)
+  \xe2\x80\xa2 Remote caching disabled (esc)
+  
+   Tasks:    1 successful, 1 total
+  Cached:    0 cached, 1 total
+    Time:\s*[\.0-9]+m?s  (re)
+  
@@ -17,7 +17,7 @@ Setup
 | false   | false   | no       |
 | false   | novalue | yes      |
 
-| missing | missing | no       |
+| missing | missing | yes      |
 | missing | true    | yes      |
 | missing | false   | no       |
 | missing | novalue | yes      |
@@ -65,11 +65,11 @@ Setup
   $ /bin/ls .turbo/runs/*.json | wc -l
   \s*1 (re)
 
-# missing env var, missing flag: no
+# missing env var, missing flag: yes
   $ rm -rf .turbo/runs
   $ ${TURBO} run build > /dev/null
-  $ test -d .turbo/runs
-  [1]
+  $ /bin/ls .turbo/runs/*.json | wc -l
+  \s*1 (re)
 # missing env var, --flag=true: yes
   $ rm -rf .turbo/runs
   $ ${TURBO} run build --summarize=true > /dev/null
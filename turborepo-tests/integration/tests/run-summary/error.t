@@ -21,6 +21,7 @@ Validate that there was a failed task and exitCode is 1 (which is what we get fr
     "failed": 1,
     "cached": 0,
     "attempted": 1,
+    "skipped": 0,
     "startTime": [0-9]+, (re)
     "endTime": [0-9]+, (re)
     "exitCode": 1
@@ -55,8 +56,12 @@ Validate that we got a full task summary for the failed task with an error in .e
     "resolvedTaskDefinition": {
       "outputs": [],
       "cache": true,
+      "cacheLogs": true,
+      "alwaysRun": false,
       "dependsOn": [],
       "inputs": [],
+      "rootInputs": [],
+      "inputsFrom": [],
       "outputMode": "full",
       "persistent": false,
       "env": [],
@@ -103,6 +108,7 @@ success should be 1, and attempted should be 2
     "failed": 1,
     "cached": 0,
     "attempted": 2,
+    "skipped": 0,
     "startTime": [0-9]+, (re)
     "endTime": [0-9]+, (re)
     "exitCode": 1
@@ -24,6 +24,7 @@ Check
     "exitCode",
     "failed",
     "repoPath",
+    "skipped",
     "startTime",
     "success"
   ]
@@ -135,6 +136,7 @@ Check Rust implementation
     "exitCode",
     "failed",
     "repoPath",
+    "skipped",
     "startTime",
     "success"
   ]
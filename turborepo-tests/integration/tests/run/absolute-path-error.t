@@ -9,7 +9,7 @@ Copy config into the root of our monorepo
 
 Run build
   $ ${TURBO} build > tmp.log 2>&1
-  [1]
+  [78]
   $ grep --quiet '`inputs` cannot contain an absolute path' tmp.log
 
 Choose our custom config based on OS, since the input/output configs will be different
@@ -20,7 +20,7 @@ Copy config into the root of our monorepo
 
 Run build
   $ ${TURBO} build > tmp.log 2>&1
-  [1]
+  [78]
   $ grep --quiet '`outputs` cannot contain an absolute path' tmp.log
 
 Choose our custom config based on OS, since the input/output configs will be different
@@ -31,5 +31,5 @@ Copy config into the root of our monorepo
 
 Run build
   $ ${TURBO} build > tmp.log 2>&1
-  [1]
+  [78]
   $ grep --quiet '`globalDependencies` cannot contain an absolute path' tmp.log
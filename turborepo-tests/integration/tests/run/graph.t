@@ -34,8 +34,24 @@ Graph to stdout
   \\t[A-Z]{4}\("my-app#build"\) --> [A-Z]{4}\("util#build"\).* (re)
   \\t[A-Z]{4}\("util#build"\) --> [A-Z]{4}\("___ROOT___"\).* (re)
 
+  $ ${TURBO} build -F my-app --graph=graph.mmd
+  
+  .*Generated task graph in .*graph\.mmd.* (re)
+
+  $ cat graph.mmd
+  graph TD
+  \\t[A-Z]{4}\("my-app#build"\) --> [A-Z]{4}\("util#build"\).* (re)
+  \\t[A-Z]{4}\("util#build"\) --> [A-Z]{4}\("___ROOT___"\).* (re)
+
+Mermaid graph to stdout
+  $ ${TURBO} build -F my-app --graph=mermaid
+  
+  graph TD
+  \\t[A-Z]{4}\("my-app#build"\) --> [A-Z]{4}\("util#build"\).* (re)
+  \\t[A-Z]{4}\("util#build"\) --> [A-Z]{4}\("___ROOT___"\).* (re)
+
   $ ${TURBO} build -F my-app --graph=graph.mdx
-   ERROR  invalid value 'graph.mdx' for '--graph [<GRAPH>]': Invalid file extension: 'mdx'. Allowed extensions are: ["svg", "png", "jpg", "pdf", "json", "html", "mermaid", "dot"]
+   ERROR  invalid value 'graph.mdx' for '--graph [<GRAPH>]': Invalid file extension: 'mdx'. Allowed extensions are: ["svg", "png", "jpg", "pdf", "json", "html", "mermaid", "mmd", "dot"]
   
   For more information, try '--help'.
   
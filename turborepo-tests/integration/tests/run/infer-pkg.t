@@ -55,6 +55,14 @@ Ensure we don't infer packages if --cwd is supplied
     "util"
   ]
 
+Opt out of inferring the package from the directory
+  $ ${TURBO} build --no-package-inference --dry=json | jq .packages
+  [
+    "another",
+    "my-app",
+    "util"
+  ]
+
 Run a dry run in packages with a glob filter from directory
   $ ${TURBO} build --dry=json -F "../*" | jq .packages
   [
@@ -7,7 +7,7 @@ Setup
   
   Error:   x could not find task `doesnotexist` in project
   
-  [1]
+  [78]
 
 # Multiple non-existent tasks also error
   $ ${TURBO} run doesnotexist alsono
@@ -16,7 +16,7 @@ Setup
   Error:   x could not find task `alsono` in project
   Error:   x could not find task `doesnotexist` in project
   
-  [1]
+  [78]
 
 # One good and one bad task does not error
   $ ${TURBO} run build doesnotexist
@@ -24,17 +24,17 @@ Setup
   
   Error:   x could not find task `doesnotexist` in project
   
-  [1]
+  [78]
 
 # Bad command
   $ ${TURBO} run something --dry > OUTPUT 2>&1
-  [1]
+  [78]
   $ grep --quiet -E "root task (//#)?something \(turbo run build\) looks like it invokes turbo and" OUTPUT
   $ grep --quiet -E "might cause a loop" OUTPUT
 
 # Bad command
 
   $ ${TURBO} run something > OUTPUT2 2>&1
-  [1]
+  [78]
   $ grep --quiet -E "root task (//#)?something \(turbo run build\) looks like it invokes turbo and" OUTPUT
   $ grep --quiet -E "might cause a loop" OUTPUT
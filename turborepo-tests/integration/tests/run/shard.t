@@ -0,0 +1,20 @@
+Setup
+  $ . ${TESTDIR}/../../../helpers/setup_integration_test.sh monorepo_dependency_error
+
+Shards split the tasks nothing depends on and keep their dependencies
+  $ ${TURBO} run build --shard=1/2 --dry=json | jq -c '.tasks | map(.taskId) | sort'
+  ["my-app#build","some-lib#build"]
+  $ ${TURBO} run build --shard=2/2 --dry=json | jq -c '.tasks | map(.taskId) | sort'
+  ["other-app#build","some-lib#build"]
+
+Shards beyond the number of tasks run nothing
+  $ ${TURBO} run build --shard=3/3 --dry=json | jq -c '.tasks | map(.taskId)'
+  []
+
+Invalid shards are rejected
+  $ ${TURBO} run build --shard=3/2
+   ERROR  invalid value '3/2' for '--shard <INDEX/TOTAL>': invalid shard 3/2, index must be between 1 and the total number of shards
+  
+  For more information, try '--help'.
+  
+  [1]
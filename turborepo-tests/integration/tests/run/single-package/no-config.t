@@ -35,7 +35,7 @@ Check
     Inferred Env Vars Values       =\s* (re)
     Passed Through Env Vars        =\s* (re)
     Passed Through Env Vars Values =\s* (re)
-    Resolved Task Definition       = {"outputs":\[],"cache":false,"dependsOn":\[],"inputs":\[],"outputMode":"full","persistent":false,"env":\[],"passThroughEnv":null,"dotEnv":null}\s* (re)
+    Resolved Task Definition       = {"outputs":\[],"cache":false,"cacheLogs":true,"alwaysRun":false,"dependsOn":\[],"inputs":\[],"rootInputs":\[],"inputsFrom":\[],"outputMode":"full","persistent":false,"env":\[],"passThroughEnv":null,"dotEnv":null}\s* (re)
     Framework                      =\s* (re)
 
   $ ${TURBO} run build --graph
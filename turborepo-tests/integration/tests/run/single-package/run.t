@@ -16,7 +16,6 @@ Check
     Time:\s*[\.0-9]+m?s  (re)
   
   $ test -d .turbo/runs/
-  [1]
 
 Run a second time, verify caching works because there is a config
   $ ${TURBO} run build
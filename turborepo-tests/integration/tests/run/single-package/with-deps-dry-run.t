@@ -33,7 +33,7 @@ Check
     Inferred Env Vars Values       =\s* (re)
     Passed Through Env Vars        =\s* (re)
     Passed Through Env Vars Values =\s* (re)
-    Resolved Task Definition       = {"outputs":\["foo.txt"],"cache":true,"dependsOn":\[],"inputs":\[],"outputMode":"full","persistent":false,"env":\[],"passThroughEnv":null,"dotEnv":null}\s* (re)
+    Resolved Task Definition       = {"outputs":\["foo.txt"],"cache":true,"cacheLogs":true,"alwaysRun":false,"dependsOn":\[],"inputs":\[],"rootInputs":\[],"inputsFrom":\[],"outputMode":"full","persistent":false,"env":\[],"passThroughEnv":null,"dotEnv":null}\s* (re)
     Framework                      =\s* (re)
   test
     Task                           = test\s* (re)
@@ -52,5 +52,5 @@ Check
     Inferred Env Vars Values       =\s* (re)
     Passed Through Env Vars        =\s* (re)
     Passed Through Env Vars Values =\s* (re)
-    Resolved Task Definition       = {"outputs":\[],"cache":true,"dependsOn":\["build"],"inputs":\[],"outputMode":"full","persistent":false,"env":\[],"passThroughEnv":null,"dotEnv":null}\s* (re)
+    Resolved Task Definition       = {"outputs":\[],"cache":true,"cacheLogs":true,"alwaysRun":false,"dependsOn":\["build"],"inputs":\[],"rootInputs":\[],"inputsFrom":\[],"outputMode":"full","persistent":false,"env":\[],"passThroughEnv":null,"dotEnv":null}\s* (re)
     Framework                      =\s* (re)
@@ -15,3 +15,7 @@ Make sure that we populate the JSON output
     "passthrough": [],
     "globalPassthrough": null
   }
+
+Report the variables each task would see under strict mode
+  $ GLOBAL_VAR_PT=1 GLOBAL_VAR_DEP=1 LOCAL_VAR_PT=1 LOCAL_VAR_DEP=1 OTHER_VAR=1 ${TURBO} build --dry=json | jq -c '.tasks[0].strictEnvironmentVariables | { hashed, passedThrough: (.passedThrough | map(select(endswith("_PT")))), otherStripped: (.stripped | index("OTHER_VAR") != null) }'
+  {"hashed":["GLOBAL_VAR_DEP","LOCAL_VAR_DEP"],"passedThrough":["GLOBAL_VAR_PT","LOCAL_VAR_PT"],"otherStripped":true}
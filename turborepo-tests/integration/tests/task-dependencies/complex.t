@@ -33,13 +33,13 @@ We can scope the run to specific packages
 
 Can't depend on unknown tasks
   $ ${TURBO} run build2 > BUILD2 2>&1
-  [1]
+  [78]
   $ cat BUILD2 | grep --only-match 'x Could not find "app-a#custom" in root turbo.json or "custom" in package'
   x Could not find "app-a#custom" in root turbo.json or "custom" in package
 
 Can't depend on tasks from unknown packages
   $ ${TURBO} run build3 > BUILD3 2>&1
-  [1]
+  [78]
   $ cat BUILD3 | grep --only-match 'x Could not find package "unknown" from task "unknown#custom" in project'
   x Could not find package "unknown" from task "unknown#custom" in project
 
@@ -99,6 +99,6 @@ Check that --only only runs leaf tasks
 
 Can't depend on itself
   $ ${TURBO} run build4 > BUILD4 2>&1
-  [1]
+  [78]
   $ cat BUILD4 | grep --only-match -E '(lib-a|lib-b|lib-c|lib-d|app-a|app-b)#build4 depends on itself'
   (lib-a|lib-b|lib-c|lib-d|app-a|app-b)#build4 depends on itself (re)
@@ -7,4 +7,4 @@ Setup
 
 # Errors are shown if we run across a malformed turbo.json
   $ ${TURBO} run trailing-comma --filter=bad-json > tmp.log 2>&1
-  [1]
+  [78]
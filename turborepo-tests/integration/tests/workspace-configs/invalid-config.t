@@ -5,7 +5,7 @@ Setup
 
 Errors are shown if we run a task that is misconfigured (invalid-config#build)
   $ ${TURBO} run build --filter=invalid-config > tmp.log 2>&1
-  [1]
+  [78]
   $ cat tmp.log | grep --quiet "[iI]nvalid turbo.json"
   $ cat tmp.log | grep --quiet "invalid-config#build"
   $ cat tmp.log | grep --quiet "//#some-root-task"
@@ -13,7 +13,7 @@ Errors are shown if we run a task that is misconfigured (invalid-config#build)
 
 Same error even if you're running a valid task in the package.
   $ ${TURBO} run valid-task --filter=invalid-config > tmp.log 2>&1
-  [1]
+  [78]
   $ cat tmp.log | grep --quiet "[iI]nvalid turbo.json"
   $ cat tmp.log | grep --quiet "invalid-config#build"
   $ cat tmp.log | grep --quiet "//#some-root-task"
@@ -28,8 +28,12 @@ Setup
   {
     "outputs": [],
     "cache": true,
+    "cacheLogs": true,
+    "alwaysRun": false,
     "dependsOn": [],
     "inputs": [],
+    "rootInputs": [],
+    "inputsFrom": [],
     "outputMode": "full",
     "persistent": false,
     "env": [],
@@ -43,8 +47,12 @@ Setup
   {
     "outputs": [],
     "cache": true,
+    "cacheLogs": true,
+    "alwaysRun": false,
     "dependsOn": [],
     "inputs": [],
+    "rootInputs": [],
+    "inputsFrom": [],
     "outputMode": "full",
     "persistent": false,
     "env": [],
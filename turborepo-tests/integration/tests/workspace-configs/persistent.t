@@ -22,7 +22,7 @@ This test covers:
    71 |     },
       `----
   
-  [1]
+  [78]
 
 # persistent-task-2-parent dependsOn persistent-task-2
 # persistent-task-2 is persistent:true in the root workspace, and IS overriden to false in the workspace
@@ -63,7 +63,7 @@ This test covers:
    77 |     },
       `----
   
-  [1]
+  [78]
 
 # persistent-task-4-parent dependsOn persistent-task-4
 # persistent-task-4 has no config in the root workspace, and is set to true in the workspace
@@ -80,4 +80,4 @@ This test covers:
    80 |     },
       `----
   
-  [1]
+  [78]